@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// archive settings of --delete-remote, finished files are deleted if archiveDir is empty
+var (
+	archiveDir       string
+	archiveBucket    string
+	archiveRetention time.Duration
+	// deleteWorkers is the number of objects deleted on R2 at once
+	deleteWorkers int
+)
+
+func addArchiveFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&archiveDir, "archive-dir", "", "With --delete-remote, move finished files into this directory on R2 (e.g. archive/) instead of deleting them")
+	cmd.Flags().StringVar(&archiveBucket, "archive-bucket", "", "Bucket of --archive-dir, or the root of another remote, defaults to the bucket in the config file or the root of the remote")
+	cmd.Flags().DurationVar(&archiveRetention, "archive-retention", 7*24*time.Hour, "Delete archived files older than this at the start of recv, 0 to keep them forever")
+	cmd.Flags().IntVar(&deleteWorkers, "delete-workers", 8, "Number of finished or expired archived files deleted on R2 in parallel")
+}
+
+// newRemoteCleanup returns the func that removes a finished file from fsrc:
+// it is deleted, or moved into the archive after purging expired archived files.
+func newRemoteCleanup(ctx context.Context, fsrc fs.Fs) (func(virtualPath string) error, error) {
+	if archiveDir == "" {
+		return func(virtualPath string) error {
+			logger.WithField("virtualPath", virtualPath).Debug("Deleting file on R2")
+			fobj, err := fsrc.NewObject(ctx, virtualPath)
+			if err != nil {
+				logger.WithError(err).WithField("virtualPath", virtualPath).Error("Failed to get object on R2")
+				return err
+			}
+			return operations.DeleteFile(ctx, fobj)
+		}, nil
+	}
+
+	farchive, err := rclone.NewArchiveRemote(ctx, config.Remote, r2Credentials(), archiveBucket, archiveDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create R2 archive backend: %w", err)
+	}
+	if archiveRetention > 0 {
+		deleted, err := rclone.PurgeArchive(ctx, farchive, archiveRetention, deleteWorkers)
+		if err != nil {
+			logger.WithError(err).Warn("Failed to purge expired archived files")
+		} else if deleted > 0 {
+			logger.WithField("count", deleted).Info("Purged expired archived files")
+		}
+	}
+	return func(virtualPath string) error {
+		logger.WithFields(logger.Fields{
+			"virtualPath": virtualPath,
+			"archive":     fs.ConfigString(farchive),
+		}).Debug("Archiving file on R2")
+		return rclone.ArchiveFile(ctx, farchive, fsrc, virtualPath)
+	}, nil
+}
+
+// removeFinishedFiles removes the files of earlier runs that are finished but still in fsrc. They are deleted
+// in one batch of --delete-workers at a time, or archived one by one with cleanup.
+func removeFinishedFiles(ctx context.Context, fsrc fs.Fs, cleanup func(virtualPath string) error, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	if archiveDir != "" {
+		for _, name := range names {
+			if err := cleanup(name); err != nil {
+				logger.WithError(err).WithField("virtualPath", name).Warn("Failed to archive finished file on R2")
+			}
+		}
+		return
+	}
+	summary, err := rclone.DeleteFiles(ctx, fsrc, names, deleteWorkers)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to delete some finished files on R2")
+	}
+	logger.WithFields(logger.Fields{
+		"count": summary.Files,
+		"size":  fs.SizeSuffix(summary.Bytes).ByteUnit(),
+	}).Info("Deleted finished files on R2")
+}
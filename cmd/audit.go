@@ -0,0 +1,308 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/hrz6976/syncmate/db"
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/spf13/cobra"
+)
+
+// auditFinding is a task whose database state doesn't match the bucket
+type auditFinding struct {
+	VirtualPath string
+	Problem     string
+	Action      string
+}
+
+// auditPageSize is the number of tasks read from the database per query
+const auditPageSize = 1000
+
+// listAllTasks reads every task of the database that matches filter
+func listAllTasks(dbHandle *db.DB, filter db.TaskFilter) ([]*db.Task, error) {
+	var tasks []*db.Task
+	for offset := 0; ; offset += auditPageSize {
+		page, err := dbHandle.ListTasksFiltered(filter, offset, auditPageSize)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, page...)
+		if len(page) < auditPageSize {
+			return tasks, nil
+		}
+	}
+}
+
+// objectChanged describes how object differs from the object with etag and size that was uploaded,
+// empty if it doesn't. Unknown ETags and sizes are not compared.
+func objectChanged(etag string, size int64, object rclone.RcloneFileInfo) string {
+	switch {
+	case size > 0 && object.Size != size:
+		return fmt.Sprintf("the object has %d bytes instead of the %d uploaded", object.Size, size)
+	case etag != "" && object.ETag != "" && object.ETag != etag:
+		return fmt.Sprintf("the object has ETag %s instead of the %s uploaded", object.ETag, etag)
+	}
+	return ""
+}
+
+// auditTasks cross-references the tasks of the database with the objects of the bucket
+func auditTasks(tasks []*db.Task, objects []rclone.RcloneFileInfo) []auditFinding {
+	objectsByName := make(map[string]rclone.RcloneFileInfo, len(objects))
+	hasDelta := make(map[string]bool)
+	for _, object := range objects {
+		objectsByName[object.Name] = object
+		if virtualPath, ok := strings.CutSuffix(object.Name, woc.DeltaSuffix); ok {
+			hasDelta[virtualPath] = true
+		}
+	}
+	known := make(map[string]bool, len(tasks))
+
+	var findings []auditFinding
+	for _, task := range tasks {
+		known[task.VirtualPath] = true
+		object, onR2 := objectsByName[task.VirtualPath]
+		changed := ""
+		if onR2 && task.Status == db.Uploaded {
+			changed = objectChanged(task.ETag, task.ObjectSize, object)
+		}
+		switch {
+		case onR2 && object.Size != task.SrcSize:
+			findings = append(findings, auditFinding{task.VirtualPath,
+				fmt.Sprintf("%s, but the object has %d bytes instead of %d", task.Status, object.Size, task.SrcSize),
+				"delete the object and reset the task to Pending to upload it again"})
+		case changed != "":
+			findings = append(findings, auditFinding{task.VirtualPath,
+				"Uploaded, but " + changed,
+				"delete the object and reset the task to Pending to upload it again"})
+		case onR2 && task.Status == db.Downloaded:
+			findings = append(findings, auditFinding{task.VirtualPath,
+				"Downloaded, but the object is still on R2",
+				"delete the object, the file is already placed"})
+		case !onR2 && task.Status == db.Uploaded && !hasDelta[task.VirtualPath]:
+			findings = append(findings, auditFinding{task.VirtualPath,
+				"Uploaded, but there is no object",
+				"reset the task to Pending to upload it again"})
+		}
+	}
+	for _, object := range objects {
+		if virtualPath, _ := woc.CompanionOf(object.Name); !known[virtualPath] {
+			findings = append(findings, auditFinding{object.Name,
+				"object has no task in the database",
+				"run send for it or delete the object"})
+		}
+	}
+	sort.Slice(findings, func(i, j int) bool { return findings[i].VirtualPath < findings[j].VirtualPath })
+	return findings
+}
+
+// printAudit writes the findings as a reconciliation report
+func printAudit(w io.Writer, findings []auditFinding, taskCount, objectCount int) {
+	if len(findings) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "Virtual Path\tProblem\tAction")
+		fmt.Fprintln(tw, "------------\t-------\t------")
+		for _, f := range findings {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", f.VirtualPath, f.Problem, f.Action)
+		}
+		tw.Flush()
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "Audited %d tasks and %d objects: %d problems\n", taskCount, objectCount, len(findings))
+}
+
+// auditCheckers is the number of destination files digested at once by audit
+var auditCheckers int
+
+// destinationSummary counts the outcome of the audit of the destination files
+type destinationSummary struct {
+	Audited  int // tasks whose destinations were audited
+	Verified int // destination files with the size and digest of the source profile
+	// Findings are the Downloaded tasks whose destinations fail verification
+	Findings []auditFinding
+}
+
+// auditDestination compares the destination file of task with the size and the digest of its source
+// file, and returns the problem, empty if they match
+func auditDestination(task *woc.WocSyncTask) (string, error) {
+	destPath := taskDestPath(task)
+	stat, err := os.Stat(destPath)
+	if os.IsNotExist(err) {
+		return "the destination file is missing", nil
+	} else if err != nil {
+		return "", err
+	}
+	switch size := task.Offset + task.Size; {
+	case task.Offset > 0 && stat.Size() == task.Offset:
+		return fmt.Sprintf("the destination has the %d bytes before the append only", stat.Size()), nil
+	case stat.Size() != size:
+		return fmt.Sprintf("the destination has %d bytes instead of %d", stat.Size(), size), nil
+	}
+	return checkDigest(destPath, 0, task.SourceDigest, task.SourceDigestAlgorithm)
+}
+
+// auditDestinations verifies the destinations of the tasks, auditCheckers at once. The failures of
+// the tasks that are Downloaded in statuses are the findings; the others aren't placed yet.
+func auditDestinations(tasks []*woc.WocSyncTask, statuses map[string]db.Status) *destinationSummary {
+	summary := &destinationSummary{Audited: len(tasks)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan *woc.WocSyncTask)
+	for range max(auditCheckers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range queue {
+				problem, err := auditDestination(task)
+				if err != nil {
+					problem = "failed to read the destination: " + err.Error()
+				}
+				status, ok := statuses[task.VirtualPath]
+				mu.Lock()
+				switch {
+				case problem == "":
+					summary.Verified++
+				case ok && status == db.Downloaded:
+					summary.Findings = append(summary.Findings, auditFinding{task.VirtualPath,
+						"Downloaded, but " + problem,
+						"reset the task to Pending to transfer it again"})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, task := range tasks {
+		queue <- task
+	}
+	close(queue)
+	wg.Wait()
+	sort.Slice(summary.Findings, func(i, j int) bool {
+		return summary.Findings[i].VirtualPath < summary.Findings[j].VirtualPath
+	})
+	return summary
+}
+
+// print writes the findings as a table and the counts of the audit
+func (s *destinationSummary) print(w io.Writer) {
+	if len(s.Findings) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "Virtual Path\tProblem\tAction")
+		fmt.Fprintln(tw, "------------\t-------\t------")
+		for _, f := range s.Findings {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", f.VirtualPath, f.Problem, f.Action)
+		}
+		tw.Flush()
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "Audited %d destination files: %d verified, %d not placed yet, %d Downloaded but failing\n",
+		s.Audited, s.Verified, s.Audited-s.Verified-len(s.Findings), len(s.Findings))
+}
+
+// taskStatuses returns the status of every task of the database by virtual path
+func taskStatuses(dbHandle *db.DB) (map[string]db.Status, error) {
+	rows, err := listAllTasks(dbHandle, db.TaskFilter{})
+	if err != nil {
+		return nil, err
+	}
+	statuses := make(map[string]db.Status, len(rows))
+	for _, row := range rows {
+		statuses[row.VirtualPath] = row.Status
+	}
+	return statuses, nil
+}
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Verify the destination files of the transfer end to end",
+	Long: `Generate the tasks from both profiles like recv and verify the destination file of every
+task: its size and digest must be those of the source profile. The digests are computed with the
+algorithm of the source profile, sampled by default. The tasks that are Downloaded in the database
+but fail verification are reported; with --repair they are reset to Pending, so that the next send
+and recv transfer them again. "check" compares the bucket with the sources and "status --audit"
+the bucket with the database. The exit code is 1 if a Downloaded task fails verification.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		srcPath, _ := cmd.Flags().GetString("src")
+		dstPath, _ := cmd.Flags().GetString("dst")
+		configPath, _ := cmd.Flags().GetString("config")
+		destDir, _ = cmd.Flags().GetString("dest-dir")
+		repair, _ := cmd.Flags().GetBool("repair")
+		filter, err := taskFilterFromFlags(cmd)
+		if err != nil {
+			cmd.PrintErrf("Invalid filter: %v\n", err)
+			os.Exit(1)
+		}
+
+		// before fetching remote profiles, which go through the proxy of the config
+		if err := loadConfig(configPath); err != nil {
+			cmd.PrintErrf("%v\n", err)
+			os.Exit(1)
+		}
+		var srcProfile, dstProfile *woc.ParsedWocProfile
+		if tasksFile == "" {
+			srcProfile, dstProfile, err = loadProfiles(srcPath, dstPath)
+			if err != nil {
+				cmd.PrintErrf("%v\n", err)
+				os.Exit(1)
+			}
+		}
+		dbHandle, err := connectDB()
+		if err != nil {
+			cmd.PrintErrf("Failed to connect to database: %v\n", err)
+			os.Exit(1)
+		}
+
+		tasksMap, err := loadAllTasks(srcProfile, dstProfile)
+		if err != nil {
+			cmd.PrintErrf("Failed to generate tasks: %v\n", err)
+			os.Exit(1)
+		}
+		filterTasks(tasksMap, filter)
+		statuses, err := taskStatuses(dbHandle)
+		if err != nil {
+			cmd.PrintErrf("Failed to list tasks: %v\n", err)
+			os.Exit(1)
+		}
+
+		summary := auditDestinations(sortedTasks(tasksMap), statuses)
+		summary.print(cmd.OutOrStdout())
+		if len(summary.Findings) == 0 {
+			return
+		}
+		if repair {
+			paths := make([]string, len(summary.Findings))
+			for i, f := range summary.Findings {
+				paths[i] = f.VirtualPath
+			}
+			if err := dbHandle.ResetTasks(paths, db.Pending); err != nil {
+				cmd.PrintErrf("Failed to reset %d tasks to Pending: %v\n", len(paths), err)
+			} else {
+				fmt.Fprintf(cmd.OutOrStdout(), "Reset %d tasks to Pending\n", len(paths))
+			}
+		}
+		os.Exit(1)
+	},
+}
+
+func init() {
+	auditCmd.Flags().StringP("src", "s", "woc.src.json", "WoC profile of the transfer source, a path or URL")
+	auditCmd.Flags().StringP("dst", "d", "woc.dst.json", "Woc profile of the transfer destination, a path or URL")
+	auditCmd.Flags().StringP("config", "c", "config.json", "Path to the configuration file")
+	auditCmd.Flags().StringP("dest-dir", "D", "", "Default destination directory of the files, as given to recv")
+	auditCmd.Flags().Bool("repair", false, "Reset the Downloaded tasks that fail verification to Pending, so they are transferred again")
+	auditCmd.Flags().IntVar(&auditCheckers, "checkers", 4, "Number of destination files digested in parallel")
+	addTaskFilterFlags(auditCmd)
+	addDigestWorkersFlag(auditCmd)
+	addStrictFlag(auditCmd)
+	addSkipBadShardsFlag(auditCmd)
+	addAllVersionsFlag(auditCmd)
+	addDatasetFlags(auditCmd)
+	addRunIDFlag(auditCmd)
+	addTasksFileFlag(auditCmd)
+	RootCmd.AddCommand(auditCmd)
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hrz6976/syncmate/db"
+	of "github.com/hrz6976/syncmate/offsetfs"
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditTasks(t *testing.T) {
+	tasks := []*db.Task{
+		{VirtualPath: "a.bin", SrcSize: 10, Status: db.Uploaded},
+		{VirtualPath: "b.bin", SrcSize: 10, Status: db.Uploaded},
+		{VirtualPath: "c.bin", SrcSize: 10, Status: db.Downloaded},
+		{VirtualPath: "d.bin", SrcSize: 10, Status: db.Downloaded},
+		{VirtualPath: "e.bin", SrcSize: 10, Status: db.Uploading},
+		{VirtualPath: "f.bin", SrcSize: 10, Status: db.Pending},
+	}
+	objects := []rclone.RcloneFileInfo{
+		{Name: "a.bin", Size: 10},
+		{Name: "c.bin", Size: 10},
+		{Name: "e.bin", Size: 4},
+		{Name: "g.bin", Size: 1},
+	}
+
+	findings := auditTasks(tasks, objects)
+	require.Len(t, findings, 4)
+	assert.Equal(t, auditFinding{"b.bin", "Uploaded, but there is no object", "reset the task to Pending to upload it again"}, findings[0])
+	assert.Equal(t, "c.bin", findings[1].VirtualPath)
+	assert.Contains(t, findings[1].Problem, "still on R2")
+	assert.Equal(t, "e.bin", findings[2].VirtualPath)
+	assert.Equal(t, "Uploading, but the object has 4 bytes instead of 10", findings[2].Problem)
+	assert.Equal(t, "g.bin", findings[3].VirtualPath)
+	assert.Contains(t, findings[3].Problem, "no task")
+
+	var out bytes.Buffer
+	printAudit(&out, findings, len(tasks), len(objects))
+	assert.Contains(t, out.String(), "b.bin         Uploaded, but there is no object")
+	assert.Contains(t, out.String(), "Audited 6 tasks and 4 objects: 4 problems\n")
+
+	out.Reset()
+	printAudit(&out, nil, 1, 1)
+	assert.Equal(t, "Audited 1 tasks and 1 objects: 0 problems\n", out.String())
+}
+
+func TestAuditTasks_ChangedObject(t *testing.T) {
+	tasks := []*db.Task{
+		{VirtualPath: "a.bin", SrcSize: 10, Status: db.Uploaded, ETag: "aaaa", ObjectSize: 10},
+		{VirtualPath: "b.bin", SrcSize: 10, Status: db.Uploaded, ETag: "bbbb", ObjectSize: 10},
+		{VirtualPath: "c.bin", SrcSize: 10, Status: db.Uploaded},
+	}
+	objects := []rclone.RcloneFileInfo{
+		{Name: "a.bin", Size: 10, ETag: "aaaa"},
+		{Name: "b.bin", Size: 10, ETag: "cccc"},
+		{Name: "c.bin", Size: 10},
+	}
+
+	findings := auditTasks(tasks, objects)
+	require.Len(t, findings, 1)
+	assert.Equal(t, "b.bin", findings[0].VirtualPath)
+	assert.Equal(t, "Uploaded, but the object has ETag cccc instead of the bbbb uploaded", findings[0].Problem)
+
+	assert.Empty(t, objectChanged("", 0, rclone.RcloneFileInfo{Size: 4, ETag: "dddd"}))
+	assert.Equal(t, "the object has 4 bytes instead of the 10 uploaded", objectChanged("aaaa", 10, rclone.RcloneFileInfo{Size: 4, ETag: "aaaa"}))
+}
+
+func TestAuditDestinations(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	write := func(name, content string) string {
+		path := filepath.Join(tmpDir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+	digest := func(path string) *string {
+		digester, err := woc.NewDigester("")
+		require.NoError(t, err)
+		d, err := digester.Digest(path, 0, 0)
+		require.NoError(t, err)
+		return &d
+	}
+	task := func(name string, offset, size int64, sourceDigest *string) *woc.WocSyncTask {
+		return &woc.WocSyncTask{
+			FileConfig:   of.FileConfig{VirtualPath: name, Offset: offset, Size: size},
+			TargetPath:   filepath.Join(tmpDir, name),
+			SourceDigest: sourceDigest,
+		}
+	}
+	good := digest(write("source.bin", "0123456789"))
+	write("a.bin", "0123456789")
+	write("b.bin", "01234")
+	write("d.bin", "9876543210")
+	write("e.bin", "01234")
+	write("f.bin", "0123456789")
+	tasks := []*woc.WocSyncTask{
+		task("a.bin", 0, 10, good),
+		task("b.bin", 0, 10, good),
+		task("c.bin", 0, 10, good),
+		task("d.bin", 0, 10, good),
+		task("e.bin", 5, 5, good),
+		// not Downloaded, but already placed
+		task("f.bin", 5, 5, good),
+	}
+	statuses := map[string]db.Status{
+		"a.bin": db.Downloaded,
+		"b.bin": db.Downloaded,
+		"c.bin": db.Uploaded,
+		"d.bin": db.Downloaded,
+		"e.bin": db.Downloaded,
+	}
+
+	summary := auditDestinations(tasks, statuses)
+	assert.Equal(t, 2, summary.Verified)
+	require.Len(t, summary.Findings, 3)
+	assert.Equal(t, auditFinding{"b.bin", "Downloaded, but the destination has 5 bytes instead of 10", "reset the task to Pending to transfer it again"}, summary.Findings[0])
+	assert.Equal(t, "d.bin", summary.Findings[1].VirtualPath)
+	assert.Contains(t, summary.Findings[1].Problem, "digest mismatch")
+	assert.Equal(t, "Downloaded, but the destination has the 5 bytes before the append only", summary.Findings[2].Problem)
+
+	var out bytes.Buffer
+	summary.print(&out)
+	assert.Contains(t, out.String(), "Audited 6 destination files: 2 verified, 1 not placed yet, 3 Downloaded but failing\n")
+}
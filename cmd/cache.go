@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/rclone/rclone/fs"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// cacheOrphan is a file in the cache directory that recv will never place
+type cacheOrphan struct {
+	Path   string
+	Size   int64
+	Reason string
+}
+
+// findCacheOrphans walks the cache directory for files without a pending task:
+// leftover partial downloads, files whose task doesn't exist or is already
+// Downloaded, and files whose size doesn't match their task.
+func findCacheOrphans(tasksMap map[string]*woc.WocSyncTask) ([]cacheOrphan, error) {
+	var orphans []cacheOrphan
+	err := filepath.Walk(cacheDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if filePath == quarantinePath() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filePath == runLockPath("recv") {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(cacheDir, filePath)
+		if err != nil {
+			return err
+		}
+		virtualPath := filepath.ToSlash(relPath)
+		task := tasksMap[virtualPath]
+		var reason string
+		switch {
+		case strings.HasSuffix(info.Name(), ".partial"):
+			reason = "partial download"
+		case task == nil:
+			reason = "no pending task"
+		case task.Size != info.Size():
+			reason = fmt.Sprintf("size %d doesn't match task size %d", info.Size(), task.Size)
+		default:
+			return nil
+		}
+		orphans = append(orphans, cacheOrphan{Path: filePath, Size: info.Size(), Reason: reason})
+		return nil
+	})
+	return orphans, err
+}
+
+// runCacheGC removes the orphans of the cache directory, or only lists them with dryRun.
+// It returns the number of bytes reclaimed.
+func runCacheGC(w io.Writer, tasksMap map[string]*woc.WocSyncTask, dryRun bool) (int64, error) {
+	orphans, err := findCacheOrphans(tasksMap)
+	if err != nil {
+		return 0, fmt.Errorf("failed to scan cache directory: %w", err)
+	}
+	var reclaimed int64
+	for _, o := range orphans {
+		if dryRun {
+			fmt.Fprintf(w, "WOULD REMOVE %s (%s): %s\n", o.Path, fs.SizeSuffix(o.Size).ByteUnit(), o.Reason)
+			reclaimed += o.Size
+			continue
+		}
+		if err := os.Remove(o.Path); err != nil {
+			logger.WithError(err).WithField("path", o.Path).Error("Failed to remove cached file")
+			continue
+		}
+		fmt.Fprintf(w, "REMOVED %s (%s): %s\n", o.Path, fs.SizeSuffix(o.Size).ByteUnit(), o.Reason)
+		reclaimed += o.Size
+	}
+	verb := "Reclaimed"
+	if dryRun {
+		verb = "Would reclaim"
+	}
+	fmt.Fprintf(w, "%s %s from %d orphaned files\n", verb, fs.SizeSuffix(reclaimed).ByteUnit(), len(orphans))
+	return reclaimed, nil
+}
+
+// isSubdir reports whether dir is parent or below it
+func isSubdir(parent, dir string) bool {
+	rel, err := filepath.Rel(parent, dir)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the recv cache directory",
+}
+
+var cacheGCCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove cached files that recv will never place",
+	Long: `Remove the files of the cache directory that have no pending task: leftover
+partial downloads, files whose task doesn't exist or is already Downloaded, and
+files whose size doesn't match their task. Quarantined files are kept.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		srcPath, _ := cmd.Flags().GetString("src")
+		dstPath, _ := cmd.Flags().GetString("dst")
+		configPath, _ := cmd.Flags().GetString("config")
+		skipDB, _ := cmd.Flags().GetBool("skip-db")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		cacheDir, _ = cmd.Flags().GetString("cache-dir")
+		destDir, _ = cmd.Flags().GetString("dest-dir")
+
+		absCache, err := filepath.Abs(cacheDir)
+		if err != nil {
+			cmd.PrintErrf("Invalid --cache-dir: %v\n", err)
+			return
+		}
+		absDest, err := filepath.Abs(destDir)
+		if err != nil || destDir == "" || isSubdir(absCache, absDest) {
+			// placed files would look like orphans
+			cmd.PrintErrln("cache gc needs a --dest-dir outside the cache directory")
+			return
+		}
+
+		if err := loadConfig(configPath); err != nil {
+			cmd.PrintErrf("%v\n", err)
+			return
+		}
+
+		srcProfile, dstProfile, err := loadProfiles(srcPath, dstPath)
+		if err != nil {
+			cmd.PrintErrf("%v\n", err)
+			return
+		}
+
+		// recv must not download into the cache while it is cleaned
+		releaseLock, err := acquireRunLock(runLockPath("recv"))
+		if err != nil {
+			cmd.PrintErrf("%v\n", err)
+			return
+		}
+		defer releaseLock()
+
+		if !skipDB {
+			if _, err = connectDB(); err != nil {
+				cmd.PrintErrf("Failed to connect to database: %v\n", err)
+				return
+			}
+		}
+
+		tasksMap, err := generateTasks(srcProfile, dstProfile, false)
+		if err != nil {
+			cmd.PrintErrf("Failed to generate tasks: %v\n", err)
+			return
+		}
+
+		if _, err := runCacheGC(cmd.OutOrStdout(), tasksMap, dryRun); err != nil {
+			cmd.PrintErrf("%v\n", err)
+		}
+	},
+}
+
+func init() {
+	cacheGCCmd.Flags().StringP("src", "s", "woc.src.json", "WoC profile of the transfer source, a path or URL")
+	cacheGCCmd.Flags().StringP("dst", "d", "woc.dst.json", "Woc profile of the transfer destination, a path or URL")
+	cacheGCCmd.Flags().StringP("config", "c", "config.json", "Path to the configuration file")
+	cacheGCCmd.Flags().StringP("cache-dir", "C", "", "Path to the cache directory")
+	cacheGCCmd.Flags().StringP("dest-dir", "D", "", "Default destination directory of recv, must be outside the cache directory")
+	cacheGCCmd.Flags().Bool("skip-db", false, "Don't treat the tasks marked Downloaded in the database as finished")
+	cacheGCCmd.Flags().Bool("dry-run", false, "Only list the files that would be removed")
+	cacheGCCmd.MarkFlagRequired("cache-dir")
+	cacheGCCmd.MarkFlagRequired("dest-dir")
+	addDigestWorkersFlag(cacheGCCmd)
+	addStrictFlag(cacheGCCmd)
+	addSkipBadShardsFlag(cacheGCCmd)
+	addAllVersionsFlag(cacheGCCmd)
+	addRunIDFlag(cacheGCCmd)
+	cacheCmd.AddCommand(cacheGCCmd)
+	RootCmd.AddCommand(cacheCmd)
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hrz6976/syncmate/offsetfs"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCacheGC(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	oldCacheDir := cacheDir
+	defer func() { cacheDir = oldCacheDir }()
+	cacheDir = filepath.Join(tmpDir, "cache")
+
+	files := map[string]string{
+		"pending.bin":               "12345",
+		"finished.bin":              "123",
+		"rclone.bin.abc123.partial": "1",
+		"resized.bin":               "1234",
+		".syncmate.lock":            "42",
+		".quarantine/bad.bin":       "bad",
+	}
+	for name, content := range files {
+		path := filepath.Join(cacheDir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	tasksMap := map[string]*woc.WocSyncTask{
+		"pending.bin": {FileConfig: offsetfs.FileConfig{VirtualPath: "pending.bin", Size: 5}},
+		"resized.bin": {FileConfig: offsetfs.FileConfig{VirtualPath: "resized.bin", Size: 10}},
+	}
+
+	var out bytes.Buffer
+	reclaimed, err := runCacheGC(&out, tasksMap, true)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), reclaimed)
+	assert.FileExists(t, filepath.Join(cacheDir, "finished.bin"))
+	assert.Contains(t, out.String(), "Would reclaim 8 B from 3 orphaned files")
+
+	out.Reset()
+	reclaimed, err = runCacheGC(&out, tasksMap, false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), reclaimed)
+	assert.NoFileExists(t, filepath.Join(cacheDir, "finished.bin"))
+	assert.NoFileExists(t, filepath.Join(cacheDir, "rclone.bin.abc123.partial"))
+	assert.NoFileExists(t, filepath.Join(cacheDir, "resized.bin"))
+	assert.FileExists(t, filepath.Join(cacheDir, "pending.bin"))
+	assert.FileExists(t, filepath.Join(cacheDir, ".syncmate.lock"))
+	assert.FileExists(t, filepath.Join(cacheDir, ".quarantine", "bad.bin"))
+}
+
+func TestIsSubdir(t *testing.T) {
+	assert.True(t, isSubdir("/data/cache", "/data/cache"))
+	assert.True(t, isSubdir("/data/cache", "/data/cache/dest"))
+	assert.False(t, isSubdir("/data/cache", "/data/dest"))
+	assert.False(t, isSubdir("/data/cache", "/data/cache2"))
+}
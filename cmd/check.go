@@ -0,0 +1,318 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/hrz6976/syncmate/db"
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/rclone/rclone/fs/hash"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// checkSizeOnly compares only the sizes of the source windows and the objects, without reading the sources
+	checkSizeOnly bool
+	// checkers is the number of source windows hashed at once
+	checkers int
+	// checkMountDir is a mounted OffsetFS the files are read from instead of the source windows
+	checkMountDir string
+)
+
+// checkFinding is an uploaded file whose object doesn't match its source window
+type checkFinding struct {
+	VirtualPath string
+	Problem     string
+	// Unreadable is set if the source couldn't be read, the object may be fine
+	Unreadable bool
+}
+
+// checkSummary counts the outcome of a check
+type checkSummary struct {
+	Checked  int // files compared with their objects
+	Matched  int // files with the size and, where the object has one, the hash of their objects
+	Unhashed int // matched files whose objects have no MD5, e.g. multipart uploads of other tools
+	Findings []checkFinding
+}
+
+// checkSourcePath returns the file with the bytes of the object of task and the range of them in it
+func checkSourcePath(task *woc.WocSyncTask) (path string, offset int64) {
+	if checkMountDir != "" {
+		return filepath.Join(checkMountDir, filepath.FromSlash(task.VirtualPath)), 0
+	}
+	return task.SourcePath, task.Offset
+}
+
+// sourceMD5 returns the MD5 of the bytes of task that send uploads
+func sourceMD5(task *woc.WocSyncTask) (string, error) {
+	path, offset := checkSourcePath(task)
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if end := offset + task.Size; stat.Size() < end {
+		return "", fmt.Errorf("%s has %d bytes, the file ends at %d", path, stat.Size(), end)
+	}
+	sums, err := hash.StreamTypes(io.NewSectionReader(f, offset, task.Size), hash.NewHashSet(hash.MD5))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return sums[hash.MD5], nil
+}
+
+// checkTask compares the task with its object like rclone check, the size first and then the MD5
+// if the object has one. It returns the problem, empty if they match, and whether the MD5 was compared.
+func checkTask(task *woc.WocSyncTask, object rclone.RcloneFileInfo, ok bool) (problem string, hashed bool, err error) {
+	switch {
+	case !ok:
+		return "the object is missing", false, nil
+	case object.Size != task.Size:
+		return fmt.Sprintf("the object has %d bytes instead of %d", object.Size, task.Size), false, nil
+	case checkSizeOnly || object.ETag == "":
+		return "", false, nil
+	}
+	sum, err := sourceMD5(task)
+	if err != nil {
+		return "", false, err
+	}
+	if sum != object.ETag {
+		return fmt.Sprintf("the object has MD5 %s instead of %s", object.ETag, sum), true, nil
+	}
+	return "", true, nil
+}
+
+// checkTasks compares the tasks with their objects, checkers at once, and returns the findings by virtual path
+func checkTasks(tasks []*woc.WocSyncTask, objects map[string]rclone.RcloneFileInfo) *checkSummary {
+	summary := &checkSummary{Checked: len(tasks)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan *woc.WocSyncTask)
+	for range max(checkers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range queue {
+				object, ok := objects[task.VirtualPath]
+				problem, hashed, err := checkTask(task, object, ok)
+				mu.Lock()
+				switch {
+				case err != nil:
+					summary.Findings = append(summary.Findings, checkFinding{task.VirtualPath, "failed to hash the source: " + err.Error(), true})
+				case problem != "":
+					summary.Findings = append(summary.Findings, checkFinding{task.VirtualPath, problem, false})
+				case hashed:
+					summary.Matched++
+				default:
+					summary.Matched++
+					summary.Unhashed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, task := range tasks {
+		queue <- task
+	}
+	close(queue)
+	wg.Wait()
+	sort.Slice(summary.Findings, func(i, j int) bool {
+		return summary.Findings[i].VirtualPath < summary.Findings[j].VirtualPath
+	})
+	return summary
+}
+
+// print writes the findings as a table and the counts of the check
+func (s *checkSummary) print(w io.Writer) {
+	if len(s.Findings) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "Virtual Path\tProblem")
+		fmt.Fprintln(tw, "------------\t-------")
+		for _, f := range s.Findings {
+			fmt.Fprintf(tw, "%s\t%s\n", f.VirtualPath, f.Problem)
+		}
+		tw.Flush()
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "Checked %d files: %d match (%d by size only), %d differ\n",
+		s.Checked, s.Matched, s.Unhashed, len(s.Findings))
+}
+
+// markCheckFailures records the tasks of the findings as Failed with their problems, so retry uploads them again.
+// The tasks whose sources couldn't be read are left alone.
+func markCheckFailures(dbHandle *db.DB, tasksMap map[string]*woc.WocSyncTask, findings []checkFinding) error {
+	rows := make([]*db.Task, 0, len(findings))
+	for _, f := range findings {
+		if f.Unreadable {
+			continue
+		}
+		row := sendTaskRow(tasksMap[f.VirtualPath], db.Failed)
+		row.Error = "check: " + f.Problem
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	err := dbHandle.UpdateTasks(rows)
+	var rejected *db.RejectedError
+	if errors.As(err, &rejected) {
+		// e.g. tasks recv downloaded meanwhile, the others were written
+		logger.WithError(err).Warn("Some task updates were rejected")
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to mark %d tasks Failed: %w", len(rows), err)
+	}
+	return nil
+}
+
+// uploadedTasks returns the tasks of tasksMap that are Uploaded in the database
+func uploadedTasks(dbHandle *db.DB, tasksMap map[string]*woc.WocSyncTask) ([]*woc.WocSyncTask, error) {
+	status := db.Uploaded
+	rows, err := listAllTasks(dbHandle, db.TaskFilter{Status: &status})
+	if err != nil {
+		return nil, err
+	}
+	var tasks []*woc.WocSyncTask
+	for _, row := range rows {
+		if task, ok := tasksMap[row.VirtualPath]; ok {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks, nil
+}
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Compare the uploaded objects with their sources",
+	Long: `Generate the tasks like send and compare the objects of the tasks that are Uploaded in the
+database with the windows of their source files, like rclone check: the sizes first, then the
+MD5 of the source window and the MD5 of the object where it has one. That is the ETag of objects
+uploaded in one part and the MD5 rclone keeps in the metadata of the objects it uploaded in parts;
+their multipart ETag isn't compared. Objects with neither, e.g. uploaded in parts by other tools,
+are compared by size only.
+
+The source windows are read directly, or from an OffsetFS mounted by "syncmate mount" with
+--mount. The differing tasks are marked Failed with the problem, "syncmate retry send" uploads
+them again; the tasks whose sources can't be read are only reported. With --skip-db, the tasks whose objects exist are checked and nothing is recorded.
+The exit code is 1 if any file differs.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		srcPath, _ := cmd.Flags().GetString("src")
+		dstPath, _ := cmd.Flags().GetString("dst")
+		configPath, _ := cmd.Flags().GetString("config")
+		skipDB, _ := cmd.Flags().GetBool("skip-db")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		filter, err := taskFilterFromFlags(cmd)
+		if err != nil {
+			cmd.PrintErrf("Invalid filter: %v\n", err)
+			os.Exit(1)
+		}
+
+		// before fetching remote profiles, which go through the proxy of the config
+		if err := loadConfig(configPath); err != nil {
+			cmd.PrintErrf("%v\n", err)
+			os.Exit(1)
+		}
+		var srcProfile, dstProfile *woc.ParsedWocProfile
+		if tasksFile == "" {
+			srcProfile, dstProfile, err = loadProfiles(srcPath, dstPath)
+			if err != nil {
+				cmd.PrintErrf("%v\n", err)
+				os.Exit(1)
+			}
+		}
+		if !skipDB {
+			if _, err = connectDB(); err != nil {
+				cmd.PrintErrf("Failed to connect to database: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		ctx := context.Background()
+		fsrc, err := newRemote(ctx)
+		if err != nil {
+			cmd.PrintErrf("Failed to create R2 backend: %v\n", err)
+			os.Exit(1)
+		}
+
+		tasksMap, err := loadAllTasks(srcProfile, dstProfile)
+		if err != nil {
+			cmd.PrintErrf("Failed to generate tasks: %v\n", err)
+			os.Exit(1)
+		}
+		filterTasks(tasksMap, filter)
+
+		var tasks []*woc.WocSyncTask
+		if skipDB {
+			for _, task := range tasksMap {
+				tasks = append(tasks, task)
+			}
+		} else if tasks, err = uploadedTasks(dbHandle, tasksMap); err != nil {
+			cmd.PrintErrf("Failed to list uploaded tasks: %v\n", err)
+			os.Exit(1)
+		}
+		names := make([]string, len(tasks))
+		for i, task := range tasks {
+			names[i] = task.VirtualPath
+		}
+		objects, err := rclone.StatFiles(ctx, fsrc, names)
+		if err != nil {
+			cmd.PrintErrf("R2 Backend: %v\n", err)
+			os.Exit(1)
+		}
+		if skipDB {
+			// without the database, the tasks that weren't uploaded can't be told from missing objects
+			found := tasks[:0]
+			for _, task := range tasks {
+				if _, ok := objects[task.VirtualPath]; ok {
+					found = append(found, task)
+				}
+			}
+			tasks = found
+		}
+
+		summary := checkTasks(tasks, objects)
+		summary.print(cmd.OutOrStdout())
+		if len(summary.Findings) == 0 {
+			return
+		}
+		if !skipDB && !dryRun {
+			if err := markCheckFailures(dbHandle, tasksMap, summary.Findings); err != nil {
+				cmd.PrintErrf("%v\n", err)
+			}
+		}
+		os.Exit(1)
+	},
+}
+
+func init() {
+	checkCmd.Flags().StringP("src", "s", "woc.src.json", "WoC profile of the transfer source, a path or URL")
+	checkCmd.Flags().StringP("dst", "d", "woc.dst.json", "Woc profile of the transfer destination, a path or URL")
+	checkCmd.Flags().StringP("config", "c", "config.json", "Path to the configuration file")
+	checkCmd.Flags().Bool("skip-db", false, "Check the tasks whose objects exist instead of the Uploaded tasks of the database, and record nothing")
+	checkCmd.Flags().Bool("dry-run", false, "Print the differing files without marking their tasks Failed")
+	checkCmd.Flags().BoolVar(&checkSizeOnly, "size-only", false, "Compare only the sizes, without reading the sources")
+	checkCmd.Flags().IntVar(&checkers, "checkers", 4, "Number of source windows hashed in parallel")
+	checkCmd.Flags().StringVar(&checkMountDir, "mount", "", "Read the files from the OffsetFS mounted at this directory instead of the source windows")
+	addTaskFilterFlags(checkCmd)
+	addDigestWorkersFlag(checkCmd)
+	addStrictFlag(checkCmd)
+	addSkipBadShardsFlag(checkCmd)
+	addAllVersionsFlag(checkCmd)
+	addDatasetFlags(checkCmd)
+	addRemotePrefixFlag(checkCmd)
+	addRunIDFlag(checkCmd)
+	addTasksFileFlag(checkCmd)
+	RootCmd.AddCommand(checkCmd)
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hrz6976/syncmate/db"
+	"github.com/hrz6976/syncmate/offsetfs"
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckTasks(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	srcPath := filepath.Join(tmpDir, "source.bin")
+	require.NoError(t, os.WriteFile(srcPath, []byte("0123456789"), 0644))
+	bucket := filepath.Join(tmpDir, "bucket")
+	require.NoError(t, os.MkdirAll(bucket, 0755))
+	for name, content := range map[string]string{
+		"a.bin":          "0123456789",
+		"b.bin.offset.3": "3456",
+		"c.bin":          "0123456780",
+		"d.bin":          "01234",
+		"f.bin":          "0123456789",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(bucket, name), []byte(content), 0644))
+	}
+	task := func(name string, offset, size int64) *woc.WocSyncTask {
+		return &woc.WocSyncTask{FileConfig: offsetfs.FileConfig{VirtualPath: name, SourcePath: srcPath, Offset: offset, Size: size}}
+	}
+	tasksMap := map[string]*woc.WocSyncTask{
+		"a.bin":          task("a.bin", 0, 10),
+		"b.bin.offset.3": task("b.bin.offset.3", 3, 4),
+		"c.bin":          task("c.bin", 0, 10),
+		"d.bin":          task("d.bin", 0, 10),
+		"e.bin":          task("e.bin", 0, 10),
+		"f.bin":          task("f.bin", 0, 10),
+	}
+	tasksMap["f.bin"].SourcePath = filepath.Join(tmpDir, "missing.bin")
+	var tasks []*woc.WocSyncTask
+	var names []string
+	for name, task := range tasksMap {
+		tasks = append(tasks, task)
+		names = append(names, name)
+	}
+
+	ctx := context.Background()
+	fsrc, err := fs.NewFs(ctx, bucket)
+	require.NoError(t, err)
+	objects, err := rclone.StatFiles(ctx, fsrc, names)
+	require.NoError(t, err)
+
+	summary := checkTasks(tasks, objects)
+	assert.Equal(t, 6, summary.Checked)
+	assert.Equal(t, 2, summary.Matched)
+	assert.Equal(t, 0, summary.Unhashed)
+	require.Len(t, summary.Findings, 4)
+	assert.Equal(t, "c.bin", summary.Findings[0].VirtualPath)
+	assert.Contains(t, summary.Findings[0].Problem, "MD5")
+	assert.Equal(t, "the object has 5 bytes instead of 10", summary.Findings[1].Problem)
+	assert.Equal(t, "the object is missing", summary.Findings[2].Problem)
+	assert.True(t, summary.Findings[3].Unreadable)
+
+	var out bytes.Buffer
+	summary.print(&out)
+	assert.Contains(t, out.String(), "Checked 6 files: 2 match (0 by size only), 4 differ\n")
+
+	// the tasks of the findings are marked Failed, except the unreadable one
+	dbHandle := openTestDB(t, filepath.Join(tmpDir, "tasks.db"))
+	for _, task := range tasks {
+		require.NoError(t, dbHandle.UpdateTask(sendTaskRow(task, db.Uploaded)))
+	}
+	uploaded, err := uploadedTasks(dbHandle, map[string]*woc.WocSyncTask{"a.bin": tasksMap["a.bin"]})
+	require.NoError(t, err)
+	assert.Equal(t, []*woc.WocSyncTask{tasksMap["a.bin"]}, uploaded)
+	require.NoError(t, markCheckFailures(dbHandle, tasksMap, summary.Findings))
+	for name, status := range map[string]db.Status{"a.bin": db.Uploaded, "c.bin": db.Failed, "e.bin": db.Failed, "f.bin": db.Uploaded} {
+		row, err := dbHandle.GetTask(name)
+		require.NoError(t, err)
+		assert.Equal(t, status, row.Status, name)
+	}
+	row, err := dbHandle.GetTask("d.bin")
+	require.NoError(t, err)
+	assert.Equal(t, "check: the object has 5 bytes instead of 10", row.Error)
+
+	// by size only, the changed content isn't noticed
+	checkSizeOnly = true
+	t.Cleanup(func() { checkSizeOnly = false })
+	summary = checkTasks(tasks, objects)
+	assert.Equal(t, 4, summary.Matched)
+	assert.Equal(t, 4, summary.Unhashed)
+	assert.Len(t, summary.Findings, 2)
+}
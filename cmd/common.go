@@ -1,7 +1,18 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
 	"github.com/hrz6976/syncmate/db"
+	"github.com/hrz6976/syncmate/notify"
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/rclone/rclone/fs"
+	logger "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 type CloudflareCredentials struct {
@@ -12,6 +23,32 @@ type CloudflareCredentials struct {
 	SecretKey  string `json:"secret_key,omitempty"`
 	Bucket     string `json:"bucket,omitempty"`
 	DatabaseID string `json:"database_id,omitempty"`
+	// ApiTokenFile, AccessKeyFile and SecretKeyFile name files the secrets are read from, e.g. docker secrets
+	ApiTokenFile  string `json:"api_token_file,omitempty"`
+	AccessKeyFile string `json:"access_key_file,omitempty"`
+	SecretKeyFile string `json:"secret_key_file,omitempty"`
+	// CredentialHelper is a shell command printing credentials as a JSON object, see resolveCredentials
+	CredentialHelper string `json:"credential_helper,omitempty"`
+	// Notifications configures the webhook notified when send/recv finish
+	Notifications *notify.Config `json:"notifications,omitempty"`
+	// Routing maps downloaded files to subdirectories of the destination directory
+	Routing *RoutingConfig `json:"routing,omitempty"`
+	// Hooks are shell commands run after placing a file and after a run
+	Hooks *HooksConfig `json:"hooks,omitempty"`
+	// Database selects the task database, Cloudflare D1 by default
+	Database *db.Config `json:"database,omitempty"`
+	// Remote selects the storage the files are transferred through, the R2 bucket by default
+	Remote *rclone.RemoteConfig `json:"remote,omitempty"`
+	// Mirrors are further destinations send uploads every file to, see MirrorConfig
+	Mirrors []MirrorConfig `json:"mirrors,omitempty"`
+	// Transfer tunes the part sizes, listing and retries of rclone
+	Transfer *rclone.TransferConfig `json:"transfer,omitempty"`
+	// Proxy routes the connections to the bucket and the database through a proxy, the environment's by default
+	Proxy *rclone.ProxyConfig `json:"proxy,omitempty"`
+	// PathRewrites resolve the paths of the profiles to the files of this host, woc.DefaultPathRewrites by default
+	PathRewrites []woc.PathRewrite `json:"path_rewrites,omitempty"`
+	// Placement tunes how recv writes the downloaded files into their destination
+	Placement *PlacementConfig `json:"placement,omitempty"`
 }
 
 var dbHandle *db.DB
@@ -21,15 +58,158 @@ func connectDB() (*db.DB, error) {
 	if dbHandle != nil {
 		return dbHandle, nil
 	}
+	gormDB, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateOnConnect(gormDB); err != nil {
+		return nil, err
+	}
+	handle := db.NewDB(gormDB)
+	handle.SetRun(runID)
+	if config.Database != nil && config.Database.Journal != "" {
+		if err := handle.SetJournal(config.Database.Journal); err != nil {
+			return nil, err
+		}
+		if queued, err := handle.ReplayJournal(); err != nil {
+			logger.WithError(err).WithField("queued", queued).Warn("Failed to replay the task updates queued in the journal")
+		}
+	}
+	dbHandle = handle
+	return dbHandle, nil
+}
+
+// r2Credentials returns the R2 credentials and the prefix of the transfers of the configuration
+func r2Credentials() *rclone.CloudflareR2Credentials {
+	return &rclone.CloudflareR2Credentials{
+		AccessKey: config.AccessKey,
+		SecretKey: config.SecretKey,
+		AccountID: config.AccountID,
+		Bucket:    config.Bucket,
+		Prefix:    bucketPrefix(),
+	}
+}
+
+// newRemote connects to the storage the files are transferred through, see CloudflareCredentials.Remote
+func newRemote(ctx context.Context) (fs.Fs, error) {
+	return rclone.NewRemote(ctx, config.Remote, r2Credentials())
+}
+
+// openDatabase connects to the database of the configuration, without migrating it
+func openDatabase() (*gorm.DB, error) {
 	cloudflareD1Creds := db.CloudflareD1Credentials{
 		APIToken:   config.ApiToken,
 		DatabaseID: config.DatabaseID,
 		AccountID:  config.AccountID,
 	}
-	gormDB, err := db.ConnectDB(cloudflareD1Creds)
+	return db.Open(config.Database, cloudflareD1Creds)
+}
+
+// migrateOnConnect brings the schema of the database up to date, or with manual migrations
+// refuses to use a database that misses some
+func migrateOnConnect(gormDB *gorm.DB) error {
+	if config.Database != nil && config.Database.ManualMigrations {
+		pending, err := db.PendingMigrations(gormDB)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("the database misses %d schema migrations, run syncmate db migrate", len(pending))
+		}
+		return nil
+	}
+	applied, err := db.Migrate(gormDB)
+	for _, m := range applied {
+		logger.WithField("version", m.Version).Infof("Applied database migration: %s", m.Name)
+	}
+	return err
+}
+
+// loadConfig reads the configuration file into the global config, or takes the settings of the profile
+// of the config file, see applyConfigProfile
+func loadConfig(configPath string) error {
+	if profileConfig != nil {
+		settings := *profileConfig
+		config = &settings
+		return applyConfig(profileName)
+	}
+	configData, err := os.ReadFile(configPath)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to read config file %s: %w", configPath, err)
 	}
-	dbHandle = db.NewDB(gormDB)
-	return dbHandle, nil
+	if err := json.Unmarshal(configData, &config); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", configPath, err)
+	}
+	return applyConfig("config file " + configPath)
+}
+
+// applyConfig validates the global config and applies its settings, source names it in the errors
+func applyConfig(source string) error {
+	if err := resolveCredentials(config); err != nil {
+		return fmt.Errorf("invalid credentials in %s: %w", source, err)
+	}
+	if err := config.Notifications.Validate(); err != nil {
+		return fmt.Errorf("invalid notifications in %s: %w", source, err)
+	}
+	if err := config.Routing.Validate(); err != nil {
+		return fmt.Errorf("invalid routing in %s: %w", source, err)
+	}
+	if err := config.Database.Validate(); err != nil {
+		return fmt.Errorf("invalid database in %s: %w", source, err)
+	}
+	if err := config.Remote.Validate(); err != nil {
+		return fmt.Errorf("invalid remote in %s: %w", source, err)
+	}
+	if err := validateMirrors(config.Mirrors); err != nil {
+		return fmt.Errorf("invalid mirrors in %s: %w", source, err)
+	}
+	if err := rclone.SetProxy(config.Proxy); err != nil {
+		return fmt.Errorf("invalid proxy in %s: %w", source, err)
+	}
+	if config.Proxy != nil {
+		logger.WithField("proxy", config.Proxy.URL).Info("Using the proxy of the " + source)
+	}
+	if err := woc.SetPathRewrites(config.PathRewrites); err != nil {
+		return fmt.Errorf("invalid path_rewrites in %s: %w", source, err)
+	}
+	if config.PathRewrites != nil {
+		logger.WithField("rules", len(config.PathRewrites)).Info("Using the path rewrites of the " + source)
+	}
+	if err := applyPlacementConfig(config.Placement); err != nil {
+		return fmt.Errorf("invalid placement in %s: %w", source, err)
+	}
+	transfer, err := rclone.SetTransferConfig(config.Transfer)
+	if err != nil {
+		return fmt.Errorf("invalid transfer in %s: %w", source, err)
+	}
+	if config.Transfer != nil {
+		logger.WithFields(logger.Fields{
+			"chunk_size":              transfer.ChunkSize,
+			"upload_cutoff":           transfer.UploadCutoff,
+			"copy_cutoff":             transfer.CopyCutoff,
+			"upload_concurrency":      transfer.UploadConcurrency,
+			"list_chunk":              transfer.ListChunk,
+			"memory_pool_flush_time":  transfer.MemoryPoolFlushTime,
+			"multi_thread_chunk_size": transfer.MultiThreadChunkSize,
+			"multi_thread_streams":    transfer.MultiThreadStreams,
+			"multi_thread_cutoff":     transfer.MultiThreadCutoff,
+			"retries":                 transfer.Retries,
+			"low_level_retries":       transfer.LowLevelRetries,
+		}).Info("Using the transfer settings of the " + source)
+	}
+	return nil
+}
+
+// loadProfiles parses the WoC profiles of the transfer source and destination
+func loadProfiles(srcPath, dstPath string) (*woc.ParsedWocProfile, *woc.ParsedWocProfile, error) {
+	opt := woc.ParseOptions{Strict: strictProfiles}
+	srcProfile, err := woc.ParseWocProfileWithOptions(srcPath, opt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse source profile: %w", err)
+	}
+	dstProfile, err := woc.ParseWocProfileWithOptions(dstPath, opt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse destination profile: %w", err)
+	}
+	return srcProfile, dstProfile, nil
 }
@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+// profileEnv selects the profile of the config file without --profile
+const profileEnv = "SYNCMATE_PROFILE"
+
+// ConfigFile is ~/.syncmate/config.yaml, the settings of several transfers by name
+type ConfigFile struct {
+	// DefaultProfile is used without --profile and SYNCMATE_PROFILE
+	DefaultProfile string                    `json:"default_profile,omitempty"`
+	Profiles       map[string]*ConfigProfile `json:"profiles"`
+}
+
+// ConfigProfile is one transfer environment of the config file, e.g. utk-to-cloud
+type ConfigProfile struct {
+	// the settings of config.json, used instead of the file unless -c/--config is given
+	CloudflareCredentials
+	// Flags are the defaults of the command line flags by name, e.g. src, dst, cache-dir or transfers.
+	// A flag given on the command line wins, and the flags a command doesn't have are ignored.
+	Flags map[string]any `json:"flags,omitempty"`
+}
+
+// profileConfig is the configuration of the selected profile that loadConfig uses, nil without one
+var profileConfig *CloudflareCredentials
+
+// profileName names the selected profile in the errors of loadConfig
+var profileName string
+
+func addConfigProfileFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("profile", "", "Named profile of ~/.syncmate/config.yaml, whose settings and flags are used (default $SYNCMATE_PROFILE or default_profile)")
+}
+
+// configFilePath returns the path of the config file in the home directory
+func configFilePath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".syncmate", "config.yaml"), nil
+}
+
+// readConfigFile parses the config file at path, unknown fields are errors
+func readConfigFile(path string) (*ConfigFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file ConfigFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &file, nil
+}
+
+// applyConfigProfile selects the profile of --profile, SYNCMATE_PROFILE or the default profile of the
+// config file, sets the flags of cmd that aren't given on the command line from it, and makes
+// loadConfig use its settings. Nothing happens without a profile.
+func applyConfigProfile(cmd *cobra.Command) error {
+	name, _ := cmd.Flags().GetString("profile")
+	if name == "" {
+		name = os.Getenv(profileEnv)
+	}
+	path, err := configFilePath()
+	if err != nil {
+		if name == "" {
+			return nil
+		}
+		return fmt.Errorf("failed to find the config file of profile %s: %w", name, err)
+	}
+	file, err := readConfigFile(path)
+	if errors.Is(err, os.ErrNotExist) && name == "" {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if name == "" {
+		name = file.DefaultProfile
+		if name == "" {
+			return nil
+		}
+	}
+	profile, ok := file.Profiles[name]
+	if !ok || profile == nil {
+		names := make([]string, 0, len(file.Profiles))
+		for n := range file.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("%s has no profile %q, it has %s", path, name, strings.Join(names, ", "))
+	}
+
+	for flagName, value := range profile.Flags {
+		flag := cmd.Flags().Lookup(flagName)
+		if flag == nil || flag.Changed || flagName == "profile" {
+			continue
+		}
+		if err := setFlagValue(cmd.Flags(), flag, value); err != nil {
+			return fmt.Errorf("invalid flag %s of profile %s in %s: %w", flagName, name, path, err)
+		}
+	}
+	if flag := cmd.Flags().Lookup("config"); flag == nil || !flag.Changed {
+		settings := profile.CloudflareCredentials
+		profileConfig = &settings
+		profileName = fmt.Sprintf("profile %s of %s", name, path)
+	}
+	logger.WithFields(logger.Fields{"profile": name, "file": path}).Debug("Using the profile of the config file")
+	return nil
+}
+
+// setFlagValue sets flag of flags to a value parsed from YAML: a string, a number, a bool or a list of them
+func setFlagValue(flags *pflag.FlagSet, flag *pflag.Flag, value any) error {
+	list, ok := value.([]any)
+	if !ok {
+		s, err := flagString(value)
+		if err != nil {
+			return err
+		}
+		return flags.Set(flag.Name, s)
+	}
+	items := make([]string, len(list))
+	for i, item := range list {
+		s, err := flagString(item)
+		if err != nil {
+			return err
+		}
+		items[i] = s
+	}
+	slice, ok := flag.Value.(pflag.SliceValue)
+	if !ok {
+		return flags.Set(flag.Name, strings.Join(items, ","))
+	}
+	if err := slice.Replace(items); err != nil {
+		return err
+	}
+	flag.Changed = true
+	return nil
+}
+
+func flagString(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		// YAML numbers are decoded as float64, large ones would be printed as 1e+06
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("must be a string, a number, a bool or a list, got %v", v)
+	}
+}
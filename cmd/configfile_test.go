@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfigFile = `default_profile: utk-to-cloud
+profiles:
+  utk-to-cloud:
+    account_id: utk
+    bucket: woc
+    path_rewrites:
+      - prefix: /da5_data
+        replacement: /data
+    flags:
+      src: /data/woc.src.json
+      transfers: 16
+      max-bytes: 1000000000
+      include: ["*.tch", "*.idx"]
+  cloud-to-backup:
+    account_id: backup
+    flags:
+      config: backup.json
+`
+
+// testProfileCommand has flags like those of send
+func testProfileCommand() *cobra.Command {
+	cmd := &cobra.Command{Use: "test", Run: func(cmd *cobra.Command, args []string) {}}
+	cmd.Flags().StringP("src", "s", "woc.src.json", "")
+	cmd.Flags().StringP("config", "c", "config.json", "")
+	cmd.Flags().Int("transfers", 4, "")
+	cmd.Flags().Int64("max-bytes", 0, "")
+	cmd.Flags().StringSlice("include", nil, "")
+	addConfigProfileFlag(cmd)
+	return cmd
+}
+
+func TestApplyConfigProfile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(profileEnv, "")
+	t.Cleanup(func() { profileConfig, profileName = nil, "" })
+
+	// without a config file nothing changes
+	cmd := testProfileCommand()
+	require.NoError(t, cmd.ParseFlags(nil))
+	require.NoError(t, applyConfigProfile(cmd))
+	assert.Nil(t, profileConfig)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(home, ".syncmate"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(home, ".syncmate", "config.yaml"), []byte(testConfigFile), 0644))
+
+	// the default profile, the command line wins
+	cmd = testProfileCommand()
+	require.NoError(t, cmd.ParseFlags([]string{"--transfers", "2"}))
+	require.NoError(t, applyConfigProfile(cmd))
+	src, _ := cmd.Flags().GetString("src")
+	assert.Equal(t, "/data/woc.src.json", src)
+	transfers, _ := cmd.Flags().GetInt("transfers")
+	assert.Equal(t, 2, transfers)
+	maxBytes, _ := cmd.Flags().GetInt64("max-bytes")
+	assert.Equal(t, int64(1000000000), maxBytes)
+	include, _ := cmd.Flags().GetStringSlice("include")
+	assert.Equal(t, []string{"*.tch", "*.idx"}, include)
+	require.NotNil(t, profileConfig)
+	assert.Equal(t, "utk", profileConfig.AccountID)
+	assert.Equal(t, "/data", profileConfig.PathRewrites[0].Replacement)
+	assert.Contains(t, profileName, "profile utk-to-cloud of ")
+
+	// a config file set by the profile is read instead of its settings
+	profileConfig = nil
+	cmd = testProfileCommand()
+	require.NoError(t, cmd.ParseFlags([]string{"--profile", "cloud-to-backup"}))
+	require.NoError(t, applyConfigProfile(cmd))
+	configPath, _ := cmd.Flags().GetString("config")
+	assert.Equal(t, "backup.json", configPath)
+	assert.Nil(t, profileConfig)
+
+	cmd = testProfileCommand()
+	require.NoError(t, cmd.ParseFlags([]string{"--profile", "missing"}))
+	assert.ErrorContains(t, applyConfigProfile(cmd), `has no profile "missing", it has cloud-to-backup, utk-to-cloud`)
+
+	// typos are errors
+	require.NoError(t, os.WriteFile(filepath.Join(home, ".syncmate", "config.yaml"), []byte("profiles:\n  a:\n    acount_id: x\n"), 0644))
+	cmd = testProfileCommand()
+	require.NoError(t, cmd.ParseFlags([]string{"--profile", "a"}))
+	assert.ErrorContains(t, applyConfigProfile(cmd), "acount_id")
+}
+
+func TestLoadConfig_Profile(t *testing.T) {
+	t.Cleanup(func() { profileConfig, profileName, config = nil, "", nil })
+	profileConfig = &CloudflareCredentials{AccountID: "utk", Bucket: "woc"}
+	profileName = "profile utk-to-cloud of config.yaml"
+	require.NoError(t, loadConfig("missing.json"))
+	assert.Equal(t, "woc", config.Bucket)
+	// the profile isn't changed by the commands
+	config.Bucket = "other"
+	assert.Equal(t, "woc", profileConfig.Bucket)
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+// credentialEnvPrefix starts the environment variables of the credentials, e.g. SYNCMATE_SECRET_KEY
+const credentialEnvPrefix = "SYNCMATE_"
+
+// credentialHelperTimeout bounds the run of the credential helper
+const credentialHelperTimeout = 30 * time.Second
+
+// credentialField is a credential of config.json that can come from another source
+type credentialField struct {
+	// name is the JSON field, e.g. secret_key
+	name  string
+	value *string
+	// file is the setting naming the file of the credential, nil if there is none
+	file *string
+}
+
+func credentialFields(c *CloudflareCredentials) []credentialField {
+	return []credentialField{
+		{"account_id", &c.AccountID, nil},
+		{"api_token", &c.ApiToken, &c.ApiTokenFile},
+		{"access_key", &c.AccessKey, &c.AccessKeyFile},
+		{"secret_key", &c.SecretKey, &c.SecretKeyFile},
+		{"bucket", &c.Bucket, nil},
+		{"database_id", &c.DatabaseID, nil},
+	}
+}
+
+// resolveCredentials fills in the credentials of c from their other sources. The first source that
+// has a credential wins: the environment variable SYNCMATE_<FIELD>, the file named by
+// SYNCMATE_<FIELD>_FILE or by the <field>_file setting, the output of the credential helper, and
+// last the value in c.
+func resolveCredentials(c *CloudflareCredentials) error {
+	var helped map[string]string
+	if c.CredentialHelper != "" {
+		var err error
+		if helped, err = runCredentialHelper(c.CredentialHelper); err != nil {
+			return err
+		}
+		for name := range helped {
+			if !isCredentialField(c, name) {
+				return fmt.Errorf("credential_helper printed unknown credential %q", name)
+			}
+		}
+	}
+	for _, field := range credentialFields(c) {
+		env := credentialEnvPrefix + strings.ToUpper(field.name)
+		source := ""
+		if value := os.Getenv(env); value != "" {
+			*field.value, source = value, env
+		} else if field.file != nil {
+			path := os.Getenv(env + "_FILE")
+			if path == "" {
+				path = *field.file
+			}
+			if path != "" {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return fmt.Errorf("failed to read %s: %w", field.name, err)
+				}
+				*field.value, source = strings.TrimSpace(string(data)), path
+			}
+		}
+		if value, ok := helped[field.name]; ok && source == "" {
+			*field.value, source = value, "credential_helper"
+		}
+		if source != "" {
+			logger.WithFields(logger.Fields{"credential": field.name, "source": source}).Debug("Read credential")
+		}
+	}
+	return nil
+}
+
+func isCredentialField(c *CloudflareCredentials, name string) bool {
+	for _, field := range credentialFields(c) {
+		if field.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// runCredentialHelper runs helper with the shell and decodes the JSON object of credentials it prints,
+// e.g. {"access_key": "...", "secret_key": "..."}
+func runCredentialHelper(helper string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), credentialHelperTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", helper)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("credential_helper failed: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("credential_helper failed: %w", err)
+	}
+	var credentials map[string]string
+	if err := json.Unmarshal(output, &credentials); err != nil {
+		// the output has secrets, it isn't quoted
+		return nil, fmt.Errorf("credential_helper must print a JSON object of strings: %w", err)
+	}
+	return credentials, nil
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveCredentials(t *testing.T) {
+	tmpDir := t.TempDir()
+	secretFile := filepath.Join(tmpDir, "secret_key")
+	require.NoError(t, os.WriteFile(secretFile, []byte("file-secret\n"), 0600))
+	tokenFile := filepath.Join(tmpDir, "api_token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("env-file-token"), 0600))
+	for _, name := range []string{"ACCOUNT_ID", "API_TOKEN", "ACCESS_KEY", "SECRET_KEY", "BUCKET", "DATABASE_ID"} {
+		t.Setenv(credentialEnvPrefix+name, "")
+		t.Setenv(credentialEnvPrefix+name+"_FILE", "")
+	}
+	t.Setenv("SYNCMATE_ACCESS_KEY", "env-access")
+	t.Setenv("SYNCMATE_API_TOKEN_FILE", tokenFile)
+
+	c := &CloudflareCredentials{
+		AccountID:        "plain-account",
+		AccessKey:        "plain-access",
+		SecretKeyFile:    secretFile,
+		Bucket:           "plain-bucket",
+		CredentialHelper: `echo '{"access_key": "helper-access", "bucket": "helper-bucket", "database_id": "helper-db"}'`,
+	}
+	require.NoError(t, resolveCredentials(c))
+	assert.Equal(t, "plain-account", c.AccountID)
+	assert.Equal(t, "env-access", c.AccessKey)
+	assert.Equal(t, "file-secret", c.SecretKey)
+	assert.Equal(t, "env-file-token", c.ApiToken)
+	assert.Equal(t, "helper-bucket", c.Bucket)
+	assert.Equal(t, "helper-db", c.DatabaseID)
+
+	c = &CloudflareCredentials{CredentialHelper: `echo '{"secret": "x"}'`}
+	assert.ErrorContains(t, resolveCredentials(c), `unknown credential "secret"`)
+	c = &CloudflareCredentials{CredentialHelper: `echo vault is sealed >&2; exit 2`}
+	assert.ErrorContains(t, resolveCredentials(c), "vault is sealed")
+	c = &CloudflareCredentials{SecretKeyFile: filepath.Join(tmpDir, "missing")}
+	assert.ErrorContains(t, resolveCredentials(c), "failed to read secret_key")
+}
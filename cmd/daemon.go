@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/hrz6976/syncmate/rclone"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// writePidfile records the pid of this process, refusing to start if another daemon is alive
+func writePidfile(pidfile string) error {
+	if data, err := os.ReadFile(pidfile); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && pid != os.Getpid() {
+			if syscall.Kill(pid, 0) == nil {
+				return fmt.Errorf("daemon already running with pid %d (pidfile %s)", pid, pidfile)
+			}
+		}
+		logger.WithField("pidfile", pidfile).Warn("Removing stale pidfile")
+	}
+	return os.WriteFile(pidfile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// runDaemonCycle re-generates the tasks from the profiles and transfers them once, with the tasks of
+// syncmate/inject
+func runDaemonCycle(role, srcPath, dstPath string, filter *taskFilter, deleteRemote bool) error {
+	srcProfile, dstProfile, err := loadProfiles(srcPath, dstPath)
+	if err != nil {
+		return err
+	}
+	tasksMap, err := generateTasks(srcProfile, dstProfile, role == "send")
+	if err != nil {
+		return fmt.Errorf("failed to generate tasks: %w", err)
+	}
+	beatWatchdog()
+	filterTasks(tasksMap, filter)
+	if n := takeInjectedTasks(tasksMap); n > 0 {
+		logger.WithField("taskCount", n).Info("Added the injected tasks")
+	}
+
+	logger.WithField("taskCount", len(tasksMap)).Info("Generated tasks for file transfer")
+	if len(tasksMap) == 0 {
+		logger.Info("No tasks to execute in this cycle")
+		return nil
+	}
+
+	rclone.ResetTransferStats()
+	startRun(role)
+	switch role {
+	case "send":
+		err = runSend(tasksMap)
+	case "recv":
+		err = runRecv(cacheDir, tasksMap, deleteRemote)
+	}
+	finishRun(err)
+	return err
+}
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon {send|recv}",
+	Short: "Run send or recv periodically",
+	Long: `Run send (on the source host) or recv (on the destination host) in a loop.
+Every cycle re-reads the WoC profiles and re-generates the tasks. Cycles start
+every --interval, or when the --cron expression matches. SIGINT or SIGTERM stops
+the daemon after cleaning up the current cycle. With --rc-addr, the cycles can
+be paused with syncmate/pause and tasks added with syncmate/inject.`,
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"send", "recv"},
+	Run: func(cmd *cobra.Command, args []string) {
+		role := args[0]
+		srcPath, _ := cmd.Flags().GetString("src")
+		dstPath, _ := cmd.Flags().GetString("dst")
+		configPath, _ := cmd.Flags().GetString("config")
+		skipDB, _ := cmd.Flags().GetBool("skip-db")
+		cacheDir, _ = cmd.Flags().GetString("cache-dir")
+		destDir, _ = cmd.Flags().GetString("dest-dir")
+		deleteRemote, _ := cmd.Flags().GetBool("delete-remote")
+		orderFlag, _ := cmd.Flags().GetString("order")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		cronExpr, _ := cmd.Flags().GetString("cron")
+		pidfile, _ := cmd.Flags().GetString("pidfile")
+
+		if role == "recv" && cacheDir == "" {
+			cmd.PrintErrln("--cache-dir is required to run the recv daemon")
+			return
+		}
+		if destDir == "" {
+			destDir = cacheDir
+		}
+
+		var sched schedule
+		switch {
+		case cronExpr != "" && cmd.Flags().Changed("interval"):
+			cmd.PrintErrln("--interval and --cron are mutually exclusive")
+			return
+		case cronExpr != "":
+			cronSched, err := parseCron(cronExpr)
+			if err != nil {
+				cmd.PrintErrf("Invalid --cron: %v\n", err)
+				return
+			}
+			sched = cronSched
+		case interval > 0:
+			sched = intervalSchedule(interval)
+		default:
+			cmd.PrintErrln("--interval must be positive")
+			return
+		}
+
+		var err error
+		if transferOrder, err = parseTaskOrder(orderFlag); err != nil {
+			cmd.PrintErrf("Invalid --order: %v\n", err)
+			return
+		}
+		filter, err := taskFilterFromFlags(cmd)
+		if err != nil {
+			cmd.PrintErrf("Invalid filter: %v\n", err)
+			return
+		}
+
+		if err := loadConfig(configPath); err != nil {
+			cmd.PrintErrf("%v\n", err)
+			return
+		}
+		if err := applyMultiThreadFlags(); err != nil {
+			cmd.PrintErrf("Invalid multi-thread download flags: %v\n", err)
+			return
+		}
+
+		if pidfile != "" {
+			if err := writePidfile(pidfile); err != nil {
+				cmd.PrintErrf("Failed to write pidfile: %v\n", err)
+				return
+			}
+			defer os.Remove(pidfile)
+		}
+
+		releaseLock, err := acquireRunLock(runLockPath(role))
+		if err != nil {
+			cmd.PrintErrf("%v\n", err)
+			return
+		}
+		defer releaseLock()
+
+		if !skipDB {
+			if _, err = connectDB(); err != nil {
+				cmd.PrintErrf("Failed to connect to database: %v\n", err)
+				return
+			}
+		}
+
+		direction := "upload"
+		if role == "recv" {
+			direction = "download"
+		}
+		stopMetrics, err := startMetrics(cmd, direction)
+		if err != nil {
+			cmd.PrintErrf("Failed to serve metrics: %v\n", err)
+			return
+		}
+		defer stopMetrics()
+		stopRC, err := startRC(cmd)
+		if err != nil {
+			cmd.PrintErrf("Failed to serve the remote control API: %v\n", err)
+			return
+		}
+		defer stopRC()
+		wake := enableInjection()
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		// with Type=notify, systemd starts the units after this one once it is ready
+		stopWatchdog := startWatchdog()
+		defer stopWatchdog()
+		notifySystemd(daemon.SdNotifyReady)
+
+		for cycle := 1; ; cycle++ {
+			if rclone.TransfersPaused() {
+				logger.Info("Transfers are paused, waiting for syncmate/resume")
+				notifySystemd("STATUS=Paused, waiting for syncmate/resume")
+				watchdogIdle.Store(true)
+				err := rclone.WaitResumed(ctx)
+				watchdogIdle.Store(false)
+				if err != nil {
+					break
+				}
+			}
+			beatWatchdog()
+			cycleStart := time.Now()
+			logger.WithFields(logger.Fields{"role": role, "cycle": cycle}).Info("Starting sync cycle")
+			notifySystemd(fmt.Sprintf("STATUS=Running sync cycle %d", cycle))
+			if err := runDaemonCycle(role, srcPath, dstPath, filter, deleteRemote); err != nil {
+				logger.WithError(err).WithField("cycle", cycle).Error("Sync cycle failed")
+			} else {
+				logger.WithFields(logger.Fields{"cycle": cycle, "duration": time.Since(cycleStart).Round(time.Second)}).Info("Sync cycle completed")
+			}
+			if ctx.Err() != nil {
+				break
+			}
+
+			next := sched.Next(cycleStart)
+			if next.IsZero() {
+				logger.Info("Schedule has no further cycles, exiting")
+				break
+			}
+			logger.WithField("next", next.Format(time.RFC3339)).Info("Waiting for next sync cycle")
+			notifySystemd(fmt.Sprintf("STATUS=Waiting for sync cycle %d at %s", cycle+1, next.Format(time.RFC3339)))
+			watchdogIdle.Store(true)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Until(next)):
+			case <-wake:
+				logger.Info("Starting the next cycle for the injected tasks")
+			}
+			watchdogIdle.Store(false)
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		notifySystemd(daemon.SdNotifyStopping)
+		logger.Info("Daemon stopped")
+	},
+}
+
+func init() {
+	daemonCmd.Flags().StringP("src", "s", "woc.src.json", "WoC profile of the transfer source, a path or URL")
+	daemonCmd.Flags().StringP("dst", "d", "woc.dst.json", "Woc profile of the transfer destination, a path or URL")
+	daemonCmd.Flags().StringP("config", "c", "config.json", "Path to the configuration file")
+	daemonCmd.Flags().StringP("cache-dir", "C", "", "Path to the cache directory (recv only)")
+	daemonCmd.Flags().StringP("dest-dir", "D", "", "Default destination directory for downloaded files. Uses cache-dir if not specified (recv only)")
+	daemonCmd.Flags().Bool("skip-db", false, "Skip database operations")
+	daemonCmd.Flags().Bool("delete-remote", true, "Delete files on remote after download (recv only)")
+	daemonCmd.Flags().String("order", string(OrderSmallestFirst), "Transfer order: smallest-first, largest-first, by-map, or by-priority-column")
+	daemonCmd.Flags().Duration("interval", time.Hour, "Time between the starts of two sync cycles")
+	daemonCmd.Flags().String("cron", "", "Start sync cycles when this cron expression (minute hour day month weekday) matches, instead of --interval")
+	daemonCmd.Flags().String("pidfile", "syncmate.pid", "Write the daemon pid to this file, empty to disable")
+	addTaskFilterFlags(daemonCmd)
+	addDigestWorkersFlag(daemonCmd)
+	addStrictFlag(daemonCmd)
+	addSkipBadShardsFlag(daemonCmd)
+	addAllVersionsFlag(daemonCmd)
+	addDatasetFlags(daemonCmd)
+	addMetricsAddrFlag(daemonCmd)
+	addRCFlags(daemonCmd)
+	addMultiThreadFlags(daemonCmd)
+	addTransferLimitFlags(daemonCmd)
+	addReportFileFlag(daemonCmd)
+	addArchiveFlags(daemonCmd)
+	addDiskSpaceFlags(daemonCmd)
+	addMoveWorkersFlags(daemonCmd)
+	addFileDoneHookFlag(daemonCmd)
+	addRunDoneHookFlag(daemonCmd)
+	addDeadLetterFlags(daemonCmd)
+	addProgressIntervalFlag(daemonCmd)
+	addDBProgressIntervalFlag(daemonCmd)
+	addRemotePrefixFlag(daemonCmd)
+	addRunIDFlag(daemonCmd)
+	RootCmd.AddCommand(daemonCmd)
+}
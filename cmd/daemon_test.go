@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritePidfile(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	pidfile := filepath.Join(tmpDir, "syncmate.pid")
+
+	require.NoError(t, writePidfile(pidfile))
+	data, err := os.ReadFile(pidfile)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(os.Getpid())+"\n", string(data))
+
+	// a live process holds the pidfile
+	require.NoError(t, os.WriteFile(pidfile, []byte(strconv.Itoa(os.Getppid())), 0644))
+	assert.Error(t, writePidfile(pidfile))
+
+	// a stale pidfile is replaced
+	require.NoError(t, os.WriteFile(pidfile, []byte("999999999"), 0644))
+	assert.NoError(t, writePidfile(pidfile))
+}
+
+func TestWatchdogStalled(t *testing.T) {
+	defer watchdogIdle.Store(false)
+	beatWatchdog()
+	now := time.Now()
+	assert.False(t, watchdogStalled(time.Minute, now))
+	assert.True(t, watchdogStalled(time.Minute, now.Add(2*time.Minute)), "no progress for longer than WatchdogSec")
+
+	// waiting for the next cycle is not a hang
+	watchdogIdle.Store(true)
+	assert.False(t, watchdogStalled(time.Minute, now.Add(2*time.Minute)))
+}
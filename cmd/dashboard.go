@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/hrz6976/syncmate/db"
+	"github.com/rclone/rclone/fs/rc"
+)
+
+// dashboardFiles is the web dashboard of --rc-dashboard, which calls the rc API below
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// writeDashboard copies the dashboard to a new temporary directory, which rclone's rc server serves
+func writeDashboard() (string, error) {
+	dir, err := os.MkdirTemp("", "syncmate-dashboard-")
+	if err != nil {
+		return "", err
+	}
+	files, err := fs.Sub(dashboardFiles, "dashboard")
+	if err == nil {
+		err = os.CopyFS(dir, files)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to write the dashboard: %w", err)
+	}
+	return dir, nil
+}
+
+// dashboardTask is a task of the database in the output of syncmate/tasks and syncmate/errors
+type dashboardTask struct {
+	VirtualPath string    `json:"virtual_path"`
+	Status      string    `json:"status"`
+	Size        int64     `json:"size"`
+	Transferred int64     `json:"transferred"`
+	Placed      int64     `json:"placed,omitempty"`
+	Attempts    int       `json:"attempts"`
+	Updated     time.Time `json:"updated"`
+	Error       string    `json:"error,omitempty"`
+}
+
+func newDashboardTasks(tasks []*db.Task) []dashboardTask {
+	out := make([]dashboardTask, 0, len(tasks))
+	for _, task := range tasks {
+		out = append(out, dashboardTask{
+			VirtualPath: task.VirtualPath,
+			Status:      task.Status.String(),
+			Size:        task.SrcSize,
+			Transferred: task.TransferredBytes,
+			Placed:      task.PlacedBytes,
+			Attempts:    task.Attempts,
+			Updated:     task.UpdatedAt,
+			Error:       task.Error,
+		})
+	}
+	return out
+}
+
+// errNoDatabase is the error of the rc calls that read the database in runs with --skip-db
+var errNoDatabase = errors.New("the database isn't connected, the run has --skip-db")
+
+// maxDashboardTasks is the most tasks syncmate/tasks and syncmate/errors return at once
+const maxDashboardTasks = 500
+
+// rcLimit returns the limit parameter of in, def if it is missing
+func rcLimit(in rc.Params, def int64) (int, error) {
+	limit, err := in.GetInt64("limit")
+	if rc.IsErrParamNotFound(err) {
+		return int(def), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if limit < 1 || limit > maxDashboardTasks {
+		return 0, fmt.Errorf("limit must be between 1 and %d, got %d", maxDashboardTasks, limit)
+	}
+	return int(limit), nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "syncmate/summary",
+		Fn:    rcSummary,
+		Title: "Summarize the tasks of the database and the files in the bucket",
+		Help: `This takes no parameters and returns the snapshot of "syncmate status --json": the count
+and size of the tasks of the database by status and the files and bytes in the bucket. The bucket
+is listed on every call.
+`,
+	})
+	rc.Add(rc.Call{
+		Path:  "syncmate/tasks",
+		Fn:    rcTasks,
+		Title: "List the tasks of the database with a status",
+		Help: `This takes the following parameters:
+
+- status - the status of the tasks, e.g. Downloading
+- offset - the tasks to skip, by virtual path (optional)
+- limit - the most tasks to return, 50 by default and at most 500 (optional)
+
+It returns "tasks", each with virtual_path, status, size, the bytes transferred and placed, attempts,
+the time of the last update and the error.
+`,
+	})
+	rc.Add(rc.Call{
+		Path:  "syncmate/errors",
+		Fn:    rcErrors,
+		Title: "List the tasks of the database with an error",
+		Help: `This takes the following parameters:
+
+- limit - the most tasks to return, 20 by default and at most 500 (optional)
+
+It returns "tasks" like syncmate/tasks, the most recently updated first.
+`,
+	})
+}
+
+func rcSummary(ctx context.Context, in rc.Params) (rc.Params, error) {
+	out := rc.Params{}
+	if err := rc.Reshape(&out, collectStatus(dbHandle == nil)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func rcTasks(ctx context.Context, in rc.Params) (rc.Params, error) {
+	if dbHandle == nil {
+		return nil, errNoDatabase
+	}
+	name, err := in.GetString("status")
+	if err != nil {
+		return nil, err
+	}
+	status, err := db.ParseStatus(name)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := in.GetInt64("offset")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("offset must not be negative, got %d", offset)
+	}
+	limit, err := rcLimit(in, 50)
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := dbHandle.ListTasksFiltered(db.TaskFilter{Status: &status}, int(offset), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tasks: %w", err)
+	}
+	return rc.Params{"tasks": newDashboardTasks(tasks)}, nil
+}
+
+func rcErrors(ctx context.Context, in rc.Params) (rc.Params, error) {
+	if dbHandle == nil {
+		return nil, errNoDatabase
+	}
+	limit, err := rcLimit(in, 20)
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := dbHandle.ListRecentErrors(limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list errors: %w", err)
+	}
+	return rc.Params{"tasks": newDashboardTasks(tasks)}, nil
+}
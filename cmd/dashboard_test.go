@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hrz6976/syncmate/db"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRCTasks(t *testing.T) {
+	ctx := context.Background()
+	oldHandle := dbHandle
+	t.Cleanup(func() { dbHandle = oldHandle })
+	dbHandle = nil
+	_, err := rcTasks(ctx, rc.Params{"status": "Failed"})
+	assert.ErrorIs(t, err, errNoDatabase)
+
+	dbHandle = openTestDB(t, filepath.Join(setupTestDir(t), "tasks.db"))
+	for _, task := range []*db.Task{
+		{VirtualPath: "a.bin", SrcPath: "/src/a.bin", SrcSize: 1024, Status: db.Failed, Error: "digest mismatch"},
+		{VirtualPath: "b.bin", SrcPath: "/src/b.bin", SrcSize: 2048, Status: db.Failed, Error: "connection reset"},
+		{VirtualPath: "c.bin", SrcPath: "/src/c.bin", SrcSize: 1024, Status: db.Downloaded},
+	} {
+		require.NoError(t, dbHandle.UpdateTask(task))
+	}
+
+	out, err := rcTasks(ctx, rc.Params{"status": "failed", "offset": 1, "limit": 10})
+	require.NoError(t, err)
+	tasks := out["tasks"].([]dashboardTask)
+	require.Len(t, tasks, 1)
+	assert.Equal(t, "b.bin", tasks[0].VirtualPath)
+	assert.Equal(t, int64(2048), tasks[0].Size)
+	assert.Equal(t, "connection reset", tasks[0].Error)
+
+	_, err = rcTasks(ctx, rc.Params{"status": "done"})
+	assert.Error(t, err)
+	_, err = rcTasks(ctx, rc.Params{"status": "Failed", "limit": 1000})
+	assert.Error(t, err)
+
+	out, err = rcErrors(ctx, rc.Params{})
+	require.NoError(t, err)
+	assert.Len(t, out["tasks"], 2)
+}
+
+func TestStartRC_Dashboard(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	t.Setenv("TMPDIR", t.TempDir())
+	cmd := &cobra.Command{}
+	addRCFlags(cmd)
+	require.NoError(t, cmd.ParseFlags([]string{"--rc-addr", addr, "--rc-dashboard"}))
+	stop, err := startRC(cmd)
+	require.NoError(t, err)
+	stopped := false
+	t.Cleanup(func() {
+		if !stopped {
+			stop()
+		}
+	})
+
+	resp, err := http.Get("http://" + addr + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), "<title>SyncMate</title>")
+
+	// the copy of the dashboard is removed with the server
+	matches, err := filepath.Glob(filepath.Join(os.TempDir(), "syncmate-dashboard-*", "index.html"))
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	stop()
+	stopped = true
+	for _, match := range matches {
+		assert.NoFileExists(t, match)
+	}
+}
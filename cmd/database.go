@@ -0,0 +1,435 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	d1 "github.com/hrz6976/syncmate/d1_gorm_adapter"
+	"github.com/hrz6976/syncmate/db"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+// parseDate reads a date like 2024-01-01 as local midnight, or an RFC 3339 time
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expected e.g. 2024-01-01 or 2024-01-01T00:00:00Z", s)
+	}
+	return t, nil
+}
+
+// writeTaskRecords writes tasks with their events to w as JSONL
+func writeTaskRecords(dbHandle *db.DB, w io.Writer, tasks []*db.Task) error {
+	ids := make([]uint, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.ID
+	}
+	events, err := dbHandle.ListEventsOfTasks(ids)
+	if err != nil {
+		return fmt.Errorf("failed to list task events: %w", err)
+	}
+	byTask := make(map[uint][]*db.TaskEvent)
+	for _, event := range events {
+		byTask[event.TaskID] = append(byTask[event.TaskID], event)
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, task := range tasks {
+		if err := enc.Encode(db.TaskRecord{Task: task, Events: byTask[task.ID]}); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// readTaskRecords reads the JSONL written by writeTaskRecords
+func readTaskRecords(r io.Reader) ([]db.TaskRecord, error) {
+	var records []db.TaskRecord
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record := db.TaskRecord{Task: &db.Task{}}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if record.VirtualPath == "" {
+			return nil, fmt.Errorf("line %d: task without a VirtualPath", line)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// writePurgeArchive writes tasks and their events to a new JSONL file at path
+func writePurgeArchive(dbHandle *db.DB, path string, tasks []*db.Task) error {
+	// never overwrite an earlier archive
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	if err := writeTaskRecords(dbHandle, f, tasks); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	// the rows are gone once deleted, the archive must be on disk first
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return f.Close()
+}
+
+// runPurge archives the tasks matching filter to archivePath and deletes them from the database
+func runPurge(w io.Writer, dbHandle *db.DB, filter db.TaskFilter, archivePath string, dryRun bool) error {
+	tasks, err := listAllTasks(dbHandle, filter)
+	if err != nil {
+		return fmt.Errorf("failed to list tasks: %w", err)
+	}
+	var size int64
+	for _, task := range tasks {
+		size += task.SrcSize
+	}
+	if dryRun {
+		fmt.Fprintf(w, "Would purge %d tasks (%s)\n", len(tasks), formatSize(size))
+		return nil
+	}
+	if len(tasks) == 0 {
+		fmt.Fprintln(w, "No matching tasks")
+		return nil
+	}
+	if err := writePurgeArchive(dbHandle, archivePath, tasks); err != nil {
+		return err
+	}
+	ids := make([]uint, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.ID
+	}
+	if err := dbHandle.PurgeTasks(ids); err != nil {
+		return fmt.Errorf("failed to delete tasks, the archive %s holds them all: %w", archivePath, err)
+	}
+	fmt.Fprintf(w, "Purged %d tasks (%s), archived to %s\n", len(tasks), formatSize(size), archivePath)
+	return nil
+}
+
+// runExport writes every task of the run with its events to w
+func runExport(w io.Writer, dbHandle *db.DB) (int, error) {
+	tasks, err := listAllTasks(dbHandle, db.TaskFilter{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list tasks: %w", err)
+	}
+	if err := writeTaskRecords(dbHandle, w, tasks); err != nil {
+		return 0, fmt.Errorf("failed to write tasks: %w", err)
+	}
+	return len(tasks), nil
+}
+
+// runMigrate applies the pending schema migrations, or with dryRun only lists them
+func runMigrate(w io.Writer, gormDB *gorm.DB, dryRun bool) error {
+	pending, err := db.PendingMigrations(gormDB)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		fmt.Fprintln(w, "The database schema is up to date")
+		return nil
+	}
+	if dryRun {
+		fmt.Fprintf(w, "Would apply %d migrations:\n", len(pending))
+		for _, m := range pending {
+			fmt.Fprintf(w, "  %d  %s\n", m.Version, m.Name)
+		}
+		return nil
+	}
+	applied, err := db.Migrate(gormDB)
+	for _, m := range applied {
+		fmt.Fprintf(w, "Applied migration %d: %s\n", m.Version, m.Name)
+	}
+	return err
+}
+
+// pingHint suggests what to fix for an error of db ping with the driver
+func pingHint(driver string, err error) string {
+	msg := err.Error()
+	switch {
+	case driver != db.DriverD1:
+		return fmt.Sprintf("check the path or dsn of the %s database in the database section of the config", driver)
+	case errors.Is(err, d1.ErrInvalidDB) || errors.Is(err, d1.ErrShortDSN):
+		return "database_id must be the UUID of the D1 database, see the D1 dashboard"
+	case strings.Contains(msg, "http status: 401") || strings.Contains(msg, "http status: 403"):
+		return "the API token is invalid or lacks the D1 Edit permission of the account, check api_token and account_id"
+	case strings.Contains(msg, "http status: 404"):
+		return "the account or the database doesn't exist, check account_id and database_id"
+	case strings.Contains(msg, "http status: 429"):
+		return "the Cloudflare API rate-limits the account, try again later"
+	case strings.Contains(msg, "dial tcp") || strings.Contains(msg, "no such host") || strings.Contains(msg, "timeout"):
+		return "the Cloudflare API can't be reached, check the network and the proxy settings"
+	}
+	return "check api_token, account_id and database_id in the config"
+}
+
+// runPing connects to the database with open and reports its round trips, schema version and rows.
+// It prints a hint for the first error and returns it.
+func runPing(w io.Writer, driver string, open func() (*gorm.DB, error), count int) error {
+	fmt.Fprintf(w, "Driver:      %s\n", driver)
+	fail := func(step string, err error) error {
+		fmt.Fprintf(w, "%-12s failed: %v\n", step+":", err)
+		fmt.Fprintf(w, "Hint:        %s\n", pingHint(driver, err))
+		return err
+	}
+	gormDB, err := open()
+	if err != nil {
+		return fail("Connection", err)
+	}
+	defer db.CloseDB(gormDB)
+
+	health, err := db.Ping(gormDB, count)
+	if len(health.RoundTrips) == 0 {
+		return fail("Connection", err)
+	}
+	var total, slowest time.Duration
+	fastest := health.RoundTrips[0]
+	for _, d := range health.RoundTrips {
+		total += d
+		fastest, slowest = min(fastest, d), max(slowest, d)
+	}
+	fmt.Fprintf(w, "Round trip:  %s avg, %s min, %s max over %d queries\n",
+		(total / time.Duration(len(health.RoundTrips))).Round(time.Millisecond),
+		fastest.Round(time.Millisecond), slowest.Round(time.Millisecond), len(health.RoundTrips))
+	if err != nil {
+		return fail("Schema", err)
+	}
+
+	switch {
+	case health.SchemaVersion == 0:
+		fmt.Fprintf(w, "Schema:      not migrated, run syncmate db migrate\n")
+	case health.SchemaVersion < health.LatestVersion:
+		fmt.Fprintf(w, "Schema:      version %d of %d, run syncmate db migrate\n", health.SchemaVersion, health.LatestVersion)
+	default:
+		fmt.Fprintf(w, "Schema:      version %d, up to date\n", health.SchemaVersion)
+	}
+	fmt.Fprintf(w, "Rows:        %d tasks, %d task events\n", health.Rows["tasks"], health.Rows["task_events"])
+	return nil
+}
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Maintain the task database",
+}
+
+var dbPurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Archive old tasks to a JSONL file and delete them from the database",
+	Long: `Write the tasks last updated before --before, optionally only those with --status,
+with their status changes to a JSONL archive and delete them from the database, to keep
+it within the row limits of Cloudflare D1. The rows are deleted permanently.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		configPath, _ := cmd.Flags().GetString("config")
+		before, _ := cmd.Flags().GetString("before")
+		statusName, _ := cmd.Flags().GetString("status")
+		archivePath, _ := cmd.Flags().GetString("archive")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		beforeTime, err := parseDate(before)
+		if err != nil {
+			cmd.PrintErrf("Invalid --before: %v\n", err)
+			os.Exit(1)
+		}
+		filter := db.TaskFilter{UpdatedBefore: beforeTime}
+		if statusName != "" {
+			status, err := db.ParseStatus(statusName)
+			if err != nil {
+				cmd.PrintErrf("Invalid --status: %v\n", err)
+				os.Exit(1)
+			}
+			filter.Status = &status
+		}
+		if archivePath == "" {
+			archivePath = fmt.Sprintf("tasks-purged-%s.jsonl", time.Now().Format("20060102-150405"))
+		}
+
+		if err := loadConfig(configPath); err != nil {
+			cmd.PrintErrf("%v\n", err)
+			os.Exit(1)
+		}
+		dbHandle, err := connectDB()
+		if err != nil {
+			cmd.PrintErrf("Failed to connect to database: %v\n", err)
+			os.Exit(1)
+		}
+		if err := runPurge(cmd.OutOrStdout(), dbHandle, filter, archivePath, dryRun); err != nil {
+			cmd.PrintErrf("%v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var dbExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Write the tasks of the database with their status changes as JSONL",
+	Long: `Write every task of the database with its status changes as one JSON object per line,
+e.g. to back the database up, move it to another driver with db import or inspect it with jq.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		configPath, _ := cmd.Flags().GetString("config")
+		outputPath, _ := cmd.Flags().GetString("output")
+
+		if err := loadConfig(configPath); err != nil {
+			cmd.PrintErrf("%v\n", err)
+			os.Exit(1)
+		}
+		dbHandle, err := connectDB()
+		if err != nil {
+			cmd.PrintErrf("Failed to connect to database: %v\n", err)
+			os.Exit(1)
+		}
+
+		w := cmd.OutOrStdout()
+		if outputPath != "" && outputPath != "-" {
+			f, err := os.Create(outputPath)
+			if err != nil {
+				cmd.PrintErrf("Failed to create output file: %v\n", err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			w = f
+		}
+		count, err := runExport(w, dbHandle)
+		if err != nil {
+			cmd.PrintErrf("%v\n", err)
+			os.Exit(1)
+		}
+		cmd.PrintErrf("Exported %d tasks\n", count)
+	},
+}
+
+var dbImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Add the tasks written by db export to the database",
+	Long: `Add the tasks of a JSONL file written by db export or db purge to the database, keeping
+their status, timestamps and status changes. Tasks that exist already are skipped unless --overwrite
+is given. A file of "-" reads standard input.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		configPath, _ := cmd.Flags().GetString("config")
+		overwrite, _ := cmd.Flags().GetBool("overwrite")
+
+		r := cmd.InOrStdin()
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				cmd.PrintErrf("Failed to open %s: %v\n", args[0], err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			r = f
+		}
+		records, err := readTaskRecords(r)
+		if err != nil {
+			cmd.PrintErrf("Failed to read %s: %v\n", args[0], err)
+			os.Exit(1)
+		}
+
+		if err := loadConfig(configPath); err != nil {
+			cmd.PrintErrf("%v\n", err)
+			os.Exit(1)
+		}
+		dbHandle, err := connectDB()
+		if err != nil {
+			cmd.PrintErrf("Failed to connect to database: %v\n", err)
+			os.Exit(1)
+		}
+		imported, err := dbHandle.ImportTasks(records, overwrite)
+		if err != nil {
+			cmd.PrintErrf("Failed to import tasks after %d of %d: %v\n", imported, len(records), err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Imported %d of %d tasks, %d existed already\n", imported, len(records), len(records)-imported)
+	},
+}
+
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply the pending schema migrations to the database",
+	Long: `Apply the schema migrations the database misses, in order, and record them in the schema_migrations table.
+Other commands migrate the database when they connect, unless "manual_migrations" is set in the database config.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		configPath, _ := cmd.Flags().GetString("config")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		if err := loadConfig(configPath); err != nil {
+			cmd.PrintErrf("%v\n", err)
+			os.Exit(1)
+		}
+		gormDB, err := openDatabase()
+		if err != nil {
+			cmd.PrintErrf("Failed to connect to database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.CloseDB(gormDB)
+		if err := runMigrate(cmd.OutOrStdout(), gormDB, dryRun); err != nil {
+			cmd.PrintErrf("%v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var dbPingCmd = &cobra.Command{
+	Use:   "ping",
+	Short: "Check the connection, schema version and size of the database",
+	Long: `Connect to the database, measure the round trip of a few trivial queries, check the schema version and count
+the rows of the tables, with a hint on what to fix when a step fails. It doesn't migrate the database.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		configPath, _ := cmd.Flags().GetString("config")
+		count, _ := cmd.Flags().GetInt("count")
+
+		if err := loadConfig(configPath); err != nil {
+			cmd.PrintErrf("%v\n", err)
+			os.Exit(1)
+		}
+		if err := runPing(cmd.OutOrStdout(), config.Database.DriverName(), openDatabase, count); err != nil {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	dbPingCmd.Flags().StringP("config", "c", "config.json", "Path to the configuration file")
+	dbPingCmd.Flags().IntP("count", "n", 3, "Number of queries whose round trip is measured")
+	dbCmd.AddCommand(dbPingCmd)
+
+	dbMigrateCmd.Flags().StringP("config", "c", "config.json", "Path to the configuration file")
+	dbMigrateCmd.Flags().Bool("dry-run", false, "Only list the migrations that would be applied")
+	dbCmd.AddCommand(dbMigrateCmd)
+
+	dbExportCmd.Flags().StringP("config", "c", "config.json", "Path to the configuration file")
+	dbExportCmd.Flags().StringP("output", "o", "", "JSONL file to write the tasks to (default: standard output)")
+	addRunIDFlag(dbExportCmd)
+	dbCmd.AddCommand(dbExportCmd)
+
+	dbImportCmd.Flags().StringP("config", "c", "config.json", "Path to the configuration file")
+	dbImportCmd.Flags().Bool("overwrite", false, "Replace the tasks that exist already and their status changes")
+	addRunIDFlag(dbImportCmd)
+	dbCmd.AddCommand(dbImportCmd)
+
+	dbPurgeCmd.Flags().StringP("config", "c", "config.json", "Path to the configuration file")
+	dbPurgeCmd.Flags().String("before", "", "Purge the tasks last updated before this date, e.g. 2024-01-01")
+	dbPurgeCmd.Flags().String("status", "", "Only purge the tasks with this status, e.g. downloaded")
+	dbPurgeCmd.Flags().String("archive", "", "JSONL file the purged tasks are written to, must not exist (default: tasks-purged-<time>.jsonl)")
+	dbPurgeCmd.Flags().Bool("dry-run", false, "Only count the tasks that would be purged")
+	dbPurgeCmd.MarkFlagRequired("before")
+	addRunIDFlag(dbPurgeCmd)
+	dbCmd.AddCommand(dbPurgeCmd)
+	RootCmd.AddCommand(dbCmd)
+}
@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	d1 "github.com/hrz6976/syncmate/d1_gorm_adapter"
+	"github.com/hrz6976/syncmate/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// openTestDB opens a migrated SQLite database at path, closed at the end of the test
+func openTestDB(t *testing.T, path string) *db.DB {
+	gormDB, err := db.ConnectSQLite(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.CloseDB(gormDB) })
+	_, err = db.Migrate(gormDB)
+	require.NoError(t, err)
+	return db.NewDB(gormDB)
+}
+
+func TestRunPurge(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	dbHandle := openTestDB(t, filepath.Join(tmpDir, "tasks.db"))
+
+	for _, task := range []*db.Task{
+		{VirtualPath: "a.bin", SrcPath: "/src/a.bin", SrcSize: 1024, Status: db.Downloaded},
+		{VirtualPath: "b.bin", SrcPath: "/src/b.bin", SrcSize: 1024, Status: db.Uploaded},
+	} {
+		require.NoError(t, dbHandle.UpdateTask(task))
+	}
+	downloaded := db.Downloaded
+	filter := db.TaskFilter{Status: &downloaded, UpdatedBefore: time.Now().Add(time.Minute)}
+	archivePath := filepath.Join(tmpDir, "purged.jsonl")
+
+	var out bytes.Buffer
+	require.NoError(t, runPurge(&out, dbHandle, filter, archivePath, true))
+	assert.Equal(t, "Would purge 1 tasks (1.0 KiB)\n", out.String())
+	assert.NoFileExists(t, archivePath)
+
+	out.Reset()
+	require.NoError(t, runPurge(&out, dbHandle, filter, archivePath, false))
+	assert.Contains(t, out.String(), "Purged 1 tasks (1.0 KiB)")
+	count, err := dbHandle.CountTasks()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	f, err := os.Open(archivePath)
+	require.NoError(t, err)
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	require.True(t, scanner.Scan())
+	var record db.TaskRecord
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+	assert.Equal(t, "a.bin", record.VirtualPath)
+	assert.Len(t, record.Events, 1)
+	assert.False(t, scanner.Scan())
+
+	// earlier archives are kept
+	assert.Error(t, runPurge(&out, dbHandle, db.TaskFilter{UpdatedBefore: time.Now().Add(time.Minute)}, archivePath, false))
+}
+
+func TestParseDate(t *testing.T) {
+	date, err := parseDate("2024-01-01")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local), date)
+	_, err = parseDate("2024-01-01T12:00:00Z")
+	assert.NoError(t, err)
+	_, err = parseDate("last year")
+	assert.Error(t, err)
+}
+
+func TestRunExportImport(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	src, dst := openTestDB(t, filepath.Join(tmpDir, "src.db")), openTestDB(t, filepath.Join(tmpDir, "dst.db"))
+
+	require.NoError(t, src.UpdateTask(&db.Task{VirtualPath: "a.bin", SrcPath: "/src/a.bin", SrcSize: 1024, Status: db.Uploading}))
+	require.NoError(t, src.UpdateTask(&db.Task{VirtualPath: "a.bin", SrcPath: "/src/a.bin", SrcSize: 1024, Status: db.Uploaded}))
+	require.NoError(t, src.UpdateTask(&db.Task{VirtualPath: "b.bin", SrcPath: "/src/b.bin", SrcSize: 2048, Status: db.Pending}))
+
+	var out bytes.Buffer
+	count, err := runExport(&out, src)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	records, err := readTaskRecords(&out)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	imported, err := dst.ImportTasks(records, false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, imported)
+
+	task, events, err := dst.ListTaskEvents("a.bin")
+	require.NoError(t, err)
+	assert.Equal(t, db.Uploaded, task.Status)
+	assert.Equal(t, 1, task.Attempts)
+	assert.Len(t, events, 2)
+
+	_, err = readTaskRecords(bytes.NewBufferString("{\"SrcPath\": \"/src/c.bin\"}\n"))
+	assert.ErrorContains(t, err, "line 1")
+}
+
+func TestRunMigrate(t *testing.T) {
+	gormDB, err := db.ConnectSQLite(filepath.Join(setupTestDir(t), "tasks.db"))
+	require.NoError(t, err)
+	defer db.CloseDB(gormDB)
+
+	var out bytes.Buffer
+	require.NoError(t, runMigrate(&out, gormDB, true))
+	assert.Contains(t, out.String(), "Would apply 6 migrations:\n  1  drop the index")
+	assert.False(t, gormDB.Migrator().HasTable(&db.Task{}))
+
+	out.Reset()
+	require.NoError(t, runMigrate(&out, gormDB, false))
+	assert.Contains(t, out.String(), "Applied migration 2: create the tasks and task_events tables\n")
+	assert.True(t, gormDB.Migrator().HasTable(&db.Task{}))
+
+	out.Reset()
+	require.NoError(t, runMigrate(&out, gormDB, true))
+	assert.Equal(t, "The database schema is up to date\n", out.String())
+}
+
+func TestRunPing(t *testing.T) {
+	path := filepath.Join(setupTestDir(t), "tasks.db")
+	open := func() (*gorm.DB, error) { return db.ConnectSQLite(path) }
+
+	var out bytes.Buffer
+	require.NoError(t, runPing(&out, db.DriverSQLite, open, 2))
+	assert.Contains(t, out.String(), "Driver:      sqlite\n")
+	assert.Contains(t, out.String(), "over 2 queries\n")
+	assert.Contains(t, out.String(), "Schema:      not migrated, run syncmate db migrate\n")
+
+	dbHandle := openTestDB(t, path)
+	require.NoError(t, dbHandle.UpdateTask(&db.Task{VirtualPath: "a.bin", SrcPath: "/src/a.bin", Status: db.Uploaded}))
+	out.Reset()
+	require.NoError(t, runPing(&out, db.DriverSQLite, open, 1))
+	assert.Contains(t, out.String(), "Schema:      version 6, up to date\n")
+	assert.Contains(t, out.String(), "Rows:        1 tasks, 1 task events\n")
+
+	out.Reset()
+	err := runPing(&out, db.DriverD1, func() (*gorm.DB, error) { return nil, fmt.Errorf("failed to connect to database: %w", d1.ErrInvalidDB) }, 1)
+	assert.ErrorIs(t, err, d1.ErrInvalidDB)
+	assert.Contains(t, out.String(), "Connection:  failed: failed to connect to database: invalid database id\n")
+	assert.Contains(t, out.String(), "Hint:        database_id must be the UUID")
+}
+
+func TestPingHint(t *testing.T) {
+	assert.Contains(t, pingHint(db.DriverD1, errors.New("query failed: http status: 403, body: {}")), "D1 Edit permission")
+	assert.Contains(t, pingHint(db.DriverD1, errors.New("dial tcp: lookup api.cloudflare.com: no such host")), "can't be reached")
+	assert.Contains(t, pingHint(db.DriverPostgres, errors.New("connection refused")), "dsn of the postgres database")
+}
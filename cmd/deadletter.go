@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/hrz6976/syncmate/db"
+	"github.com/hrz6976/syncmate/woc"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// maxFileFailures is the number of failed placements after which a downloaded file is quarantined
+	maxFileFailures = 3
+	quarantineDir   string
+)
+
+func addDeadLetterFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&maxFileFailures, "max-file-failures", 3, "Quarantine a downloaded file and mark its task Failed after it failed to be placed this many times (0 never gives up)")
+	cmd.Flags().StringVar(&quarantineDir, "quarantine-dir", "", "Directory for quarantined files, on the filesystem of the cache directory (default: <cache-dir>/.quarantine)")
+}
+
+// quarantinePath returns the quarantine directory
+func quarantinePath() string {
+	if quarantineDir != "" {
+		return quarantineDir
+	}
+	return filepath.Join(cacheDir, ".quarantine")
+}
+
+// deadLetters counts the failed placements of the downloaded files in a run
+type deadLetters struct {
+	mu          sync.Mutex
+	failures    map[string]int
+	quarantined map[string]bool
+}
+
+var fileFailures = newDeadLetters()
+
+func newDeadLetters() *deadLetters {
+	return &deadLetters{
+		failures:    make(map[string]int),
+		quarantined: make(map[string]bool),
+	}
+}
+
+// reset forgets the failures of the previous run
+func (d *deadLetters) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	clear(d.failures)
+	clear(d.quarantined)
+}
+
+// isQuarantined reports whether the file of virtualPath was quarantined in this run
+func (d *deadLetters) isQuarantined(virtualPath string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.quarantined[virtualPath]
+}
+
+// fail records a failed placement of the file at filePath. Once it failed
+// --max-file-failures times, the file is moved into the quarantine directory
+// and its task marked Failed, so it isn't retried on every processing loop.
+func (d *deadLetters) fail(task *woc.WocSyncTask, filePath string, placeErr error) (bool, error) {
+	d.mu.Lock()
+	d.failures[task.VirtualPath]++
+	failures := d.failures[task.VirtualPath]
+	d.mu.Unlock()
+	if maxFileFailures <= 0 || failures < maxFileFailures {
+		return false, nil
+	}
+
+	dst := filepath.Join(quarantinePath(), filepath.FromSlash(task.VirtualPath))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return false, fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	if err := os.Rename(filePath, dst); err != nil {
+		return false, fmt.Errorf("failed to quarantine %s: %w", filePath, err)
+	}
+	d.mu.Lock()
+	d.quarantined[task.VirtualPath] = true
+	d.mu.Unlock()
+	logger.WithFields(logger.Fields{
+		"virtualPath": task.VirtualPath,
+		"failures":    failures,
+		"quarantine":  dst,
+	}).Error("File failed too many times, quarantined")
+
+	if dbHandle == nil {
+		return true, nil
+	}
+	srcDigest, dstDigest := taskDigests(task)
+	if err := dbHandle.UpdateTask(&db.Task{
+		VirtualPath:        task.VirtualPath,
+		Status:             db.Failed,
+		Error:              placeErr.Error(),
+		SrcDigest:          srcDigest,
+		DstDigest:          dstDigest,
+		SrcPath:            task.SourcePath,
+		SrcSize:            task.Size,
+		DstPath:            taskDestPath(task),
+		DstSize:            task.Offset,
+		SrcDigestAlgorithm: task.SourceDigestAlgorithm,
+		DstDigestAlgorithm: task.TargetDigestAlgorithm,
+	}); err != nil {
+		return true, fmt.Errorf("failed to mark task %s failed: %w", task.VirtualPath, err)
+	}
+	return true, nil
+}
+
+// listDeadLetters returns the virtual paths of the tasks marked Failed in the database
+func listDeadLetters() (map[string]bool, error) {
+	failed := make(map[string]bool)
+	if dbHandle == nil {
+		return failed, nil
+	}
+	tasks, err := dbHandle.ListFailedTasks()
+	if err != nil {
+		return nil, err
+	}
+	for _, task := range tasks {
+		if task.Status == db.Failed {
+			failed[task.VirtualPath] = true
+		}
+	}
+	return failed, nil
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hrz6976/syncmate/offsetfs"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetters(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	oldCacheDir, oldDestDir, oldMaxFailures := cacheDir, destDir, maxFileFailures
+	defer func() { cacheDir, destDir, maxFileFailures = oldCacheDir, oldDestDir, oldMaxFailures }()
+	cacheDir = filepath.Join(tmpDir, "cache")
+	destDir = filepath.Join(tmpDir, "dest")
+	maxFileFailures = 2
+
+	task := &woc.WocSyncTask{FileConfig: offsetfs.FileConfig{VirtualPath: "blob_1.bin", Size: 5}}
+	filePath := filepath.Join(cacheDir, "blob_1.bin")
+	require.NoError(t, os.MkdirAll(cacheDir, 0755))
+	require.NoError(t, os.WriteFile(filePath, []byte("12345"), 0644))
+
+	d := newDeadLetters()
+	placeErr := errors.New("source file digest mismatch")
+	quarantined, err := d.fail(task, filePath, placeErr)
+	require.NoError(t, err)
+	assert.False(t, quarantined)
+	assert.FileExists(t, filePath)
+
+	quarantined, err = d.fail(task, filePath, placeErr)
+	require.NoError(t, err)
+	assert.True(t, quarantined)
+	assert.True(t, d.isQuarantined("blob_1.bin"))
+	assert.NoFileExists(t, filePath)
+	assert.FileExists(t, filepath.Join(cacheDir, ".quarantine", "blob_1.bin"))
+
+	// quarantined files are not picked up again
+	files, err := scanDownloadedFiles(map[string]*woc.WocSyncTask{"blob_1.bin": task})
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	d.reset()
+	assert.False(t, d.isQuarantined("blob_1.bin"))
+}
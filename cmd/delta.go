@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/hrz6976/syncmate/delta"
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/rclone/rclone/fs"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Delta transfers of the files that are rewritten in place: recv --delta uploads the block signatures of
+// the old destination file, send --delta uploads the delta of the new file against them instead of the
+// file, and recv rebuilds the file from the delta and the old destination file into the cache directory,
+// where it is verified and placed like a downloaded file.
+
+var (
+	deltaTransfers bool
+	deltaBlockSize = fs.SizeSuffix(delta.DefaultBlockSize)
+)
+
+func addDeltaFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&deltaTransfers, "delta", false, "Upload only the changed blocks of the files whose destination published its block signatures with recv --delta")
+}
+
+func addDeltaFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&deltaTransfers, "delta", false, "Publish the block signatures of the destination files that are rewritten in full, for send --delta")
+	cmd.Flags().Var(&deltaBlockSize, "delta-block-size", "Block size of the signatures of --delta")
+}
+
+// signFile returns the block signatures of the file at path
+func signFile(path string, blockSize int) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	sig, err := delta.ComputeSignature(bufio.NewReaderSize(file, 1<<20), blockSize)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err := sig.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// publishSignatures uploads the block signatures of the destination files that the tasks overwrite,
+// for send --delta, and returns how many it uploaded. skip tells the tasks to leave out, e.g. those
+// whose file is in the bucket already.
+func publishSignatures(ctx context.Context, fsrc fs.Fs, tasks []*woc.WocSyncTask, skip func(virtualPath string) bool) int {
+	published := 0
+	for _, task := range tasks {
+		if task.Offset != 0 || skip(task.VirtualPath) {
+			continue
+		}
+		destPath := taskDestPath(task)
+		if stat, err := os.Stat(destPath); err != nil || !stat.Mode().IsRegular() || stat.Size() == 0 {
+			continue
+		}
+		log := logger.WithFields(logger.Fields{"virtualPath": task.VirtualPath, "destPath": destPath})
+		data, err := signFile(destPath, int(deltaBlockSize))
+		if err != nil {
+			log.WithError(err).Warn("Failed to compute the block signatures of the destination file")
+			continue
+		}
+		if err := rclone.PutFile(ctx, fsrc, woc.SignaturePath(task.VirtualPath), data); err != nil {
+			log.WithError(err).Warn("Failed to upload the block signatures of the destination file")
+			continue
+		}
+		log.Debug("Published the block signatures of the destination file")
+		published++
+	}
+	if published > 0 {
+		logger.WithField("count", published).Info("Published the block signatures of the destination files for send --delta")
+	}
+	return published
+}
+
+// rebuildFromDelta rebuilds the file of task into the cache directory from its delta there and its
+// destination file. The delta is removed.
+func rebuildFromDelta(task *woc.WocSyncTask) error {
+	deltaPath := filepath.Join(cacheDir, woc.DeltaPath(task.VirtualPath))
+	defer os.Remove(deltaPath)
+	in, err := os.Open(deltaPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	old, err := os.Open(taskDestPath(task))
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+	stat, err := old.Stat()
+	if err != nil {
+		return err
+	}
+
+	// .partial files aren't placed
+	cachePath := filepath.Join(cacheDir, task.VirtualPath)
+	partialPath := cachePath + ".partial"
+	out, err := os.Create(partialPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriterSize(out, 1<<20)
+	n, err := delta.Apply(old, stat.Size(), in, w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n != task.Size {
+		err = fmt.Errorf("rebuilt %d bytes instead of %d", n, task.Size)
+	}
+	if err != nil {
+		os.Remove(partialPath)
+		return err
+	}
+	return os.Rename(partialPath, cachePath)
+}
+
+// rebuildDeltas downloads the deltas of the tasks into the cache directory and rebuilds their files,
+// which processDoneFiles then verifies and places like downloaded files
+func rebuildDeltas(ctx context.Context, fsrc, fcache fs.Fs, tasks []*woc.WocSyncTask) {
+	if len(tasks) == 0 {
+		return
+	}
+	names := make([]string, len(tasks))
+	for i, task := range tasks {
+		names[i] = woc.DeltaPath(task.VirtualPath)
+	}
+	logger.WithField("count", len(tasks)).Info("Downloading the deltas of the rewritten files...")
+	results, err := rclone.CopyFiles(ctx, fsrc, fcache, names)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to download some deltas")
+	}
+	rebuilt := 0
+	for _, task := range tasks {
+		if result, ok := results[woc.DeltaPath(task.VirtualPath)]; !ok || !result.Succeeded() {
+			continue
+		}
+		if err := rebuildFromDelta(task); err != nil {
+			logger.WithError(err).WithField("virtualPath", task.VirtualPath).Warn("Failed to rebuild the file from its delta")
+			continue
+		}
+		rebuilt++
+	}
+	logger.WithField("count", rebuilt).Info("Rebuilt the rewritten files from their deltas")
+}
+
+// writeDelta writes the delta of the file of task against the signatures data of its destination to path
+func writeDelta(task *woc.WocSyncTask, data []byte, path string) (delta.Stats, error) {
+	sig, err := delta.ReadSignature(bytes.NewReader(data))
+	if err != nil {
+		return delta.Stats{}, err
+	}
+	src, err := os.Open(task.SourcePath)
+	if err != nil {
+		return delta.Stats{}, err
+	}
+	defer src.Close()
+	out, err := os.Create(path)
+	if err != nil {
+		return delta.Stats{}, err
+	}
+	stats, err := delta.Write(sig, io.LimitReader(src, task.Size), out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && stats.Copied+stats.Literal != task.Size {
+		err = fmt.Errorf("source file has %d bytes instead of %d", stats.Copied+stats.Literal, task.Size)
+	}
+	return stats, err
+}
+
+// uploadDeltas uploads the deltas of the files of fileList whose destination published its block signatures
+// to fdst instead of the files, and returns their results by virtual path. Deltas that aren't smaller than
+// their file are left out, the file is uploaded as usual then.
+func uploadDeltas(ctx context.Context, fdst fs.Fs, fileList []string, tasksMap map[string]*woc.WocSyncTask) (map[string]rclone.TransferResult, error) {
+	// the listings of ctx only have the files of fileList
+	var sigNames []string
+	for _, virtualPath := range fileList {
+		if tasksMap[virtualPath].Offset == 0 {
+			sigNames = append(sigNames, woc.SignaturePath(virtualPath))
+		}
+	}
+	signatures, err := rclone.StatFiles(ctx, fdst, sigNames)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the block signatures: %w", err)
+	}
+	if len(signatures) == 0 {
+		return nil, nil
+	}
+	staging, err := os.MkdirTemp("", "syncmate-delta-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	var names []string
+	for _, virtualPath := range fileList {
+		task := tasksMap[virtualPath]
+		if _, ok := signatures[woc.SignaturePath(virtualPath)]; !ok || task.Offset != 0 {
+			continue
+		}
+		log := logger.WithField("virtualPath", virtualPath)
+		data, err := rclone.ReadFile(ctx, fdst, woc.SignaturePath(virtualPath))
+		if err != nil {
+			log.WithError(err).Warn("Failed to read the block signatures of the destination, uploading the file")
+			continue
+		}
+		path := filepath.Join(staging, woc.DeltaPath(virtualPath))
+		stats, err := writeDelta(task, data, path)
+		if err != nil {
+			log.WithError(err).Warn("Failed to compute the delta, uploading the file")
+			os.Remove(path)
+			continue
+		}
+		if stat, err := os.Stat(path); err != nil || stat.Size() >= task.Size {
+			log.Info("The delta isn't smaller than the file, uploading the file")
+			os.Remove(path)
+			continue
+		}
+		log.WithFields(logger.Fields{
+			"copied":  fs.SizeSuffix(stats.Copied).ByteUnit(),
+			"literal": fs.SizeSuffix(stats.Literal).ByteUnit(),
+		}).Debug("Computed the delta of the file")
+		names = append(names, woc.DeltaPath(virtualPath))
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	fstaging, err := fs.NewFs(ctx, staging)
+	if err != nil {
+		return nil, err
+	}
+	logger.WithField("count", len(names)).Info("Uploading the deltas of the rewritten files to R2...")
+	transfers, err := rclone.CopyFiles(ctx, fstaging, fdst, names)
+	results := make(map[string]rclone.TransferResult, len(transfers))
+	for name, result := range transfers {
+		results[strings.TrimSuffix(name, woc.DeltaSuffix)] = result
+	}
+	return results, err
+}
+
+// withoutUploaded returns the files of batch that have no successful result
+func withoutUploaded(batch []string, results map[string]rclone.TransferResult) []string {
+	return slices.DeleteFunc(slices.Clone(batch), func(virtualPath string) bool {
+		result, ok := results[virtualPath]
+		return ok && result.Succeeded()
+	})
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hrz6976/syncmate/offsetfs"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeltaTransfer(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	oldCacheDir, oldBlockSize := cacheDir, deltaBlockSize
+	defer func() { cacheDir, deltaBlockSize = oldCacheDir, oldBlockSize }()
+	cacheDir, deltaBlockSize = filepath.Join(tmpDir, "cache"), 4096
+	for _, dir := range []string{"cache", "bucket", "src", "dst"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(tmpDir, dir), 0755))
+	}
+
+	// the destination has the old file, the source rewrote some blocks of it
+	r := rand.New(rand.NewSource(1))
+	old := make([]byte, 64*1024)
+	r.Read(old)
+	changed := bytes.Clone(old)
+	copy(changed[20000:], []byte("rewritten in the middle"))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "dst", "blob_0.bin"), old, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "src", "blob_0.bin"), changed, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "dst", "blob_1.bin"), old, 0644))
+	task := &woc.WocSyncTask{
+		FileConfig: offsetfs.FileConfig{VirtualPath: "blob_0.bin", SourcePath: filepath.Join(tmpDir, "src", "blob_0.bin"), Size: int64(len(changed))},
+		TargetPath: filepath.Join(tmpDir, "dst", "blob_0.bin"),
+	}
+	appended := &woc.WocSyncTask{
+		FileConfig: offsetfs.FileConfig{VirtualPath: "blob_1.bin", Offset: int64(len(old)), Size: 10},
+		TargetPath: filepath.Join(tmpDir, "dst", "blob_1.bin"),
+	}
+	missing := &woc.WocSyncTask{
+		FileConfig: offsetfs.FileConfig{VirtualPath: "blob_2.bin", Size: 10},
+		TargetPath: filepath.Join(tmpDir, "dst", "blob_2.bin"),
+	}
+	tasksMap := map[string]*woc.WocSyncTask{"blob_0.bin": task, "blob_1.bin": appended, "blob_2.bin": missing}
+
+	ctx := context.Background()
+	fbucket, err := fs.NewFs(ctx, filepath.Join(tmpDir, "bucket"))
+	require.NoError(t, err)
+	fcache, err := fs.NewFs(ctx, cacheDir)
+	require.NoError(t, err)
+
+	// only the files overwritten in place that the destination has are signed
+	published := publishSignatures(ctx, fbucket, sortedTasks(tasksMap), func(string) bool { return false })
+	assert.Equal(t, 1, published)
+	assert.FileExists(t, filepath.Join(tmpDir, "bucket", woc.SignaturePath("blob_0.bin")))
+	assert.Zero(t, publishSignatures(ctx, fbucket, sortedTasks(tasksMap), func(string) bool { return true }))
+
+	results, err := uploadDeltas(ctx, fbucket, []string{"blob_0.bin", "blob_1.bin", "blob_2.bin"}, tasksMap)
+	require.NoError(t, err)
+	require.Contains(t, results, "blob_0.bin")
+	assert.True(t, results["blob_0.bin"].Succeeded())
+	assert.Len(t, results, 1)
+	stat, err := os.Stat(filepath.Join(tmpDir, "bucket", woc.DeltaPath("blob_0.bin")))
+	require.NoError(t, err)
+	assert.Less(t, stat.Size(), int64(3*4096))
+	assert.Equal(t, []string{"blob_1.bin", "blob_2.bin"}, withoutUploaded([]string{"blob_0.bin", "blob_1.bin", "blob_2.bin"}, results))
+
+	rebuildDeltas(ctx, fbucket, fcache, []*woc.WocSyncTask{task})
+	rebuilt, err := os.ReadFile(filepath.Join(cacheDir, "blob_0.bin"))
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(changed, rebuilt), "rebuilt file differs")
+	assert.NoFileExists(t, filepath.Join(cacheDir, woc.DeltaPath("blob_0.bin")))
+	assert.NoFileExists(t, filepath.Join(cacheDir, "blob_0.bin.partial"))
+}
+
+func TestRebuildFromDelta_SizeMismatch(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	oldCacheDir := cacheDir
+	defer func() { cacheDir = oldCacheDir }()
+	cacheDir = tmpDir
+
+	createTestFile(t, filepath.Join(tmpDir, "dst", "blob_0.bin"), "the old file")
+	createTestFile(t, filepath.Join(tmpDir, "src", "blob_0.bin"), "the new file")
+	sig, err := signFile(filepath.Join(tmpDir, "dst", "blob_0.bin"), 4)
+	require.NoError(t, err)
+	task := &woc.WocSyncTask{
+		FileConfig: offsetfs.FileConfig{VirtualPath: "blob_0.bin", SourcePath: filepath.Join(tmpDir, "src", "blob_0.bin"), Size: 12},
+		TargetPath: filepath.Join(tmpDir, "dst", "blob_0.bin"),
+	}
+	_, err = writeDelta(task, sig, filepath.Join(tmpDir, woc.DeltaPath("blob_0.bin")))
+	require.NoError(t, err)
+
+	// the task expects more bytes than the delta has
+	task.Size = 20
+	assert.ErrorContains(t, rebuildFromDelta(task), "rebuilt 12 bytes instead of 20")
+	assert.NoFileExists(t, filepath.Join(tmpDir, "blob_0.bin"))
+	assert.NoFileExists(t, filepath.Join(tmpDir, "blob_0.bin.partial"))
+	assert.NoFileExists(t, filepath.Join(tmpDir, woc.DeltaPath("blob_0.bin")))
+
+	_, err = writeDelta(task, []byte("not a signature"), filepath.Join(tmpDir, woc.DeltaPath("blob_0.bin")))
+	assert.Error(t, err)
+}
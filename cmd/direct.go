@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/rclone/rclone/fs"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// In direct mode send doesn't upload the files but keeps them mounted, and recv downloads them from the
+// mount over SFTP, skipping the bucket. The tasks, the sidecar-less checks and the verification are those
+// of a transfer through the bucket.
+
+// sendMountpoint is where send mounts the OffsetFS of its tasks
+const sendMountpoint = "/tmp/syncmate_offsetfs"
+
+// directPollInterval is how often send --direct asks the database which files recv downloaded
+const directPollInterval = 30 * time.Second
+
+var (
+	// directServe is send --direct
+	directServe bool
+	// directHost is recv --direct, the host of send --direct
+	directHost    string
+	directDir     string
+	directKeyFile string
+)
+
+func addDirectServeFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&directServe, "direct", false, "Don't upload the files, serve them from the OffsetFS mount at "+sendMountpoint+" to recv --direct until it downloaded them all")
+}
+
+func addDirectFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&directHost, "direct", "", "Download the files over SFTP from the mount of send --direct on this host, [user@]host[:port], instead of the bucket")
+	cmd.Flags().StringVar(&directDir, "direct-dir", sendMountpoint, "Mountpoint of send --direct on the --direct host")
+	cmd.Flags().StringVar(&directKeyFile, "direct-key", "", "SSH private key of the --direct host (default: the ssh-agent)")
+}
+
+// directRemoteConfig returns the SFTP remote of the mount dir on host, [user@]host[:port]
+func directRemoteConfig(host, dir, keyFile string) (*rclone.RemoteConfig, error) {
+	target := host
+	options := make(map[string]string)
+	if user, rest, ok := strings.Cut(host, "@"); ok {
+		options["user"], host = user, rest
+	}
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		options["port"], host = port, h
+	}
+	if host == "" {
+		return nil, fmt.Errorf("--direct needs a host, got %q", target)
+	}
+	options["host"] = host
+	if keyFile != "" {
+		options["key_file"] = keyFile
+	}
+	// md5sum would read every virtual file once more, the digests of the tasks check them instead
+	options["disable_hashcheck"] = "true"
+	return &rclone.RemoteConfig{Type: rclone.RemoteSFTP, Root: dir, Options: options}, nil
+}
+
+// newRecvRemote connects to the storage recv downloads from, the mount of send --direct with --direct
+func newRecvRemote(ctx context.Context) (fs.Fs, error) {
+	if directHost == "" {
+		return newRemote(ctx)
+	}
+	cfg, err := directRemoteConfig(directHost, directDir, directKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	// the files are at the root of the mount, --remote-prefix and --run-id don't apply
+	return rclone.NewRemote(ctx, cfg, &rclone.CloudflareR2Credentials{})
+}
+
+// countUnfinished returns how many of the files aren't among the finished ones
+func countUnfinished(files, finished []string) int {
+	done := make(map[string]bool, len(finished))
+	for _, virtualPath := range finished {
+		done[virtualPath] = true
+	}
+	n := 0
+	for _, virtualPath := range files {
+		if !done[virtualPath] {
+			n++
+		}
+	}
+	return n
+}
+
+// serveDirect keeps the files of fileList mounted for recv --direct until the database has them all
+// Downloaded. Without the database it serves them until the first interrupt.
+func serveDirect(ctx context.Context, softStop *rclone.SoftStop, fileList []string) error {
+	logger.WithFields(logger.Fields{
+		"mountpoint": sendMountpoint,
+		"count":      len(fileList),
+	}).Info("Serving the files to recv --direct, interrupt to stop")
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	polled := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return errSendCancelled
+		case <-ticker.C:
+		}
+		if softStop.Stopped() {
+			return errStopped
+		}
+		if dbHandle == nil || time.Since(polled) < directPollInterval {
+			continue
+		}
+		polled = time.Now()
+		finished, err := dbHandle.ListFinishedVirtualPaths()
+		if err != nil {
+			logger.WithError(err).Warn("Failed to list the downloaded files")
+			continue
+		}
+		left := countUnfinished(fileList, finished)
+		if left == 0 {
+			logger.Info("All files were downloaded by recv --direct")
+			return nil
+		}
+		logger.WithField("remaining", left).Debug("Waiting for recv --direct")
+	}
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirectRemoteConfig(t *testing.T) {
+	cfg, err := directRemoteConfig("syncmate@da5.eecs.utk.edu:2222", sendMountpoint, "~/.ssh/id_ed25519")
+	require.NoError(t, err)
+	assert.Equal(t, rclone.RemoteSFTP, cfg.Type)
+	assert.Equal(t, sendMountpoint, cfg.Root)
+	assert.Equal(t, map[string]string{
+		"host":              "da5.eecs.utk.edu",
+		"user":              "syncmate",
+		"port":              "2222",
+		"key_file":          "~/.ssh/id_ed25519",
+		"disable_hashcheck": "true",
+	}, cfg.Options)
+	require.NoError(t, cfg.Validate())
+
+	cfg, err = directRemoteConfig("da5", "/mnt/offsetfs", "")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"host": "da5", "disable_hashcheck": "true"}, cfg.Options)
+
+	_, err = directRemoteConfig("syncmate@", sendMountpoint, "")
+	assert.ErrorContains(t, err, "--direct needs a host")
+}
+
+func TestCountUnfinished(t *testing.T) {
+	files := []string{"a.bin", "b.bin", "c.bin"}
+	assert.Equal(t, 3, countUnfinished(files, nil))
+	assert.Equal(t, 1, countUnfinished(files, []string{"a.bin", "c.bin", "other.bin"}))
+	assert.Equal(t, 0, countUnfinished(files, []string{"a.bin", "b.bin", "c.bin"}))
+}
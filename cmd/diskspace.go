@@ -0,0 +1,357 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/rclone/rclone/fs"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// minFreeSpace is kept free on the cache and destination filesystems
+	minFreeSpace   = fs.SizeSuffix(10 * fs.Gibi)
+	skipSpaceCheck bool
+	// spacePollInterval is how often free space is checked while downloading
+	spacePollInterval = 10 * time.Second
+	// maxCacheSize pauses downloads while the cache directory holds more, until it is back at cacheResumeSize
+	maxCacheSize    fs.SizeSuffix
+	cacheResumeSize fs.SizeSuffix
+	// cacheStallPolls is how many checks the cache may stay full without shrinking before downloads
+	// resume anyway, e.g. when its files belong to no task of this run or keep failing to be placed
+	cacheStallPolls = 30
+)
+
+// placedFiles are the virtual paths placed by this process, not to be downloaded again after a pause
+var placedFiles sync.Map
+
+func addDiskSpaceFlags(cmd *cobra.Command) {
+	cmd.Flags().Var(&minFreeSpace, "min-free-space", "Free space to keep on the cache and destination filesystems, downloads pause below it (0 to disable)")
+	cmd.Flags().BoolVar(&skipSpaceCheck, "skip-space-check", false, "Start even if the files to download don't fit into the free space")
+	cmd.Flags().Var(&maxCacheSize, "max-cache-size", "Pause downloads while the cache directory holds more than this, until placement catches up (0 to disable)")
+	cmd.Flags().Var(&cacheResumeSize, "cache-resume-size", "Resume downloads once the cache directory holds at most this (default: 80% of --max-cache-size)")
+}
+
+// fsSpace is the space needed on one filesystem
+type fsSpace struct {
+	// a directory on the filesystem
+	dir  string
+	free int64
+	need int64
+}
+
+// statFs returns the device and free bytes of the filesystem holding path, or its nearest existing parent
+func statFs(path string) (uint64, int64, error) {
+	for {
+		var st syscall.Stat_t
+		err := syscall.Stat(path, &st)
+		if err == nil {
+			var sfs syscall.Statfs_t
+			if err := syscall.Statfs(path, &sfs); err != nil {
+				return 0, 0, err
+			}
+			return uint64(st.Dev), int64(sfs.Bavail) * int64(sfs.Bsize), nil
+		}
+		parent := filepath.Dir(path)
+		if !os.IsNotExist(err) || parent == path {
+			return 0, 0, err
+		}
+		path = parent
+	}
+}
+
+// planDiskSpace adds up the bytes the tasks write per filesystem: the download
+// into the cache directory, and the growth of the destination file.
+func planDiskSpace(tasks []*woc.WocSyncTask) ([]*fsSpace, error) {
+	spaces := make(map[uint64]*fsSpace)
+	add := func(path string, bytes int64) error {
+		dev, free, err := statFs(path)
+		if err != nil {
+			return fmt.Errorf("failed to get free space of %s: %w", path, err)
+		}
+		s, ok := spaces[dev]
+		if !ok {
+			s = &fsSpace{dir: filepath.Dir(path), free: free}
+			spaces[dev] = s
+		}
+		s.need += bytes
+		return nil
+	}
+
+	for _, task := range tasks {
+		cachePath := filepath.Join(cacheDir, filepath.FromSlash(task.VirtualPath))
+		var cached int64
+		if stat, err := os.Stat(cachePath); err == nil {
+			cached = min(stat.Size(), task.Size)
+		}
+		if err := add(cachePath, task.Size-cached); err != nil {
+			return nil, err
+		}
+
+		destPath := taskDestPath(task)
+		grow := task.Size
+		if stat, err := os.Stat(destPath); err == nil && task.Offset == 0 {
+			// overwritten in place
+			grow = max(task.Size-stat.Size(), 0)
+		}
+		if err := add(destPath, grow); err != nil {
+			return nil, err
+		}
+	}
+
+	result := make([]*fsSpace, 0, len(spaces))
+	for _, s := range spaces {
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].dir < result[j].dir
+	})
+	return result, nil
+}
+
+// checkDiskSpace fails if the tasks don't fit into the free space minus --min-free-space
+func checkDiskSpace(spaces []*fsSpace) error {
+	var short []string
+	for _, s := range spaces {
+		logger.WithFields(logger.Fields{
+			"dir":  s.dir,
+			"need": fs.SizeSuffix(s.need).ByteUnit(),
+			"free": fs.SizeSuffix(s.free).ByteUnit(),
+		}).Info("Disk space needed by downloads")
+		if s.free-s.need < int64(minFreeSpace) {
+			short = append(short, fmt.Sprintf("%s needs %s but has %s free",
+				s.dir, fs.SizeSuffix(s.need).ByteUnit(), fs.SizeSuffix(s.free).ByteUnit()))
+		}
+	}
+	if len(short) > 0 {
+		return fmt.Errorf("not enough disk space (keeping %s free): %s; use --max-bytes or --skip-space-check",
+			minFreeSpace.ByteUnit(), strings.Join(short, "; "))
+	}
+	return nil
+}
+
+// lowSpaceDir returns the first directory whose filesystem has less than --min-free-space free
+func lowSpaceDir(dirs []string) (string, bool) {
+	if minFreeSpace <= 0 {
+		return "", false
+	}
+	for _, dir := range dirs {
+		if _, free, err := statFs(dir); err == nil && free < int64(minFreeSpace) {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+// partialVirtualPath returns the virtual path of a partial download, which rclone names <path>.<hash>.partial
+func partialVirtualPath(name string) string {
+	name = strings.TrimSuffix(name, ".partial")
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// downloadingFiles returns the virtual paths rclone is transferring right now
+func downloadingFiles() map[string]bool {
+	downloading := make(map[string]bool)
+	for _, tr := range rclone.InFlightTransfers() {
+		downloading[tr.Name] = true
+	}
+	return downloading
+}
+
+// cacheSize is the size of the files in the cache directory, quarantined files excluded. Of the partial
+// downloads, only those of the files in downloading count: the ones a crashed run left behind are never
+// placed, they would keep the cache above --max-cache-size until cache gc removes them.
+func cacheSize(downloading map[string]bool) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(cacheDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				// placed or deleted while walking
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if path == quarantinePath() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(d.Name(), ".partial") {
+			relPath, err := filepath.Rel(cacheDir, path)
+			if err != nil || !downloading[partialVirtualPath(filepath.ToSlash(relPath))] {
+				return nil
+			}
+		}
+		if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// resumeCacheSize is the low watermark of --max-cache-size
+func resumeCacheSize() int64 {
+	if cacheResumeSize > 0 && cacheResumeSize < maxCacheSize {
+		return int64(cacheResumeSize)
+	}
+	return int64(maxCacheSize) / 10 * 8
+}
+
+// cacheOver reports the size of the cache directory and whether it is above limit.
+// Without --max-cache-size, or if the cache can't be walked, it is never over.
+func cacheOver(limit int64) (int64, bool) {
+	if maxCacheSize <= 0 || cacheDir == "" {
+		return 0, false
+	}
+	size, err := cacheSize(downloadingFiles())
+	if err != nil {
+		logger.WithError(err).WithField("cacheDir", cacheDir).Warn("Failed to measure the cache directory")
+		return size, false
+	}
+	return size, size > limit
+}
+
+// waitForCache blocks while the cache directory is above the low watermark of --max-cache-size.
+// paused is notified on every check, so the downloaded files keep being placed. After cacheStallPolls
+// checks without the cache shrinking it gives up with a warning and reports that it stalled.
+func waitForCache(ctx context.Context, paused chan<- struct{}) (bool, error) {
+	smallest, stalled := int64(-1), 0
+	for {
+		size, over := cacheOver(resumeCacheSize())
+		if !over {
+			return false, nil
+		}
+		if smallest < 0 || size < smallest {
+			smallest, stalled = size, 0
+		} else if stalled++; stalled >= cacheStallPolls {
+			logger.WithFields(logger.Fields{
+				"cacheSize":  fs.SizeSuffix(size).ByteUnit(),
+				"resumeSize": fs.SizeSuffix(resumeCacheSize()).ByteUnit(),
+				"checks":     stalled,
+			}).Warn("Cache directory stopped shrinking, ignoring --max-cache-size for the rest of the batch")
+			return true, nil
+		}
+		logger.WithFields(logger.Fields{
+			"cacheSize":  fs.SizeSuffix(size).ByteUnit(),
+			"resumeSize": fs.SizeSuffix(resumeCacheSize()).ByteUnit(),
+		}).Warn("Cache directory is full, downloads paused until files are placed")
+		select {
+		case paused <- struct{}{}:
+		default:
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(spacePollInterval):
+		}
+	}
+}
+
+// waitForSpace blocks while a watched filesystem is below --min-free-space
+func waitForSpace(ctx context.Context, dirs []string) error {
+	for {
+		dir, low := lowSpaceDir(dirs)
+		if !low {
+			return nil
+		}
+		logger.WithFields(logger.Fields{
+			"dir":          dir,
+			"minFreeSpace": minFreeSpace.ByteUnit(),
+		}).Warn("Low disk space, downloads paused until files are placed or space is freed")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(spacePollInterval):
+		}
+	}
+}
+
+// copyBatch downloads a batch, pausing while a watched filesystem is low on space or the
+// cache directory is above --max-cache-size: the copy finishes its in-flight files and resumes
+// with the files not placed yet once there is space again. paused is notified when a pause starts.
+func copyBatch(ctx context.Context, fsrc, fdst fs.Fs, batch []string, dirs []string, userStop *rclone.SoftStop, paused chan<- struct{}) error {
+	// set once the cache stopped shrinking, the files in it aren't going to be placed
+	cacheStalled := false
+	for {
+		if !cacheStalled {
+			stalled, err := waitForCache(ctx, paused)
+			if err != nil {
+				return err
+			}
+			cacheStalled = stalled
+		}
+		if err := waitForSpace(ctx, dirs); err != nil {
+			return err
+		}
+		var remaining []string
+		for _, virtualPath := range batch {
+			if _, ok := placedFiles.Load(virtualPath); !ok && !fileFailures.isQuarantined(virtualPath) {
+				remaining = append(remaining, virtualPath)
+			}
+		}
+		if len(remaining) == 0 {
+			return nil
+		}
+
+		copyCtx, pause := rclone.InjectSoftStop(ctx)
+		if pause.Stopped() {
+			// stopped by the user
+			return nil
+		}
+		done := make(chan struct{})
+		checkCache := !cacheStalled
+		go func() {
+			ticker := time.NewTicker(spacePollInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					dir, low := lowSpaceDir(dirs)
+					var size int64
+					full := false
+					if checkCache {
+						size, full = cacheOver(int64(maxCacheSize))
+					}
+					if low || full {
+						if low {
+							logger.WithField("dir", dir).Warn("Low disk space, pausing downloads after in-flight files")
+						} else {
+							logger.WithField("cacheSize", fs.SizeSuffix(size).ByteUnit()).Warn("Cache directory is full, pausing downloads after in-flight files")
+						}
+						pause.Stop()
+						select {
+						case paused <- struct{}{}:
+						default:
+						}
+						return
+					}
+				}
+			}
+		}()
+		results, err := rclone.CopyFiles(copyCtx, fsrc, fdst, remaining)
+		close(done)
+		traceTransfers(ctx, "download", results)
+
+		if !pause.Stopped() || userStop.Stopped() || !(err == nil || rclone.IsSoftStopped(err)) {
+			return err
+		}
+		batch = remaining
+	}
+}
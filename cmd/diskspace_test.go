@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hrz6976/syncmate/offsetfs"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatFs(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	dev, free, err := statFs(tmpDir)
+	require.NoError(t, err)
+	assert.Positive(t, free)
+
+	// missing paths are resolved to their nearest existing parent
+	missingDev, _, err := statFs(filepath.Join(tmpDir, "a", "b", "c.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, dev, missingDev)
+}
+
+func TestPlanDiskSpace(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	oldCacheDir, oldDestDir := cacheDir, destDir
+	defer func() { cacheDir, destDir = oldCacheDir, oldDestDir }()
+	cacheDir = filepath.Join(tmpDir, "cache")
+	destDir = filepath.Join(tmpDir, "dest")
+	require.NoError(t, os.MkdirAll(cacheDir, 0755))
+
+	// already downloaded, needs no cache space
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, "cached.bin"), make([]byte, 100), 0644))
+	// overwritten, only the growth counts on the destination
+	overwritten := filepath.Join(tmpDir, "overwritten.bin")
+	require.NoError(t, os.WriteFile(overwritten, make([]byte, 30), 0644))
+
+	tasks := []*woc.WocSyncTask{
+		{FileConfig: offsetfs.FileConfig{VirtualPath: "cached.bin", Size: 100}, TargetPath: filepath.Join(tmpDir, "cached.bin")},
+		{FileConfig: offsetfs.FileConfig{VirtualPath: "overwritten.bin", Size: 50}, TargetPath: overwritten},
+		{FileConfig: offsetfs.FileConfig{VirtualPath: "appended.bin.offset.10", Offset: 10, Size: 20}, TargetPath: filepath.Join(tmpDir, "appended.bin")},
+	}
+	spaces, err := planDiskSpace(tasks)
+	require.NoError(t, err)
+	require.Len(t, spaces, 1)
+	// cache: 0 + 50 + 20, destination: 100 + 20 + 20
+	assert.Equal(t, int64(210), spaces[0].need)
+
+	oldMinFreeSpace := minFreeSpace
+	defer func() { minFreeSpace = oldMinFreeSpace }()
+	minFreeSpace = 0
+	assert.NoError(t, checkDiskSpace(spaces))
+	minFreeSpace = fs.SizeSuffix(spaces[0].free)
+	assert.ErrorContains(t, checkDiskSpace(spaces), "not enough disk space")
+
+	// other tests may have written to the disk since spaces was planned
+	minFreeSpace = fs.SizeSuffix(spaces[0].free / 2)
+	_, low := lowSpaceDir([]string{tmpDir})
+	assert.False(t, low)
+	minFreeSpace = fs.SizeSuffix(1 << 62)
+	_, low = lowSpaceDir([]string{tmpDir})
+	assert.True(t, low)
+}
+
+func TestCacheOver(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	oldCacheDir, oldQuarantineDir := cacheDir, quarantineDir
+	oldMaxCacheSize, oldCacheResumeSize := maxCacheSize, cacheResumeSize
+	defer func() {
+		cacheDir, quarantineDir = oldCacheDir, oldQuarantineDir
+		maxCacheSize, cacheResumeSize = oldMaxCacheSize, oldCacheResumeSize
+	}()
+	cacheDir = filepath.Join(tmpDir, "cache")
+	quarantineDir = ""
+	require.NoError(t, os.MkdirAll(filepath.Join(cacheDir, "sub"), 0755))
+	require.NoError(t, os.MkdirAll(quarantinePath(), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, "done.bin"), make([]byte, 60), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, "sub", "pending.bin.1a2b3c4d.partial"), make([]byte, 40), 0644))
+	// left behind by a crashed run, it is never placed
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, "stale.bin.5e6f7a8b.partial"), make([]byte, 500), 0644))
+	// quarantined files are not downloads waiting for placement
+	require.NoError(t, os.WriteFile(filepath.Join(quarantinePath(), "bad.bin"), make([]byte, 1000), 0644))
+
+	size, err := cacheSize(map[string]bool{"sub/pending.bin": true})
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), size)
+	size, err = cacheSize(nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(60), size)
+
+	maxCacheSize = 0
+	_, over := cacheOver(50)
+	assert.False(t, over, "disabled without --max-cache-size")
+
+	// nothing is downloading, the partial downloads don't keep the downloads paused
+	maxCacheSize = 70
+	assert.Equal(t, int64(56), resumeCacheSize())
+	size, over = cacheOver(int64(maxCacheSize))
+	assert.False(t, over)
+	assert.Equal(t, int64(60), size)
+	_, over = cacheOver(resumeCacheSize())
+	assert.True(t, over)
+	cacheResumeSize = 60
+	_, over = cacheOver(resumeCacheSize())
+	assert.False(t, over)
+	// a resume size above the limit falls back to the default
+	cacheResumeSize = 200
+	assert.Equal(t, int64(56), resumeCacheSize())
+}
+
+func TestWaitForCache_Stalled(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	oldCacheDir, oldMaxCacheSize := cacheDir, maxCacheSize
+	oldInterval, oldStallPolls := spacePollInterval, cacheStallPolls
+	defer func() {
+		cacheDir, maxCacheSize = oldCacheDir, oldMaxCacheSize
+		spacePollInterval, cacheStallPolls = oldInterval, oldStallPolls
+	}()
+	cacheDir = tmpDir
+	maxCacheSize = 50
+	spacePollInterval, cacheStallPolls = time.Millisecond, 3
+	// a finished file no task of this run places
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, "orphan.bin"), make([]byte, 60), 0644))
+
+	paused := make(chan struct{}, 1)
+	stalled, err := waitForCache(context.Background(), paused)
+	require.NoError(t, err)
+	assert.True(t, stalled)
+	assert.Len(t, paused, 1)
+
+	require.NoError(t, os.Remove(filepath.Join(cacheDir, "orphan.bin")))
+	stalled, err = waitForCache(context.Background(), paused)
+	require.NoError(t, err)
+	assert.False(t, stalled)
+}
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/hrz6976/syncmate/woc"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// taskFilter selects tasks by virtual path
+type taskFilter struct {
+	includes []string
+	excludes []string
+	// files is the allow list read from --files-from, nil if not given
+	files map[string]bool
+}
+
+func newTaskFilter(includes, excludes []string, filesFrom string) (*taskFilter, error) {
+	for _, pattern := range append(append([]string{}, includes...), excludes...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+		}
+	}
+	f := &taskFilter{
+		includes: includes,
+		excludes: excludes,
+	}
+	if filesFrom != "" {
+		files, err := readFileList(filesFrom)
+		if err != nil {
+			return nil, err
+		}
+		f.files = files
+	}
+	return f, nil
+}
+
+// readFileList reads one virtual path per line, skipping empty lines and # comments
+func readFileList(filePath string) (map[string]bool, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file list %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	files := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		files[line] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read file list %s: %w", filePath, err)
+	}
+	return files, nil
+}
+
+func matchAny(patterns []string, virtualPath string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, virtualPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether the task with the given virtual path should be transferred
+func (f *taskFilter) Match(virtualPath string) bool {
+	if f == nil {
+		return true
+	}
+	if f.files != nil && !f.files[virtualPath] {
+		return false
+	}
+	if len(f.includes) > 0 && !matchAny(f.includes, virtualPath) {
+		return false
+	}
+	return !matchAny(f.excludes, virtualPath)
+}
+
+// filterTasks removes the tasks that do not pass the filter from tasksMap
+func filterTasks(tasksMap map[string]*woc.WocSyncTask, f *taskFilter) {
+	for virtualPath := range tasksMap {
+		if !f.Match(virtualPath) {
+			logger.WithField("file", virtualPath).Debug("File filtered out")
+			delete(tasksMap, virtualPath)
+		}
+	}
+}
+
+func addTaskFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSlice("include", nil, "Only transfer files whose virtual path matches one of these glob patterns")
+	cmd.Flags().StringSlice("exclude", nil, "Skip files whose virtual path matches one of these glob patterns")
+	cmd.Flags().String("files-from", "", "Only transfer the virtual paths listed in this file, one per line")
+}
+
+func taskFilterFromFlags(cmd *cobra.Command) (*taskFilter, error) {
+	includes, _ := cmd.Flags().GetStringSlice("include")
+	excludes, _ := cmd.Flags().GetStringSlice("exclude")
+	filesFrom, _ := cmd.Flags().GetString("files-from")
+	return newTaskFilter(includes, excludes, filesFrom)
+}
+
+// maps and objects chosen by --maps and --objects, all datasets if both are empty
+var (
+	mapSelectors    []string
+	objectSelectors []string
+)
+
+func addDatasetFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&mapSelectors, "maps", nil, "Only compare these WoC maps (names or glob patterns, @file reads them from a file)")
+	cmd.Flags().StringSliceVar(&objectSelectors, "objects", nil, "Only compare these WoC objects (names, types like tree or glob patterns, @file reads them from a file)")
+}
+
+// readSelectors expands the @file entries of selectors
+func readSelectors(selectors []string) ([]string, error) {
+	var result []string
+	for _, s := range selectors {
+		if !strings.HasPrefix(s, "@") {
+			result = append(result, s)
+			continue
+		}
+		names, err := readFileList(s[1:])
+		if err != nil {
+			return nil, err
+		}
+		for name := range names {
+			result = append(result, name)
+		}
+	}
+	for _, pattern := range result {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+		}
+	}
+	return result, nil
+}
+
+// datasetMatcher returns whether a dataset name is chosen by the selectors: by
+// its name, a glob pattern, or its type, i.e. "tree" chooses tree.tch and tree.idx
+func datasetMatcher(selectors []string) func(name string) bool {
+	return func(name string) bool {
+		for _, s := range selectors {
+			if strings.HasPrefix(name, s+".") {
+				return true
+			}
+		}
+		return matchAny(selectors, name)
+	}
+}
+
+// datasetOptions sets the dataset selection of --maps and --objects in opt.
+// Once one is given, only the chosen datasets are compared.
+func datasetOptions(opt *woc.GenerateOptions) error {
+	if len(mapSelectors) == 0 && len(objectSelectors) == 0 {
+		return nil
+	}
+	maps, err := readSelectors(mapSelectors)
+	if err != nil {
+		return fmt.Errorf("invalid --maps: %w", err)
+	}
+	objects, err := readSelectors(objectSelectors)
+	if err != nil {
+		return fmt.Errorf("invalid --objects: %w", err)
+	}
+	opt.SelectMap = datasetMatcher(maps)
+	opt.SelectObject = datasetMatcher(objects)
+	return nil
+}
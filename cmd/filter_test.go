@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskFilter_IncludeExclude(t *testing.T) {
+	f, err := newTaskFilter([]string{"c2fbb*", "blob_*"}, []string{"blob_1*"}, "")
+	require.NoError(t, err)
+
+	assert.True(t, f.Match("c2fbbFullV2412.0.tch"))
+	assert.True(t, f.Match("blob_0.bin"))
+	assert.False(t, f.Match("blob_1.bin"))
+	assert.False(t, f.Match("c2pFullV2412.0.tch"))
+}
+
+func TestTaskFilter_ExcludeOnly(t *testing.T) {
+	f, err := newTaskFilter(nil, []string{"*.bin", "*.idx"}, "")
+	require.NoError(t, err)
+
+	assert.False(t, f.Match("blob_0.bin"))
+	assert.False(t, f.Match("blob_0.idx"))
+	assert.True(t, f.Match("c2pFullV2412.0.tch"))
+}
+
+func TestTaskFilter_FilesFrom(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	listPath := filepath.Join(tmpDir, "files.txt")
+	require.NoError(t, os.WriteFile(listPath, []byte("# wanted files\nblob_0.bin\n\n  c2pFullV2412.0.tch  \n"), 0644))
+
+	f, err := newTaskFilter(nil, []string{"c2p*"}, listPath)
+	require.NoError(t, err)
+
+	assert.True(t, f.Match("blob_0.bin"))
+	assert.False(t, f.Match("blob_1.bin"))
+	// excludes still apply to listed files
+	assert.False(t, f.Match("c2pFullV2412.0.tch"))
+}
+
+func TestTaskFilter_Errors(t *testing.T) {
+	_, err := newTaskFilter([]string{"["}, nil, "")
+	assert.Error(t, err)
+
+	_, err = newTaskFilter(nil, nil, "/non/existent/files.txt")
+	assert.Error(t, err)
+}
+
+func TestFilterTasks(t *testing.T) {
+	tasksMap := newOrderTestTasks()
+	f, err := newTaskFilter([]string{"c2p*"}, nil, "")
+	require.NoError(t, err)
+
+	filterTasks(tasksMap, f)
+	assert.Len(t, tasksMap, 2)
+	assert.Contains(t, tasksMap, "c2pFullV2412.0.tch")
+	assert.Contains(t, tasksMap, "c2pFullV2412.1.tch")
+
+	// a nil filter keeps everything
+	filterTasks(tasksMap, nil)
+	assert.Len(t, tasksMap, 2)
+}
+
+func TestDatasetOptions(t *testing.T) {
+	oldMaps, oldObjects := mapSelectors, objectSelectors
+	defer func() { mapSelectors, objectSelectors = oldMaps, oldObjects }()
+
+	var opt woc.GenerateOptions
+	mapSelectors, objectSelectors = nil, nil
+	require.NoError(t, datasetOptions(&opt))
+	assert.Nil(t, opt.SelectMap)
+
+	listPath := filepath.Join(t.TempDir(), "objects.txt")
+	require.NoError(t, os.WriteFile(listPath, []byte("# blobs\nblob\n"), 0644))
+	mapSelectors, objectSelectors = []string{"c2p*"}, []string{"tree", "@" + listPath}
+	require.NoError(t, datasetOptions(&opt))
+	assert.True(t, opt.SelectMap("c2pFull"))
+	assert.False(t, opt.SelectMap("b2fa"))
+	assert.True(t, opt.SelectObject("tree.tch"))
+	assert.True(t, opt.SelectObject("tree.idx"))
+	assert.True(t, opt.SelectObject("blob.bin"))
+	assert.False(t, opt.SelectObject("sha1.tree.tch"))
+	assert.False(t, opt.SelectObject("commit.tch"))
+
+	// only objects chosen, no maps
+	mapSelectors, objectSelectors = nil, []string{"tree"}
+	require.NoError(t, datasetOptions(&opt))
+	assert.False(t, opt.SelectMap("c2pFull"))
+
+	mapSelectors = []string{"[c2p"}
+	assert.Error(t, datasetOptions(&opt))
+}
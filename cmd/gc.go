@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hrz6976/syncmate/db"
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/rclone/rclone/fs"
+	"github.com/spf13/cobra"
+)
+
+// staleObject is an object of the bucket that recv will never download
+type staleObject struct {
+	rclone.RcloneFileInfo
+	Reason string
+}
+
+// findStaleObjects returns the objects uploaded more than olderThan before now whose task is
+// Downloaded or doesn't exist. Sidecars, deltas and signatures go with the file they belong to.
+func findStaleObjects(objects []rclone.RcloneFileInfo, statuses map[string]db.Status, olderThan time.Duration, now time.Time) []staleObject {
+	var stale []staleObject
+	for _, object := range objects {
+		if object.ModTime.IsZero() || now.Sub(object.ModTime) < olderThan {
+			continue
+		}
+		virtualPath, _ := woc.CompanionOf(object.Name)
+		status, ok := statuses[virtualPath]
+		switch {
+		case !ok:
+			stale = append(stale, staleObject{object, "no task"})
+		case status == db.Downloaded:
+			stale = append(stale, staleObject{object, "task is Downloaded"})
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].Name < stale[j].Name })
+	return stale
+}
+
+// printStaleObjects writes the stale objects as a table
+func printStaleObjects(w io.Writer, stale []staleObject) {
+	if len(stale) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Virtual Path\tSize\tUploaded\tReason")
+	fmt.Fprintln(tw, "------------\t----\t--------\t------")
+	for _, object := range stale {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", object.Name, fs.SizeSuffix(object.Size).ByteUnit(),
+			object.ModTime.Format(time.DateOnly), object.Reason)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+}
+
+// removeStaleObjects deletes the stale objects of fsrc, up to --delete-workers at a time, or moves
+// them into --archive-dir one by one. It returns what was removed, and the errors joined.
+func removeStaleObjects(ctx context.Context, fsrc fs.Fs, stale []staleObject) (rclone.DeleteSummary, error) {
+	if archiveDir == "" {
+		names := make([]string, len(stale))
+		for i, object := range stale {
+			names[i] = object.Name
+		}
+		return rclone.DeleteFiles(ctx, fsrc, names, deleteWorkers)
+	}
+	var summary rclone.DeleteSummary
+	cleanup, err := newRemoteCleanup(ctx, fsrc)
+	if err != nil {
+		return summary, err
+	}
+	var errs []error
+	for _, object := range stale {
+		if err := cleanup(object.Name); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", object.Name, err))
+			continue
+		}
+		summary.Files++
+		summary.Bytes += object.Size
+	}
+	return summary, errors.Join(errs...)
+}
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove stale objects from the bucket",
+	Long: `List the bucket and remove the objects uploaded more than --older-than ago whose task is
+already Downloaded or isn't in the database at all, e.g. those of a recv that ran without
+--delete-remote or of tasks that were deleted. They are deleted, or moved into --archive-dir.
+The sidecars are removed with their objects. The directories of the bucket, like the archive,
+are not listed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		configPath, _ := cmd.Flags().GetString("config")
+		olderThan, _ := cmd.Flags().GetDuration("older-than")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		if err := loadConfig(configPath); err != nil {
+			cmd.PrintErrf("%v\n", err)
+			os.Exit(1)
+		}
+		dbHandle, err := connectDB()
+		if err != nil {
+			cmd.PrintErrf("Failed to connect to database: %v\n", err)
+			os.Exit(1)
+		}
+		statuses, err := taskStatuses(dbHandle)
+		if err != nil {
+			cmd.PrintErrf("Failed to list tasks: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx, fsrc, err := newStatusR2Backend()
+		if err != nil {
+			cmd.PrintErrf("%v\n", err)
+			os.Exit(1)
+		}
+		objects, err := rclone.ListFilesWithModTime(ctx, fsrc)
+		if err != nil {
+			cmd.PrintErrf("Error listing files: %v\n", err)
+			os.Exit(1)
+		}
+		stale := findStaleObjects(objects, statuses, olderThan, time.Now())
+
+		printStaleObjects(cmd.OutOrStdout(), stale)
+		if dryRun {
+			var size int64
+			for _, object := range stale {
+				size += object.Size
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Would reclaim %s from %d stale objects\n", fs.SizeSuffix(size).ByteUnit(), len(stale))
+			return
+		}
+		summary, err := removeStaleObjects(ctx, fsrc, stale)
+		fmt.Fprintf(cmd.OutOrStdout(), "Reclaimed %s from %d stale objects\n", fs.SizeSuffix(summary.Bytes).ByteUnit(), summary.Files)
+		if err != nil {
+			cmd.PrintErrf("Failed to remove some stale objects: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	gcCmd.Flags().StringP("config", "c", "config.json", "Path to the configuration file")
+	gcCmd.Flags().Duration("older-than", 7*24*time.Hour, "Only remove the objects uploaded longer ago than this")
+	gcCmd.Flags().Bool("dry-run", false, "Only list the objects that would be removed")
+	gcCmd.Flags().StringVar(&archiveDir, "archive-dir", "", "Move the stale objects into this directory on R2 (e.g. archive/) instead of deleting them")
+	gcCmd.Flags().StringVar(&archiveBucket, "archive-bucket", "", "Bucket of --archive-dir, or the root of another remote, defaults to the bucket in the config file or the root of the remote")
+	gcCmd.Flags().IntVar(&deleteWorkers, "delete-workers", 8, "Number of stale objects deleted on R2 in parallel")
+	addRemotePrefixFlag(gcCmd)
+	addRunIDFlag(gcCmd)
+	RootCmd.AddCommand(gcCmd)
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hrz6976/syncmate/db"
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindStaleObjects(t *testing.T) {
+	now := time.Now()
+	old, recent := now.Add(-10*24*time.Hour), now.Add(-time.Hour)
+	objects := []rclone.RcloneFileInfo{
+		{Name: "uploaded.bin", Size: 1, ModTime: old},
+		{Name: "downloaded.bin", Size: 2, ModTime: old},
+		{Name: woc.MetaPath("downloaded.bin"), Size: 3, ModTime: old},
+		{Name: "recent.bin", Size: 4, ModTime: recent},
+		{Name: "orphan.bin", Size: 5, ModTime: old},
+		{Name: "unknown-time.bin", Size: 6},
+	}
+	statuses := map[string]db.Status{
+		"uploaded.bin":   db.Uploaded,
+		"downloaded.bin": db.Downloaded,
+		"recent.bin":     db.Downloaded,
+	}
+
+	stale := findStaleObjects(objects, statuses, 7*24*time.Hour, now)
+	require.Len(t, stale, 3)
+	assert.Equal(t, "downloaded.bin", stale[0].Name)
+	assert.Equal(t, "task is Downloaded", stale[0].Reason)
+	assert.Equal(t, woc.MetaPath("downloaded.bin"), stale[1].Name)
+	assert.Equal(t, "task is Downloaded", stale[1].Reason)
+	assert.Equal(t, "orphan.bin", stale[2].Name)
+	assert.Equal(t, "no task", stale[2].Reason)
+
+	// objects without a time are never old enough
+	assert.Len(t, findStaleObjects(objects, statuses, 0, now), 4)
+}
+
+func TestRemoveStaleObjects(t *testing.T) {
+	ctx := context.Background()
+	bucket := filepath.Join(setupTestDir(t), "bucket")
+	fsrc, err := fs.NewFs(ctx, bucket)
+	require.NoError(t, err)
+	for _, name := range []string{"keep.bin", "stale.bin"} {
+		require.NoError(t, rclone.PutFile(ctx, fsrc, name, []byte("0123456789")))
+	}
+	objects, err := rclone.ListFilesWithModTime(ctx, fsrc)
+	require.NoError(t, err)
+	require.Len(t, objects, 2)
+
+	stale := findStaleObjects(objects, map[string]db.Status{"keep.bin": db.Uploaded}, 0, time.Now())
+	require.Len(t, stale, 1)
+	summary, err := removeStaleObjects(ctx, fsrc, stale)
+	require.NoError(t, err)
+	assert.Equal(t, rclone.DeleteSummary{Files: 1, Bytes: 10}, summary)
+
+	_, err = os.Stat(filepath.Join(bucket, "stale.bin"))
+	assert.True(t, os.IsNotExist(err))
+	assert.FileExists(t, filepath.Join(bucket, "keep.bin"))
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// HooksConfig is the "hooks" section of config.json
+type HooksConfig struct {
+	// OnFileDone runs after recv verified and placed a file, with {dst}, {virtual} and {size}.
+	OnFileDone string `json:"on_file_done,omitempty"`
+	// OnRunDone runs when a run finishes, with {command}, {event}, {files}, {bytes}, {failures}, {error} and {report}.
+	OnRunDone string `json:"on_run_done,omitempty"`
+}
+
+var (
+	onFileDone string
+	onRunDone  string
+	// hookTimeout bounds the runtime of a hook command
+	hookTimeout = 30 * time.Minute
+)
+
+func addFileDoneHookFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&onFileDone, "on-file-done", "", "Shell command run after each file is verified and placed, e.g. \"chmod 444 {dst}\" (placeholders: {dst}, {virtual}, {size})")
+}
+
+func addRunDoneHookFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&onRunDone, "on-run-done", "", "Shell command run when the run finishes (placeholders: {command}, {event}, {files}, {bytes}, {failures}, {error}, {report})")
+}
+
+// fileDoneHook returns the --on-file-done command, or on_file_done of the config file
+func fileDoneHook() string {
+	if onFileDone != "" || config == nil || config.Hooks == nil {
+		return onFileDone
+	}
+	return config.Hooks.OnFileDone
+}
+
+// runDoneHook returns the --on-run-done command, or on_run_done of the config file
+func runDoneHook() string {
+	if onRunDone != "" || config == nil || config.Hooks == nil {
+		return onRunDone
+	}
+	return config.Hooks.OnRunDone
+}
+
+var hookPlaceholder = regexp.MustCompile(`\{[a-z]+\}`)
+
+// expandHook replaces the placeholders of command with their shell-quoted values.
+// Unknown placeholders are left as they are.
+func expandHook(command string, vars map[string]string) string {
+	return hookPlaceholder.ReplaceAllStringFunc(command, func(p string) string {
+		v, ok := vars[p[1:len(p)-1]]
+		if !ok {
+			return p
+		}
+		return "'" + strings.ReplaceAll(v, "'", `'\''`) + "'"
+	})
+}
+
+// runHook runs the expanded command with sh, an empty command does nothing
+func runHook(ctx context.Context, name, command string, vars map[string]string) error {
+	if command == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+	expanded := expandHook(command, vars)
+	logger.WithFields(logger.Fields{"hook": name, "command": expanded}).Debug("Running hook")
+	out, err := exec.CommandContext(ctx, "sh", "-c", expanded).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s hook %q failed: %w: %s", name, expanded, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandHook(t *testing.T) {
+	vars := map[string]string{"dst": "/data/it's here", "virtual": "blob_1.bin"}
+	assert.Equal(t, `rebuild '/data/it'\''s here' 'blob_1.bin' {unknown}`,
+		expandHook("rebuild {dst} {virtual} {unknown}", vars))
+}
+
+func TestRunHook(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	out := filepath.Join(tmpDir, "hook out.txt")
+
+	require.NoError(t, runHook(context.Background(), "on_file_done", "echo {virtual} > {out}", map[string]string{
+		"virtual": "c2pFullV2412.0.tch; rm -rf /",
+		"out":     out,
+	}))
+	content, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "c2pFullV2412.0.tch; rm -rf /\n", string(content))
+
+	assert.NoError(t, runHook(context.Background(), "on_file_done", "", nil))
+	err = runHook(context.Background(), "on_run_done", "echo oops >&2; exit 3", nil)
+	assert.ErrorContains(t, err, "oops")
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hrz6976/syncmate/db"
+	"github.com/hrz6976/syncmate/woc"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// leasing of send, disabled if claimTasks is 0
+var (
+	claimTasks int
+	leaseTTL   time.Duration
+	leaseOwner string
+)
+
+func addLeaseFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&claimTasks, "claim", 0, "Lease up to this many tasks in the database and upload only those, so that several hosts can send one task set; 0 to disable")
+	cmd.Flags().DurationVar(&leaseTTL, "lease-ttl", time.Hour, "With --claim, how long the leases last without being renewed, other hosts take the tasks over afterwards")
+	cmd.Flags().StringVar(&leaseOwner, "lease-owner", "", "With --claim, name of this host in the leases (default: the hostname)")
+}
+
+// leaseOwnerName returns the owner of the leases of this host
+func leaseOwnerName() (string, error) {
+	if leaseOwner != "" {
+		return leaseOwner, nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("failed to get the hostname for the leases, set --lease-owner: %w", err)
+	}
+	return hostname, nil
+}
+
+// isLocalSource reports whether the bytes of task can be read on this host
+func isLocalSource(task *woc.WocSyncTask) bool {
+	info, err := os.Stat(task.SourcePath)
+	return err == nil && info.Mode().IsRegular() && info.Size() >= task.Offset+task.Size
+}
+
+// claimSendTasks adds the tasks to the database and returns those of them that this host leased, in order.
+// Only the tasks whose source is on this host are claimed, the others are left to the hosts that have it.
+func claimSendTasks(owner string, tasks []*woc.WocSyncTask) ([]*woc.WocSyncTask, error) {
+	rows := make([]*db.Task, len(tasks))
+	var paths []string
+	for i, task := range tasks {
+		rows[i] = sendTaskRow(task, db.Pending)
+		if isLocalSource(task) {
+			paths = append(paths, task.VirtualPath)
+		}
+	}
+	if err := dbHandle.AddTasks(rows); err != nil {
+		return nil, fmt.Errorf("failed to add tasks: %w", err)
+	}
+	claimed, err := dbHandle.ClaimTasks(owner, paths, claimTasks, leaseTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim tasks: %w", err)
+	}
+	byPath := make(map[string]*woc.WocSyncTask, len(tasks))
+	for _, task := range tasks {
+		byPath[task.VirtualPath] = task
+	}
+	leased := make([]*woc.WocSyncTask, len(claimed))
+	for i, path := range claimed {
+		leased[i] = byPath[path]
+	}
+	logger.WithFields(logger.Fields{
+		"owner":   owner,
+		"claimed": len(leased),
+		"local":   len(paths),
+		"tasks":   len(tasks),
+	}).Info("Claimed tasks")
+	return leased, nil
+}
+
+// releaseLeases gives up the leases of owner on the tasks it didn't upload, for the other hosts to take over
+func releaseLeases(owner string) {
+	if err := dbHandle.ReleaseLeases(owner); err != nil {
+		logger.WithError(err).Warn("Failed to release the leases, other hosts take the tasks over once they expire")
+		return
+	}
+	logger.WithField("owner", owner).Info("Released the leases of the tasks not uploaded")
+}
+
+// renewLeases renews the leases of owner until ctx is done
+func renewLeases(ctx context.Context, owner string) {
+	ticker := time.NewTicker(leaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := dbHandle.RenewLeases(owner, leaseTTL); err != nil {
+				logger.WithError(err).Warn("Failed to renew the leases, other hosts may take the tasks over")
+			}
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hrz6976/syncmate/offsetfs"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsLocalSource(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	source := filepath.Join(tmpDir, "blob_0.bin")
+	require.NoError(t, os.WriteFile(source, make([]byte, 100), 0644))
+
+	assert.True(t, isLocalSource(&woc.WocSyncTask{FileConfig: offsetfs.FileConfig{SourcePath: source, Offset: 40, Size: 60}}))
+	// on another host, or only partly written here
+	assert.False(t, isLocalSource(&woc.WocSyncTask{FileConfig: offsetfs.FileConfig{SourcePath: filepath.Join(tmpDir, "blob_1.bin"), Size: 10}}))
+	assert.False(t, isLocalSource(&woc.WocSyncTask{FileConfig: offsetfs.FileConfig{SourcePath: source, Offset: 50, Size: 60}}))
+	assert.False(t, isLocalSource(&woc.WocSyncTask{FileConfig: offsetfs.FileConfig{SourcePath: tmpDir}}))
+}
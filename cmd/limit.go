@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/rclone/rclone/fs"
+	"github.com/spf13/cobra"
+)
+
+// transfer limits of a run, negative for no limit
+var (
+	maxBytes = fs.SizeSuffix(-1)
+	maxFiles = -1
+)
+
+func addTransferLimitFlags(cmd *cobra.Command) {
+	cmd.Flags().Var(&maxBytes, "max-bytes", "Stop the run after transferring this many bytes (e.g. 500G), unlimited if negative")
+	cmd.Flags().IntVar(&maxFiles, "max-files", -1, "Stop the run after transferring this many files, unlimited if negative")
+}
+
+// limitTasks splits the ordered tasks into those that fit into the transfer
+// limits and the remaining ones. Tasks too large for the bytes left are skipped
+// so that smaller tasks later in the order can still fill the quota.
+func limitTasks(tasks []*woc.WocSyncTask, maxBytes int64, maxFiles int) ([]*woc.WocSyncTask, []*woc.WocSyncTask) {
+	if maxBytes < 0 && maxFiles < 0 {
+		return tasks, nil
+	}
+	var selected, remaining []*woc.WocSyncTask
+	var bytes int64
+	for _, task := range tasks {
+		if (maxFiles >= 0 && len(selected) >= maxFiles) || (maxBytes >= 0 && bytes+task.Size > maxBytes) {
+			remaining = append(remaining, task)
+			continue
+		}
+		selected = append(selected, task)
+		bytes += task.Size
+	}
+	return selected, remaining
+}
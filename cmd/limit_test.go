@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitTasks(t *testing.T) {
+	tasks := orderTasks(newOrderTestTasks(), OrderSmallestFirst, nil)
+
+	selected, remaining := limitTasks(tasks, -1, -1)
+	assert.Len(t, selected, 4)
+	assert.Empty(t, remaining)
+
+	selected, remaining = limitTasks(tasks, -1, 3)
+	assert.Equal(t, []string{"blob_1.bin", "c2pFullV2412.1.tch", "blob_0.bin"}, virtualPaths(selected))
+	assert.Equal(t, []string{"c2pFullV2412.0.tch"}, virtualPaths(remaining))
+
+	selected, remaining = limitTasks(tasks, 450, -1)
+	assert.Equal(t, []string{"blob_1.bin", "c2pFullV2412.1.tch", "blob_0.bin"}, virtualPaths(selected))
+	assert.Equal(t, []string{"c2pFullV2412.0.tch"}, virtualPaths(remaining))
+
+	// too large tasks are skipped, smaller ones still fill the quota
+	tasks = orderTasks(newOrderTestTasks(), OrderLargestFirst, nil)
+	selected, remaining = limitTasks(tasks, 250, -1)
+	assert.Equal(t, []string{"blob_0.bin"}, virtualPaths(selected))
+	assert.Equal(t, []string{"c2pFullV2412.0.tch", "blob_1.bin", "c2pFullV2412.1.tch"}, virtualPaths(remaining))
+
+	selected, remaining = limitTasks(tasks, 0, -1)
+	assert.Empty(t, selected)
+	assert.Len(t, remaining, 4)
+}
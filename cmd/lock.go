@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// runLockPath returns the lock file of a role: send mounts a fixed mountpoint,
+// so one send may run per host; recv owns its cache directory.
+func runLockPath(role string) string {
+	if role == "recv" {
+		return filepath.Join(cacheDir, ".syncmate.lock")
+	}
+	return filepath.Join(os.TempDir(), "syncmate-send.lock")
+}
+
+// acquireRunLock takes an exclusive advisory lock on path, failing if another process holds it.
+// The lock is released when the returned func is called or the process exits.
+func acquireRunLock(path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory of lock file %s: %w", path, err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file %s: %w", path, err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		defer f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			data, _ := os.ReadFile(path)
+			if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+				return nil, fmt.Errorf("another syncmate process (pid %d) holds the lock file %s", pid, path)
+			}
+			return nil, fmt.Errorf("another syncmate process holds the lock file %s", path)
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	// record our pid for the error message of the next process
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcquireRunLock(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	lockPath := filepath.Join(tmpDir, ".syncmate.lock")
+
+	release, err := acquireRunLock(lockPath)
+	require.NoError(t, err)
+
+	_, err = acquireRunLock(lockPath)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "pid "+strconv.Itoa(os.Getpid()))
+
+	release()
+	release, err = acquireRunLock(lockPath)
+	require.NoError(t, err)
+	release()
+}
+
+func TestRunLockPath(t *testing.T) {
+	oldCacheDir := cacheDir
+	defer func() { cacheDir = oldCacheDir }()
+	cacheDir = "/data/cache"
+
+	assert.Equal(t, "/data/cache/.syncmate.lock", runLockPath("recv"))
+	assert.Equal(t, filepath.Join(os.TempDir(), "syncmate-send.lock"), runLockPath("send"))
+}
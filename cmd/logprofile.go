@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/terminal"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Log profiles of --log-profile
+const (
+	LogProfileAuto        = "auto"
+	LogProfileInteractive = "interactive"
+	LogProfileBatch       = "batch"
+)
+
+// Formats of --log-format
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+func addLogProfileFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("log-profile", LogProfileAuto, "How transfers report: interactive (progress display), batch (periodic stats, for cron), or auto (interactive in a terminal)")
+	cmd.PersistentFlags().String("rclone-log-level", "", "Level of the logs of rclone: DEBUG, INFO, NOTICE or ERROR, by default INFO when interactive, NOTICE in batch and DEBUG with -vv")
+	cmd.PersistentFlags().Bool("progress", false, "Show the progress display, by default when interactive")
+	cmd.PersistentFlags().Duration("stats-interval", 0, "Log the transfer stats this often without the progress display, 0 to disable, by default 5m in batch")
+	cmd.PersistentFlags().String("log-format", LogFormatText, "Format of the logs: text or json (one object per line)")
+	cmd.PersistentFlags().String("log-file", "", "Append the logs to this file instead of writing them to stderr")
+	cmd.PersistentFlags().Bool("no-color", false, "Don't color the text logs, also if NO_COLOR is set")
+}
+
+// logFormatter returns the formatter of a --log-format. The text logs are colored if they go to a
+// terminal, unless noColor.
+func logFormatter(format string, noColor bool) (logger.Formatter, error) {
+	switch format {
+	case LogFormatText:
+		return &logger.TextFormatter{DisableColors: noColor}, nil
+	case LogFormatJSON:
+		return &logger.JSONFormatter{}, nil
+	default:
+		return nil, fmt.Errorf("--log-format must be %s or %s, got %q", LogFormatText, LogFormatJSON, format)
+	}
+}
+
+// applyLogOutput sets the format and the file of the logs from the flags of cmd
+func applyLogOutput(cmd *cobra.Command) error {
+	format, _ := cmd.Flags().GetString("log-format")
+	noColor, _ := cmd.Flags().GetBool("no-color")
+	// see https://no-color.org
+	if os.Getenv("NO_COLOR") != "" {
+		noColor = true
+	}
+	formatter, err := logFormatter(format, noColor)
+	if err != nil {
+		return err
+	}
+	logger.SetFormatter(formatter)
+	if path, _ := cmd.Flags().GetString("log-file"); path != "" {
+		// the file stays open until the process exits
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open --log-file: %w", err)
+		}
+		logger.SetOutput(file)
+	}
+	return nil
+}
+
+// logProfileFromFlags returns the rclone profile chosen by the flags of cmd, terminal tells whether the output
+// is an interactive terminal
+func logProfileFromFlags(cmd *cobra.Command, terminal bool, verbose int) (rclone.Profile, error) {
+	name, _ := cmd.Flags().GetString("log-profile")
+	var p rclone.Profile
+	switch name {
+	case LogProfileInteractive:
+		p = rclone.InteractiveProfile
+	case LogProfileBatch:
+		p = rclone.BatchProfile
+	case LogProfileAuto:
+		p = rclone.BatchProfile
+		if terminal {
+			p = rclone.InteractiveProfile
+		}
+	default:
+		return p, fmt.Errorf("--log-profile must be %s, %s or %s, got %q", LogProfileAuto, LogProfileInteractive, LogProfileBatch, name)
+	}
+
+	if level, _ := cmd.Flags().GetString("rclone-log-level"); level != "" {
+		if err := p.LogLevel.Set(level); err != nil {
+			return p, fmt.Errorf("invalid --rclone-log-level: %w", err)
+		}
+	} else if verbose >= 2 {
+		p.LogLevel = fs.LogLevelDebug
+	}
+	if cmd.Flags().Changed("progress") {
+		p.Progress, _ = cmd.Flags().GetBool("progress")
+	}
+	if cmd.Flags().Changed("stats-interval") {
+		p.StatsInterval, _ = cmd.Flags().GetDuration("stats-interval")
+	}
+	return p, nil
+}
+
+// applyLogProfile makes the logs and the transfers of cmd report like its flags ask and passes the
+// logs of rclone and of the other packages to the logger
+func applyLogProfile(cmd *cobra.Command, verbose int) error {
+	if err := applyLogOutput(cmd); err != nil {
+		return err
+	}
+	p, err := logProfileFromFlags(cmd, terminal.IsTerminal(int(os.Stdout.Fd())), verbose)
+	if err != nil {
+		return err
+	}
+	rclone.SetProfile(p)
+	rclone.BridgeLogs()
+	return nil
+}
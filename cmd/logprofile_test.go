@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/rclone/rclone/fs"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogProfileFromFlags(t *testing.T) {
+	profile := func(terminal bool, verbose int, args ...string) (rclone.Profile, error) {
+		cmd := &cobra.Command{}
+		addLogProfileFlags(cmd)
+		require.NoError(t, cmd.ParseFlags(args))
+		return logProfileFromFlags(cmd, terminal, verbose)
+	}
+
+	p, err := profile(true, 0)
+	require.NoError(t, err)
+	assert.Equal(t, rclone.InteractiveProfile, p)
+	p, err = profile(false, 0)
+	require.NoError(t, err)
+	assert.Equal(t, rclone.BatchProfile, p)
+	p, err = profile(true, 0, "--log-profile", "batch")
+	require.NoError(t, err)
+	assert.Equal(t, rclone.BatchProfile, p)
+
+	// -vv shows the debug logs of rclone, unless the level is given
+	p, err = profile(false, 2)
+	require.NoError(t, err)
+	assert.Equal(t, fs.LogLevelDebug, p.LogLevel)
+	p, err = profile(false, 2, "--rclone-log-level", "error")
+	require.NoError(t, err)
+	assert.Equal(t, fs.LogLevelError, p.LogLevel)
+
+	p, err = profile(false, 0, "--progress", "--stats-interval", "1m")
+	require.NoError(t, err)
+	assert.True(t, p.Progress)
+	assert.Equal(t, time.Minute, p.StatsInterval)
+
+	_, err = profile(false, 0, "--log-profile", "loud")
+	assert.Error(t, err)
+	_, err = profile(false, 0, "--rclone-log-level", "chatty")
+	assert.Error(t, err)
+}
+
+func TestApplyLogOutput(t *testing.T) {
+	oldOut, oldFormatter := logger.StandardLogger().Out, logger.StandardLogger().Formatter
+	t.Cleanup(func() {
+		logger.SetOutput(oldOut)
+		logger.SetFormatter(oldFormatter)
+	})
+	apply := func(args ...string) error {
+		cmd := &cobra.Command{}
+		addLogProfileFlags(cmd)
+		require.NoError(t, cmd.ParseFlags(args))
+		return applyLogOutput(cmd)
+	}
+
+	t.Setenv("NO_COLOR", "")
+	require.NoError(t, apply())
+	assert.Equal(t, &logger.TextFormatter{}, logger.StandardLogger().Formatter)
+	assert.Equal(t, oldOut, logger.StandardLogger().Out)
+	require.NoError(t, apply("--no-color"))
+	assert.Equal(t, &logger.TextFormatter{DisableColors: true}, logger.StandardLogger().Formatter)
+	t.Setenv("NO_COLOR", "1")
+	require.NoError(t, apply())
+	assert.Equal(t, &logger.TextFormatter{DisableColors: true}, logger.StandardLogger().Formatter)
+
+	path := filepath.Join(t.TempDir(), "syncmate.log")
+	require.NoError(t, os.WriteFile(path, []byte("earlier run\n"), 0644))
+	require.NoError(t, apply("--log-format", "json", "--log-file", path))
+	logger.WithField("file", "a.txt").Warn("placed")
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "earlier run\n{")
+	assert.Contains(t, string(data), `"file":"a.txt","level":"warning","msg":"placed"`)
+
+	assert.Error(t, apply("--log-format", "xml"))
+	assert.Error(t, apply("--log-file", filepath.Join(path, "not-a-dir", "syncmate.log")))
+}
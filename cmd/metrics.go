@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"github.com/hrz6976/syncmate/metrics"
+	"github.com/spf13/cobra"
+)
+
+func addMetricsAddrFlag(cmd *cobra.Command) {
+	cmd.Flags().String("metrics-addr", "", "Expose Prometheus metrics on this address (e.g. :9090), disabled if empty")
+}
+
+// startMetrics serves the metrics if --metrics-addr is set
+//
+// It returns a func which should be called to stop the server.
+func startMetrics(cmd *cobra.Command, direction string) (func(), error) {
+	addr, _ := cmd.Flags().GetString("metrics-addr")
+	if addr == "" {
+		return func() {}, nil
+	}
+	metrics.RegisterTransferStats(direction)
+	return metrics.Serve(addr)
+}
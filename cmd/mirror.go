@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hrz6976/syncmate/db"
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/rclone/rclone/fs"
+)
+
+// primaryDestination names the remote of config.json among the destinations of send
+const primaryDestination = "primary"
+
+// MirrorConfig is a destination send uploads every file to besides the remote, e.g. a bucket in another region
+type MirrorConfig struct {
+	// Name identifies the mirror in the database and the logs
+	Name string `json:"name"`
+	// Remote is the storage of the mirror, an R2 bucket if it is missing
+	Remote *rclone.RemoteConfig `json:"remote,omitempty"`
+	// AccountID, AccessKey, SecretKey and Bucket are the R2 bucket of the mirror, those of the top level by default
+	AccountID string `json:"account_id,omitempty"`
+	AccessKey string `json:"access_key,omitempty"`
+	SecretKey string `json:"secret_key,omitempty"`
+	Bucket    string `json:"bucket,omitempty"`
+}
+
+// validateMirrors checks that the mirrors have distinct names and valid remotes
+func validateMirrors(mirrors []MirrorConfig) error {
+	names := map[string]bool{primaryDestination: true}
+	for i, m := range mirrors {
+		if m.Name == "" {
+			return fmt.Errorf("mirror %d has no name", i)
+		}
+		if names[m.Name] {
+			return fmt.Errorf("mirror name %q is used twice or reserved", m.Name)
+		}
+		names[m.Name] = true
+		if err := m.Remote.Validate(); err != nil {
+			return fmt.Errorf("mirror %s: %w", m.Name, err)
+		}
+	}
+	return nil
+}
+
+// r2Credentials returns the R2 credentials of the mirror, falling back to those of the top level
+func (m *MirrorConfig) r2Credentials() *rclone.CloudflareR2Credentials {
+	cred := r2Credentials()
+	for _, field := range []struct{ value, dst *string }{
+		{&m.AccountID, &cred.AccountID},
+		{&m.AccessKey, &cred.AccessKey},
+		{&m.SecretKey, &cred.SecretKey},
+		{&m.Bucket, &cred.Bucket},
+	} {
+		if *field.value != "" {
+			*field.dst = *field.value
+		}
+	}
+	return cred
+}
+
+// sendDestination is a storage send uploads the files to
+type sendDestination struct {
+	name string
+	fs   fs.Fs
+}
+
+// newSendDestinations returns the remote fdst followed by the mirrors of the config
+func newSendDestinations(ctx context.Context, fdst fs.Fs) ([]sendDestination, error) {
+	destinations := []sendDestination{{primaryDestination, fdst}}
+	for i := range config.Mirrors {
+		m := &config.Mirrors[i]
+		f, err := rclone.NewRemote(ctx, m.Remote, m.r2Credentials())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the backend of mirror %s: %w", m.Name, err)
+		}
+		destinations = append(destinations, sendDestination{m.Name, f})
+	}
+	return destinations, nil
+}
+
+// mergeDestinationResults returns the results of the files that were transferred to every destination, by
+// virtual path. A file succeeded if it did everywhere and carries the results of the first destination,
+// otherwise it carries the first failure. The files some destination didn't attempt are left out unless
+// they failed before, e.g. on the primary whose failure stopped the upload before the mirrors.
+func mergeDestinationResults(destinations []sendDestination, results map[string]map[string]rclone.TransferResult) map[string]rclone.TransferResult {
+	merged := make(map[string]rclone.TransferResult)
+	for virtualPath, first := range results[destinations[0].name] {
+		result := first
+		attempted := true
+		for _, d := range destinations {
+			r, ok := results[d.name][virtualPath]
+			if !ok {
+				attempted = !result.Succeeded()
+				break
+			}
+			if !r.Succeeded() && result.Succeeded() {
+				result = r
+				result.Err = fmt.Errorf("%s: %w", d.name, r.Err)
+			}
+		}
+		if attempted {
+			merged[virtualPath] = result
+		}
+	}
+	return merged
+}
+
+// succeededFiles returns the files of the results that are in the destination
+func succeededFiles(results map[string]rclone.TransferResult) []string {
+	var files []string
+	for virtualPath, result := range results {
+		if result.Succeeded() {
+			files = append(files, virtualPath)
+		}
+	}
+	return files
+}
+
+// destinationRows returns the database rows of the transfers of the files to each destination.
+// objects are the uploaded objects of StatFiles, by destination.
+func destinationRows(destinations []sendDestination, results map[string]map[string]rclone.TransferResult, objects map[string]map[string]rclone.RcloneFileInfo) []*db.TaskDestination {
+	var rows []*db.TaskDestination
+	for _, d := range destinations {
+		for virtualPath, result := range results[d.name] {
+			row := &db.TaskDestination{VirtualPath: virtualPath, Destination: d.name, Status: db.Uploaded}
+			if !result.Succeeded() {
+				row.Status, row.Error = db.Uploading, result.Err.Error()
+			}
+			if object, ok := objects[d.name][virtualPath]; ok {
+				row.ETag, row.ObjectSize = object.ETag, object.Size
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
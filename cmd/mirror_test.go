@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hrz6976/syncmate/db"
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateMirrors(t *testing.T) {
+	assert.NoError(t, validateMirrors(nil))
+	assert.NoError(t, validateMirrors([]MirrorConfig{{Name: "backup", Bucket: "woc-backup"}}))
+	assert.ErrorContains(t, validateMirrors([]MirrorConfig{{Bucket: "woc-backup"}}), "mirror 0 has no name")
+	assert.ErrorContains(t, validateMirrors([]MirrorConfig{{Name: "primary"}}), `"primary" is used twice or reserved`)
+	assert.ErrorContains(t, validateMirrors([]MirrorConfig{{Name: "a"}, {Name: "a"}}), `"a" is used twice`)
+	assert.ErrorContains(t, validateMirrors([]MirrorConfig{{Name: "gcs", Remote: &rclone.RemoteConfig{Type: rclone.RemoteGCS}}}),
+		"mirror gcs: root is required")
+}
+
+func TestMirrorR2Credentials(t *testing.T) {
+	t.Cleanup(func() { config = nil })
+	config = &CloudflareCredentials{AccountID: "utk", AccessKey: "key", SecretKey: "secret", Bucket: "woc"}
+	m := &MirrorConfig{Name: "backup", Bucket: "woc-backup", SecretKey: "backup-secret"}
+	cred := m.r2Credentials()
+	assert.Equal(t, "utk", cred.AccountID)
+	assert.Equal(t, "key", cred.AccessKey)
+	assert.Equal(t, "backup-secret", cred.SecretKey)
+	assert.Equal(t, "woc-backup", cred.Bucket)
+	// the top level is left alone
+	assert.Equal(t, "woc", r2Credentials().Bucket)
+}
+
+func TestMergeDestinationResults(t *testing.T) {
+	destinations := []sendDestination{{name: primaryDestination}, {name: "backup"}}
+	results := map[string]map[string]rclone.TransferResult{
+		primaryDestination: {
+			"a.bin": {Name: "a.bin", Bytes: 10},
+			"b.bin": {Name: "b.bin", Bytes: 10},
+			"c.bin": {Name: "c.bin", Bytes: 10},
+			"d.bin": {Name: "d.bin", Err: errors.New("timeout")},
+		},
+		"backup": {
+			"a.bin": {Name: "a.bin", Bytes: 10},
+			"b.bin": {Name: "b.bin", Err: errors.New("connection reset")},
+			"d.bin": {Name: "d.bin", Err: errors.New("connection reset")},
+		},
+	}
+	merged := mergeDestinationResults(destinations, results)
+	require.Len(t, merged, 3)
+	assert.True(t, merged["a.bin"].Succeeded())
+	assert.Equal(t, int64(10), merged["a.bin"].Bytes)
+	assert.EqualError(t, merged["b.bin"].Err, "backup: connection reset")
+	// the backup didn't get to c.bin, it isn't uploaded or failed yet
+	assert.NotContains(t, merged, "c.bin")
+	assert.EqualError(t, merged["d.bin"].Err, "timeout")
+
+	// the primary failed the batch and send stopped before the backup got it
+	stopped := mergeDestinationResults(destinations, map[string]map[string]rclone.TransferResult{
+		primaryDestination: {
+			"a.bin": {Name: "a.bin", Bytes: 10},
+			"b.bin": {Name: "b.bin", Err: errors.New("timeout")},
+		},
+		"backup": {},
+	})
+	require.Len(t, stopped, 1)
+	assert.EqualError(t, stopped["b.bin"].Err, "timeout")
+
+	objects := map[string]map[string]rclone.RcloneFileInfo{
+		"backup": {"a.bin": {Name: "a.bin", Size: 10, ETag: "abc"}},
+	}
+	rows := destinationRows(destinations, results, objects)
+	assert.Len(t, rows, 7)
+	for _, row := range rows {
+		switch {
+		case row.Destination == "backup" && row.VirtualPath == "a.bin":
+			assert.Equal(t, db.Uploaded, row.Status)
+			assert.Equal(t, "abc", row.ETag)
+			assert.Equal(t, int64(10), row.ObjectSize)
+		case row.Destination == "backup" && row.VirtualPath == "b.bin":
+			assert.Equal(t, db.Uploading, row.Status)
+			assert.Equal(t, "connection reset", row.Error)
+		}
+	}
+}
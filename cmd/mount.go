@@ -4,11 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"strings"
 
 	of "github.com/hrz6976/syncmate/offsetfs"
+	logger "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
@@ -54,7 +54,7 @@ func LoadConfigs(configPath string) (map[string]*of.FileConfig, error) {
 		}
 
 		configs[config.VirtualPath] = &config
-		log.Printf("Loaded config: %s -> %s (offset=%d, size=%d)",
+		logger.Infof("Loaded config: %s -> %s (offset=%d, size=%d)",
 			config.VirtualPath, config.SourcePath, config.Offset, config.Size)
 	}
 
@@ -76,22 +76,17 @@ var mountCmd = &cobra.Command{
 		allowOther := cmd.Flag("allow-other").Value.String() == "true"
 		readOnly := cmd.Flag("readonly").Value.String() == "true"
 		if configFile == "" {
-			log.Fatal("Configuration file is required. Use -config flag.")
+			logger.Fatal("Configuration file is required. Use -config flag.")
 		}
 		if mountpoint == "" {
-			log.Fatal("Mount point is required.")
-		}
-		if debug {
-			log.SetFlags(log.LstdFlags | log.Lshortfile)
-		} else {
-			log.SetFlags(log.LstdFlags)
+			logger.Fatal("Mount point is required.")
 		}
 		// 加载配置
 		configs, err := LoadConfigs(configFile)
 		if err != nil {
-			log.Fatalf("Failed to load configurations: %v", err)
+			logger.Fatalf("Failed to load configurations: %v", err)
 		}
-		log.Printf("Loaded %d file configurations", len(configs))
+		logger.Infof("Loaded %d file configurations", len(configs))
 		err = of.MountOffsetFS(of.MountOptions{
 			Mountpoint: mountpoint,
 			Configs:    configs,
@@ -100,7 +95,7 @@ var mountCmd = &cobra.Command{
 			ReadOnly:   readOnly,
 		})
 		if err != nil {
-			log.Fatalf("%v", err)
+			logger.Fatalf("%v", err)
 		}
 	},
 }
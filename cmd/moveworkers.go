@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// moveWorkers is the number of downloaded files placed in parallel
+	moveWorkers = 10
+	// fsMoveWorkers limits the files placed in parallel on the filesystem of a path
+	fsMoveWorkers map[string]int
+)
+
+func addMoveWorkersFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&moveWorkers, "move-workers", 10, "Number of downloaded files placed into their destination in parallel")
+	cmd.Flags().StringToIntVar(&fsMoveWorkers, "fs-move-workers", nil, "Limit the files placed in parallel on the filesystem of a path, e.g. /data/raid1=2 (repeatable)")
+}
+
+// moveLimiter bounds the number of files placed in parallel, overall and per destination filesystem
+type moveLimiter struct {
+	all   chan struct{}
+	perFs map[uint64]chan struct{}
+}
+
+func newMoveLimiter(workers int, fsWorkers map[string]int) (*moveLimiter, error) {
+	l := &moveLimiter{
+		all:   make(chan struct{}, max(workers, 1)),
+		perFs: make(map[uint64]chan struct{}),
+	}
+	for path, n := range fsWorkers {
+		if n < 1 {
+			return nil, fmt.Errorf("move workers of %s must be at least 1, got %d", path, n)
+		}
+		dev, _, err := statFs(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get filesystem of %s: %w", path, err)
+		}
+		if sem, ok := l.perFs[dev]; ok && cap(sem) <= n {
+			// several paths on one filesystem, the smallest limit wins
+			continue
+		}
+		l.perFs[dev] = make(chan struct{}, n)
+	}
+	return l, nil
+}
+
+// acquire waits for a slot to place a file at destPath and returns the func releasing it
+func (l *moveLimiter) acquire(destPath string) func() {
+	var fsSem chan struct{}
+	if len(l.perFs) > 0 {
+		if dev, _, err := statFs(destPath); err == nil {
+			fsSem = l.perFs[dev]
+		}
+	}
+	// take the filesystem slot first, so files waiting for a busy filesystem don't hold up the others
+	if fsSem != nil {
+		fsSem <- struct{}{}
+	}
+	l.all <- struct{}{}
+	return func() {
+		<-l.all
+		if fsSem != nil {
+			<-fsSem
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMoveLimiter(t *testing.T) {
+	tmpDir := setupTestDir(t)
+
+	_, err := newMoveLimiter(10, map[string]int{tmpDir: 0})
+	assert.Error(t, err)
+
+	// the filesystem limit applies below the overall limit
+	limiter, err := newMoveLimiter(10, map[string]int{tmpDir: 2})
+	require.NoError(t, err)
+
+	var running, peak atomic.Int32
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release := limiter.acquire(filepath.Join(tmpDir, "dest", "file"+string(rune('a'+i))))
+			defer release()
+			n := running.Add(1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			time.Sleep(10 * time.Millisecond)
+			running.Add(-1)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), peak.Load())
+}
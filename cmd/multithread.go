@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/rclone/rclone/fs"
+	"github.com/spf13/cobra"
+)
+
+// multi-thread download settings that override the transfer section of the config, unset if 0
+var (
+	multiThreadStreams int
+	multiThreadCutoff  fs.SizeSuffix
+)
+
+func addMultiThreadFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&multiThreadStreams, "multi-thread-streams", 0, "Download large files with this many parallel range requests, 1 for a single stream (default from the config, 4)")
+	cmd.Flags().Var(&multiThreadCutoff, "multi-thread-cutoff", "Download files above this size (e.g. 1G) with several streams (default from the config, 256M)")
+}
+
+// applyMultiThreadFlags overrides the multi-thread settings of the loaded config with the flags that are set
+func applyMultiThreadFlags() error {
+	if multiThreadStreams == 0 && multiThreadCutoff == 0 {
+		return nil
+	}
+	var transfer rclone.TransferConfig
+	if config.Transfer != nil {
+		transfer = *config.Transfer
+	}
+	if multiThreadStreams != 0 {
+		transfer.MultiThreadStreams = multiThreadStreams
+	}
+	if multiThreadCutoff != 0 {
+		transfer.MultiThreadCutoff = multiThreadCutoff.String()
+	}
+	config.Transfer = &transfer
+	_, err := rclone.SetTransferConfig(config.Transfer)
+	return err
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyMultiThreadFlags(t *testing.T) {
+	oldConfig := config
+	t.Cleanup(func() {
+		config = oldConfig
+		multiThreadStreams, multiThreadCutoff = 0, 0
+		rclone.SetTransferConfig(nil)
+	})
+	config = &CloudflareCredentials{Transfer: &rclone.TransferConfig{ChunkSize: "64M", MultiThreadStreams: 2}}
+
+	// without flags, the config is kept
+	require.NoError(t, applyMultiThreadFlags())
+	assert.Equal(t, 2, config.Transfer.MultiThreadStreams)
+
+	multiThreadStreams, multiThreadCutoff = 16, fs.SizeSuffix(fs.Gibi)
+	require.NoError(t, applyMultiThreadFlags())
+	assert.Equal(t, "64M", config.Transfer.ChunkSize)
+	assert.Equal(t, 16, config.Transfer.MultiThreadStreams)
+	assert.Equal(t, "1Gi", config.Transfer.MultiThreadCutoff)
+
+	multiThreadStreams = -1
+	assert.Error(t, applyMultiThreadFlags())
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hrz6976/syncmate/woc"
+)
+
+// TaskOrder controls the order in which tasks are uploaded by send and placed by recv
+type TaskOrder string
+
+const (
+	OrderSmallestFirst TaskOrder = "smallest-first"
+	OrderLargestFirst  TaskOrder = "largest-first"
+	OrderByMap         TaskOrder = "by-map"
+	OrderByPriority    TaskOrder = "by-priority-column"
+)
+
+var taskOrders = []TaskOrder{OrderSmallestFirst, OrderLargestFirst, OrderByMap, OrderByPriority}
+
+// transferOrder is the order selected with --order
+var transferOrder = OrderSmallestFirst
+
+func parseTaskOrder(s string) (TaskOrder, error) {
+	for _, o := range taskOrders {
+		if string(o) == s {
+			return o, nil
+		}
+	}
+	return "", fmt.Errorf("unknown order %q, expected one of %v", s, taskOrders)
+}
+
+// taskLess reports whether task a should be transferred before task b.
+// Ties are broken by virtual path so the result does not depend on map iteration order.
+func taskLess(a, b *woc.WocSyncTask, order TaskOrder, priorities map[string]int) bool {
+	switch order {
+	case OrderLargestFirst:
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+	case OrderByMap:
+		if a.Dataset != b.Dataset {
+			return a.Dataset < b.Dataset
+		}
+	case OrderByPriority:
+		if pa, pb := priorities[a.VirtualPath], priorities[b.VirtualPath]; pa != pb {
+			return pa > pb
+		}
+		if a.Size != b.Size {
+			return a.Size < b.Size
+		}
+	default: // smallest-first
+		if a.Size != b.Size {
+			return a.Size < b.Size
+		}
+	}
+	return a.VirtualPath < b.VirtualPath
+}
+
+// orderTasks returns the tasks of tasksMap as a slice sorted by order
+func orderTasks(tasksMap map[string]*woc.WocSyncTask, order TaskOrder, priorities map[string]int) []*woc.WocSyncTask {
+	tasks := make([]*woc.WocSyncTask, 0, len(tasksMap))
+	for _, task := range tasksMap {
+		tasks = append(tasks, task)
+	}
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return taskLess(tasks[i], tasks[j], order, priorities)
+	})
+	return tasks
+}
+
+// batchTasks splits ordered tasks into batches that are transferred one after another.
+// rclone can only order files within a batch by size or name, so by-priority-column runs one batch per priority level.
+func batchTasks(tasks []*woc.WocSyncTask, order TaskOrder, priorities map[string]int) [][]string {
+	var batches [][]string
+	var batch []string
+	for i, task := range tasks {
+		if order == OrderByPriority && i > 0 && priorities[task.VirtualPath] != priorities[tasks[i-1].VirtualPath] {
+			batches = append(batches, batch)
+			batch = nil
+		}
+		batch = append(batch, task.VirtualPath)
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// rcloneOrderBy translates a TaskOrder into rclone's --order-by syntax
+func rcloneOrderBy(order TaskOrder) string {
+	switch order {
+	case OrderLargestFirst:
+		return "size,descending"
+	case OrderByMap:
+		// virtual paths start with the map or object name
+		return "name,ascending"
+	default:
+		return "size,ascending"
+	}
+}
+
+// loadTaskPriorities fetches task priorities from the database if the order needs them
+func loadTaskPriorities(order TaskOrder) (map[string]int, error) {
+	if order != OrderByPriority || dbHandle == nil {
+		return nil, nil
+	}
+	return dbHandle.ListTaskPriorities()
+}
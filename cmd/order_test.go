@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/hrz6976/syncmate/offsetfs"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newOrderTestTasks() map[string]*woc.WocSyncTask {
+	newTask := func(virtualPath, dataset string, size int64) *woc.WocSyncTask {
+		return &woc.WocSyncTask{
+			FileConfig: offsetfs.FileConfig{VirtualPath: virtualPath, Size: size},
+			Dataset:    dataset,
+		}
+	}
+	return map[string]*woc.WocSyncTask{
+		"c2pFullV2412.0.tch": newTask("c2pFullV2412.0.tch", "c2p", 300),
+		"c2pFullV2412.1.tch": newTask("c2pFullV2412.1.tch", "c2p", 100),
+		"blob_0.bin":         newTask("blob_0.bin", "blob", 200),
+		"blob_1.bin":         newTask("blob_1.bin", "blob", 100),
+	}
+}
+
+func virtualPaths(tasks []*woc.WocSyncTask) []string {
+	paths := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		paths = append(paths, task.VirtualPath)
+	}
+	return paths
+}
+
+func TestParseTaskOrder(t *testing.T) {
+	for _, o := range taskOrders {
+		parsed, err := parseTaskOrder(string(o))
+		require.NoError(t, err)
+		assert.Equal(t, o, parsed)
+	}
+	_, err := parseTaskOrder("random")
+	assert.Error(t, err)
+}
+
+func TestOrderTasks(t *testing.T) {
+	tasksMap := newOrderTestTasks()
+
+	assert.Equal(t,
+		[]string{"blob_1.bin", "c2pFullV2412.1.tch", "blob_0.bin", "c2pFullV2412.0.tch"},
+		virtualPaths(orderTasks(tasksMap, OrderSmallestFirst, nil)))
+	assert.Equal(t,
+		[]string{"c2pFullV2412.0.tch", "blob_0.bin", "blob_1.bin", "c2pFullV2412.1.tch"},
+		virtualPaths(orderTasks(tasksMap, OrderLargestFirst, nil)))
+	assert.Equal(t,
+		[]string{"blob_0.bin", "blob_1.bin", "c2pFullV2412.0.tch", "c2pFullV2412.1.tch"},
+		virtualPaths(orderTasks(tasksMap, OrderByMap, nil)))
+
+	priorities := map[string]int{"c2pFullV2412.0.tch": 10, "blob_0.bin": 10}
+	assert.Equal(t,
+		[]string{"blob_0.bin", "c2pFullV2412.0.tch", "blob_1.bin", "c2pFullV2412.1.tch"},
+		virtualPaths(orderTasks(tasksMap, OrderByPriority, priorities)))
+}
+
+func TestBatchTasks(t *testing.T) {
+	tasksMap := newOrderTestTasks()
+	priorities := map[string]int{"c2pFullV2412.0.tch": 10, "blob_0.bin": 10}
+
+	batches := batchTasks(orderTasks(tasksMap, OrderByPriority, priorities), OrderByPriority, priorities)
+	assert.Equal(t, [][]string{
+		{"blob_0.bin", "c2pFullV2412.0.tch"},
+		{"blob_1.bin", "c2pFullV2412.1.tch"},
+	}, batches)
+
+	batches = batchTasks(orderTasks(tasksMap, OrderLargestFirst, nil), OrderLargestFirst, nil)
+	assert.Len(t, batches, 1)
+	assert.Len(t, batches[0], 4)
+
+	assert.Empty(t, batchTasks(nil, OrderSmallestFirst, nil))
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/rclone/rclone/fs"
+)
+
+// PlacementConfig is the "placement" section of config.json, how recv writes the downloaded files into their destination
+type PlacementConfig struct {
+	// Preallocate reserves the space of a file before writing it, true by default
+	Preallocate *bool `json:"preallocate,omitempty"`
+	// BufferSize is the size of the reads and writes like "4M", woc.DefaultMoveBufferSize by default
+	BufferSize string `json:"buffer_size,omitempty"`
+	// DirectIO writes the destination files past the page cache, for spinning disks
+	DirectIO bool `json:"direct_io,omitempty"`
+	// Fsync flushes every placed file to the disk before its cached copy is removed
+	Fsync bool `json:"fsync,omitempty"`
+}
+
+// moveOptions returns the options of woc.MoveFile the section gives, the defaults if c is nil
+func (c *PlacementConfig) moveOptions() (woc.MoveOptions, error) {
+	var opt woc.MoveOptions
+	if c == nil {
+		return opt, nil
+	}
+	opt.NoPreallocate = c.Preallocate != nil && !*c.Preallocate
+	opt.DirectIO = c.DirectIO
+	opt.Sync = c.Fsync
+	if c.BufferSize != "" {
+		var size fs.SizeSuffix
+		if err := size.Set(c.BufferSize); err != nil {
+			return opt, fmt.Errorf("invalid buffer_size %q: %w", c.BufferSize, err)
+		}
+		if size <= 0 || size > 1<<30 {
+			return opt, fmt.Errorf("buffer_size must be between 1 and 1G, got %q", c.BufferSize)
+		}
+		opt.BufferSize = int(size)
+	}
+	return opt, nil
+}
+
+// applyPlacementConfig sets the options of woc.MoveFile from the section
+func applyPlacementConfig(c *PlacementConfig) error {
+	opt, err := c.moveOptions()
+	if err != nil {
+		return err
+	}
+	return woc.SetMoveOptions(opt)
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlacementConfig(t *testing.T) {
+	var c *PlacementConfig
+	opt, err := c.moveOptions()
+	require.NoError(t, err)
+	assert.Equal(t, woc.MoveOptions{}, opt)
+
+	off := false
+	c = &PlacementConfig{Preallocate: &off, BufferSize: "4M", DirectIO: true, Fsync: true}
+	opt, err = c.moveOptions()
+	require.NoError(t, err)
+	assert.Equal(t, woc.MoveOptions{NoPreallocate: true, BufferSize: 4 << 20, DirectIO: true, Sync: true}, opt)
+
+	for _, size := range []string{"big", "0", "2G"} {
+		_, err = (&PlacementConfig{BufferSize: size}).moveOptions()
+		assert.Error(t, err, size)
+	}
+	// direct I/O needs aligned buffers
+	defer woc.SetMoveOptions(woc.MoveOptions{})
+	assert.Error(t, applyPlacementConfig(&PlacementConfig{BufferSize: "1000B", DirectIO: true}))
+	assert.NoError(t, applyPlacementConfig(&PlacementConfig{BufferSize: "64k", DirectIO: true}))
+}
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/rclone/rclone/fs"
+	"github.com/spf13/cobra"
+)
+
+// planBandwidth is the transfer rate in bytes per second assumed by plan
+var planBandwidth fs.SizeSuffix
+
+// planRow sums up the tasks of one dataset
+type planRow struct {
+	Dataset       string
+	FullCopies    int
+	PartialCopies int
+	Bytes         int64
+	FinishedBytes int64
+}
+
+// transferPlan sums up the tasks of a transfer per dataset
+type transferPlan struct {
+	Rows  []*planRow
+	Total planRow
+}
+
+// buildPlan sums up the tasks, counting those in finished as already transferred
+func buildPlan(tasksMap map[string]*woc.WocSyncTask, finished map[string]bool) *transferPlan {
+	rows := make(map[string]*planRow)
+	plan := &transferPlan{Total: planRow{Dataset: "Total"}}
+	for _, task := range tasksMap {
+		dataset := task.Dataset
+		if dataset == "" {
+			dataset = "(unknown)"
+		}
+		row, ok := rows[dataset]
+		if !ok {
+			row = &planRow{Dataset: dataset}
+			rows[dataset] = row
+			plan.Rows = append(plan.Rows, row)
+		}
+		for _, r := range []*planRow{row, &plan.Total} {
+			if task.Offset > 0 {
+				r.PartialCopies++
+			} else {
+				r.FullCopies++
+			}
+			r.Bytes += task.Size
+			if finished[task.VirtualPath] {
+				r.FinishedBytes += task.Size
+			}
+		}
+	}
+	sort.Slice(plan.Rows, func(i, j int) bool {
+		if plan.Rows[i].Bytes != plan.Rows[j].Bytes {
+			return plan.Rows[i].Bytes > plan.Rows[j].Bytes
+		}
+		return plan.Rows[i].Dataset < plan.Rows[j].Dataset
+	})
+	return plan
+}
+
+// print writes the plan as a table, with the remaining duration at bandwidth bytes per second if it is positive
+func (p *transferPlan) print(w io.Writer, bandwidth fs.SizeSuffix) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Dataset\tFull\tPartial\tTotal Size\tFinished")
+	fmt.Fprintln(tw, "-------\t----\t-------\t----------\t--------")
+	for _, row := range append(p.Rows, &p.Total) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\n",
+			row.Dataset, row.FullCopies, row.PartialCopies, formatSize(row.Bytes), formatSize(row.FinishedBytes))
+	}
+	tw.Flush()
+
+	remaining := p.Total.Bytes - p.Total.FinishedBytes
+	fmt.Fprintf(w, "\n%d tasks (%d full copies, %d partial copies), %s remaining\n",
+		p.Total.FullCopies+p.Total.PartialCopies, p.Total.FullCopies, p.Total.PartialCopies, formatSize(remaining))
+	if bandwidth > 0 {
+		duration := time.Duration(float64(remaining) / float64(bandwidth) * float64(time.Second))
+		fmt.Fprintf(w, "Estimated duration at %s/s: %s\n", bandwidth.ByteUnit(), duration.Round(time.Minute))
+	}
+}
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show the size of a transfer before running it",
+	Long: `Generate the tasks like send and recv and print the number of full and partial
+copies and the bytes per dataset, the bytes already Downloaded according to the
+database, and the estimated duration at the --bandwidth assumption.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		srcPath, _ := cmd.Flags().GetString("src")
+		dstPath, _ := cmd.Flags().GetString("dst")
+		configPath, _ := cmd.Flags().GetString("config")
+		skipDB, _ := cmd.Flags().GetBool("skip-db")
+		filter, err := taskFilterFromFlags(cmd)
+		if err != nil {
+			cmd.PrintErrf("Invalid filter: %v\n", err)
+			return
+		}
+
+		// before fetching remote profiles, which go through the proxy of the config.
+		// Without the database, a config is only needed if one is given.
+		if !skipDB || profileConfig != nil || cmd.Flags().Changed("config") {
+			if err := loadConfig(configPath); err != nil {
+				cmd.PrintErrf("%v\n", err)
+				return
+			}
+		}
+		var srcProfile, dstProfile *woc.ParsedWocProfile
+		if tasksFile == "" {
+			srcProfile, dstProfile, err = loadProfiles(srcPath, dstPath)
+			if err != nil {
+				cmd.PrintErrf("%v\n", err)
+				return
+			}
+		}
+
+		finished := make(map[string]bool)
+		if !skipDB {
+			if _, err = connectDB(); err != nil {
+				cmd.PrintErrf("Failed to connect to database: %v\n", err)
+				return
+			}
+			paths, err := dbHandle.ListFinishedVirtualPaths()
+			if err != nil {
+				cmd.PrintErrf("Failed to list finished tasks: %v\n", err)
+				return
+			}
+			for _, path := range paths {
+				finished[path] = true
+			}
+		}
+
+		tasksMap, err := loadAllTasks(srcProfile, dstProfile)
+		if err != nil {
+			cmd.PrintErrf("Failed to generate tasks: %v\n", err)
+			return
+		}
+		filterTasks(tasksMap, filter)
+		buildPlan(tasksMap, finished).print(cmd.OutOrStdout(), planBandwidth)
+	},
+}
+
+func init() {
+	planCmd.Flags().StringP("src", "s", "woc.src.json", "WoC profile of the transfer source, a path or URL")
+	planCmd.Flags().StringP("dst", "d", "woc.dst.json", "Woc profile of the transfer destination, a path or URL")
+	planCmd.Flags().StringP("config", "c", "config.json", "Path to the configuration file")
+	planCmd.Flags().Bool("skip-db", false, "Don't look up the finished tasks in the database")
+	planCmd.Flags().Var(&planBandwidth, "bandwidth", "Assumed transfer rate in bytes per second (e.g. 100M) to estimate the duration, no estimate if 0")
+	addTaskFilterFlags(planCmd)
+	addDigestWorkersFlag(planCmd)
+	addStrictFlag(planCmd)
+	addSkipBadShardsFlag(planCmd)
+	addAllVersionsFlag(planCmd)
+	addDatasetFlags(planCmd)
+	addTasksFileFlag(planCmd)
+	addRunIDFlag(planCmd)
+	RootCmd.AddCommand(planCmd)
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hrz6976/syncmate/offsetfs"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildPlan(t *testing.T) {
+	tasksMap := map[string]*woc.WocSyncTask{
+		"c2pFullV2412.0.tch":  {FileConfig: offsetfs.FileConfig{VirtualPath: "c2pFullV2412.0.tch", Size: 6 << 30}, Dataset: "c2pFull"},
+		"c2pFullV2412.1.tch":  {FileConfig: offsetfs.FileConfig{VirtualPath: "c2pFullV2412.1.tch", Size: 2 << 30}, Dataset: "c2pFull"},
+		"blob_0.bin.offset.5": {FileConfig: offsetfs.FileConfig{VirtualPath: "blob_0.bin.offset.5", Offset: 5, Size: 1 << 30}, Dataset: "blob"},
+	}
+	plan := buildPlan(tasksMap, map[string]bool{"c2pFullV2412.1.tch": true})
+	assert.Equal(t, []*planRow{
+		{Dataset: "c2pFull", FullCopies: 2, Bytes: 8 << 30, FinishedBytes: 2 << 30},
+		{Dataset: "blob", PartialCopies: 1, Bytes: 1 << 30},
+	}, plan.Rows)
+	assert.Equal(t, planRow{Dataset: "Total", FullCopies: 2, PartialCopies: 1, Bytes: 9 << 30, FinishedBytes: 2 << 30}, plan.Total)
+
+	var out bytes.Buffer
+	plan.print(&out, fs.SizeSuffix(100*fs.Mebi))
+	assert.Contains(t, out.String(), "3 tasks (2 full copies, 1 partial copies), 7.0 GiB remaining")
+	assert.Contains(t, out.String(), "Estimated duration at 100 MiB/s: 1m0s")
+}
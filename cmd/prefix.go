@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"path"
+
+	"github.com/spf13/cobra"
+)
+
+// remotePrefix scopes the transfer to the keys under it in the R2 bucket
+var remotePrefix string
+
+func addRemotePrefixFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&remotePrefix, "remote-prefix", "", "Only use the objects under this key prefix of the bucket (e.g. campaign-2412/), so several transfers can share one bucket")
+}
+
+// runID scopes the tasks in the database and the objects in the bucket to one transfer
+var runID string
+
+func addRunIDFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&runID, "run-id", "", "Keep the tasks and objects of this transfer (e.g. 2025q1) apart from those of other runs")
+}
+
+// bucketPrefix returns the key prefix of the objects of the run
+func bucketPrefix() string {
+	if runID == "" {
+		return remotePrefix
+	}
+	return path.Join(remotePrefix, runID)
+}
@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/hrz6976/syncmate/woc"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Manage WoC profiles",
+}
+
+var profileScanCmd = &cobra.Command{
+	Use:   "scan <dir>...",
+	Short: "Generate a WoC profile from the files of the given directories",
+	Long: `Generate a WoC profile from the files of the given directories, e.g. All.blobs,
+All.sha1c and basemaps. Map shards (c2pFullV2412.0.tch) and object shards
+(tree_0.idx) are grouped by their names, and the digests of all files are
+computed unless --skip-digest is given. Subdirectories are not scanned.
+
+The digests are the sample MD5 of woc.utils.fast_digest by default. Another
+algorithm is chosen with --digest-algorithm, e.g. blake3 for full-file BLAKE3
+or xxhash64:sampled for xxHash64 over the same samples, and recorded in the
+profile as digestAlgorithm for task generation, recv and verify.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		outputPath, _ := cmd.Flags().GetString("output")
+		skipDigest, _ := cmd.Flags().GetBool("skip-digest")
+		algorithm, _ := cmd.Flags().GetString("digest-algorithm")
+		digester, err := woc.NewDigester(algorithm)
+		if err != nil {
+			cmd.PrintErrf("Invalid --digest-algorithm: %v\n", err)
+			return
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		profile, err := woc.ScanProfile(ctx, args, woc.ScanOptions{
+			DigestWorkers: digestWorkers,
+			SkipDigest:    skipDigest,
+			Digester:      digester,
+		})
+		if err != nil {
+			cmd.PrintErrf("Failed to scan profile: %v\n", err)
+			return
+		}
+
+		if err := writeProfile(cmd, profile, outputPath); err != nil {
+			cmd.PrintErrf("%v\n", err)
+		}
+	},
+}
+
+// writeProfile writes profile as indented JSON to outputPath, stdout if empty
+func writeProfile(cmd *cobra.Command, profile *woc.WocProfile, outputPath string) error {
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode profile: %w", err)
+	}
+	data = append(data, '\n')
+	if outputPath == "" {
+		_, err = cmd.OutOrStdout().Write(data)
+	} else {
+		err = os.WriteFile(outputPath, data, 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write profile: %w", err)
+	}
+	return nil
+}
+
+// readProfile reads a local or remote profile as it is, without picking the latest map versions like woc.ParseWocProfile
+func readProfile(path string) (*woc.WocProfile, error) {
+	data, err := woc.ReadProfileData(context.Background(), path)
+	if err != nil {
+		return nil, err
+	}
+	var profile woc.WocProfile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &profile, nil
+}
+
+var profileMergeCmd = &cobra.Command{
+	Use:   "merge <profile>...",
+	Short: "Combine the profiles of several mounts into one",
+	Long: `Combine profiles, e.g. scanned separately from the mounts of a host, into one. The
+versions of each map are combined. A map version or an object in more than one of
+the profiles must be the same in all of them, and so must their versionOrder and
+digestAlgorithm. The issues of the merged profile, e.g. paths in two profiles, are
+logged like those of any profile that is read.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		outputPath, _ := cmd.Flags().GetString("output")
+		profiles := make([]*woc.WocProfile, len(args))
+		for i, path := range args {
+			profile, err := readProfile(path)
+			if err != nil {
+				cmd.PrintErrf("Failed to read profile: %v\n", err)
+				return
+			}
+			profiles[i] = profile
+		}
+		merged, err := woc.MergeProfiles(profiles, args)
+		if err != nil {
+			cmd.PrintErrf("Failed to merge profiles: %v\n", err)
+			return
+		}
+		data, err := json.Marshal(merged)
+		if err == nil {
+			for _, issue := range woc.ValidateWocProfile(data, merged) {
+				logger.Warnf("Merged profile: %s: %s", issue.Field, issue.Problem)
+			}
+		}
+		if err := writeProfile(cmd, merged, outputPath); err != nil {
+			cmd.PrintErrf("%v\n", err)
+		}
+	},
+}
+
+// formatChangeSize renders the sizes of a change, e.g. "1.0 GiB -> 1.5 GiB"
+func formatChangeSize(change woc.ProfileChange) string {
+	size := func(bytes int64) string {
+		if bytes < 0 {
+			return "-"
+		}
+		return formatSize(bytes)
+	}
+	switch {
+	case change.OldSize < 0:
+		return size(change.NewSize)
+	case change.NewSize < 0 || change.OldSize == change.NewSize:
+		return size(change.OldSize)
+	}
+	return size(change.OldSize) + " -> " + size(change.NewSize)
+}
+
+// printProfileChanges writes the changes as a table and their number
+func printProfileChanges(w io.Writer, changes []woc.ProfileChange) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "The profiles are the same")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Change\tDataset\tShard\tDestination\tSource\tSize")
+	fmt.Fprintln(tw, "------\t-------\t-----\t-----------\t------\t----")
+	for _, c := range changes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Kind, c.Dataset, dash(c.Shard), dash(c.Old), dash(c.New), formatChangeSize(c))
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\n%d differences\n", len(changes))
+}
+
+var profileDiffCmd = &cobra.Command{
+	Use:   "diff <src profile> <dst profile>",
+	Short: "Print the differences between a source and a destination profile",
+	Long: `Compare a source profile with a destination profile and print what differs, without
+generating tasks or reading any shard: the maps and objects of one profile only, the
+newer or older latest versions of maps, and for the same version or sharding the
+resharded datasets and the new, removed, grown and shrunk shards and those whose
+digests changed at the same size.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		srcProfile, dstProfile, err := loadProfiles(args[0], args[1])
+		if err != nil {
+			cmd.PrintErrf("%v\n", err)
+			return
+		}
+		printProfileChanges(cmd.OutOrStdout(), woc.DiffProfiles(srcProfile, dstProfile))
+	},
+}
+
+func init() {
+	profileMergeCmd.Flags().StringP("output", "o", "", "Output file for the merged profile, stdout by default")
+	profileCmd.AddCommand(profileMergeCmd)
+	addStrictFlag(profileDiffCmd)
+	profileCmd.AddCommand(profileDiffCmd)
+	profileScanCmd.Flags().StringP("output", "o", "", "Output file for the profile, stdout by default")
+	profileScanCmd.Flags().Bool("skip-digest", false, "Don't compute the digests, task generation computes the ones it needs then")
+	profileScanCmd.Flags().String("digest-algorithm", woc.DefaultDigestAlgorithm, "Digest algorithm of the profile: sample_md5, or md5, xxhash64 or blake3 with an optional :sampled or :full (default) suffix")
+	addDigestWorkersFlag(profileScanCmd)
+	profileCmd.AddCommand(profileScanCmd)
+	RootCmd.AddCommand(profileCmd)
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrintProfileChanges(t *testing.T) {
+	var out bytes.Buffer
+	printProfileChanges(&out, []woc.ProfileChange{
+		{Kind: woc.ChangeNewVersion, Dataset: "c2pFull", Old: "V2406", New: "V2412", OldSize: 1 << 30, NewSize: 3 << 29},
+		{Kind: woc.ChangeGrown, Dataset: "tree.idx", Shard: "tree_0.idx", OldSize: 1024, NewSize: 2048},
+		{Kind: woc.ChangeNewObject, Dataset: "blob.bin", OldSize: -1, NewSize: 10},
+	})
+	assert.Equal(t, `Change       Dataset   Shard       Destination  Source  Size
+------       -------   -----       -----------  ------  ----
+new version  c2pFull   -           V2406        V2412   1.0 GiB -> 1.5 GiB
+grown        tree.idx  tree_0.idx  -            -       1.0 KiB -> 2.0 KiB
+new object   blob.bin  -           -            -       10 B
+
+3 differences
+`, out.String())
+
+	out.Reset()
+	printProfileChanges(&out, nil)
+	assert.Equal(t, "The profiles are the same\n", out.String())
+}
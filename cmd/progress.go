@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hrz6976/syncmate/db"
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/rclone/rclone/fs"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// progressInterval is the interval between the overall progress lines of recv, 0 disables them
+var progressInterval = time.Minute
+
+func addProgressIntervalFlag(cmd *cobra.Command) {
+	cmd.Flags().DurationVar(&progressInterval, "progress-interval", time.Minute, "Interval between overall download and placement progress lines with an ETA (0 to disable)")
+}
+
+// dbProgressInterval is the interval between the updates of the bytes transferred of the tasks in flight, 0 disables them
+var dbProgressInterval = time.Minute
+
+func addDBProgressIntervalFlag(cmd *cobra.Command) {
+	cmd.Flags().DurationVar(&dbProgressInterval, "db-progress-interval", time.Minute, "Interval between updates of the bytes transferred of the files in flight in the database (0 to disable)")
+}
+
+// recordTaskProgress writes the bytes rclone transferred so far of the files in flight to their tasks
+func recordTaskProgress(dbHandle *db.DB, transfers []rclone.TransferProgress) {
+	if len(transfers) == 0 {
+		return
+	}
+	progress := make(map[string]int64, len(transfers))
+	for _, tr := range transfers {
+		progress[tr.Name] = tr.Bytes
+	}
+	if err := dbHandle.UpdateProgress(progress); err != nil {
+		logger.WithError(err).Warn("Failed to record the progress of the transfers in the database")
+	}
+}
+
+// placements are the bytes placed so far of the files being moved into their destination, by virtual path
+var placements sync.Map
+
+// placementProgress returns the bytes placed of the files being placed, by virtual path
+func placementProgress() map[string]int64 {
+	placed := make(map[string]int64)
+	placements.Range(func(key, value any) bool {
+		placed[key.(string)] = value.(int64)
+		return true
+	})
+	return placed
+}
+
+// recordPlacementProgress writes the bytes placed so far of the files being placed to their tasks
+func recordPlacementProgress(dbHandle *db.DB, placed map[string]int64) {
+	if len(placed) == 0 {
+		return
+	}
+	if err := dbHandle.UpdatePlacement(placed); err != nil {
+		logger.WithError(err).Warn("Failed to record the progress of the placements in the database")
+	}
+}
+
+// reportTaskProgress records the progress of the files in flight in the database every --db-progress-interval
+// until the returned func is called, so status on the other host shows how far the transfers are
+func reportTaskProgress() func() {
+	if dbHandle == nil || dbProgressInterval <= 0 {
+		return func() {}
+	}
+	handle := dbHandle
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(dbProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				recordTaskProgress(handle, rclone.InFlightTransfers())
+				recordPlacementProgress(handle, placementProgress())
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// recvProgress tracks the bytes of a recv run that are downloaded and placed
+type recvProgress struct {
+	start       time.Time
+	totalBytes  int64
+	totalFiles  int64
+	placedBytes atomic.Int64
+	placedFiles atomic.Int64
+}
+
+// activeProgress is the progress of the running recv, nil if none
+var activeProgress *recvProgress
+
+func newRecvProgress(tasks []*woc.WocSyncTask) *recvProgress {
+	p := &recvProgress{start: time.Now(), totalFiles: int64(len(tasks))}
+	for _, task := range tasks {
+		p.totalBytes += task.Size
+	}
+	return p
+}
+
+// place records a placed file
+func (p *recvProgress) place(size int64) {
+	if p == nil {
+		return
+	}
+	p.placedBytes.Add(size)
+	p.placedFiles.Add(1)
+}
+
+// line renders the progress given the bytes downloaded so far, with the bytes placed of the
+// files being placed. The ETA counts downloading and placing as one half of the work each.
+func (p *recvProgress) line(downloaded int64, now time.Time) string {
+	downloaded = min(downloaded, p.totalBytes)
+	placed := p.placedBytes.Load()
+	for _, n := range placementProgress() {
+		placed += n
+	}
+	placed = min(placed, p.totalBytes)
+	percent := func(n int64) float64 {
+		if p.totalBytes == 0 {
+			return 100
+		}
+		return 100 * float64(n) / float64(p.totalBytes)
+	}
+	eta := "unknown"
+	if p.totalBytes == 0 {
+		eta = "0s"
+	} else if done := float64(downloaded+placed) / float64(2*p.totalBytes); done > 0 {
+		elapsed := now.Sub(p.start)
+		eta = time.Duration(float64(elapsed) * (1 - done) / done).Round(time.Second).String()
+	}
+	return fmt.Sprintf("downloaded %s / %s (%.1f%%), placed %d / %d files, %s (%.1f%%), ETA %s",
+		fs.SizeSuffix(downloaded).ByteUnit(), fs.SizeSuffix(p.totalBytes).ByteUnit(), percent(downloaded),
+		p.placedFiles.Load(), p.totalFiles, fs.SizeSuffix(placed).ByteUnit(), percent(placed), eta)
+}
+
+// report logs the progress every --progress-interval until the returned func is called
+func (p *recvProgress) report() func() {
+	if progressInterval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				_, downloaded, _ := rclone.TransferStats()
+				logger.Info("Recv progress: " + p.line(downloaded, now))
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hrz6976/syncmate/db"
+	"github.com/hrz6976/syncmate/offsetfs"
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecvProgress(t *testing.T) {
+	p := newRecvProgress([]*woc.WocSyncTask{
+		{FileConfig: offsetfs.FileConfig{VirtualPath: "a", Size: 300 << 20}},
+		{FileConfig: offsetfs.FileConfig{VirtualPath: "b", Size: 100 << 20}},
+	})
+	start := p.start
+	assert.Equal(t, "downloaded 0 B / 400 MiB (0.0%), placed 0 / 2 files, 0 B (0.0%), ETA unknown", p.line(0, start))
+
+	// half downloaded, a quarter placed after 10 minutes: 3/8 done
+	p.place(100 << 20)
+	assert.Equal(t, "downloaded 200 MiB / 400 MiB (50.0%), placed 1 / 2 files, 100 MiB (25.0%), ETA 16m40s",
+		p.line(200<<20, start.Add(10*time.Minute)))
+
+	// nil progress ignores placements
+	var none *recvProgress
+	none.place(1)
+}
+
+func TestRecordTaskProgress(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	dbHandle := openTestDB(t, filepath.Join(tmpDir, "tasks.db"))
+	require.NoError(t, dbHandle.UpdateTask(&db.Task{VirtualPath: "a.bin", SrcSize: 10, Status: db.Uploaded}))
+
+	recordTaskProgress(dbHandle, []rclone.TransferProgress{{Name: "a.bin", Bytes: 4, Size: 10}})
+	task, err := dbHandle.GetTask("a.bin")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), task.TransferredBytes)
+	assert.Equal(t, "down 40%", formatProgress(task))
+
+	// a downloaded file being moved into its destination
+	require.NoError(t, dbHandle.UpdateTask(&db.Task{VirtualPath: "a.bin", SrcSize: 10, Status: db.Downloading}))
+	placements.Store("a.bin", int64(5))
+	defer placements.Delete("a.bin")
+	recordPlacementProgress(dbHandle, placementProgress())
+	task, err = dbHandle.GetTask("a.bin")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), task.PlacedBytes)
+	assert.Equal(t, "place 50%", formatProgress(task))
+}
@@ -0,0 +1,237 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/rclone/rclone/fs/rc"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func addRCFlags(cmd *cobra.Command) {
+	cmd.Flags().String("rc-addr", "", "Serve rclone's remote control API on this address (e.g. localhost:5572, :5572 binds to localhost too), disabled if empty")
+	cmd.Flags().String("rc-user", "", "User name of the basic auth of the remote control API")
+	cmd.Flags().String("rc-pass", "", "Password of the basic auth of the remote control API")
+	cmd.Flags().Bool("rc-dashboard", false, "Serve the web dashboard on the address of the remote control API")
+}
+
+// rcListenAddr returns addr with localhost as the host if it has none, e.g. for :5572
+func rcListenAddr(addr string) string {
+	if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+		return net.JoinHostPort("localhost", port)
+	}
+	return addr
+}
+
+// startRC serves rclone's remote control API if --rc-addr is set
+//
+// It returns a func which should be called to stop the server.
+func startRC(cmd *cobra.Command) (func(), error) {
+	addr, _ := cmd.Flags().GetString("rc-addr")
+	if addr == "" {
+		return func() {}, nil
+	}
+	addr = rcListenAddr(addr)
+	user, _ := cmd.Flags().GetString("rc-user")
+	pass, _ := cmd.Flags().GetString("rc-pass")
+	opt := rclone.RCOptions{Addr: addr, User: user, Pass: pass}
+	if dashboard, _ := cmd.Flags().GetBool("rc-dashboard"); dashboard {
+		dir, err := writeDashboard()
+		if err != nil {
+			return nil, err
+		}
+		opt.Files = dir
+	}
+	stop, err := rclone.StartRC(context.Background(), opt)
+	if err != nil {
+		if opt.Files != "" {
+			os.RemoveAll(opt.Files)
+		}
+		return nil, err
+	}
+	logger.WithField("addr", addr).Info("Serving the rclone remote control API")
+	if opt.Files != "" {
+		logger.WithField("url", "http://"+addr+"/").Info("Serving the dashboard")
+	}
+	return func() {
+		stop()
+		if opt.Files != "" {
+			os.RemoveAll(opt.Files)
+		}
+	}, nil
+}
+
+// injected holds the tasks given to syncmate/inject until the next cycle of the daemon takes them
+var injected struct {
+	mu sync.Mutex
+	// tasks is nil unless a daemon is running
+	tasks map[string]*woc.WocSyncTask
+	// wake starts the next cycle early
+	wake chan struct{}
+}
+
+// enableInjection makes syncmate/inject queue tasks for the cycles of the daemon
+//
+// It returns the channel that receives when tasks were injected.
+func enableInjection() <-chan struct{} {
+	injected.mu.Lock()
+	defer injected.mu.Unlock()
+	injected.tasks = make(map[string]*woc.WocSyncTask)
+	injected.wake = make(chan struct{}, 1)
+	return injected.wake
+}
+
+// injectTasks queues tasks for the next cycle, replacing the queued tasks with the same virtual paths
+func injectTasks(tasks []*woc.WocSyncTask) error {
+	for _, task := range tasks {
+		if task.VirtualPath == "" {
+			return errors.New("task has no virtual_path")
+		}
+	}
+	injected.mu.Lock()
+	defer injected.mu.Unlock()
+	if injected.tasks == nil {
+		return errors.New("tasks can only be injected into a daemon")
+	}
+	for _, task := range tasks {
+		injected.tasks[task.VirtualPath] = task
+	}
+	select {
+	case injected.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// takeInjectedTasks adds the queued tasks to tasksMap and empties the queue
+func takeInjectedTasks(tasksMap map[string]*woc.WocSyncTask) int {
+	injected.mu.Lock()
+	defer injected.mu.Unlock()
+	n := len(injected.tasks)
+	for virtualPath, task := range injected.tasks {
+		tasksMap[virtualPath] = task
+		delete(injected.tasks, virtualPath)
+	}
+	return n
+}
+
+func queuedTaskCount() int {
+	injected.mu.Lock()
+	defer injected.mu.Unlock()
+	return len(injected.tasks)
+}
+
+// rcTaskStatus is a task of the run in progress in the output of syncmate/status
+type rcTaskStatus struct {
+	VirtualPath string `json:"virtual_path"`
+	Status      string `json:"status"`
+	Size        int64  `json:"size"`
+	// Transferred is the bytes rclone copied so far, Placed those MoveFile placed
+	Transferred int64  `json:"transferred"`
+	Placed      int64  `json:"placed,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// runStatus returns the state of the run in progress for syncmate/status
+func runStatus() rc.Params {
+	out := rc.Params{
+		"running": false,
+		"paused":  rclone.TransfersPaused(),
+		"queued":  queuedTaskCount(),
+	}
+	run := currentRun.Load()
+	if run == nil {
+		return out
+	}
+	transferred := make(map[string]int64)
+	for _, tr := range rclone.InFlightTransfers() {
+		transferred[tr.Name] = tr.Bytes
+	}
+	placed := placementProgress()
+
+	run.tasksMu.Lock()
+	tasks := make([]rcTaskStatus, 0, len(run.tasks))
+	for _, report := range run.tasks {
+		task := rcTaskStatus{
+			VirtualPath: report.VirtualPath,
+			Status:      report.Status,
+			Size:        report.Size,
+			Transferred: report.Bytes,
+			Placed:      placed[report.VirtualPath],
+			Error:       report.Error,
+		}
+		if bytes, ok := transferred[report.VirtualPath]; ok {
+			task.Transferred = bytes
+		}
+		tasks = append(tasks, task)
+	}
+	run.tasksMu.Unlock()
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].VirtualPath < tasks[j].VirtualPath })
+
+	out["running"] = true
+	out["command"] = run.command
+	out["started"] = run.start.Format(time.RFC3339)
+	out["files"] = run.files.Load()
+	out["bytes"] = run.bytes.Load()
+	out["failures"] = run.failures.Load()
+	out["tasks"] = tasks
+	return out
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "syncmate/status",
+		Fn:    rcStatus,
+		Title: "Show the state of the run of syncmate",
+		Help: `This takes no parameters and returns
+
+- running - whether a send or recv run is in progress, the other fields below only if it is
+- command - send or recv
+- started - when the run started
+- files, bytes, failures - the files and bytes transferred so far and the failed files
+- tasks - the tasks the run started, each with virtual_path, status, size, the bytes transferred, the
+  bytes placed in the destination and the error of the task
+- paused - whether syncmate/pause paused the transfers
+- queued - the tasks syncmate/inject queued for the next cycle of the daemon
+`,
+	})
+	rc.Add(rc.Call{
+		Path:         "syncmate/inject",
+		Fn:           rcInject,
+		Title:        "Add tasks to the next cycle of the syncmate daemon",
+		AuthRequired: true,
+		Help: `This takes the following parameters:
+
+- tasks - a list of tasks, as in the lines of the tasks file of taskgen
+
+The next cycle transfers them along with the tasks of the profiles, replacing the tasks with the same
+virtual paths, and starts at once if the daemon is waiting for it. Both the send and the recv daemon
+need the tasks.
+`,
+	})
+}
+
+func rcStatus(ctx context.Context, in rc.Params) (rc.Params, error) {
+	return runStatus(), nil
+}
+
+func rcInject(ctx context.Context, in rc.Params) (rc.Params, error) {
+	var tasks []*woc.WocSyncTask
+	if err := in.GetStruct("tasks", &tasks); err != nil {
+		return nil, err
+	}
+	if err := injectTasks(tasks); err != nil {
+		return nil, fmt.Errorf("failed to inject tasks: %w", err)
+	}
+	logger.WithField("taskCount", len(tasks)).Info("Injected tasks into the next cycle")
+	return rc.Params{"queued": queuedTaskCount()}, nil
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hrz6976/syncmate/db"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRCListenAddr(t *testing.T) {
+	assert.Equal(t, "localhost:5572", rcListenAddr(":5572"))
+	assert.Equal(t, "0.0.0.0:5572", rcListenAddr("0.0.0.0:5572"))
+	assert.Equal(t, "localhost:5572", rcListenAddr("localhost:5572"))
+}
+
+func TestRCInject(t *testing.T) {
+	t.Cleanup(func() {
+		injected.mu.Lock()
+		defer injected.mu.Unlock()
+		injected.tasks, injected.wake = nil, nil
+	})
+	in := rc.Params{"tasks": []any{
+		map[string]any{"virtual_path": "blob_0.bin", "source_path": "/data/blob_0.bin", "size": 100, "target_path": "/dst/blob_0.bin"},
+	}}
+
+	// only a daemon takes tasks
+	_, err := rcInject(context.Background(), in)
+	assert.Error(t, err)
+
+	wake := enableInjection()
+	out, err := rcInject(context.Background(), in)
+	require.NoError(t, err)
+	assert.Equal(t, 1, out["queued"])
+	select {
+	case <-wake:
+	default:
+		t.Fatal("injecting didn't wake the daemon")
+	}
+	_, err = rcInject(context.Background(), rc.Params{"tasks": []any{map[string]any{"size": 1}}})
+	assert.Error(t, err)
+
+	tasksMap := map[string]*woc.WocSyncTask{"blob_0.bin": {}, "blob_1.bin": {}}
+	assert.Equal(t, 1, takeInjectedTasks(tasksMap))
+	require.Len(t, tasksMap, 2)
+	assert.Equal(t, "/dst/blob_0.bin", tasksMap["blob_0.bin"].TargetPath)
+	assert.Equal(t, int64(100), tasksMap["blob_0.bin"].Size)
+	assert.Equal(t, 0, queuedTaskCount())
+}
+
+func TestRCStatus(t *testing.T) {
+	out, err := rcStatus(context.Background(), rc.Params{})
+	require.NoError(t, err)
+	assert.Equal(t, false, out["running"])
+	assert.Equal(t, false, out["paused"])
+
+	tasksMap := newOrderTestTasks()
+	startRun("recv")
+	defer currentRun.Store(nil)
+	recordTransfer(200)
+	recordTaskStatus(tasksMap["blob_1.bin"], db.Downloading, nil)
+	recordTaskStatus(tasksMap["blob_0.bin"], db.Downloaded, nil)
+
+	out, err = rcStatus(context.Background(), rc.Params{})
+	require.NoError(t, err)
+	assert.Equal(t, true, out["running"])
+	assert.Equal(t, "recv", out["command"])
+	assert.Equal(t, int64(1), out["files"])
+	assert.Equal(t, []rcTaskStatus{
+		{VirtualPath: "blob_0.bin", Status: "Downloaded", Size: 200, Transferred: 200},
+		{VirtualPath: "blob_1.bin", Status: "Downloading", Size: 100},
+	}, out["tasks"])
+}
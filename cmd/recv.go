@@ -2,22 +2,25 @@ package cmd
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/hrz6976/syncmate/db"
+	"github.com/hrz6976/syncmate/metrics"
 	"github.com/hrz6976/syncmate/rclone"
+	"github.com/hrz6976/syncmate/tracing"
 	"github.com/hrz6976/syncmate/woc"
 	"github.com/rclone/rclone/fs"
-	"github.com/rclone/rclone/fs/operations"
 	logger "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // downloadedFileInfo 包含已下载文件的信息
@@ -27,18 +30,75 @@ type downloadedFileInfo struct {
 	destPath string
 }
 
-func onFileTransferred(task *woc.WocSyncTask, filePath string, destPath string, finishedCallback func(virtualPath string) error) error {
-	isPartial := strings.Contains(filePath, ".offset.")
-	copyMode := woc.CopyModeOverwrite
+// transferMetas are the checked sidecars of the files being downloaded, by virtual path
+var transferMetas sync.Map
+
+// transferMeta returns the sidecar uploaded with the file of task, or the transfer described by the task for files without one
+func transferMeta(task *woc.WocSyncTask) *woc.TransferMeta {
+	if meta, ok := transferMetas.Load(task.VirtualPath); ok {
+		return meta.(*woc.TransferMeta)
+	}
+	return woc.NewTransferMeta(task)
+}
+
+// loadTransferMeta reads and checks the sidecar of task from R2
+func loadTransferMeta(ctx context.Context, fsrc fs.Fs, task *woc.WocSyncTask) error {
+	data, err := rclone.ReadFile(ctx, fsrc, woc.MetaPath(task.VirtualPath))
+	if err != nil {
+		return fmt.Errorf("failed to read transfer metadata: %w", err)
+	}
+	meta, err := woc.ParseTransferMeta(data)
+	if err != nil {
+		return err
+	}
+	if err := meta.Check(task); err != nil {
+		return err
+	}
+	transferMetas.Store(task.VirtualPath, meta)
+	return nil
+}
+
+// loadObjectMetadata checks task against the metadata send attached to its object of size bytes, for
+// files without a sidecar. Objects uploaded without metadata are accepted.
+func loadObjectMetadata(ctx context.Context, fsrc fs.Fs, task *woc.WocSyncTask, size int64) error {
+	metadata, err := rclone.ReadMetadata(ctx, fsrc, task.VirtualPath)
+	if err != nil {
+		return fmt.Errorf("failed to read the object metadata: %w", err)
+	}
+	meta, err := woc.ParseObjectMetadata(task.VirtualPath, size, metadata)
+	if err != nil || meta == nil {
+		return err
+	}
+	if meta.RunID != runID {
+		return fmt.Errorf("object was uploaded by run %q, not %q", meta.RunID, runID)
+	}
+	if err := meta.Check(task); err != nil {
+		return err
+	}
+	logger.WithFields(logger.Fields{
+		"virtualPath": task.VirtualPath,
+		"sourceHost":  meta.SourceHost,
+		"sourcePath":  meta.SourcePath,
+	}).Debug("Read the transfer from the object metadata")
+	transferMetas.Store(task.VirtualPath, &meta.TransferMeta)
+	return nil
+}
+
+func onFileTransferred(ctx context.Context, task *woc.WocSyncTask, filePath string, destPath string, finishedCallback func(virtualPath string) error) (err error) {
+	ctx, span := tracing.Start(ctx, "place", attribute.String("syncmate.virtual_path", task.VirtualPath), attribute.Int64("syncmate.bytes", task.Size))
+	defer func() { tracing.End(span, err) }()
+	meta := transferMeta(task)
+	copyMode, err := meta.CopyMode()
+	if err != nil {
+		return err
+	}
+	srcDigester, err := task.SourceDigester()
+	if err != nil {
+		return err
+	}
 	var expectedDstSizeBeforeTransfer int64
-	if isPartial {
-		copyMode = woc.CopyModeAppend
-		parts := strings.Split(filePath, ".offset.")
-		if len(parts) > 1 {
-			if size, err := strconv.ParseInt(parts[len(parts)-1], 10, 64); err == nil {
-				expectedDstSizeBeforeTransfer = size
-			}
-		}
+	if copyMode == woc.CopyModeAppend {
+		expectedDstSizeBeforeTransfer = meta.Offset
 		// Recover from unexpected interrupts
 		var dstSize int64
 		if stat, err := os.Stat(destPath); err != nil {
@@ -48,21 +108,25 @@ func onFileTransferred(task *woc.WocSyncTask, filePath string, destPath string,
 			dstSize = stat.Size()
 		}
 		if dstSize > expectedDstSizeBeforeTransfer {
-			// calculate sample md5
-			dstPartMd5, err := woc.SampleMD5(destPath, 0, expectedDstSizeBeforeTransfer)
+			// calculate the digest of the part the destination profile has
+			dstDigester, err := task.TargetDigester()
 			if err != nil {
-				logger.WithError(err).Errorf("Failed to calculate sample md5 for destination file %s", destPath)
 				return err
 			}
-			if task.TargetDigest != nil && dstPartMd5.Digest == *task.TargetDigest {
-				logger.Warnf("Recovering from unexpected interrupt, destination file md5: %s, expected size: %d, current size: %d", dstPartMd5.Digest, expectedDstSizeBeforeTransfer, dstSize)
+			dstPartDigest, err := dstDigester.Digest(destPath, 0, expectedDstSizeBeforeTransfer)
+			if err != nil {
+				logger.WithError(err).Errorf("Failed to calculate %s digest for destination file %s", dstDigester.Name(), destPath)
+				return err
+			}
+			if task.TargetDigest != nil && dstPartDigest == *task.TargetDigest {
+				logger.Warnf("Recovering from unexpected interrupt, destination file digest: %s, expected size: %d, current size: %d", dstPartDigest, expectedDstSizeBeforeTransfer, dstSize)
 				// trunc file
 				if err := os.Truncate(destPath, expectedDstSizeBeforeTransfer); err != nil {
 					logger.WithError(err).Errorf("Failed to truncate destination file %s", destPath)
 					return err
 				}
 			} else {
-				logger.Warnf("Destination file md5 mismatch, expected: %s, got: %s", *task.TargetDigest, dstPartMd5.Digest)
+				logger.Warnf("Destination file digest mismatch, expected: %s, got: %s", *task.TargetDigest, dstPartDigest)
 			}
 		}
 	}
@@ -70,28 +134,65 @@ func onFileTransferred(task *woc.WocSyncTask, filePath string, destPath string,
 	if task.SourceDigest != nil {
 		sourceDigest = *task.SourceDigest
 	}
-	err := woc.MoveFile(
+	progressItem := rclone.AddProgressItem(task.VirtualPath, task.Size)
+	onProgress := func(p woc.MoveProgress) {
+		progressItem.Set(p.Copied)
+		placements.Store(task.VirtualPath, p.Copied)
+	}
+	moveStart := time.Now()
+	_, moveSpan := tracing.Start(ctx, "MoveFile", attribute.String("syncmate.mode", meta.Mode), attribute.String("syncmate.dest_path", destPath))
+	err = woc.MoveFile(
+		ctx,
 		filePath,
 		destPath,
 		copyMode,
+		srcDigester,
 		sourceDigest,
 		expectedDstSizeBeforeTransfer,
+		onProgress,
 	)
+	tracing.End(moveSpan, err)
+	placements.Delete(task.VirtualPath)
+	progressItem.Done()
+	metrics.ObserveMoveFile(time.Since(moveStart))
 	if err != nil {
 		return err
 	}
+	placedFiles.Store(task.VirtualPath, true)
+	recordTransfer(task.Size)
+	activeProgress.place(task.Size)
+	recordTaskStatus(task, db.Downloaded, nil)
+	recordPlacement(task, time.Since(moveStart))
+	metrics.SetTaskState(task.VirtualPath, db.Downloaded.String())
+	hookVars := map[string]string{
+		"dst":     destPath,
+		"virtual": task.VirtualPath,
+		"size":    strconv.FormatInt(task.Size, 10),
+	}
+	if err := runHook(context.Background(), "on_file_done", fileDoneHook(), hookVars); err != nil {
+		// the file is in place, a failed hook doesn't undo that
+		logger.WithError(err).WithField("file", task.VirtualPath).Error("File hook failed")
+	}
 	if dbHandle == nil {
 		return nil
 	}
-	err = dbHandle.UpdateTask(&db.Task{
-		VirtualPath: task.VirtualPath,
-		SrcPath:     task.SourcePath,
-		DstPath:     destPath,
-		SrcSize:     task.Size,
-		SrcDigest:   sourceDigest,
-		DstSize:     task.Size,
-		Status:      db.Downloaded,
-	})
+	row := &db.Task{
+		VirtualPath:        task.VirtualPath,
+		SrcPath:            task.SourcePath,
+		DstPath:            destPath,
+		SrcSize:            task.Size,
+		SrcDigest:          sourceDigest,
+		DstSize:            task.Size,
+		Status:             db.Downloaded,
+		SrcDigestAlgorithm: task.SourceDigestAlgorithm,
+	}
+	if tr, ok := rclone.FindSuccessfulTransfer(task.VirtualPath); ok {
+		row.TransferStartedAt = &tr.StartedAt
+		row.TransferEndedAt = &tr.CompletedAt
+	}
+	_, dbSpan := tracing.Start(ctx, "db update task")
+	err = dbHandle.UpdateTask(row)
+	tracing.End(dbSpan, err)
 	if err != nil {
 		logger.WithError(err).Errorf("Failed to update task for %s", task.VirtualPath)
 		return err
@@ -121,8 +222,18 @@ func processDoneFiles(
 
 	logger.WithField("fileCount", len(downloadedFiles)).Info("Found downloaded files, processing with goroutines")
 
-	const maxConcurrency = 10
-	semaphore := make(chan struct{}, maxConcurrency)
+	priorities, err := loadTaskPriorities(transferOrder)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to load task priorities, ignoring them")
+	}
+	sort.SliceStable(downloadedFiles, func(i, j int) bool {
+		return taskLess(downloadedFiles[i].task, downloadedFiles[j].task, transferOrder, priorities)
+	})
+
+	limiter, err := newMoveLimiter(moveWorkers, fsMoveWorkers)
+	if err != nil {
+		return err
+	}
 
 	var wg sync.WaitGroup
 	errChan := make(chan error, len(downloadedFiles))
@@ -147,8 +258,8 @@ fileLoop:
 		go func(info downloadedFileInfo) {
 			defer wg.Done()
 
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }()
+			release := limiter.acquire(info.destPath)
+			defer release()
 
 			// Check for cancellation before processing each file
 			select {
@@ -158,8 +269,19 @@ fileLoop:
 			default:
 			}
 
-			if err := onFileTransferred(info.task, info.filePath, info.destPath, finishedCallback); err != nil {
+			if err := onFileTransferred(ctx, info.task, info.filePath, info.destPath, finishedCallback); err != nil {
+				if ctx.Err() != nil {
+					// aborted by the second interrupt, the file stays in the cache for the next run
+					logger.WithError(err).WithField("file", info.task.VirtualPath).Warn("File processing aborted")
+					return
+				}
 				logger.WithError(err).WithField("file", info.task.VirtualPath).Error("Failed to process transferred file")
+				recordFailure()
+				recordTaskStatus(info.task, db.Failed, err)
+				metrics.SetTaskState(info.task.VirtualPath, db.Failed.String())
+				if _, qerr := fileFailures.fail(info.task, info.filePath, err); qerr != nil {
+					logger.WithError(qerr).WithField("file", info.task.VirtualPath).Error("Failed to quarantine file")
+				}
 				errChan <- err
 			} else {
 				logger.WithField("file", info.task.VirtualPath).Debug("Successfully processed transferred file")
@@ -223,6 +345,9 @@ func scanDownloadedFiles(tasksMap map[string]*woc.WocSyncTask) ([]downloadedFile
 		}
 
 		if info.IsDir() {
+			if filePath == quarantinePath() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
@@ -251,18 +376,15 @@ func scanDownloadedFiles(tasksMap map[string]*woc.WocSyncTask) ([]downloadedFile
 			return nil
 		}
 
-		var destPath string
-		if task.TargetPath != "" {
-			destPath = task.TargetPath
-		} else {
+		destPath := taskDestPath(task)
+		if task.TargetPath == "" {
 			logger.WithField("virtualPath", virtualPath).Debug("No target path specified for task, using default destination")
-			dirPath := filepath.Join(destDir, virtualPathToSubdir(virtualPath))
+			dirPath := filepath.Dir(destPath)
 			// create destination directory if it doesn't exist
 			if err := os.MkdirAll(dirPath, 0755); err != nil {
 				logger.WithError(err).WithField("dirPath", dirPath).Error("Failed to create destination directory")
 				return err
 			}
-			destPath = filepath.Join(dirPath, virtualPath)
 		}
 
 		// check file size
@@ -304,45 +426,80 @@ func runRecv(
 ) error {
 	var err error
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(tracing.Context())
 	defer cancel()
 
-	syncCtx := rclone.InjectConfig(ctx)
-	r2Creds := &rclone.CloudflareR2Credentials{
-		AccessKey: config.AccessKey,
-		SecretKey: config.SecretKey,
-		AccountID: config.AccountID,
-		Bucket:    config.Bucket,
-	}
+	// The first interrupt finishes the files being downloaded, the second one aborts
+	stopCtx, softStop := rclone.InjectSoftStop(ctx)
+	stopInterrupts := handleInterrupts(softStop, cancel)
+	defer stopInterrupts()
+
+	syncCtx := rclone.InjectConfig(stopCtx)
 	fdst, err := fs.NewFs(syncCtx, cacheDir)
 	if err != nil {
 		logger.WithError(err).Error("Failed to create local filesystem")
 		return err
 	}
-	fsrc, err := rclone.NewR2Backend(syncCtx, r2Creds)
+	fsrc, err := newRecvRemote(syncCtx)
 	if err != nil {
 		logger.WithError(err).Error("Failed to create R2 backend")
 		return err
 	}
 
+	fileFailures.reset()
+
 	logger.Info("Getting existing files from R2...")
-	existingFiles, err := rclone.ListFiles(syncCtx, fsrc)
+	remoteFiles, err := rclone.ListFiles(syncCtx, fsrc)
 	if err != nil {
 		logger.WithError(err).Error("Failed to list files from R2")
 	}
+	// sidecars, deltas and signatures are handled together with their files
+	var existingFiles []rclone.RcloneFileInfo
+	existing := make(map[string]bool)
+	metaFiles := make(map[string]bool)
+	deltaFiles := make(map[string]bool)
+	signatureFiles := make(map[string]bool)
+	for _, finfo := range remoteFiles {
+		switch {
+		case woc.IsMetaPath(finfo.Name):
+			metaFiles[finfo.Name] = true
+		case strings.HasSuffix(finfo.Name, woc.DeltaSuffix):
+			deltaFiles[strings.TrimSuffix(finfo.Name, woc.DeltaSuffix)] = true
+		case strings.HasSuffix(finfo.Name, woc.SignatureSuffix):
+			signatureFiles[strings.TrimSuffix(finfo.Name, woc.SignatureSuffix)] = true
+		default:
+			existingFiles = append(existingFiles, finfo)
+			existing[finfo.Name] = true
+		}
+	}
 
 	deleteFileFunc := func(virtualPath string) error {
 		return nil
 	}
+	var cleanup func(virtualPath string) error
 	if deleteRemote {
+		cleanup, err = newRemoteCleanup(syncCtx, fsrc)
+		if err != nil {
+			return err
+		}
 		deleteFileFunc = func(virtualPath string) error {
-			logger.WithField("virtualPath", virtualPath).Debug("Deleting file on R2")
-			fobj, err := fsrc.NewObject(syncCtx, virtualPath)
-			if err != nil {
-				logger.WithError(err).WithField("virtualPath", virtualPath).Error("Failed to get object on R2")
+			// a file rebuilt from its delta has no object
+			object := virtualPath
+			if deltaFiles[virtualPath] {
+				object = woc.DeltaPath(virtualPath)
+			}
+			if err := cleanup(object); err != nil {
 				return err
 			}
-			return operations.DeleteFile(syncCtx, fobj)
+			if signatureFiles[virtualPath] {
+				if err := cleanup(woc.SignaturePath(virtualPath)); err != nil {
+					return err
+				}
+			}
+			if metaFiles[woc.MetaPath(virtualPath)] {
+				return cleanup(woc.MetaPath(virtualPath))
+			}
+			return nil
 		}
 	}
 
@@ -351,6 +508,12 @@ func runRecv(
 		return err
 	}
 
+	// files quarantined by earlier runs wait for `syncmate retry recv`
+	deadLetters, err := listDeadLetters()
+	if err != nil {
+		return fmt.Errorf("failed to list failed tasks: %w", err)
+	}
+
 	// update task list
 	ignoredFilesMap := make(map[string]bool)
 	if dbHandle != nil {
@@ -363,22 +526,30 @@ func runRecv(
 		}
 	}
 
-	// if some files are finished but not deleted on R2, run deleteFileFunc for them
-	for _, finfo := range existingFiles {
-		if _, ok := ignoredFilesMap[finfo.Name]; ok {
-			// run delete
-			deleteFileFunc(finfo.Name)
+	// if some files are finished but not deleted on R2, remove them with their sidecars, deltas and signatures
+	if deleteRemote {
+		var finished []string
+		for _, finfo := range remoteFiles {
+			if virtualPath, _ := woc.CompanionOf(finfo.Name); ignoredFilesMap[virtualPath] {
+				finished = append(finished, finfo.Name)
+			}
 		}
+		removeFinishedFiles(syncCtx, fsrc, cleanup, finished)
 	}
 
 	// what files need to sync?
-	fileList := make([]string, 0)
+	transferMetas.Clear()
+	toSync := make(map[string]*woc.WocSyncTask)
 	for _, finfo := range existingFiles {
 		// if it is ignored, skip it
 		if _, ok := ignoredFilesMap[finfo.Name]; ok {
 			logger.WithField("virtualPath", finfo.Name).Debug("File is ignored, skipping")
 			continue
 		}
+		if deadLetters[finfo.Name] {
+			logger.WithField("virtualPath", finfo.Name).Warn("Task is marked Failed, skipping file until it is retried")
+			continue
+		}
 		// get task by virtual path
 		task, exists := tasksMap[finfo.Name]
 		if !exists {
@@ -397,22 +568,127 @@ func runRecv(
 			}).Warn("File size mismatch, skipping file")
 			continue // skip files with size mismatch
 		}
-		fileList = append(fileList, finfo.Name)
+		if metaFiles[woc.MetaPath(finfo.Name)] {
+			if err := loadTransferMeta(syncCtx, fsrc, task); err != nil {
+				logger.WithError(err).WithField("virtualPath", finfo.Name).Warn("Invalid transfer metadata, skipping file")
+				continue
+			}
+		} else if err := loadObjectMetadata(syncCtx, fsrc, task, finfo.Size); err != nil {
+			logger.WithError(err).WithField("virtualPath", finfo.Name).Warn("Invalid object metadata, skipping file")
+			continue
+		}
+		toSync[finfo.Name] = task
 		logger.WithFields(logger.Fields{
 			"virtualPath": finfo.Name,
 			"size":        finfo.Size,
 		}).Debug("Found existing file in R2")
 	}
+	// the files send --delta uploaded the delta of are rebuilt from their destination file
+	var rebuilt []*woc.WocSyncTask
+	for virtualPath := range deltaFiles {
+		task, exists := tasksMap[virtualPath]
+		if !exists || task == nil || ignoredFilesMap[virtualPath] || deadLetters[virtualPath] || existing[virtualPath] {
+			continue
+		}
+		if metaFiles[woc.MetaPath(virtualPath)] {
+			if err := loadTransferMeta(syncCtx, fsrc, task); err != nil {
+				logger.WithError(err).WithField("virtualPath", virtualPath).Warn("Invalid transfer metadata, skipping delta")
+				continue
+			}
+		}
+		rebuilt = append(rebuilt, task)
+	}
+	if deltaTransfers {
+		publishSignatures(syncCtx, fsrc, sortedTasks(tasksMap), func(virtualPath string) bool {
+			return ignoredFilesMap[virtualPath] || deadLetters[virtualPath] || existing[virtualPath] ||
+				deltaFiles[virtualPath] || signatureFiles[virtualPath]
+		})
+	}
+	if dbHandle != nil {
+		changed, err := changedObjects(syncCtx, fsrc, dbHandle, toSync)
+		if err != nil {
+			logger.WithError(err).Warn("Failed to compare the objects with their upload, downloading them anyway")
+		}
+		for virtualPath, reason := range changed {
+			logger.WithField("virtualPath", virtualPath).Warnf("Object changed since it was uploaded, %s, skipping file", reason)
+			delete(toSync, virtualPath)
+		}
+	}
+	priorities, err := loadTaskPriorities(transferOrder)
+	if err != nil {
+		return fmt.Errorf("failed to load task priorities: %w", err)
+	}
+	selected, remaining := limitTasks(orderTasks(toSync, transferOrder, priorities), int64(maxBytes), maxFiles)
+	if len(remaining) > 0 {
+		logger.WithFields(logger.Fields{
+			"selected":  len(selected),
+			"remaining": len(remaining),
+		}).Info("Transfer limits reached, leaving remaining files on R2 for the next run")
+	}
+	for _, task := range selected {
+		metrics.SetTaskState(task.VirtualPath, db.Downloading.String())
+		recordTaskStatus(task, db.Downloading, nil)
+	}
+	for _, task := range remaining {
+		recordTaskStatus(task, db.Pending, nil)
+	}
+	for _, task := range rebuilt {
+		metrics.SetTaskState(task.VirtualPath, db.Downloading.String())
+		recordTaskStatus(task, db.Downloading, nil)
+	}
+	spaces, err := planDiskSpace(append(slices.Clone(selected), rebuilt...))
+	if err != nil {
+		return err
+	}
+	if !skipSpaceCheck {
+		if err := checkDiskSpace(spaces); err != nil {
+			return err
+		}
+	}
+	var watchDirs []string
+	placedFiles.Clear()
+	for _, s := range spaces {
+		watchDirs = append(watchDirs, s.dir)
+	}
+	batches := batchTasks(selected, transferOrder, priorities)
+	fileList := make([]string, 0, len(selected))
+	for _, batch := range batches {
+		fileList = append(fileList, batch...)
+	}
+	// processDoneFiles places the rebuilt files with the downloaded ones
+	rebuildDeltas(syncCtx, fsrc, fdst, rebuilt)
+
 	// inject file list into context
+	syncCtx = rclone.InjectOrderBy(syncCtx, rcloneOrderBy(transferOrder))
 	syncCtx = rclone.InjectFileList(syncCtx, fileList)
 
+	activeProgress = newRecvProgress(selected)
+	defer func() { activeProgress = nil }()
+	stopProgress := activeProgress.report()
+	defer stopProgress()
+	stopTaskProgress := reportTaskProgress()
+	defer stopTaskProgress()
+
 	downloadDone := make(chan error, 1)
+	// closed when CopyFiles returns, to wake up the processing loop
+	copyFinished := make(chan struct{})
+	// notified when downloads pause for disk space, to place the downloaded files right away
+	paused := make(chan struct{}, 1)
 	var copyErr error
 
 	// Start the CopyFiles operation in background
 	go func() {
+		defer close(copyFinished)
 		downloadDone <- rclone.Run(syncCtx, func() error {
-			return rclone.CopyFiles(syncCtx, fsrc, fdst, fileList)
+			for _, batch := range batches {
+				if softStop.Stopped() {
+					return nil
+				}
+				if err := copyBatch(syncCtx, fsrc, fdst, batch, watchDirs, softStop, paused); err != nil {
+					return err
+				}
+			}
+			return nil
 		})
 	}()
 
@@ -424,6 +700,7 @@ func runRecv(
 		if err := processDoneFiles(ctx, tasksMap, deleteFileFunc); err != nil {
 			logger.WithError(err).Warn("processDoneFiles failed, will retry in next iteration")
 		}
+		beatWatchdog()
 
 		// After processDoneFiles completes, check if CopyFiles has finished
 		select {
@@ -436,7 +713,10 @@ func runRecv(
 					copyErr = err // Only override if CopyFiles succeeded
 				}
 			}
-			if copyErr != nil {
+			if softStop.Stopped() && (copyErr == nil || rclone.IsSoftStopped(copyErr)) {
+				logger.Info("Download stopped after finishing in-flight files")
+				copyErr = errStopped
+			} else if copyErr != nil {
 				logger.WithError(copyErr).Error("File upload failed")
 			} else {
 				logger.Info("File upload completed successfully")
@@ -452,6 +732,10 @@ func runRecv(
 			case <-ctx.Done():
 				logger.Info("Upload cancelled by user interrupt")
 				return fmt.Errorf("upload cancelled by user interrupt")
+			case <-copyFinished:
+				// Process the last files right away
+			case <-paused:
+				// Free disk space by placing the downloaded files
 			case <-time.After(5 * time.Minute):
 				// Continue processing loop
 			}
@@ -459,26 +743,46 @@ func runRecv(
 	}
 }
 
-// find which subdirectory the virtual path belongs to
-func virtualPathToSubdir(virtualPath string) string {
-	// if it contains .idx or .bin, goes to All.blobs
-	if strings.Contains(virtualPath, ".idx") || strings.Contains(virtualPath, ".bin") {
-		return "All.blobs"
+// changedObjects returns how the objects of toSync differ from the objects send recorded when it uploaded them,
+// by virtual path. Objects uploaded without an ETag are not checked.
+func changedObjects(ctx context.Context, fsrc fs.Fs, dbHandle *db.DB, toSync map[string]*woc.WocSyncTask) (map[string]string, error) {
+	uploaded, err := dbHandle.ListUploadedObjects()
+	if err != nil {
+		return nil, err
 	}
-	// if it contains .s, goes to gz
-	if strings.Contains(virtualPath, ".s") {
-		return "gz"
+	var names []string
+	for virtualPath := range toSync {
+		if _, ok := uploaded[virtualPath]; ok {
+			names = append(names, virtualPath)
+		}
 	}
-	// if it starts with sha1., goes to All.sha1o
-	if strings.HasPrefix(virtualPath, "sha1.") {
-		return "All.sha1o"
+	if len(names) == 0 {
+		return nil, nil
 	}
-	// if it has Full in it, goes to basemaps
-	if strings.Contains(virtualPath, "Full") {
-		return "basemaps"
+	objects, err := rclone.StatFiles(ctx, fsrc, names)
+	if err != nil {
+		return nil, err
 	}
-	// otherwise goes to All.sha1c
-	return "All.sha1c"
+	changed := make(map[string]string)
+	for name, object := range objects {
+		if reason := objectChanged(uploaded[name].ETag, uploaded[name].ObjectSize, object); reason != "" {
+			changed[name] = reason
+		}
+	}
+	return changed, nil
+}
+
+// taskDestPath returns where a downloaded task is placed: its target path, or the default destination
+func taskDestPath(task *woc.WocSyncTask) string {
+	if task.TargetPath != "" {
+		return task.TargetPath
+	}
+	return filepath.Join(destDir, virtualPathToSubdir(task.VirtualPath), task.VirtualPath)
+}
+
+// find which subdirectory the virtual path belongs to
+func virtualPathToSubdir(virtualPath string) string {
+	return routing().Subdir(virtualPath)
 }
 
 var cacheDir string
@@ -496,38 +800,62 @@ var recvCmd = &cobra.Command{
 		cacheDir, _ = cmd.Flags().GetString("cache-dir")
 		destDir, _ = cmd.Flags().GetString("dest-dir")
 		deleteRemote, _ := cmd.Flags().GetBool("delete-remote")
+		verifyOnly, _ := cmd.Flags().GetBool("verify-only")
+		orderFlag, _ := cmd.Flags().GetString("order")
 
 		if destDir == "" {
 			destDir = cacheDir // use cacheDir as default destination directory
 		}
+		if directHost != "" && deleteRemote {
+			// the mount of send --direct is read-only, send stops serving the files once they are Downloaded
+			logger.Info("Not deleting the files on the --direct host")
+			deleteRemote = false
+		}
 
 		if srcPath == "" || dstPath == "" || configPath == "" {
 			cmd.Help()
 			return
 		}
 
-		if configData, err := os.ReadFile(configPath); err != nil {
-			cmd.PrintErrf("Failed to read config file %s: %v\n", configPath, err)
+		var err error
+		if transferOrder, err = parseTaskOrder(orderFlag); err != nil {
+			cmd.PrintErrf("Invalid --order: %v\n", err)
 			return
-		} else {
-			if err := json.Unmarshal(configData, &config); err != nil {
-				cmd.PrintErrf("Failed to parse config file %s: %v\n", configPath, err)
-				return
-			}
 		}
-
-		srcProfile, err := woc.ParseWocProfile(&srcPath)
+		filter, err := taskFilterFromFlags(cmd)
 		if err != nil {
-			cmd.PrintErrf("Failed to parse source profile: %v\n", err)
+			cmd.PrintErrf("Invalid filter: %v\n", err)
+			return
+		}
+
+		if err := loadConfig(configPath); err != nil {
+			cmd.PrintErrf("%v\n", err)
+			return
+		}
+		if err := applyMultiThreadFlags(); err != nil {
+			cmd.PrintErrf("Invalid multi-thread download flags: %v\n", err)
 			return
 		}
-		dstProfile, err := woc.ParseWocProfile(&dstPath)
+
+		// the profiles are only needed to generate the tasks
+		var srcProfile, dstProfile *woc.ParsedWocProfile
+		if tasksFile == "" {
+			srcProfile, dstProfile, err = loadProfiles(srcPath, dstPath)
+			if err != nil {
+				cmd.PrintErrf("%v\n", err)
+				return
+			}
+		}
+
+		releaseLock, err := acquireRunLock(runLockPath("recv"))
 		if err != nil {
-			cmd.PrintErrf("Failed to parse destination profile: %v\n", err)
+			cmd.PrintErrf("%v\n", err)
 			return
 		}
+		defer releaseLock()
 
-		if !skipDB {
+		// verification doesn't touch the database
+		if !skipDB && !verifyOnly {
 			_, err = connectDB()
 			if err != nil {
 				cmd.PrintErrf("Failed to connect to database: %v\n", err)
@@ -535,16 +863,47 @@ var recvCmd = &cobra.Command{
 			}
 		}
 
-		tasksMap, err := generateTasks(srcProfile, dstProfile, false)
+		stopTracing, err := startTracing(cmd, "recv")
+		if err != nil {
+			cmd.PrintErrf("Failed to export traces: %v\n", err)
+			return
+		}
+		defer stopTracing()
+
+		tasksMap, err := loadTasks(srcProfile, dstProfile, false)
 		if err != nil {
 			cmd.PrintErrf("Failed to generate tasks: %v\n", err)
 			return
 		}
+		filterTasks(tasksMap, filter)
+
+		if verifyOnly {
+			if summary := runVerify(cmd.OutOrStdout(), tasksMap); len(summary.Mismatches) > 0 {
+				cmd.PrintErrf("Found %d mismatched files\n", len(summary.Mismatches))
+			}
+			return
+		}
 
 		logger.WithField("taskCount", len(tasksMap)).Info("Generated tasks for file transfer")
 
+		stopMetrics, err := startMetrics(cmd, "download")
+		if err != nil {
+			cmd.PrintErrf("Failed to serve metrics: %v\n", err)
+			return
+		}
+		defer stopMetrics()
+		stopRC, err := startRC(cmd)
+		if err != nil {
+			cmd.PrintErrf("Failed to serve the remote control API: %v\n", err)
+			return
+		}
+		defer stopRC()
+
 		if len(tasksMap) > 0 {
-			if err := runRecv(cacheDir, tasksMap, deleteRemote); err != nil {
+			startRun("recv")
+			err := runRecv(cacheDir, tasksMap, deleteRemote)
+			finishRun(err)
+			if err != nil {
 				cmd.PrintErrf("Failed to run file transfer: %v\n", err)
 				return
 			}
@@ -557,13 +916,40 @@ var recvCmd = &cobra.Command{
 }
 
 func init() {
-	recvCmd.Flags().StringP("src", "s", "woc.src.json", "WoC profile of the transfer source")
-	recvCmd.Flags().StringP("dst", "d", "woc.dst.json", "Woc profile of the transfer destination")
+	recvCmd.Flags().StringP("src", "s", "woc.src.json", "WoC profile of the transfer source, a path or URL")
+	recvCmd.Flags().StringP("dst", "d", "woc.dst.json", "Woc profile of the transfer destination, a path or URL")
 	recvCmd.Flags().StringP("config", "c", "config.json", "Path to the configuration file")
 	recvCmd.Flags().StringP("cache-dir", "C", "", "Path to the cache directory")
 	recvCmd.Flags().StringP("dest-dir", "D", "", "Default destination directory for downloaded files. Uses cache-dir if not specified")
 	recvCmd.Flags().Bool("skip-db", false, "Skip database operations (useful for testing)")
 	recvCmd.Flags().Bool("delete-remote", true, "Delete files on remote after download")
+	recvCmd.Flags().String("order", string(OrderSmallestFirst), "Download and placement order: smallest-first, largest-first, by-map, or by-priority-column")
+	recvCmd.Flags().Bool("verify-only", false, "Check the digests of downloaded and destination files against the tasks, without moving or deleting anything")
 	recvCmd.MarkFlagRequired("cache-dir")
+	addTaskFilterFlags(recvCmd)
+	addDigestWorkersFlag(recvCmd)
+	addStrictFlag(recvCmd)
+	addSkipBadShardsFlag(recvCmd)
+	addAllVersionsFlag(recvCmd)
+	addDatasetFlags(recvCmd)
+	addMetricsAddrFlag(recvCmd)
+	addRCFlags(recvCmd)
+	addMultiThreadFlags(recvCmd)
+	addTransferLimitFlags(recvCmd)
+	addReportFileFlag(recvCmd)
+	addArchiveFlags(recvCmd)
+	addDiskSpaceFlags(recvCmd)
+	addMoveWorkersFlags(recvCmd)
+	addFileDoneHookFlag(recvCmd)
+	addRunDoneHookFlag(recvCmd)
+	addDeadLetterFlags(recvCmd)
+	addProgressIntervalFlag(recvCmd)
+	addDBProgressIntervalFlag(recvCmd)
+	addRemotePrefixFlag(recvCmd)
+	addRunIDFlag(recvCmd)
+	addTasksFileFlag(recvCmd)
+	addDirectFlags(recvCmd)
+	addDeltaFlags(recvCmd)
+	addTracingFlag(recvCmd)
 	RootCmd.AddCommand(recvCmd)
 }
@@ -1,12 +1,17 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/hrz6976/syncmate/db"
 	"github.com/hrz6976/syncmate/offsetfs"
+	"github.com/hrz6976/syncmate/rclone"
 	"github.com/hrz6976/syncmate/woc"
+	"github.com/rclone/rclone/fs"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -92,3 +97,101 @@ func TestScanDownloadedFiles_NonExistentCache(t *testing.T) {
 	require.NoError(t, err)
 	assert.Empty(t, downloadedFiles)
 }
+
+func TestOnFileTransferred_Append(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	destPath := filepath.Join(tmpDir, "blob.idx.v2")
+	require.NoError(t, os.WriteFile(destPath, []byte("abc"), 0644))
+	full := filepath.Join(tmpDir, "full")
+	require.NoError(t, os.WriteFile(full, []byte("abcdef"), 0644))
+	digest, err := woc.SampleMD5(full, 0, 0)
+	require.NoError(t, err)
+
+	// the mode comes from the task, not from the dots in the name
+	cachePath := filepath.Join(tmpDir, "blob.idx.v2.part")
+	require.NoError(t, os.WriteFile(cachePath, []byte("def"), 0644))
+	task := &woc.WocSyncTask{
+		FileConfig:   offsetfs.FileConfig{VirtualPath: "blob.idx.v2.part", Offset: 3, Size: 3},
+		TargetPath:   destPath,
+		SourceDigest: &digest.Digest,
+	}
+	require.NoError(t, onFileTransferred(context.Background(), task, cachePath, destPath, func(string) error { return nil }))
+	content, err := os.ReadFile(destPath)
+	require.NoError(t, err)
+	assert.Equal(t, "abcdef", string(content))
+}
+
+func TestLoadTransferMeta(t *testing.T) {
+	ctx := context.Background()
+	fsrc, err := fs.NewFs(ctx, setupTestDir(t))
+	require.NoError(t, err)
+	defer transferMetas.Clear()
+
+	task := &woc.WocSyncTask{FileConfig: offsetfs.FileConfig{VirtualPath: "blob_0.bin.offset.5", Offset: 5, Size: 10}}
+	data, err := json.Marshal(woc.NewTransferMeta(task))
+	require.NoError(t, err)
+	require.NoError(t, rclone.PutFile(ctx, fsrc, woc.MetaPath(task.VirtualPath), data))
+	require.NoError(t, loadTransferMeta(ctx, fsrc, task))
+	assert.Equal(t, int64(5), transferMeta(task).Offset)
+
+	// a task generated from other profiles is refused
+	other := &woc.WocSyncTask{FileConfig: offsetfs.FileConfig{VirtualPath: "blob_0.bin.offset.5", Offset: 5, Size: 12}}
+	assert.Error(t, loadTransferMeta(ctx, fsrc, other))
+}
+
+func TestChangedObjects(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := setupTestDir(t)
+	fsrc, err := fs.NewFs(ctx, filepath.Join(tmpDir, "bucket"))
+	require.NoError(t, err)
+	dbHandle := openTestDB(t, filepath.Join(tmpDir, "tasks.db"))
+
+	toSync := make(map[string]*woc.WocSyncTask)
+	for _, name := range []string{"a.bin", "b.bin", "c.bin"} {
+		require.NoError(t, rclone.PutFile(ctx, fsrc, name, []byte("0123456789")))
+		toSync[name] = &woc.WocSyncTask{FileConfig: offsetfs.FileConfig{VirtualPath: name, Size: 10}}
+	}
+	objects, err := rclone.StatFiles(ctx, fsrc, []string{"a.bin", "b.bin"})
+	require.NoError(t, err)
+	for _, object := range objects {
+		require.NoError(t, dbHandle.UpdateTask(&db.Task{VirtualPath: object.Name, SrcSize: 10, Status: db.Uploaded, ETag: object.ETag, ObjectSize: object.Size}))
+	}
+	// replaced in the bucket after the upload, c.bin has no recorded ETag
+	require.NoError(t, rclone.PutFile(ctx, fsrc, "b.bin", []byte("9876543210")))
+	require.NoError(t, rclone.PutFile(ctx, fsrc, "c.bin", []byte("9876543210")))
+
+	changed, err := changedObjects(ctx, fsrc, dbHandle, toSync)
+	require.NoError(t, err)
+	require.Len(t, changed, 1)
+	assert.Contains(t, changed["b.bin"], "instead of the "+objects["b.bin"].ETag+" uploaded")
+}
+
+func TestLoadObjectMetadata(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := setupTestDir(t)
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "blob_0.bin"), []byte("0123456789"), 0644))
+	flocal, err := fs.NewFs(ctx, tmpDir)
+	require.NoError(t, err)
+	fsrc, err := fs.NewFs(ctx, filepath.Join(tmpDir, "bucket"))
+	require.NoError(t, err)
+	if !fsrc.Features().UserMetadata {
+		t.Skip("the filesystem of the temporary directory has no extended attributes")
+	}
+	defer transferMetas.Clear()
+
+	task := &woc.WocSyncTask{FileConfig: offsetfs.FileConfig{VirtualPath: "blob_0.bin", SourcePath: "/data/blob_0.bin", Size: 10}}
+	wrapped := rclone.WithMetadata(flocal, func(string) map[string]string {
+		return woc.NewObjectMetadata(task, "da5", "").Map()
+	})
+	_, err = rclone.CopyFiles(ctx, wrapped, fsrc, []string{"blob_0.bin"})
+	require.NoError(t, err)
+	require.NoError(t, loadObjectMetadata(ctx, fsrc, task, 10))
+	assert.Equal(t, woc.MetaModeOverwrite, transferMeta(task).Mode)
+
+	// a task generated from other profiles and an object of another run are refused
+	other := &woc.WocSyncTask{FileConfig: offsetfs.FileConfig{VirtualPath: "blob_0.bin", Offset: 5, Size: 10}}
+	assert.Error(t, loadObjectMetadata(ctx, fsrc, other, 10))
+	runID = "2025q1"
+	defer func() { runID = "" }()
+	assert.ErrorContains(t, loadObjectMetadata(ctx, fsrc, task, 10), "uploaded by run")
+}
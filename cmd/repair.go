@@ -0,0 +1,288 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/hrz6976/syncmate/db"
+	of "github.com/hrz6976/syncmate/offsetfs"
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/rclone/rclone/fs"
+	"github.com/spf13/cobra"
+)
+
+// Kinds of repairAction
+const (
+	// repairReupload deletes the object and uploads the file again
+	repairReupload = "re-upload"
+	// repairRetry transfers the task again as it is
+	repairRetry = "retry"
+	// repairReappend appends the rest of the source file to the short destination
+	repairReappend = "re-append"
+	// repairRecopy copies the whole source file over the destination
+	repairRecopy = "re-copy"
+)
+
+// repairAction is how repair transfers a broken file again
+type repairAction struct {
+	VirtualPath string
+	Problem     string
+	Kind        string
+	// Task transfers the file again, a new one for re-append and re-copy
+	Task *woc.WocSyncTask
+}
+
+// planObjectRepairs finds the objects of the bucket that recv skips because their size doesn't match
+// their task or they changed since they were uploaded. Their tasks aren't Downloaded yet.
+func planObjectRepairs(tasksMap map[string]*woc.WocSyncTask, rows map[string]*db.Task, objects []rclone.RcloneFileInfo) []repairAction {
+	var actions []repairAction
+	for _, object := range objects {
+		task, ok := tasksMap[object.Name]
+		if !ok {
+			continue
+		}
+		row := rows[object.Name]
+		if row != nil && row.Status == db.Downloaded {
+			continue
+		}
+		problem := ""
+		if object.Size != task.Size {
+			problem = fmt.Sprintf("the object has %d bytes instead of %d", object.Size, task.Size)
+		} else if row != nil && row.Status == db.Uploaded {
+			problem = objectChanged(row.ETag, row.ObjectSize, object)
+		}
+		if problem != "" {
+			actions = append(actions, repairAction{object.Name, problem, repairReupload, task})
+		}
+	}
+	return actions
+}
+
+// planDestinationRepair decides how to transfer the destination of a task that failed verification with
+// problem again. A short destination gets the rest of the source file appended, a destination with only
+// the bytes before the append gets the append again, and any other one is replaced by the source file.
+func planDestinationRepair(task *woc.WocSyncTask, problem string) (repairAction, error) {
+	action := repairAction{VirtualPath: task.VirtualPath, Problem: problem, Kind: repairRetry, Task: task}
+	destPath := taskDestPath(task)
+	size := task.Offset + task.Size
+	var destSize int64
+	if stat, err := os.Stat(destPath); err == nil {
+		destSize = stat.Size()
+	} else if !os.IsNotExist(err) {
+		return action, err
+	}
+	switch {
+	case task.Offset > 0 && destSize == task.Offset:
+		return action, nil
+	case destSize > 0 && destSize < size:
+		digester, err := task.TargetDigester()
+		if err != nil {
+			return action, err
+		}
+		digest, err := digester.Digest(destPath, 0, destSize)
+		if err != nil {
+			return action, fmt.Errorf("failed to calculate digest of %s: %w", destPath, err)
+		}
+		action.Kind, action.Task = repairReappend, repairTask(task, destSize)
+		action.Task.TargetDigest = &digest
+		return action, nil
+	case task.Offset > 0:
+		action.Kind, action.Task = repairRecopy, repairTask(task, 0)
+	}
+	return action, nil
+}
+
+// repairTask returns the task that transfers the source file of task to its destination from offset.
+// The destination is given explicitly, the default one is named after the virtual path.
+func repairTask(task *woc.WocSyncTask, offset int64) *woc.WocSyncTask {
+	virtualPath := filepath.Base(task.SourcePath)
+	if offset > 0 {
+		virtualPath = fmt.Sprintf("%s.offset.%d", virtualPath, offset)
+	}
+	repair := &woc.WocSyncTask{
+		FileConfig: of.FileConfig{
+			VirtualPath: virtualPath,
+			SourcePath:  task.SourcePath,
+			Offset:      offset,
+			Size:        task.Offset + task.Size - offset,
+		},
+		Dataset:               task.Dataset,
+		TargetPath:            taskDestPath(task),
+		SourceDigest:          task.SourceDigest,
+		SourceDigestAlgorithm: task.SourceDigestAlgorithm,
+	}
+	if offset > 0 {
+		repair.TargetDigestAlgorithm = task.TargetDigestAlgorithm
+	}
+	return repair
+}
+
+// printRepairs writes the repairs as a table
+func printRepairs(w io.Writer, actions []repairAction) {
+	if len(actions) == 0 {
+		fmt.Fprintln(w, "Nothing to repair")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Virtual Path\tProblem\tAction\tTask")
+	fmt.Fprintln(tw, "------------\t-------\t------\t----")
+	for _, a := range actions {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", a.VirtualPath, a.Problem, a.Kind, a.Task.VirtualPath)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+}
+
+// applyRepairs deletes the objects to upload again with their sidecars, resets the tasks of the repairs
+// to Pending and writes them to tasksOut for send and recv
+func applyRepairs(ctx context.Context, fsrc fs.Fs, dbHandle *db.DB, actions []repairAction, tasksOut string) error {
+	var reupload []string
+	repairTasks := make(map[string]*woc.WocSyncTask, len(actions))
+	paths := make([]string, 0, len(actions))
+	for _, a := range actions {
+		if a.Kind == repairReupload {
+			reupload = append(reupload, a.VirtualPath, woc.MetaPath(a.VirtualPath))
+		}
+		repairTasks[a.Task.VirtualPath] = a.Task
+		paths = append(paths, a.Task.VirtualPath)
+	}
+	if len(reupload) > 0 {
+		if _, err := rclone.DeleteFiles(ctx, fsrc, reupload, deleteWorkers); err != nil {
+			return fmt.Errorf("failed to delete the objects to upload again: %w", err)
+		}
+	}
+	// a new task may have been finished by an earlier run
+	if err := dbHandle.ResetTasks(paths, db.Pending); err != nil {
+		return fmt.Errorf("failed to reset %d tasks to Pending: %w", len(paths), err)
+	}
+	file, err := os.Create(tasksOut)
+	if err != nil {
+		return err
+	}
+	if err := writeTasks(file, repairTasks, "jsonl"); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+var repairCmd = &cobra.Command{
+	Use:   "repair",
+	Short: "Transfer the corrupted or mismatched files again",
+	Long: `Generate the tasks from both profiles like recv and find the files to transfer again: the
+objects of the bucket whose size doesn't match their task or that changed since they were
+uploaded, which recv only logs and skips, and the destination files of Downloaded tasks that fail
+verification like in audit. A broken object is deleted so that send uploads it again, a short
+destination is appended the rest of its source file, and any other broken destination is copied
+again from its source file. The tasks of the repairs are reset to Pending and written to
+--tasks-out, run send and recv with --tasks-file to transfer them.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		srcPath, _ := cmd.Flags().GetString("src")
+		dstPath, _ := cmd.Flags().GetString("dst")
+		configPath, _ := cmd.Flags().GetString("config")
+		destDir, _ = cmd.Flags().GetString("dest-dir")
+		tasksOut, _ := cmd.Flags().GetString("tasks-out")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		filter, err := taskFilterFromFlags(cmd)
+		if err != nil {
+			cmd.PrintErrf("Invalid filter: %v\n", err)
+			os.Exit(1)
+		}
+
+		// before fetching remote profiles, which go through the proxy of the config
+		if err := loadConfig(configPath); err != nil {
+			cmd.PrintErrf("%v\n", err)
+			os.Exit(1)
+		}
+		var srcProfile, dstProfile *woc.ParsedWocProfile
+		if tasksFile == "" {
+			srcProfile, dstProfile, err = loadProfiles(srcPath, dstPath)
+			if err != nil {
+				cmd.PrintErrf("%v\n", err)
+				os.Exit(1)
+			}
+		}
+		dbHandle, err := connectDB()
+		if err != nil {
+			cmd.PrintErrf("Failed to connect to database: %v\n", err)
+			os.Exit(1)
+		}
+
+		tasksMap, err := loadAllTasks(srcProfile, dstProfile)
+		if err != nil {
+			cmd.PrintErrf("Failed to generate tasks: %v\n", err)
+			os.Exit(1)
+		}
+		filterTasks(tasksMap, filter)
+		taskRows, err := listAllTasks(dbHandle, db.TaskFilter{})
+		if err != nil {
+			cmd.PrintErrf("Failed to list tasks: %v\n", err)
+			os.Exit(1)
+		}
+		rows := make(map[string]*db.Task, len(taskRows))
+		statuses := make(map[string]db.Status, len(taskRows))
+		for _, row := range taskRows {
+			rows[row.VirtualPath] = row
+			statuses[row.VirtualPath] = row.Status
+		}
+
+		ctx, fsrc, err := newStatusR2Backend()
+		if err != nil {
+			cmd.PrintErrf("%v\n", err)
+			os.Exit(1)
+		}
+		objects, err := rclone.ListFiles(ctx, fsrc)
+		if err != nil {
+			cmd.PrintErrf("Error listing files: %v\n", err)
+			os.Exit(1)
+		}
+		actions := planObjectRepairs(tasksMap, rows, objects)
+		for _, f := range auditDestinations(sortedTasks(tasksMap), statuses).Findings {
+			action, err := planDestinationRepair(tasksMap[f.VirtualPath], f.Problem)
+			if err != nil {
+				cmd.PrintErrf("Failed to plan the repair of %s: %v\n", f.VirtualPath, err)
+				os.Exit(1)
+			}
+			actions = append(actions, action)
+		}
+		sort.Slice(actions, func(i, j int) bool { return actions[i].VirtualPath < actions[j].VirtualPath })
+
+		printRepairs(cmd.OutOrStdout(), actions)
+		if len(actions) == 0 || dryRun {
+			return
+		}
+		if err := applyRepairs(ctx, fsrc, dbHandle, actions, tasksOut); err != nil {
+			cmd.PrintErrf("%v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Wrote %d tasks to %s, run send and recv with --tasks-file %s to transfer them\n",
+			len(actions), tasksOut, tasksOut)
+	},
+}
+
+func init() {
+	repairCmd.Flags().StringP("src", "s", "woc.src.json", "WoC profile of the transfer source, a path or URL")
+	repairCmd.Flags().StringP("dst", "d", "woc.dst.json", "Woc profile of the transfer destination, a path or URL")
+	repairCmd.Flags().StringP("config", "c", "config.json", "Path to the configuration file")
+	repairCmd.Flags().StringP("dest-dir", "D", "", "Default destination directory of the files, as given to recv")
+	repairCmd.Flags().StringP("tasks-out", "o", "repair.jsonl", "JSONL file the tasks of the repairs are written to, for --tasks-file of send and recv")
+	repairCmd.Flags().Bool("dry-run", false, "Only list the repairs")
+	repairCmd.Flags().IntVar(&auditCheckers, "checkers", 4, "Number of destination files digested in parallel")
+	repairCmd.Flags().IntVar(&deleteWorkers, "delete-workers", 8, "Number of broken objects deleted on R2 in parallel")
+	addTaskFilterFlags(repairCmd)
+	addDigestWorkersFlag(repairCmd)
+	addStrictFlag(repairCmd)
+	addSkipBadShardsFlag(repairCmd)
+	addAllVersionsFlag(repairCmd)
+	addDatasetFlags(repairCmd)
+	addRemotePrefixFlag(repairCmd)
+	addRunIDFlag(repairCmd)
+	addTasksFileFlag(repairCmd)
+	RootCmd.AddCommand(repairCmd)
+}
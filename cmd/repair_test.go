@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hrz6976/syncmate/db"
+	of "github.com/hrz6976/syncmate/offsetfs"
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanObjectRepairs(t *testing.T) {
+	tasksMap := make(map[string]*woc.WocSyncTask)
+	for _, name := range []string{"a.bin", "b.bin", "c.bin", "d.bin"} {
+		tasksMap[name] = &woc.WocSyncTask{FileConfig: of.FileConfig{VirtualPath: name, Size: 10}}
+	}
+	rows := map[string]*db.Task{
+		"b.bin": {VirtualPath: "b.bin", Status: db.Uploaded, ETag: "bbbb", ObjectSize: 10},
+		"c.bin": {VirtualPath: "c.bin", Status: db.Downloaded},
+	}
+	objects := []rclone.RcloneFileInfo{
+		{Name: "a.bin", Size: 4},
+		{Name: woc.MetaPath("a.bin"), Size: 100},
+		{Name: "b.bin", Size: 10, ETag: "cccc"},
+		{Name: "c.bin", Size: 4},
+		{Name: "d.bin", Size: 10},
+		{Name: "e.bin", Size: 1},
+	}
+
+	actions := planObjectRepairs(tasksMap, rows, objects)
+	require.Len(t, actions, 2)
+	assert.Equal(t, repairAction{"a.bin", "the object has 4 bytes instead of 10", repairReupload, tasksMap["a.bin"]}, actions[0])
+	assert.Equal(t, "b.bin", actions[1].VirtualPath)
+	assert.Equal(t, "the object has ETag cccc instead of the bbbb uploaded", actions[1].Problem)
+}
+
+func TestPlanDestinationRepair(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	write := func(name, content string) string {
+		path := filepath.Join(tmpDir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+	task := func(virtualPath, target string, offset, size int64) *woc.WocSyncTask {
+		return &woc.WocSyncTask{
+			FileConfig: of.FileConfig{VirtualPath: virtualPath, SourcePath: "/data/blob_0.bin", Offset: offset, Size: size},
+			Dataset:    "blob",
+			TargetPath: target,
+		}
+	}
+
+	// the append never happened, it is transferred again
+	appendTask := task("blob_0.bin.offset.5", write("unappended.bin", "01234"), 5, 5)
+	action, err := planDestinationRepair(appendTask, "short")
+	require.NoError(t, err)
+	assert.Equal(t, repairRetry, action.Kind)
+	assert.Same(t, appendTask, action.Task)
+
+	// the rest of the source is appended to a short destination
+	short := write("short.bin", "0123456")
+	action, err = planDestinationRepair(task("blob_0.bin.offset.5", short, 5, 5), "short")
+	require.NoError(t, err)
+	assert.Equal(t, repairReappend, action.Kind)
+	assert.Equal(t, "blob_0.bin.offset.7", action.Task.VirtualPath)
+	assert.Equal(t, of.FileConfig{VirtualPath: "blob_0.bin.offset.7", SourcePath: "/data/blob_0.bin", Offset: 7, Size: 3}, action.Task.FileConfig)
+	assert.Equal(t, short, action.Task.TargetPath)
+	require.NotNil(t, action.Task.TargetDigest)
+	assert.Equal(t, "blob", action.Task.Dataset)
+
+	// a corrupted destination of an append is copied again
+	action, err = planDestinationRepair(task("blob_0.bin.offset.5", write("long.bin", "0123456789ab"), 5, 5), "long")
+	require.NoError(t, err)
+	assert.Equal(t, repairRecopy, action.Kind)
+	assert.Equal(t, of.FileConfig{VirtualPath: "blob_0.bin", SourcePath: "/data/blob_0.bin", Size: 10}, action.Task.FileConfig)
+
+	// a missing full copy is transferred again
+	fullTask := task("blob_0.bin", filepath.Join(tmpDir, "missing.bin"), 0, 10)
+	action, err = planDestinationRepair(fullTask, "missing")
+	require.NoError(t, err)
+	assert.Equal(t, repairRetry, action.Kind)
+	assert.Same(t, fullTask, action.Task)
+
+	var out bytes.Buffer
+	printRepairs(&out, []repairAction{action})
+	assert.Contains(t, out.String(), "blob_0.bin    missing  retry   blob_0.bin\n")
+	out.Reset()
+	printRepairs(&out, nil)
+	assert.Equal(t, "Nothing to repair\n", out.String())
+}
+
+func TestApplyRepairs(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := setupTestDir(t)
+	fsrc, err := fs.NewFs(ctx, filepath.Join(tmpDir, "bucket"))
+	require.NoError(t, err)
+	dbHandle := openTestDB(t, filepath.Join(tmpDir, "tasks.db"))
+	for _, name := range []string{"a.bin", woc.MetaPath("a.bin"), "b.bin"} {
+		require.NoError(t, rclone.PutFile(ctx, fsrc, name, []byte("0123")))
+	}
+	require.NoError(t, dbHandle.UpdateTask(&db.Task{VirtualPath: "a.bin", SrcSize: 10, Status: db.Uploaded}))
+	require.NoError(t, dbHandle.UpdateTask(&db.Task{VirtualPath: "c.bin", SrcSize: 10, Status: db.Downloaded}))
+
+	actions := []repairAction{
+		{"a.bin", "broken", repairReupload, &woc.WocSyncTask{FileConfig: of.FileConfig{VirtualPath: "a.bin", Size: 10}}},
+		{"c.bin", "short", repairReappend, &woc.WocSyncTask{FileConfig: of.FileConfig{VirtualPath: "c.bin.offset.4", Offset: 4, Size: 6}}},
+	}
+	tasksOut := filepath.Join(tmpDir, "repair.jsonl")
+	require.NoError(t, applyRepairs(ctx, fsrc, dbHandle, actions, tasksOut))
+
+	objects, err := rclone.ListFiles(ctx, fsrc)
+	require.NoError(t, err)
+	assert.Equal(t, []rclone.RcloneFileInfo{{Name: "b.bin", Size: 4}}, objects)
+	task, err := dbHandle.GetTask("a.bin")
+	require.NoError(t, err)
+	assert.Equal(t, db.Pending, task.Status)
+
+	tasksMap, err := readTasksFromJSONL(tasksOut)
+	require.NoError(t, err)
+	assert.Len(t, tasksMap, 2)
+	assert.Equal(t, int64(4), tasksMap["c.bin.offset.4"].Offset)
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/hrz6976/syncmate/db"
+	"github.com/hrz6976/syncmate/notify"
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/spf13/cobra"
+)
+
+// reportFile receives the JSON report of the run, disabled if empty
+var reportFile string
+
+func addReportFileFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&reportFile, "report-file", "", "Write a JSON summary of the run with the outcome of every task to this file")
+}
+
+// taskReport is the outcome of one task in the run report
+type taskReport struct {
+	VirtualPath string `json:"virtual_path"`
+	// Status reached in this run, one of the db.Status names
+	Status    string `json:"status"`
+	Size      int64  `json:"size"`
+	Bytes     int64  `json:"bytes"`
+	SrcDigest string `json:"src_digest,omitempty"`
+	DstDigest string `json:"dst_digest,omitempty"`
+	// TransferDuration is the time rclone spent on the file
+	TransferDuration time.Duration `json:"transfer_duration_ns,omitempty"`
+	// PlaceDuration is the time MoveFile spent placing the file (recv only)
+	PlaceDuration time.Duration `json:"place_duration_ns,omitempty"`
+	Error         string        `json:"error,omitempty"`
+}
+
+func newTaskReport(task *woc.WocSyncTask) *taskReport {
+	srcDigest, dstDigest := taskDigests(task)
+	return &taskReport{
+		VirtualPath: task.VirtualPath,
+		Size:        task.Size,
+		SrcDigest:   srcDigest,
+		DstDigest:   dstDigest,
+	}
+}
+
+// runReport is written to --report-file when a run finishes
+type runReport struct {
+	notify.Summary
+	Start time.Time     `json:"start"`
+	Tasks []*taskReport `json:"tasks"`
+}
+
+func buildReport(run *runStats, summary notify.Summary, transfers []rclone.TransferResult) *runReport {
+	run.tasksMu.Lock()
+	defer run.tasksMu.Unlock()
+
+	for _, tr := range transfers {
+		report, ok := run.tasks[tr.Name]
+		if !ok {
+			continue
+		}
+		report.TransferDuration = tr.Duration
+		if tr.Err != nil {
+			report.Status = db.Failed.String()
+			report.Bytes = tr.Bytes
+			if report.Error == "" {
+				report.Error = tr.Err.Error()
+			}
+		}
+	}
+
+	tasks := make([]*taskReport, 0, len(run.tasks))
+	for _, report := range run.tasks {
+		tasks = append(tasks, report)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].VirtualPath < tasks[j].VirtualPath
+	})
+	return &runReport{Summary: summary, Start: run.start, Tasks: tasks}
+}
+
+func writeReport(path string, run *runStats, summary notify.Summary) error {
+	data, err := json.MarshalIndent(buildReport(run, summary, rclone.CompletedTransfers()), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
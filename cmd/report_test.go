@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hrz6976/syncmate/db"
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildReport(t *testing.T) {
+	tasksMap := newOrderTestTasks()
+	startRun("send")
+	run := currentRun.Load()
+	defer currentRun.Store(nil)
+
+	recordTaskStatus(tasksMap["blob_0.bin"], db.Uploading, nil)
+	recordTaskStatus(tasksMap["blob_1.bin"], db.Uploading, nil)
+	recordTaskStatus(tasksMap["c2pFullV2412.0.tch"], db.Pending, nil)
+	recordTaskStatus(tasksMap["blob_0.bin"], db.Uploaded, nil)
+
+	transfers := []rclone.TransferResult{
+		{Name: "blob_0.bin", Bytes: 200, Duration: 2 * time.Second},
+		{Name: "blob_1.bin", Bytes: 40, Duration: time.Second, Err: errors.New("connection reset")},
+	}
+	report := buildReport(run, run.summary(nil), transfers)
+
+	require.Len(t, report.Tasks, 3)
+	assert.Equal(t, "send", report.Command)
+	assert.Equal(t, &taskReport{VirtualPath: "blob_0.bin", Status: "Uploaded", Size: 200, Bytes: 200, TransferDuration: 2 * time.Second}, report.Tasks[0])
+	assert.Equal(t, &taskReport{VirtualPath: "blob_1.bin", Status: "Failed", Size: 100, Bytes: 40, TransferDuration: time.Second, Error: "connection reset"}, report.Tasks[1])
+	assert.Equal(t, "Pending", report.Tasks[2].Status)
+}
+
+func TestFinishRun_WritesReport(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	reportFile = filepath.Join(tmpDir, "report.json")
+	defer func() { reportFile = "" }()
+
+	startRun("recv")
+	recordTransfer(100)
+	recordTaskStatus(newOrderTestTasks()["blob_1.bin"], db.Downloaded, nil)
+	finishRun(errors.New("upload cancelled by user interrupt"))
+	assert.Nil(t, currentRun.Load())
+
+	data, err := os.ReadFile(reportFile)
+	require.NoError(t, err)
+	var report map[string]any
+	require.NoError(t, json.Unmarshal(data, &report))
+	assert.Equal(t, "recv", report["command"])
+	assert.Equal(t, "failure", report["event"])
+	assert.Equal(t, float64(1), report["files"])
+	assert.Len(t, report["tasks"], 1)
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"github.com/hrz6976/syncmate/db"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var retryCmd = &cobra.Command{
+	Use:   "retry {send|recv}",
+	Short: "Retry failed tasks",
+	Long: `Find the tasks that are marked Failed or have a recorded error in the database,
+reset them to Pending and run send or recv again for those tasks only.`,
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"send", "recv"},
+	Run: func(cmd *cobra.Command, args []string) {
+		role := args[0]
+		srcPath, _ := cmd.Flags().GetString("src")
+		dstPath, _ := cmd.Flags().GetString("dst")
+		configPath, _ := cmd.Flags().GetString("config")
+		cacheDir, _ = cmd.Flags().GetString("cache-dir")
+		destDir, _ = cmd.Flags().GetString("dest-dir")
+		deleteRemote, _ := cmd.Flags().GetBool("delete-remote")
+		orderFlag, _ := cmd.Flags().GetString("order")
+
+		if role == "recv" && cacheDir == "" {
+			cmd.PrintErrln("--cache-dir is required to retry recv")
+			return
+		}
+		if destDir == "" {
+			destDir = cacheDir
+		}
+
+		var err error
+		if transferOrder, err = parseTaskOrder(orderFlag); err != nil {
+			cmd.PrintErrf("Invalid --order: %v\n", err)
+			return
+		}
+
+		if err := loadConfig(configPath); err != nil {
+			cmd.PrintErrf("%v\n", err)
+			return
+		}
+
+		srcProfile, dstProfile, err := loadProfiles(srcPath, dstPath)
+		if err != nil {
+			cmd.PrintErrf("%v\n", err)
+			return
+		}
+
+		releaseLock, err := acquireRunLock(runLockPath(role))
+		if err != nil {
+			cmd.PrintErrf("%v\n", err)
+			return
+		}
+		defer releaseLock()
+
+		if _, err = connectDB(); err != nil {
+			cmd.PrintErrf("Failed to connect to database: %v\n", err)
+			return
+		}
+
+		failedTasks, err := dbHandle.ListFailedTasks()
+		if err != nil {
+			cmd.PrintErrf("Failed to list failed tasks: %v\n", err)
+			return
+		}
+		if len(failedTasks) == 0 {
+			logger.Info("No failed tasks to retry")
+			return
+		}
+
+		failed := &taskFilter{files: make(map[string]bool)}
+		for _, task := range failedTasks {
+			logger.WithFields(logger.Fields{
+				"virtualPath": task.VirtualPath,
+				"status":      task.Status.String(),
+				"error":       task.Error,
+			}).Debug("Found failed task")
+			failed.files[task.VirtualPath] = true
+		}
+
+		tasksMap, err := generateTasks(srcProfile, dstProfile, role == "send")
+		if err != nil {
+			cmd.PrintErrf("Failed to generate tasks: %v\n", err)
+			return
+		}
+		filterTasks(tasksMap, failed)
+
+		if len(tasksMap) < len(failedTasks) {
+			logger.WithField("count", len(failedTasks)-len(tasksMap)).Warn("Some failed tasks are no longer generated from the profiles, leaving them untouched")
+		}
+		if len(tasksMap) == 0 {
+			logger.Info("No tasks to retry")
+			return
+		}
+
+		virtualPaths := make([]string, 0, len(tasksMap))
+		for virtualPath := range tasksMap {
+			virtualPaths = append(virtualPaths, virtualPath)
+		}
+		if err := dbHandle.ResetTasks(virtualPaths, db.Pending); err != nil {
+			cmd.PrintErrf("Failed to reset failed tasks: %v\n", err)
+			return
+		}
+		logger.WithField("taskCount", len(tasksMap)).Info("Retrying failed tasks")
+
+		startRun(role)
+		switch role {
+		case "send":
+			err = runSend(tasksMap)
+		case "recv":
+			err = runRecv(cacheDir, tasksMap, deleteRemote)
+		}
+		finishRun(err)
+		if err != nil {
+			cmd.PrintErrf("Failed to retry tasks: %v\n", err)
+			return
+		}
+		logger.Info("Retry completed successfully")
+	},
+}
+
+func init() {
+	retryCmd.Flags().StringP("src", "s", "woc.src.json", "WoC profile of the transfer source, a path or URL")
+	retryCmd.Flags().StringP("dst", "d", "woc.dst.json", "Woc profile of the transfer destination, a path or URL")
+	retryCmd.Flags().StringP("config", "c", "config.json", "Path to the configuration file")
+	retryCmd.Flags().StringP("cache-dir", "C", "", "Path to the cache directory (recv only)")
+	retryCmd.Flags().StringP("dest-dir", "D", "", "Default destination directory for downloaded files. Uses cache-dir if not specified (recv only)")
+	retryCmd.Flags().Bool("delete-remote", true, "Delete files on remote after download (recv only)")
+	retryCmd.Flags().String("order", string(OrderSmallestFirst), "Transfer order: smallest-first, largest-first, by-map, or by-priority-column")
+	addDigestWorkersFlag(retryCmd)
+	addStrictFlag(retryCmd)
+	addSkipBadShardsFlag(retryCmd)
+	addAllVersionsFlag(retryCmd)
+	addTransferLimitFlags(retryCmd)
+	addReportFileFlag(retryCmd)
+	addArchiveFlags(retryCmd)
+	addDiskSpaceFlags(retryCmd)
+	addMoveWorkersFlags(retryCmd)
+	addFileDoneHookFlag(retryCmd)
+	addRunDoneHookFlag(retryCmd)
+	addDeadLetterFlags(retryCmd)
+	addProgressIntervalFlag(retryCmd)
+	addDBProgressIntervalFlag(retryCmd)
+	addRemotePrefixFlag(retryCmd)
+	addRunIDFlag(retryCmd)
+	RootCmd.AddCommand(retryCmd)
+}
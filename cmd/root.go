@@ -23,6 +23,11 @@ using S3 as the transfer medium.`,
 	// has an action associated with it:
 	//	Run: func(cmd *cobra.Command, args []string) { },
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// first, the profile may set the other flags
+		if err := applyConfigProfile(cmd); err != nil {
+			cmd.PrintErrf("%v\n", err)
+			os.Exit(1)
+		}
 		verbose, _ := cmd.Flags().GetCount("verbose")
 		if verbose > 0 {
 			switch verbose {
@@ -34,6 +39,10 @@ using S3 as the transfer medium.`,
 				logger.SetLevel(logger.TraceLevel)
 			}
 		}
+		if err := applyLogProfile(cmd, verbose); err != nil {
+			cmd.PrintErrf("%v\n", err)
+			os.Exit(1)
+		}
 	},
 }
 
@@ -55,4 +64,6 @@ func init() {
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	RootCmd.PersistentFlags().CountP("verbose", "v", "Verbose output (use -v, -vv, or --verbose=N)")
+	addLogProfileFlags(RootCmd)
+	addConfigProfileFlag(RootCmd)
 }
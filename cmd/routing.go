@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+)
+
+// RoutingRule places downloaded files whose virtual path matches Pattern into Subdir of the destination directory
+type RoutingRule struct {
+	// Pattern is a glob pattern in the path.Match syntax, e.g. "*.idx*"
+	Pattern string `json:"pattern"`
+	Subdir  string `json:"subdir"`
+}
+
+// RoutingConfig is the "routing" section of config.json
+type RoutingConfig struct {
+	// Rules are tried in order, the first match wins
+	Rules []RoutingRule `json:"rules"`
+	// Default is the subdirectory of files matching no rule
+	Default string `json:"default"`
+}
+
+// defaultRouting is the layout of a WoC destination
+var defaultRouting = &RoutingConfig{
+	Rules: []RoutingRule{
+		{Pattern: "*.idx*", Subdir: "All.blobs"},
+		{Pattern: "*.bin*", Subdir: "All.blobs"},
+		{Pattern: "*.s*", Subdir: "gz"},
+		{Pattern: "sha1.*", Subdir: "All.sha1o"},
+		{Pattern: "*Full*", Subdir: "basemaps"},
+	},
+	Default: "All.sha1c",
+}
+
+// Validate checks the patterns of the rules
+func (r *RoutingConfig) Validate() error {
+	if r == nil {
+		return nil
+	}
+	for i, rule := range r.Rules {
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return fmt.Errorf("rule %d: invalid pattern %q: %w", i, rule.Pattern, err)
+		}
+		if rule.Subdir == "" {
+			return fmt.Errorf("rule %d: subdir is required", i)
+		}
+	}
+	return nil
+}
+
+// Subdir returns the subdirectory of the first rule matching the virtual path
+func (r *RoutingConfig) Subdir(virtualPath string) string {
+	for _, rule := range r.Rules {
+		if ok, _ := path.Match(rule.Pattern, virtualPath); ok {
+			return rule.Subdir
+		}
+	}
+	return r.Default
+}
+
+// routing returns the routing rules of the config file, or the WoC layout if there are none
+func routing() *RoutingConfig {
+	if config != nil && config.Routing != nil {
+		return config.Routing
+	}
+	return defaultRouting
+}
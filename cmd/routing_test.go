@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVirtualPathToSubdir_Default(t *testing.T) {
+	oldConfig := config
+	defer func() { config = oldConfig }()
+	config = &CloudflareCredentials{}
+
+	cases := map[string]string{
+		"blob_0.idx":          "All.blobs",
+		"blob_12.bin":         "All.blobs",
+		"tree_3.s":            "gz",
+		"sha1.commit_0.tch":   "All.sha1o",
+		"c2pFullV2412.0.tch":  "basemaps",
+		"commit_0.tch":        "All.sha1c",
+		"blob_0.bin.offset.5": "All.blobs",
+	}
+	for virtualPath, subdir := range cases {
+		assert.Equal(t, subdir, virtualPathToSubdir(virtualPath), virtualPath)
+	}
+}
+
+func TestVirtualPathToSubdir_Config(t *testing.T) {
+	oldConfig := config
+	defer func() { config = oldConfig }()
+	config = &CloudflareCredentials{Routing: &RoutingConfig{
+		Rules: []RoutingRule{
+			{Pattern: "*.parquet", Subdir: "tables"},
+			{Pattern: "c2p*", Subdir: "c2p"},
+		},
+		Default: "misc",
+	}}
+
+	assert.Equal(t, "tables", virtualPathToSubdir("commits.parquet"))
+	assert.Equal(t, "c2p", virtualPathToSubdir("c2pFullV2412.0.tch"))
+	assert.Equal(t, "misc", virtualPathToSubdir("blob_0.bin"))
+}
+
+func TestRoutingConfig_Validate(t *testing.T) {
+	assert.NoError(t, (*RoutingConfig)(nil).Validate())
+	assert.NoError(t, defaultRouting.Validate())
+	assert.Error(t, (&RoutingConfig{Rules: []RoutingRule{{Pattern: "[", Subdir: "x"}}}).Validate())
+	assert.Error(t, (&RoutingConfig{Rules: []RoutingRule{{Pattern: "*"}}}).Validate())
+}
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hrz6976/syncmate/db"
+	"github.com/hrz6976/syncmate/notify"
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/hrz6976/syncmate/tracing"
+	"github.com/hrz6976/syncmate/woc"
+	logger "github.com/sirupsen/logrus"
+)
+
+// runStats collects the outcome of a send or recv run for notifications and reports
+type runStats struct {
+	command  string
+	start    time.Time
+	files    atomic.Int64
+	bytes    atomic.Int64
+	failures atomic.Int64
+
+	tasksMu sync.Mutex
+	tasks   map[string]*taskReport
+}
+
+// currentRun is the run in progress, nil outside of startRun/finishRun. The rc server reads it
+// while the daemon starts and finishes its runs.
+var currentRun atomic.Pointer[runStats]
+
+func startRun(command string) {
+	currentRun.Store(&runStats{command: command, start: time.Now(), tasks: make(map[string]*taskReport)})
+	// for the report and the transfer times recorded in the database
+	rclone.KeepCompletedTransfers()
+}
+
+// recordTransfer counts a file of size bytes that reached its destination
+func recordTransfer(size int64) {
+	run := currentRun.Load()
+	if run == nil {
+		return
+	}
+	run.files.Add(1)
+	run.bytes.Add(size)
+}
+
+// recordFailure counts a file that failed to transfer
+func recordFailure() {
+	run := currentRun.Load()
+	if run == nil {
+		return
+	}
+	run.failures.Add(1)
+}
+
+// recordRcloneStats takes the transfer counters of the finished rclone copy
+func recordRcloneStats() {
+	run := currentRun.Load()
+	if run == nil {
+		return
+	}
+	files, bytes, errors := rclone.TransferStats()
+	run.files.Store(files)
+	run.bytes.Store(bytes)
+	run.failures.Store(errors)
+}
+
+// setTransferTimes records the rclone transfer of the task's file in its database row, if there was one
+func setTransferTimes(row *db.Task, transfers map[string]rclone.TransferResult) {
+	if tr, ok := transfers[row.VirtualPath]; ok {
+		row.TransferStartedAt = &tr.StartedAt
+		row.TransferEndedAt = &tr.CompletedAt
+	}
+}
+
+// recordTaskStatus records the state a task reached in this run
+func recordTaskStatus(task *woc.WocSyncTask, status db.Status, err error) {
+	run := currentRun.Load()
+	if run == nil {
+		return
+	}
+	run.tasksMu.Lock()
+	defer run.tasksMu.Unlock()
+	report, ok := run.tasks[task.VirtualPath]
+	if !ok {
+		report = newTaskReport(task)
+		run.tasks[task.VirtualPath] = report
+	}
+	report.Status = status.String()
+	if status == db.Uploaded || status == db.Downloaded {
+		report.Bytes = task.Size
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+}
+
+// recordPlacement records the time MoveFile took to place a downloaded task
+func recordPlacement(task *woc.WocSyncTask, d time.Duration) {
+	run := currentRun.Load()
+	if run == nil {
+		return
+	}
+	run.tasksMu.Lock()
+	defer run.tasksMu.Unlock()
+	if report, ok := run.tasks[task.VirtualPath]; ok {
+		report.PlaceDuration = d
+	}
+}
+
+func (run *runStats) summary(runErr error) notify.Summary {
+	host, _ := os.Hostname()
+	summary := notify.Summary{
+		Command:  run.command,
+		Host:     host,
+		Event:    notify.EventSuccess,
+		Files:    run.files.Load(),
+		Bytes:    run.bytes.Load(),
+		Failures: run.failures.Load(),
+		Duration: time.Since(run.start),
+	}
+	if runErr != nil {
+		summary.Event = notify.EventFailure
+		summary.Error = runErr.Error()
+	}
+	return summary
+}
+
+// finishRun ends the current run, writes the --report-file and sends the configured notification
+func finishRun(runErr error) {
+	tracing.EndRun(runErr)
+	run := currentRun.Swap(nil)
+	if run == nil {
+		return
+	}
+
+	summary := run.summary(runErr)
+	if reportFile != "" {
+		if err := writeReport(reportFile, run, summary); err != nil {
+			logger.WithError(err).Warn("Failed to write run report")
+		} else {
+			logger.WithField("reportFile", reportFile).Info("Wrote run report")
+		}
+	}
+
+	hookVars := map[string]string{
+		"command":  summary.Command,
+		"event":    string(summary.Event),
+		"files":    strconv.FormatInt(summary.Files, 10),
+		"bytes":    strconv.FormatInt(summary.Bytes, 10),
+		"failures": strconv.FormatInt(summary.Failures, 10),
+		"error":    summary.Error,
+		"report":   reportFile,
+	}
+	if err := runHook(context.Background(), "on_run_done", runDoneHook(), hookVars); err != nil {
+		logger.WithError(err).Warn("Run hook failed")
+	}
+
+	if config == nil {
+		return
+	}
+	if err := notify.Send(context.Background(), config.Notifications, &summary); err != nil {
+		logger.WithError(err).Warn("Failed to send notification")
+	}
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCron_Errors(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		_, err := parseCron(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestCronSchedule_Next(t *testing.T) {
+	// Wednesday
+	start := time.Date(2025, 7, 16, 10, 17, 30, 0, time.UTC)
+
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2025, 7, 16, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2025, 7, 16, 10, 30, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2025, 7, 17, 2, 0, 0, 0, time.UTC)},
+		{"30 9,18 * * 1-5", time.Date(2025, 7, 16, 18, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2025, 7, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC)},
+		// day of month or day of week
+		{"0 0 20 * 4", time.Date(2025, 7, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2025, 7, 16, 11, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		s, err := parseCron(c.expr)
+		require.NoError(t, err, c.expr)
+		assert.Equal(t, c.want, s.Next(start), c.expr)
+	}
+
+	s, err := parseCron("0 0 31 2 *")
+	require.NoError(t, err)
+	assert.True(t, s.Next(start).IsZero())
+}
+
+func TestIntervalSchedule_Next(t *testing.T) {
+	start := time.Date(2025, 7, 16, 10, 17, 30, 0, time.UTC)
+	assert.Equal(t, start.Add(time.Hour), intervalSchedule(time.Hour).Next(start))
+}
@@ -3,49 +3,185 @@ package cmd
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
-	"os/signal"
+	"slices"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/hrz6976/syncmate/db"
+	"github.com/hrz6976/syncmate/metrics"
 	"github.com/hrz6976/syncmate/offsetfs"
 	of "github.com/hrz6976/syncmate/offsetfs"
 	"github.com/hrz6976/syncmate/rclone"
+	"github.com/hrz6976/syncmate/tracing"
 	"github.com/hrz6976/syncmate/woc"
 	"github.com/rclone/rclone/fs"
 	logger "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/winfsp/cgofuse/fuse"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var errSendCancelled = errors.New("upload cancelled by user interrupt")
+
+// taskDigests returns the source and target digests of a task, empty if unknown
+func taskDigests(task *woc.WocSyncTask) (string, string) {
+	var srcDigest, dstDigest string
+	if task.SourceDigest != nil {
+		srcDigest = *task.SourceDigest
+	}
+	if task.TargetDigest != nil {
+		dstDigest = *task.TargetDigest
+	}
+	return srcDigest, dstDigest
+}
+
+// uploadTransferMetas uploads the TransferMeta sidecar of every file
+func uploadTransferMetas(ctx context.Context, fdst fs.Fs, fileList []string, tasksMap map[string]*woc.WocSyncTask) error {
+	for _, virtualPath := range fileList {
+		data, err := json.Marshal(woc.NewTransferMeta(tasksMap[virtualPath]))
+		if err != nil {
+			return fmt.Errorf("failed to encode transfer metadata of %s: %w", virtualPath, err)
+		}
+		if err := rclone.PutFile(ctx, fdst, woc.MetaPath(virtualPath), data); err != nil {
+			return fmt.Errorf("failed to upload transfer metadata of %s: %w", virtualPath, err)
+		}
+	}
+	return nil
+}
+
+// splitUploadResults returns the tasks whose file CopyFiles uploaded and those it failed to upload.
+// The tasks of the files it didn't attempt are in neither.
+func splitUploadResults(tasksMap map[string]*woc.WocSyncTask, results map[string]rclone.TransferResult) (uploaded, failed map[string]*woc.WocSyncTask) {
+	uploaded = make(map[string]*woc.WocSyncTask)
+	failed = make(map[string]*woc.WocSyncTask)
+	for virtualPath, task := range tasksMap {
+		result, ok := results[virtualPath]
+		switch {
+		case !ok:
+		case result.Succeeded():
+			uploaded[virtualPath] = task
+		default:
+			failed[virtualPath] = task
+		}
+	}
+	return uploaded, failed
+}
+
+// sendTaskRow returns the database row of a task of send
+func sendTaskRow(task *woc.WocSyncTask, status db.Status) *db.Task {
+	srcDigest, dstDigest := taskDigests(task)
+	return &db.Task{
+		VirtualPath:        task.VirtualPath,
+		Status:             status,
+		SrcDigest:          srcDigest,
+		DstDigest:          dstDigest,
+		SrcPath:            task.SourcePath,
+		SrcSize:            task.Size,
+		DstSize:            task.Offset,
+		SrcDigestAlgorithm: task.SourceDigestAlgorithm,
+		DstDigestAlgorithm: task.TargetDigestAlgorithm,
+	}
+}
+
+// upsertSendTasks records the tasks with status in the database, in batches.
+// transfers are the results of CopyFiles, their times and errors are recorded with the tasks.
+// objects are the uploaded objects of StatFiles, recorded with the tasks they belong to.
+func upsertSendTasks(tasks []*woc.WocSyncTask, status db.Status, transfers map[string]rclone.TransferResult, objects map[string]rclone.RcloneFileInfo) error {
+	rows := make([]*db.Task, len(tasks))
+	for i, task := range tasks {
+		rows[i] = sendTaskRow(task, status)
+		setTransferTimes(rows[i], transfers)
+		if tr, ok := transfers[task.VirtualPath]; ok && tr.Err != nil {
+			rows[i].Error = tr.Err.Error()
+		}
+		if object, ok := objects[task.VirtualPath]; ok {
+			rows[i].ETag = object.ETag
+			rows[i].ObjectSize = object.Size
+		}
+	}
+	_, span := tracing.Start(tracing.Context(), "db update tasks", attribute.Int("syncmate.tasks", len(rows)), attribute.String("syncmate.status", status.String()))
+	err := dbHandle.UpdateTasks(rows)
+	tracing.End(span, err)
+	var rejected *db.RejectedError
+	if errors.As(err, &rejected) {
+		// e.g. tasks another host finished meanwhile, the others were written
+		logger.WithError(err).Warn("Some task updates were rejected")
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to upsert %d %s tasks: %w", len(tasks), status, err)
+	}
+	return nil
+}
+
 func runSend(
 	tasksMap map[string]*woc.WocSyncTask,
 ) error {
+	// Decide the upload order before mounting, the database may be slow
+	priorities, err := loadTaskPriorities(transferOrder)
+	if err != nil {
+		return fmt.Errorf("failed to load task priorities: %w", err)
+	}
+	selected, remaining := limitTasks(orderTasks(tasksMap, transferOrder, priorities), int64(maxBytes), maxFiles)
+	leasing := claimTasks > 0 && dbHandle != nil
+	if leasing {
+		if leaseTTL <= 0 {
+			return fmt.Errorf("--lease-ttl must be positive")
+		}
+		owner, err := leaseOwnerName()
+		if err != nil {
+			return err
+		}
+		if selected, err = claimSendTasks(owner, selected); err != nil {
+			return err
+		}
+		if len(selected) == 0 {
+			logger.Info("All tasks are leased by other hosts or uploaded, nothing to claim")
+			return nil
+		}
+		// the tasks this host didn't claim belong to the other hosts, don't touch them
+		remaining = nil
+		tasksMap = make(map[string]*woc.WocSyncTask, len(selected))
+		for _, task := range selected {
+			tasksMap[task.VirtualPath] = task
+		}
+		leaseCtx, stopLeases := context.WithCancel(context.Background())
+		defer func() {
+			stopLeases()
+			releaseLeases(owner)
+		}()
+		go renewLeases(leaseCtx, owner)
+	}
+	if len(remaining) > 0 {
+		logger.WithFields(logger.Fields{
+			"selected":  len(selected),
+			"remaining": len(remaining),
+		}).Info("Transfer limits reached, leaving remaining tasks Pending")
+		tasksMap = make(map[string]*woc.WocSyncTask, len(selected))
+		for _, task := range selected {
+			tasksMap[task.VirtualPath] = task
+		}
+	}
+	batches := batchTasks(selected, transferOrder, priorities)
+
 	// 1. Populate the remote database
 	if dbHandle != nil {
-		for _, task := range tasksMap {
-			var srcDigest, dstDigest string
-			if task.SourceDigest != nil {
-				srcDigest = *task.SourceDigest
-			}
-			if task.TargetDigest != nil {
-				dstDigest = *task.TargetDigest
-			}
-			if err := dbHandle.UpdateTask(&db.Task{
-				VirtualPath: task.VirtualPath,
-				Status:      db.Uploading,
-				SrcDigest:   srcDigest,
-				DstDigest:   dstDigest,
-				SrcPath:     task.SourcePath,
-				SrcSize:     task.Size,
-				DstSize:     task.Offset,
-			}); err != nil {
-				return fmt.Errorf("failed to upsert task %s: %w", task.VirtualPath, err)
-			}
+		if err := upsertSendTasks(selected, db.Uploading, nil, nil); err != nil {
+			return err
 		}
+		if err := upsertSendTasks(remaining, db.Pending, nil, nil); err != nil {
+			return err
+		}
+	}
+	for _, task := range selected {
+		metrics.SetTaskState(task.VirtualPath, db.Uploading.String())
+		recordTaskStatus(task, db.Uploading, nil)
+	}
+	for _, task := range remaining {
+		recordTaskStatus(task, db.Pending, nil)
 	}
 
 	// 2. Mount OffsetFS (don't block the main thread, listen to signals)
@@ -59,7 +195,7 @@ func runSend(
 		}
 	}
 
-	mountpoint := "/tmp/syncmate_offsetfs"
+	mountpoint := sendMountpoint
 	// does the dir exist?
 	if _, err := os.Stat(mountpoint); os.IsNotExist(err) {
 		// Create the mountpoint directory if it doesn't exist
@@ -76,7 +212,7 @@ func runSend(
 	// Clean up any existing mount at this location
 	_ = offsetfs.UmountExec(mountpoint)
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(tracing.Context())
 	defer cancel()
 
 	filesystem := of.NewOffsetFS(offsetConfigs, true)
@@ -89,16 +225,23 @@ func runSend(
 
 	var mountWg sync.WaitGroup
 	mountWg.Add(1)
+	// errors of the mount and upload goroutines, read after mountWg.Wait()
+	var mountErr, sendErr error
 
 	go func() {
 		defer mountWg.Done()
 
 		logger.WithField("mountpoint", mountpoint).Info("Mounting OffsetFS...")
 
+		// the span lasts as long as the files are mounted
+		_, span := tracing.Start(ctx, "mount OffsetFS", attribute.String("syncmate.mountpoint", mountpoint), attribute.Int("syncmate.files", len(offsetConfigs)))
 		if !host.Mount(mountpoint, options) {
 			logger.WithField("mountpoint", mountpoint).Error("Failed to mount OffsetFS")
+			mountErr = fmt.Errorf("failed to mount OffsetFS at %s", mountpoint)
+			tracing.End(span, mountErr)
 			return
 		}
+		tracing.End(span, nil)
 
 		<-ctx.Done()
 		logger.Info("Unmounting OffsetFS...")
@@ -108,20 +251,22 @@ func runSend(
 
 	time.Sleep(1 * time.Second) // Give some time for the mount to complete
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	// The first interrupt finishes the files being uploaded, the second one aborts
+	stopCtx, softStop := rclone.InjectSoftStop(ctx)
+	stopInterrupts := handleInterrupts(softStop, func() {
+		logger.Info("Cleaning up...")
+		cancel()
+		if err := offsetfs.UmountExec(mountpoint); err != nil {
+			logger.WithError(err).Error("Failed to unmount OffsetFS on interrupt")
+		}
+	})
+	defer stopInterrupts()
 
 	taskDone := make(chan bool, 1)
 
 	go func() {
 		select {
-		case <-sigChan:
-			logger.Info("Received interrupt signal, cleaning up...")
-			cancel()
-			err := offsetfs.UmountExec(mountpoint)
-			if err != nil {
-				logger.WithError(err).Error("Failed to unmount OffsetFS on interrupt")
-			}
+		case <-ctx.Done():
 		case <-taskDone:
 			logger.Info("Tasks completed, cleaning up...")
 			cancel()
@@ -141,8 +286,8 @@ func runSend(
 
 		// 准备要上传的文件列表
 		var fileList []string
-		for virtualPath := range offsetConfigs {
-			fileList = append(fileList, virtualPath)
+		for _, batch := range batches {
+			fileList = append(fileList, batch...)
 		}
 
 		if len(fileList) == 0 {
@@ -150,25 +295,33 @@ func runSend(
 			return
 		}
 
+		if directServe {
+			sendErr = serveDirect(ctx, softStop, fileList)
+			return
+		}
+
 		logger.WithField("count", len(fileList)).Info("Uploading files to R2...")
 
-		syncCtx := rclone.InjectConfig(ctx)
+		syncCtx := rclone.InjectConfig(stopCtx)
+		syncCtx = rclone.InjectOrderBy(syncCtx, rcloneOrderBy(transferOrder))
 		syncCtx = rclone.InjectFileList(syncCtx, fileList)
-		r2Creds := &rclone.CloudflareR2Credentials{
-			AccessKey: config.AccessKey,
-			SecretKey: config.SecretKey,
-			AccountID: config.AccountID,
-			Bucket:    config.Bucket,
-		}
-		fdst, err := rclone.NewR2Backend(syncCtx, r2Creds)
+		fdst, err := newRemote(syncCtx)
 		if err != nil {
 			logger.WithError(err).Error("Failed to create R2 backend")
+			sendErr = fmt.Errorf("failed to create R2 backend: %w", err)
+			return
+		}
+		destinations, err := newSendDestinations(syncCtx, fdst)
+		if err != nil {
+			logger.WithError(err).Error("Failed to create the backends of the mirrors")
+			sendErr = err
 			return
 		}
 
 		select {
 		case <-ctx.Done():
 			logger.Info("Upload cancelled before creating local filesystem")
+			sendErr = errSendCancelled
 			return
 		default:
 		}
@@ -176,71 +329,178 @@ func runSend(
 		fsrc, err := fs.NewFs(syncCtx, mountpoint)
 		if err != nil {
 			logger.WithError(err).Error("Failed to create local filesystem")
+			sendErr = fmt.Errorf("failed to create local filesystem: %w", err)
 			return
 		}
+		// the objects describe their transfer even without the database and the sidecars
+		host, _ := os.Hostname()
+		fsrc = rclone.WithMetadata(fsrc, func(remote string) map[string]string {
+			if task, ok := tasksMap[remote]; ok {
+				return woc.NewObjectMetadata(task, host, runID).Map()
+			}
+			return nil
+		})
 
 		select {
 		case <-ctx.Done():
 			logger.Info("Upload cancelled before starting file transfer")
+			sendErr = errSendCancelled
 			return
 		default:
 		}
 
+		// Upload the sidecars first, recv ignores a sidecar without its object
+		logger.Info("Uploading transfer metadata to R2...")
+		for _, d := range destinations {
+			if err := uploadTransferMetas(syncCtx, d.fs, fileList, tasksMap); err != nil {
+				logger.WithError(err).WithField("destination", d.name).Error("Failed to upload transfer metadata")
+				sendErr = err
+				return
+			}
+		}
+
+		// the files rewritten in place whose destination published its signatures only need their delta in R2,
+		// the mirrors get the full files
+		var deltaResults map[string]rclone.TransferResult
+		if deltaTransfers {
+			if deltaResults, err = uploadDeltas(syncCtx, fdst, fileList, tasksMap); err != nil {
+				logger.WithError(err).Warn("Failed to upload some deltas, uploading their files")
+			}
+			traceTransfers(syncCtx, "upload delta", deltaResults, attribute.String("syncmate.destination", primaryDestination))
+		}
+
 		uploadDone := make(chan error, 1)
+		// results of the uploaded files by destination, read after uploadDone. A retry of a batch replaces them.
+		destResults := make(map[string]map[string]rclone.TransferResult, len(destinations))
+		for _, d := range destinations {
+			destResults[d.name] = make(map[string]rclone.TransferResult)
+		}
+		maps.Copy(destResults[primaryDestination], deltaResults)
+		stopTaskProgress := reportTaskProgress()
+		defer stopTaskProgress()
 
 		// 在单独的goroutine中执行上传
 		go func() {
 			uploadDone <- rclone.Run(syncCtx, func() error {
-				return rclone.CopyFiles(syncCtx, fsrc, fdst, fileList)
+				for _, batch := range batches {
+					if softStop.Stopped() {
+						return nil
+					}
+					// every destination gets the batch before the next one starts
+					for _, d := range destinations {
+						files := batch
+						if d.name == primaryDestination {
+							files = withoutUploaded(batch, deltaResults)
+						}
+						if len(files) == 0 {
+							continue
+						}
+						batchResults, err := rclone.CopyFiles(syncCtx, fsrc, d.fs, files)
+						maps.Copy(destResults[d.name], batchResults)
+						traceTransfers(syncCtx, "upload", batchResults, attribute.String("syncmate.destination", d.name))
+						if err != nil && d.name != primaryDestination {
+							return fmt.Errorf("mirror %s: %w", d.name, err)
+						} else if err != nil {
+							return err
+						}
+					}
+				}
+				return nil
 			})
 		}()
 
 		// 等待上传完成或被中断
 		select {
 		case err := <-uploadDone:
-			if err != nil {
+			recordRcloneStats()
+			if softStop.Stopped() && (err == nil || rclone.IsSoftStopped(err)) {
+				logger.Info("Upload stopped after finishing in-flight files")
+				sendErr = errStopped
+			} else if err != nil {
 				logger.WithError(err).Error("File upload failed")
-				return
+				sendErr = fmt.Errorf("file upload failed: %w", err)
+			} else {
+				logger.Info("File upload completed successfully")
 			}
-			logger.Info("File upload completed successfully")
 		case <-ctx.Done():
 			logger.Info("Upload cancelled by user interrupt")
 			// 这里可以添加清理逻辑，比如取消正在进行的上传
+			recordRcloneStats()
+			sendErr = errSendCancelled
 			return
 		}
 
+		// After a soft stop or a failure, only checkpoint the files that made it to R2 and every mirror.
+		// The failed files stay Uploading with their error, for the next run or retry send.
+		results := mergeDestinationResults(destinations, destResults)
+		uploaded, failed := tasksMap, map[string]*woc.WocSyncTask(nil)
+		if sendErr != nil {
+			uploaded, failed = splitUploadResults(tasksMap, results)
+			logger.WithFields(logger.Fields{
+				"uploaded": len(uploaded),
+				"failed":   len(failed),
+			}).Info("Checkpointing uploaded files")
+		}
+
+		for _, task := range uploaded {
+			metrics.SetTaskState(task.VirtualPath, db.Uploaded.String())
+			recordTaskStatus(task, db.Uploaded, nil)
+		}
+		for virtualPath, task := range failed {
+			recordTaskStatus(task, db.Uploading, results[virtualPath].Err)
+		}
+
 		// 更新数据库状态为完成
 		if dbHandle != nil {
 			logger.Info("Updating task status in database...")
-			for _, task := range tasksMap {
-				// 再次检查是否被中断
-				select {
-				case <-ctx.Done():
-					logger.Info("Database update cancelled by user interrupt")
-					return
-				default:
-				}
+			// 再次检查是否被中断
+			select {
+			case <-ctx.Done():
+				logger.Info("Database update cancelled by user interrupt")
+				sendErr = errSendCancelled
+				return
+			default:
+			}
 
-				if err := dbHandle.UpdateTask(&db.Task{
-					VirtualPath: task.VirtualPath,
-					Status:      db.Uploaded,
-					SrcPath:     task.SourcePath,
-					SrcSize:     task.Size,
-					DstSize:     task.Offset,
-					SrcDigest:   *task.SourceDigest,
-					DstDigest:   *task.TargetDigest,
-				}); err != nil {
-					logger.WithError(err).WithField("virtualPath", task.VirtualPath).Error("Failed to update task status in database")
+			// the ETags let recv and status --audit notice objects that change in the bucket
+			objects, err := rclone.StatFiles(syncCtx, fdst, slices.Collect(maps.Keys(uploaded)))
+			if err != nil {
+				logger.WithError(err).Warn("Failed to read the ETags of the uploaded files")
+			}
+			if err := upsertSendTasks(slices.Collect(maps.Values(uploaded)), db.Uploaded, results, objects); err != nil {
+				logger.WithError(err).Error("Failed to update task status in database")
+			}
+			if err := upsertSendTasks(slices.Collect(maps.Values(failed)), db.Uploading, results, nil); err != nil {
+				logger.WithError(err).Error("Failed to record the failed uploads in the database")
+			}
+			if len(destinations) > 1 {
+				destObjects := map[string]map[string]rclone.RcloneFileInfo{primaryDestination: objects}
+				for _, d := range destinations[1:] {
+					if destObjects[d.name], err = rclone.StatFiles(syncCtx, d.fs, succeededFiles(destResults[d.name])); err != nil {
+						logger.WithError(err).WithField("destination", d.name).Warn("Failed to read the ETags of the mirrored files")
+					}
+				}
+				rows := destinationRows(destinations, destResults, destObjects)
+				_, span := tracing.Start(syncCtx, "db update destinations", attribute.Int("syncmate.rows", len(rows)))
+				err = dbHandle.UpdateDestinations(rows)
+				tracing.End(span, err)
+				if err != nil {
+					logger.WithError(err).Error("Failed to record the uploads to the mirrors in the database")
 				}
 			}
 		}
 
-		logger.Info("Sync tasks completed successfully")
+		if sendErr == nil {
+			logger.Info("Sync tasks completed successfully")
+		}
 	}()
 
 	mountWg.Wait()
 
-	return nil
+	if mountErr != nil {
+		return mountErr
+	}
+	return sendErr
 }
 
 var sendCmd = &cobra.Command{
@@ -252,32 +512,45 @@ var sendCmd = &cobra.Command{
 		dstPath, _ := cmd.Flags().GetString("dst")
 		configPath, _ := cmd.Flags().GetString("config")
 		skipDB, _ := cmd.Flags().GetBool("skip-db")
+		orderFlag, _ := cmd.Flags().GetString("order")
 
 		if srcPath == "" || dstPath == "" || configPath == "" {
 			cmd.Help()
 			return
 		}
 
-		if configData, err := os.ReadFile(configPath); err != nil {
-			cmd.PrintErrf("Failed to read config file %s: %v\n", configPath, err)
+		var err error
+		if transferOrder, err = parseTaskOrder(orderFlag); err != nil {
+			cmd.PrintErrf("Invalid --order: %v\n", err)
 			return
-		} else {
-			if err := json.Unmarshal(configData, &config); err != nil {
-				cmd.PrintErrf("Failed to parse config file %s: %v\n", configPath, err)
-				return
-			}
 		}
-
-		srcProfile, err := woc.ParseWocProfile(&srcPath)
+		filter, err := taskFilterFromFlags(cmd)
 		if err != nil {
-			cmd.PrintErrf("Failed to parse source profile: %v\n", err)
+			cmd.PrintErrf("Invalid filter: %v\n", err)
 			return
 		}
-		dstProfile, err := woc.ParseWocProfile(&dstPath)
+
+		if err := loadConfig(configPath); err != nil {
+			cmd.PrintErrf("%v\n", err)
+			return
+		}
+
+		// the profiles are only needed to generate the tasks
+		var srcProfile, dstProfile *woc.ParsedWocProfile
+		if tasksFile == "" {
+			srcProfile, dstProfile, err = loadProfiles(srcPath, dstPath)
+			if err != nil {
+				cmd.PrintErrf("%v\n", err)
+				return
+			}
+		}
+
+		releaseLock, err := acquireRunLock(runLockPath("send"))
 		if err != nil {
-			cmd.PrintErrf("Failed to parse destination profile: %v\n", err)
+			cmd.PrintErrf("%v\n", err)
 			return
 		}
+		defer releaseLock()
 
 		if !skipDB {
 			_, err = connectDB()
@@ -287,16 +560,40 @@ var sendCmd = &cobra.Command{
 			}
 		}
 
-		tasksMap, err := generateTasks(srcProfile, dstProfile, true)
+		stopTracing, err := startTracing(cmd, "send")
+		if err != nil {
+			cmd.PrintErrf("Failed to export traces: %v\n", err)
+			return
+		}
+		defer stopTracing()
+
+		tasksMap, err := loadTasks(srcProfile, dstProfile, true)
 		if err != nil {
 			cmd.PrintErrf("Failed to generate tasks: %v\n", err)
 			return
 		}
+		filterTasks(tasksMap, filter)
 
 		logger.WithField("taskCount", len(tasksMap)).Info("Generated tasks for file transfer")
 
+		stopMetrics, err := startMetrics(cmd, "upload")
+		if err != nil {
+			cmd.PrintErrf("Failed to serve metrics: %v\n", err)
+			return
+		}
+		defer stopMetrics()
+		stopRC, err := startRC(cmd)
+		if err != nil {
+			cmd.PrintErrf("Failed to serve the remote control API: %v\n", err)
+			return
+		}
+		defer stopRC()
+
 		if len(tasksMap) > 0 {
-			if err := runSend(tasksMap); err != nil {
+			startRun("send")
+			err := runSend(tasksMap)
+			finishRun(err)
+			if err != nil {
 				cmd.PrintErrf("Failed to run send operation: %v\n", err)
 				return
 			}
@@ -307,9 +604,29 @@ var sendCmd = &cobra.Command{
 }
 
 func init() {
-	sendCmd.Flags().StringP("src", "s", "woc.src.json", "WoC profile of the transfer source")
-	sendCmd.Flags().StringP("dst", "d", "woc.dst.json", "Woc profile of the transfer destination")
+	sendCmd.Flags().StringP("src", "s", "woc.src.json", "WoC profile of the transfer source, a path or URL")
+	sendCmd.Flags().StringP("dst", "d", "woc.dst.json", "Woc profile of the transfer destination, a path or URL")
 	sendCmd.Flags().StringP("config", "c", "config.json", "Path to the configuration file")
 	sendCmd.Flags().Bool("skip-db", false, "Skip database operations")
+	sendCmd.Flags().String("order", string(OrderSmallestFirst), "Upload order: smallest-first, largest-first, by-map, or by-priority-column")
+	addTaskFilterFlags(sendCmd)
+	addDigestWorkersFlag(sendCmd)
+	addStrictFlag(sendCmd)
+	addSkipBadShardsFlag(sendCmd)
+	addAllVersionsFlag(sendCmd)
+	addDatasetFlags(sendCmd)
+	addMetricsAddrFlag(sendCmd)
+	addRCFlags(sendCmd)
+	addTransferLimitFlags(sendCmd)
+	addLeaseFlags(sendCmd)
+	addReportFileFlag(sendCmd)
+	addRunDoneHookFlag(sendCmd)
+	addRemotePrefixFlag(sendCmd)
+	addRunIDFlag(sendCmd)
+	addTasksFileFlag(sendCmd)
+	addDBProgressIntervalFlag(sendCmd)
+	addDirectServeFlag(sendCmd)
+	addDeltaFlag(sendCmd)
+	addTracingFlag(sendCmd)
 	RootCmd.AddCommand(sendCmd)
 }
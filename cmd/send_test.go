@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/hrz6976/syncmate/offsetfs"
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitUploadResults(t *testing.T) {
+	tasksMap := make(map[string]*woc.WocSyncTask)
+	for _, name := range []string{"a.bin", "b.bin", "c.bin", "d.bin"} {
+		tasksMap[name] = &woc.WocSyncTask{FileConfig: offsetfs.FileConfig{VirtualPath: name}}
+	}
+	results := map[string]rclone.TransferResult{
+		"a.bin": {Name: "a.bin", Bytes: 10},
+		"b.bin": {Name: "b.bin", Err: errors.New("connection reset")},
+		"c.bin": {Name: "c.bin", Checked: true},
+	}
+
+	uploaded, failed := splitUploadResults(tasksMap, results)
+	assert.ElementsMatch(t, []string{"a.bin", "c.bin"}, slices.Collect(maps.Keys(uploaded)))
+	assert.ElementsMatch(t, []string{"b.bin"}, slices.Collect(maps.Keys(failed)))
+}
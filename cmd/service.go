@@ -0,0 +1,460 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// serviceKinds are the commands service install writes units for, see newServiceUnit
+var serviceKinds = []string{"recv", "send", "mount"}
+
+// daemonStopTimeout is how long systemd waits for a stopped daemon to finish the files in flight
+const daemonStopTimeout = 15 * time.Minute
+
+// serviceUnit is a systemd service running a syncmate command
+type serviceUnit struct {
+	Kind        string
+	Description string
+	// Notify is set for the commands that tell systemd when they are ready, Type=notify
+	Notify    bool
+	ExecStart []string
+	// ExecStartPre and ExecStopPost run before and after the command, their failures are ignored
+	ExecStartPre     []string
+	ExecStopPost     []string
+	WorkingDirectory string
+	EnvironmentFile  string
+	RunAs            string
+	After            string
+	Watchdog         time.Duration
+	RestartSec       time.Duration
+	StopTimeout      time.Duration
+	// UserUnit is set for the units of systemctl --user
+	UserUnit bool
+}
+
+// serviceOptions are the flags of service install
+type serviceOptions struct {
+	workingDir string
+	envFile    string
+	runAs      string
+	watchdog   time.Duration
+	restartSec time.Duration
+	userUnit   bool
+}
+
+// newServiceUnit returns the unit of kind running the syncmate binary at exe with args, which are
+// checked against the flags of the command
+func newServiceUnit(kind, exe string, args []string, opt serviceOptions) (*serviceUnit, error) {
+	u := &serviceUnit{
+		Kind:             kind,
+		WorkingDirectory: opt.workingDir,
+		EnvironmentFile:  opt.envFile,
+		RunAs:            opt.runAs,
+		RestartSec:       opt.restartSec,
+		UserUnit:         opt.userUnit,
+	}
+	switch kind {
+	case "recv", "send":
+		if _, err := parseServiceArgs(daemonCmd, args); err != nil {
+			return nil, fmt.Errorf("invalid arguments of syncmate daemon %s: %w", kind, err)
+		}
+		u.Description = fmt.Sprintf("SyncMate %s daemon", kind)
+		u.Notify = true
+		u.ExecStart = append([]string{exe, "daemon", kind}, args...)
+		u.After = "network-online.target"
+		u.Watchdog = opt.watchdog
+		u.StopTimeout = daemonStopTimeout
+	case "mount":
+		positional, err := parseServiceArgs(mountCmd, args)
+		if err != nil {
+			return nil, fmt.Errorf("invalid arguments of syncmate mount: %w", err)
+		}
+		if len(positional) != 1 {
+			return nil, fmt.Errorf("syncmate mount needs exactly one mountpoint, got %d", len(positional))
+		}
+		mountpoint := positional[0]
+		if !filepath.IsAbs(mountpoint) {
+			mountpoint = filepath.Join(opt.workingDir, mountpoint)
+		}
+		// a crashed mount leaves its mountpoint unusable until it is unmounted
+		unmount := []string{fusermountPath(), "-u", mountpoint}
+		u.Description = "SyncMate OffsetFS mount at " + mountpoint
+		u.ExecStart = append([]string{exe, "mount"}, args...)
+		u.ExecStartPre = unmount
+		u.ExecStopPost = unmount
+		u.After = "remote-fs.target"
+	default:
+		return nil, fmt.Errorf("unknown service %q, must be one of %v", kind, serviceKinds)
+	}
+	return u, nil
+}
+
+// parseServiceArgs parses args with the flags of cmd and the global flags, and returns the arguments
+// that aren't flags. The flags of cmd are left untouched.
+func parseServiceArgs(cmd *cobra.Command, args []string) ([]string, error) {
+	flags := pflag.NewFlagSet(cmd.Name(), pflag.ContinueOnError)
+	flags.SetOutput(&bytes.Buffer{})
+	for _, set := range []*pflag.FlagSet{cmd.Flags(), RootCmd.PersistentFlags()} {
+		set.VisitAll(func(f *pflag.Flag) {
+			if flags.Lookup(f.Name) == nil {
+				copied := *f
+				copied.Value = &stringValue{typ: f.Value.Type()}
+				flags.AddFlag(&copied)
+			}
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	return flags.Args(), nil
+}
+
+// stringValue takes any value of a flag, the command checks them when it runs
+type stringValue struct {
+	value string
+	typ   string
+}
+
+func (v *stringValue) String() string { return v.value }
+func (v *stringValue) Set(s string) error {
+	v.value = s
+	return nil
+}
+func (v *stringValue) Type() string { return v.typ }
+
+func fusermountPath() string {
+	for _, name := range []string{"fusermount3", "fusermount"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return path
+		}
+	}
+	return "/bin/fusermount"
+}
+
+// unitName returns the default name of the unit of kind
+func unitName(kind string) string {
+	return "syncmate-" + kind + ".service"
+}
+
+// render returns the unit file of u
+func (u *serviceUnit) render() string {
+	var b strings.Builder
+	line := func(key, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "%s=%s\n", key, value)
+		}
+	}
+	b.WriteString("# Generated by syncmate service install " + u.Kind + "\n")
+	b.WriteString("[Unit]\n")
+	line("Description", u.Description)
+	line("Documentation", "https://github.com/hrz6976/syncmate")
+	line("After", u.After)
+	if u.After == "network-online.target" {
+		line("Wants", u.After)
+	}
+
+	b.WriteString("\n[Service]\n")
+	if u.Notify {
+		line("Type", "notify")
+		// the hooks may not report for the daemon
+		line("NotifyAccess", "main")
+	} else {
+		line("Type", "simple")
+	}
+	if len(u.ExecStartPre) > 0 {
+		line("ExecStartPre", "-"+systemdCommandLine(u.ExecStartPre))
+	}
+	line("ExecStart", systemdCommandLine(u.ExecStart))
+	if len(u.ExecStopPost) > 0 {
+		line("ExecStopPost", "-"+systemdCommandLine(u.ExecStopPost))
+	}
+	line("WorkingDirectory", systemdEscape(u.WorkingDirectory))
+	if u.EnvironmentFile != "" {
+		line("EnvironmentFile", systemdEscape(u.EnvironmentFile))
+	}
+	if !u.UserUnit {
+		line("User", u.RunAs)
+	}
+	line("Restart", "on-failure")
+	if u.RestartSec > 0 {
+		line("RestartSec", systemdDuration(u.RestartSec))
+	}
+	if u.Watchdog > 0 {
+		line("WatchdogSec", systemdDuration(u.Watchdog))
+	}
+	if u.StopTimeout > 0 {
+		// SIGTERM to the daemon, which finishes the files in flight, SIGKILL to all after the timeout
+		line("KillMode", "mixed")
+		line("TimeoutStopSec", systemdDuration(u.StopTimeout))
+	}
+
+	b.WriteString("\n[Install]\n")
+	if u.UserUnit {
+		line("WantedBy", "default.target")
+	} else {
+		line("WantedBy", "multi-user.target")
+	}
+	return b.String()
+}
+
+func systemdDuration(d time.Duration) string {
+	return fmt.Sprintf("%ds", int64(d.Round(time.Second)/time.Second))
+}
+
+var systemdPlainArg = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./~-]+$`)
+
+// systemdEscape doubles the % of specifiers and the $ of variables, which systemd expands in unit files
+func systemdEscape(s string) string {
+	return strings.NewReplacer("%", "%%", "$", "$$").Replace(s)
+}
+
+// systemdCommandLine quotes args for the Exec lines of a unit
+func systemdCommandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		arg = systemdEscape(arg)
+		if !systemdPlainArg.MatchString(arg) {
+			arg = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+// defaultUnitDir is where systemd finds the units of the administrator, or of the user with userUnit
+func defaultUnitDir(userUnit bool) (string, error) {
+	if !userUnit {
+		return "/etc/systemd/system", nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "systemd", "user"), nil
+}
+
+// systemctl runs systemctl, of the user with userUnit, and returns its output
+var systemctl = func(userUnit bool, args ...string) ([]byte, error) {
+	if userUnit {
+		args = append([]string{"--user"}, args...)
+	}
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return out, fmt.Errorf("systemctl %s: %w: %s", strings.Join(args, " "), err, msg)
+		}
+		return out, fmt.Errorf("systemctl %s: %w", strings.Join(args, " "), err)
+	}
+	return out, nil
+}
+
+// unitStatusProperties are the properties service status shows, in the order of its columns
+var unitStatusProperties = []string{"Id", "LoadState", "ActiveState", "SubState", "MainPID", "NRestarts", "ActiveEnterTimestamp", "StatusText"}
+
+// parseUnitProperties reads the key=value lines of systemctl show, one unit after the other,
+// separated by empty lines
+func parseUnitProperties(out []byte) []map[string]string {
+	var units []map[string]string
+	var unit map[string]string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			unit = nil
+			continue
+		}
+		if unit == nil {
+			unit = make(map[string]string)
+			units = append(units, unit)
+		}
+		unit[key] = value
+	}
+	return units
+}
+
+// printUnitStatus prints a table of the units of systemctl show
+func printUnitStatus(cmd *cobra.Command, units []map[string]string) {
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "UNIT\tLOADED\tACTIVE\tSINCE\tPID\tRESTARTS\tSTATUS")
+	for _, u := range units {
+		pid := u["MainPID"]
+		if pid == "0" {
+			pid = "-"
+		}
+		since := u["ActiveEnterTimestamp"]
+		if since == "" || u["ActiveState"] != "active" {
+			since = "-"
+		}
+		status := u["StatusText"]
+		if status == "" {
+			status = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s (%s)\t%s\t%s\t%s\t%s\n", u["Id"], u["LoadState"], u["ActiveState"], u["SubState"], since, pid, u["NRestarts"], status)
+	}
+	w.Flush()
+}
+
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Run the daemons and the mount as systemd services",
+}
+
+var serviceInstallCmd = &cobra.Command{
+	Use:   "install {recv|send|mount} [-- args...]",
+	Short: "Write the systemd unit of a daemon or of the mount",
+	Long: `Write the systemd unit of "syncmate daemon recv", "syncmate daemon send" or
+"syncmate mount", so they start at boot and restart when they fail. The
+arguments after -- are passed to the command, e.g.
+
+  syncmate service install recv -- -c /etc/syncmate/config.json -C /data/cache --interval 1h
+
+The daemons report their readiness and state to systemd and ping its watchdog.
+The mount is unmounted before it starts and after it stops.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		kind := args[0]
+		name, _ := cmd.Flags().GetString("name")
+		unitDir, _ := cmd.Flags().GetString("unit-dir")
+		printOnly, _ := cmd.Flags().GetBool("print")
+		enable, _ := cmd.Flags().GetBool("enable")
+		var opt serviceOptions
+		opt.workingDir, _ = cmd.Flags().GetString("working-dir")
+		opt.envFile, _ = cmd.Flags().GetString("env-file")
+		opt.runAs, _ = cmd.Flags().GetString("run-as")
+		opt.watchdog, _ = cmd.Flags().GetDuration("watchdog")
+		opt.restartSec, _ = cmd.Flags().GetDuration("restart-sec")
+		opt.userUnit, _ = cmd.Flags().GetBool("user")
+
+		var err error
+		if opt.workingDir == "" {
+			if opt.workingDir, err = os.Getwd(); err != nil {
+				cmd.PrintErrf("Failed to get the working directory: %v\n", err)
+				return
+			}
+		} else if opt.workingDir, err = filepath.Abs(opt.workingDir); err != nil {
+			cmd.PrintErrf("Invalid --working-dir: %v\n", err)
+			return
+		}
+		if opt.envFile != "" {
+			if opt.envFile, err = filepath.Abs(opt.envFile); err != nil {
+				cmd.PrintErrf("Invalid --env-file: %v\n", err)
+				return
+			}
+		}
+		exe, err := os.Executable()
+		if err == nil {
+			exe, err = filepath.EvalSymlinks(exe)
+		}
+		if err != nil {
+			cmd.PrintErrf("Failed to find the syncmate binary: %v\n", err)
+			return
+		}
+		unit, err := newServiceUnit(kind, exe, args[1:], opt)
+		if err != nil {
+			cmd.PrintErrf("%v\n", err)
+			return
+		}
+		if printOnly {
+			fmt.Fprint(cmd.OutOrStdout(), unit.render())
+			return
+		}
+
+		if name == "" {
+			name = unitName(kind)
+		} else if !strings.HasSuffix(name, ".service") {
+			name += ".service"
+		}
+		if unitDir == "" {
+			if unitDir, err = defaultUnitDir(opt.userUnit); err != nil {
+				cmd.PrintErrf("Failed to find the unit directory: %v\n", err)
+				return
+			}
+		}
+		if err := os.MkdirAll(unitDir, 0755); err != nil {
+			cmd.PrintErrf("Failed to create the unit directory: %v\n", err)
+			return
+		}
+		path := filepath.Join(unitDir, name)
+		if err := os.WriteFile(path, []byte(unit.render()), 0644); err != nil {
+			cmd.PrintErrf("Failed to write the unit: %v\n", err)
+			return
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Wrote %s\n", path)
+
+		if _, err := systemctl(opt.userUnit, "daemon-reload"); err != nil {
+			logger.WithError(err).Warn("Failed to reload the units of systemd")
+		}
+		userFlag := ""
+		if opt.userUnit {
+			userFlag = "--user "
+		}
+		if !enable {
+			fmt.Fprintf(cmd.OutOrStdout(), "Start it at boot with: systemctl %senable --now %s\n", userFlag, name)
+			return
+		}
+		if _, err := systemctl(opt.userUnit, "enable", "--now", name); err != nil {
+			cmd.PrintErrf("Failed to enable %s: %v\n", name, err)
+			return
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Enabled and started %s, see syncmate service status %s\n", name, strings.TrimSuffix(name, ".service"))
+	},
+}
+
+var serviceStatusCmd = &cobra.Command{
+	Use:   "status [unit...]",
+	Short: "Show the state of the syncmate services",
+	Long: `Show whether the units of service install are running, since when, how often
+they restarted and the state the daemons reported, e.g. the cycle they run.
+Without units, shows syncmate-recv, syncmate-send and syncmate-mount.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		userUnit, _ := cmd.Flags().GetBool("user")
+		names := args
+		if len(names) == 0 {
+			for _, kind := range serviceKinds {
+				names = append(names, unitName(kind))
+			}
+		}
+		query := []string{"show", "--property=" + strings.Join(unitStatusProperties, ",")}
+		for _, name := range names {
+			if !strings.Contains(name, ".") {
+				name += ".service"
+			}
+			query = append(query, name)
+		}
+		out, err := systemctl(userUnit, query...)
+		if err != nil {
+			cmd.PrintErrf("%v\n", err)
+			return
+		}
+		printUnitStatus(cmd, parseUnitProperties(out))
+	},
+}
+
+func init() {
+	serviceInstallCmd.Flags().String("name", "", "Name of the unit (default: syncmate-<service>.service)")
+	serviceInstallCmd.Flags().String("unit-dir", "", "Directory of the unit file (default: /etc/systemd/system, or ~/.config/systemd/user with --user)")
+	serviceInstallCmd.Flags().Bool("user", false, "Install a unit of the systemd instance of this user instead of the system")
+	serviceInstallCmd.Flags().Bool("print", false, "Print the unit instead of writing it")
+	serviceInstallCmd.Flags().Bool("enable", false, "Enable and start the unit after writing it")
+	serviceInstallCmd.Flags().String("working-dir", "", "Working directory of the service, where relative paths of the arguments start (default: the current directory)")
+	serviceInstallCmd.Flags().String("env-file", "", "File of environment variables of the service, e.g. the SYNCMATE_* credentials")
+	serviceInstallCmd.Flags().String("run-as", "", "User the system unit runs as (default: root)")
+	serviceInstallCmd.Flags().Duration("watchdog", 10*time.Minute, "Restart a daemon that stops pinging the systemd watchdog for this long, 0 disables it")
+	serviceInstallCmd.Flags().Duration("restart-sec", time.Minute, "Time to wait before restarting a failed service")
+	serviceStatusCmd.Flags().Bool("user", false, "Show the units of the systemd instance of this user")
+	serviceCmd.AddCommand(serviceInstallCmd)
+	serviceCmd.AddCommand(serviceStatusCmd)
+	RootCmd.AddCommand(serviceCmd)
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewServiceUnit_Daemon(t *testing.T) {
+	opt := serviceOptions{workingDir: "/srv/syncmate", envFile: "/etc/syncmate/env", runAs: "woc", watchdog: 10 * time.Minute, restartSec: time.Minute}
+	unit, err := newServiceUnit("recv", "/usr/local/bin/syncmate", []string{"-c", "config.json", "-C", "/data/cache", "--delete-remote=false", "-vv", "--on-file-done", "chmod 444 {dst}"}, opt)
+	require.NoError(t, err)
+	rendered := unit.render()
+	for _, line := range []string{
+		"Description=SyncMate recv daemon\n",
+		"After=network-online.target\nWants=network-online.target\n",
+		"Type=notify\n",
+		"NotifyAccess=main\n",
+		`ExecStart=/usr/local/bin/syncmate daemon recv -c config.json -C /data/cache --delete-remote=false -vv --on-file-done "chmod 444 {dst}"` + "\n",
+		"WorkingDirectory=/srv/syncmate\n",
+		"EnvironmentFile=/etc/syncmate/env\n",
+		"User=woc\n",
+		"RestartSec=60s\n",
+		"WatchdogSec=600s\n",
+		"KillMode=mixed\nTimeoutStopSec=900s\n",
+		"WantedBy=multi-user.target\n",
+	} {
+		assert.Contains(t, rendered, line)
+	}
+
+	_, err = newServiceUnit("send", "/usr/local/bin/syncmate", []string{"--no-such-flag"}, opt)
+	assert.ErrorContains(t, err, "invalid arguments of syncmate daemon send")
+	_, err = newServiceUnit("taskgen", "/usr/local/bin/syncmate", nil, opt)
+	assert.ErrorContains(t, err, `unknown service "taskgen"`)
+}
+
+func TestNewServiceUnit_Mount(t *testing.T) {
+	opt := serviceOptions{workingDir: "/srv/syncmate", userUnit: true, runAs: "woc"}
+	unit, err := newServiceUnit("mount", "/usr/local/bin/syncmate", []string{"mnt", "-c", "tasks.jsonl", "-r"}, opt)
+	require.NoError(t, err)
+	rendered := unit.render()
+	assert.Contains(t, rendered, "Type=simple\n")
+	assert.Contains(t, rendered, "ExecStart=/usr/local/bin/syncmate mount mnt -c tasks.jsonl -r\n")
+	assert.Contains(t, rendered, " -u /srv/syncmate/mnt\n")
+	assert.Contains(t, rendered, "ExecStartPre=-")
+	assert.Contains(t, rendered, "ExecStopPost=-")
+	assert.Contains(t, rendered, "WantedBy=default.target\n")
+	assert.NotContains(t, rendered, "User=")
+	assert.NotContains(t, rendered, "WatchdogSec")
+
+	_, err = newServiceUnit("mount", "/usr/local/bin/syncmate", []string{"-c", "tasks.jsonl"}, opt)
+	assert.ErrorContains(t, err, "needs exactly one mountpoint, got 0")
+}
+
+func TestSystemdCommandLine(t *testing.T) {
+	assert.Equal(t, `/bin/syncmate --cron "0 3 * * *" 100%% "$$HOME" "a \"b\" c\\d"`,
+		systemdCommandLine([]string{"/bin/syncmate", "--cron", "0 3 * * *", "100%", "$HOME", `a "b" c\d`}))
+}
+
+func TestParseUnitProperties(t *testing.T) {
+	out := []byte("Id=syncmate-recv.service\nLoadState=loaded\nActiveState=active\nSubState=running\nMainPID=4242\nNRestarts=1\n" +
+		"ActiveEnterTimestamp=Tue 2025-01-07 03:00:00 EST\nStatusText=Running sync cycle 3\n\n" +
+		"Id=syncmate-mount.service\nLoadState=not-found\nActiveState=inactive\nSubState=dead\nMainPID=0\nNRestarts=0\nActiveEnterTimestamp=\nStatusText=\n")
+	units := parseUnitProperties(out)
+	require.Len(t, units, 2)
+	assert.Equal(t, "Running sync cycle 3", units[0]["StatusText"])
+	assert.Equal(t, "not-found", units[1]["LoadState"])
+
+	cmd := &cobra.Command{}
+	var buf bytes.Buffer
+	cmd.SetOut(&buf)
+	printUnitStatus(cmd, units)
+	assert.Equal(t, `UNIT                    LOADED     ACTIVE            SINCE                        PID   RESTARTS  STATUS
+syncmate-recv.service   loaded     active (running)  Tue 2025-01-07 03:00:00 EST  4242  1         Running sync cycle 3
+syncmate-mount.service  not-found  inactive (dead)   -                            -     0         -
+`, buf.String())
+}
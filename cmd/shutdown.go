@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/hrz6976/syncmate/rclone"
+	logger "github.com/sirupsen/logrus"
+)
+
+var errStopped = errors.New("transfer stopped by user interrupt after finishing in-flight files")
+
+// handleInterrupts soft-stops the transfer on the first SIGINT/SIGTERM, letting
+// in-flight files finish, and calls abort on the second one.
+//
+// It returns a func which should be called when the transfer is over.
+func handleInterrupts(softStop *rclone.SoftStop, abort func()) func() {
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-sigChan:
+		case <-done:
+			return
+		}
+		logger.Warn("Received interrupt signal, finishing in-flight files. Interrupt again to abort")
+		softStop.Stop()
+
+		select {
+		case <-sigChan:
+		case <-done:
+			return
+		}
+		logger.Warn("Received second interrupt signal, aborting")
+		abort()
+	}()
+	return func() {
+		signal.Stop(sigChan)
+		close(done)
+	}
+}
@@ -4,10 +4,18 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/hrz6976/syncmate/db"
 	"github.com/hrz6976/syncmate/rclone"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/rclone/rclone/fs"
 	logger "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -26,114 +34,635 @@ func formatSize(bytes int64) string {
 }
 
 type StatusSummary struct {
-	Count int64
-	Size  int64
+	Count int64 `json:"count"`
+	Size  int64 `json:"size"`
 }
 
-func runStatus(configPath string, skipDB bool) error {
-	// Load configuration
-	if configData, err := os.ReadFile(configPath); err != nil {
-		return fmt.Errorf("failed to read config file %s: %w", configPath, err)
-	} else {
-		if err := json.Unmarshal(configData, &config); err != nil {
-			return fmt.Errorf("failed to parse config file %s: %w", configPath, err)
-		}
+// statusSnapshot is the state reported by status
+type statusSnapshot struct {
+	Database struct {
+		Skipped   bool   `json:"skipped"`
+		Reachable bool   `json:"reachable"`
+		Error     string `json:"error,omitempty"`
+		// Statuses counts the tasks of the database by status name
+		Statuses   map[string]StatusSummary `json:"statuses,omitempty"`
+		Throughput *statusThroughput        `json:"throughput,omitempty"`
+	} `json:"database"`
+	R2 struct {
+		Reachable bool   `json:"reachable"`
+		Error     string `json:"error,omitempty"`
+		Files     int64  `json:"files"`
+		Bytes     int64  `json:"bytes"`
+	} `json:"r2"`
+}
+
+// statusThroughput is the recent download rate and the projected completion of the remaining tasks
+type statusThroughput struct {
+	Window       string `json:"window"`
+	BytesPerHour int64  `json:"bytes_per_hour"`
+	// RemainingBytes are the tasks that are neither Downloaded nor Failed
+	RemainingBytes int64      `json:"remaining_bytes"`
+	ETA            *time.Time `json:"eta,omitempty"`
+}
+
+// statusRateWindow is the period whose downloads give the transfer rate of status
+var statusRateWindow time.Duration
+
+func newThroughput(downloaded int64, window time.Duration, remaining int64, now time.Time) *statusThroughput {
+	t := &statusThroughput{
+		Window:         window.String(),
+		BytesPerHour:   int64(float64(downloaded) / window.Hours()),
+		RemainingBytes: remaining,
+	}
+	if downloaded > 0 {
+		eta := now.Add(time.Duration(float64(remaining) / float64(downloaded) * float64(window))).Round(time.Minute)
+		t.ETA = &eta
+	}
+	return t
+}
+
+func (t *statusThroughput) print(w io.Writer, now time.Time) {
+	if t == nil {
+		return
 	}
+	fmt.Fprintf(w, "\nDownloaded %s/h over the last %s, %s remaining", formatSize(t.BytesPerHour), t.Window, formatSize(t.RemainingBytes))
+	switch {
+	case t.RemainingBytes == 0:
+		fmt.Fprintln(w)
+	case t.ETA == nil:
+		fmt.Fprintln(w, ", no estimate without recent downloads")
+	default:
+		fmt.Fprintf(w, ", done in %s (%s)\n", t.ETA.Sub(now).Round(time.Minute), t.ETA.Local().Format(time.DateTime))
+	}
+}
+
+// statuses reported from the database, in table order
+var snapshotStatuses = []db.Status{db.Pending, db.Uploading, db.Downloading, db.Downloaded, db.Failed}
 
-	stats := make(map[db.Status]StatusSummary)
+// status exit codes, so that monitoring can tell stuck transfers from an unreachable database or bucket
+const (
+	statusExitFailedTasks = 2
+	statusExitUnreachable = 3
+	statusExitAuditFailed = 4
+)
+
+// exitCode is 0 if the database and R2 were reachable and no task failed
+func (s *statusSnapshot) exitCode() int {
+	if (!s.Database.Skipped && !s.Database.Reachable) || !s.R2.Reachable {
+		return statusExitUnreachable
+	}
+	if s.Database.Statuses[db.Failed.String()].Count > 0 {
+		return statusExitFailedTasks
+	}
+	return 0
+}
+
+func collectStatus(skipDB bool) *statusSnapshot {
+	snapshot := &statusSnapshot{}
 
 	// Database statistics
-	if !skipDB {
-		dbHandle, err := connectDB()
+	if skipDB {
+		snapshot.Database.Skipped = true
+	} else if dbHandle, err := connectDB(); err != nil {
+		snapshot.Database.Error = fmt.Sprintf("failed to connect to database: %v", err)
+	} else {
+		summaries, err := dbHandle.SummarizeByStatus()
 		if err != nil {
-			return fmt.Errorf("failed to connect to database: %w", err)
+			logger.WithError(err).Warn("Failed to get status summary")
+			snapshot.Database.Error = err.Error()
+		} else {
+			snapshot.Database.Reachable = true
+			snapshot.Database.Statuses = make(map[string]StatusSummary)
+			for _, status := range snapshotStatuses {
+				summary := summaries[status]
+				snapshot.Database.Statuses[status.String()] = StatusSummary{
+					Count: summary.Count,
+					Size:  summary.Size,
+				}
+			}
+			if statusRateWindow > 0 {
+				snapshot.Database.Throughput = collectThroughput(dbHandle, summaries)
+			}
+		}
+	}
+
+	fileInfos, err := listR2Objects()
+	if err != nil {
+		snapshot.R2.Error = err.Error()
+		return snapshot
+	}
+	snapshot.R2.Reachable = true
+	for _, fileInfo := range fileInfos {
+		snapshot.R2.Bytes += fileInfo.Size
+		snapshot.R2.Files++
+	}
+	return snapshot
+}
+
+// newStatusR2Backend connects to the bucket of the configuration
+func newStatusR2Backend() (context.Context, fs.Fs, error) {
+	ctx := rclone.InjectConfig(context.Background())
+	fdst, err := newRemote(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error connecting: %w", err)
+	}
+	return ctx, fdst, nil
+}
+
+// listR2Objects lists the transferred files in the bucket, without the transfer metadata sidecars
+func listR2Objects() ([]rclone.RcloneFileInfo, error) {
+	ctx, fdst, err := newStatusR2Backend()
+	if err != nil {
+		return nil, err
+	}
+	fileInfos, err := rclone.ListFiles(ctx, fdst)
+	if err != nil {
+		return nil, fmt.Errorf("error listing files: %w", err)
+	}
+	objects := fileInfos[:0]
+	for _, fileInfo := range fileInfos {
+		// the deltas are uploaded instead of their files, they are kept
+		if !woc.IsMetaPath(fileInfo.Name) && !strings.HasSuffix(fileInfo.Name, woc.SignatureSuffix) {
+			objects = append(objects, fileInfo)
 		}
+	}
+	return objects, nil
+}
+
+// collectThroughput sums the recent downloads and the remaining tasks, nil if the database fails
+func collectThroughput(dbHandle *db.DB, summaries map[db.Status]db.StatusSummary) *statusThroughput {
+	now := time.Now()
+	downloaded, err := dbHandle.DownloadedSince(now.Add(-statusRateWindow))
+	if err != nil {
+		logger.WithError(err).Warn("Failed to get recent downloads")
+		return nil
+	}
+	// Uploaded tasks are counted on R2 in the table, but still remain to be downloaded
+	var remaining int64
+	for _, status := range []db.Status{db.Pending, db.Uploading, db.Uploaded, db.Downloading} {
+		remaining += summaries[status].Size
+	}
+	return newThroughput(downloaded.Size, statusRateWindow, remaining, now)
+}
+
+// print writes the snapshot as a table, the files on R2 are the Uploaded ones
+func (s *statusSnapshot) print(w io.Writer) {
+	switch {
+	case s.Database.Skipped:
+		fmt.Fprintln(w, "Database Status: Skipped (--skip-db flag)")
+	case s.Database.Error != "":
+		fmt.Fprintf(w, "Database Status: Error - %s\n", s.Database.Error)
+	}
+	if s.R2.Error != "" {
+		fmt.Fprintf(w, "R2 Backend: %s\n", s.R2.Error)
+	}
+
+	fmt.Fprintf(w, "%-12s %-8s %-12s\n", "Status", "Count", "Total Size")
+	fmt.Fprintf(w, "%-12s %-8s %-12s\n", "------", "-----", "----------")
+	for _, row := range s.rows() {
+		fmt.Fprintf(w, "%-12s %-8d %-12s\n", row.name, row.Count, formatSize(row.Size))
+	}
+	s.Database.Throughput.print(w, time.Now())
+}
+
+type statusRow struct {
+	name string
+	StatusSummary
+}
 
-		// Get statistics for each status
-		statuses := []struct {
-			status db.Status
-			name   string
-		}{
-			{db.Uploading, "Uploading"},
-			{db.Downloaded, "Downloaded"},
+// rows are the lines of the status table
+func (s *statusSnapshot) rows() []statusRow {
+	var rows []statusRow
+	for _, status := range snapshotStatuses {
+		if stat, ok := s.Database.Statuses[status.String()]; ok {
+			rows = append(rows, statusRow{status.String(), stat})
 		}
+	}
+	if s.R2.Reachable {
+		rows = append(rows, statusRow{db.Uploaded.String(), StatusSummary{Count: s.R2.Files, Size: s.R2.Bytes}})
+	}
+	return rows
+}
 
-		for _, statusInfo := range statuses {
-			summary, err := dbHandle.GetTasksByStatus(statusInfo.status)
-			if err != nil {
-				logger.WithError(err).WithField("status", statusInfo.name).Warn("Failed to get status summary")
-				stats[statusInfo.status] = StatusSummary{
-					Count: 0,
-					Size:  0,
-				}
-				continue
+// printDelta writes the table with the change of each row since prev, elapsed earlier
+func (s *statusSnapshot) printDelta(w io.Writer, prev *statusSnapshot, elapsed time.Duration) {
+	before := make(map[string]StatusSummary)
+	for _, row := range prev.rows() {
+		before[row.name] = row.StatusSummary
+	}
+	if s.Database.Error != "" {
+		fmt.Fprintf(w, "Database Status: Error - %s\n", s.Database.Error)
+	}
+	if s.R2.Error != "" {
+		fmt.Fprintf(w, "R2 Backend: %s\n", s.R2.Error)
+	}
+	fmt.Fprintf(w, "%-12s %-8s %-12s %-8s %-12s\n", "Status", "Count", "Total Size", "Change", "Rate")
+	fmt.Fprintf(w, "%-12s %-8s %-12s %-8s %-12s\n", "------", "-----", "----------", "------", "----")
+	for _, row := range s.rows() {
+		old, ok := before[row.name]
+		if !ok {
+			fmt.Fprintf(w, "%-12s %-8d %-12s %-8s %-12s\n", row.name, row.Count, formatSize(row.Size), "-", "-")
+			continue
+		}
+		rate := "-"
+		if elapsed > 0 {
+			delta, sign := row.Size-old.Size, ""
+			if delta < 0 {
+				// e.g. objects deleted from R2 after download
+				delta, sign = -delta, "-"
 			}
-			stats[statusInfo.status] = StatusSummary{
-				Count: summary.Count,
-				Size:  summary.Size,
+			rate = sign + formatSize(int64(float64(delta)/elapsed.Seconds())) + "/s"
+		}
+		fmt.Fprintf(w, "%-12s %-8d %-12s %-+8d %-12s\n", row.name, row.Count, formatSize(row.Size), row.Count-old.Count, rate)
+	}
+	s.Database.Throughput.print(w, time.Now())
+}
+
+// clearScreen moves the cursor home and clears the terminal, so the table is redrawn in place
+const clearScreen = "\033[H\033[2J"
+
+// watchStatus samples the status every interval until ctx is done and returns the exit code of the last sample
+func watchStatus(ctx context.Context, w io.Writer, interval time.Duration, skipDB, asJSON bool) int {
+	var prev *statusSnapshot
+	var prevAt time.Time
+	for {
+		snapshot := collectStatus(skipDB)
+		now := time.Now()
+		if asJSON {
+			// one snapshot per line
+			data, err := json.Marshal(struct {
+				Time time.Time `json:"time"`
+				*statusSnapshot
+			}{now, snapshot})
+			if err == nil {
+				fmt.Fprintln(w, string(data))
+			}
+		} else {
+			fmt.Fprint(w, clearScreen)
+			fmt.Fprintf(w, "Every %s, sampled at %s\n\n", interval, now.Format(time.DateTime))
+			if prev == nil {
+				snapshot.print(w)
+			} else {
+				snapshot.printDelta(w, prev, now.Sub(prevAt))
 			}
 		}
-	} else {
-		fmt.Println("Database Status: Skipped (--skip-db flag)")
+		prev, prevAt = snapshot, now
+
+		select {
+		case <-ctx.Done():
+			return snapshot.exitCode()
+		case <-time.After(interval):
+		}
 	}
+}
 
-	ctx := rclone.InjectConfig(context.Background())
-	r2Creds := &rclone.CloudflareR2Credentials{
-		AccessKey: config.AccessKey,
-		SecretKey: config.SecretKey,
-		AccountID: config.AccountID,
-		Bucket:    config.Bucket,
+// statusMinSize is the smallest task listed by status --list
+var statusMinSize fs.SizeSuffix
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
 	}
+	return s
+}
 
-	fdst, err := rclone.NewR2Backend(ctx, r2Creds)
+// dashError keeps a multi-line error on one row of a table
+func dashError(s string) string {
+	return dash(strings.ReplaceAll(s, "\n", " "))
+}
+
+// formatProgress renders the bytes transferred or placed of a task in flight as reported by send or recv, e.g. "up 37%"
+func formatProgress(task *db.Task) string {
+	if task.PlacedBytes > 0 {
+		if task.SrcSize <= 0 {
+			return "place " + formatSize(task.PlacedBytes)
+		}
+		return fmt.Sprintf("place %d%%", 100*min(task.PlacedBytes, task.SrcSize)/task.SrcSize)
+	}
+	if task.TransferredBytes <= 0 {
+		return "-"
+	}
+	direction := "down"
+	if task.Status == db.Uploading {
+		direction = "up"
+	}
+	if task.SrcSize <= 0 {
+		return direction + " " + formatSize(task.TransferredBytes)
+	}
+	return fmt.Sprintf("%s %d%%", direction, 100*min(task.TransferredBytes, task.SrcSize)/task.SrcSize)
+}
+
+// printTaskList writes a page of tasks as a table, and a hint if more tasks match
+func printTaskList(w io.Writer, tasks []*db.Task, offset, limit int) {
+	more := len(tasks) > limit
+	if more {
+		tasks = tasks[:limit]
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Virtual Path\tStatus\tSize\tProgress\tSrc Digest\tDst Digest\tAttempts\tUpdated\tError")
+	fmt.Fprintln(tw, "------------\t------\t----\t--------\t----------\t----------\t--------\t-------\t-----")
+	for _, task := range tasks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", task.VirtualPath, task.Status, formatSize(task.SrcSize),
+			formatProgress(task), dash(task.SrcDigest), dash(task.DstDigest), task.Attempts, task.UpdatedAt.Local().Format(time.DateTime),
+			dashError(task.Error))
+	}
+	tw.Flush()
+	switch {
+	case len(tasks) == 0:
+		fmt.Fprintln(w, "\nNo matching tasks")
+	case more:
+		fmt.Fprintf(w, "\nTasks %d-%d, use --offset %d for the next page\n", offset+1, offset+len(tasks), offset+len(tasks))
+	default:
+		fmt.Fprintf(w, "\nTasks %d-%d\n", offset+1, offset+len(tasks))
+	}
+}
+
+// runStatusList lists the tasks of the database matching the flags of status --list
+func runStatusList(cmd *cobra.Command) error {
+	statusName, _ := cmd.Flags().GetString("status")
+	prefix, _ := cmd.Flags().GetString("prefix")
+	offset, _ := cmd.Flags().GetInt("offset")
+	limit, _ := cmd.Flags().GetInt("limit")
+	if limit < 1 || offset < 0 {
+		return fmt.Errorf("--limit must be positive and --offset not negative")
+	}
+
+	filter := db.TaskFilter{Prefix: prefix, MinSize: int64(statusMinSize)}
+	if statusName != "" {
+		status, err := db.ParseStatus(statusName)
+		if err != nil {
+			return err
+		}
+		filter.Status = &status
+	}
+
+	dbHandle, err := connectDB()
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	// one more than the page to tell if there is a next one
+	tasks, err := dbHandle.ListTasksFiltered(filter, offset, limit+1)
 	if err != nil {
-		fmt.Printf("R2 Backend: Error connecting - %v\n", err)
+		return fmt.Errorf("failed to list tasks: %w", err)
 	}
+	printTaskList(cmd.OutOrStdout(), tasks, offset, limit)
+	return nil
+}
 
-	fileInfos, err := rclone.ListFiles(ctx, fdst)
+// printAggregates writes the counts and sizes of the tasks by group and status as a table
+func printAggregates(w io.Writer, by db.GroupBy, aggregates []db.TaskAggregate) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := map[db.GroupBy]string{db.GroupByHost: "Host", db.GroupByDstDir: "Destination Directory"}[by]
+	fmt.Fprintf(tw, "%s\tStatus\tCount\tTotal Size\n", header)
+	fmt.Fprintf(tw, "%s\t------\t-----\t----------\n", strings.Repeat("-", len(header)))
+	for _, aggregate := range aggregates {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", dash(aggregate.Key), aggregate.Status, aggregate.Count, formatSize(aggregate.Size))
+	}
+	tw.Flush()
+	if len(aggregates) == 0 {
+		fmt.Fprintln(w, "\nNo tasks")
+	}
+}
+
+// printLeases writes the tasks each send --claim host is uploading as a table
+func printLeases(w io.Writer, leases []db.HostLeases) {
+	if len(leases) == 0 {
+		fmt.Fprintln(w, "\nNo active leases")
+		return
+	}
+	fmt.Fprintln(w, "\nActive Leases")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Host\tLeased\tTotal Size\tTransferred\tLease Until\n")
+	fmt.Fprintf(tw, "----\t------\t----------\t-----------\t-----------\n")
+	for _, lease := range leases {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", lease.Owner, lease.Count, formatSize(lease.Size),
+			formatSize(lease.TransferredBytes), lease.ExpiresAt.Local().Format(time.DateTime))
+	}
+	tw.Flush()
+}
+
+// runStatusGroupBy prints the tasks of the database grouped by the given dimension
+func runStatusGroupBy(cmd *cobra.Command, by db.GroupBy) error {
+	dbHandle, err := connectDB()
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	aggregates, err := dbHandle.AggregateTasks(by)
 	if err != nil {
-		fmt.Printf("R2 Backend: Error listing files - %v\n", err)
+		return fmt.Errorf("failed to aggregate tasks: %w", err)
 	}
+	printAggregates(cmd.OutOrStdout(), by, aggregates)
+	if by == db.GroupByHost {
+		leases, err := dbHandle.ListLeases()
+		if err != nil {
+			return fmt.Errorf("failed to list leases: %w", err)
+		}
+		printLeases(cmd.OutOrStdout(), leases)
+	}
+	return nil
+}
 
-	var totalSize int64
-	for _, fileInfo := range fileInfos {
-		totalSize += fileInfo.Size
+// printTaskHistory writes the status changes of a task as a table
+func printTaskHistory(w io.Writer, task *db.Task, events []*db.TaskEvent) {
+	fmt.Fprintf(w, "%s: %s, %d attempts\n\n", task.VirtualPath, task.Status, task.Attempts)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Time\tFrom\tTo\tSize\tHost\tTransfer\tRate\tError")
+	fmt.Fprintln(tw, "----\t----\t--\t----\t----\t--------\t----\t-----")
+	for _, event := range events {
+		from := "-"
+		if event.OldStatus != nil {
+			from = event.OldStatus.String()
+		}
+		transfer, rate := "-", "-"
+		if event.Rate() > 0 {
+			transfer = event.TransferEndedAt.Sub(*event.TransferStartedAt).Round(time.Second).String()
+			rate = formatSize(int64(event.Rate())) + "/s"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", event.CreatedAt.Local().Format(time.DateTime), from, event.NewStatus,
+			formatSize(event.Bytes), dash(event.Host), transfer, rate, dashError(event.Error))
 	}
-	stats[db.Uploaded] = StatusSummary{
-		Count: int64(len(fileInfos)),
-		Size:  totalSize,
+	tw.Flush()
+	if len(events) == 0 {
+		fmt.Fprintln(w, "\nNo recorded events")
 	}
-	// recalculate uploading: should be uploading - uploaded
-	// because we can't run a callback after each file is uploaded
-	// stats[db.Uploading] = StatusSummary{
-	// 	Count: stats[db.Uploading].Count,
-	// 	Size:  stats[db.Uploading].Size - stats[db.Uploaded].Size,
-	// }
+}
 
-	fmt.Printf("%-12s %-8s %-12s\n", "Status", "Count", "Total Size")
-	fmt.Printf("%-12s %-8s %-12s\n", "------", "-----", "----------")
-	for k, stat := range stats {
-		fmt.Printf("%-12s %-8d %-12s\n", k.String(), stat.Count, formatSize(stat.Size))
+// printTaskDestinations writes the uploads of a task to the destinations of send as a table
+func printTaskDestinations(w io.Writer, destinations []*db.TaskDestination) {
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Destination\tStatus\tSize\tUpdated\tError")
+	fmt.Fprintln(tw, "-----------\t------\t----\t-------\t-----")
+	for _, d := range destinations {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", d.Destination, d.Status, formatSize(d.ObjectSize),
+			d.UpdatedAt.Local().Format(time.DateTime), dashError(d.Error))
 	}
+	tw.Flush()
+}
 
-	return err
+// runStatusHistory prints the status changes of the task given to status --history
+func runStatusHistory(cmd *cobra.Command, virtualPath string) error {
+	dbHandle, err := connectDB()
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	task, events, err := dbHandle.ListTaskEvents(virtualPath)
+	if err != nil {
+		return fmt.Errorf("failed to list the events of %s: %w", virtualPath, err)
+	}
+	printTaskHistory(cmd.OutOrStdout(), task, events)
+	destinations, err := dbHandle.ListTaskDestinations(virtualPath)
+	if err != nil {
+		return fmt.Errorf("failed to list the destinations of %s: %w", virtualPath, err)
+	}
+	if len(destinations) > 0 {
+		printTaskDestinations(cmd.OutOrStdout(), destinations)
+	}
+	return nil
+}
+
+// statAuditedObjects reads the ETags of the objects of the Uploaded tasks that recorded one
+func statAuditedObjects(tasks []*db.Task, objects []rclone.RcloneFileInfo) error {
+	onR2 := make(map[string]int, len(objects))
+	for i, object := range objects {
+		onR2[object.Name] = i
+	}
+	var names []string
+	for _, task := range tasks {
+		if _, ok := onR2[task.VirtualPath]; ok && task.Status == db.Uploaded && task.ETag != "" {
+			names = append(names, task.VirtualPath)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	ctx, fdst, err := newStatusR2Backend()
+	if err != nil {
+		return err
+	}
+	infos, err := rclone.StatFiles(ctx, fdst, names)
+	if err != nil {
+		return err
+	}
+	for name, info := range infos {
+		objects[onR2[name]].ETag = info.ETag
+	}
+	return nil
+}
+
+// runStatusAudit compares the database with the bucket and exits with statusExitAuditFailed on problems
+func runStatusAudit(cmd *cobra.Command) error {
+	dbHandle, err := connectDB()
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	tasks, err := listAllTasks(dbHandle, db.TaskFilter{})
+	if err != nil {
+		return fmt.Errorf("failed to list tasks: %w", err)
+	}
+	objects, err := listR2Objects()
+	if err != nil {
+		return fmt.Errorf("R2 Backend: %w", err)
+	}
+	if err := statAuditedObjects(tasks, objects); err != nil {
+		return fmt.Errorf("R2 Backend: error reading ETags: %w", err)
+	}
+	findings := auditTasks(tasks, objects)
+	printAudit(cmd.OutOrStdout(), findings, len(tasks), len(objects))
+	if len(findings) > 0 {
+		os.Exit(statusExitAuditFailed)
+	}
+	return nil
 }
 
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Show transfer progress and statistics",
-	Long:  "Display statistics about file transfer progress, including database status and R2 backend file counts",
+	Long: `Display statistics about file transfer progress, including database status and R2 backend file counts.
+Exits with 2 if there are Failed tasks, with 3 if the database or R2 can't be reached
+and with 4 if --audit finds inconsistencies.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		configPath, _ := cmd.Flags().GetString("config")
 		skipDB, _ := cmd.Flags().GetBool("skip-db")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		watch, _ := cmd.Flags().GetDuration("watch")
 
 		if configPath == "" {
 			cmd.Help()
 			return
 		}
 
-		if err := runStatus(configPath, skipDB); err != nil {
-			panic(err)
+		if err := loadConfig(configPath); err != nil {
+			cmd.PrintErrf("%v\n", err)
+			os.Exit(1)
+		}
+
+		audit, _ := cmd.Flags().GetBool("audit")
+		history, _ := cmd.Flags().GetString("history")
+		groupBy, _ := cmd.Flags().GetString("group-by")
+		if list, _ := cmd.Flags().GetBool("list"); list || audit || history != "" || groupBy != "" {
+			if skipDB {
+				cmd.PrintErrln("--list, --history, --group-by and --audit read the tasks from the database and can't be used with --skip-db")
+				os.Exit(1)
+			}
+		}
+		if groupBy != "" {
+			by := db.GroupBy(groupBy)
+			if by != db.GroupByHost && by != db.GroupByDstDir {
+				cmd.PrintErrf("--group-by must be %s or %s\n", db.GroupByHost, db.GroupByDstDir)
+				os.Exit(1)
+			}
+			if err := runStatusGroupBy(cmd, by); err != nil {
+				cmd.PrintErrf("%v\n", err)
+				os.Exit(statusExitUnreachable)
+			}
+			return
+		}
+		if history != "" {
+			if err := runStatusHistory(cmd, history); err != nil {
+				cmd.PrintErrf("%v\n", err)
+				os.Exit(statusExitUnreachable)
+			}
+			return
+		}
+		if audit {
+			if err := runStatusAudit(cmd); err != nil {
+				cmd.PrintErrf("%v\n", err)
+				os.Exit(statusExitUnreachable)
+			}
+			return
+		}
+		if list, _ := cmd.Flags().GetBool("list"); list {
+			if err := runStatusList(cmd); err != nil {
+				cmd.PrintErrf("%v\n", err)
+				os.Exit(statusExitUnreachable)
+			}
+			return
+		}
+
+		if watch > 0 {
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			if code := watchStatus(ctx, cmd.OutOrStdout(), watch, skipDB, asJSON); code != 0 {
+				stop()
+				os.Exit(code)
+			}
+			return
+		}
+
+		snapshot := collectStatus(skipDB)
+		if asJSON {
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(snapshot); err != nil {
+				cmd.PrintErrf("Failed to encode status: %v\n", err)
+				os.Exit(1)
+			}
+		} else {
+			snapshot.print(cmd.OutOrStdout())
+		}
+		if code := snapshot.exitCode(); code != 0 {
+			os.Exit(code)
 		}
 	},
 }
@@ -141,5 +670,19 @@ var statusCmd = &cobra.Command{
 func init() {
 	statusCmd.Flags().StringP("config", "c", "config.json", "Path to the configuration file")
 	statusCmd.Flags().Bool("skip-db", false, "Skip database operations")
+	statusCmd.Flags().Bool("json", false, "Print the status as JSON")
+	statusCmd.Flags().Bool("audit", false, "Compare the tasks of the database with the objects on R2 and report the inconsistencies")
+	statusCmd.Flags().Bool("list", false, "List the tasks of the database instead of the summary")
+	statusCmd.Flags().String("status", "", "With --list, only list the tasks with this status, e.g. uploading")
+	statusCmd.Flags().String("prefix", "", "With --list, only list the tasks whose virtual path starts with this prefix")
+	statusCmd.Flags().Var(&statusMinSize, "min-size", "With --list, only list the tasks of at least this size (e.g. 10G)")
+	statusCmd.Flags().Int("limit", 50, "With --list, number of tasks per page")
+	statusCmd.Flags().Int("offset", 0, "With --list, number of tasks to skip")
+	statusCmd.Flags().String("group-by", "", "Count the tasks of the database by status and host or dst-dir instead of the summary")
+	statusCmd.Flags().String("history", "", "Show the status changes and errors of the task with this virtual path")
+	statusCmd.Flags().DurationVar(&statusRateWindow, "rate-window", 6*time.Hour, "Period of recent downloads that the transfer rate and ETA are computed from, 0 to disable")
+	statusCmd.Flags().Duration("watch", 0, "Refresh the status at this interval (e.g. 30s) until interrupted")
+	addRemotePrefixFlag(statusCmd)
+	addRunIDFlag(statusCmd)
 	RootCmd.AddCommand(statusCmd)
 }
@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hrz6976/syncmate/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusSnapshot(t *testing.T) {
+	snapshot := &statusSnapshot{}
+	snapshot.Database.Reachable = true
+	snapshot.Database.Statuses = map[string]StatusSummary{
+		"Pending":    {Count: 3, Size: 3072},
+		"Downloaded": {Count: 1, Size: 1024},
+		"Failed":     {Count: 0},
+	}
+	snapshot.R2.Reachable = true
+	snapshot.R2.Files, snapshot.R2.Bytes = 2, 2048
+	assert.Equal(t, 0, snapshot.exitCode())
+
+	var out bytes.Buffer
+	snapshot.print(&out)
+	assert.Equal(t, `Status       Count    Total Size  
+------       -----    ----------  
+Pending      3        3.0 KiB     
+Downloaded   1        1.0 KiB     
+Failed       0        0 B         
+Uploaded     2        2.0 KiB     
+`, out.String())
+
+	data, err := json.Marshal(snapshot)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"database": {"skipped": false, "reachable": true, "statuses": {
+			"Pending": {"count": 3, "size": 3072},
+			"Downloaded": {"count": 1, "size": 1024},
+			"Failed": {"count": 0, "size": 0}
+		}},
+		"r2": {"reachable": true, "files": 2, "bytes": 2048}
+	}`, string(data))
+
+	snapshot.Database.Statuses["Failed"] = StatusSummary{Count: 1, Size: 10}
+	assert.Equal(t, statusExitFailedTasks, snapshot.exitCode())
+
+	snapshot.R2.Reachable = false
+	snapshot.R2.Error = "error listing files: timeout"
+	assert.Equal(t, statusExitUnreachable, snapshot.exitCode())
+
+	skipped := &statusSnapshot{}
+	skipped.Database.Skipped = true
+	skipped.R2.Reachable = true
+	assert.Equal(t, 0, skipped.exitCode())
+}
+
+func TestStatusSnapshot_PrintDelta(t *testing.T) {
+	newSnapshot := func(downloaded, uploaded StatusSummary) *statusSnapshot {
+		s := &statusSnapshot{}
+		s.Database.Reachable = true
+		s.Database.Statuses = map[string]StatusSummary{"Downloaded": downloaded}
+		s.R2.Reachable = true
+		s.R2.Files, s.R2.Bytes = uploaded.Count, uploaded.Size
+		return s
+	}
+	prev := newSnapshot(StatusSummary{Count: 1, Size: 1024}, StatusSummary{Count: 4, Size: 40960})
+	cur := newSnapshot(StatusSummary{Count: 3, Size: 1024 + 20480}, StatusSummary{Count: 2, Size: 20480})
+
+	var out bytes.Buffer
+	cur.printDelta(&out, prev, 10*time.Second)
+	assert.Equal(t, `Status       Count    Total Size   Change   Rate        
+------       -----    ----------   ------   ----        
+Downloaded   3        21.0 KiB     +2       2.0 KiB/s   
+Uploaded     2        20.0 KiB     -2       -2.0 KiB/s  
+`, out.String())
+}
+
+func TestPrintTaskList(t *testing.T) {
+	updated := time.Date(2025, 1, 10, 14, 2, 30, 0, time.Local)
+	newTask := func(virtualPath string, status db.Status, size int64, taskErr string) *db.Task {
+		task := &db.Task{VirtualPath: virtualPath, Status: status, SrcSize: size, SrcDigest: "0123456789abcdef", Error: taskErr}
+		task.UpdatedAt = updated
+		return task
+	}
+	tasks := []*db.Task{
+		newTask("sha1.tree_0.tch", db.Uploading, 2048, ""),
+		newTask("sha1.tree_1.tch", db.Failed, 1024, "digest mismatch\nexpected a"),
+		newTask("sha1.tree_2.tch", db.Uploading, 1024, ""),
+	}
+	tasks[0].TransferredBytes = 768
+
+	var out bytes.Buffer
+	printTaskList(&out, tasks, 10, 2)
+	assert.Equal(t, `Virtual Path     Status     Size     Progress  Src Digest        Dst Digest  Attempts  Updated              Error
+------------     ------     ----     --------  ----------        ----------  --------  -------              -----
+sha1.tree_0.tch  Uploading  2.0 KiB  up 37%    0123456789abcdef  -           0         2025-01-10 14:02:30  -
+sha1.tree_1.tch  Failed     1.0 KiB  -         0123456789abcdef  -           0         2025-01-10 14:02:30  digest mismatch expected a
+
+Tasks 11-12, use --offset 12 for the next page
+`, out.String())
+
+	out.Reset()
+	printTaskList(&out, nil, 0, 2)
+	assert.Contains(t, out.String(), "No matching tasks")
+}
+
+func TestPrintTaskHistory(t *testing.T) {
+	at := time.Date(2025, 1, 10, 14, 2, 30, 0, time.Local)
+	uploaded := db.Uploaded
+	task := &db.Task{VirtualPath: "sha1.tree_1.tch", Status: db.Failed, Attempts: 2}
+	started := at.Add(-4 * time.Second)
+	events := []*db.TaskEvent{
+		{CreatedAt: at, NewStatus: db.Uploaded, Bytes: 1024 << 20, Host: "da5", TransferStartedAt: &started, TransferEndedAt: &at},
+		{CreatedAt: at, OldStatus: &uploaded, NewStatus: db.Failed, Bytes: 1024 << 20, Error: "digest mismatch\nexpected a"},
+	}
+
+	var out bytes.Buffer
+	printTaskHistory(&out, task, events)
+	assert.Equal(t, `sha1.tree_1.tch: Failed, 2 attempts
+
+Time                 From      To        Size     Host  Transfer  Rate         Error
+----                 ----      --        ----     ----  --------  ----         -----
+2025-01-10 14:02:30  -         Uploaded  1.0 GiB  da5   4s        256.0 MiB/s  -
+2025-01-10 14:02:30  Uploaded  Failed    1.0 GiB  -     -         -            digest mismatch expected a
+`, out.String())
+}
+
+func TestPrintTaskDestinations(t *testing.T) {
+	at := time.Date(2025, 1, 10, 14, 2, 30, 0, time.Local)
+	var out bytes.Buffer
+	printTaskDestinations(&out, []*db.TaskDestination{
+		{Destination: "backup", Status: db.Uploading, UpdatedAt: at, Error: "connection reset"},
+		{Destination: "primary", Status: db.Uploaded, ObjectSize: 1024, UpdatedAt: at},
+	})
+	assert.Equal(t, `
+Destination  Status     Size     Updated              Error
+-----------  ------     ----     -------              -----
+backup       Uploading  0 B      2025-01-10 14:02:30  connection reset
+primary      Uploaded   1.0 KiB  2025-01-10 14:02:30  -
+`, out.String())
+}
+
+func TestPrintAggregates(t *testing.T) {
+	var out bytes.Buffer
+	printAggregates(&out, db.GroupByHost, []db.TaskAggregate{
+		{Key: "", Status: db.Pending, Count: 2, Size: 2048},
+		{Key: "da5", Status: db.Uploaded, Count: 1, Size: 1024},
+	})
+	assert.Equal(t, `Host  Status    Count  Total Size
+----  ------    -----  ----------
+-     Pending   2      2.0 KiB
+da5   Uploaded  1      1.0 KiB
+`, out.String())
+}
+
+func TestPrintLeases(t *testing.T) {
+	var out bytes.Buffer
+	printLeases(&out, nil)
+	assert.Equal(t, "\nNo active leases\n", out.String())
+
+	out.Reset()
+	expires := time.Date(2025, 1, 10, 15, 0, 0, 0, time.Local)
+	printLeases(&out, []db.HostLeases{
+		{Owner: "da5", Count: 3, Size: 3072, TransferredBytes: 512, ExpiresAt: expires},
+		{Owner: "da7", Count: 1, Size: 1024, ExpiresAt: expires},
+	})
+	assert.Equal(t, `
+Active Leases
+Host  Leased  Total Size  Transferred  Lease Until
+----  ------  ----------  -----------  -----------
+da5   3       3.0 KiB     512 B        2025-01-10 15:00:00
+da7   1       1.0 KiB     0 B          2025-01-10 15:00:00
+`, out.String())
+}
+
+func TestStatusThroughput(t *testing.T) {
+	now := time.Date(2025, 1, 10, 12, 0, 0, 0, time.Local)
+	throughput := newThroughput(6<<40, 6*time.Hour, 10<<40, now)
+	assert.Equal(t, int64(1<<40), throughput.BytesPerHour)
+	require.NotNil(t, throughput.ETA)
+	assert.Equal(t, now.Add(10*time.Hour), *throughput.ETA)
+
+	var out bytes.Buffer
+	throughput.print(&out, now)
+	assert.Equal(t, "\nDownloaded 1.0 TiB/h over the last 6h0m0s, 10.0 TiB remaining, done in 10h0m0s (2025-01-10 22:00:00)\n", out.String())
+
+	out.Reset()
+	newThroughput(0, time.Hour, 1024, now).print(&out, now)
+	assert.Contains(t, out.String(), "no estimate without recent downloads")
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/hrz6976/syncmate/rclone"
+	logger "github.com/sirupsen/logrus"
+)
+
+var (
+	// watchdogBeat is the time in unix nanoseconds the daemon last made progress, see beatWatchdog
+	watchdogBeat atomic.Int64
+	// watchdogIdle is set while the daemon waits for its next cycle, when no progress is expected
+	watchdogIdle atomic.Bool
+)
+
+// beatWatchdog records that the daemon made progress, e.g. finished a pass over the downloaded files
+func beatWatchdog() {
+	watchdogBeat.Store(time.Now().UnixNano())
+}
+
+// transferredBytes are the bytes rclone transferred and recv placed so far, they grow while files move
+func transferredBytes() int64 {
+	_, bytes, _ := rclone.TransferStats()
+	for _, placed := range placementProgress() {
+		bytes += placed
+	}
+	return bytes
+}
+
+// notifySystemd sends state, e.g. READY=1, to systemd if it started this process with Type=notify
+func notifySystemd(state string) {
+	if _, err := daemon.SdNotify(false, state); err != nil {
+		logger.WithError(err).WithField("state", state).Debug("Failed to notify systemd")
+	}
+}
+
+// startWatchdog pings the watchdog of systemd at half its WatchdogSec, if the unit has one, as long
+// as the daemon is idle or made progress within WatchdogSec: it called beatWatchdog or bytes were
+// transferred or placed. A cycle that hangs stops the pings and systemd restarts the daemon.
+//
+// It returns a func which should be called to stop the pings.
+func startWatchdog() func() {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		logger.WithError(err).Warn("Invalid systemd watchdog settings, not pinging it")
+		return func() {}
+	} else if interval == 0 {
+		return func() {}
+	}
+	logger.WithField("interval", interval).Debug("Pinging the systemd watchdog")
+	beatWatchdog()
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		lastBytes := transferredBytes()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if bytes := transferredBytes(); bytes != lastBytes {
+					lastBytes = bytes
+					beatWatchdog()
+				}
+				if watchdogStalled(interval, time.Now()) {
+					logger.WithField("interval", interval).Warn("Daemon made no progress, not pinging the systemd watchdog")
+					continue
+				}
+				notifySystemd(daemon.SdNotifyWatchdog)
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// watchdogStalled reports whether the daemon is busy and made no progress for longer than interval
+func watchdogStalled(interval time.Duration, now time.Time) bool {
+	return !watchdogIdle.Load() && now.Sub(time.Unix(0, watchdogBeat.Load())) > interval
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hrz6976/syncmate/woc"
+)
+
+// taskFormats are the output formats of taskgen --format
+var taskFormats = []string{"jsonl", "json", "csv", "table"}
+
+// taskColumns is the column order of the csv and table formats, keep it stable for scripts reading them
+var taskColumns = []string{"virtual_path", "dataset", "source_path", "target_path", "offset", "size", "source_digest", "target_digest"}
+
+func validTaskFormat(format string) bool {
+	for _, f := range taskFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedTasks returns the tasks ordered by virtual path, so that repeated runs write the same output
+func sortedTasks(fileList map[string]*woc.WocSyncTask) []*woc.WocSyncTask {
+	tasks := make([]*woc.WocSyncTask, 0, len(fileList))
+	for _, task := range fileList {
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].VirtualPath < tasks[j].VirtualPath })
+	return tasks
+}
+
+func taskRow(task *woc.WocSyncTask, size string) []string {
+	digest := func(d *string) string {
+		if d == nil {
+			return ""
+		}
+		return *d
+	}
+	return []string{
+		task.VirtualPath,
+		task.Dataset,
+		task.SourcePath,
+		task.TargetPath,
+		strconv.FormatInt(task.Offset, 10),
+		size,
+		digest(task.SourceDigest),
+		digest(task.TargetDigest),
+	}
+}
+
+// writeTasks writes the tasks to w in one of taskFormats
+func writeTasks(w io.Writer, fileList map[string]*woc.WocSyncTask, format string) error {
+	tasks := sortedTasks(fileList)
+	switch format {
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		for _, task := range tasks {
+			if err := enc.Encode(task); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(tasks)
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(taskColumns); err != nil {
+			return err
+		}
+		for _, task := range tasks {
+			if err := cw.Write(taskRow(task, strconv.FormatInt(task.Size, 10))); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		rule := make([]string, len(taskColumns))
+		for i, col := range taskColumns {
+			rule[i] = strings.Repeat("-", len(col))
+		}
+		fmt.Fprintln(tw, strings.Join(taskColumns, "\t"))
+		fmt.Fprintln(tw, strings.Join(rule, "\t"))
+		var total int64
+		for _, task := range tasks {
+			row := taskRow(task, formatSize(task.Size))
+			for i, cell := range row {
+				if cell == "" {
+					row[i] = "-"
+				}
+			}
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+			total += task.Size
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(w, "\n%d tasks, %s\n", len(tasks), formatSize(total))
+		return err
+	default:
+		return fmt.Errorf("unknown format %q, must be one of %v", format, taskFormats)
+	}
+}
@@ -1,16 +1,27 @@
 package cmd
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"os"
+	"os/signal"
 	"syscall"
 
+	"github.com/hrz6976/syncmate/tracing"
 	"github.com/hrz6976/syncmate/woc"
 	logger "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func writeFileListToJSONL(fileList map[string]*woc.WocSyncTask, outputPath string) error {
+	return writeFileList(fileList, outputPath, "jsonl")
+}
+
+// writeFileList writes the tasks to outputPath, or stdout if it is empty, in one of taskFormats
+func writeFileList(fileList map[string]*woc.WocSyncTask, outputPath, format string) error {
 	// if outputPath is empty, use stdout
 	var file *os.File
 	if outputPath == "" {
@@ -24,16 +35,7 @@ func writeFileListToJSONL(fileList map[string]*woc.WocSyncTask, outputPath strin
 		}
 		defer file.Close()
 	}
-	for _, task := range fileList {
-		data, err := json.Marshal(task)
-		if err != nil {
-			return err
-		}
-		if _, err := file.WriteString(string(data) + "\n"); err != nil {
-			return err
-		}
-	}
-	return nil
+	return writeTasks(file, fileList, format)
 }
 
 const NFS_SUPER_MAGIC = 0x6969
@@ -57,19 +59,83 @@ func isFileLocal(filePath string) (bool, error) {
 	return true, nil
 }
 
+// digestWorkers is the number of files digested in parallel by generateTasks
+var digestWorkers int
+
+func addDigestWorkersFlag(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&digestWorkers, "digest-workers", 4, "Number of files digested in parallel during task generation")
+}
+
+// skipBadShards leaves out the shards that tasks can't be generated for instead of failing the run
+var skipBadShards bool
+
+func addSkipBadShardsFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&skipBadShards, "skip-bad-shards", false, "Skip the shards with missing sizes, failed digests or unresolvable paths instead of failing task generation")
+}
+
+// strictProfiles fails on the profiles with missing sizes or digests, duplicate paths or empty maps instead of warning
+var strictProfiles bool
+
+func addStrictFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&strictProfiles, "strict", false, "Fail with every shard missing its size or digest, duplicate path and empty map of the profiles instead of warning")
+}
+
+// allMapVersions syncs every map version newer than the destination's instead of only the latest
+var allMapVersions bool
+
+func addAllVersionsFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&allMapVersions, "all-versions", false, "Transfer every map version newer than the destination's, not only the latest")
+}
+
 func generateTasks(
 	srcProfile,
 	dstProfile *woc.ParsedWocProfile,
 	localOnly bool,
 ) (map[string]*woc.WocSyncTask, error) {
-	tasksMap := woc.GenerateFileLists(dstProfile, srcProfile)
+	tasksMap, err := generateAllTasks(srcProfile, dstProfile)
+	if err != nil {
+		return nil, err
+	}
+	if err := dropFinishedTasks(tasksMap, localOnly); err != nil {
+		return nil, err
+	}
+	return tasksMap, nil
+}
+
+// generateAllTasks compares the profiles, including the tasks that are already finished
+func generateAllTasks(srcProfile, dstProfile *woc.ParsedWocProfile) (map[string]*woc.WocSyncTask, error) {
+	// Digesting thousands of shards on NFS takes hours, let Ctrl-C abort it
+	ctx, stop := signal.NotifyContext(tracing.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, span := tracing.Start(ctx, "generate tasks")
+	opt := woc.GenerateOptions{
+		DigestWorkers: digestWorkers,
+		SkipBadShards: skipBadShards,
+		AllVersions:   allMapVersions,
+	}
+	if err := datasetOptions(&opt); err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+	tasksMap, err := woc.GenerateFileListsContext(ctx, dstProfile, srcProfile, opt)
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("syncmate.tasks", len(tasksMap)))
+	tracing.End(span, nil)
 	logger.WithField("taskCount", len(tasksMap)).Debug("Generated tasks for file transfer")
+	return tasksMap, nil
+}
+
+// dropFinishedTasks removes the tasks that are Downloaded in the database and, with localOnly, those of files on NFS
+func dropFinishedTasks(tasksMap map[string]*woc.WocSyncTask, localOnly bool) error {
 	var finishedFiles []string
 	var err error
 	if dbHandle != nil {
 		finishedFiles, err = dbHandle.ListFinishedVirtualPaths()
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 	finishedFilesMap := make(map[string]bool)
@@ -83,7 +149,7 @@ func generateTasks(
 		}
 
 		if isLocal, err := isFileLocal(task.SourcePath); err != nil {
-			return nil, err
+			return err
 		} else if !isLocal && localOnly {
 			// Skip tasks for files on NFS
 			logger.WithField("file", task.SourcePath).Debug("File ignored")
@@ -91,6 +157,83 @@ func generateTasks(
 			continue
 		}
 	}
+	return nil
+}
+
+// tasksFile is a JSONL file written by taskgen, used instead of generating the tasks from the profiles
+var tasksFile string
+
+func addTasksFileFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&tasksFile, "tasks-file", "", "Read the tasks from this JSONL file written by taskgen instead of generating them from the profiles")
+}
+
+// readTasksFromJSONL reads the tasks written by writeFileListToJSONL
+func readTasksFromJSONL(path string) (map[string]*woc.WocSyncTask, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tasksMap := make(map[string]*woc.WocSyncTask)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var task woc.WocSyncTask
+		if err := json.Unmarshal(scanner.Bytes(), &task); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if task.VirtualPath == "" {
+			return nil, fmt.Errorf("%s:%d: task has no virtual_path", path, line)
+		}
+		if _, ok := tasksMap[task.VirtualPath]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate task %s", path, line, task.VirtualPath)
+		}
+		tasksMap[task.VirtualPath] = &task
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return tasksMap, nil
+}
+
+// loadTasks reads --tasks-file if set, and generates the tasks from the profiles otherwise
+func loadTasks(srcProfile, dstProfile *woc.ParsedWocProfile, localOnly bool) (map[string]*woc.WocSyncTask, error) {
+	tasksMap, err := loadAllTasks(srcProfile, dstProfile)
+	if err != nil {
+		return nil, err
+	}
+	if err := dropFinishedTasks(tasksMap, localOnly); err != nil {
+		return nil, err
+	}
+	return tasksMap, nil
+}
+
+// loadAllTasks is loadTasks including the tasks that are already finished
+func loadAllTasks(srcProfile, dstProfile *woc.ParsedWocProfile) (map[string]*woc.WocSyncTask, error) {
+	if tasksFile == "" {
+		return generateAllTasks(srcProfile, dstProfile)
+	}
+	tasksMap, err := readTasksFromJSONL(tasksFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tasks file: %w", err)
+	}
+	logger.WithFields(logger.Fields{"taskCount": len(tasksMap), "tasksFile": tasksFile}).Debug("Read tasks for file transfer")
+	// the file doesn't tell maps from objects, a task is kept if either selection chooses its dataset
+	var opt woc.GenerateOptions
+	if err := datasetOptions(&opt); err != nil {
+		return nil, err
+	}
+	if opt.SelectMap != nil {
+		for virtualPath, task := range tasksMap {
+			if !opt.SelectMap(task.Dataset) && !opt.SelectObject(task.Dataset) {
+				delete(tasksMap, virtualPath)
+			}
+		}
+	}
 	return tasksMap, nil
 }
 
@@ -103,34 +246,75 @@ var taskCmd = &cobra.Command{
 		dstPath, _ := cmd.Flags().GetString("dst")
 		outputPath, _ := cmd.Flags().GetString("output")
 		localOnly, _ := cmd.Flags().GetBool("local-only")
+		format, _ := cmd.Flags().GetString("format")
+		configPath, _ := cmd.Flags().GetString("config")
+		if !validTaskFormat(format) {
+			cmd.PrintErrf("Invalid format %q, must be one of %v\n", format, taskFormats)
+			return
+		}
 
-		srcProfile, err := woc.ParseWocProfile(&srcPath)
+		filter, err := taskFilterFromFlags(cmd)
 		if err != nil {
-			cmd.PrintErrf("Failed to parse source profile: %v\n", err)
+			cmd.PrintErrf("Invalid filter: %v\n", err)
 			return
 		}
 
-		dstProfile, err := woc.ParseWocProfile(&dstPath)
+		if configPath != "" {
+			// only for the path rewrites, taskgen doesn't connect to anything
+			if err := loadConfig(configPath); err != nil {
+				cmd.PrintErrf("%v\n", err)
+				return
+			}
+		}
+
+		srcProfile, dstProfile, err := loadProfiles(srcPath, dstPath)
 		if err != nil {
-			cmd.PrintErrf("Failed to parse destination profile: %v\n", err)
+			cmd.PrintErrf("%v\n", err)
 			return
 		}
 
+		stopTracing, err := startTracing(cmd, "taskgen")
+		if err != nil {
+			cmd.PrintErrf("Failed to export traces: %v\n", err)
+			return
+		}
+		defer stopTracing()
+
 		var fileList map[string]*woc.WocSyncTask
 		fileList, err = generateTasks(srcProfile, dstProfile, localOnly)
 		if err != nil {
-			panic(err)
+			cmd.PrintErrf("Failed to generate tasks: %v\n", err)
+			return
+		}
+		filterTasks(fileList, filter)
+		if validate, _ := cmd.Flags().GetBool("validate"); validate {
+			digests, _ := cmd.Flags().GetBool("validate-digests")
+			if failed := runValidate(cmd.OutOrStdout(), fileList, digests); len(failed) > 0 {
+				cmd.PrintErrf("%d tasks failed validation\n", len(failed))
+			}
+			return
 		}
-		if err := writeFileListToJSONL(fileList, outputPath); err != nil {
-			panic(err)
+		if err := writeFileList(fileList, outputPath, format); err != nil {
+			cmd.PrintErrf("Failed to write tasks: %v\n", err)
 		}
 	},
 }
 
 func init() {
-	taskCmd.Flags().StringP("src", "s", "woc.src.json", "WoC profile of the transfer source")
-	taskCmd.Flags().StringP("dst", "d", "woc.dst.json", "Woc profile of the transfer destination")
+	taskCmd.Flags().StringP("src", "s", "woc.src.json", "WoC profile of the transfer source, a path or URL")
+	taskCmd.Flags().StringP("dst", "d", "woc.dst.json", "Woc profile of the transfer destination, a path or URL")
 	taskCmd.Flags().StringP("output", "o", "", "Output file for the generated tasks")
+	taskCmd.Flags().StringP("config", "c", "", "Configuration file with the path_rewrites of this host, the built-in rules if empty")
+	taskCmd.Flags().String("format", "jsonl", "Output format, one of jsonl, json, csv or table")
 	taskCmd.Flags().Bool("local-only", false, "Generate tasks for local files only, ignoring nonexisting files")
+	taskCmd.Flags().Bool("validate", false, "Check the generated tasks against the source files and print a report instead of the tasks")
+	taskCmd.Flags().Bool("validate-digests", false, "With --validate, also compare the sample digests of the source files")
+	addTaskFilterFlags(taskCmd)
+	addDigestWorkersFlag(taskCmd)
+	addStrictFlag(taskCmd)
+	addSkipBadShardsFlag(taskCmd)
+	addAllVersionsFlag(taskCmd)
+	addDatasetFlags(taskCmd)
+	addTracingFlag(taskCmd)
 	RootCmd.AddCommand(taskCmd)
 }
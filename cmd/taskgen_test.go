@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hrz6976/syncmate/offsetfs"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadTasksFromJSONL(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	path := filepath.Join(tmpDir, "tasks.jsonl")
+	digest := "0123456789abcdef"
+	tasks := map[string]*woc.WocSyncTask{
+		"blob_0.bin": {
+			FileConfig:   offsetfs.FileConfig{VirtualPath: "blob_0.bin", SourcePath: "/da5/blob_0.bin", Size: 100},
+			Dataset:      "blob",
+			SourceDigest: &digest,
+		},
+		"c2pFullV2412.0.tch.offset.5": {
+			FileConfig: offsetfs.FileConfig{VirtualPath: "c2pFullV2412.0.tch.offset.5", Offset: 5, Size: 10},
+			TargetPath: "/da7/c2pFullV2412.0.tch",
+		},
+	}
+	require.NoError(t, writeFileListToJSONL(tasks, path))
+
+	read, err := readTasksFromJSONL(path)
+	require.NoError(t, err)
+	assert.Equal(t, tasks, read)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"virtual_path":"a"}`+"\n\n"+`{"virtual_path":"a"}`+"\n"), 0644))
+	_, err = readTasksFromJSONL(path)
+	assert.ErrorContains(t, err, "tasks.jsonl:3: duplicate task a")
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"size":1}`+"\n"), 0644))
+	_, err = readTasksFromJSONL(path)
+	assert.ErrorContains(t, err, "no virtual_path")
+}
+
+func TestWriteTasks_Formats(t *testing.T) {
+	digest := "0123456789abcdef"
+	tasks := map[string]*woc.WocSyncTask{
+		"c2pFullV2412.0.tch.offset.5": {
+			FileConfig: offsetfs.FileConfig{VirtualPath: "c2pFullV2412.0.tch.offset.5", SourcePath: "/da5/c2pFullV2412.0.tch", Offset: 5, Size: 10},
+			Dataset:    "c2pFullV2412",
+			TargetPath: "/da7/c2pFullV2412.0.tch",
+		},
+		"blob_0.bin": {
+			FileConfig:   offsetfs.FileConfig{VirtualPath: "blob_0.bin", SourcePath: "/da5/blob_0.bin", Size: 2048},
+			Dataset:      "blob",
+			TargetPath:   "/da7/blob_0.bin",
+			SourceDigest: &digest,
+		},
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, writeTasks(&out, tasks, "csv"))
+	assert.Equal(t, "virtual_path,dataset,source_path,target_path,offset,size,source_digest,target_digest\n"+
+		"blob_0.bin,blob,/da5/blob_0.bin,/da7/blob_0.bin,0,2048,0123456789abcdef,\n"+
+		"c2pFullV2412.0.tch.offset.5,c2pFullV2412,/da5/c2pFullV2412.0.tch,/da7/c2pFullV2412.0.tch,5,10,,\n", out.String())
+
+	out.Reset()
+	require.NoError(t, writeTasks(&out, tasks, "json"))
+	var decoded []*woc.WocSyncTask
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	require.Len(t, decoded, 2)
+	assert.Equal(t, tasks["blob_0.bin"], decoded[0])
+
+	out.Reset()
+	require.NoError(t, writeTasks(&out, tasks, "table"))
+	lines := strings.Split(out.String(), "\n")
+	assert.True(t, strings.HasPrefix(lines[0], "virtual_path"))
+	assert.True(t, strings.HasPrefix(lines[2], "blob_0.bin"))
+	assert.Contains(t, lines[2], "2.0 KiB")
+	assert.Contains(t, out.String(), "2 tasks, ")
+
+	assert.Error(t, writeTasks(&out, tasks, "xml"))
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/hrz6976/syncmate/tracing"
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func addTracingFlag(cmd *cobra.Command) {
+	cmd.Flags().String("otlp-endpoint", "", "Export OpenTelemetry traces of the run over OTLP/HTTP to this collector (e.g. http://localhost:4318), or as configured by OTEL_EXPORTER_OTLP_ENDPOINT, disabled if both are empty")
+}
+
+// startTracing exports the spans of the run if --otlp-endpoint or the OpenTelemetry environment is set,
+// and starts the span of the run
+//
+// It returns a func which should be called to end the run and export the remaining spans.
+func startTracing(cmd *cobra.Command, command string) (func(), error) {
+	endpoint, _ := cmd.Flags().GetString("otlp-endpoint")
+	shutdown, err := tracing.Setup(context.Background(), endpoint, command)
+	if err != nil {
+		return nil, err
+	}
+	var attrs []attribute.KeyValue
+	if runID != "" {
+		attrs = append(attrs, attribute.String("syncmate.run_id", runID))
+	}
+	tracing.StartRun("syncmate "+command, attrs...)
+	return func() {
+		tracing.EndRun(nil)
+		shutdown()
+	}, nil
+}
+
+// traceTransfers adds a span for each file rclone transferred, timed by rclone
+func traceTransfers(ctx context.Context, name string, results map[string]rclone.TransferResult, attrs ...attribute.KeyValue) {
+	for _, tr := range results {
+		tracing.Record(ctx, name, tr.StartedAt, tr.CompletedAt, tr.Err, append([]attribute.KeyValue{
+			attribute.String("syncmate.virtual_path", tr.Name),
+			attribute.Int64("syncmate.bytes", tr.Bytes),
+			attribute.Bool("syncmate.checked", tr.Checked),
+		}, attrs...)...)
+	}
+}
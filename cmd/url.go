@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/rclone/rclone/fs"
+	"github.com/spf13/cobra"
+)
+
+// maxURLExpiry is the longest validity of a presigned URL of R2 and S3
+const maxURLExpiry = 7 * 24 * time.Hour
+
+// runURL writes a presigned URL of the object virtualPath in fsrc that expires after expires
+func runURL(ctx context.Context, w io.Writer, fsrc fs.Fs, virtualPath string, expires time.Duration) error {
+	if expires <= 0 || expires > maxURLExpiry {
+		return fmt.Errorf("--expires must be positive and at most %s, got %s", maxURLExpiry, expires)
+	}
+	link, err := rclone.PresignURL(ctx, fsrc, virtualPath, expires)
+	if err != nil {
+		return fmt.Errorf("failed to create a URL of %s: %w", virtualPath, err)
+	}
+	fmt.Fprintln(w, link)
+	return nil
+}
+
+var urlCmd = &cobra.Command{
+	Use:   "url <virtual_path>",
+	Short: "Print a presigned URL to download a staged object",
+	Long: `Print a presigned GET URL of the object of a virtual path in the bucket, so a collaborator can
+download a single file over HTTPS without the R2 credentials. The URL works until --expires passes,
+or until the object is downloaded by recv and deleted.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		configPath, _ := cmd.Flags().GetString("config")
+		expires, _ := cmd.Flags().GetDuration("expires")
+
+		if err := loadConfig(configPath); err != nil {
+			cmd.PrintErrf("%v\n", err)
+			os.Exit(1)
+		}
+		ctx := context.Background()
+		fsrc, err := newRemote(ctx)
+		if err != nil {
+			cmd.PrintErrf("Failed to create R2 backend: %v\n", err)
+			os.Exit(1)
+		}
+		if err := runURL(ctx, cmd.OutOrStdout(), fsrc, args[0], expires); err != nil {
+			cmd.PrintErrf("%v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	urlCmd.Flags().StringP("config", "c", "config.json", "Path to the configuration file")
+	urlCmd.Flags().Duration("expires", 24*time.Hour, "How long the URL works, at most 168h")
+	addRemotePrefixFlag(urlCmd)
+	addRunIDFlag(urlCmd)
+	RootCmd.AddCommand(urlCmd)
+}
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunURL(t *testing.T) {
+	ctx := context.Background()
+	fsrc, err := fs.NewFs(ctx, setupTestDir(t))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	for _, expires := range []time.Duration{0, -time.Hour, 8 * 24 * time.Hour} {
+		assert.ErrorContains(t, runURL(ctx, &out, fsrc, "blob_0.bin", expires), "--expires")
+	}
+	// the local backend can't make links, R2 presigns them
+	assert.ErrorContains(t, runURL(ctx, &out, fsrc, "blob_0.bin", time.Hour), "can't make links")
+	assert.Empty(t, out.String())
+}
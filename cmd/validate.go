@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hrz6976/syncmate/woc"
+)
+
+// taskCheck is the outcome of validating one task, passed if Reason is empty
+type taskCheck struct {
+	VirtualPath string
+	Reason      string
+}
+
+// validateTask checks that the source of a task is a regular file that holds the task's byte window.
+// With digests, the digest of the source is compared with the digests of the task.
+func validateTask(task *woc.WocSyncTask, digests bool) string {
+	if task.Offset < 0 || task.Size <= 0 {
+		return fmt.Sprintf("invalid window: offset %d, size %d", task.Offset, task.Size)
+	}
+	if task.Offset > 0 && task.TargetPath == "" {
+		return "partial copy has no target path"
+	}
+	stat, err := os.Stat(task.SourcePath)
+	if err != nil {
+		return fmt.Sprintf("source %s: %v", task.SourcePath, err)
+	}
+	if !stat.Mode().IsRegular() {
+		return fmt.Sprintf("source %s is not a regular file", task.SourcePath)
+	}
+	end := task.Offset + task.Size
+	if stat.Size() < end {
+		return fmt.Sprintf("window ends at %d, beyond the source size %d", end, stat.Size())
+	}
+	if stat.Size() != end {
+		// the profile recorded a different size, e.g. the file was written after profiling
+		return fmt.Sprintf("size mismatch: profile has %d, source has %d", end, stat.Size())
+	}
+	if !digests {
+		return ""
+	}
+	if reason, err := checkDigest(task.SourcePath, 0, task.SourceDigest, task.SourceDigestAlgorithm); err != nil {
+		return err.Error()
+	} else if reason != "" {
+		return "source " + reason
+	}
+	if task.Offset > 0 {
+		// the head of the source must be what the destination already has
+		if reason, err := checkDigest(task.SourcePath, task.Offset, task.TargetDigest, task.TargetDigestAlgorithm); err != nil {
+			return err.Error()
+		} else if reason != "" {
+			return "partial " + reason
+		}
+	}
+	return ""
+}
+
+// runValidate validates all tasks and prints a PASS or FAIL line for each to w, returning the failed tasks
+func runValidate(w io.Writer, tasksMap map[string]*woc.WocSyncTask, digests bool) []taskCheck {
+	tasks := sortedTasks(tasksMap)
+	var failed []taskCheck
+	for _, task := range tasks {
+		if reason := validateTask(task, digests); reason != "" {
+			failed = append(failed, taskCheck{task.VirtualPath, reason})
+			fmt.Fprintf(w, "FAIL %s: %s\n", task.VirtualPath, reason)
+		} else {
+			fmt.Fprintf(w, "PASS %s\n", task.VirtualPath)
+		}
+	}
+	fmt.Fprintf(w, "Validated %d tasks: %d passed, %d failed\n", len(tasks), len(tasks)-len(failed), len(failed))
+	return failed
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hrz6976/syncmate/offsetfs"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunValidate(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	srcPath := filepath.Join(tmpDir, "c.tch")
+	require.NoError(t, os.WriteFile(srcPath, []byte("0123456789"), 0644))
+	full, err := woc.SampleMD5(srcPath, 0, 0)
+	require.NoError(t, err)
+	head, err := woc.SampleMD5(srcPath, 0, 5)
+	require.NoError(t, err)
+	wrong := "ffffffffffffffff"
+
+	newTask := func(virtualPath, sourcePath string, offset, size int64, srcDigest, dstDigest *string) *woc.WocSyncTask {
+		return &woc.WocSyncTask{
+			FileConfig:   offsetfs.FileConfig{VirtualPath: virtualPath, SourcePath: sourcePath, Offset: offset, Size: size},
+			TargetPath:   "/da7/c.tch",
+			SourceDigest: srcDigest,
+			TargetDigest: dstDigest,
+		}
+	}
+	tasksMap := map[string]*woc.WocSyncTask{
+		"a.tch":          newTask("a.tch", srcPath, 0, 10, &full.Digest, nil),
+		"b.tch":          newTask("b.tch", filepath.Join(tmpDir, "missing.tch"), 0, 10, nil, nil),
+		"c.tch.offset.5": newTask("c.tch.offset.5", srcPath, 5, 5, &full.Digest, &head.Digest),
+		"d.tch.offset.5": newTask("d.tch.offset.5", srcPath, 5, 5, &full.Digest, &wrong),
+		"e.tch":          newTask("e.tch", srcPath, 0, 12, nil, nil),
+		"f.tch":          newTask("f.tch", srcPath, 0, 8, nil, nil),
+		"g.tch":          newTask("g.tch", tmpDir, 0, 10, nil, nil),
+	}
+
+	var out bytes.Buffer
+	failed := runValidate(&out, tasksMap, false)
+	require.Len(t, failed, 4)
+	assert.Equal(t, "b.tch", failed[0].VirtualPath)
+	assert.Contains(t, failed[1].Reason, "beyond the source size 10")
+	assert.Contains(t, failed[2].Reason, "size mismatch: profile has 8, source has 10")
+	assert.Contains(t, failed[3].Reason, "not a regular file")
+	assert.Contains(t, out.String(), "PASS d.tch.offset.5\n")
+	assert.Contains(t, out.String(), "Validated 7 tasks: 3 passed, 4 failed\n")
+
+	out.Reset()
+	failed = runValidate(&out, tasksMap, true)
+	require.Len(t, failed, 5)
+	assert.Equal(t, "d.tch.offset.5", failed[1].VirtualPath)
+	assert.Contains(t, failed[1].Reason, "partial digest mismatch")
+	assert.Contains(t, out.String(), "PASS c.tch.offset.5\n")
+}
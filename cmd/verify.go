@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/hrz6976/syncmate/woc"
+)
+
+// verifyMismatch is a downloaded or placed file that doesn't match its task
+type verifyMismatch struct {
+	VirtualPath string
+	Path        string
+	Reason      string
+}
+
+// verifySummary counts the outcome of a verification
+type verifySummary struct {
+	Downloaded int // files in the cache directory
+	Placed     int // destination files that already have the new version
+	Mismatches []verifyMismatch
+}
+
+// checkDigest compares the digest of the algorithm of the first size bytes of path, 0 for the whole file, with expected
+func checkDigest(path string, size int64, expected *string, algorithm string) (string, error) {
+	if expected == nil {
+		return "", nil
+	}
+	digester, err := woc.NewDigester(algorithm)
+	if err != nil {
+		return "", err
+	}
+	digest, err := digester.Digest(path, 0, size)
+	if err != nil {
+		return "", err
+	}
+	if digest != *expected {
+		return fmt.Sprintf("digest mismatch: expected %s, got %s (%s)", *expected, digest, digester.Name()), nil
+	}
+	return "", nil
+}
+
+// verifyTask checks the cached download and the destination of a task against its sizes and digests
+func verifyTask(task *woc.WocSyncTask, summary *verifySummary) {
+	mismatch := func(path, reason string) {
+		summary.Mismatches = append(summary.Mismatches, verifyMismatch{task.VirtualPath, path, reason})
+	}
+	isPartial := task.Offset > 0
+
+	cachePath := filepath.Join(cacheDir, filepath.FromSlash(task.VirtualPath))
+	if stat, err := os.Stat(cachePath); err == nil {
+		summary.Downloaded++
+		switch {
+		case stat.Size() != task.Size:
+			mismatch(cachePath, fmt.Sprintf("size mismatch: expected %d, got %d", task.Size, stat.Size()))
+		case !isPartial:
+			// the appended part of a partial copy has no digest of its own
+			if reason, err := checkDigest(cachePath, 0, task.SourceDigest, task.SourceDigestAlgorithm); err != nil {
+				mismatch(cachePath, err.Error())
+			} else if reason != "" {
+				mismatch(cachePath, reason)
+			}
+		}
+	}
+
+	destPath := taskDestPath(task)
+	stat, err := os.Stat(destPath)
+	if err != nil {
+		if isPartial {
+			mismatch(destPath, "destination file to append to is missing")
+		}
+		return
+	}
+	switch {
+	case stat.Size() == task.Offset+task.Size:
+		// already placed, e.g. by an interrupted run
+		reason, err := checkDigest(destPath, 0, task.SourceDigest, task.SourceDigestAlgorithm)
+		if err != nil {
+			mismatch(destPath, err.Error())
+		} else if reason == "" {
+			summary.Placed++
+		} else if isPartial {
+			mismatch(destPath, reason)
+		}
+	case isPartial && stat.Size() == task.Offset:
+		if reason, err := checkDigest(destPath, task.Offset, task.TargetDigest, task.TargetDigestAlgorithm); err != nil {
+			mismatch(destPath, err.Error())
+		} else if reason != "" {
+			mismatch(destPath, reason)
+		}
+	case isPartial:
+		mismatch(destPath, fmt.Sprintf("size mismatch: expected %d before or %d after appending, got %d",
+			task.Offset, task.Offset+task.Size, stat.Size()))
+	}
+}
+
+// runVerify checks all tasks without moving or deleting anything and prints the mismatches to w
+func runVerify(w io.Writer, tasksMap map[string]*woc.WocSyncTask) *verifySummary {
+	tasks := make([]*woc.WocSyncTask, 0, len(tasksMap))
+	for _, task := range tasksMap {
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].VirtualPath < tasks[j].VirtualPath
+	})
+
+	summary := &verifySummary{}
+	for _, task := range tasks {
+		verifyTask(task, summary)
+	}
+
+	for _, m := range summary.Mismatches {
+		fmt.Fprintf(w, "MISMATCH %s (%s): %s\n", m.VirtualPath, m.Path, m.Reason)
+	}
+	fmt.Fprintf(w, "Verified %d tasks: %d downloaded, %d already placed, %d mismatches\n",
+		len(tasks), summary.Downloaded, summary.Placed, len(summary.Mismatches))
+	return summary
+}
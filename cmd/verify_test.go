@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hrz6976/syncmate/offsetfs"
+	"github.com/hrz6976/syncmate/woc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunVerify(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	oldCacheDir, oldDestDir := cacheDir, destDir
+	defer func() { cacheDir, destDir = oldCacheDir, oldDestDir }()
+	cacheDir = filepath.Join(tmpDir, "cache")
+	destDir = filepath.Join(tmpDir, "dest")
+	require.NoError(t, os.MkdirAll(cacheDir, 0755))
+	require.NoError(t, os.MkdirAll(destDir, 0755))
+
+	writeFile := func(path, content string) string {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		res, err := woc.SampleMD5(path, 0, 0)
+		require.NoError(t, err)
+		return res.Digest
+	}
+	newTask := func(virtualPath, targetPath string, offset, size int64, srcDigest, dstDigest string) *woc.WocSyncTask {
+		task := &woc.WocSyncTask{
+			FileConfig: offsetfs.FileConfig{VirtualPath: virtualPath, Offset: offset, Size: size},
+			TargetPath: targetPath,
+		}
+		if srcDigest != "" {
+			task.SourceDigest = &srcDigest
+		}
+		if dstDigest != "" {
+			task.TargetDigest = &dstDigest
+		}
+		return task
+	}
+
+	goodDigest := writeFile(filepath.Join(cacheDir, "a.bin"), "0123456789")
+	writeFile(filepath.Join(cacheDir, "b.bin"), "9876543210")
+	oldDigest := writeFile(filepath.Join(destDir, "c.tch"), "01234")
+	writeFile(filepath.Join(cacheDir, "c.tch.offset.5"), "56789")
+	placedDigest := writeFile(filepath.Join(destDir, "d.bin"), "abcdefghij")
+
+	tasksMap := map[string]*woc.WocSyncTask{
+		"a.bin":          newTask("a.bin", filepath.Join(destDir, "a.bin"), 0, 10, goodDigest, ""),
+		"b.bin":          newTask("b.bin", filepath.Join(destDir, "b.bin"), 0, 10, goodDigest, ""),
+		"c.tch.offset.5": newTask("c.tch.offset.5", filepath.Join(destDir, "c.tch"), 5, 5, goodDigest, oldDigest),
+		"e.tch.offset.5": newTask("e.tch.offset.5", filepath.Join(destDir, "e.tch"), 5, 5, goodDigest, oldDigest),
+		"d.bin":          newTask("d.bin", filepath.Join(destDir, "d.bin"), 0, 10, placedDigest, ""),
+	}
+
+	var out bytes.Buffer
+	summary := runVerify(&out, tasksMap)
+
+	assert.Equal(t, 3, summary.Downloaded)
+	assert.Equal(t, 1, summary.Placed)
+	require.Len(t, summary.Mismatches, 2)
+	assert.Equal(t, "b.bin", summary.Mismatches[0].VirtualPath)
+	assert.Contains(t, summary.Mismatches[0].Reason, "digest mismatch")
+	assert.Equal(t, "e.tch.offset.5", summary.Mismatches[1].VirtualPath)
+	assert.Contains(t, out.String(), "Verified 5 tasks: 3 downloaded, 1 already placed, 2 mismatches")
+
+	// nothing was moved
+	assert.FileExists(t, filepath.Join(cacheDir, "a.bin"))
+	assert.NoFileExists(t, filepath.Join(destDir, "a.bin"))
+}
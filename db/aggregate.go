@@ -0,0 +1,85 @@
+package db
+
+import (
+	"fmt"
+
+	"gorm.io/gorm/clause"
+)
+
+// GroupBy is the dimension AggregateTasks groups the tasks by, in addition to their status
+type GroupBy string
+
+const (
+	// GroupByStatus only groups by status
+	GroupByStatus GroupBy = "status"
+	// GroupByHost groups by the host that holds or held the lease of the task, see ClaimTasks
+	GroupByHost GroupBy = "host"
+	// GroupByDstDir groups by the directory of the destination path
+	GroupByDstDir GroupBy = "dst-dir"
+)
+
+// GroupBys are the dimensions of AggregateTasks
+var GroupBys = []GroupBy{GroupByStatus, GroupByHost, GroupByDstDir}
+
+// TaskAggregate is the number and total source size of the tasks of a group with a status
+type TaskAggregate struct {
+	// Key is the host or directory of the group, empty for GroupByStatus
+	Key    string `gorm:"column:group_key"`
+	Status Status
+	Count  int64
+	Size   int64
+}
+
+// groupKey returns the SQL expression of the group key
+func (db *DB) groupKey(by GroupBy) (string, error) {
+	switch by {
+	case GroupByStatus:
+		return "''", nil
+	case GroupByHost:
+		return "COALESCE(owner_host, '')", nil
+	case GroupByDstDir:
+		if db.conn.Dialector.Name() == DriverMySQL {
+			return "SUBSTRING(dst_path, 1, CHAR_LENGTH(dst_path) - LOCATE('/', REVERSE(dst_path)))", nil
+		}
+		// strip the characters after the last slash, then the slash
+		return "RTRIM(RTRIM(dst_path, REPLACE(dst_path, '/', '')), '/')", nil
+	default:
+		return "", fmt.Errorf("unknown grouping %q, must be one of %v", by, GroupBys)
+	}
+}
+
+// AggregateTasks counts and sums the tasks by status and the group of by in one query,
+// ordered by group and status.
+func (db *DB) AggregateTasks(by GroupBy) ([]TaskAggregate, error) {
+	key, err := db.groupKey(by)
+	if err != nil {
+		return nil, err
+	}
+	// PostgreSQL doesn't group by a constant
+	group := "status"
+	if by != GroupByStatus {
+		group = key + ", status"
+	}
+	var aggregates []TaskAggregate
+	if err := db.getConnection().Model(&Task{}).
+		Select(key + " AS group_key, status, COUNT(*) AS count, COALESCE(SUM(src_size), 0) AS size").
+		Clauses(clause.GroupBy{Columns: []clause.Column{{Name: group, Raw: true}}}).
+		Order("group_key, status").
+		Scan(&aggregates).Error; err != nil {
+		return nil, err
+	}
+	return aggregates, nil
+}
+
+// SummarizeByStatus returns the number and total size of the tasks of every status that has tasks
+func (db *DB) SummarizeByStatus() (map[Status]StatusSummary, error) {
+	aggregates, err := db.AggregateTasks(GroupByStatus)
+	if err != nil {
+		return nil, err
+	}
+	summaries := make(map[Status]StatusSummary, len(aggregates))
+	for _, aggregate := range aggregates {
+		summaries[aggregate.Status] = StatusSummary{Count: aggregate.Count, Size: aggregate.Size}
+	}
+	return summaries, nil
+}
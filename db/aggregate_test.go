@@ -0,0 +1,79 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAggregateTasks(t *testing.T) {
+	dbInstance := SetupDBInstance(t)
+
+	tasks := []*Task{
+		{VirtualPath: "/test/agg_0.bin", DstPath: "/da8/All.blobs/agg_0.bin", SrcSize: 10, Status: Pending},
+		{VirtualPath: "/test/agg_1.bin", DstPath: "/da8/All.blobs/agg_1.bin", SrcSize: 20, Status: Pending},
+		{VirtualPath: "/test/agg_2.bin", DstPath: "/da8/basemaps/agg_2.tch", SrcSize: 30, Status: Downloaded},
+		{VirtualPath: "/test/agg_3.bin", DstPath: "agg_3.bin", SrcSize: 40, Status: Pending},
+	}
+	if err := dbInstance.AddTasks(tasks); err != nil {
+		t.Fatalf("Failed to add tasks: %v", err)
+	}
+	defer func() {
+		for _, task := range tasks {
+			_ = dbInstance.DeleteTask(task.VirtualPath)
+		}
+	}()
+	if _, err := dbInstance.ClaimTasks("da5", []string{"/test/agg_0.bin"}, 1, time.Hour); err != nil {
+		t.Fatalf("Failed to claim tasks: %v", err)
+	}
+
+	summaries, err := dbInstance.SummarizeByStatus()
+	if err != nil {
+		t.Fatalf("Failed to summarize tasks: %v", err)
+	}
+	if summaries[Pending] != (StatusSummary{Count: 3, Size: 70}) || summaries[Downloaded] != (StatusSummary{Count: 1, Size: 30}) {
+		t.Errorf("Unexpected summaries %+v", summaries)
+	}
+	if _, ok := summaries[Failed]; ok {
+		t.Error("Expected no summary of a status without tasks")
+	}
+
+	byHost, err := dbInstance.AggregateTasks(GroupByHost)
+	if err != nil {
+		t.Fatalf("Failed to aggregate tasks: %v", err)
+	}
+	wantHost := []TaskAggregate{
+		{Key: "", Status: Pending, Count: 2, Size: 60},
+		{Key: "", Status: Downloaded, Count: 1, Size: 30},
+		{Key: "da5", Status: Pending, Count: 1, Size: 10},
+	}
+	if len(byHost) != len(wantHost) {
+		t.Fatalf("Expected %v, got %v", wantHost, byHost)
+	}
+	for i := range wantHost {
+		if byHost[i] != wantHost[i] {
+			t.Errorf("Expected %+v, got %+v", wantHost[i], byHost[i])
+		}
+	}
+
+	byDir, err := dbInstance.AggregateTasks(GroupByDstDir)
+	if err != nil {
+		t.Fatalf("Failed to aggregate tasks: %v", err)
+	}
+	wantDir := []TaskAggregate{
+		{Key: "", Status: Pending, Count: 1, Size: 40},
+		{Key: "/da8/All.blobs", Status: Pending, Count: 2, Size: 30},
+		{Key: "/da8/basemaps", Status: Downloaded, Count: 1, Size: 30},
+	}
+	if len(byDir) != len(wantDir) {
+		t.Fatalf("Expected %v, got %v", wantDir, byDir)
+	}
+	for i := range wantDir {
+		if byDir[i] != wantDir[i] {
+			t.Errorf("Expected %+v, got %+v", wantDir[i], byDir[i])
+		}
+	}
+
+	if _, err := dbInstance.AggregateTasks("size"); err == nil {
+		t.Error("Expected an error for an unknown grouping")
+	}
+}
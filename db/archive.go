@@ -0,0 +1,125 @@
+package db
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ListEventsOfTasks returns the events of the tasks with the given ids, in the order they happened
+func (db *DB) ListEventsOfTasks(taskIDs []uint) ([]*TaskEvent, error) {
+	var events []*TaskEvent
+	for start := 0; start < len(taskIDs); start += maxBoundParams {
+		var chunk []*TaskEvent
+		if err := db.conn.Where("task_id IN ?", taskIDs[start:min(start+maxBoundParams, len(taskIDs))]).
+			Order("id").Find(&chunk).Error; err != nil {
+			return nil, err
+		}
+		events = append(events, chunk...)
+	}
+	return events, nil
+}
+
+// PurgeTasks permanently deletes the tasks with the given ids and their events, unlike DeleteTask
+// which keeps the rows. The events are deleted first, so a failed purge leaves no events without a task.
+func (db *DB) PurgeTasks(taskIDs []uint) error {
+	for start := 0; start < len(taskIDs); start += maxBoundParams {
+		chunk := taskIDs[start:min(start+maxBoundParams, len(taskIDs))]
+		if err := db.conn.Where("task_id IN ?", chunk).Delete(&TaskEvent{}).Error; err != nil {
+			return err
+		}
+		if err := db.getConnection().Unscoped().Where("id IN ?", chunk).Delete(&Task{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TaskRecord is a task with its status changes, as written by db export and db purge
+type TaskRecord struct {
+	*Task
+	Events []*TaskEvent `json:"events,omitempty"`
+}
+
+// ImportTasks writes the records exported from another database into the run of this one,
+// keeping their status, timestamps and events. Tasks that exist already are skipped, or
+// replaced with their events if overwrite is set. Returns the number of tasks written.
+func (db *DB) ImportTasks(records []TaskRecord, overwrite bool) (int, error) {
+	imported := 0
+	chunkSize := maxBoundParams / taskColumnCount
+	for start := 0; start < len(records); start += chunkSize {
+		chunk := records[start:min(start+chunkSize, len(records))]
+		paths := make([]string, len(chunk))
+		for i, record := range chunk {
+			paths[i] = record.VirtualPath
+		}
+		existing, err := db.taskIDs(db.getConnection().Unscoped(), paths)
+		if err != nil {
+			return imported, err
+		}
+
+		var rows []*Task
+		var replaced []uint
+		events := make(map[string][]*TaskEvent)
+		for _, record := range chunk {
+			if id, ok := existing[record.VirtualPath]; ok {
+				if !overwrite {
+					continue
+				}
+				replaced = append(replaced, id)
+			}
+			row := *record.Task
+			row.ID = 0
+			row.RunID = db.runID
+			rows = append(rows, &row)
+			events[row.VirtualPath] = record.Events
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		if len(replaced) > 0 {
+			if err := db.conn.Where("task_id IN ?", replaced).Delete(&TaskEvent{}).Error; err != nil {
+				return imported, err
+			}
+		}
+		if err := db.getConnection().Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "run_id"}, {Name: "virtual_path"}},
+			UpdateAll: true,
+		}).Create(rows).Error; err != nil {
+			return imported, err
+		}
+
+		ids, err := db.taskIDs(db.getConnection().Unscoped(), paths)
+		if err != nil {
+			return imported, err
+		}
+		var rowEvents []*TaskEvent
+		for _, row := range rows {
+			for _, event := range events[row.VirtualPath] {
+				copied := *event
+				copied.ID = 0
+				copied.TaskID = ids[row.VirtualPath]
+				rowEvents = append(rowEvents, &copied)
+			}
+		}
+		if len(rowEvents) > 0 {
+			if err := db.conn.CreateInBatches(rowEvents, maxBoundParams/eventColumnCount).Error; err != nil {
+				return imported, err
+			}
+		}
+		imported += len(rows)
+	}
+	return imported, nil
+}
+
+// taskIDs returns the ids of the tasks at paths that exist in query, by virtual path
+func (db *DB) taskIDs(query *gorm.DB, paths []string) (map[string]uint, error) {
+	var rows []Task
+	if err := query.Where("virtual_path IN ?", paths).Select("id", "virtual_path").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	ids := make(map[string]uint, len(rows))
+	for _, row := range rows {
+		ids[row.VirtualPath] = row.ID
+	}
+	return ids, nil
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPurgeTasks(t *testing.T) {
+	dbInstance := SetupDBInstance(t)
+
+	for _, path := range []string{"/test/purge_0.bin", "/test/purge_1.bin"} {
+		for _, status := range []Status{Uploaded, Downloaded} {
+			if err := dbInstance.UpdateTask(&Task{VirtualPath: path, SrcPath: "/source" + path, Status: status}); err != nil {
+				t.Fatalf("Failed to update task: %v", err)
+			}
+		}
+	}
+	defer func() { _ = dbInstance.DeleteTask("/test/purge_1.bin") }()
+
+	downloaded := Downloaded
+	tasks, err := dbInstance.ListTasksFiltered(TaskFilter{Status: &downloaded, UpdatedBefore: time.Now().Add(time.Second)}, 0, 10)
+	if err != nil || len(tasks) != 2 {
+		t.Fatalf("Expected 2 tasks updated before now, got %d %v", len(tasks), err)
+	}
+	if old, err := dbInstance.ListTasksFiltered(TaskFilter{UpdatedBefore: time.Now().Add(-time.Hour)}, 0, 10); err != nil || len(old) != 0 {
+		t.Errorf("Expected no tasks updated an hour ago, got %d %v", len(old), err)
+	}
+
+	events, err := dbInstance.ListEventsOfTasks([]uint{tasks[0].ID, tasks[1].ID})
+	if err != nil || len(events) != 4 {
+		t.Fatalf("Expected 4 events, got %d %v", len(events), err)
+	}
+
+	if err := dbInstance.PurgeTasks([]uint{tasks[0].ID}); err != nil {
+		t.Fatalf("Failed to purge tasks: %v", err)
+	}
+	var rows int64
+	if err := dbInstance.conn.Unscoped().Model(&Task{}).Count(&rows).Error; err != nil || rows != 1 {
+		t.Errorf("Expected the purged row to be gone, got %d rows %v", rows, err)
+	}
+	if events, err := dbInstance.ListEventsOfTasks([]uint{tasks[0].ID, tasks[1].ID}); err != nil || len(events) != 2 {
+		t.Errorf("Expected the events of the other task to be kept, got %d %v", len(events), err)
+	}
+}
+
+func TestImportTasks(t *testing.T) {
+	dbInstance := SetupDBInstance(t)
+	defer func() {
+		_ = dbInstance.DeleteTask("/test/import_0.bin")
+		_ = dbInstance.DeleteTask("/test/import_1.bin")
+	}()
+
+	if err := dbInstance.UpdateTask(&Task{VirtualPath: "/test/import_0.bin", SrcPath: "/source/old", Status: Pending}); err != nil {
+		t.Fatalf("Failed to update task: %v", err)
+	}
+	updatedAt := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	pending := Pending
+	records := []TaskRecord{
+		{Task: &Task{VirtualPath: "/test/import_0.bin", SrcPath: "/source/new", Status: Uploaded, Version: 2}},
+		{
+			Task: &Task{VirtualPath: "/test/import_1.bin", SrcPath: "/source/import_1.bin", Status: Downloaded, Attempts: 2, Version: 3},
+			Events: []*TaskEvent{
+				{TaskID: 42, NewStatus: Pending},
+				{TaskID: 42, OldStatus: &pending, NewStatus: Downloaded, Host: "worker-1"},
+			},
+		},
+	}
+	records[1].Task.ID = 42
+	records[1].Task.UpdatedAt = updatedAt
+
+	imported, err := dbInstance.ImportTasks(records, false)
+	if err != nil || imported != 1 {
+		t.Fatalf("Expected 1 imported task, got %d %v", imported, err)
+	}
+	task, events, err := dbInstance.ListTaskEvents("/test/import_1.bin")
+	if err != nil {
+		t.Fatalf("Failed to list task events: %v", err)
+	}
+	if task.Status != Downloaded || task.Attempts != 2 || task.Version != 3 || !task.UpdatedAt.Equal(updatedAt) {
+		t.Errorf("Expected the imported task to keep its fields, got %+v", task)
+	}
+	if len(events) != 2 || events[1].TaskID != task.ID || events[1].Host != "worker-1" {
+		t.Errorf("Expected the events to follow the imported task, got %+v", events)
+	}
+	if kept, err := dbInstance.GetTask("/test/import_0.bin"); err != nil || kept.SrcPath != "/source/old" {
+		t.Errorf("Expected the existing task to be skipped, got %+v %v", kept, err)
+	}
+
+	imported, err = dbInstance.ImportTasks(records, true)
+	if err != nil || imported != 2 {
+		t.Fatalf("Expected 2 imported tasks, got %d %v", imported, err)
+	}
+	if replaced, err := dbInstance.GetTask("/test/import_0.bin"); err != nil || replaced.SrcPath != "/source/new" || replaced.Status != Uploaded {
+		t.Errorf("Expected the existing task to be overwritten, got %+v %v", replaced, err)
+	}
+	if _, events, err := dbInstance.ListTaskEvents("/test/import_1.bin"); err != nil || len(events) != 2 {
+		t.Errorf("Expected the events of an overwritten task to be replaced, got %d %v", len(events), err)
+	}
+}
@@ -2,13 +2,12 @@ package db
 
 import (
 	"fmt"
-	"log"
-	"os"
 	"time"
 
 	d1 "github.com/hrz6976/syncmate/d1_gorm_adapter"
 	"github.com/hrz6976/syncmate/d1_gorm_adapter/gormd1"
 	_ "github.com/hrz6976/syncmate/d1_gorm_adapter/stdlib"
+	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
@@ -21,14 +20,15 @@ type CloudflareD1Credentials struct {
 
 func ConnectDB(p CloudflareD1Credentials) (*gorm.DB, error) {
 	defaultDSN := fmt.Sprintf("d1://%s:%s@%s", p.AccountID, p.APIToken, p.DatabaseID)
-	d1.TraceOn(os.Stdout)
+	// the traces of the requests are debug logs
+	d1.TraceOn(logrus.StandardLogger().WriterLevel(logrus.DebugLevel))
 	newLogger := logger.New(
-		log.New(os.Stdout, "\r\n", log.LstdFlags), // io writer
+		logrus.StandardLogger(), // the syncmate logger, in its format
 		logger.Config{
 			SlowThreshold:        time.Second, // Slow SQL threshold
 			LogLevel:             logger.Info, // Log level
 			ParameterizedQueries: true,        // Don't include params in the SQL log
-			Colorful:             true,        // Disable color
+			Colorful:             false,       // no escape codes in the log files
 		},
 	)
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"gorm.io/driver/sqlite"
@@ -81,3 +82,76 @@ func TestConnectDB(t *testing.T) {
 
 	fmt.Println("Database connection is alive")
 }
+
+func TestConnectSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.db")
+	gdb, err := ConnectSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to open SQLite database: %v", err)
+	}
+	if err := newMigratedDB(t, gdb).UpdateTask(&Task{VirtualPath: "blob_0.bin", SrcPath: "/da5/blob_0.bin", Status: Uploaded}); err != nil {
+		t.Fatalf("Failed to update task: %v", err)
+	}
+	if err := CloseDB(gdb); err != nil {
+		t.Fatal(err)
+	}
+
+	// the tasks are kept in the file
+	gdb, err = ConnectSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen SQLite database: %v", err)
+	}
+	defer CloseDB(gdb)
+	task, err := newMigratedDB(t, gdb).GetTask("blob_0.bin")
+	if err != nil || task.Status != Uploaded {
+		t.Errorf("Expected the Uploaded task, got %v %v", task, err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	var missing *Config
+	if err := missing.Validate(); err != nil {
+		t.Errorf("Expected a missing config to select D1, got %v", err)
+	}
+	for _, cfg := range []*Config{
+		{Driver: DriverSQLite},
+		{Driver: DriverPostgres},
+		{Driver: DriverMySQL, Path: "tasks.db"},
+		{Driver: "oracle"},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected an error for %+v", cfg)
+		}
+	}
+	for _, cfg := range []*Config{
+		{Driver: DriverD1},
+		{Driver: DriverSQLite, Path: "tasks.db"},
+		{Driver: DriverPostgres, DSN: "host=localhost dbname=syncmate"},
+		{Driver: DriverMySQL, DSN: "syncmate@tcp(localhost:3306)/syncmate"},
+	} {
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Unexpected error for %+v: %v", cfg, err)
+		}
+	}
+}
+
+func TestOpen_RegisteredDriver(t *testing.T) {
+	RegisterDriver("memory", Driver{
+		Open: func(cfg *Config, creds CloudflareD1Credentials) (*gorm.DB, error) {
+			return SetupSQLiteDB()
+		},
+	})
+	defer delete(drivers, "memory")
+
+	gdb, err := Open(&Config{Driver: "memory"}, CloudflareD1Credentials{})
+	if err != nil {
+		t.Fatalf("Failed to open registered driver: %v", err)
+	}
+	defer CloseDB(gdb)
+	if _, err := newMigratedDB(t, gdb).CountTasks(); err != nil {
+		t.Errorf("Failed to count tasks: %v", err)
+	}
+	if _, err := Open(&Config{Driver: "oracle"}, CloudflareD1Credentials{}); err == nil {
+		t.Error("Expected an error for an unknown driver")
+	}
+}
@@ -1,6 +1,13 @@
 package db
 
 import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
@@ -22,15 +29,32 @@ type DBOperation interface {
 	CountTasks() (int64, error)
 	// GetTasksByStatus returns count and total size of tasks by status.
 	GetTasksByStatus(status Status) (*StatusSummary, error)
+	// SummarizeByStatus returns count and total size of the tasks of every status in one query.
+	SummarizeByStatus() (map[Status]StatusSummary, error)
+	// AggregateTasks returns count and total size of the tasks by status and host or destination directory.
+	AggregateTasks(by GroupBy) ([]TaskAggregate, error)
 }
 
+var _ DBOperation = (*DB)(nil)
+
 // DB is the concrete implementation of DBOperation
 type DB struct {
-	conn *gorm.DB
+	conn    *gorm.DB
+	journal *journal
+	runID   string
+	// host is recorded in the events
+	host string
 }
 
+// getConnection returns the connection scoped to the tasks of the run, see SetRun
 func (db *DB) getConnection() *gorm.DB {
-	return db.conn
+	return db.conn.Where("run_id = ?", db.runID)
+}
+
+// SetRun scopes the tasks read and written to those of runID, so that the tasks of successive
+// transfers of the same files are kept apart. The default run is the empty one.
+func (db *DB) SetRun(runID string) {
+	db.runID = runID
 }
 
 func (db *DB) CreateTask(task *Task) error {
@@ -48,14 +72,263 @@ func (db *DB) GetTask(virtualPath string) (*Task, error) {
 	return &task, nil
 }
 
+// upsertColumns are the columns overwritten when UpdateTask hits an existing row.
+// Priority is left alone so that operator-assigned priorities survive status updates.
+var upsertColumns = []string{
+	"updated_at", "deleted_at", "src_path", "src_size", "src_digest",
+	"src_digest_algorithm", "dst_path", "dst_size", "dst_digest", "dst_digest_algorithm", "status", "error", "transferred_bytes", "placed_bytes",
+}
+
+// UpdateTask creates or updates the task by virtual path. Marking it Uploaded or Downloaded
+// records the time of the transition, the other transition time is kept.
+// Every call appends a TaskEvent and marking the task Uploading or Downloading counts an attempt.
+// Status changes that CanTransition doesn't allow are rejected with a RejectedError.
 func (db *DB) UpdateTask(task *Task) error {
-	if err := db.getConnection().Clauses(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "virtual_path"}},
-		UpdateAll: true,
-	}).Create(task).Error; err != nil {
-		return err
+	return db.UpdateTasks([]*Task{task})
+}
+
+// Columns of a Task and a TaskEvent row, the rows of one statement stay below maxBoundParams
+const (
+	taskColumnCount  = 27
+	eventColumnCount = 9
+)
+
+// UpdateTasks is UpdateTask for many tasks, with a few statements per chunk of tasks instead of a
+// few per task. The chunks are not written in one transaction, after an error the previous chunks are kept.
+// With a journal, the tasks that could not be written are queued instead, see SetJournal.
+func (db *DB) UpdateTasks(tasks []*Task) error {
+	if db.journal != nil {
+		return db.journal.update(db, tasks)
 	}
-	return nil
+	_, err := db.writeTasks(tasks)
+	return err
+}
+
+// RejectedError reports the tasks that UpdateTasks left alone because their status can't change
+// to the new one or they kept being updated concurrently. The other tasks were written.
+type RejectedError struct {
+	// Tasks maps the virtual paths to the reasons
+	Tasks map[string]string
+}
+
+func (e *RejectedError) Error() string {
+	paths := slices.Sorted(maps.Keys(e.Tasks))
+	reasons := make([]string, 0, min(len(paths), 3))
+	for _, path := range paths[:min(len(paths), 3)] {
+		reasons = append(reasons, path+": "+e.Tasks[path])
+	}
+	if len(paths) > 3 {
+		reasons = append(reasons, fmt.Sprintf("and %d more", len(paths)-3))
+	}
+	return fmt.Sprintf("rejected %d task updates: %s", len(paths), strings.Join(reasons, ", "))
+}
+
+// writeTasks upserts tasks and returns the ones that were not written on an error
+func (db *DB) writeTasks(tasks []*Task) ([]*Task, error) {
+	for _, task := range tasks {
+		task.RunID = db.runID
+	}
+	// the transition time columns depend on the status, upsert the tasks of each status together
+	byStatus := make(map[Status][]*Task)
+	var statuses []Status
+	for _, task := range tasks {
+		if _, ok := byStatus[task.Status]; !ok {
+			statuses = append(statuses, task.Status)
+		}
+		byStatus[task.Status] = append(byStatus[task.Status], task)
+	}
+	chunkSize := maxBoundParams / taskColumnCount
+	rejected := &RejectedError{Tasks: make(map[string]string)}
+	for s, status := range statuses {
+		group := byStatus[status]
+		for start := 0; start < len(group); start += chunkSize {
+			reasons, err := db.upsertTasks(status, group[start:min(start+chunkSize, len(group))])
+			maps.Copy(rejected.Tasks, reasons)
+			if err != nil {
+				rest := slices.Clone(group[start:])
+				for _, later := range statuses[s+1:] {
+					rest = append(rest, byStatus[later]...)
+				}
+				return rest, err
+			}
+		}
+	}
+	if len(rejected.Tasks) > 0 {
+		return nil, rejected
+	}
+	return nil, nil
+}
+
+// maxUpdateTries bounds how often upsertTasks rereads the tasks that were updated concurrently
+const maxUpdateTries = 3
+
+// upsertTasks writes tasks of the same status and their events. Tasks that can't change to status or
+// keep being updated concurrently are left out and returned with the reason.
+func (db *DB) upsertTasks(status Status, tasks []*Task) (map[string]string, error) {
+	rejected := make(map[string]string)
+	for try := 1; len(tasks) > 0; try++ {
+		if try > maxUpdateTries {
+			for _, task := range tasks {
+				rejected[task.VirtualPath] = "updated concurrently"
+			}
+			break
+		}
+		conflicts, err := db.tryUpsertTasks(status, tasks, rejected)
+		if err != nil {
+			return rejected, err
+		}
+		tasks = conflicts
+	}
+	return rejected, nil
+}
+
+// tryUpsertTasks writes the tasks whose rows didn't change since they were read and returns the others
+func (db *DB) tryUpsertTasks(status Status, tasks []*Task, rejected map[string]string) ([]*Task, error) {
+	paths := make([]string, len(tasks))
+	for i, task := range tasks {
+		paths[i] = task.VirtualPath
+	}
+	var rows []Task
+	if err := db.getConnection().Unscoped().Where("virtual_path IN ?", paths).
+		Select("id", "virtual_path", "status", "attempts", "version", "deleted_at").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	old := make(map[string]Task, len(rows))
+	for _, row := range rows {
+		old[row.VirtualPath] = row
+	}
+
+	columns := append(upsertColumns[:len(upsertColumns):len(upsertColumns)], "attempts", "version")
+	switch status {
+	case Uploaded:
+		columns = append(columns, "uploaded_at", "etag", "object_size")
+	case Downloaded:
+		columns = append(columns, "downloaded_at")
+	}
+	// the databases keep microseconds at least, so that the batch can find the rows it wrote by it
+	now := time.Now().Truncate(time.Microsecond)
+	var allowed []*Task
+	for _, task := range tasks {
+		row, exists := old[task.VirtualPath]
+		// a deleted task starts over
+		if exists && !row.DeletedAt.Valid && !CanTransition(row.Status, status) {
+			rejected[task.VirtualPath] = fmt.Sprintf("can't change from %s to %s", row.Status, status)
+			continue
+		}
+		switch status {
+		case Uploaded:
+			task.UploadedAt = &now
+		case Downloaded:
+			task.DownloadedAt = &now
+		}
+		task.Attempts = row.Attempts
+		switch status {
+		case Uploading, Downloading:
+			task.Attempts++
+		}
+		task.Version = row.Version + 1
+		task.UpdatedAt = now
+		allowed = append(allowed, task)
+	}
+	if len(allowed) == 0 {
+		return nil, nil
+	}
+
+	// compare and swap, the row is only updated if no one else did since it was read
+	upsert := clause.OnConflict{
+		Columns:   []clause.Column{{Name: "run_id"}, {Name: "virtual_path"}},
+		DoUpdates: clause.AssignmentColumns(columns),
+	}
+	cas := db.conn.Dialector.Name() != DriverMySQL
+	if cas {
+		upsert.Where = clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "tasks.version = excluded.version - 1"}}}
+	}
+	result := db.getConnection().Clauses(upsert).Create(allowed)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	written, conflicts := allowed, []*Task(nil)
+	if cas && result.RowsAffected < int64(len(allowed)) {
+		// the batch wrote the rows that have its version and update time now, the others were swapped by someone else
+		allowedPaths := make([]string, len(allowed))
+		for i, task := range allowed {
+			allowedPaths[i] = task.VirtualPath
+		}
+		var rows []Task
+		if err := db.getConnection().Where("virtual_path IN ?", allowedPaths).Select("virtual_path", "version", "updated_at").Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		current := make(map[string]Task, len(rows))
+		for _, row := range rows {
+			current[row.VirtualPath] = row
+		}
+		written = nil
+		for _, task := range allowed {
+			if row, ok := current[task.VirtualPath]; ok && row.Version == task.Version && row.UpdatedAt.Equal(now) {
+				written = append(written, task)
+			} else {
+				conflicts = append(conflicts, task)
+			}
+		}
+	}
+
+	// not every driver returns the ids of upserted rows, look up the new ones
+	var created []string
+	for _, task := range written {
+		if row, ok := old[task.VirtualPath]; ok {
+			task.ID = row.ID
+		} else if task.ID == 0 {
+			created = append(created, task.VirtualPath)
+		}
+	}
+	ids := make(map[string]uint, len(created))
+	if len(created) > 0 {
+		var rows []Task
+		if err := db.getConnection().Where("virtual_path IN ?", created).Select("id", "virtual_path").Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			ids[row.VirtualPath] = row.ID
+		}
+	}
+
+	events := make([]*TaskEvent, len(written))
+	for i, task := range written {
+		if task.ID == 0 {
+			task.ID = ids[task.VirtualPath]
+		}
+		events[i] = &TaskEvent{
+			TaskID:            task.ID,
+			NewStatus:         status,
+			Error:             task.Error,
+			Bytes:             task.SrcSize,
+			Host:              db.host,
+			TransferStartedAt: task.TransferStartedAt,
+			TransferEndedAt:   task.TransferEndedAt,
+		}
+		if row, ok := old[task.VirtualPath]; ok {
+			events[i].OldStatus = &row.Status
+		}
+	}
+	if len(events) > 0 {
+		if err := db.conn.CreateInBatches(events, maxBoundParams/eventColumnCount).Error; err != nil {
+			return nil, err
+		}
+	}
+	return conflicts, nil
+}
+
+// ListTaskEvents returns the status changes of the task in the order they happened, and the task itself.
+func (db *DB) ListTaskEvents(virtualPath string) (*Task, []*TaskEvent, error) {
+	var task Task
+	if err := db.getConnection().Unscoped().Where("virtual_path = ?", virtualPath).First(&task).Error; err != nil {
+		return nil, nil, err
+	}
+	var events []*TaskEvent
+	if err := db.conn.Where("task_id = ?", task.ID).Order("id").Find(&events).Error; err != nil {
+		return nil, nil, err
+	}
+	return &task, events, nil
 }
 
 func (db *DB) DeleteTask(virtualPath string) error {
@@ -74,6 +347,62 @@ func (db *DB) ListTasks(offset, limit int) ([]*Task, error) {
 	return tasks, nil
 }
 
+// TaskFilter selects the tasks of ListTasksFiltered, zero fields match all tasks.
+type TaskFilter struct {
+	Status *Status
+	// Prefix of the virtual path
+	Prefix string
+	// MinSize is the smallest source size
+	MinSize int64
+	// UpdatedBefore only matches the tasks last updated before it
+	UpdatedBefore time.Time
+}
+
+// ListTasksFiltered retrieves the tasks matching filter with pagination, ordered by virtual path.
+func (db *DB) ListTasksFiltered(filter TaskFilter, offset, limit int) ([]*Task, error) {
+	query := db.getConnection().Order("virtual_path")
+	if filter.Status != nil {
+		query = query.Where("status = ?", *filter.Status)
+	}
+	if filter.Prefix != "" {
+		// not a backslash, MySQL would read it as an escape of the quote
+		escaped := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(filter.Prefix)
+		query = query.Where("virtual_path LIKE ? ESCAPE '!'", escaped+"%")
+	}
+	if filter.MinSize > 0 {
+		query = query.Where("src_size >= ?", filter.MinSize)
+	}
+	if !filter.UpdatedBefore.IsZero() {
+		query = query.Where("updated_at < ?", filter.UpdatedBefore)
+	}
+	var tasks []*Task
+	if err := query.Offset(offset).Limit(limit).Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// UploadedObject is the object of an Uploaded task as it was when the task was marked Uploaded
+type UploadedObject struct {
+	VirtualPath string
+	ETag        string `gorm:"column:etag"`
+	ObjectSize  int64
+}
+
+// ListUploadedObjects returns the objects of the Uploaded tasks whose ETag is known, by virtual path
+func (db *DB) ListUploadedObjects() (map[string]UploadedObject, error) {
+	var rows []UploadedObject
+	if err := db.getConnection().Model(&Task{}).Where("status = ? AND etag <> ''", Uploaded).
+		Select("virtual_path", "etag", "object_size").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	objects := make(map[string]UploadedObject, len(rows))
+	for _, row := range rows {
+		objects[row.VirtualPath] = row
+	}
+	return objects, nil
+}
+
 func (db *DB) ListFinishedVirtualPaths() ([]string, error) {
 	var paths []string
 	if err := db.getConnection().Model(&Task{}).Where("status = ?", Downloaded).Pluck("virtual_path", &paths).Error; err != nil {
@@ -82,6 +411,84 @@ func (db *DB) ListFinishedVirtualPaths() ([]string, error) {
 	return paths, nil
 }
 
+// ListTaskPriorities returns the priority of every task that has a non-zero priority, keyed by virtual path.
+func (db *DB) ListTaskPriorities() (map[string]int, error) {
+	var rows []struct {
+		VirtualPath string
+		Priority    int
+	}
+	if err := db.getConnection().Model(&Task{}).Where("priority <> 0").Select("virtual_path, priority").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	priorities := make(map[string]int, len(rows))
+	for _, row := range rows {
+		priorities[row.VirtualPath] = row.Priority
+	}
+	return priorities, nil
+}
+
+// inFlightStatuses are the statuses of the tasks whose files send or recv may be transferring
+var inFlightStatuses = []Status{Uploading, Uploaded, Downloading}
+
+// UpdateProgress records the bytes transferred so far of the files in flight, by virtual path. Only tasks
+// that are uploading or being downloaded are written, without an event, a new version or a journal, so the
+// status updates of send and recv never conflict with it.
+func (db *DB) UpdateProgress(progress map[string]int64) error {
+	return db.updateProgressColumn("transferred_bytes", progress)
+}
+
+// UpdatePlacement records the bytes placed so far of the downloaded files recv is moving into their
+// destination, by virtual path, like UpdateProgress.
+func (db *DB) UpdatePlacement(placed map[string]int64) error {
+	return db.updateProgressColumn("placed_bytes", placed)
+}
+
+func (db *DB) updateProgressColumn(column string, progress map[string]int64) error {
+	for _, virtualPath := range slices.Sorted(maps.Keys(progress)) {
+		if err := db.getConnection().Model(&Task{}).
+			Where("virtual_path = ? AND status IN ?", virtualPath, inFlightStatuses).
+			UpdateColumn(column, progress[virtualPath]).Error; err != nil {
+			return fmt.Errorf("failed to record the progress of %s: %w", virtualPath, err)
+		}
+	}
+	return nil
+}
+
+// ListFailedTasks returns the tasks marked Failed or carrying a recorded error.
+func (db *DB) ListFailedTasks() ([]*Task, error) {
+	var tasks []*Task
+	if err := db.getConnection().Where("status = ? OR (error IS NOT NULL AND error <> '')", Failed).Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// ListRecentErrors returns up to limit tasks carrying a recorded error, the most recently updated first.
+func (db *DB) ListRecentErrors(limit int) ([]*Task, error) {
+	var tasks []*Task
+	if err := db.getConnection().Where("error IS NOT NULL AND error <> ''").
+		Order("updated_at DESC").Limit(limit).Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// maxBoundParams keeps IN (...) lists below the D1 limit of 100 bound parameters per query.
+const maxBoundParams = 90
+
+// ResetTasks sets the status of the given tasks and clears their recorded errors.
+func (db *DB) ResetTasks(virtualPaths []string, status Status) error {
+	for start := 0; start < len(virtualPaths); start += maxBoundParams {
+		end := min(start+maxBoundParams, len(virtualPaths))
+		if err := db.getConnection().Model(&Task{}).
+			Where("virtual_path IN ?", virtualPaths[start:end]).
+			Updates(map[string]any{"status": status, "error": ""}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (db *DB) CountTasks() (int64, error) {
 	var count int64
 	if err := db.getConnection().Model(&Task{}).Count(&count).Error; err != nil {
@@ -90,6 +497,18 @@ func (db *DB) CountTasks() (int64, error) {
 	return count, nil
 }
 
+// DownloadedSince returns the number and total size of the tasks marked Downloaded after since
+func (db *DB) DownloadedSince(since time.Time) (*StatusSummary, error) {
+	var summary StatusSummary
+	if err := db.getConnection().Model(&Task{}).
+		Where("status = ? AND downloaded_at >= ?", Downloaded, since).
+		Select("COUNT(*) as count, COALESCE(SUM(src_size), 0) as size").
+		Scan(&summary).Error; err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
 // StatusSummary represents statistics for tasks by status
 type StatusSummary struct {
 	Count int64
@@ -108,13 +527,12 @@ func (db *DB) GetTasksByStatus(status Status) (*StatusSummary, error) {
 	return &summary, nil
 }
 
-// NewDB creates a new DB instance with the given gorm.DB connection
+// NewDB creates a new DB instance with the given gorm.DB connection.
+// The schema of the database must be up to date, see Migrate.
 func NewDB(conn *gorm.DB) *DB {
 	if conn == nil {
 		panic("gorm.DB connection cannot be nil")
 	}
-	if err := conn.AutoMigrate(&Task{}); err != nil {
-		panic("failed to auto migrate Task model: " + err.Error())
-	}
-	return &DB{conn: conn}
+	host, _ := os.Hostname()
+	return &DB{conn: conn, host: host}
 }
@@ -1,7 +1,15 @@
 package db
 
 import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
 func SetupDBInstance(t *testing.T) *DB {
@@ -12,18 +20,28 @@ func SetupDBInstance(t *testing.T) *DB {
 
 	// For SQLite, we don't need to drop tables as we use in-memory database
 	// For D1, we drop the test table if it exists
-	if err := db.Exec("DROP TABLE IF EXISTS tasks").Error; err != nil {
-		// Ignore error for SQLite in-memory database
-		t.Logf("Note: Could not drop test table (this is normal for in-memory databases): %v", err)
+	for _, table := range []string{"tasks", "task_events", "task_destinations", "schema_migrations"} {
+		if err := db.Exec("DROP TABLE IF EXISTS " + table).Error; err != nil {
+			// Ignore error for SQLite in-memory database
+			t.Logf("Note: Could not drop test table (this is normal for in-memory databases): %v", err)
+		}
 	}
 
-	dbInstance := NewDB(db)
+	dbInstance := newMigratedDB(t, db)
 	if dbInstance.getConnection() == nil {
 		t.Fatal("Expected DB connection to be non-nil")
 	}
 	return dbInstance
 }
 
+// newMigratedDB migrates the database of gdb and returns a DB on it
+func newMigratedDB(t *testing.T, gdb *gorm.DB) *DB {
+	if _, err := Migrate(gdb); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	return NewDB(gdb)
+}
+
 func TestCreateTask(t *testing.T) {
 	dbInstance := SetupDBInstance(t)
 
@@ -157,3 +175,577 @@ func TestDeleteTask(t *testing.T) {
 		t.Fatal("Expected error when getting deleted task, but got none")
 	}
 }
+
+func TestUpdateTaskKeepsPriority(t *testing.T) {
+	dbInstance := SetupDBInstance(t)
+
+	task := &Task{
+		VirtualPath: "/test/priority.txt",
+		SrcPath:     "/source/priority.txt",
+		Status:      Pending,
+		Priority:    5,
+	}
+	if err := dbInstance.CreateTask(task); err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+
+	// An upsert coming from send or recv does not know about priorities
+	if err := dbInstance.UpdateTask(&Task{
+		VirtualPath: task.VirtualPath,
+		SrcPath:     task.SrcPath,
+		Status:      Uploading,
+	}); err != nil {
+		t.Fatalf("Failed to update task: %v", err)
+	}
+
+	priorities, err := dbInstance.ListTaskPriorities()
+	if err != nil {
+		t.Fatalf("Failed to list task priorities: %v", err)
+	}
+	if priorities[task.VirtualPath] != 5 {
+		t.Errorf("Expected priority 5, got %d", priorities[task.VirtualPath])
+	}
+
+	updatedTask, err := dbInstance.GetTask(task.VirtualPath)
+	if err != nil {
+		t.Fatalf("Failed to get updated task: %v", err)
+	}
+	if updatedTask.Status != Uploading {
+		t.Errorf("Expected Status %d, got %d", Uploading, updatedTask.Status)
+	}
+
+	// Clean up
+	_ = dbInstance.DeleteTask(task.VirtualPath)
+}
+
+func TestListFailedAndResetTasks(t *testing.T) {
+	dbInstance := SetupDBInstance(t)
+
+	tasks := []*Task{
+		{VirtualPath: "/test/failed.txt", SrcPath: "/source/failed.txt", Status: Failed},
+		{VirtualPath: "/test/errored.txt", SrcPath: "/source/errored.txt", Status: Uploaded, Error: "digest mismatch"},
+		{VirtualPath: "/test/ok.txt", SrcPath: "/source/ok.txt", Status: Uploaded},
+	}
+	for _, task := range tasks {
+		if err := dbInstance.CreateTask(task); err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+	}
+
+	failed, err := dbInstance.ListFailedTasks()
+	if err != nil {
+		t.Fatalf("Failed to list failed tasks: %v", err)
+	}
+	if len(failed) != 2 {
+		t.Fatalf("Expected 2 failed tasks, got %d", len(failed))
+	}
+
+	var paths []string
+	for _, task := range failed {
+		paths = append(paths, task.VirtualPath)
+	}
+	if err := dbInstance.ResetTasks(paths, Pending); err != nil {
+		t.Fatalf("Failed to reset tasks: %v", err)
+	}
+
+	failed, err = dbInstance.ListFailedTasks()
+	if err != nil {
+		t.Fatalf("Failed to list failed tasks: %v", err)
+	}
+	if len(failed) != 0 {
+		t.Errorf("Expected no failed tasks after reset, got %d", len(failed))
+	}
+	resetTask, err := dbInstance.GetTask("/test/errored.txt")
+	if err != nil {
+		t.Fatalf("Failed to get task: %v", err)
+	}
+	if resetTask.Status != Pending || resetTask.Error != "" {
+		t.Errorf("Expected task to be Pending without error, got %s %q", resetTask.Status, resetTask.Error)
+	}
+
+	// Clean up
+	for _, task := range tasks {
+		_ = dbInstance.DeleteTask(task.VirtualPath)
+	}
+}
+
+func TestListTasksFiltered(t *testing.T) {
+	dbInstance := SetupDBInstance(t)
+
+	tasks := []*Task{
+		{VirtualPath: "sha1.tree_1.tch", SrcPath: "/source/sha1.tree_1.tch", SrcSize: 100, Status: Uploading},
+		{VirtualPath: "sha1.tree_0.tch", SrcPath: "/source/sha1.tree_0.tch", SrcSize: 10, Status: Uploading},
+		{VirtualPath: "sha1xtree_2.tch", SrcPath: "/source/sha1xtree_2.tch", SrcSize: 100, Status: Uploading},
+		{VirtualPath: "tree_0.tch", SrcPath: "/source/tree_0.tch", SrcSize: 100, Status: Downloaded},
+	}
+	for _, task := range tasks {
+		if err := dbInstance.CreateTask(task); err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+	}
+	defer func() {
+		for _, task := range tasks {
+			_ = dbInstance.DeleteTask(task.VirtualPath)
+		}
+	}()
+
+	paths := func(filter TaskFilter, offset, limit int) string {
+		found, err := dbInstance.ListTasksFiltered(filter, offset, limit)
+		if err != nil {
+			t.Fatalf("Failed to list tasks: %v", err)
+		}
+		var paths []string
+		for _, task := range found {
+			paths = append(paths, task.VirtualPath)
+		}
+		return strings.Join(paths, ",")
+	}
+
+	uploading := Uploading
+	if got := paths(TaskFilter{Status: &uploading, Prefix: "sha1."}, 0, 10); got != "sha1.tree_0.tch,sha1.tree_1.tch" {
+		t.Errorf("Unexpected tasks with prefix sha1.: %s", got)
+	}
+	if got := paths(TaskFilter{MinSize: 50}, 1, 2); got != "sha1xtree_2.tch,tree_0.tch" {
+		t.Errorf("Unexpected second page of large tasks: %s", got)
+	}
+	if got := paths(TaskFilter{Prefix: "sha1_"}, 0, 10); got != "" {
+		t.Errorf("Expected _ to be matched literally, got %s", got)
+	}
+}
+
+func TestListRecentErrors(t *testing.T) {
+	dbInstance := SetupDBInstance(t)
+
+	tasks := []*Task{
+		{VirtualPath: "/test/err_old.bin", SrcPath: "/source/err_old.bin", Status: Failed, Error: "connection reset"},
+		{VirtualPath: "/test/ok.bin", SrcPath: "/source/ok.bin", Status: Downloaded},
+		{VirtualPath: "/test/err_new.bin", SrcPath: "/source/err_new.bin", Status: Failed, Error: "digest mismatch"},
+	}
+	for i, task := range tasks {
+		if err := dbInstance.CreateTask(task); err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+		// the later tasks were updated more recently
+		updatedAt := time.Now().Add(time.Duration(i-len(tasks)) * time.Minute)
+		if err := dbInstance.getConnection().Model(task).UpdateColumn("updated_at", updatedAt).Error; err != nil {
+			t.Fatalf("Failed to set the update time: %v", err)
+		}
+	}
+	defer func() {
+		for _, task := range tasks {
+			_ = dbInstance.DeleteTask(task.VirtualPath)
+		}
+	}()
+
+	found, err := dbInstance.ListRecentErrors(10)
+	if err != nil {
+		t.Fatalf("Failed to list errors: %v", err)
+	}
+	if len(found) != 2 || found[0].VirtualPath != "/test/err_new.bin" || found[1].VirtualPath != "/test/err_old.bin" {
+		t.Errorf("Unexpected tasks with errors: %v", found)
+	}
+	if found, err := dbInstance.ListRecentErrors(1); err != nil || len(found) != 1 {
+		t.Errorf("Expected 1 task, got %v %v", found, err)
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	if s, err := ParseStatus("uploading"); err != nil || s != Uploading {
+		t.Errorf("Expected Uploading, got %v %v", s, err)
+	}
+	if _, err := ParseStatus("done"); err == nil {
+		t.Error("Expected an error for an unknown status")
+	}
+}
+
+func TestDownloadedSince(t *testing.T) {
+	dbInstance := SetupDBInstance(t)
+
+	start := time.Now().Add(-time.Second)
+	tasks := []*Task{
+		{VirtualPath: "/test/rate_1.bin", SrcPath: "/source/rate_1.bin", SrcSize: 100, Status: Downloaded},
+		{VirtualPath: "/test/rate_2.bin", SrcPath: "/source/rate_2.bin", SrcSize: 50, Status: Uploaded},
+	}
+	for _, task := range tasks {
+		if err := dbInstance.UpdateTask(task); err != nil {
+			t.Fatalf("Failed to update task: %v", err)
+		}
+	}
+	defer func() {
+		for _, task := range tasks {
+			_ = dbInstance.DeleteTask(task.VirtualPath)
+		}
+	}()
+
+	summary, err := dbInstance.DownloadedSince(start)
+	if err != nil {
+		t.Fatalf("Failed to sum downloaded tasks: %v", err)
+	}
+	if summary.Count != 1 || summary.Size != 100 {
+		t.Errorf("Expected 1 task of 100 bytes, got %+v", summary)
+	}
+
+	// the upload time is kept when the task is downloaded
+	uploaded, err := dbInstance.GetTask("/test/rate_2.bin")
+	if err != nil || uploaded.UploadedAt == nil {
+		t.Fatalf("Expected an upload time, got %v %v", uploaded, err)
+	}
+	if err := dbInstance.UpdateTask(&Task{VirtualPath: "/test/rate_2.bin", SrcPath: "/source/rate_2.bin", SrcSize: 50, Status: Downloaded}); err != nil {
+		t.Fatalf("Failed to update task: %v", err)
+	}
+	downloaded, err := dbInstance.GetTask("/test/rate_2.bin")
+	if err != nil || downloaded.UploadedAt == nil || downloaded.DownloadedAt == nil {
+		t.Errorf("Expected both transition times, got %+v %v", downloaded, err)
+	}
+
+	summary, err = dbInstance.DownloadedSince(time.Now().Add(time.Hour))
+	if err != nil || summary.Count != 0 {
+		t.Errorf("Expected no tasks downloaded in the future, got %+v %v", summary, err)
+	}
+}
+
+func TestListTaskEvents(t *testing.T) {
+	dbInstance := SetupDBInstance(t)
+
+	path := "/test/events.bin"
+	defer func() { _ = dbInstance.DeleteTask(path) }()
+	started, ended := time.Now().Add(-2*time.Second), time.Now()
+	for _, task := range []*Task{
+		{VirtualPath: path, SrcPath: "/source/events.bin", SrcSize: 100, Status: Uploading},
+		{VirtualPath: path, SrcPath: "/source/events.bin", SrcSize: 100, Status: Uploaded, TransferStartedAt: &started, TransferEndedAt: &ended},
+		{VirtualPath: path, SrcPath: "/source/events.bin", SrcSize: 100, Status: Failed, Error: "digest mismatch"},
+		{VirtualPath: path, SrcPath: "/source/events.bin", SrcSize: 100, Status: Uploading},
+	} {
+		if err := dbInstance.UpdateTask(task); err != nil {
+			t.Fatalf("Failed to update task: %v", err)
+		}
+	}
+
+	task, events, err := dbInstance.ListTaskEvents(path)
+	if err != nil {
+		t.Fatalf("Failed to list task events: %v", err)
+	}
+	if task.Attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", task.Attempts)
+	}
+	if len(events) != 4 {
+		t.Fatalf("Expected 4 events, got %d", len(events))
+	}
+	if events[0].OldStatus != nil || events[0].NewStatus != Uploading || events[0].Rate() != 0 {
+		t.Errorf("Expected the first event to create the task, got %+v", events[0])
+	}
+	if rate := events[1].Rate(); rate < 45 || rate > 55 || events[1].Host == "" {
+		t.Errorf("Expected the upload at about 50 B/s by this host, got %v from %q", rate, events[1].Host)
+	}
+	if events[2].OldStatus == nil || *events[2].OldStatus != Uploaded || events[2].NewStatus != Failed ||
+		events[2].Error != "digest mismatch" || events[2].Bytes != 100 {
+		t.Errorf("Unexpected failure event %+v", events[2])
+	}
+	for _, event := range events {
+		if event.TaskID != task.ID {
+			t.Errorf("Expected task id %d, got %d", task.ID, event.TaskID)
+		}
+	}
+
+	if _, _, err := dbInstance.ListTaskEvents("/test/missing.bin"); err == nil {
+		t.Error("Expected an error for a missing task")
+	}
+}
+
+func TestUpdateTasks(t *testing.T) {
+	dbInstance := SetupDBInstance(t)
+
+	// more tasks than fit in one statement, of two statuses
+	var tasks []*Task
+	for i := range 12 {
+		status := Uploading
+		if i%3 == 0 {
+			status = Pending
+		}
+		tasks = append(tasks, &Task{
+			VirtualPath: fmt.Sprintf("/test/batch_%d.bin", i),
+			SrcPath:     fmt.Sprintf("/source/batch_%d.bin", i),
+			SrcSize:     int64(i),
+			Status:      status,
+		})
+	}
+	defer func() {
+		for _, task := range tasks {
+			_ = dbInstance.DeleteTask(task.VirtualPath)
+		}
+	}()
+	if err := dbInstance.UpdateTasks(tasks); err != nil {
+		t.Fatalf("Failed to update tasks: %v", err)
+	}
+	for _, task := range tasks {
+		task.Status = Uploaded
+	}
+	if err := dbInstance.UpdateTasks(tasks); err != nil {
+		t.Fatalf("Failed to update tasks: %v", err)
+	}
+
+	count, err := dbInstance.CountTasks()
+	if err != nil || count != 12 {
+		t.Fatalf("Expected 12 tasks, got %d %v", count, err)
+	}
+	for i, task := range tasks {
+		got, events, err := dbInstance.ListTaskEvents(task.VirtualPath)
+		if err != nil {
+			t.Fatalf("Failed to list task events: %v", err)
+		}
+		wantAttempts := 1
+		if i%3 == 0 {
+			wantAttempts = 0
+		}
+		if got.Status != Uploaded || got.UploadedAt == nil || got.Attempts != wantAttempts || got.SrcSize != int64(i) {
+			t.Errorf("Unexpected task %+v", got)
+		}
+		if len(events) != 2 || events[1].OldStatus == nil || events[1].TaskID != got.ID {
+			t.Errorf("Expected 2 events of task %d, got %+v", got.ID, events)
+		}
+	}
+}
+
+// legacyTask is the tasks table before run ids, with the virtual path unique by itself
+type legacyTask struct {
+	gorm.Model
+	VirtualPath string `gorm:"uniqueIndex;not null"`
+	SrcPath     string `gorm:"not null"`
+	SrcSize     int64  `gorm:"not null"`
+	DstPath     string `gorm:"not null"`
+	DstSize     int64  `gorm:"not null"`
+	Status      Status `gorm:"not null"`
+}
+
+func (legacyTask) TableName() string { return "tasks" }
+
+func TestSetRun(t *testing.T) {
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer CloseDB(gdb)
+	if err := gdb.AutoMigrate(&legacyTask{}); err != nil {
+		t.Fatalf("Failed to create legacy table: %v", err)
+	}
+	if err := gdb.Create(&legacyTask{VirtualPath: "/test/run.bin", Status: Downloaded}).Error; err != nil {
+		t.Fatalf("Failed to create legacy task: %v", err)
+	}
+
+	previous := newMigratedDB(t, gdb)
+	current := newMigratedDB(t, gdb)
+	current.SetRun("2025q1")
+	// the tasks from before run ids belong to the default run
+	if paths, err := previous.ListFinishedVirtualPaths(); err != nil || len(paths) != 1 {
+		t.Fatalf("Expected the legacy task in the default run, got %v %v", paths, err)
+	}
+	if err := current.UpdateTask(&Task{VirtualPath: "/test/run.bin", SrcPath: "/source/run.bin", Status: Uploaded}); err != nil {
+		t.Fatalf("Failed to update task of another run: %v", err)
+	}
+
+	if paths, err := current.ListFinishedVirtualPaths(); err != nil || len(paths) != 0 {
+		t.Errorf("Expected no finished tasks in the new run, got %v %v", paths, err)
+	}
+	old, err := previous.GetTask("/test/run.bin")
+	if err != nil || old.Status != Downloaded {
+		t.Errorf("Expected the task of the default run to be kept, got %+v %v", old, err)
+	}
+	task, err := current.GetTask("/test/run.bin")
+	if err != nil || task.Status != Uploaded || task.RunID != "2025q1" {
+		t.Errorf("Expected the task of the new run, got %+v %v", task, err)
+	}
+	if count, err := current.CountTasks(); err != nil || count != 1 {
+		t.Errorf("Expected 1 task in the new run, got %d %v", count, err)
+	}
+}
+
+func TestUpdateTasks_Transitions(t *testing.T) {
+	dbInstance := SetupDBInstance(t)
+
+	task := func(path string, status Status) *Task {
+		return &Task{VirtualPath: path, SrcPath: "/source" + path, SrcSize: 10, Status: status}
+	}
+	paths := []string{"/test/state_0.bin", "/test/state_1.bin"}
+	defer func() {
+		for _, path := range paths {
+			_ = dbInstance.DeleteTask(path)
+		}
+	}()
+	if err := dbInstance.UpdateTasks([]*Task{task(paths[0], Downloaded), task(paths[1], Uploaded)}); err != nil {
+		t.Fatalf("Failed to update tasks: %v", err)
+	}
+
+	// a stale send doesn't regress the downloaded task, the other one is written
+	err := dbInstance.UpdateTasks([]*Task{task(paths[0], Uploading), task(paths[1], Uploading)})
+	var rejected *RejectedError
+	if !errors.As(err, &rejected) || len(rejected.Tasks) != 1 || !strings.Contains(rejected.Tasks[paths[0]], "Downloaded to Uploading") {
+		t.Fatalf("Expected the downloaded task to be rejected, got %v", err)
+	}
+	if got, err := dbInstance.GetTask(paths[0]); err != nil || got.Status != Downloaded || got.Version != 1 {
+		t.Errorf("Expected the task to stay Downloaded, got %+v %v", got, err)
+	}
+	if got, err := dbInstance.GetTask(paths[1]); err != nil || got.Status != Uploading || got.Version != 2 {
+		t.Errorf("Expected the task to be Uploading, got %+v %v", got, err)
+	}
+	if !CanTransition(Failed, Pending) || CanTransition(Uploaded, Pending) {
+		t.Error("Unexpected transitions")
+	}
+}
+
+func TestUpdateTask_ConcurrentWriter(t *testing.T) {
+	gdb, err := ConnectSQLite(filepath.Join(t.TempDir(), "tasks.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer CloseDB(gdb)
+	dbInstance := newMigratedDB(t, gdb)
+
+	path := "/test/race.bin"
+	if err := dbInstance.UpdateTask(&Task{VirtualPath: path, SrcPath: "/source/race.bin", Status: Uploading}); err != nil {
+		t.Fatalf("Failed to update task: %v", err)
+	}
+	// recv marks the task Downloaded between the read and the write of a stale send
+	armed := true
+	if err := gdb.Callback().Create().Before("gorm:create").Register("test:race", func(tx *gorm.DB) {
+		if armed && tx.Statement.Table == "tasks" {
+			armed = false
+			if err := gdb.Exec("UPDATE tasks SET status = ?, version = version + 1 WHERE virtual_path = ?", Downloaded, path).Error; err != nil {
+				t.Errorf("Failed to update task concurrently: %v", err)
+			}
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	err = dbInstance.UpdateTask(&Task{VirtualPath: path, SrcPath: "/source/race.bin", Status: Uploaded})
+	var rejected *RejectedError
+	if !errors.As(err, &rejected) {
+		t.Fatalf("Expected the stale update to be rejected, got %v", err)
+	}
+	got, err := dbInstance.GetTask(path)
+	if err != nil || got.Status != Downloaded || got.Version != 2 {
+		t.Errorf("Expected the concurrent update to win, got %+v %v", got, err)
+	}
+}
+
+func TestUpdateTasks_PartialBatch(t *testing.T) {
+	gdb, err := ConnectSQLite(filepath.Join(t.TempDir(), "tasks.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer CloseDB(gdb)
+	dbInstance := newMigratedDB(t, gdb)
+
+	task := func(path string, status Status) *Task {
+		return &Task{VirtualPath: path, SrcPath: "/source" + path, SrcSize: 10, Status: status}
+	}
+	paths := []string{"/test/batch_0.bin", "/test/batch_1.bin"}
+	if err := dbInstance.UpdateTasks([]*Task{task(paths[0], Pending), task(paths[1], Pending)}); err != nil {
+		t.Fatalf("Failed to update tasks: %v", err)
+	}
+	// another host updates the second task between the read and the write of the batch
+	armed := true
+	if err := gdb.Callback().Create().Before("gorm:create").Register("test:partial", func(tx *gorm.DB) {
+		if armed && tx.Statement.Table == "tasks" {
+			armed = false
+			if err := gdb.Exec("UPDATE tasks SET version = version + 1 WHERE virtual_path = ?", paths[1]).Error; err != nil {
+				t.Errorf("Failed to update task concurrently: %v", err)
+			}
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dbInstance.UpdateTasks([]*Task{task(paths[0], Uploading), task(paths[1], Uploading)}); err != nil {
+		t.Fatalf("Failed to update tasks: %v", err)
+	}
+	// the row the batch wrote isn't written again, the swapped one is retried
+	for path, version := range map[string]int{paths[0]: 2, paths[1]: 3} {
+		got, events, err := dbInstance.ListTaskEvents(path)
+		if err != nil {
+			t.Fatalf("Failed to list events: %v", err)
+		}
+		if got.Status != Uploading || got.Attempts != 1 || got.Version != version {
+			t.Errorf("Expected %s to be Uploading once at version %d, got %+v", path, version, got)
+		}
+		if len(events) != 2 || events[1].OldStatus == nil || *events[1].OldStatus != Pending || events[1].NewStatus != Uploading {
+			t.Errorf("Expected the Pending to Uploading event of %s, got %+v", path, events)
+		}
+	}
+}
+
+func TestListUploadedObjects(t *testing.T) {
+	dbInstance := SetupDBInstance(t)
+	defer func() {
+		_ = dbInstance.DeleteTask("/test/etag_0.bin")
+		_ = dbInstance.DeleteTask("/test/etag_1.bin")
+	}()
+
+	updates := []*Task{
+		{VirtualPath: "/test/etag_0.bin", SrcPath: "/source/etag_0.bin", Status: Uploading},
+		{VirtualPath: "/test/etag_0.bin", SrcPath: "/source/etag_0.bin", Status: Uploaded, ETag: "aaaa", ObjectSize: 10},
+		{VirtualPath: "/test/etag_1.bin", SrcPath: "/source/etag_1.bin", Status: Uploaded, ETag: "bbbb", ObjectSize: 10},
+		{VirtualPath: "/test/etag_1.bin", SrcPath: "/source/etag_1.bin", Status: Downloaded},
+	}
+	for _, task := range updates {
+		if err := dbInstance.UpdateTask(task); err != nil {
+			t.Fatalf("Failed to update task: %v", err)
+		}
+	}
+
+	objects, err := dbInstance.ListUploadedObjects()
+	if err != nil {
+		t.Fatalf("Failed to list uploaded objects: %v", err)
+	}
+	if len(objects) != 1 || objects["/test/etag_0.bin"] != (UploadedObject{VirtualPath: "/test/etag_0.bin", ETag: "aaaa", ObjectSize: 10}) {
+		t.Errorf("Expected only the object of the Uploaded task, got %+v", objects)
+	}
+	// the ETag of the upload is kept when the task is downloaded
+	if task, err := dbInstance.GetTask("/test/etag_1.bin"); err != nil || task.ETag != "bbbb" || task.ObjectSize != 10 {
+		t.Errorf("Expected the ETag to be kept, got %+v %v", task, err)
+	}
+}
+
+func TestUpdateProgress(t *testing.T) {
+	dbInstance := SetupDBInstance(t)
+	defer func() {
+		_ = dbInstance.DeleteTask("/test/progress_0.bin")
+		_ = dbInstance.DeleteTask("/test/progress_1.bin")
+	}()
+
+	for _, task := range []*Task{
+		{VirtualPath: "/test/progress_0.bin", SrcPath: "/source/progress_0.bin", SrcSize: 100, Status: Uploading},
+		{VirtualPath: "/test/progress_1.bin", SrcPath: "/source/progress_1.bin", SrcSize: 100, Status: Pending},
+	} {
+		if err := dbInstance.UpdateTask(task); err != nil {
+			t.Fatalf("Failed to update task: %v", err)
+		}
+	}
+	before, _ := dbInstance.GetTask("/test/progress_0.bin")
+
+	if err := dbInstance.UpdateProgress(map[string]int64{"/test/progress_0.bin": 40, "/test/progress_1.bin": 50, "/test/missing.bin": 1}); err != nil {
+		t.Fatalf("Failed to update progress: %v", err)
+	}
+	task, err := dbInstance.GetTask("/test/progress_0.bin")
+	if err != nil || task.TransferredBytes != 40 {
+		t.Fatalf("Expected 40 bytes transferred, got %+v %v", task, err)
+	}
+	if task.Version != before.Version {
+		t.Errorf("Expected the version to stay %d, got %d", before.Version, task.Version)
+	}
+	if task, _ := dbInstance.GetTask("/test/progress_1.bin"); task.TransferredBytes != 0 {
+		t.Errorf("Expected a Pending task to be skipped, got %d bytes", task.TransferredBytes)
+	}
+	_, events, err := dbInstance.ListTaskEvents("/test/progress_0.bin")
+	if err != nil || len(events) != 1 {
+		t.Errorf("Expected no event for the progress, got %d %v", len(events), err)
+	}
+
+	// the next status resets the progress
+	if err := dbInstance.UpdateTask(&Task{VirtualPath: "/test/progress_0.bin", SrcPath: "/source/progress_0.bin", Status: Uploaded}); err != nil {
+		t.Fatalf("Failed to update task: %v", err)
+	}
+	if task, _ := dbInstance.GetTask("/test/progress_0.bin"); task.TransferredBytes != 0 {
+		t.Errorf("Expected the progress to be reset, got %d bytes", task.TransferredBytes)
+	}
+}
@@ -0,0 +1,34 @@
+package db
+
+import (
+	"gorm.io/gorm/clause"
+)
+
+// destinationColumnCount are the columns of a TaskDestination row, see maxBoundParams
+const destinationColumnCount = 9
+
+// UpdateDestinations records the uploads of tasks to the destinations of send, by run, virtual path and destination.
+// Unlike UpdateTasks there are no events and no versions, the last upload wins.
+func (db *DB) UpdateDestinations(destinations []*TaskDestination) error {
+	if len(destinations) == 0 {
+		return nil
+	}
+	for _, d := range destinations {
+		d.RunID = db.runID
+	}
+	upsert := clause.OnConflict{
+		Columns:   []clause.Column{{Name: "run_id"}, {Name: "virtual_path"}, {Name: "destination"}},
+		DoUpdates: clause.AssignmentColumns([]string{"updated_at", "status", "error", "etag", "object_size"}),
+	}
+	return db.conn.Clauses(upsert).CreateInBatches(destinations, maxBoundParams/destinationColumnCount).Error
+}
+
+// ListTaskDestinations returns the uploads of a task to the destinations of send, by destination
+func (db *DB) ListTaskDestinations(virtualPath string) ([]*TaskDestination, error) {
+	var destinations []*TaskDestination
+	if err := db.conn.Where("run_id = ? AND virtual_path = ?", db.runID, virtualPath).
+		Order("destination").Find(&destinations).Error; err != nil {
+		return nil, err
+	}
+	return destinations, nil
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"testing"
+)
+
+func TestUpdateDestinations(t *testing.T) {
+	dbInstance := SetupDBInstance(t)
+
+	err := dbInstance.UpdateDestinations([]*TaskDestination{
+		{VirtualPath: "/test/mirror.bin", Destination: "primary", Status: Uploaded, ETag: "abc", ObjectSize: 10},
+		{VirtualPath: "/test/mirror.bin", Destination: "backup", Status: Uploading, Error: "connection reset"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to update destinations: %v", err)
+	}
+	// the retry of the mirror replaces its row
+	err = dbInstance.UpdateDestinations([]*TaskDestination{
+		{VirtualPath: "/test/mirror.bin", Destination: "backup", Status: Uploaded, ETag: "abc", ObjectSize: 10},
+	})
+	if err != nil {
+		t.Fatalf("Failed to update destinations: %v", err)
+	}
+	if err := dbInstance.UpdateDestinations(nil); err != nil {
+		t.Errorf("Expected no error without destinations, got %v", err)
+	}
+
+	destinations, err := dbInstance.ListTaskDestinations("/test/mirror.bin")
+	if err != nil {
+		t.Fatalf("Failed to list destinations: %v", err)
+	}
+	if len(destinations) != 2 {
+		t.Fatalf("Expected 2 destinations, got %d", len(destinations))
+	}
+	backup := destinations[0]
+	if backup.Destination != "backup" || backup.Status != Uploaded || backup.Error != "" || backup.ETag != "abc" {
+		t.Errorf("Expected the retried upload to the backup, got %+v", backup)
+	}
+	if destinations[1].Destination != "primary" || destinations[1].ObjectSize != 10 {
+		t.Errorf("Expected the upload to the primary, got %+v", destinations[1])
+	}
+
+	// the destinations are scoped to the run like the tasks
+	dbInstance.SetRun("other")
+	defer dbInstance.SetRun("")
+	if destinations, err := dbInstance.ListTaskDestinations("/test/mirror.bin"); err != nil || len(destinations) != 0 {
+		t.Errorf("Expected no destinations in another run, got %d %v", len(destinations), err)
+	}
+}
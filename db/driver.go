@@ -0,0 +1,172 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Database drivers of Config
+const (
+	DriverD1       = "d1"
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+	DriverMySQL    = "mysql"
+)
+
+// Config is the "database" section of config.json, Cloudflare D1 if it is missing
+type Config struct {
+	Driver string `json:"driver"`
+	// Path of the SQLite database file
+	Path string `json:"path,omitempty"`
+	// DSN is the connection string of PostgreSQL and MySQL, e.g.
+	// "host=db user=syncmate dbname=syncmate" or "syncmate:secret@tcp(db:3306)/syncmate?parseTime=true"
+	DSN string `json:"dsn,omitempty"`
+	// Journal is the file that queues task updates while the database can't be reached, see DB.SetJournal
+	Journal string `json:"journal,omitempty"`
+	// ManualMigrations stops commands from migrating the schema on connect, it is left to syncmate db migrate
+	ManualMigrations bool `json:"manual_migrations,omitempty"`
+}
+
+// Driver opens the database of a Config. The D1 credentials come from the top level of config.json.
+type Driver struct {
+	// Validate checks the options of the driver in the config, optional
+	Validate func(cfg *Config) error
+	Open     func(cfg *Config, creds CloudflareD1Credentials) (*gorm.DB, error)
+}
+
+var drivers = map[string]Driver{
+	DriverD1: {
+		Open: func(cfg *Config, creds CloudflareD1Credentials) (*gorm.DB, error) {
+			return ConnectDB(creds)
+		},
+	},
+	DriverSQLite: {
+		Validate: func(cfg *Config) error {
+			if cfg.Path == "" {
+				return fmt.Errorf("path is required for the %s driver", DriverSQLite)
+			}
+			return nil
+		},
+		Open: func(cfg *Config, creds CloudflareD1Credentials) (*gorm.DB, error) {
+			return ConnectSQLite(cfg.Path)
+		},
+	},
+	DriverPostgres: {
+		Validate: requireDSN(DriverPostgres),
+		Open: func(cfg *Config, creds CloudflareD1Credentials) (*gorm.DB, error) {
+			return openDialector(postgres.Open(cfg.DSN), DriverPostgres)
+		},
+	},
+	DriverMySQL: {
+		Validate: requireDSN(DriverMySQL),
+		Open: func(cfg *Config, creds CloudflareD1Credentials) (*gorm.DB, error) {
+			return openDialector(mysql.Open(cfg.DSN), DriverMySQL)
+		},
+	},
+}
+
+// RegisterDriver makes a driver available to the "driver" of Config
+func RegisterDriver(name string, driver Driver) {
+	drivers[name] = driver
+}
+
+func requireDSN(name string) func(cfg *Config) error {
+	return func(cfg *Config) error {
+		if cfg.DSN == "" {
+			return fmt.Errorf("dsn is required for the %s driver", name)
+		}
+		return nil
+	}
+}
+
+// DriverName returns the name of the driver selected by the config, D1 if it is nil or has no driver
+func (c *Config) DriverName() string {
+	if c != nil && c.Driver != "" {
+		return c.Driver
+	}
+	return DriverD1
+}
+
+// driver returns the driver selected by the config
+func (c *Config) driver() (Driver, error) {
+	name := c.DriverName()
+	driver, ok := drivers[name]
+	if !ok {
+		names := make([]string, 0, len(drivers))
+		for n := range drivers {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return Driver{}, fmt.Errorf("unknown driver %q, must be one of %v", name, names)
+	}
+	return driver, nil
+}
+
+// Validate checks the driver and its options
+func (c *Config) Validate() error {
+	driver, err := c.driver()
+	if err != nil {
+		return err
+	}
+	if c != nil && driver.Validate != nil {
+		return driver.Validate(c)
+	}
+	return nil
+}
+
+// Open connects to the database selected by cfg
+func Open(cfg *Config, creds CloudflareD1Credentials) (*gorm.DB, error) {
+	driver, err := cfg.driver()
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	return driver.Open(cfg, creds)
+}
+
+func newLogger() logger.Interface {
+	return logger.New(
+		logrus.StandardLogger(), // the syncmate logger, in its format
+		logger.Config{
+			SlowThreshold:        time.Second, // Slow SQL threshold
+			LogLevel:             logger.Warn, // Log level
+			ParameterizedQueries: true,        // Don't include params in the SQL log
+			Colorful:             false,       // no escape codes in the log files
+		},
+	)
+}
+
+func openDialector(dialector gorm.Dialector, name string) (*gorm.DB, error) {
+	gdb, err := gorm.Open(dialector, &gorm.Config{
+		SkipDefaultTransaction: true,
+		Logger:                 newLogger(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s database: %w", name, err)
+	}
+	return gdb, nil
+}
+
+// ConnectSQLite opens the SQLite database at path, creating it if it doesn't exist
+func ConnectSQLite(path string) (*gorm.DB, error) {
+	// send and recv may share the file on one host, wait for each other's writes
+	dsn := fmt.Sprintf("file:%s?_busy_timeout=10000&_journal_mode=WAL", path)
+	gdb, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		SkipDefaultTransaction: true,
+		Logger:                 newLogger(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+	return gdb, nil
+}
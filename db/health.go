@@ -0,0 +1,51 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// healthTables are the tables whose rows Ping counts
+var healthTables = []string{"tasks", "task_events", "schema_migrations"}
+
+// Health is the state of a database as found by Ping
+type Health struct {
+	// RoundTrips are the durations of the trivial queries
+	RoundTrips    []time.Duration
+	SchemaVersion int
+	LatestVersion int
+	// Rows are the rows of the tables that exist, including deleted tasks, by table
+	Rows map[string]int64
+}
+
+// Ping runs n trivial queries against the database, then reads its schema version and counts the rows
+// of its tables. After an error, the health found so far is returned with it.
+func Ping(conn *gorm.DB, n int) (*Health, error) {
+	health := &Health{Rows: make(map[string]int64)}
+	for range max(n, 1) {
+		var one int
+		start := time.Now()
+		if err := conn.Raw("SELECT 1").Scan(&one).Error; err != nil {
+			return health, fmt.Errorf("query failed: %w", err)
+		}
+		health.RoundTrips = append(health.RoundTrips, time.Since(start))
+	}
+
+	var err error
+	if health.SchemaVersion, health.LatestVersion, err = SchemaVersion(conn); err != nil {
+		return health, fmt.Errorf("failed to read the schema version: %w", err)
+	}
+	for _, table := range healthTables {
+		if !conn.Migrator().HasTable(table) {
+			continue
+		}
+		var rows int64
+		if err := conn.Table(table).Count(&rows).Error; err != nil {
+			return health, fmt.Errorf("failed to count the rows of %s: %w", table, err)
+		}
+		health.Rows[table] = rows
+	}
+	return health, nil
+}
@@ -0,0 +1,26 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPing(t *testing.T) {
+	gdb, err := ConnectSQLite(filepath.Join(t.TempDir(), "tasks.db"))
+	if err != nil {
+		t.Fatalf("Failed to open SQLite database: %v", err)
+	}
+	defer CloseDB(gdb)
+
+	health, err := Ping(gdb, 3)
+	if err != nil || len(health.RoundTrips) != 3 || health.SchemaVersion != 0 || len(health.Rows) != 0 {
+		t.Fatalf("Expected 3 round trips to an empty database, got %+v %v", health, err)
+	}
+	if err := newMigratedDB(t, gdb).UpdateTask(&Task{VirtualPath: "/test/ping.bin", SrcPath: "/source/ping.bin", Status: Pending}); err != nil {
+		t.Fatalf("Failed to update task: %v", err)
+	}
+	health, err = Ping(gdb, 1)
+	if err != nil || health.SchemaVersion != health.LatestVersion || health.Rows["tasks"] != 1 || health.Rows["schema_migrations"] != int64(len(migrations)) {
+		t.Errorf("Expected a migrated database with 1 task, got %+v %v", health, err)
+	}
+}
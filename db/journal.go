@@ -0,0 +1,194 @@
+package db
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	logger "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// journal queues the task updates that failed to reach the database in an append-only
+// JSONL file, one line per UpdateTasks call, and replays them before the next update.
+type journal struct {
+	mu      sync.Mutex
+	path    string
+	pending bool
+}
+
+// SetJournal queues the updates of UpdateTask and UpdateTasks in the file at path while the
+// database can't be reached instead of returning their errors. Updates queued by an earlier
+// run are replayed by the next update or ReplayJournal.
+func (db *DB) SetJournal(path string) error {
+	info, err := os.Stat(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to open journal %s: %w", path, err)
+	}
+	db.journal = &journal{path: path, pending: err == nil && info.Size() > 0}
+	return nil
+}
+
+// ReplayJournal writes the queued updates to the database and returns how many tasks are still queued.
+func (db *DB) ReplayJournal() (int, error) {
+	if db.journal == nil {
+		return 0, nil
+	}
+	db.journal.mu.Lock()
+	defer db.journal.mu.Unlock()
+	err := db.journal.replay(db)
+	if err == nil {
+		return 0, nil
+	}
+	batches, readErr := db.journal.read()
+	if readErr != nil {
+		return 0, readErr
+	}
+	count := 0
+	for _, batch := range batches {
+		count += len(batch)
+	}
+	return count, err
+}
+
+// update writes tasks after the queued updates, or queues them too
+func (j *journal) update(db *DB, tasks []*Task) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.replay(db); err != nil {
+		logger.WithError(err).WithField("journal", j.path).Warn("Database unreachable, queueing task updates")
+		return j.append(tasks)
+	}
+	rest, err := db.writeTasks(tasks)
+	if len(rest) == 0 {
+		// nothing to queue, e.g. the rejected updates of a RejectedError
+		return err
+	}
+	logger.WithError(err).WithField("journal", j.path).Warn("Database unreachable, queueing task updates")
+	return j.append(rest)
+}
+
+func (j *journal) read() ([][]*Task, error) {
+	f, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open journal %s: %w", j.path, err)
+	}
+	defer f.Close()
+	var batches [][]*Task
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var batch []*Task
+		if err := json.Unmarshal(scanner.Bytes(), &batch); err != nil {
+			return nil, fmt.Errorf("failed to parse journal %s: %w", j.path, err)
+		}
+		batches = append(batches, batch)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read journal %s: %w", j.path, err)
+	}
+	return batches, nil
+}
+
+// journalLine encodes a batch of tasks without the row fields that writeTasks may have set
+func journalLine(tasks []*Task) ([]byte, error) {
+	queued := make([]Task, len(tasks))
+	for i, task := range tasks {
+		queued[i] = *task
+		queued[i].Model = gorm.Model{}
+	}
+	line, err := json.Marshal(queued)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+func (j *journal) append(tasks []*Task) error {
+	line, err := journalLine(tasks)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open journal %s: %w", j.path, err)
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write journal %s: %w", j.path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write journal %s: %w", j.path, err)
+	}
+	j.pending = true
+	return nil
+}
+
+// replay writes the queued updates in order. On an error the updates that were not written stay queued.
+func (j *journal) replay(db *DB) error {
+	if !j.pending {
+		return nil
+	}
+	batches, err := j.read()
+	if err != nil {
+		return err
+	}
+	for i, batch := range batches {
+		rest, err := db.writeTasks(batch)
+		if len(rest) == 0 {
+			if err != nil {
+				logger.WithError(err).WithField("journal", j.path).Warn("Dropped queued task updates")
+			}
+			continue
+		}
+		if i == 0 && len(rest) == len(batch) {
+			// nothing was written, keep the file as it is
+			return err
+		}
+		if rewriteErr := j.rewrite(append([][]*Task{rest}, batches[i+1:]...)); rewriteErr != nil {
+			return rewriteErr
+		}
+		return err
+	}
+	if err := os.Remove(j.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove journal %s: %w", j.path, err)
+	}
+	j.pending = false
+	logger.WithFields(logger.Fields{"journal": j.path, "batches": len(batches)}).Info("Replayed queued task updates")
+	return nil
+}
+
+// rewrite replaces the journal with batches
+func (j *journal) rewrite(batches [][]*Task) error {
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to rewrite journal %s: %w", j.path, err)
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	for _, batch := range batches {
+		line, err := journalLine(batch)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(line)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to rewrite journal %s: %w", j.path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to rewrite journal %s: %w", j.path, err)
+	}
+	if err := os.Rename(tmp.Name(), j.path); err != nil {
+		return fmt.Errorf("failed to rewrite journal %s: %w", j.path, err)
+	}
+	return nil
+}
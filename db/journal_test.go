@@ -0,0 +1,78 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestJournal(t *testing.T) {
+	gdb, err := SetupSQLiteDB()
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer CloseDB(gdb)
+	down := false
+	outage := func(tx *gorm.DB) {
+		if down {
+			tx.AddError(errors.New("database unreachable"))
+		}
+	}
+	if err := gdb.Callback().Create().Before("gorm:create").Register("test:outage", outage); err != nil {
+		t.Fatal(err)
+	}
+	if err := gdb.Callback().Query().Before("gorm:query").Register("test:outage", outage); err != nil {
+		t.Fatal(err)
+	}
+	dbInstance := newMigratedDB(t, gdb)
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	if err := dbInstance.SetJournal(path); err != nil {
+		t.Fatalf("Failed to set journal: %v", err)
+	}
+
+	task := func(status Status) *Task {
+		return &Task{VirtualPath: "/test/journal.bin", SrcPath: "/source/journal.bin", SrcSize: 100, Status: status}
+	}
+	if err := dbInstance.UpdateTask(task(Uploading)); err != nil {
+		t.Fatalf("Failed to update task: %v", err)
+	}
+
+	// updates during the outage are queued in order
+	down = true
+	if err := dbInstance.UpdateTask(task(Uploaded)); err != nil {
+		t.Fatalf("Expected the update to be queued, got %v", err)
+	}
+	if err := dbInstance.UpdateTask(task(Downloaded)); err != nil {
+		t.Fatalf("Expected the update to be queued, got %v", err)
+	}
+	if count, err := dbInstance.ReplayJournal(); err == nil || count != 2 {
+		t.Errorf("Expected 2 queued tasks and an error, got %d %v", count, err)
+	}
+
+	// a new handle picks the journal up, e.g. after a restart
+	next := NewDB(gdb)
+	if err := next.SetJournal(path); err != nil {
+		t.Fatalf("Failed to set journal: %v", err)
+	}
+	down = false
+	if count, err := next.ReplayJournal(); err != nil || count != 0 {
+		t.Fatalf("Expected the journal to be replayed, got %d %v", count, err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the replayed journal to be removed, got %v", err)
+	}
+
+	got, events, err := next.ListTaskEvents("/test/journal.bin")
+	if err != nil {
+		t.Fatalf("Failed to list task events: %v", err)
+	}
+	if got.Status != Downloaded || got.UploadedAt == nil || got.DownloadedAt == nil {
+		t.Errorf("Expected the task to be downloaded, got %+v", got)
+	}
+	if len(events) != 3 || events[1].NewStatus != Uploaded || events[2].NewStatus != Downloaded {
+		t.Errorf("Expected the queued events in order, got %+v", events)
+	}
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// AddTasks creates the tasks that are not in the database yet and leaves the existing ones alone
+func (db *DB) AddTasks(tasks []*Task) error {
+	for _, task := range tasks {
+		task.RunID = db.runID
+	}
+	chunkSize := maxBoundParams / taskColumnCount
+	for start := 0; start < len(tasks); start += chunkSize {
+		if err := db.getConnection().Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "run_id"}, {Name: "virtual_path"}},
+			DoNothing: true,
+		}).Create(tasks[start:min(start+chunkSize, len(tasks))]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimable selects the Pending and Uploading tasks that no other host holds a lease of
+const claimable = "status IN ? AND (owner_host = ? OR owner_host = '' OR owner_host IS NULL OR lease_expires_at IS NULL OR lease_expires_at < ?)"
+
+// ClaimTasks leases up to n of the tasks at virtualPaths to owner for ttl, preferring the first ones.
+// A task that is Pending or Uploading can be claimed if its lease is expired or held by owner already,
+// so the tasks of a host that died are taken over once their leases expire. Each claim is a
+// conditional update, hosts claiming at the same time get disjoint tasks. Returns the claimed virtual paths.
+func (db *DB) ClaimTasks(owner string, virtualPaths []string, n int, ttl time.Duration) ([]string, error) {
+	var claimed []string
+	// keep the conditions' parameters below the limit as well
+	chunkSize := maxBoundParams - 8
+	for start := 0; start < len(virtualPaths) && len(claimed) < n; {
+		end := min(start+chunkSize, start+n-len(claimed), len(virtualPaths))
+		chunk := virtualPaths[start:end]
+		start = end
+
+		now := time.Now()
+		expires := now.Add(ttl)
+		if err := db.getConnection().Model(&Task{}).
+			Where("virtual_path IN ?", chunk).
+			Where(claimable, []Status{Pending, Uploading}, owner, now).
+			Updates(map[string]any{"owner_host": owner, "lease_expires_at": expires}).Error; err != nil {
+			return claimed, err
+		}
+		var paths []string
+		if err := db.getConnection().Model(&Task{}).
+			Where("virtual_path IN ? AND owner_host = ? AND lease_expires_at > ?", chunk, owner, now).
+			Pluck("virtual_path", &paths).Error; err != nil {
+			return claimed, err
+		}
+		// in the order of virtualPaths
+		leased := make(map[string]bool, len(paths))
+		for _, path := range paths {
+			leased[path] = true
+		}
+		for _, path := range chunk {
+			if leased[path] {
+				claimed = append(claimed, path)
+			}
+		}
+	}
+	return claimed, nil
+}
+
+// RenewLeases extends the leases of owner on the tasks it hasn't uploaded yet to ttl from now
+func (db *DB) RenewLeases(owner string, ttl time.Duration) error {
+	return db.getConnection().Model(&Task{}).
+		Where("owner_host = ? AND status IN ?", owner, []Status{Pending, Uploading}).
+		Update("lease_expires_at", time.Now().Add(ttl)).Error
+}
+
+// ReleaseLeases gives up the leases of owner on the tasks it hasn't uploaded yet, so that the other
+// hosts can claim them right away instead of once they expire
+func (db *DB) ReleaseLeases(owner string) error {
+	return db.getConnection().Model(&Task{}).
+		Where("owner_host = ? AND status IN ?", owner, []Status{Pending, Uploading}).
+		Updates(map[string]any{"owner_host": "", "lease_expires_at": nil}).Error
+}
+
+// HostLeases are the tasks a host holds a lease of and hasn't uploaded yet
+type HostLeases struct {
+	Owner string
+	// Count and Size are the number and total source size of the leased tasks
+	Count int64
+	Size  int64
+	// TransferredBytes are the bytes of the uploads in progress, see Task.TransferredBytes
+	TransferredBytes int64
+	// ExpiresAt is the latest lease, which the host renews while it is uploading
+	ExpiresAt time.Time
+}
+
+// ListLeases returns the unexpired leases of the tasks not uploaded yet by host, ordered by host
+func (db *DB) ListLeases() ([]HostLeases, error) {
+	var tasks []Task
+	if err := db.getConnection().
+		Select("owner_host", "src_size", "transferred_bytes", "lease_expires_at").
+		Where("owner_host <> '' AND status IN ? AND lease_expires_at > ?", []Status{Pending, Uploading}, time.Now()).
+		Order("owner_host").
+		Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	var leases []HostLeases
+	for _, task := range tasks {
+		if len(leases) == 0 || leases[len(leases)-1].Owner != task.OwnerHost {
+			leases = append(leases, HostLeases{Owner: task.OwnerHost})
+		}
+		lease := &leases[len(leases)-1]
+		lease.Count++
+		lease.Size += task.SrcSize
+		lease.TransferredBytes += task.TransferredBytes
+		if task.LeaseExpiresAt.After(lease.ExpiresAt) {
+			lease.ExpiresAt = *task.LeaseExpiresAt
+		}
+	}
+	return leases, nil
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestClaimTasks(t *testing.T) {
+	dbInstance := SetupDBInstance(t)
+
+	var tasks []*Task
+	var paths []string
+	for i := range 6 {
+		path := fmt.Sprintf("/test/lease_%d.bin", i)
+		tasks = append(tasks, &Task{VirtualPath: path, SrcPath: "/source" + path, SrcSize: 10, Status: Pending})
+		paths = append(paths, path)
+	}
+	defer func() {
+		for _, path := range paths {
+			_ = dbInstance.DeleteTask(path)
+		}
+	}()
+	if err := dbInstance.AddTasks(tasks); err != nil {
+		t.Fatalf("Failed to add tasks: %v", err)
+	}
+	if err := dbInstance.UpdateTask(&Task{VirtualPath: paths[5], SrcPath: "/source" + paths[5], SrcSize: 10, Status: Uploaded}); err != nil {
+		t.Fatalf("Failed to update task: %v", err)
+	}
+	// existing tasks are left alone
+	if err := dbInstance.AddTasks(tasks[5:]); err != nil {
+		t.Fatalf("Failed to add tasks: %v", err)
+	}
+
+	da5, err := dbInstance.ClaimTasks("da5", paths, 3, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to claim tasks: %v", err)
+	}
+	if !slices.Equal(da5, paths[:3]) {
+		t.Errorf("Expected da5 to claim the first 3 tasks, got %v", da5)
+	}
+	da7, err := dbInstance.ClaimTasks("da7", paths, 10, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to claim tasks: %v", err)
+	}
+	if !slices.Equal(da7, paths[3:5]) {
+		t.Errorf("Expected da7 to claim the unleased, not uploaded tasks, got %v", da7)
+	}
+
+	// da5 died, its leases expire
+	if err := dbInstance.RenewLeases("da5", -time.Minute); err != nil {
+		t.Fatalf("Failed to renew leases: %v", err)
+	}
+	da8, err := dbInstance.ClaimTasks("da8", paths, 10, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to claim tasks: %v", err)
+	}
+	if !slices.Equal(da8, paths[:3]) {
+		t.Errorf("Expected da8 to take over the expired leases, got %v", da8)
+	}
+	task, err := dbInstance.GetTask(paths[0])
+	if err != nil || task.OwnerHost != "da8" || task.LeaseExpiresAt == nil || !task.LeaseExpiresAt.After(time.Now()) {
+		t.Errorf("Expected a lease of da8, got %+v %v", task, err)
+	}
+
+	leases, err := dbInstance.ListLeases()
+	if err != nil {
+		t.Fatalf("Failed to list leases: %v", err)
+	}
+	if len(leases) != 2 || leases[0].Owner != "da7" || leases[0].Count != 2 || leases[1].Owner != "da8" || leases[1].Size != 30 {
+		t.Errorf("Expected the leases of da7 and da8, got %+v", leases)
+	}
+
+	// da8 exits, its tasks can be claimed right away
+	if err := dbInstance.ReleaseLeases("da8"); err != nil {
+		t.Fatalf("Failed to release leases: %v", err)
+	}
+	da5, err = dbInstance.ClaimTasks("da5", paths, 10, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to claim tasks: %v", err)
+	}
+	if !slices.Equal(da5, paths[:3]) {
+		t.Errorf("Expected da5 to claim the released tasks, got %v", da5)
+	}
+	// da5 exits as well
+	if err := dbInstance.ReleaseLeases("da5"); err != nil {
+		t.Fatalf("Failed to release leases: %v", err)
+	}
+	leases, err = dbInstance.ListLeases()
+	if err != nil || len(leases) != 1 || leases[0].Owner != "da7" {
+		t.Errorf("Expected only the leases of da7, got %+v %v", leases, err)
+	}
+}
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Migration is a step of the database schema. Migrate applies the steps a database misses in
+// the order of their versions and records them in the schema_migrations table.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(conn *gorm.DB) error
+}
+
+// SchemaMigration is a row of schema_migrations, a migration applied to the database
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// migrations are the steps of the schema, in order. Steps are never changed once released,
+// a change of the models gets a new step. D1 can't run them in a transaction, so every step
+// must be safe to run again after a failure in the middle.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "drop the index of virtual paths, they are unique per run",
+		Up: func(conn *gorm.DB) error {
+			if m := conn.Migrator(); m.HasTable(&Task{}) && m.HasIndex(&Task{}, "idx_tasks_virtual_path") {
+				return m.DropIndex(&Task{}, "idx_tasks_virtual_path")
+			}
+			return nil
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create the tasks and task_events tables",
+		Up: func(conn *gorm.DB) error {
+			// also brings databases created before schema_migrations up to date
+			return conn.AutoMigrate(&Task{}, &TaskEvent{})
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add the bytes transferred of the tasks in flight",
+		Up: func(conn *gorm.DB) error {
+			if m := conn.Migrator(); !m.HasColumn(&Task{}, "TransferredBytes") {
+				return m.AddColumn(&Task{}, "TransferredBytes")
+			}
+			return nil
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add the bytes placed of the tasks being placed",
+		Up: func(conn *gorm.DB) error {
+			if m := conn.Migrator(); !m.HasColumn(&Task{}, "PlacedBytes") {
+				return m.AddColumn(&Task{}, "PlacedBytes")
+			}
+			return nil
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create the task_destinations table of the mirrors of send",
+		Up: func(conn *gorm.DB) error {
+			return conn.AutoMigrate(&TaskDestination{})
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add the digest algorithms of the tasks",
+		Up: func(conn *gorm.DB) error {
+			m := conn.Migrator()
+			for _, column := range []string{"SrcDigestAlgorithm", "DstDigestAlgorithm"} {
+				if !m.HasColumn(&Task{}, column) {
+					if err := m.AddColumn(&Task{}, column); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// AppliedMigrations returns the migrations recorded in the database, in order
+func AppliedMigrations(conn *gorm.DB) ([]SchemaMigration, error) {
+	if !conn.Migrator().HasTable(&SchemaMigration{}) {
+		return nil, nil
+	}
+	var applied []SchemaMigration
+	if err := conn.Order("version").Find(&applied).Error; err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// SchemaVersion returns the highest migration applied to the database and the highest there is
+func SchemaVersion(conn *gorm.DB) (applied, latest int, err error) {
+	recorded, err := AppliedMigrations(conn)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, m := range recorded {
+		applied = max(applied, m.Version)
+	}
+	for _, m := range migrations {
+		latest = max(latest, m.Version)
+	}
+	return applied, latest, nil
+}
+
+// PendingMigrations returns the migrations that Migrate would apply to the database
+func PendingMigrations(conn *gorm.DB) ([]Migration, error) {
+	applied, err := AppliedMigrations(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the applied migrations: %w", err)
+	}
+	done := make(map[int]bool, len(applied))
+	for _, m := range applied {
+		done[m.Version] = true
+	}
+	var pending []Migration
+	for _, m := range migrations {
+		if !done[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies the pending migrations to the database and returns them. After a failed
+// step, the steps before it stay applied.
+func Migrate(conn *gorm.DB) ([]Migration, error) {
+	pending, err := PendingMigrations(conn)
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+	if err := conn.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	for i, m := range pending {
+		if err := m.Up(conn); err != nil {
+			return pending[:i], fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		// another host starting against the database may have applied and recorded it meanwhile
+		if err := conn.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error; err != nil {
+			return pending[:i], fmt.Errorf("failed to record migration %d: %w", m.Version, err)
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestMigrate(t *testing.T) {
+	gdb, err := ConnectSQLite(filepath.Join(t.TempDir(), "tasks.db"))
+	if err != nil {
+		t.Fatalf("Failed to open SQLite database: %v", err)
+	}
+	defer CloseDB(gdb)
+
+	pending, err := PendingMigrations(gdb)
+	if err != nil || len(pending) != len(migrations) {
+		t.Fatalf("Expected every migration to be pending, got %d %v", len(pending), err)
+	}
+	applied, err := Migrate(gdb)
+	if err != nil || len(applied) != len(migrations) {
+		t.Fatalf("Expected every migration to be applied, got %d %v", len(applied), err)
+	}
+	if !gdb.Migrator().HasTable(&Task{}) || !gdb.Migrator().HasTable(&TaskEvent{}) {
+		t.Error("Expected the tasks and task_events tables")
+	}
+	recorded, err := AppliedMigrations(gdb)
+	if err != nil || len(recorded) != len(migrations) || recorded[0].Version != 1 || recorded[0].AppliedAt.IsZero() {
+		t.Errorf("Expected the migrations to be recorded in order, got %+v %v", recorded, err)
+	}
+
+	// nothing is left to do
+	if applied, err := Migrate(gdb); err != nil || len(applied) != 0 {
+		t.Errorf("Expected no migrations to be applied again, got %d %v", len(applied), err)
+	}
+
+	// a new step is applied on its own
+	migrations = append(migrations, Migration{Version: 99, Name: "test", Up: func(conn *gorm.DB) error {
+		return conn.Exec("CREATE TABLE test_migration (id INTEGER)").Error
+	}})
+	defer func() { migrations = migrations[:len(migrations)-1] }()
+	if pending, err := PendingMigrations(gdb); err != nil || len(pending) != 1 || pending[0].Version != 99 {
+		t.Fatalf("Expected the new migration to be pending, got %+v %v", pending, err)
+	}
+	if _, err := Migrate(gdb); err != nil || !gdb.Migrator().HasTable("test_migration") {
+		t.Errorf("Expected the new migration to be applied, got %v", err)
+	}
+}
+
+func TestMigrate_Concurrently(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.db")
+	gdb, err := ConnectSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to open SQLite database: %v", err)
+	}
+	defer CloseDB(gdb)
+	other, err := ConnectSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to open SQLite database: %v", err)
+	}
+	defer CloseDB(other)
+
+	// the other host migrates the database while this one is applying the last step
+	var otherErr error
+	started := false
+	migrations = append(migrations, Migration{Version: 99, Name: "test", Up: func(conn *gorm.DB) error {
+		if !started {
+			started = true
+			_, otherErr = Migrate(other)
+		}
+		return conn.Exec("CREATE TABLE IF NOT EXISTS test_migration (id INTEGER)").Error
+	}})
+	defer func() { migrations = migrations[:len(migrations)-1] }()
+
+	if _, err := Migrate(gdb); err != nil {
+		t.Errorf("Expected the migration recorded by the other host to succeed, got %v", err)
+	}
+	if otherErr != nil {
+		t.Errorf("Failed to migrate on the other host: %v", otherErr)
+	}
+	recorded, err := AppliedMigrations(gdb)
+	if err != nil || len(recorded) != len(migrations) {
+		t.Errorf("Expected every migration to be recorded once, got %+v %v", recorded, err)
+	}
+}
+
+func TestMigrate_DigestAlgorithms(t *testing.T) {
+	gdb, err := ConnectSQLite(filepath.Join(t.TempDir(), "tasks.db"))
+	if err != nil {
+		t.Fatalf("Failed to open SQLite database: %v", err)
+	}
+	defer CloseDB(gdb)
+	if _, err := Migrate(gdb); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+
+	// a database of version 5 misses the columns
+	for _, column := range []string{"src_digest_algorithm", "dst_digest_algorithm"} {
+		if err := gdb.Exec("ALTER TABLE tasks DROP COLUMN " + column).Error; err != nil {
+			t.Fatalf("Failed to drop %s: %v", column, err)
+		}
+	}
+	if err := gdb.Where("version = ?", 6).Delete(&SchemaMigration{}).Error; err != nil {
+		t.Fatalf("Failed to forget migration 6: %v", err)
+	}
+	if applied, err := Migrate(gdb); err != nil || len(applied) != 1 || applied[0].Version != 6 {
+		t.Fatalf("Expected migration 6 to be applied, got %+v %v", applied, err)
+	}
+
+	dbInstance := NewDB(gdb)
+	if err := dbInstance.UpdateTask(&Task{VirtualPath: "/test/blake3.bin", SrcPath: "/source/blake3.bin", Status: Uploaded, SrcDigestAlgorithm: "blake3", DstDigestAlgorithm: "sha256"}); err != nil {
+		t.Fatalf("Failed to update task: %v", err)
+	}
+	task, err := dbInstance.GetTask("/test/blake3.bin")
+	if err != nil || task.SrcDigestAlgorithm != "blake3" || task.DstDigestAlgorithm != "sha256" {
+		t.Errorf("Expected the digest algorithms to be recorded, got %+v %v", task, err)
+	}
+}
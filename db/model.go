@@ -1,6 +1,11 @@
 package db
 
 import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
 	"gorm.io/gorm"
 )
 
@@ -35,25 +40,134 @@ func (s Status) String() string {
 	}
 }
 
+// ParseStatus returns the status named name, ignoring case
+func ParseStatus(name string) (Status, error) {
+	for s := Pending; s <= Failed; s++ {
+		if strings.EqualFold(s.String(), name) {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown status %q", name)
+}
+
+// transitions are the statuses UpdateTask may change a task of each status to.
+// A task isn't moved back to Pending once uploaded, and a Downloaded task is final.
+// ResetTasks bypasses them, e.g. for retry.
+var transitions = map[Status][]Status{
+	Pending:     {Pending, Uploading, Uploaded, Downloading, Downloaded, Failed},
+	Uploading:   {Pending, Uploading, Uploaded, Downloading, Downloaded, Failed},
+	Uploaded:    {Uploading, Uploaded, Downloading, Downloaded, Failed},
+	Downloading: {Uploading, Uploaded, Downloading, Downloaded, Failed},
+	Downloaded:  {Downloaded},
+	Failed:      {Pending, Uploading, Uploaded, Downloading, Downloaded, Failed},
+}
+
+// CanTransition reports whether UpdateTask may change a task from one status to another
+func CanTransition(from, to Status) bool {
+	return slices.Contains(transitions[from], to)
+}
+
 type Task struct {
 	gorm.Model
 	/* VirtualPath is the path in the S3 bucket and the virual file system.
 	   It is unique and not null. */
-	VirtualPath string `gorm:"uniqueIndex;not null"`
+	VirtualPath string `gorm:"uniqueIndex:idx_tasks_run_path,priority:2;not null;size:512"`
+	/* RunID is the transfer the task belongs to, the virtual path is unique per run. */
+	RunID string `gorm:"uniqueIndex:idx_tasks_run_path,priority:1;not null;default:'';size:255"`
 	/* SrcPath is the path of the file in the transfer source. */
 	SrcPath string `gorm:"not null"`
 	/* SrcSize is the size of the file in the transfer source. */
 	SrcSize int64 `gorm:"not null"`
-	/* SrcDigest is the sample_md5 digest of the file in the transfer source. */
+	/* SrcDigest is the digest of the file in the transfer source, sample_md5 unless its profile names another algorithm. */
 	SrcDigest string `gorm:"nullable"`
 	/* DstPath is the path of the file in the transfer destination. */
 	DstPath string `gorm:"not null"`
 	/* DstSize is the size of the file in the transfer destination. */
 	DstSize int64 `gorm:"not null"`
-	/* DstDigest is the sample_md5 digest of the file in the transfer destination. */
+	/* DstDigest is the digest of the file in the transfer destination, sample_md5 unless its profile names another algorithm. */
 	DstDigest string `gorm:"nullable"`
+	/* SrcDigestAlgorithm and DstDigestAlgorithm are the algorithms of SrcDigest and DstDigest, sample_md5 if empty. */
+	SrcDigestAlgorithm string `gorm:"size:64"`
+	DstDigestAlgorithm string `gorm:"size:64"`
 	/* Status is the status of the task. */
 	Status Status `gorm:"not null"`
 	/* Error is the error message of the task. */
 	Error string `gorm:"type:text"`
+	/* Priority is set by operators to transfer some tasks earlier. Higher goes first.
+	   It is never overwritten by UpdateTask. */
+	Priority int `gorm:"not null;default:0"`
+	/* UploadedAt and DownloadedAt are set by UpdateTask when it marks the task Uploaded or Downloaded. */
+	UploadedAt   *time.Time
+	DownloadedAt *time.Time
+	/* Attempts counts how often UpdateTask marked the task Uploading or Downloading. */
+	Attempts int `gorm:"not null;default:0"`
+	/* OwnerHost holds the lease of ClaimTasks until LeaseExpiresAt, other hosts don't claim the task meanwhile.
+	   They are kept after the upload as the host that uploaded the task. */
+	OwnerHost      string `gorm:"size:255;index"`
+	LeaseExpiresAt *time.Time
+	/* ETag and ObjectSize are reported by R2 for the object when the task is marked Uploaded, the ETag is the MD5 of the object.
+	   recv and status --audit compare them with the object to find objects that changed in the bucket since. */
+	ETag       string `gorm:"column:etag;size:255"`
+	ObjectSize int64  `gorm:"not null;default:0"`
+	/* TransferredBytes are the bytes of the upload or download in progress, reported by send and recv every
+	   --db-progress-interval. Every status change resets them. */
+	TransferredBytes int64 `gorm:"not null;default:0"`
+	/* PlacedBytes are the bytes recv placed so far of a downloaded file it is moving into its destination,
+	   reported like TransferredBytes and reset by every status change. */
+	PlacedBytes int64 `gorm:"not null;default:0"`
+	/* Version is incremented by every UpdateTask, which only writes the row if its version didn't change since it was read. */
+	Version int `gorm:"not null;default:0"`
+	/* TransferStartedAt and TransferEndedAt are the upload or download that led to this update.
+	   They are only recorded in its TaskEvent. */
+	TransferStartedAt *time.Time `gorm:"-"`
+	TransferEndedAt   *time.Time `gorm:"-"`
+}
+
+// TaskEvent is a status change of a task, written by every UpdateTask
+type TaskEvent struct {
+	ID        uint `gorm:"primarykey"`
+	TaskID    uint `gorm:"index;not null"`
+	CreatedAt time.Time
+	/* OldStatus is nil when the event created the task. */
+	OldStatus *Status
+	NewStatus Status `gorm:"not null"`
+	Error     string `gorm:"type:text"`
+	/* Bytes is the source size of the task at the time of the event. */
+	Bytes int64 `gorm:"not null"`
+	/* Host is the host that wrote the event. */
+	Host string `gorm:"size:255"`
+	/* TransferStartedAt and TransferEndedAt are the transfer that led to the event, if known. */
+	TransferStartedAt *time.Time
+	TransferEndedAt   *time.Time
+}
+
+// Rate returns the bytes per second of the transfer of the event, 0 if it isn't known
+func (e *TaskEvent) Rate() float64 {
+	if e.TransferStartedAt == nil || e.TransferEndedAt == nil {
+		return 0
+	}
+	d := e.TransferEndedAt.Sub(*e.TransferStartedAt)
+	if d <= 0 {
+		return 0
+	}
+	return float64(e.Bytes) / d.Seconds()
+}
+
+// TaskDestination is the upload of a task to one of the destinations of send, the remote or a mirror.
+// They are only written when send has mirrors, a task is Uploaded once it is Uploaded to all of them.
+type TaskDestination struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	/* RunID and VirtualPath are those of the task. */
+	RunID       string `gorm:"uniqueIndex:idx_task_destinations_run_path,priority:1;not null;default:'';size:255"`
+	VirtualPath string `gorm:"uniqueIndex:idx_task_destinations_run_path,priority:2;not null;size:512"`
+	/* Destination is the name of the mirror, or primary for the remote. */
+	Destination string `gorm:"uniqueIndex:idx_task_destinations_run_path,priority:3;not null;size:255"`
+	/* Status is Uploaded or, while the upload is failing, Uploading. */
+	Status Status `gorm:"not null"`
+	Error  string `gorm:"type:text"`
+	/* ETag and ObjectSize are reported by the destination for the uploaded object, like those of Task. */
+	ETag       string `gorm:"column:etag;size:255"`
+	ObjectSize int64  `gorm:"not null;default:0"`
 }
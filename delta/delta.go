@@ -0,0 +1,410 @@
+// Package delta transfers the changes of a file rsync-style: the receiver computes the block signatures of
+// its old copy, the sender finds the blocks of the new file in them with a rolling checksum and writes a
+// delta of block references and literal bytes, and the receiver rebuilds the new file from the delta and
+// its old copy.
+package delta
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultBlockSize is the block size of the signatures if none is given
+const DefaultBlockSize = 1 << 20
+
+// maxLiteral bounds the literal bytes of one operation, and so the memory of Write
+const maxLiteral = 4 << 20
+
+var (
+	signatureMagic = [8]byte{'S', 'M', 'S', 'I', 'G', '0', '0', '1'}
+	deltaMagic     = [8]byte{'S', 'M', 'D', 'L', 'T', '0', '0', '1'}
+)
+
+// operations of a delta
+const (
+	opEnd byte = iota
+	opCopy
+	opLiteral
+)
+
+// Block is the checksums of a block of the old file
+type Block struct {
+	Weak   uint32
+	Strong [md5.Size]byte
+}
+
+// Signature is the block checksums of the old file. The last block is shorter if the size isn't a multiple
+// of the block size.
+type Signature struct {
+	BlockSize int
+	Size      int64
+	Blocks    []Block
+}
+
+// blockLen returns the length of block i
+func (s *Signature) blockLen(i int) int {
+	if i == len(s.Blocks)-1 && s.Size%int64(s.BlockSize) != 0 {
+		return int(s.Size % int64(s.BlockSize))
+	}
+	return s.BlockSize
+}
+
+// rolling is the weak checksum of rsync over a window, updated a byte at a time
+type rolling struct {
+	a, b uint32
+	n    uint32
+}
+
+func newRolling(window []byte) rolling {
+	r := rolling{n: uint32(len(window))}
+	for i, c := range window {
+		r.a += uint32(c)
+		r.b += uint32(len(window)-i) * uint32(c)
+	}
+	return r
+}
+
+// roll moves the window by one byte, dropping out and taking in
+func (r *rolling) roll(out, in byte) {
+	r.a += uint32(in) - uint32(out)
+	r.b += r.a - r.n*uint32(out)
+}
+
+func (r rolling) sum() uint32 {
+	return r.a&0xffff | r.b<<16
+}
+
+// ComputeSignature reads the old file from r and returns the checksums of its blocks of blockSize bytes
+func ComputeSignature(r io.Reader, blockSize int) (*Signature, error) {
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("block size must be positive, got %d", blockSize)
+	}
+	sig := &Signature{BlockSize: blockSize}
+	buf := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			block := buf[:n]
+			sig.Blocks = append(sig.Blocks, Block{Weak: newRolling(block).sum(), Strong: md5.Sum(block)})
+			sig.Size += int64(n)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return sig, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// WriteTo encodes the signature to w
+func (s *Signature) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	header := struct {
+		Magic     [8]byte
+		BlockSize uint32
+		Size      int64
+		Count     uint64
+	}{signatureMagic, uint32(s.BlockSize), s.Size, uint64(len(s.Blocks))}
+	if err := binary.Write(bw, binary.BigEndian, header); err != nil {
+		return 0, err
+	}
+	for _, block := range s.Blocks {
+		if err := binary.Write(bw, binary.BigEndian, block); err != nil {
+			return 0, err
+		}
+	}
+	n := int64(binary.Size(header)) + int64(len(s.Blocks))*int64(binary.Size(Block{}))
+	return n, bw.Flush()
+}
+
+// ReadSignature decodes a signature written by WriteTo
+func ReadSignature(r io.Reader) (*Signature, error) {
+	br := bufio.NewReader(r)
+	var header struct {
+		Magic     [8]byte
+		BlockSize uint32
+		Size      int64
+		Count     uint64
+	}
+	if err := binary.Read(br, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("failed to read signature header: %w", err)
+	}
+	if header.Magic != signatureMagic {
+		return nil, errors.New("not a signature")
+	}
+	if header.BlockSize == 0 || header.Size < 0 {
+		return nil, fmt.Errorf("invalid signature of %d bytes in blocks of %d", header.Size, header.BlockSize)
+	}
+	if want := (uint64(header.Size) + uint64(header.BlockSize) - 1) / uint64(header.BlockSize); header.Count != want {
+		return nil, fmt.Errorf("signature of %d bytes has %d blocks instead of %d", header.Size, header.Count, want)
+	}
+	sig := &Signature{BlockSize: int(header.BlockSize), Size: header.Size, Blocks: make([]Block, header.Count)}
+	if err := binary.Read(br, binary.BigEndian, sig.Blocks); err != nil {
+		return nil, fmt.Errorf("failed to read signature blocks: %w", err)
+	}
+	return sig, nil
+}
+
+// Stats are the bytes of the new file a delta copies from the old one and those it carries
+type Stats struct {
+	Copied  int64
+	Literal int64
+}
+
+// deltaWriter writes the operations of a delta, merging the copies of successive blocks
+type deltaWriter struct {
+	w          *bufio.Writer
+	stats      Stats
+	copyStart  int
+	copyCount  int
+	copyLength int64
+}
+
+func (d *deltaWriter) flushCopy() error {
+	if d.copyCount == 0 {
+		return nil
+	}
+	if err := d.w.WriteByte(opCopy); err != nil {
+		return err
+	}
+	if err := binary.Write(d.w, binary.BigEndian, [2]uint64{uint64(d.copyStart), uint64(d.copyCount)}); err != nil {
+		return err
+	}
+	d.stats.Copied += d.copyLength
+	d.copyCount, d.copyLength = 0, 0
+	return nil
+}
+
+func (d *deltaWriter) copyBlock(i, length int) error {
+	if d.copyCount > 0 && d.copyStart+d.copyCount == i {
+		d.copyCount++
+		d.copyLength += int64(length)
+		return nil
+	}
+	if err := d.flushCopy(); err != nil {
+		return err
+	}
+	d.copyStart, d.copyCount, d.copyLength = i, 1, int64(length)
+	return nil
+}
+
+func (d *deltaWriter) literal(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if err := d.flushCopy(); err != nil {
+		return err
+	}
+	if err := d.w.WriteByte(opLiteral); err != nil {
+		return err
+	}
+	if err := binary.Write(d.w, binary.BigEndian, uint64(len(data))); err != nil {
+		return err
+	}
+	d.stats.Literal += int64(len(data))
+	_, err := d.w.Write(data)
+	return err
+}
+
+// Write reads the new file from src and writes its delta against the old file of sig to w
+func Write(sig *Signature, src io.Reader, w io.Writer) (Stats, error) {
+	d := &deltaWriter{w: bufio.NewWriter(w)}
+	header := struct {
+		Magic     [8]byte
+		BlockSize uint32
+		Size      int64
+	}{deltaMagic, uint32(sig.BlockSize), sig.Size}
+	if err := binary.Write(d.w, binary.BigEndian, header); err != nil {
+		return d.stats, err
+	}
+
+	// the full blocks by weak checksum, the short last block is only looked for at the end
+	blocks := make(map[uint32][]int, len(sig.Blocks))
+	for i, block := range sig.Blocks {
+		if sig.blockLen(i) == sig.BlockSize {
+			blocks[block.Weak] = append(blocks[block.Weak], i)
+		}
+	}
+	match := func(weak uint32, window []byte) (int, bool) {
+		candidates, ok := blocks[weak]
+		if !ok {
+			return 0, false
+		}
+		strong := md5.Sum(window)
+		for _, i := range candidates {
+			if sig.Blocks[i].Strong == strong {
+				return i, true
+			}
+		}
+		return 0, false
+	}
+
+	size := sig.BlockSize
+	br := bufio.NewReaderSize(src, 1<<20)
+	// buf holds the pending literal from lit and the window from pos
+	buf := make([]byte, 0, 2*(size+maxLiteral))
+	lit, pos := 0, 0
+	eof := false
+	// fill reads until buf has n bytes from pos or the source ends
+	fill := func(n int) error {
+		if lit > 0 && len(buf)+n > cap(buf) {
+			buf = buf[:copy(buf, buf[lit:])]
+			pos -= lit
+			lit = 0
+		}
+		for !eof && len(buf)-pos < n {
+			if len(buf) == cap(buf) {
+				grown := make([]byte, len(buf), 2*cap(buf))
+				copy(grown, buf)
+				buf = grown
+			}
+			m, err := br.Read(buf[len(buf):cap(buf)])
+			buf = buf[:len(buf)+m]
+			if errors.Is(err, io.EOF) {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var r rolling
+	fresh := true
+	for {
+		if err := fill(size + 1); err != nil {
+			return d.stats, err
+		}
+		if len(buf)-pos < size {
+			break
+		}
+		window := buf[pos : pos+size]
+		if fresh {
+			r, fresh = newRolling(window), false
+		}
+		if i, ok := match(r.sum(), window); ok {
+			if err := d.literal(buf[lit:pos]); err != nil {
+				return d.stats, err
+			}
+			if err := d.copyBlock(i, size); err != nil {
+				return d.stats, err
+			}
+			pos += size
+			lit, fresh = pos, true
+			continue
+		}
+		if pos+size < len(buf) {
+			r.roll(buf[pos], buf[pos+size])
+		} else {
+			fresh = true
+		}
+		pos++
+		if pos-lit >= maxLiteral {
+			if err := d.literal(buf[lit:pos]); err != nil {
+				return d.stats, err
+			}
+			lit = pos
+		}
+	}
+
+	// the rest is shorter than a block, it may be the last block of the old file
+	tail := buf[pos:]
+	if last := len(sig.Blocks) - 1; last >= 0 && len(tail) > 0 && sig.blockLen(last) == len(tail) &&
+		sig.Blocks[last].Weak == newRolling(tail).sum() && sig.Blocks[last].Strong == md5.Sum(tail) {
+		if err := d.literal(buf[lit:pos]); err != nil {
+			return d.stats, err
+		}
+		if err := d.copyBlock(last, len(tail)); err != nil {
+			return d.stats, err
+		}
+	} else if err := d.literal(buf[lit:]); err != nil {
+		return d.stats, err
+	}
+	if err := d.flushCopy(); err != nil {
+		return d.stats, err
+	}
+	if err := d.w.WriteByte(opEnd); err != nil {
+		return d.stats, err
+	}
+	if err := binary.Write(d.w, binary.BigEndian, d.stats.Copied+d.stats.Literal); err != nil {
+		return d.stats, err
+	}
+	return d.stats, d.w.Flush()
+}
+
+// Apply rebuilds the new file from the delta and the old file of size bytes, and writes it to w. It returns
+// the size of the new file.
+func Apply(old io.ReaderAt, size int64, delta io.Reader, w io.Writer) (int64, error) {
+	br := bufio.NewReader(delta)
+	var header struct {
+		Magic     [8]byte
+		BlockSize uint32
+		Size      int64
+	}
+	if err := binary.Read(br, binary.BigEndian, &header); err != nil {
+		return 0, fmt.Errorf("failed to read delta header: %w", err)
+	}
+	if header.Magic != deltaMagic {
+		return 0, errors.New("not a delta")
+	}
+	if header.Size != size {
+		return 0, fmt.Errorf("delta is against a file of %d bytes, the old file has %d", header.Size, size)
+	}
+	if header.BlockSize == 0 {
+		return 0, errors.New("delta has no block size")
+	}
+	blockSize := int64(header.BlockSize)
+	blockCount := uint64((size + blockSize - 1) / blockSize)
+	var written int64
+	for {
+		op, err := br.ReadByte()
+		if err != nil {
+			return written, fmt.Errorf("failed to read delta: %w", err)
+		}
+		switch op {
+		case opCopy:
+			var blocks [2]uint64
+			if err := binary.Read(br, binary.BigEndian, &blocks); err != nil {
+				return written, fmt.Errorf("failed to read delta: %w", err)
+			}
+			if blocks[1] == 0 || blocks[0] >= blockCount || blocks[1] > blockCount-blocks[0] {
+				return written, fmt.Errorf("delta copies blocks %d+%d of a file of %d blocks", blocks[0], blocks[1], blockCount)
+			}
+			start := int64(blocks[0]) * blockSize
+			length := min(int64(blocks[1])*blockSize, size-start)
+			n, err := io.Copy(w, io.NewSectionReader(old, start, length))
+			written += n
+			if err != nil {
+				return written, err
+			}
+			if n != length {
+				return written, fmt.Errorf("old file ended at %d bytes", start+n)
+			}
+		case opLiteral:
+			var length uint64
+			if err := binary.Read(br, binary.BigEndian, &length); err != nil {
+				return written, fmt.Errorf("failed to read delta: %w", err)
+			}
+			n, err := io.CopyN(w, br, int64(length))
+			written += n
+			if err != nil {
+				return written, fmt.Errorf("failed to read delta: %w", err)
+			}
+		case opEnd:
+			var total int64
+			if err := binary.Read(br, binary.BigEndian, &total); err != nil {
+				return written, fmt.Errorf("failed to read delta: %w", err)
+			}
+			if total != written {
+				return written, fmt.Errorf("delta rebuilt %d bytes instead of %d", written, total)
+			}
+			return written, nil
+		default:
+			return written, fmt.Errorf("unknown delta operation %d", op)
+		}
+	}
+}
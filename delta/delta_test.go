@@ -0,0 +1,101 @@
+package delta
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func randomBytes(r *rand.Rand, n int) []byte {
+	data := make([]byte, n)
+	r.Read(data)
+	return data
+}
+
+// roundTrip sends new against old and returns the stats of the delta
+func roundTrip(t *testing.T, old, new []byte, blockSize int) Stats {
+	t.Helper()
+	sig, err := ComputeSignature(bytes.NewReader(old), blockSize)
+	require.NoError(t, err)
+	var encoded bytes.Buffer
+	_, err = sig.WriteTo(&encoded)
+	require.NoError(t, err)
+	sig, err = ReadSignature(&encoded)
+	require.NoError(t, err)
+
+	var delta bytes.Buffer
+	stats, err := Write(sig, bytes.NewReader(new), &delta)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(new)), stats.Copied+stats.Literal)
+
+	var rebuilt bytes.Buffer
+	n, err := Apply(bytes.NewReader(old), int64(len(old)), &delta, &rebuilt)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(new)), n)
+	assert.True(t, bytes.Equal(new, rebuilt.Bytes()), "rebuilt file differs")
+	return stats
+}
+
+func TestRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	old := randomBytes(r, 10*1024+300)
+
+	// unchanged, the short last block too
+	stats := roundTrip(t, old, old, 1024)
+	assert.Equal(t, int64(0), stats.Literal)
+
+	// rewritten in the middle
+	changed := bytes.Clone(old)
+	copy(changed[4100:], randomBytes(r, 500))
+	stats = roundTrip(t, old, changed, 1024)
+	assert.LessOrEqual(t, stats.Literal, int64(2048))
+
+	// bytes inserted and removed shift the rest
+	shifted := append(append(bytes.Clone(old[:3000]), randomBytes(r, 77)...), old[3000:]...)
+	shifted = append(shifted[:8000], shifted[8200:]...)
+	stats = roundTrip(t, old, shifted, 1024)
+	assert.Less(t, stats.Literal, int64(4*1024))
+
+	// grown and truncated
+	roundTrip(t, old, append(bytes.Clone(old), randomBytes(r, 5000)...), 1024)
+	roundTrip(t, old, old[:5555], 1024)
+
+	// nothing in common, nothing old or nothing new
+	stats = roundTrip(t, old, randomBytes(r, 3000), 1024)
+	assert.Equal(t, int64(0), stats.Copied)
+	roundTrip(t, nil, old, 1024)
+	roundTrip(t, old, nil, 1024)
+}
+
+func TestRoundTrip_LongLiterals(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	old := randomBytes(r, 64*1024)
+	// longer than the literals of one operation, with the old file in between
+	new := append(append(randomBytes(r, maxLiteral+12345), old...), randomBytes(r, maxLiteral+1)...)
+	stats := roundTrip(t, old, new, 4096)
+	assert.Equal(t, int64(len(old)), stats.Copied)
+}
+
+func TestApply_Errors(t *testing.T) {
+	old := []byte("the old file of some bytes")
+	sig, err := ComputeSignature(bytes.NewReader(old), 4)
+	require.NoError(t, err)
+	var delta bytes.Buffer
+	_, err = Write(sig, bytes.NewReader([]byte("the new file of some bytes")), &delta)
+	require.NoError(t, err)
+
+	_, err = Apply(bytes.NewReader(old[:10]), 10, bytes.NewReader(delta.Bytes()), &bytes.Buffer{})
+	assert.ErrorContains(t, err, "delta is against a file of 26 bytes, the old file has 10")
+	_, err = Apply(bytes.NewReader(old), int64(len(old)), bytes.NewReader(delta.Bytes()[:delta.Len()-3]), &bytes.Buffer{})
+	assert.ErrorContains(t, err, "failed to read delta")
+	_, err = Apply(bytes.NewReader(old), int64(len(old)), bytes.NewReader([]byte("garbage that isn't a delta at all")), &bytes.Buffer{})
+	assert.ErrorContains(t, err, "not a delta")
+
+	_, err = ReadSignature(bytes.NewReader(delta.Bytes()))
+	assert.ErrorContains(t, err, "not a signature")
+	_, err = ComputeSignature(bytes.NewReader(old), 0)
+	assert.ErrorContains(t, err, "block size must be positive")
+}
@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hrz6976/syncmate/rclone"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	logger "github.com/sirupsen/logrus"
+)
+
+var registry = prometheus.NewRegistry()
+
+var (
+	tasks = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "syncmate_tasks",
+		Help: "Number of tasks of this run by state.",
+	}, []string{"state"})
+	moveFileDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "syncmate_movefile_duration_seconds",
+		Help:    "Time spent placing downloaded files with MoveFile.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	})
+)
+
+var (
+	taskStatesMu sync.Mutex
+	taskStates   = make(map[string]string)
+)
+
+func init() {
+	registry.MustRegister(tasks, moveFileDuration)
+}
+
+// RegisterTransferStats exposes the rclone transfer counters, labelled with
+// the direction ("upload" or "download") of the run. Call it once per process.
+func RegisterTransferStats(direction string) {
+	labels := prometheus.Labels{"direction": direction}
+	registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "syncmate_bytes_transferred_total",
+			Help:        "Bytes transferred by rclone.",
+			ConstLabels: labels,
+		}, func() float64 {
+			_, bytes, _ := rclone.TransferStats()
+			return float64(bytes)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "syncmate_files_transferred_total",
+			Help:        "Files transferred by rclone.",
+			ConstLabels: labels,
+		}, func() float64 {
+			files, _, _ := rclone.TransferStats()
+			return float64(files)
+		}),
+		// rclone resets its error count between retries, so this is a gauge
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "syncmate_rclone_errors",
+			Help:        "Errors counted by rclone in the current attempt.",
+			ConstLabels: labels,
+		}, func() float64 {
+			_, _, errors := rclone.TransferStats()
+			return float64(errors)
+		}),
+	)
+}
+
+// SetTaskState moves a task to state in the syncmate_tasks gauge
+func SetTaskState(virtualPath string, state string) {
+	taskStatesMu.Lock()
+	defer taskStatesMu.Unlock()
+	if old, ok := taskStates[virtualPath]; ok {
+		if old == state {
+			return
+		}
+		tasks.WithLabelValues(old).Dec()
+	}
+	taskStates[virtualPath] = state
+	tasks.WithLabelValues(state).Inc()
+}
+
+// ObserveMoveFile records the duration of a MoveFile call
+func ObserveMoveFile(d time.Duration) {
+	moveFileDuration.Observe(d.Seconds())
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Serve exposes the metrics on http://addr/metrics
+//
+// It returns a func which should be called to stop the server.
+func Serve(addr string) (func(), error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.WithError(err).Error("Metrics server stopped")
+		}
+	}()
+	logger.WithField("addr", listener.Addr().String()).Info("Serving metrics")
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}, nil
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetTaskState(t *testing.T) {
+	SetTaskState("blob_0.bin", "Downloading")
+	SetTaskState("blob_1.bin", "Downloading")
+	SetTaskState("blob_0.bin", "Downloaded")
+	SetTaskState("blob_0.bin", "Downloaded")
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(tasks.WithLabelValues("Downloading")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(tasks.WithLabelValues("Downloaded")))
+}
+
+func TestHandler(t *testing.T) {
+	RegisterTransferStats("download")
+	ObserveMoveFile(3 * time.Second)
+
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(body), `syncmate_bytes_transferred_total{direction="download"}`)
+	assert.Contains(t, string(body), `syncmate_rclone_errors{direction="download"}`)
+	assert.Contains(t, string(body), "syncmate_movefile_duration_seconds_count 1")
+}
@@ -0,0 +1,125 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+type Event string
+
+const (
+	// EventSuccess is sent when a run finishes without errors
+	EventSuccess Event = "success"
+	// EventFailure is sent when a run fails or is aborted
+	EventFailure Event = "failure"
+)
+
+const (
+	FormatSlack = "slack"
+	FormatJSON  = "json"
+)
+
+// Config is the "notifications" section of config.json
+type Config struct {
+	// WebhookURL receives a POST request for every notified event.
+	WebhookURL string `json:"webhook_url"`
+	// Format of the request body: "slack" (default) posts {"text": ...}, "json" posts the Summary.
+	Format string `json:"format,omitempty"`
+	// Events to notify, all events if empty.
+	Events []Event `json:"events,omitempty"`
+}
+
+// Summary describes the outcome of a send or recv run
+type Summary struct {
+	Command  string        `json:"command"`
+	Host     string        `json:"host"`
+	Event    Event         `json:"event"`
+	Files    int64         `json:"files"`
+	Bytes    int64         `json:"bytes"`
+	Failures int64         `json:"failures"`
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Text renders the summary as a single human-readable message
+func (s *Summary) Text() string {
+	status := "finished"
+	if s.Event == EventFailure {
+		status = "FAILED"
+	}
+	text := fmt.Sprintf("syncmate %s on %s %s after %s: %d files, %s, %d failures",
+		s.Command, s.Host, status, s.Duration.Round(time.Second), s.Files, fs.SizeSuffix(s.Bytes).ByteUnit(), s.Failures)
+	if s.Error != "" {
+		text += "\nError: " + s.Error
+	}
+	return text
+}
+
+// Enabled reports whether the event should be notified
+func (c *Config) Enabled(event Event) bool {
+	if c == nil || c.WebhookURL == "" {
+		return false
+	}
+	return len(c.Events) == 0 || slices.Contains(c.Events, event)
+}
+
+// Validate checks the configuration for unknown formats and events
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	switch c.Format {
+	case "", FormatSlack, FormatJSON:
+	default:
+		return fmt.Errorf("unknown notification format %q", c.Format)
+	}
+	for _, event := range c.Events {
+		if event != EventSuccess && event != EventFailure {
+			return fmt.Errorf("unknown notification event %q", event)
+		}
+	}
+	return nil
+}
+
+// Send posts the summary to the configured webhook if its event is enabled
+func Send(ctx context.Context, c *Config, s *Summary) error {
+	if !c.Enabled(s.Event) {
+		return nil
+	}
+
+	var body []byte
+	var err error
+	if c.Format == FormatJSON {
+		body, err = json.Marshal(s)
+	} else {
+		body, err = json.Marshal(map[string]string{"text": s.Text()})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create notification request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send notification: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notification webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSummary(event Event) *Summary {
+	return &Summary{
+		Command:  "recv",
+		Host:     "da5",
+		Event:    event,
+		Files:    12,
+		Bytes:    3 << 30,
+		Failures: 1,
+		Duration: 90 * time.Minute,
+	}
+}
+
+func TestSend_Slack(t *testing.T) {
+	var received map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+
+	err := Send(context.Background(), &Config{WebhookURL: server.URL}, newTestSummary(EventSuccess))
+	require.NoError(t, err)
+	assert.Contains(t, received["text"], "syncmate recv on da5 finished after 1h30m0s")
+	assert.Contains(t, received["text"], "12 files, 3 GiB, 1 failures")
+}
+
+func TestSend_JSON(t *testing.T) {
+	var received Summary
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+
+	summary := newTestSummary(EventFailure)
+	summary.Error = "upload cancelled by user interrupt"
+	err := Send(context.Background(), &Config{WebhookURL: server.URL, Format: FormatJSON}, summary)
+	require.NoError(t, err)
+	assert.Equal(t, *summary, received)
+}
+
+func TestSend_EventFiltering(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer server.Close()
+
+	c := &Config{WebhookURL: server.URL, Events: []Event{EventFailure}}
+	require.NoError(t, Send(context.Background(), c, newTestSummary(EventSuccess)))
+	assert.Equal(t, 0, calls)
+	require.NoError(t, Send(context.Background(), c, newTestSummary(EventFailure)))
+	assert.Equal(t, 1, calls)
+
+	// no config, no notification
+	require.NoError(t, Send(context.Background(), nil, newTestSummary(EventFailure)))
+}
+
+func TestSend_WebhookError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	err := Send(context.Background(), &Config{WebhookURL: server.URL}, newTestSummary(EventSuccess))
+	assert.Error(t, err)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	assert.NoError(t, (*Config)(nil).Validate())
+	assert.NoError(t, (&Config{Format: FormatJSON, Events: []Event{EventSuccess}}).Validate())
+	assert.Error(t, (&Config{Format: "xml"}).Validate())
+	assert.Error(t, (&Config{Events: []Event{"started"}}).Validate())
+}
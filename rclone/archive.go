@@ -0,0 +1,48 @@
+package rclone
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	logger "github.com/sirupsen/logrus"
+)
+
+// ArchiveFile moves remote from fsrc to the same path in farchive, server-side if possible.
+// The archived file is given the current time as its modification time for PurgeArchive, unless farchive
+// reports the time of the move already, like R2 with use_server_modtime.
+func ArchiveFile(ctx context.Context, farchive, fsrc fs.Fs, remote string) error {
+	obj, err := fsrc.NewObject(ctx, remote)
+	if err != nil {
+		return err
+	}
+	moved, err := operations.Move(ctx, farchive, nil, remote, obj)
+	if err != nil || moved == nil {
+		return err
+	}
+	if now := time.Now(); now.Sub(moved.ModTime(ctx)) > time.Minute {
+		if err := moved.SetModTime(ctx, now); err != nil && !errors.Is(err, fs.ErrorCantSetModTime) {
+			logger.WithError(err).WithField("remote", remote).Warn("Failed to set the archive time of the file")
+		}
+	}
+	return nil
+}
+
+// PurgeArchive deletes the objects of farchive that are older than retention, up to workers at a time.
+// It returns the number of deleted objects.
+func PurgeArchive(ctx context.Context, farchive fs.Fs, retention time.Duration, workers int) (int, error) {
+	var expired []fs.Object
+	err := operations.ListFn(ctx, farchive, func(o fs.Object) {
+		if time.Since(o.ModTime(ctx)) > retention {
+			expired = append(expired, o)
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	logger.WithField("count", len(expired)).Debug("Deleting expired archived files")
+	summary, err := deleteObjects(ctx, expired, workers)
+	return int(summary.Files), err
+}
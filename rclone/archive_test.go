@@ -0,0 +1,59 @@
+package rclone
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveFile(t *testing.T) {
+	srcDir := t.TempDir()
+	archiveDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "blob_0.bin"), []byte("blob"), 0644))
+	twoWeeksAgo := time.Now().Add(-14 * 24 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(srcDir, "blob_0.bin"), twoWeeksAgo, twoWeeksAgo))
+
+	ctx := context.Background()
+	fsrc, err := fs.NewFs(ctx, srcDir)
+	require.NoError(t, err)
+	farchive, err := fs.NewFs(ctx, archiveDir)
+	require.NoError(t, err)
+
+	require.NoError(t, ArchiveFile(ctx, farchive, fsrc, "blob_0.bin"))
+	assert.NoFileExists(t, filepath.Join(srcDir, "blob_0.bin"))
+	content, err := os.ReadFile(filepath.Join(archiveDir, "blob_0.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, "blob", string(content))
+	// the retention counts from the time of archiving
+	stat, err := os.Stat(filepath.Join(archiveDir, "blob_0.bin"))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), stat.ModTime(), time.Minute)
+
+	assert.Error(t, ArchiveFile(ctx, farchive, fsrc, "missing.bin"))
+}
+
+func TestPurgeArchive(t *testing.T) {
+	archiveDir := t.TempDir()
+	oldPath := filepath.Join(archiveDir, "old.bin")
+	newPath := filepath.Join(archiveDir, "new.bin")
+	require.NoError(t, os.WriteFile(oldPath, []byte("old"), 0644))
+	require.NoError(t, os.WriteFile(newPath, []byte("new"), 0644))
+	twoWeeksAgo := time.Now().Add(-14 * 24 * time.Hour)
+	require.NoError(t, os.Chtimes(oldPath, twoWeeksAgo, twoWeeksAgo))
+
+	ctx := context.Background()
+	farchive, err := fs.NewFs(ctx, archiveDir)
+	require.NoError(t, err)
+
+	deleted, err := PurgeArchive(ctx, farchive, 7*24*time.Hour, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	assert.NoFileExists(t, oldPath)
+	assert.FileExists(t, newPath)
+}
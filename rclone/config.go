@@ -3,6 +3,8 @@ package rclone
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
 
 	_ "github.com/rclone/rclone/backend/local"
 	"github.com/rclone/rclone/backend/s3"
@@ -26,19 +28,31 @@ func InjectFileList(ctx context.Context, files []string) context.Context {
 	return filter.ReplaceConfig(ctx, f)
 }
 
+// InjectOrderBy sets the order in which rclone transfers files, using the --order-by syntax (e.g. "size,ascending").
+func InjectOrderBy(ctx context.Context, orderBy string) context.Context {
+	ctx, ci := fs.AddConfig(ctx)
+	ci.OrderBy = orderBy
+	return ctx
+}
+
+// InjectConfig adds the config of the copies of syncmate to ctx, which report like the profile of SetProfile
 func InjectConfig(
 	ctx context.Context,
 ) context.Context {
 	ctx, ci := fs.AddConfig(ctx)
-	ci.Progress = true
+	ci.Progress = profile.Progress
 	ci.AskPassword = false
-	ci.LogLevel = fs.LogLevelDebug
-	ci.StatsLogLevel = fs.LogLevelDebug
-	ci.Retries = 0
-	ci.LowLevelRetries = 100
+	ci.LogLevel = profile.LogLevel
+	// the stats are logged at the level of the profile, so they show
+	ci.StatsLogLevel = profile.LogLevel
+	ci.Retries = transferConfig.Retries
+	ci.LowLevelRetries = transferConfig.LowLevelRetries
 	ci.NoTraverse = true
 	ci.StatsOneLine = true
-	ci.MultiThreadChunkSize = fs.Mebi * 500 // 500 MiB chunk size
+	// validated by SetTransferConfig
+	ci.MultiThreadChunkSize, _ = parseSize("multi_thread_chunk_size", transferConfig.MultiThreadChunkSize)
+	ci.MultiThreadCutoff, _ = parseSize("multi_thread_cutoff", transferConfig.MultiThreadCutoff)
+	ci.MultiThreadStreams = transferConfig.MultiThreadStreams
 	// ctx = injectFileList(ctx, files)
 	accounting.Start(ctx)
 	// // This is kinda stupid: rclone reads log level from an empty context
@@ -51,7 +65,10 @@ type CloudflareR2Credentials struct {
 	AccessKey string `json:"access_key"`
 	SecretKey string `json:"secret_key"`
 	AccountID string `json:"account_id"`
-	Bucket    string `json:"bucket"`
+	// Bucket is the name of the bucket, or "bucket/prefix" to keep the keys of syncmate under a prefix
+	Bucket string `json:"bucket"`
+	// Prefix scopes the backend to the keys under it, empty for the whole bucket
+	Prefix string `json:"prefix,omitempty"`
 }
 
 // mocks the config store of rclone
@@ -71,6 +88,55 @@ func NewR2Backend(ctx context.Context, cred *CloudflareR2Credentials) (fs.Fs, er
 	if cred == nil {
 		return nil, fmt.Errorf("Cloudflare R2 credentials are required")
 	}
+	root, err := bucketRoot(cred.Bucket, cred.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	return newR2Fs(ctx, cred, root, nil)
+}
+
+// splitBucket returns the name of the bucket and the key prefix of a bucket like "woc/campaign-2412"
+func splitBucket(bucket string) (name, prefix string) {
+	name, prefix, _ = strings.Cut(strings.Trim(bucket, "/"), "/")
+	return name, prefix
+}
+
+// bucketRoot is prefixedRoot for a bucket that may carry a prefix of its own, which comes before prefix
+func bucketRoot(bucket, prefix string) (string, error) {
+	name, bucketPrefix := splitBucket(bucket)
+	if bucketPrefix != "" {
+		if prefix = strings.Trim(prefix, "/"); prefix != "" {
+			prefix = bucketPrefix + "/" + prefix
+		} else {
+			prefix = bucketPrefix
+		}
+	}
+	return prefixedRoot(name, prefix)
+}
+
+// prefixedRoot returns the rclone root of the keys under prefix in bucket
+func prefixedRoot(bucket, prefix string) (string, error) {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return bucket, nil
+	}
+
+	for _, segment := range strings.Split(prefix, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", fmt.Errorf("invalid remote prefix %q", prefix)
+		}
+	}
+	if bucket == "" {
+		// e.g. relative to the home directory of sftp
+		return prefix, nil
+	}
+	return bucket + "/" + prefix, nil
+}
+
+func newR2Fs(ctx context.Context, cred *CloudflareR2Credentials, root string, extra map[string]string) (fs.Fs, error) {
+	if cred == nil {
+		return nil, fmt.Errorf("Cloudflare R2 credentials are required")
+	}
 
 	conf := &dictConfigStore{
 		config: make(map[string]string),
@@ -84,17 +150,20 @@ func NewR2Backend(ctx context.Context, cred *CloudflareR2Credentials) (fs.Fs, er
 	mopt.Set("endpoint", fmt.Sprintf("https://%s.r2.cloudflarestorage.com", cred.AccountID))
 	mopt.Set("region", "auto")
 	mopt.Set("no_check_bucket", "true")
-	mopt.Set("chunk_size", "500M")
-	mopt.Set("upload_cutoff", "500M")
-	mopt.Set("copy_cutoff", "500M")
+	mopt.Set("chunk_size", transferConfig.ChunkSize)
+	mopt.Set("upload_cutoff", transferConfig.UploadCutoff)
+	mopt.Set("copy_cutoff", transferConfig.CopyCutoff)
 	mopt.Set("acl", "private")
-	mopt.Set("memory_pool_flush_time", "1m")
-	mopt.Set("list_chunk", "1000")
+	mopt.Set("memory_pool_flush_time", transferConfig.MemoryPoolFlushTime)
+	mopt.Set("list_chunk", strconv.Itoa(transferConfig.ListChunk))
 	mopt.Set("force_path_style", "true")
-	mopt.Set("upload_concurrency", "4")
+	mopt.Set("upload_concurrency", strconv.Itoa(transferConfig.UploadConcurrency))
 	mopt.Set("max_upload_parts", "10000")
+	for k, v := range extra {
+		mopt.Set(k, v)
+	}
 
-	f, err := s3.NewFs(ctx, "r2:", cred.Bucket, mopt)
+	f, err := s3.NewFs(ctx, "r2:", root, mopt)
 	if err != nil {
 		return nil, err
 	}
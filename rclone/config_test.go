@@ -66,3 +66,48 @@ func TestNewR2Backend_WithRealCredentials(t *testing.T) {
 		t.Logf("Files listed successfully: %d files found", len(files))
 	}
 }
+
+func TestPrefixedRoot(t *testing.T) {
+	for _, tc := range []struct {
+		prefix, root string
+	}{
+		{"", "woc"},
+		{"campaign-2412", "woc/campaign-2412"},
+		{"/v2412/da5/", "woc/v2412/da5"},
+	} {
+		root, err := prefixedRoot("woc", tc.prefix)
+		if err != nil || root != tc.root {
+			t.Errorf("prefixedRoot(%q) = %q, %v, want %q", tc.prefix, root, err, tc.root)
+		}
+	}
+	if root, err := prefixedRoot("", "campaign-2412"); err != nil || root != "campaign-2412" {
+		t.Errorf("prefixedRoot without a bucket = %q, %v, want the prefix", root, err)
+	}
+	for _, prefix := range []string{"../other", "a//b", "a/./b"} {
+		if _, err := prefixedRoot("woc", prefix); err == nil {
+			t.Errorf("prefixedRoot(%q) should fail", prefix)
+		}
+	}
+}
+
+func TestBucketRoot(t *testing.T) {
+	for _, tc := range []struct {
+		bucket, prefix, root string
+	}{
+		{"woc", "run", "woc/run"},
+		{"woc/campaign-2412", "", "woc/campaign-2412"},
+		{"woc/campaign-2412/", "run/archive", "woc/campaign-2412/run/archive"},
+		{"/woc/a/b", "/run/", "woc/a/b/run"},
+	} {
+		root, err := bucketRoot(tc.bucket, tc.prefix)
+		if err != nil || root != tc.root {
+			t.Errorf("bucketRoot(%q, %q) = %q, %v, want %q", tc.bucket, tc.prefix, root, err, tc.root)
+		}
+	}
+	if _, err := bucketRoot("woc/../other", ""); err == nil {
+		t.Error("bucketRoot should fail for a bucket prefix leaving the prefix")
+	}
+	if name, prefix := splitBucket("woc/campaign-2412/da5"); name != "woc" || prefix != "campaign-2412/da5" {
+		t.Errorf("splitBucket = %q, %q", name, prefix)
+	}
+}
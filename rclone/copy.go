@@ -2,16 +2,56 @@ package rclone
 
 import (
 	"context"
+	"time"
 
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
 	"github.com/rclone/rclone/fs/sync"
 )
 
+// CopyFiles copies the files of the list from fsrc to fdst. It returns the result of every file rclone
+// copied, failed to copy or found unchanged in fdst, by name; the files missing from it weren't
+// attempted, e.g. because the copy failed early. The error is the error of the copy as a whole.
+// The results come from rclone's accounting, which forgets all but the last 100 completed transfers,
+// or 100000 after KeepCompletedTransfers. While StartRC serves, the transfers can be cancelled
+// with CancelTransfer.
 func CopyFiles(
 	ctx context.Context,
 	fsrc fs.Fs, fdst fs.Fs, files []string,
-) error {
+) (map[string]TransferResult, error) {
 	ctx = InjectConfig(ctx)
 	ctx = InjectFileList(ctx, files)
-	return sync.CopyDir(ctx, fdst, fsrc, false)
+	if _, ok := fsrc.(*metadataFs); ok {
+		var ci *fs.ConfigInfo
+		ctx, ci = fs.AddConfig(ctx)
+		ci.Metadata = true
+	}
+	fsrc, done := withCancel(fsrc, files)
+	defer done()
+	if s, ok := ctx.Value(softStopKey{}).(*SoftStop); ok {
+		s.watch(fs.GetConfig(ctx))
+	}
+	started := time.Now()
+	err := sync.CopyDir(ctx, fdst, fsrc, false)
+	return transferResults(files, started), err
+}
+
+// transferResults returns the last transfer of each of the files that started after since. A copy
+// wins over the check of the file that preceded it.
+func transferResults(files []string, since time.Time) map[string]TransferResult {
+	wanted := make(map[string]bool, len(files))
+	for _, name := range files {
+		wanted[name] = true
+	}
+	results := make(map[string]TransferResult)
+	for _, tr := range accounting.GlobalStats().Transferred() {
+		if !wanted[tr.Name] || tr.StartedAt.Before(since) {
+			continue
+		}
+		if _, ok := results[tr.Name]; ok && tr.Checked {
+			continue
+		}
+		results[tr.Name] = newTransferResult(tr)
+	}
+	return results
 }
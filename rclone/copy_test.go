@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
 	"github.com/stretchr/testify/require"
 )
 
@@ -52,7 +53,7 @@ func TestCopyFiles_WithR2Backend(t *testing.T) {
 	}
 
 	// Test copy to R2
-	err = CopyFiles(ctx, fsrc, fdst, []string{"r2_test.txt"})
+	_, err = CopyFiles(ctx, fsrc, fdst, []string{"r2_test.txt"})
 	if err != nil {
 		t.Logf("Copy to R2 failed (may be expected if credentials are test values): %v", err)
 	} else {
@@ -74,3 +75,58 @@ func TestCopyFiles_WithR2Backend(t *testing.T) {
 		t.Log("Test file successfully copied to R2 backend")
 	}
 }
+
+func TestCopyFiles_Results(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	for name, content := range map[string]string{"a.txt": "aaaa", "b.txt": "bb", "c.txt": "c"} {
+		require.NoError(t, os.WriteFile(filepath.Join(srcDir, name), []byte(content), 0644))
+	}
+	// b.txt can't be written over a directory, c.txt is in the destination already
+	require.NoError(t, os.MkdirAll(filepath.Join(dstDir, "b.txt", "x"), 0755))
+	ctx := context.Background()
+	fsrc, err := fs.NewFs(ctx, srcDir)
+	require.NoError(t, err)
+	fdst, err := fs.NewFs(ctx, dstDir)
+	require.NoError(t, err)
+	_, err = CopyFiles(ctx, fsrc, fdst, []string{"c.txt"})
+	require.NoError(t, err)
+
+	results, err := CopyFiles(ctx, fsrc, fdst, []string{"a.txt", "b.txt", "c.txt", "missing.txt"})
+	require.Error(t, err)
+	require.Len(t, results, 3)
+	require.True(t, results["a.txt"].Succeeded())
+	require.Equal(t, int64(4), results["a.txt"].Bytes)
+	require.False(t, results["a.txt"].Checked)
+	require.False(t, results["b.txt"].Succeeded())
+	require.True(t, results["c.txt"].Succeeded())
+	require.True(t, results["c.txt"].Checked)
+}
+
+func TestKeepCompletedTransfers(t *testing.T) {
+	oldMax, oldKept := accounting.MaxCompletedTransfers, maxKeptTransfers
+	defer func() { accounting.MaxCompletedTransfers, maxKeptTransfers = oldMax, oldKept }()
+	maxKeptTransfers = 2
+	KeepCompletedTransfers()
+	ResetTransferStats()
+	defer ResetTransferStats()
+
+	srcDir := t.TempDir()
+	var files []string
+	for i := 0; i < 12; i++ {
+		name := fmt.Sprintf("%d.txt", i)
+		require.NoError(t, os.WriteFile(filepath.Join(srcDir, name), []byte(name), 0644))
+		files = append(files, name)
+	}
+	ctx := context.Background()
+	fsrc, err := fs.NewFs(ctx, srcDir)
+	require.NoError(t, err)
+	fdst, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	_, err = CopyFiles(ctx, fsrc, fdst, files)
+	require.NoError(t, err)
+
+	// rclone keeps the transfers in flight on top of the bound
+	transfers := fs.GetConfig(InjectConfig(ctx)).Transfers
+	require.LessOrEqual(t, len(CompletedTransfers()), maxKeptTransfers+transfers)
+}
@@ -0,0 +1,84 @@
+package rclone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+)
+
+// DeleteSummary is the number and total size of the objects deleted by DeleteFiles
+type DeleteSummary struct {
+	Files int64
+	Bytes int64
+}
+
+// DeleteFiles deletes the objects names of f, up to workers at a time. The objects are looked up with one
+// listing per directory instead of a request each, and missing objects are skipped. It deletes every
+// object it can and returns the errors of the others, joined.
+func DeleteFiles(ctx context.Context, f fs.Fs, names []string, workers int) (DeleteSummary, error) {
+	byDir := make(map[string]map[string]bool)
+	for _, name := range names {
+		dir := path.Dir(name)
+		if dir == "." {
+			dir = ""
+		}
+		if byDir[dir] == nil {
+			byDir[dir] = make(map[string]bool)
+		}
+		byDir[dir][name] = true
+	}
+	var objects []fs.Object
+	for dir, wanted := range byDir {
+		entries, err := f.List(ctx, dir)
+		if errors.Is(err, fs.ErrorDirNotFound) {
+			continue
+		} else if err != nil {
+			return DeleteSummary{}, fmt.Errorf("failed to list the objects to delete: %w", err)
+		}
+		for _, entry := range entries {
+			if o, ok := entry.(fs.Object); ok && wanted[o.Remote()] {
+				objects = append(objects, o)
+			}
+		}
+	}
+	return deleteObjects(ctx, objects, workers)
+}
+
+// deleteObjects deletes the objects, up to workers at a time
+func deleteObjects(ctx context.Context, objects []fs.Object, workers int) (DeleteSummary, error) {
+	var (
+		summary DeleteSummary
+		errs    []error
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+	)
+	jobs := make(chan fs.Object)
+	for range max(1, min(workers, len(objects))) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for o := range jobs {
+				err := operations.DeleteFile(ctx, o)
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, fmt.Errorf("failed to delete %s: %w", o.Remote(), err))
+				} else {
+					summary.Files++
+					summary.Bytes += o.Size()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, o := range objects {
+		jobs <- o
+	}
+	close(jobs)
+	wg.Wait()
+	return summary, errors.Join(errs...)
+}
@@ -0,0 +1,35 @@
+package rclone
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	for name, content := range map[string]string{"a.bin": "aaaa", "b.bin": "bb", "keep.bin": "k", "sub/c.bin": "c"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, dir)
+	require.NoError(t, err)
+
+	summary, err := DeleteFiles(ctx, f, []string{"a.bin", "b.bin", "sub/c.bin", "missing.bin", "gone/d.bin"}, 2)
+	require.NoError(t, err)
+	assert.Equal(t, DeleteSummary{Files: 3, Bytes: 7}, summary)
+	for _, name := range []string{"a.bin", "b.bin", "sub/c.bin"} {
+		assert.NoFileExists(t, filepath.Join(dir, name))
+	}
+	assert.FileExists(t, filepath.Join(dir, "keep.bin"))
+
+	summary, err = DeleteFiles(ctx, f, nil, 2)
+	require.NoError(t, err)
+	assert.Zero(t, summary)
+}
@@ -2,6 +2,7 @@ package rclone
 
 import (
 	"context"
+	"time"
 
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/operations"
@@ -10,12 +11,26 @@ import (
 type RcloneFileInfo struct {
 	Name string
 	Size int64
+	// ETag is the MD5 of the object, only set by StatFiles
+	ETag string
+	// ModTime is the modification time of the object, only set by ListFilesWithModTime
+	ModTime time.Time
 }
 
 func ListFiles(ctx context.Context, f fs.Fs) ([]RcloneFileInfo, error) {
+	return listFiles(ctx, f, false)
+}
+
+// ListFilesWithModTime is ListFiles with the modification times of the objects, which is the time of the
+// upload on R2 with use_server_modtime. Other backends may read the time of each object separately.
+func ListFilesWithModTime(ctx context.Context, f fs.Fs) ([]RcloneFileInfo, error) {
+	return listFiles(ctx, f, true)
+}
+
+func listFiles(ctx context.Context, f fs.Fs, modTime bool) ([]RcloneFileInfo, error) {
 	var fileInfos []RcloneFileInfo
 	var opt = operations.ListJSONOpt{
-		NoModTime:  true,
+		NoModTime:  !modTime,
 		NoMimeType: true,
 		DirsOnly:   false,
 		FilesOnly:  true,
@@ -26,8 +41,9 @@ func ListFiles(ctx context.Context, f fs.Fs) ([]RcloneFileInfo, error) {
 			return nil // Skip directories
 		}
 		fileInfos = append(fileInfos, RcloneFileInfo{
-			Name: item.Path,
-			Size: item.Size,
+			Name:    item.Path,
+			Size:    item.Size,
+			ModTime: item.ModTime.When,
 		})
 		return nil
 	})
@@ -62,6 +62,18 @@ func TestListFiles_WithLocalBackend(t *testing.T) {
 		info, found := foundFiles[tf.name]
 		require.True(t, found, "File %s not found in listing", tf.name)
 		require.Equal(t, int64(len(tf.content)), info.Size, "Size mismatch for file %s", tf.name)
+		require.True(t, info.ModTime.IsZero(), "ListFiles doesn't read the time of %s", tf.name)
+	}
+
+	fileInfos, err = ListFilesWithModTime(ctx, fsrc)
+	require.NoError(t, err)
+	require.Len(t, fileInfos, len(testFiles))
+	for _, info := range fileInfos {
+		for _, tf := range testFiles {
+			if tf.name == info.Name {
+				require.WithinDuration(t, tf.modTime, info.ModTime, time.Second, "ModTime mismatch for file %s", tf.name)
+			}
+		}
 	}
 }
 
@@ -122,7 +134,7 @@ func TestListFiles_WithR2Backend(t *testing.T) {
 	fsrc, err := fs.NewFs(ctx, srcDir)
 	require.NoError(t, err)
 
-	err = CopyFiles(ctx, fsrc, fdst, []string{testFileName})
+	_, err = CopyFiles(ctx, fsrc, fdst, []string{testFileName})
 	if err != nil {
 		t.Skipf("Failed to upload test file to R2 (may be expected if credentials are test values): %v", err)
 	}
@@ -0,0 +1,66 @@
+package rclone
+
+import (
+	"context"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// metadataFs is a source fs whose objects report the metadata of a callback, see WithMetadata
+type metadataFs struct {
+	fs.Fs
+	metadata func(remote string) map[string]string
+}
+
+// metadataObject is an object of a metadataFs
+type metadataObject struct {
+	fs.Object
+	metadata map[string]string
+}
+
+// WithMetadata returns f with objects that report metadata(remote) as their metadata instead of their own,
+// so CopyFiles attaches it to the objects it uploads from f
+func WithMetadata(f fs.Fs, metadata func(remote string) map[string]string) fs.Fs {
+	return &metadataFs{Fs: f, metadata: metadata}
+}
+
+func (f *metadataFs) wrap(o fs.Object) fs.Object {
+	return &metadataObject{Object: o, metadata: f.metadata(o.Remote())}
+}
+
+// List lists the directory dir of f, with the objects wrapped
+func (f *metadataFs) List(ctx context.Context, dir string) (fs.DirEntries, error) {
+	entries, err := f.Fs.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	for i, entry := range entries {
+		if o, ok := entry.(fs.Object); ok {
+			entries[i] = f.wrap(o)
+		}
+	}
+	return entries, nil
+}
+
+// NewObject returns the wrapped object remote of f
+func (f *metadataFs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	o, err := f.Fs.NewObject(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.wrap(o), nil
+}
+
+// Metadata returns the metadata of the callback of WithMetadata
+func (o *metadataObject) Metadata(ctx context.Context) (fs.Metadata, error) {
+	return o.metadata, nil
+}
+
+// ReadMetadata returns the metadata of the object remote of f, nil if f doesn't support metadata
+func ReadMetadata(ctx context.Context, f fs.Fs, remote string) (map[string]string, error) {
+	obj, err := f.NewObject(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	return fs.GetMetadata(ctx, obj)
+}
@@ -0,0 +1,41 @@
+package rclone
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithMetadata(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "a.bin"), []byte("aaaa"), 0644))
+	ctx := context.Background()
+	fsrc, err := fs.NewFs(ctx, srcDir)
+	require.NoError(t, err)
+	fdst, err := fs.NewFs(ctx, dstDir)
+	require.NoError(t, err)
+	if !fdst.Features().UserMetadata {
+		t.Skip("the filesystem of the temporary directory has no extended attributes")
+	}
+
+	wrapped := WithMetadata(fsrc, func(remote string) map[string]string {
+		return map[string]string{"syncmate-source-path": "/data/" + remote}
+	})
+	obj, err := wrapped.NewObject(ctx, "a.bin")
+	require.NoError(t, err)
+	metadata, err := fs.GetMetadata(ctx, obj)
+	require.NoError(t, err)
+	assert.Equal(t, fs.Metadata{"syncmate-source-path": "/data/a.bin"}, metadata)
+
+	_, err = CopyFiles(ctx, wrapped, fdst, []string{"a.bin"})
+	require.NoError(t, err)
+	uploaded, err := ReadMetadata(ctx, fdst, "a.bin")
+	require.NoError(t, err)
+	assert.Equal(t, "/data/a.bin", uploaded["syncmate-source-path"])
+}
@@ -0,0 +1,67 @@
+package rclone
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
+)
+
+// PutFile writes data to remote in f, replacing an existing object
+func PutFile(ctx context.Context, f fs.Fs, remote string, data []byte) error {
+	_, err := operations.Rcat(ctx, f, remote, io.NopCloser(bytes.NewReader(data)), time.Now(), nil)
+	return err
+}
+
+// StatFiles returns the size and ETag of the objects names in f, by name. Missing objects are left out.
+// The ETag is the MD5 rclone reports for the object, which is the S3 ETag of objects uploaded in one part
+// and the MD5 rclone stores in the metadata of multipart uploads. It is empty if f doesn't know it.
+func StatFiles(ctx context.Context, f fs.Fs, names []string) (map[string]RcloneFileInfo, error) {
+	infos := make(map[string]RcloneFileInfo, len(names))
+	for _, name := range names {
+		obj, err := f.NewObject(ctx, name)
+		if errors.Is(err, fs.ErrorObjectNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		etag, err := obj.Hash(ctx, hash.MD5)
+		if err != nil && !errors.Is(err, hash.ErrUnsupported) {
+			return nil, err
+		}
+		infos[name] = RcloneFileInfo{Name: name, Size: obj.Size(), ETag: etag}
+	}
+	return infos, nil
+}
+
+// PresignURL returns a URL that downloads remote in f without credentials until it expires, a presigned
+// GET URL for S3 and R2. It fails if f can't make links or remote doesn't exist.
+func PresignURL(ctx context.Context, f fs.Fs, remote string, expires time.Duration) (string, error) {
+	if f.Features().PublicLink == nil {
+		return "", fmt.Errorf("%s can't make links to its objects", fs.ConfigString(f))
+	}
+	if _, err := f.NewObject(ctx, remote); err != nil {
+		return "", err
+	}
+	return operations.PublicLink(ctx, f, remote, fs.Duration(expires), false)
+}
+
+// ReadFile returns the content of remote in f
+func ReadFile(ctx context.Context, f fs.Fs, remote string) ([]byte, error) {
+	obj, err := f.NewObject(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	in, err := obj.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	return io.ReadAll(in)
+}
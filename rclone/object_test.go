@@ -0,0 +1,37 @@
+package rclone
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPutReadFile(t *testing.T) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, PutFile(ctx, f, "blob_0.bin.syncmate.json", []byte(`{"mode":"append"}`)))
+	require.NoError(t, PutFile(ctx, f, "blob_0.bin.syncmate.json", []byte(`{"mode":"overwrite"}`)))
+	data, err := ReadFile(ctx, f, "blob_0.bin.syncmate.json")
+	require.NoError(t, err)
+	assert.Equal(t, `{"mode":"overwrite"}`, string(data))
+
+	_, err = ReadFile(ctx, f, "missing.json")
+	assert.ErrorIs(t, err, fs.ErrorObjectNotFound)
+}
+
+func TestStatFiles(t *testing.T) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, PutFile(ctx, f, "blob_0.bin", []byte("0123456789")))
+
+	infos, err := StatFiles(ctx, f, []string{"blob_0.bin", "missing.bin"})
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, RcloneFileInfo{Name: "blob_0.bin", Size: 10, ETag: "781e5e245d69b566979b86e28d23f2c7"}, infos["blob_0.bin"])
+}
@@ -0,0 +1,132 @@
+package rclone
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	logger "github.com/sirupsen/logrus"
+)
+
+// Profile is how the copies of a command report to the user, see SetProfile
+type Profile struct {
+	// Progress shows the live progress display of the transfers, for interactive terminals
+	Progress bool
+	// LogLevel is the level of the logs of rclone, which BridgeLogs passes to the syncmate logger
+	LogLevel fs.LogLevel
+	// StatsInterval is how often the stats of the transfers are logged without the progress display, never if 0
+	StatsInterval time.Duration
+}
+
+// Profiles of runs in an interactive terminal and of batch runs, e.g. from cron
+var (
+	InteractiveProfile = Profile{Progress: true, LogLevel: fs.LogLevelInfo}
+	BatchProfile       = Profile{LogLevel: fs.LogLevelNotice, StatsInterval: 5 * time.Minute}
+)
+
+// profile is the profile of the copies, see SetProfile
+var profile = InteractiveProfile
+
+// SetProfile makes the copies started afterwards report like p. rclone checks the log level of
+// its global config, so it is set there too.
+func SetProfile(p Profile) {
+	profile = p
+	fs.GetConfig(context.Background()).LogLevel = p.LogLevel
+}
+
+// startStatsLog logs the stats of the transfers every interval
+//
+// It returns a func which should be called to stop the logging.
+func startStatsLog(interval time.Duration) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				accounting.GlobalStats().Log()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// logrusHandler is a slog handler that passes the records to the syncmate logger
+type logrusHandler struct {
+	attrs []slog.Attr
+}
+
+// BridgeLogs passes the logs of rclone, and of the standard log package, to the syncmate logger
+func BridgeLogs() {
+	slog.SetDefault(slog.New(logrusHandler{}))
+	// not through the handler, which labels the records as rclone's
+	log.SetFlags(0)
+	log.SetOutput(stdlogWriter{})
+}
+
+// stdlogWriter logs the lines of the standard log package, e.g. of offsetfs, with the syncmate logger.
+// The standard logger has no levels and is only used for errors, e.g. of reading the source files
+// behind the FUSE mount, so they are warnings.
+type stdlogWriter struct{}
+
+func (stdlogWriter) Write(p []byte) (int, error) {
+	logger.Warn(strings.TrimSpace(string(p)))
+	return len(p), nil
+}
+
+// logrusLevel maps a slog level, including those rclone adds, to a logrus level
+func logrusLevel(level slog.Level) logger.Level {
+	switch {
+	case level >= slog.LevelError:
+		return logger.ErrorLevel
+	case level >= slog.LevelWarn:
+		return logger.WarnLevel
+	case level >= slog.LevelInfo:
+		// rclone's NOTICE is between INFO and WARN
+		return logger.InfoLevel
+	default:
+		return logger.DebugLevel
+	}
+}
+
+func (h logrusHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return logger.IsLevelEnabled(logrusLevel(level))
+}
+
+func (h logrusHandler) Handle(_ context.Context, r slog.Record) error {
+	fields := logger.Fields{"component": "rclone"}
+	add := func(a slog.Attr) bool {
+		// the type of the object is noise in text logs
+		if a.Key != "objectType" {
+			fields[a.Key] = a.Value.Any()
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(add)
+	logger.WithFields(fields).Log(logrusLevel(r.Level), strings.TrimSpace(r.Message))
+	return nil
+}
+
+func (h logrusHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return logrusHandler{attrs: append(append([]slog.Attr(nil), h.attrs...), attrs...)}
+}
+
+func (h logrusHandler) WithGroup(name string) slog.Handler {
+	// rclone doesn't group its attributes
+	return h
+}
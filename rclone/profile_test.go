@@ -0,0 +1,62 @@
+package rclone
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	logger "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetProfile(t *testing.T) {
+	defer SetProfile(InteractiveProfile)
+
+	ci := fs.GetConfig(InjectConfig(context.Background()))
+	assert.True(t, ci.Progress)
+	assert.Equal(t, fs.LogLevelInfo, ci.LogLevel)
+
+	SetProfile(Profile{LogLevel: fs.LogLevelError, StatsInterval: time.Minute})
+	ci = fs.GetConfig(InjectConfig(context.Background()))
+	assert.False(t, ci.Progress)
+	assert.Equal(t, fs.LogLevelError, ci.LogLevel)
+	assert.Equal(t, fs.LogLevelError, ci.StatsLogLevel)
+	assert.Equal(t, fs.LogLevelError, fs.GetConfig(context.Background()).LogLevel)
+}
+
+func TestBridgeLogs(t *testing.T) {
+	oldDefault := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(oldDefault) })
+	var out bytes.Buffer
+	oldOut, oldLevel := logger.StandardLogger().Out, logger.GetLevel()
+	t.Cleanup(func() {
+		logger.SetOutput(oldOut)
+		logger.SetLevel(oldLevel)
+	})
+	logger.SetOutput(&out)
+	logger.SetLevel(logger.InfoLevel)
+	defer SetProfile(InteractiveProfile)
+	SetProfile(Profile{LogLevel: fs.LogLevelDebug})
+
+	BridgeLogs()
+	fs.Logf("a.txt", "Copied (new)")
+	fs.Debugf(nil, "hidden below the level of the logger")
+	fs.Errorf(nil, "failed\n")
+	assert.Contains(t, out.String(), `level=info msg="Copied (new)" component=rclone object=a.txt`)
+	assert.Contains(t, out.String(), `level=error msg=failed component=rclone`)
+	assert.NotContains(t, out.String(), "hidden")
+	assert.NotContains(t, out.String(), "objectType")
+
+	oldFlags := log.Flags()
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(oldFlags)
+	})
+	log.Printf("Error opening source file: %v", os.ErrNotExist)
+	assert.Contains(t, out.String(), `level=warning msg="Error opening source file: file does not exist"`+"\n")
+}
@@ -4,21 +4,76 @@ import (
 	"bytes"
 	"fmt"
 	"log/slog"
+	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/accounting"
 	"github.com/rclone/rclone/fs/log"
 	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/rc"
 	"github.com/rclone/rclone/lib/terminal"
+	logger "github.com/sirupsen/logrus"
 )
 
 const (
 	// interval between progress prints
 	defaultProgressInterval = 1 * time.Second
+	// width of the per-file progress bars
+	progressBarWidth = 20
 )
 
+// ProgressItem is a transfer outside of rclone shown in the progress display,
+// e.g. a downloaded file being placed by MoveFile.
+type ProgressItem struct {
+	name  string
+	size  int64
+	bytes atomic.Int64
+	start time.Time
+}
+
+var (
+	progressItemsMu sync.Mutex
+	progressItems   []*ProgressItem
+)
+
+// AddProgressItem registers a transfer of size bytes that is displayed until Done is called.
+func AddProgressItem(name string, size int64) *ProgressItem {
+	item := &ProgressItem{name: name, size: size, start: time.Now()}
+	progressItemsMu.Lock()
+	defer progressItemsMu.Unlock()
+	progressItems = append(progressItems, item)
+	return item
+}
+
+// Set records the number of bytes transferred so far
+func (p *ProgressItem) Set(bytes int64) {
+	p.bytes.Store(bytes)
+}
+
+// Done removes the item from the progress display
+func (p *ProgressItem) Done() {
+	progressItemsMu.Lock()
+	defer progressItemsMu.Unlock()
+	for i, item := range progressItems {
+		if item == p {
+			progressItems = append(progressItems[:i], progressItems[i+1:]...)
+			return
+		}
+	}
+}
+
+// progressLogWriter prints logrus output above the progress block instead of through it
+type progressLogWriter struct{}
+
+func (progressLogWriter) Write(p []byte) (int, error) {
+	printProgress(string(p))
+	return len(p), nil
+}
+
 // startProgress starts the progress bar printing
 //
 // It returns a func which should be called to stop the stats.
@@ -38,6 +93,12 @@ func startProgress() func() {
 		printProgress(fmt.Sprintf(format, a...))
 	}
 
+	// Intercept syncmate's own logs as well, unless they go to a file
+	oldLogOutput := logger.StandardLogger().Out
+	if oldLogOutput == os.Stderr {
+		logger.SetOutput(progressLogWriter{})
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
@@ -56,6 +117,7 @@ func startProgress() func() {
 					log.Handler.ResetOutput()
 				}
 				operations.SyncPrintf = oldSyncPrint
+				logger.SetOutput(oldLogOutput)
 				fmt.Println("")
 				return
 			}
@@ -79,7 +141,7 @@ func printProgress(logMessage string) {
 
 	var buf bytes.Buffer
 	w, _ := terminal.GetSize()
-	stats := strings.TrimSpace(accounting.GlobalStats().String())
+	stats := renderStats()
 	logMessage = strings.TrimSpace(logMessage)
 
 	out := func(s string) {
@@ -114,3 +176,90 @@ func printProgress(logMessage string) {
 	}
 	terminal.Write(buf.Bytes())
 }
+
+// renderBar draws a progress bar for bytes out of size
+func renderBar(bytes, size int64) string {
+	filled := 0
+	if size > 0 {
+		filled = int(int64(progressBarWidth) * min(bytes, size) / size)
+	}
+	return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled) + "]"
+}
+
+// renderETA formats a remaining duration in seconds as reported by rclone's stats
+func renderETA(eta any) string {
+	seconds, ok := eta.(float64)
+	if !ok {
+		return "-"
+	}
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+// renderTransfer renders one line of the per-file section
+func renderTransfer(name string, bytes, size int64, speed float64, eta any) string {
+	percent := 0
+	if size > 0 {
+		percent = int(100 * bytes / size)
+	}
+	return fmt.Sprintf(" * %s %s %3d%% %s/%s, %s, ETA %s",
+		name, renderBar(bytes, size), percent,
+		fs.SizeSuffix(bytes).ByteUnit(), fs.SizeSuffix(size).ByteUnit(),
+		fs.SizeSuffix(int64(speed)).ByteRateUnit(), renderETA(eta))
+}
+
+// renderStats renders the overall progress, one bar per rclone transfer and one bar per ProgressItem
+func renderStats() string {
+	stats, err := accounting.GlobalStats().RemoteStats(false)
+	if err != nil {
+		return strings.TrimSpace(accounting.GlobalStats().String())
+	}
+	getInt := func(p rc.Params, key string) int64 {
+		v, _ := p[key].(int64)
+		return v
+	}
+	getFloat := func(p rc.Params, key string) float64 {
+		v, _ := p[key].(float64)
+		return v
+	}
+
+	var lines []string
+	bytes, totalBytes := getInt(stats, "bytes"), getInt(stats, "totalBytes")
+	percent := 0
+	if totalBytes > 0 {
+		percent = int(100 * bytes / totalBytes)
+	}
+	lines = append(lines, fmt.Sprintf("Transferred: %s / %s, %d%%, %s, ETA %s",
+		fs.SizeSuffix(bytes).ByteUnit(), fs.SizeSuffix(totalBytes).ByteUnit(), percent,
+		fs.SizeSuffix(int64(getFloat(stats, "speed"))).ByteRateUnit(), renderETA(stats["eta"])))
+	lines = append(lines, fmt.Sprintf("Files:       %d / %d, errors: %d, elapsed: %s",
+		getInt(stats, "transfers"), getInt(stats, "totalTransfers"), getInt(stats, "errors"),
+		(time.Duration(getFloat(stats, "elapsedTime"))*time.Second).String()))
+
+	if transferring, ok := stats["transferring"].([]rc.Params); ok && len(transferring) > 0 {
+		lines = append(lines, "Transferring:")
+		for _, tr := range transferring {
+			name, _ := tr["name"].(string)
+			lines = append(lines, renderTransfer(name, getInt(tr, "bytes"), getInt(tr, "size"), getFloat(tr, "speed"), tr["eta"]))
+		}
+	}
+
+	progressItemsMu.Lock()
+	items := append([]*ProgressItem(nil), progressItems...)
+	progressItemsMu.Unlock()
+	if len(items) > 0 {
+		lines = append(lines, "Placing:")
+		for _, item := range items {
+			copied := item.bytes.Load()
+			var speed float64
+			var eta any
+			if elapsed := time.Since(item.start).Seconds(); elapsed > 0 {
+				speed = float64(copied) / elapsed
+			}
+			if speed > 0 {
+				eta = float64(item.size-copied) / speed
+			}
+			lines = append(lines, renderTransfer(item.name, copied, item.size, speed, eta))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,30 @@
+package rclone
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderBar(t *testing.T) {
+	require.Equal(t, "["+strings.Repeat(" ", progressBarWidth)+"]", renderBar(0, 100))
+	require.Equal(t, "["+strings.Repeat("=", progressBarWidth/2)+strings.Repeat(" ", progressBarWidth/2)+"]", renderBar(50, 100))
+	require.Equal(t, "["+strings.Repeat("=", progressBarWidth)+"]", renderBar(200, 100))
+	// unknown size
+	require.Equal(t, "["+strings.Repeat(" ", progressBarWidth)+"]", renderBar(10, 0))
+}
+
+func TestProgressItems(t *testing.T) {
+	item := AddProgressItem("blob_0.bin", 1000)
+	item.Set(500)
+
+	stats := renderStats()
+	require.Contains(t, stats, "Transferred:")
+	require.Contains(t, stats, "Placing:")
+	require.Contains(t, stats, "blob_0.bin")
+	require.Contains(t, stats, " 50%")
+
+	item.Done()
+	require.NotContains(t, renderStats(), "blob_0.bin")
+}
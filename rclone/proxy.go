@@ -0,0 +1,57 @@
+package rclone
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// ProxyConfig is the "proxy" section of config.json, the proxy of the HTTP connections to the bucket and
+// the database. Without it, the HTTPS_PROXY, HTTP_PROXY and NO_PROXY variables of the environment apply.
+type ProxyConfig struct {
+	// URL is the proxy of HTTP and HTTPS connections, e.g. "http://proxy:3128" or "socks5://proxy:1080"
+	URL string `json:"url"`
+	// NoProxy are the hosts connected to directly, comma separated like NO_PROXY, e.g. "localhost,.internal"
+	NoProxy string `json:"no_proxy,omitempty"`
+}
+
+// proxySchemes are the schemes of proxy URLs that Go's HTTP transport supports
+var proxySchemes = map[string]bool{"http": true, "https": true, "socks5": true, "socks5h": true}
+
+// Validate checks the URL of the proxy
+func (c *ProxyConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url %q: %w", c.URL, err)
+	}
+	if !proxySchemes[u.Scheme] || u.Host == "" {
+		return fmt.Errorf("url must be like http://host:port or socks5://host:port, got %q", c.URL)
+	}
+	return nil
+}
+
+// SetProxy routes the HTTP connections of the process through the proxy of c, or leaves the proxy of the
+// environment if c is nil. The hosts of NO_PROXY of the environment are kept if c has none. rclone's transport reads the proxy from the environment, and Go reads it only
+// once, so SetProxy must be called before the first connection.
+func SetProxy(c *ProxyConfig) error {
+	if c == nil {
+		return nil
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	// Go prefers the upper case variables to the lower case ones
+	env := map[string]string{"HTTPS_PROXY": c.URL, "HTTP_PROXY": c.URL}
+	if c.NoProxy != "" {
+		env["NO_PROXY"] = c.NoProxy
+	}
+	for name, value := range env {
+		if err := os.Setenv(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
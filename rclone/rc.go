@@ -0,0 +1,314 @@
+package rclone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fs/rc/rcserver"
+	libhttp "github.com/rclone/rclone/lib/http"
+)
+
+// RCOptions configures the remote control server of StartRC
+type RCOptions struct {
+	// Addr is the address to listen on, e.g. "localhost:5572"
+	Addr string
+	// User and Pass enable basic auth, which the calls that change the config or the remotes require
+	User string
+	Pass string
+	// Files is a directory served on the paths that aren't rc calls, e.g. a web UI, if not empty
+	Files string
+}
+
+// StartRC serves rclone's remote control API, so the standard rclone tooling can query the stats of the
+// transfers of CopyFiles with core/stats, change the bandwidth limit with core/bwlimit, cancel a
+// transfer with syncmate/cancel and pause them with syncmate/pause. It returns a func which stops the
+// server.
+func StartRC(ctx context.Context, opt RCOptions) (func(), error) {
+	rcOpt := rc.Options{
+		HTTP:              libhttp.DefaultCfg(),
+		Auth:              libhttp.DefaultAuthCfg(),
+		Template:          libhttp.DefaultTemplateCfg(),
+		Enabled:           true,
+		JobExpireDuration: time.Minute,
+		JobExpireInterval: 10 * time.Second,
+	}
+	rcOpt.HTTP.ListenAddr = []string{opt.Addr}
+	rcOpt.Auth.BasicUser = opt.User
+	rcOpt.Auth.BasicPass = opt.Pass
+	rcOpt.Files = opt.Files
+	server, err := rcserver.Start(ctx, &rcOpt)
+	if err != nil {
+		return nil, err
+	}
+	enableCancel()
+	return func() {
+		disableCancel()
+		ResumeTransfers()
+		_ = server.Shutdown()
+	}, nil
+}
+
+// ErrTransferCancelled is the error of a transfer cancelled with the rc call syncmate/cancel
+var ErrTransferCancelled = errors.New("transfer cancelled through the rc API")
+
+// cancelFs is a source fs of CopyFiles whose transfers can be cancelled by name, see CancelTransfer
+type cancelFs struct {
+	fs.Fs
+	mu        sync.Mutex
+	files     map[string]bool
+	cancelled map[string]bool
+}
+
+// cancelObject is an object of a cancelFs
+type cancelObject struct {
+	fs.Object
+	f *cancelFs
+}
+
+// cancelReader fails the reads of an object once its transfer is cancelled
+type cancelReader struct {
+	io.ReadCloser
+	o *cancelObject
+}
+
+// pausePollInterval is how often a paused read checks whether its transfer was cancelled
+var pausePollInterval = time.Second
+
+// pause holds the reads of the transfers while resumed isn't nil, see PauseTransfers
+var pause struct {
+	mu      sync.Mutex
+	resumed chan struct{}
+}
+
+// PauseTransfers holds the reads of the transfers of CopyFiles started while an rc server is running
+// until ResumeTransfers. It returns false if they are already paused.
+func PauseTransfers() bool {
+	pause.mu.Lock()
+	defer pause.mu.Unlock()
+	if pause.resumed != nil {
+		return false
+	}
+	pause.resumed = make(chan struct{})
+	return true
+}
+
+// ResumeTransfers continues the transfers paused by PauseTransfers. It returns false if they aren't paused.
+func ResumeTransfers() bool {
+	pause.mu.Lock()
+	defer pause.mu.Unlock()
+	if pause.resumed == nil {
+		return false
+	}
+	close(pause.resumed)
+	pause.resumed = nil
+	return true
+}
+
+// TransfersPaused reports whether the transfers are paused
+func TransfersPaused() bool {
+	return resumedChan() != nil
+}
+
+// resumedChan returns a channel closed when the transfers are resumed, nil if they aren't paused
+func resumedChan() chan struct{} {
+	pause.mu.Lock()
+	defer pause.mu.Unlock()
+	return pause.resumed
+}
+
+// WaitResumed waits until the transfers aren't paused or ctx is done
+func WaitResumed(ctx context.Context) error {
+	if resumed := resumedChan(); resumed != nil {
+		select {
+		case <-resumed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// copies are the sources of the CopyFiles in progress, nil unless an rc server is running
+var copies struct {
+	mu      sync.Mutex
+	sources map[*cancelFs]bool
+}
+
+func enableCancel() {
+	copies.mu.Lock()
+	defer copies.mu.Unlock()
+	copies.sources = make(map[*cancelFs]bool)
+}
+
+func disableCancel() {
+	copies.mu.Lock()
+	defer copies.mu.Unlock()
+	copies.sources = nil
+}
+
+// withCancel returns f with transfers of the files that CancelTransfer can cancel, and a func that
+// forgets it when the copy is done. It returns f unchanged if no rc server is running.
+func withCancel(f fs.Fs, files []string) (fs.Fs, func()) {
+	copies.mu.Lock()
+	defer copies.mu.Unlock()
+	if copies.sources == nil {
+		return f, func() {}
+	}
+	cf := &cancelFs{Fs: f, files: make(map[string]bool, len(files)), cancelled: make(map[string]bool)}
+	for _, name := range files {
+		cf.files[name] = true
+	}
+	copies.sources[cf] = true
+	return cf, func() {
+		copies.mu.Lock()
+		defer copies.mu.Unlock()
+		delete(copies.sources, cf)
+	}
+}
+
+// CancelTransfer makes the transfer of the file name fail with ErrTransferCancelled at its next read.
+// It returns false if no CopyFiles in progress copies the file.
+func CancelTransfer(name string) bool {
+	copies.mu.Lock()
+	defer copies.mu.Unlock()
+	found := false
+	for cf := range copies.sources {
+		cf.mu.Lock()
+		if cf.files[name] {
+			cf.cancelled[name] = true
+			found = true
+		}
+		cf.mu.Unlock()
+	}
+	return found
+}
+
+func (f *cancelFs) isCancelled(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cancelled[name]
+}
+
+// NewObject returns the wrapped object remote of f
+func (f *cancelFs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	o, err := f.Fs.NewObject(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	return &cancelObject{Object: o, f: f}, nil
+}
+
+// List lists the directory dir of f, with the objects wrapped
+func (f *cancelFs) List(ctx context.Context, dir string) (fs.DirEntries, error) {
+	entries, err := f.Fs.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	for i, entry := range entries {
+		if o, ok := entry.(fs.Object); ok {
+			entries[i] = &cancelObject{Object: o, f: f}
+		}
+	}
+	return entries, nil
+}
+
+func (o *cancelObject) cancelled() error {
+	if o.f.isCancelled(o.Remote()) {
+		// retrying would read the cancelled file again
+		return fserrors.NoRetryError(ErrTransferCancelled)
+	}
+	return nil
+}
+
+// Open opens the object for a read that fails once the transfer is cancelled
+func (o *cancelObject) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	if err := o.cancelled(); err != nil {
+		return nil, err
+	}
+	in, err := o.Object.Open(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &cancelReader{ReadCloser: in, o: o}, nil
+}
+
+// Metadata returns the metadata of the wrapped object, e.g. of a metadataFs
+func (o *cancelObject) Metadata(ctx context.Context) (fs.Metadata, error) {
+	return fs.GetMetadata(ctx, o.Object)
+}
+
+func (r *cancelReader) Read(p []byte) (int, error) {
+	for {
+		if err := r.o.cancelled(); err != nil {
+			return 0, err
+		}
+		resumed := resumedChan()
+		if resumed == nil {
+			break
+		}
+		// a paused transfer can still be cancelled
+		select {
+		case <-resumed:
+		case <-time.After(pausePollInterval):
+		}
+	}
+	return r.ReadCloser.Read(p)
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "syncmate/cancel",
+		Fn:    rcCancel,
+		Title: "Cancel a transfer of syncmate",
+		Help: `This takes the following parameters:
+
+- name - the name of the file, as in the "transferring" list of core/stats
+
+The transfer fails at its next read and is retried by the next run. It is an error if the file isn't
+being copied.
+`,
+	})
+	rc.Add(rc.Call{
+		Path:         "syncmate/pause",
+		Fn:           rcPause,
+		Title:        "Pause the transfers of syncmate",
+		AuthRequired: true,
+		Help: `The transfers stop at their next read until syncmate/resume, and a daemon doesn't start
+its next cycle. This takes no parameters and returns "paused", false if they were paused already.
+`,
+	})
+	rc.Add(rc.Call{
+		Path:         "syncmate/resume",
+		Fn:           rcResume,
+		Title:        "Resume the transfers of syncmate paused by syncmate/pause",
+		AuthRequired: true,
+		Help: `This takes no parameters and returns "resumed", false if the transfers weren't paused.
+`,
+	})
+}
+
+func rcCancel(ctx context.Context, in rc.Params) (rc.Params, error) {
+	name, err := in.GetString("name")
+	if err != nil {
+		return nil, err
+	}
+	if !CancelTransfer(name) {
+		return nil, fmt.Errorf("%s is not being transferred", name)
+	}
+	return rc.Params{}, nil
+}
+
+func rcPause(ctx context.Context, in rc.Params) (rc.Params, error) {
+	return rc.Params{"paused": PauseTransfers()}, nil
+}
+
+func rcResume(ctx context.Context, in rc.Params) (rc.Params, error) {
+	return rc.Params{"resumed": ResumeTransfers()}, nil
+}
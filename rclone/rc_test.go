@@ -0,0 +1,141 @@
+package rclone
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCancelTransfer(t *testing.T) {
+	srcDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("aaaa"), 0644))
+	ctx := context.Background()
+	fsrc, err := fs.NewFs(ctx, srcDir)
+	require.NoError(t, err)
+
+	// without an rc server, the source isn't wrapped
+	f, done := withCancel(fsrc, []string{"a.txt"})
+	done()
+	assert.Equal(t, fsrc, f)
+
+	enableCancel()
+	t.Cleanup(disableCancel)
+	f, done = withCancel(fsrc, []string{"a.txt"})
+	obj, err := f.NewObject(ctx, "a.txt")
+	require.NoError(t, err)
+	in, err := obj.Open(ctx)
+	require.NoError(t, err)
+	defer in.Close()
+	buf := make([]byte, 2)
+	_, err = in.Read(buf)
+	require.NoError(t, err)
+
+	assert.False(t, CancelTransfer("b.txt"))
+	assert.True(t, CancelTransfer("a.txt"))
+	_, err = in.Read(buf)
+	assert.True(t, errors.Is(err, ErrTransferCancelled), "unexpected error: %v", err)
+	_, err = obj.Open(ctx)
+	assert.True(t, errors.Is(err, ErrTransferCancelled), "unexpected error: %v", err)
+
+	// the copy is forgotten when it is done
+	done()
+	assert.False(t, CancelTransfer("a.txt"))
+}
+
+func TestStartRC(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	stop, err := StartRC(context.Background(), RCOptions{Addr: addr})
+	require.NoError(t, err)
+	t.Cleanup(stop)
+
+	call := func(path, body string) (int, string) {
+		resp, err := http.Post("http://"+addr+"/"+path, "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		out, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(out)
+	}
+	code, out := call("core/stats", "{}")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, out, `"bytes"`)
+
+	code, out = call("syncmate/cancel", `{"name": "a.txt"}`)
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Contains(t, out, "a.txt is not being transferred")
+
+	// pausing needs auth
+	code, _ = call("syncmate/pause", "{}")
+	assert.NotEqual(t, http.StatusOK, code)
+	assert.False(t, TransfersPaused())
+}
+
+func TestPauseTransfers(t *testing.T) {
+	srcDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("aaaa"), 0644))
+	ctx := context.Background()
+	fsrc, err := fs.NewFs(ctx, srcDir)
+	require.NoError(t, err)
+	oldInterval := pausePollInterval
+	pausePollInterval = 10 * time.Millisecond
+	t.Cleanup(func() {
+		pausePollInterval = oldInterval
+		ResumeTransfers()
+	})
+
+	enableCancel()
+	t.Cleanup(disableCancel)
+	f, done := withCancel(fsrc, []string{"a.txt"})
+	defer done()
+	obj, err := f.NewObject(ctx, "a.txt")
+	require.NoError(t, err)
+	in, err := obj.Open(ctx)
+	require.NoError(t, err)
+	defer in.Close()
+
+	assert.True(t, PauseTransfers())
+	assert.False(t, PauseTransfers())
+	assert.True(t, TransfersPaused())
+	read := make(chan error, 1)
+	go func() {
+		_, err := in.Read(make([]byte, 2))
+		read <- err
+	}()
+	select {
+	case err := <-read:
+		t.Fatalf("read returned while paused: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, WaitResumed(waitCtx), context.DeadlineExceeded)
+
+	assert.True(t, ResumeTransfers())
+	assert.False(t, ResumeTransfers())
+	require.NoError(t, <-read)
+	require.NoError(t, WaitResumed(ctx))
+
+	// a paused transfer can be cancelled
+	PauseTransfers()
+	go func() {
+		_, err := in.Read(make([]byte, 2))
+		read <- err
+	}()
+	CancelTransfer("a.txt")
+	assert.ErrorIs(t, <-read, ErrTransferCancelled)
+}
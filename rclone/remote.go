@@ -0,0 +1,189 @@
+package rclone
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+
+	_ "github.com/rclone/rclone/backend/azureblob"
+	_ "github.com/rclone/rclone/backend/googlecloudstorage"
+	_ "github.com/rclone/rclone/backend/sftp"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/obscure"
+)
+
+// Remote types of RemoteConfig
+const (
+	RemoteR2        = "r2"
+	RemoteSFTP      = "sftp"
+	RemoteAzureBlob = "azureblob"
+	RemoteGCS       = "gcs"
+)
+
+// RemoteConfig is the "remote" section of config.json, the Cloudflare R2 bucket of the top level if it is missing
+type RemoteConfig struct {
+	Type string `json:"type"`
+	// Root is the directory of sftp, the container of azureblob or the bucket of gcs
+	Root string `json:"root,omitempty"`
+	// Options are the options of the rclone backend, e.g. "host", "user" and "pass" of sftp.
+	// Passwords are given in plain text, they are obscured for rclone.
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// Backend creates the fs of a RemoteConfig. The R2 credentials come from the top level of config.json.
+type Backend struct {
+	// Validate checks the options of the backend in the config, optional
+	Validate func(cfg *RemoteConfig) error
+	// DefaultRoot is the root of the remote if the caller doesn't choose one
+	DefaultRoot func(cfg *RemoteConfig, cred *CloudflareR2Credentials) string
+	// Root joins the root and the key prefix of the remote, prefixedRoot if it is nil
+	Root func(root, prefix string) (string, error)
+	// New returns the fs at root. archive asks for objects that report the time they were archived as their
+	// modification time, if the backend can.
+	New func(ctx context.Context, cfg *RemoteConfig, cred *CloudflareR2Credentials, root string, archive bool) (fs.Fs, error)
+}
+
+var backends = map[string]Backend{
+	RemoteR2: {
+		DefaultRoot: func(cfg *RemoteConfig, cred *CloudflareR2Credentials) string {
+			return cred.Bucket
+		},
+		Root: bucketRoot,
+		New: func(ctx context.Context, cfg *RemoteConfig, cred *CloudflareR2Credentials, root string, archive bool) (fs.Fs, error) {
+			var extra map[string]string
+			if archive {
+				extra = map[string]string{"use_server_modtime": "true"}
+			}
+			return newR2Fs(ctx, cred, root, extra)
+		},
+	},
+	RemoteSFTP: {
+		Validate: func(cfg *RemoteConfig) error {
+			if cfg.Options["host"] == "" {
+				return fmt.Errorf("options.host is required for the %s remote", RemoteSFTP)
+			}
+			return nil
+		},
+		DefaultRoot: configRoot,
+		New:         rcloneBackend("sftp"),
+	},
+	RemoteAzureBlob: {
+		Validate:    requireRoot(RemoteAzureBlob, "container"),
+		DefaultRoot: configRoot,
+		New:         rcloneBackend("azureblob"),
+	},
+	RemoteGCS: {
+		Validate:    requireRoot(RemoteGCS, "bucket"),
+		DefaultRoot: configRoot,
+		New:         rcloneBackend("google cloud storage"),
+	},
+}
+
+// RegisterBackend makes a backend available to the "type" of RemoteConfig
+func RegisterBackend(name string, backend Backend) {
+	backends[name] = backend
+}
+
+func configRoot(cfg *RemoteConfig, cred *CloudflareR2Credentials) string {
+	return cfg.Root
+}
+
+func requireRoot(name, what string) func(cfg *RemoteConfig) error {
+	return func(cfg *RemoteConfig) error {
+		if cfg.Root == "" {
+			return fmt.Errorf("root is required for the %s remote, it is the %s", name, what)
+		}
+		return nil
+	}
+}
+
+// rcloneBackend creates the fs of the registered rclone backend name from the options of the config
+func rcloneBackend(name string) func(context.Context, *RemoteConfig, *CloudflareR2Credentials, string, bool) (fs.Fs, error) {
+	return func(ctx context.Context, cfg *RemoteConfig, cred *CloudflareR2Credentials, root string, archive bool) (fs.Fs, error) {
+		info, err := fs.Find(name)
+		if err != nil {
+			return nil, err
+		}
+		m := configmap.Simple{}
+		for k, v := range cfg.Options {
+			m[k] = v
+		}
+		for _, opt := range info.Options {
+			if v, ok := m[opt.Name]; ok && opt.IsPassword && v != "" {
+				if m[opt.Name], err = obscure.Obscure(v); err != nil {
+					return nil, fmt.Errorf("failed to obscure option %s: %w", opt.Name, err)
+				}
+			}
+		}
+		// the options of the backend that aren't set keep their defaults
+		return info.NewFs(ctx, cfg.Type+":", root, fs.ConfigMap(info.Prefix, info.Options, "", m))
+	}
+}
+
+// backend returns the backend selected by the config, R2 if it is nil or has no type
+func (c *RemoteConfig) backend() (Backend, error) {
+	name := RemoteR2
+	if c != nil && c.Type != "" {
+		name = c.Type
+	}
+	backend, ok := backends[name]
+	if !ok {
+		names := make([]string, 0, len(backends))
+		for n := range backends {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return Backend{}, fmt.Errorf("unknown remote type %q, must be one of %v", name, names)
+	}
+	return backend, nil
+}
+
+// Validate checks the type and the options of the remote
+func (c *RemoteConfig) Validate() error {
+	backend, err := c.backend()
+	if err != nil {
+		return err
+	}
+	if c != nil && backend.Validate != nil {
+		return backend.Validate(c)
+	}
+	return nil
+}
+
+// NewRemote returns the keys under the prefix of cred in the remote selected by cfg
+func NewRemote(ctx context.Context, cfg *RemoteConfig, cred *CloudflareR2Credentials) (fs.Fs, error) {
+	return newRemote(ctx, cfg, cred, "", "", false)
+}
+
+// NewArchiveRemote returns the archive directory dir below the prefix of cred in the remote selected by cfg,
+// in bucket instead of its root if it is set
+func NewArchiveRemote(ctx context.Context, cfg *RemoteConfig, cred *CloudflareR2Credentials, bucket, dir string) (fs.Fs, error) {
+	return newRemote(ctx, cfg, cred, bucket, dir, true)
+}
+
+func newRemote(ctx context.Context, cfg *RemoteConfig, cred *CloudflareR2Credentials, bucket, dir string, archive bool) (fs.Fs, error) {
+	if cred == nil {
+		return nil, fmt.Errorf("Cloudflare R2 credentials are required")
+	}
+	backend, err := cfg.backend()
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		cfg = &RemoteConfig{Type: RemoteR2}
+	}
+	if bucket == "" {
+		bucket = backend.DefaultRoot(cfg, cred)
+	}
+	join := backend.Root
+	if join == nil {
+		join = prefixedRoot
+	}
+	root, err := join(bucket, path.Join(cred.Prefix, dir))
+	if err != nil {
+		return nil, err
+	}
+	return backend.New(ctx, cfg, cred, root, archive)
+}
@@ -0,0 +1,72 @@
+package rclone
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteConfigValidate(t *testing.T) {
+	var missing *RemoteConfig
+	assert.NoError(t, missing.Validate(), "a missing remote selects R2")
+	for _, cfg := range []*RemoteConfig{
+		{Type: RemoteSFTP},
+		{Type: RemoteAzureBlob, Options: map[string]string{"account": "woc"}},
+		{Type: RemoteGCS},
+		{Type: "ftp"},
+	} {
+		assert.Error(t, cfg.Validate(), "%+v", cfg)
+	}
+	for _, cfg := range []*RemoteConfig{
+		{Type: RemoteR2},
+		{Type: RemoteSFTP, Options: map[string]string{"host": "da5.example.org"}},
+		{Type: RemoteAzureBlob, Root: "woc"},
+		{Type: RemoteGCS, Root: "woc"},
+	} {
+		assert.NoError(t, cfg.Validate(), "%+v", cfg)
+	}
+}
+
+func TestNewRemote(t *testing.T) {
+	// the local backend stands in for the remotes that need a server
+	RegisterBackend("local", Backend{DefaultRoot: configRoot, New: rcloneBackend("local")})
+	defer delete(backends, "local")
+
+	root := t.TempDir()
+	cfg := &RemoteConfig{Type: "local", Root: root, Options: map[string]string{"copy_links": "true"}}
+	cred := &CloudflareR2Credentials{Prefix: "run-1"}
+	ctx := context.Background()
+
+	f, err := NewRemote(ctx, cfg, cred)
+	require.NoError(t, err)
+	require.NoError(t, PutFile(ctx, f, "blob_0.bin", []byte("blob")))
+	assert.FileExists(t, filepath.Join(root, "run-1", "blob_0.bin"))
+
+	archiveRoot := t.TempDir()
+	farchive, err := NewArchiveRemote(ctx, cfg, cred, archiveRoot, "archive")
+	require.NoError(t, err)
+	require.NoError(t, ArchiveFile(ctx, farchive, f, "blob_0.bin"))
+	assert.FileExists(t, filepath.Join(archiveRoot, "run-1", "archive", "blob_0.bin"))
+
+	_, err = NewRemote(ctx, &RemoteConfig{Type: "ftp"}, cred)
+	assert.ErrorContains(t, err, "unknown remote type")
+	_, err = NewRemote(ctx, cfg, &CloudflareR2Credentials{Prefix: "../other"})
+	assert.Error(t, err)
+	_, err = os.Stat(filepath.Join(root, "other"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestNewRemote_BucketPrefix(t *testing.T) {
+	cred := &CloudflareR2Credentials{AccessKey: "key", SecretKey: "secret", AccountID: "account", Bucket: "woc/campaign-2412", Prefix: "run-1"}
+	f, err := NewRemote(context.Background(), nil, cred)
+	require.NoError(t, err)
+	assert.Equal(t, "woc/campaign-2412/run-1", f.Root())
+
+	farchive, err := NewArchiveRemote(context.Background(), nil, cred, "", "archive")
+	require.NoError(t, err)
+	assert.Equal(t, "woc/campaign-2412/run-1/archive", farchive.Root())
+}
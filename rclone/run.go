@@ -11,15 +11,18 @@ import (
 	"github.com/rclone/rclone/lib/terminal"
 )
 
-// Run the function with stats and retries if required
+// Run the function with stats and retries if required.
+// It is attempted once even if the config has no retries.
 func Run(ctx context.Context, f func() error) error {
 	ci := fs.GetConfig(ctx)
 	var cmdErr error
 	stopStats := func() {}
 	if ci.Progress {
 		stopStats = startProgress()
+	} else if profile.StatsInterval > 0 {
+		stopStats = startStatsLog(profile.StatsInterval)
 	}
-	for try := 1; try <= ci.Retries; try++ {
+	for try := 1; try <= max(ci.Retries, 1); try++ {
 		cmdErr = f()
 		cmdErr = fs.CountError(ctx, cmdErr)
 		lastErr := accounting.GlobalStats().GetLastError()
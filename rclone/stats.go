@@ -0,0 +1,115 @@
+package rclone
+
+import (
+	"time"
+
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/rc"
+)
+
+// TransferResult is a file transfer completed by rclone
+type TransferResult struct {
+	Name        string
+	Bytes       int64
+	StartedAt   time.Time
+	CompletedAt time.Time
+	Duration    time.Duration
+	Err         error
+	// Checked is set if the file was found unchanged in the destination and not copied, see CopyFiles
+	Checked bool
+}
+
+// Succeeded reports whether the file is in the destination after the transfer
+func (r TransferResult) Succeeded() bool {
+	return r.Err == nil
+}
+
+func newTransferResult(tr accounting.TransferSnapshot) TransferResult {
+	return TransferResult{
+		Name:        tr.Name,
+		Bytes:       tr.Bytes,
+		StartedAt:   tr.StartedAt,
+		CompletedAt: tr.CompletedAt,
+		Duration:    tr.CompletedAt.Sub(tr.StartedAt),
+		Err:         tr.Error,
+		Checked:     tr.Checked,
+	}
+}
+
+// maxKeptTransfers bounds the completed transfers KeepCompletedTransfers keeps, rclone forgets the
+// oldest beyond it so that a run over a huge list doesn't hold all of them in memory
+var maxKeptTransfers = 100_000
+
+// KeepCompletedTransfers makes rclone keep up to maxKeptTransfers completed transfers instead of 100
+func KeepCompletedTransfers() {
+	accounting.MaxCompletedTransfers = maxKeptTransfers
+}
+
+// CompletedTransfers returns the transfers completed since the last ResetTransferStats
+func CompletedTransfers() []TransferResult {
+	var results []TransferResult
+	for _, tr := range accounting.GlobalStats().Transferred() {
+		if tr.Checked {
+			continue
+		}
+		results = append(results, newTransferResult(tr))
+	}
+	return results
+}
+
+// FindSuccessfulTransfer returns the last successful transfer of the file name in CompletedTransfers
+func FindSuccessfulTransfer(name string) (TransferResult, bool) {
+	transferred := accounting.GlobalStats().Transferred()
+	for i := len(transferred) - 1; i >= 0; i-- {
+		if tr := transferred[i]; !tr.Checked && tr.Name == name && tr.Error == nil {
+			return newTransferResult(tr), true
+		}
+	}
+	return TransferResult{}, false
+}
+
+// SuccessfulTransfers returns the last successful transfer of every file in CompletedTransfers, by name
+func SuccessfulTransfers() map[string]TransferResult {
+	transfers := make(map[string]TransferResult)
+	for _, tr := range CompletedTransfers() {
+		if tr.Err == nil {
+			transfers[tr.Name] = tr
+		}
+	}
+	return transfers
+}
+
+// TransferProgress is a file rclone is transferring, Size is -1 if it is unknown
+type TransferProgress struct {
+	Name  string
+	Bytes int64
+	Size  int64
+}
+
+// InFlightTransfers returns the files rclone is transferring and the bytes transferred of each so far
+func InFlightTransfers() []TransferProgress {
+	stats, err := accounting.GlobalStats().RemoteStats(false)
+	if err != nil {
+		return nil
+	}
+	transferring, _ := stats["transferring"].([]rc.Params)
+	progress := make([]TransferProgress, 0, len(transferring))
+	for _, tr := range transferring {
+		name, _ := tr["name"].(string)
+		bytes, _ := tr["bytes"].(int64)
+		size, _ := tr["size"].(int64)
+		progress = append(progress, TransferProgress{Name: name, Bytes: bytes, Size: size})
+	}
+	return progress
+}
+
+// TransferStats returns the number of files and bytes transferred and the errors counted so far
+func TransferStats() (files, bytes, errors int64) {
+	stats := accounting.GlobalStats()
+	return stats.GetTransfers(), stats.GetBytes(), stats.GetErrors()
+}
+
+// ResetTransferStats clears the transfer counters, e.g. between daemon cycles
+func ResetTransferStats() {
+	accounting.GlobalStats().ResetCounters()
+}
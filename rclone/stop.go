@@ -0,0 +1,75 @@
+package rclone
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+)
+
+type softStopKey struct{}
+
+// SoftStop stops the copies of a context gracefully: transfers in flight are
+// finished, but no new transfers are started.
+type SoftStop struct {
+	mu       sync.Mutex
+	stopped  bool
+	configs  []*fs.ConfigInfo
+	children []*SoftStop
+}
+
+// InjectSoftStop attaches a SoftStop to the context used for CopyFiles.
+// Stopping a SoftStop also stops those injected below it.
+func InjectSoftStop(ctx context.Context) (context.Context, *SoftStop) {
+	s := &SoftStop{}
+	if parent, ok := ctx.Value(softStopKey{}).(*SoftStop); ok {
+		parent.mu.Lock()
+		parent.children = append(parent.children, s)
+		stopped := parent.stopped
+		parent.mu.Unlock()
+		if stopped {
+			s.Stop()
+		}
+	}
+	return context.WithValue(ctx, softStopKey{}, s), s
+}
+
+// watch makes the copy using ci stop when s is stopped
+func (s *SoftStop) watch(ci *fs.ConfigInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ci.CutoffMode = fs.CutoffModeSoft
+	if s.stopped {
+		ci.MaxTransfer = 0
+	}
+	s.configs = append(s.configs, ci)
+}
+
+// Stop makes rclone refuse new transfers, as if --max-transfer was reached
+func (s *SoftStop) Stop() {
+	s.mu.Lock()
+	s.stopped = true
+	for _, ci := range s.configs {
+		ci.MaxTransfer = 0
+	}
+	children := s.children
+	s.mu.Unlock()
+
+	for _, child := range children {
+		child.Stop()
+	}
+}
+
+// Stopped reports whether Stop has been called
+func (s *SoftStop) Stopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopped
+}
+
+// IsSoftStopped reports whether err is the error of a copy cut off by SoftStop
+func IsSoftStopped(err error) bool {
+	return errors.Is(err, accounting.ErrorMaxTransferLimitReached)
+}
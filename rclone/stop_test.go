@@ -0,0 +1,53 @@
+package rclone
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSoftStop(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("a"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "b.txt"), []byte("b"), 0644))
+
+	ctx, softStop := InjectSoftStop(context.Background())
+	fsrc, err := fs.NewFs(ctx, srcDir)
+	require.NoError(t, err)
+	fdst, err := fs.NewFs(ctx, dstDir)
+	require.NoError(t, err)
+
+	_, err = CopyFiles(ctx, fsrc, fdst, []string{"a.txt"})
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dstDir, "a.txt"))
+	assert.False(t, softStop.Stopped())
+
+	softStop.Stop()
+	assert.True(t, softStop.Stopped())
+	results, err := CopyFiles(ctx, fsrc, fdst, []string{"b.txt"})
+	assert.False(t, results["b.txt"].Succeeded())
+	assert.True(t, IsSoftStopped(err), "unexpected error: %v", err)
+	assert.NoFileExists(t, filepath.Join(dstDir, "b.txt"))
+}
+
+func TestSoftStop_Nested(t *testing.T) {
+	ctx, parent := InjectSoftStop(context.Background())
+	_, child := InjectSoftStop(ctx)
+
+	child.Stop()
+	assert.False(t, parent.Stopped())
+
+	_, child = InjectSoftStop(ctx)
+	parent.Stop()
+	assert.True(t, child.Stopped())
+
+	// children of a stopped parent start stopped
+	_, child = InjectSoftStop(ctx)
+	assert.True(t, child.Stopped())
+}
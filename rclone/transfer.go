@@ -0,0 +1,157 @@
+package rclone
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// TransferConfig is the "transfer" section of config.json, it tunes rclone. Unset fields keep their defaults.
+// The part sizes, cutoffs, concurrency, listing and memory pool apply to R2, the multi-thread downloads and
+// retries to every remote.
+type TransferConfig struct {
+	// ChunkSize is the size of the parts of multipart uploads, e.g. "500M"
+	ChunkSize string `json:"chunk_size,omitempty"`
+	// UploadCutoff and CopyCutoff are the sizes above which files are uploaded and copied in parts
+	UploadCutoff string `json:"upload_cutoff,omitempty"`
+	CopyCutoff   string `json:"copy_cutoff,omitempty"`
+	// UploadConcurrency is the number of parts of a file uploaded at once
+	UploadConcurrency int `json:"upload_concurrency,omitempty"`
+	// ListChunk is the number of objects listed per request, at most 1000
+	ListChunk int `json:"list_chunk,omitempty"`
+	// MemoryPoolFlushTime is how long unused upload buffers are kept, e.g. "1m"
+	MemoryPoolFlushTime string `json:"memory_pool_flush_time,omitempty"`
+	// MultiThreadChunkSize is the size of the parts of downloads with several streams
+	MultiThreadChunkSize string `json:"multi_thread_chunk_size,omitempty"`
+	// MultiThreadStreams is the number of range requests a large file is downloaded with at once, 1 for one stream
+	MultiThreadStreams int `json:"multi_thread_streams,omitempty"`
+	// MultiThreadCutoff is the size above which files are downloaded with several streams, e.g. "256M"
+	MultiThreadCutoff string `json:"multi_thread_cutoff,omitempty"`
+	// Retries is the number of times a copy is attempted, LowLevelRetries the number of times a request is
+	Retries         int `json:"retries,omitempty"`
+	LowLevelRetries int `json:"low_level_retries,omitempty"`
+}
+
+var defaultTransferConfig = TransferConfig{
+	ChunkSize:            "500M",
+	UploadCutoff:         "500M",
+	CopyCutoff:           "500M",
+	UploadConcurrency:    4,
+	ListChunk:            1000,
+	MemoryPoolFlushTime:  "1m",
+	MultiThreadChunkSize: "500M",
+	MultiThreadStreams:   4,
+	MultiThreadCutoff:    "256M",
+	Retries:              1,
+	LowLevelRetries:      100,
+}
+
+// transferConfig is the tuning of the backends and copies, see SetTransferConfig
+var transferConfig = defaultTransferConfig
+
+// minChunkSize and maxChunkSize are the limits of the part size of S3 multipart uploads
+const (
+	minChunkSize = 5 * fs.Mebi
+	maxChunkSize = 5 * fs.Gibi
+)
+
+// withDefaults returns the config with the defaults for the unset fields
+func (c *TransferConfig) withDefaults() TransferConfig {
+	if c == nil {
+		return defaultTransferConfig
+	}
+	merged := *c
+	d := defaultTransferConfig
+	for _, f := range []struct {
+		value *string
+		def   string
+	}{
+		{&merged.ChunkSize, d.ChunkSize},
+		{&merged.UploadCutoff, d.UploadCutoff},
+		{&merged.CopyCutoff, d.CopyCutoff},
+		{&merged.MemoryPoolFlushTime, d.MemoryPoolFlushTime},
+		{&merged.MultiThreadChunkSize, d.MultiThreadChunkSize},
+		{&merged.MultiThreadCutoff, d.MultiThreadCutoff},
+	} {
+		if *f.value == "" {
+			*f.value = f.def
+		}
+	}
+	for _, f := range []struct {
+		value *int
+		def   int
+	}{
+		{&merged.UploadConcurrency, d.UploadConcurrency},
+		{&merged.ListChunk, d.ListChunk},
+		{&merged.MultiThreadStreams, d.MultiThreadStreams},
+		{&merged.Retries, d.Retries},
+		{&merged.LowLevelRetries, d.LowLevelRetries},
+	} {
+		if *f.value == 0 {
+			*f.value = f.def
+		}
+	}
+	return merged
+}
+
+// parseSize reads a size like "500M" of the option name
+func parseSize(name, value string) (fs.SizeSuffix, error) {
+	var size fs.SizeSuffix
+	if err := size.Set(value); err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, value, err)
+	}
+	if size <= 0 {
+		return 0, fmt.Errorf("%s must be positive, got %q", name, value)
+	}
+	return size, nil
+}
+
+// Validate checks the sizes, durations and counts of the config
+func (c *TransferConfig) Validate() error {
+	cfg := c.withDefaults()
+	chunkSize, err := parseSize("chunk_size", cfg.ChunkSize)
+	if err != nil {
+		return err
+	}
+	if chunkSize < minChunkSize || chunkSize > maxChunkSize {
+		return fmt.Errorf("chunk_size must be between %s and %s, got %s", minChunkSize, maxChunkSize, cfg.ChunkSize)
+	}
+	for name, value := range map[string]string{
+		"upload_cutoff":           cfg.UploadCutoff,
+		"copy_cutoff":             cfg.CopyCutoff,
+		"multi_thread_chunk_size": cfg.MultiThreadChunkSize,
+		"multi_thread_cutoff":     cfg.MultiThreadCutoff,
+	} {
+		if _, err := parseSize(name, value); err != nil {
+			return err
+		}
+	}
+	if d, err := time.ParseDuration(cfg.MemoryPoolFlushTime); err != nil || d <= 0 {
+		return fmt.Errorf("memory_pool_flush_time must be a positive duration like 1m, got %q", cfg.MemoryPoolFlushTime)
+	}
+	if cfg.ListChunk < 1 || cfg.ListChunk > 1000 {
+		return fmt.Errorf("list_chunk must be between 1 and 1000, got %d", cfg.ListChunk)
+	}
+	for name, value := range map[string]int{
+		"upload_concurrency":   cfg.UploadConcurrency,
+		"multi_thread_streams": cfg.MultiThreadStreams,
+		"retries":              cfg.Retries,
+		"low_level_retries":    cfg.LowLevelRetries,
+	} {
+		if value < 1 {
+			return fmt.Errorf("%s must be at least 1, got %d", name, value)
+		}
+	}
+	return nil
+}
+
+// SetTransferConfig tunes the backends created and the copies run afterwards with c, the defaults if it is nil.
+// It returns the settings in effect.
+func SetTransferConfig(c *TransferConfig) (TransferConfig, error) {
+	if err := c.Validate(); err != nil {
+		return transferConfig, err
+	}
+	transferConfig = c.withDefaults()
+	return transferConfig, nil
+}
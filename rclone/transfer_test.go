@@ -0,0 +1,58 @@
+package rclone
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferConfigValidate(t *testing.T) {
+	var missing *TransferConfig
+	assert.NoError(t, missing.Validate())
+	assert.NoError(t, (&TransferConfig{ChunkSize: "64M", MemoryPoolFlushTime: "30s", Retries: 3}).Validate())
+	for _, cfg := range []*TransferConfig{
+		{ChunkSize: "1M"},
+		{ChunkSize: "lots"},
+		{UploadCutoff: "-1"},
+		{MemoryPoolFlushTime: "soon"},
+		{ListChunk: 5000},
+		{Retries: -1},
+		{MultiThreadStreams: -1},
+		{MultiThreadCutoff: "big"},
+	} {
+		assert.Error(t, cfg.Validate(), "%+v", cfg)
+	}
+}
+
+func TestSetTransferConfig(t *testing.T) {
+	defer SetTransferConfig(nil)
+
+	effective, err := SetTransferConfig(&TransferConfig{ChunkSize: "64M", Retries: 3, MultiThreadStreams: 8})
+	require.NoError(t, err)
+	assert.Equal(t, "64M", effective.ChunkSize)
+	assert.Equal(t, "500M", effective.UploadCutoff)
+	assert.Equal(t, 1000, effective.ListChunk)
+
+	ci := fs.GetConfig(InjectConfig(context.Background()))
+	assert.Equal(t, 3, ci.Retries)
+	assert.Equal(t, 500*fs.Mebi, ci.MultiThreadChunkSize)
+	assert.Equal(t, 8, ci.MultiThreadStreams)
+	assert.Equal(t, 256*fs.Mebi, ci.MultiThreadCutoff)
+
+	// an invalid config keeps the settings in effect
+	_, err = SetTransferConfig(&TransferConfig{ListChunk: -1})
+	assert.Error(t, err)
+	assert.Equal(t, "64M", transferConfig.ChunkSize)
+}
+
+func TestRun_NoRetries(t *testing.T) {
+	ctx, ci := fs.AddConfig(context.Background())
+	ci.Retries = 0
+	ResetTransferStats()
+	calls := 0
+	require.NoError(t, Run(ctx, func() error { calls++; return nil }))
+	assert.Equal(t, 1, calls)
+}
@@ -0,0 +1,129 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// shutdownTimeout bounds the export of the spans left when the run ends
+const shutdownTimeout = 10 * time.Second
+
+// tracer records nothing until Setup installs an exporter
+var tracer = otel.Tracer("github.com/hrz6976/syncmate")
+
+var (
+	runMu   sync.Mutex
+	runCtx  = context.Background()
+	runSpan trace.Span
+)
+
+// Enabled reports whether Setup would export the spans: endpoint is set, or the OpenTelemetry
+// environment names a collector
+func Enabled(endpoint string) bool {
+	return endpoint != "" || os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Setup exports the spans of this process over OTLP/HTTP to endpoint, e.g. http://localhost:4318.
+// Without endpoint, the OTEL_EXPORTER_OTLP_* variables configure the exporter, and nothing is
+// exported without them.
+//
+// It returns a func which should be called to export the remaining spans.
+func Setup(ctx context.Context, endpoint string, command string) (func(), error) {
+	if !Enabled(endpoint) {
+		return func() {}, nil
+	}
+	var opts []otlptracehttp.Option
+	if endpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithHost(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName("syncmate"), attribute.String("syncmate.command", command)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.WithError(err).Debug("Failed to export spans")
+	}))
+	if endpoint == "" {
+		endpoint = "from OTEL_EXPORTER_OTLP_*"
+	}
+	logger.WithField("endpoint", endpoint).Info("Exporting traces")
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			logger.WithError(err).Warn("Failed to export the last spans")
+		}
+	}, nil
+}
+
+// StartRun starts the span of the whole run, which the spans started from Context belong to
+func StartRun(name string, attrs ...attribute.KeyValue) {
+	runMu.Lock()
+	defer runMu.Unlock()
+	runCtx, runSpan = tracer.Start(context.Background(), name, trace.WithAttributes(attrs...))
+}
+
+// EndRun ends the span of the run with its error. Only the first call counts.
+func EndRun(err error) {
+	runMu.Lock()
+	defer runMu.Unlock()
+	if runSpan == nil {
+		return
+	}
+	End(runSpan, err)
+	runSpan = nil
+}
+
+// Context returns the context of the span of the run, for the spans of code without a context
+func Context() context.Context {
+	runMu.Lock()
+	defer runMu.Unlock()
+	return runCtx
+}
+
+// Start starts a span below the span of ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends span, marking it failed if err is set
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Record adds a finished span below the span of ctx, for work timed by others, e.g. the transfers of rclone
+func Record(ctx context.Context, name string, start, end time.Time, err error, attrs ...attribute.KeyValue) {
+	_, span := tracer.Start(ctx, name, trace.WithTimestamp(start), trace.WithAttributes(attrs...))
+	if err != nil {
+		span.RecordError(err, trace.WithTimestamp(end))
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End(trace.WithTimestamp(end))
+}
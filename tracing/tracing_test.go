@@ -0,0 +1,58 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	StartRun("syncmate recv", attribute.String("syncmate.run_id", "2025q1"))
+	_, span := Start(Context(), "MoveFile")
+	End(span, nil)
+	start := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	Record(Context(), "download", start, start.Add(time.Minute), errors.New("connection reset"))
+	EndRun(errors.New("1 file failed"))
+	EndRun(nil)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	run := spans[2]
+	assert.Equal(t, "syncmate recv", run.Name())
+	assert.Equal(t, codes.Error, run.Status().Code)
+	assert.Equal(t, "1 file failed", run.Status().Description)
+	assert.Contains(t, run.Attributes(), attribute.String("syncmate.run_id", "2025q1"))
+
+	assert.Equal(t, "MoveFile", spans[0].Name())
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	download := spans[1]
+	assert.Equal(t, start, download.StartTime())
+	assert.Equal(t, time.Minute, download.EndTime().Sub(download.StartTime()))
+	assert.Equal(t, codes.Error, download.Status().Code)
+	for _, s := range spans[:2] {
+		assert.Equal(t, run.SpanContext().SpanID(), s.Parent().SpanID())
+		assert.Equal(t, run.SpanContext().TraceID(), s.SpanContext().TraceID())
+	}
+}
+
+func TestSetup_Disabled(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	assert.False(t, Enabled(""))
+	assert.True(t, Enabled("http://localhost:4318"))
+	shutdown, err := Setup(context.Background(), "", "send")
+	require.NoError(t, err)
+	shutdown()
+}
@@ -0,0 +1,180 @@
+package woc
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Kinds of ProfileChange
+const (
+	ChangeNewMap        = "new map"
+	ChangeRemovedMap    = "removed map"
+	ChangeNewVersion    = "new version"
+	ChangeOlderVersion  = "older version"
+	ChangeNewObject     = "new object"
+	ChangeRemovedObject = "removed object"
+	ChangeResharded     = "resharded"
+	ChangeNewShard      = "new shard"
+	ChangeRemovedShard  = "removed shard"
+	ChangeGrown         = "grown"
+	ChangeShrunk        = "shrunk"
+	ChangeDigest        = "changed digest"
+)
+
+// ProfileChange is a difference between a source and a destination profile, see DiffProfiles
+type ProfileChange struct {
+	Kind string
+	// Dataset is the name of the map or object
+	Dataset string
+	// Shard is the file name of the shard of the source, or of the destination if the source has none
+	Shard string
+	// Old and New are the versions, shardings or digests of the destination and the source
+	Old, New string
+	// OldSize and NewSize are the bytes of the dataset or shard in the destination and the source, -1 if unknown
+	OldSize, NewSize int64
+}
+
+// DiffProfiles compares the latest map versions and the objects of src with those of dst. The maps
+// and objects of one profile only, the newer and older versions and, for the same version or
+// sharding, every shard whose size or digest differs are reported, by dataset. Maps are compared in
+// the version order of src.
+func DiffProfiles(src, dst *ParsedWocProfile) []ProfileChange {
+	var changes []ProfileChange
+	for _, name := range sortedKeys(mergeKeys(src.Maps, dst.Maps)) {
+		s, inSrc := src.Maps[name]
+		d, inDst := dst.Maps[name]
+		switch {
+		case !inDst:
+			changes = append(changes, ProfileChange{Kind: ChangeNewMap, Dataset: name, New: s.Version, OldSize: -1, NewSize: mapSize(s)})
+		case !inSrc:
+			changes = append(changes, ProfileChange{Kind: ChangeRemovedMap, Dataset: name, Old: d.Version, OldSize: mapSize(d), NewSize: -1})
+		default:
+			change := ProfileChange{Dataset: name, Old: d.Version, New: s.Version, OldSize: mapSize(d), NewSize: mapSize(s)}
+			if c := src.compareVersions(s.Version, d.Version); c > 0 {
+				change.Kind = ChangeNewVersion
+				changes = append(changes, change)
+			} else if c < 0 {
+				change.Kind = ChangeOlderVersion
+				changes = append(changes, change)
+			} else {
+				changes = append(changes, diffShards(name, s.ShardingBits, s.Shards, d.ShardingBits, d.Shards)...)
+				changes = append(changes, diffLarges(name, s.Larges, d.Larges)...)
+			}
+		}
+	}
+	for _, name := range sortedKeys(mergeKeys(src.Objects, dst.Objects)) {
+		s, inSrc := src.Objects[name]
+		d, inDst := dst.Objects[name]
+		switch {
+		case !inDst:
+			changes = append(changes, ProfileChange{Kind: ChangeNewObject, Dataset: name, OldSize: -1, NewSize: filesSize(s.Shards)})
+		case !inSrc:
+			changes = append(changes, ProfileChange{Kind: ChangeRemovedObject, Dataset: name, OldSize: filesSize(d.Shards), NewSize: -1})
+		default:
+			changes = append(changes, diffShards(name, s.ShardingBits, s.Shards, d.ShardingBits, d.Shards)...)
+		}
+	}
+	return changes
+}
+
+// diffShards compares the shards of a dataset with the same version in both profiles
+func diffShards(dataset string, srcBits int, src []WocFile, dstBits int, dst []WocFile) []ProfileChange {
+	if srcBits != dstBits || len(src) != len(dst) {
+		return []ProfileChange{{
+			Kind:    ChangeResharded,
+			Dataset: dataset,
+			Old:     fmt.Sprintf("%d shards", len(dst)),
+			New:     fmt.Sprintf("%d shards", len(src)),
+			OldSize: filesSize(dst),
+			NewSize: filesSize(src),
+		}}
+	}
+	var changes []ProfileChange
+	for i := range src {
+		if change, ok := diffFile(dataset, &src[i], &dst[i]); ok {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// diffLarges compares the large files of a map version by key
+func diffLarges(dataset string, src, dst map[string]WocFile) []ProfileChange {
+	var changes []ProfileChange
+	for _, key := range sortedKeys(mergeKeys(src, dst)) {
+		s, inSrc := src[key]
+		d, inDst := dst[key]
+		var srcFile, dstFile *WocFile
+		if inSrc {
+			srcFile = &s
+		}
+		if inDst {
+			dstFile = &d
+		}
+		if change, ok := diffFile(dataset, srcFile, dstFile); ok {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// diffFile compares a file of the source with the file of the destination it replaces, either may be nil
+func diffFile(dataset string, src, dst *WocFile) (ProfileChange, bool) {
+	change := ProfileChange{Dataset: dataset, OldSize: fileSize(dst), NewSize: fileSize(src)}
+	switch {
+	case dst == nil:
+		change.Kind, change.Shard = ChangeNewShard, filepath.Base(src.Path)
+		return change, true
+	case src == nil:
+		change.Kind, change.Shard = ChangeRemovedShard, filepath.Base(dst.Path)
+		return change, true
+	}
+	change.Shard = filepath.Base(src.Path)
+	switch {
+	case change.OldSize >= 0 && change.NewSize > change.OldSize:
+		change.Kind = ChangeGrown
+	case change.NewSize >= 0 && change.OldSize > change.NewSize:
+		change.Kind = ChangeShrunk
+	case src.Digest != nil && dst.Digest != nil && *src.Digest != *dst.Digest:
+		change.Kind, change.Old, change.New = ChangeDigest, *dst.Digest, *src.Digest
+	default:
+		return change, false
+	}
+	return change, true
+}
+
+func fileSize(file *WocFile) int64 {
+	if file == nil || file.Size == nil {
+		return -1
+	}
+	return int64(*file.Size)
+}
+
+// filesSize returns the total size of files, leaving out those without a size
+func filesSize(files []WocFile) int64 {
+	var total int64
+	for i := range files {
+		total += max(fileSize(&files[i]), 0)
+	}
+	return total
+}
+
+func mapSize(m WocMap) int64 {
+	total := filesSize(m.Shards)
+	for _, large := range m.Larges {
+		total += max(fileSize(&large), 0)
+	}
+	return total
+}
+
+// mergeKeys returns a map with the keys of a and b
+func mergeKeys[A, B any](a map[string]A, b map[string]B) map[string]bool {
+	keys := make(map[string]bool, len(a)+len(b))
+	for key := range a {
+		keys[key] = true
+	}
+	for key := range b {
+		keys[key] = true
+	}
+	return keys
+}
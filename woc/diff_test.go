@@ -0,0 +1,54 @@
+package woc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffProfiles(t *testing.T) {
+	file := func(path string, size int, digest string) WocFile {
+		return WocFile{Path: path, Size: &size, Digest: &digest}
+	}
+	src := &ParsedWocProfile{
+		Maps: map[string]WocMap{
+			"c2pFull": {Version: "V2412", Shards: []WocFile{file("/src/c2pFullV2412.0.tch", 30, "a")}},
+			"b2fa": {Version: "V2412", Shards: []WocFile{file("/src/b2faFullV2412.0.tch", 10, "b")}, Larges: map[string]WocFile{
+				"k1": file("/src/b2faFullV2412.0.tch.large.k1", 5, "c"),
+			}},
+			"p2c": {Version: "V2412", Shards: []WocFile{file("/src/p2cFullV2412.0.tch", 10, "d")}},
+		},
+		Objects: map[string]WocObject{
+			"tree.idx": {ShardingBits: 1, Shards: []WocFile{file("/src/tree_0.idx", 20, "e"), file("/src/tree_1.idx", 10, "f")}},
+			"blob.bin": {ShardingBits: 1, Shards: []WocFile{file("/src/blob_0.bin", 20, "g"), file("/src/blob_1.bin", 20, "h")}},
+		},
+	}
+	dst := &ParsedWocProfile{
+		Maps: map[string]WocMap{
+			"c2pFull": {Version: "V2406", Shards: []WocFile{file("/dst/c2pFullV2406.0.tch", 20, "x")}},
+			"b2fa": {Version: "V2412", Shards: []WocFile{file("/dst/b2faFullV2412.0.tch", 10, "z")}, Larges: map[string]WocFile{
+				"k2": file("/dst/b2faFullV2412.0.tch.large.k2", 5, "c"),
+			}},
+			"a2f": {Version: "V2406", Shards: []WocFile{file("/dst/a2fFullV2406.0.tch", 10, "y")}},
+		},
+		Objects: map[string]WocObject{
+			"tree.idx": {ShardingBits: 1, Shards: []WocFile{file("/dst/tree_0.idx", 10, "u"), file("/dst/tree_1.idx", 10, "f")}},
+			"blob.bin": {ShardingBits: 0, Shards: []WocFile{file("/dst/blob_0.bin", 30, "v")}},
+			"sha1.tch": {Shards: []WocFile{file("/dst/sha1_0.tch", 10, "w")}},
+		},
+	}
+
+	assert.Equal(t, []ProfileChange{
+		{Kind: ChangeRemovedMap, Dataset: "a2f", Old: "V2406", OldSize: 10, NewSize: -1},
+		{Kind: ChangeDigest, Dataset: "b2fa", Shard: "b2faFullV2412.0.tch", Old: "z", New: "b", OldSize: 10, NewSize: 10},
+		{Kind: ChangeNewShard, Dataset: "b2fa", Shard: "b2faFullV2412.0.tch.large.k1", OldSize: -1, NewSize: 5},
+		{Kind: ChangeRemovedShard, Dataset: "b2fa", Shard: "b2faFullV2412.0.tch.large.k2", OldSize: 5, NewSize: -1},
+		{Kind: ChangeNewVersion, Dataset: "c2pFull", Old: "V2406", New: "V2412", OldSize: 20, NewSize: 30},
+		{Kind: ChangeNewMap, Dataset: "p2c", New: "V2412", OldSize: -1, NewSize: 10},
+		{Kind: ChangeResharded, Dataset: "blob.bin", Old: "1 shards", New: "2 shards", OldSize: 30, NewSize: 40},
+		{Kind: ChangeRemovedObject, Dataset: "sha1.tch", OldSize: 10, NewSize: -1},
+		{Kind: ChangeGrown, Dataset: "tree.idx", Shard: "tree_0.idx", OldSize: 10, NewSize: 20},
+	}, DiffProfiles(src, dst))
+
+	assert.Empty(t, DiffProfiles(src, src))
+}
@@ -0,0 +1,231 @@
+package woc
+
+import (
+	"crypto/md5"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/zeebo/blake3"
+)
+
+// DefaultDigestAlgorithm is the algorithm of the profiles and tasks that don't name one:
+// the 16-char MD5 of samples of woc.utils.fast_digest, see SampleMD5
+const DefaultDigestAlgorithm = "sample_md5"
+
+// Sampling strategies of the digest algorithms, the suffix of an algorithm name after a colon
+const (
+	// DigestSampled hashes up to 10 runs of 128 bytes spread over the file, see sampleRanges
+	DigestSampled = "sampled"
+	// DigestFull hashes every byte of the file
+	DigestFull = "full"
+)
+
+// digestHashes are the hashes of the digest algorithms by name
+var digestHashes = map[string]func() hash.Hash{
+	"md5":      md5.New,
+	"xxhash64": func() hash.Hash { return xxhash.New() },
+	"blake3":   func() hash.Hash { return blake3.New() },
+}
+
+// DigestAlgorithms returns the names of the hashes NewDigester accepts, sorted
+func DigestAlgorithms() []string {
+	names := make([]string, 0, len(digestHashes))
+	for name := range digestHashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Digester computes the digests of files with one hash and sampling strategy
+type Digester interface {
+	// Name returns the name of the algorithm that is recorded alongside the digests, see NewDigester
+	Name() string
+	// Digest returns the digest of size bytes of the file from skip on, of the rest of the file if size <= 0
+	Digest(filePath string, skip int64, size int64) (string, error)
+	// DigestReaderAt is Digest of the fileSize bytes of r instead of a file
+	DigestReaderAt(r io.ReaderAt, fileSize int64, skip int64, size int64) (string, error)
+	// NewWriter returns a DigestWriter for a file of size bytes whose bytes from offset on will be written
+	NewWriter(size int64, offset int64) DigestWriter
+}
+
+// DigestWriter computes the digest of a file while it is written, so it doesn't have to be read back
+type DigestWriter interface {
+	io.Writer
+	// ReadPrefix reads the bytes before the offset of the writer from r, which holds the start of the file
+	ReadPrefix(r io.ReaderAt) error
+	// Sum returns the digest, or an error if the file wasn't written up to its size
+	Sum() (string, error)
+}
+
+// NewDigester returns the digester of an algorithm name: a hash of DigestAlgorithms, optionally followed
+// by a colon and a sampling strategy, full by default, e.g. "blake3" or "xxhash64:sampled".
+// "sample_md5", the default for an empty name, is "md5:sampled". Sampled digests are the first 16 hex
+// chars of the hash like fast_digest, full digests the whole hash.
+func NewDigester(name string) (Digester, error) {
+	if name == "" || name == DefaultDigestAlgorithm {
+		return sampleDigester{"md5", md5.New}, nil
+	}
+	algorithm, strategy, _ := strings.Cut(name, ":")
+	newHash, ok := digestHashes[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unknown digest algorithm %q, expected %s or one of %s", algorithm, DefaultDigestAlgorithm, strings.Join(DigestAlgorithms(), ", "))
+	}
+	switch strategy {
+	case "", DigestFull:
+		return fullDigester{algorithm, newHash}, nil
+	case DigestSampled:
+		return sampleDigester{algorithm, newHash}, nil
+	default:
+		return nil, fmt.Errorf("unknown sampling strategy %q, expected %s or %s", strategy, DigestSampled, DigestFull)
+	}
+}
+
+// digesterOrDefault returns d, or the digester of DefaultDigestAlgorithm if d is nil
+func digesterOrDefault(d Digester) Digester {
+	if d == nil {
+		d, _ = NewDigester(DefaultDigestAlgorithm)
+	}
+	return d
+}
+
+// DigestAlgorithmField returns the name of d to record in profiles and tasks, empty for the default
+// algorithm so they stay readable by older versions
+func DigestAlgorithmField(d Digester) string {
+	if d == nil || d.Name() == DefaultDigestAlgorithm {
+		return ""
+	}
+	return d.Name()
+}
+
+// sampleDigester hashes the samples of sampleRanges
+type sampleDigester struct {
+	algorithm string
+	newHash   func() hash.Hash
+}
+
+func (d sampleDigester) Name() string {
+	if d.algorithm == "md5" {
+		return DefaultDigestAlgorithm
+	}
+	return d.algorithm + ":" + DigestSampled
+}
+
+func (d sampleDigester) Digest(filePath string, skip int64, size int64) (string, error) {
+	res, err := sampleDigest(filePath, skip, size, d.newHash)
+	if err != nil {
+		return "", err
+	}
+	return res.Digest, nil
+}
+
+func (d sampleDigester) DigestReaderAt(r io.ReaderAt, fileSize int64, skip int64, size int64) (string, error) {
+	res, err := sampleDigestReaderAt(r, fileSize, skip, size, d.newHash)
+	if err != nil {
+		return "", err
+	}
+	return res.Digest, nil
+}
+
+func (d sampleDigester) NewWriter(size int64, offset int64) DigestWriter {
+	return sampleDigestWriter{newSampleWriter(size, offset, d.newHash)}
+}
+
+// sampleDigestWriter is a SampleMD5Writer with the hash of a sampleDigester
+type sampleDigestWriter struct {
+	*SampleMD5Writer
+}
+
+func (w sampleDigestWriter) Sum() (string, error) {
+	res, err := w.SampleMD5Writer.Sum()
+	if err != nil {
+		return "", err
+	}
+	return res.Digest, nil
+}
+
+// fullDigester hashes every byte
+type fullDigester struct {
+	algorithm string
+	newHash   func() hash.Hash
+}
+
+func (d fullDigester) Name() string {
+	return d.algorithm
+}
+
+func (d fullDigester) Digest(filePath string, skip int64, size int64) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	return d.DigestReaderAt(file, stat.Size(), skip, size)
+}
+
+func (d fullDigester) DigestReaderAt(r io.ReaderAt, fileSize int64, skip int64, size int64) (string, error) {
+	if size <= 0 {
+		size = fileSize - skip
+	}
+	if skip+size > fileSize {
+		return "", fmt.Errorf("supplied size %dB > file size %dB", size, fileSize)
+	}
+	hasher := d.newHash()
+	if _, err := io.Copy(hasher, io.NewSectionReader(r, skip, size)); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+func (d fullDigester) NewWriter(size int64, offset int64) DigestWriter {
+	return &fullDigestWriter{hasher: d.newHash(), size: size, offset: offset}
+}
+
+// fullDigestWriter hashes the prefix of the file and then the bytes written
+type fullDigestWriter struct {
+	hasher hash.Hash
+	size   int64
+	offset int64
+	// pos is the number of bytes hashed
+	pos int64
+}
+
+func (w *fullDigestWriter) ReadPrefix(r io.ReaderAt) error {
+	if w.pos != 0 {
+		return fmt.Errorf("prefix read after %dB were written", w.pos)
+	}
+	n, err := io.Copy(w.hasher, io.NewSectionReader(r, 0, w.offset))
+	w.pos = n
+	if err != nil {
+		return fmt.Errorf("failed to read prefix: %w", err)
+	}
+	if n != w.offset {
+		return fmt.Errorf("prefix has %dB instead of %dB", n, w.offset)
+	}
+	return nil
+}
+
+func (w *fullDigestWriter) Write(p []byte) (int, error) {
+	if w.pos < w.offset {
+		return 0, fmt.Errorf("write before the prefix of %dB was read", w.offset)
+	}
+	w.hasher.Write(p)
+	w.pos += int64(len(p))
+	return len(p), nil
+}
+
+func (w *fullDigestWriter) Sum() (string, error) {
+	if w.pos != w.size {
+		return "", fmt.Errorf("written %dB of %dB", w.pos, w.size)
+	}
+	return fmt.Sprintf("%x", w.hasher.Sum(nil)), nil
+}
@@ -0,0 +1,106 @@
+package woc
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDigester(t *testing.T) {
+	for name, expected := range map[string]string{
+		"":                 DefaultDigestAlgorithm,
+		"sample_md5":       DefaultDigestAlgorithm,
+		"md5:sampled":      DefaultDigestAlgorithm,
+		"md5":              "md5",
+		"md5:full":         "md5",
+		"xxhash64:sampled": "xxhash64:sampled",
+		"blake3":           "blake3",
+	} {
+		d, err := NewDigester(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, expected, d.Name(), name)
+		d2, err := NewDigester(d.Name())
+		require.NoError(t, err)
+		assert.Equal(t, d.Name(), d2.Name(), "the name of %q doesn't parse to itself", name)
+	}
+	assert.Equal(t, "", DigestAlgorithmField(nil))
+	d, _ := NewDigester("md5:sampled")
+	assert.Equal(t, "", DigestAlgorithmField(d))
+	d, _ = NewDigester("blake3:full")
+	assert.Equal(t, "blake3", DigestAlgorithmField(d))
+
+	_, err := NewDigester("sha1")
+	assert.ErrorContains(t, err, `unknown digest algorithm "sha1"`)
+	_, err = NewDigester("blake3:sparse")
+	assert.ErrorContains(t, err, `unknown sampling strategy "sparse"`)
+}
+
+func TestDigesters(t *testing.T) {
+	tmpDir := t.TempDir()
+	rnd := rand.New(rand.NewSource(1))
+	content := make([]byte, 70000)
+	rnd.Read(content)
+	path := filepath.Join(tmpDir, "file.bin")
+	require.NoError(t, os.WriteFile(path, content, 0644))
+
+	sample, err := SampleMD5(path, 0, 0)
+	require.NoError(t, err)
+	d, _ := NewDigester(DefaultDigestAlgorithm)
+	digest, err := d.Digest(path, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, sample.Digest, digest)
+
+	d, _ = NewDigester("md5")
+	digest, err = d.Digest(path, 100, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x", md5.Sum(content[100:1100])), digest)
+	_, err = d.Digest(path, 100, int64(len(content)))
+	assert.ErrorContains(t, err, "file size")
+
+	digests := make(map[string]string)
+	for _, name := range []string{"sample_md5", "md5", "xxhash64", "xxhash64:sampled", "blake3", "blake3:sampled"} {
+		d, err := NewDigester(name)
+		require.NoError(t, err)
+		expected, err := d.Digest(path, 0, 0)
+		require.NoError(t, err)
+		digests[expected] = name
+		fromReader, err := d.DigestReaderAt(bytes.NewReader(content), int64(len(content)), 0, 0)
+		require.NoError(t, err)
+		assert.Equal(t, expected, fromReader, name)
+		// a full BLAKE3 has 64 hex chars, a sampled one 16 like fast_digest
+		if d.Name() == "blake3" {
+			assert.Len(t, expected, 64)
+		} else if name == "blake3:sampled" {
+			assert.Len(t, expected, 16)
+		}
+
+		size := int64(len(content))
+		for _, offset := range []int64{0, size / 3, size} {
+			w := d.NewWriter(size, offset)
+			require.NoError(t, w.ReadPrefix(bytes.NewReader(content[:offset])))
+			for rest := content[offset:]; len(rest) > 0; {
+				n := min(len(rest), 1000)
+				_, err := w.Write(rest[:n])
+				require.NoError(t, err)
+				rest = rest[n:]
+			}
+			digest, err := w.Sum()
+			require.NoError(t, err, "%s, offset %d", name, offset)
+			assert.Equal(t, expected, digest, "%s, offset %d", name, offset)
+		}
+
+		w := d.NewWriter(size, 0)
+		_, err = w.Write(content[:100])
+		require.NoError(t, err)
+		_, err = w.Sum()
+		assert.Error(t, err, "%s: incomplete file", name)
+	}
+	assert.Len(t, digests, 6, "the algorithms must give different digests")
+}
@@ -0,0 +1,121 @@
+package woc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+// profileFetchTimeout bounds the download of a remote profile
+const profileFetchTimeout = 5 * time.Minute
+
+// IsRemoteProfile reports whether location names a profile ReadProfileData fetches from another host
+func IsRemoteProfile(location string) bool {
+	for _, scheme := range []string{"http://", "https://", "ssh://"} {
+		if strings.HasPrefix(location, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadProfileData returns the bytes of the profile at location: a local path, an http:// or https://
+// URL, or ssh://[user@]host:path, which is read with the ssh command and its configuration. path is
+// relative to the home directory unless it starts with a slash; ssh://[user@]host[:port]/path works too.
+func ReadProfileData(ctx context.Context, location string) ([]byte, error) {
+	if !IsRemoteProfile(location) {
+		return os.ReadFile(location)
+	}
+	ctx, cancel := context.WithTimeout(ctx, profileFetchTimeout)
+	defer cancel()
+	var data []byte
+	var err error
+	if strings.HasPrefix(location, "ssh://") {
+		data, err = fetchProfileSSH(ctx, location)
+	} else {
+		data, err = fetchProfileHTTP(ctx, location)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch profile %s: %w", location, err)
+	}
+	logger.WithFields(logger.Fields{"profile": location, "bytes": len(data)}).Info("Fetched remote profile")
+	return data, nil
+}
+
+func fetchProfileHTTP(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the proxy of the environment applies, see rclone.SetProxy
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// sshTarget is the host and file of an ssh:// profile location
+type sshTarget struct {
+	host string
+	port string
+	path string
+}
+
+// parseSSHLocation splits ssh://[user@]host:path or ssh://[user@]host[:port]/path
+func parseSSHLocation(location string) (sshTarget, error) {
+	rest := strings.TrimPrefix(location, "ssh://")
+	var target sshTarget
+	colon, slash := strings.IndexByte(rest, ':'), strings.IndexByte(rest, '/')
+	switch {
+	case colon >= 0 && (slash < 0 || colon < slash):
+		target.host, target.path = rest[:colon], rest[colon+1:]
+		// a port is followed by the absolute path
+		if port, path, ok := strings.Cut(target.path, "/"); ok && port != "" && strings.Trim(port, "0123456789") == "" {
+			target.port, target.path = port, "/"+path
+		}
+	case slash >= 0:
+		target.host, target.path = rest[:slash], rest[slash:]
+	}
+	if target.host == "" || target.path == "" || target.path == "/" {
+		return sshTarget{}, fmt.Errorf("location must be like ssh://host:path, got %q", location)
+	}
+	return target, nil
+}
+
+func fetchProfileSSH(ctx context.Context, location string) ([]byte, error) {
+	target, err := parseSSHLocation(location)
+	if err != nil {
+		return nil, err
+	}
+	// no password prompts, the runs are unattended
+	args := []string{"-o", "BatchMode=yes"}
+	if target.port != "" {
+		args = append(args, "-p", target.port)
+	}
+	// the remote shell parses the command
+	args = append(args, target.host, "cat", "--", "'"+strings.ReplaceAll(target.path, "'", `'\''`)+"'")
+	cmd := exec.CommandContext(ctx, "ssh", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	data, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,72 @@
+package woc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSSHLocation(t *testing.T) {
+	for location, expected := range map[string]sshTarget{
+		"ssh://da5:woc.dst.json":                {host: "da5", path: "woc.dst.json"},
+		"ssh://da5:/data/woc.dst.json":          {host: "da5", path: "/data/woc.dst.json"},
+		"ssh://user@da5:2222/data/woc.dst.json": {host: "user@da5", port: "2222", path: "/data/woc.dst.json"},
+		"ssh://da5/data/woc.dst.json":           {host: "da5", path: "/data/woc.dst.json"},
+		"ssh://da5:profiles/v2/woc.dst.json":    {host: "da5", path: "profiles/v2/woc.dst.json"},
+	} {
+		target, err := parseSSHLocation(location)
+		require.NoError(t, err, location)
+		assert.Equal(t, expected, target, location)
+	}
+	for _, location := range []string{"ssh://da5", "ssh://da5:", "ssh://:woc.json", "ssh://da5/"} {
+		_, err := parseSSHLocation(location)
+		assert.Error(t, err, location)
+	}
+}
+
+func TestReadProfileData(t *testing.T) {
+	profile := []byte(`{"maps": {}, "objects": {}}`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/woc.dst.json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(profile)
+	}))
+	defer server.Close()
+	data, err := ReadProfileData(context.Background(), server.URL+"/woc.dst.json")
+	require.NoError(t, err)
+	assert.Equal(t, profile, data)
+	_, err = ReadProfileData(context.Background(), server.URL+"/missing.json")
+	assert.ErrorContains(t, err, "404 Not Found")
+
+	// a fake ssh runs the remote command locally
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "it's a profile.json")
+	require.NoError(t, os.WriteFile(path, profile, 0644))
+	argsPath := filepath.Join(tmpDir, "args")
+	script := "#!/bin/sh\necho \"$@\" > " + argsPath + "\nwhile [ \"$1\" != cat ]; do shift; done\neval \"$@\"\n"
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "ssh"), []byte(script), 0755))
+	t.Setenv("PATH", tmpDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	data, err = ReadProfileData(context.Background(), "ssh://user@da5:2222"+path)
+	require.NoError(t, err)
+	assert.Equal(t, profile, data)
+	args, err := os.ReadFile(argsPath)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(args), "-o BatchMode=yes -p 2222 user@da5 cat --"), string(args))
+	_, err = ReadProfileData(context.Background(), "ssh://da5:"+tmpDir+"/missing.json")
+	assert.ErrorContains(t, err, "missing.json")
+
+	profilePath := "ssh://da5:" + path
+	parsed, err := ParseWocProfile(&profilePath)
+	require.NoError(t, err)
+	assert.Empty(t, parsed.Maps)
+}
@@ -2,29 +2,76 @@ package woc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/machinebox/progress"
 	logger "github.com/sirupsen/logrus"
 )
 
 type CopyMode int
 
+// DefaultMoveBufferSize is the buffer MoveFile copies with when the kernel doesn't copy the file
+const DefaultMoveBufferSize = 1 << 20
+
+// MoveOptions tune how MoveFile writes the destinations, see SetMoveOptions
+type MoveOptions struct {
+	// NoPreallocate skips reserving the space of the copied bytes first, which otherwise keeps
+	// the shards that are appended to again and again from fragmenting
+	NoPreallocate bool
+	// BufferSize is the size of the reads and writes, DefaultMoveBufferSize if 0
+	BufferSize int
+	// DirectIO writes the destinations with O_DIRECT, bypassing the page cache; this needs Linux
+	// and is ignored for filesystems without it
+	DirectIO bool
+	// Sync flushes a destination and its directory to the disk before the source is removed, so a
+	// crash can't lose a file whose only other copy was deleted
+	Sync bool
+}
+
+// directIOAlignment is the alignment of the offsets, sizes and buffers of O_DIRECT writes
+const directIOAlignment = 4096
+
+// moveOptions are the options of MoveFile, see SetMoveOptions
+var moveOptions MoveOptions
+
+// SetMoveOptions replaces the options of MoveFile, the zero value restores the defaults
+func SetMoveOptions(opt MoveOptions) error {
+	if opt.BufferSize < 0 {
+		return fmt.Errorf("buffer size %d is negative", opt.BufferSize)
+	}
+	if opt.DirectIO && opt.BufferSize%directIOAlignment != 0 {
+		return fmt.Errorf("buffer size %d of direct I/O is not a multiple of %d", opt.BufferSize, directIOAlignment)
+	}
+	moveOptions = opt
+	return nil
+}
+
 const (
 	CopyModeOverwrite CopyMode = iota
 	CopyModeAppend
 )
 
+// MoveFile places srcPath at dstPath and removes it. The digest of the destination after the transfer is
+// verified with digester against expectedDigestAfterTransfer unless it is empty, digester nil means
+// DefaultDigestAlgorithm. Cancelling ctx stops waiting for the lock of dstPath or stops the copy; an
+// aborted append is rolled back to the size dstPath had before, and srcPath is kept. Appends are
+// recorded in an AppendJournal, and one a crash interrupted is rolled back by the next MoveFile.
+// onProgress, if not nil, is called with the progress of the copy.
 func MoveFile(
+	ctx context.Context,
 	srcPath,
 	dstPath string,
 	mode CopyMode,
+	digester Digester,
 	expectedDigestAfterTransfer string,
-	expectedDstSizeBeforeTransfer int64) error {
+	expectedDstSizeBeforeTransfer int64,
+	onProgress MoveProgressFunc) error {
 	srcStat, err := os.Stat(srcPath)
 
 	// 1. Check before copying
@@ -40,37 +87,63 @@ func MoveFile(
 		return fmt.Errorf("unable to open source file for reading: %w", err)
 	}
 	defer srcFile.Close()
+	digester = digesterOrDefault(digester)
+	opt := moveOptions
 
 	// trunc: verify digest now
 	if mode == CopyModeOverwrite && expectedDigestAfterTransfer != "" {
-		md5Res, err := SampleMD5(srcPath, 0, 0)
+		digest, err := digester.Digest(srcPath, 0, 0)
 		if err != nil {
 			return fmt.Errorf("failed to compute source file digest: %w", err)
 		}
-		if md5Res.Digest != expectedDigestAfterTransfer {
-			return fmt.Errorf("source file digest mismatch: expected %s, got %s", expectedDigestAfterTransfer, md5Res.Digest)
+		if digest != expectedDigestAfterTransfer {
+			return fmt.Errorf("source file digest mismatch: expected %s, got %s (%s)", expectedDigestAfterTransfer, digest, digester.Name())
 		}
 	}
 
-	lockFile, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE, 0666)
+	lockFile, err := os.OpenFile(dstPath+LockSuffix, os.O_WRONLY|os.O_CREATE, 0666)
 	if err != nil {
-		return fmt.Errorf("unable to open destination file for locking: %w", err)
+		return fmt.Errorf("unable to open lock file of destination: %w", err)
 	}
 	defer lockFile.Close()
 
 	// Apply exclusive lock to prevent other processes from accessing this file simultaneously
-	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+	if err := lockContext(ctx, lockFile); err != nil {
 		return fmt.Errorf("unable to lock destination file: %w", err)
 	}
 	// Ensure the lock is released when the function exits
 	defer syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
 
+	// overwrite: the verified source replaces the destination without a copy if they share a filesystem,
+	// taking over the mode and owner of the destination it replaces
+	if mode == CopyModeOverwrite && keepDestinationAttributes(srcFile, dstPath) {
+		if opt.Sync {
+			if err := srcFile.Sync(); err != nil {
+				return fmt.Errorf("failed to sync source file: %w", err)
+			}
+		}
+		err := os.Rename(srcPath, dstPath)
+		if err == nil {
+			if opt.Sync {
+				if err := syncDir(filepath.Dir(dstPath)); err != nil {
+					return fmt.Errorf("failed to sync destination directory: %w", err)
+				}
+			}
+			logger.Infof("Moved file %s successfully by renaming it", srcPath)
+			return nil
+		}
+		if !errors.Is(err, syscall.EXDEV) {
+			return fmt.Errorf("unable to rename source file: %w", err)
+		}
+	}
+
 	var openFlags int
 	switch mode {
 	case CopyModeOverwrite:
 		openFlags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
 	case CopyModeAppend:
-		openFlags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		// copy_file_range refuses O_APPEND, the lock keeps other writers away from the end
+		openFlags = os.O_WRONLY | os.O_CREATE
 	default:
 		return fmt.Errorf("invalid copy mode: %d", mode)
 	}
@@ -86,6 +159,15 @@ func MoveFile(
 		return fmt.Errorf("unable to get destination file info: %w", err)
 	}
 	dstSize := dstStat.Size()
+	if mode == CopyModeAppend {
+		dstSize, err = recoverAppend(dstFile, dstPath, dstSize, expectedDstSizeBeforeTransfer, srcStat.Size())
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := dstFile.Seek(dstSize, io.SeekStart); err != nil {
+		return fmt.Errorf("unable to seek to the end of destination file: %w", err)
+	}
 	// append: check size
 	if mode == CopyModeAppend && expectedDstSizeBeforeTransfer >= 0 {
 		if dstSize != expectedDstSizeBeforeTransfer {
@@ -93,20 +175,110 @@ func MoveFile(
 		}
 	}
 
+	// append: a full digest of the destination file is computed while copying instead of reading it back,
+	// sampled ones read the samples back so that the kernel can copy the file
+	verifyAfterTransfer := mode == CopyModeAppend && expectedDigestAfterTransfer != ""
+	_, sampled := digester.(sampleDigester)
+	newDigestWriter := func() (DigestWriter, error) {
+		w := digester.NewWriter(dstSize+srcStat.Size(), dstSize)
+		prefixFile, err := os.Open(dstPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open destination file for reading: %w", err)
+		}
+		err = w.ReadPrefix(prefixFile)
+		prefixFile.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute destination file digest: %w", err)
+		}
+		return w, nil
+	}
+	var digestWriter DigestWriter
+	if verifyAfterTransfer && !sampled {
+		if digestWriter, err = newDigestWriter(); err != nil {
+			return err
+		}
+	}
+
 	// 2. Do copy
-	r := progress.NewReader(srcFile)
-	// Start a goroutine printing progress
-	go func() {
-		ctx := context.Background()
-		progressChan := progress.NewTicker(ctx, r, srcStat.Size(), 10*time.Second)
-		for p := range progressChan {
-			logger.Debugf("Moving file %s->%s, %.1f%% copied, remaining %v", srcPath, dstPath, p.Percent(), p.Remaining().Round(time.Second))
+	if mode == CopyModeAppend {
+		journal := AppendJournal{OriginalSize: dstSize, FinalSize: dstSize + srcStat.Size()}
+		if expectedDigestAfterTransfer != "" {
+			journal.Digest, journal.DigestAlgorithm = expectedDigestAfterTransfer, digester.Name()
 		}
-		logger.Infof("Moved file %s successfully", srcPath)
-	}()
-	written, err := io.Copy(dstFile, r)
+		if err := writeAppendJournal(dstPath, journal); err != nil {
+			return fmt.Errorf("failed to write append journal: %w", err)
+		}
+	}
+	bufferSize := opt.BufferSize
+	if bufferSize == 0 {
+		bufferSize = DefaultMoveBufferSize
+	}
+	if !opt.NoPreallocate {
+		if err := preallocate(dstFile, dstSize, srcStat.Size()); err != nil {
+			logger.WithError(err).Debugf("Failed to preallocate %s", dstPath)
+		}
+	}
+	var copiedBytes atomic.Int64
+	stopProgress := reportMoveProgress(&copiedBytes, srcStat.Size(), srcPath, dstPath, onProgress)
+	defer stopProgress()
+	// copyOnce copies the source from its offset on to the destination at dstSize+offset
+	copyOnce := func(offset int64) (written int64, err error) {
+		var copySrc io.Reader = &contextReader{ctx: ctx, r: srcFile, counter: &copiedBytes}
+		if digestWriter != nil {
+			copySrc = io.TeeReader(copySrc, digestWriter)
+		}
+		copied := false
+		if opt.DirectIO {
+			written, copied, err = copyDirect(dstPath, dstSize+offset, copySrc, bufferSize)
+			if err == nil && !copied {
+				logger.Debugf("Direct I/O is not available for %s, copying through the page cache", dstPath)
+			}
+		} else if digestWriter == nil {
+			written, copied, err = copyFileRange(ctx, dstFile, srcFile, &copiedBytes)
+		}
+		if err == nil && !copied {
+			// hide ReadFrom of the file, it would copy with its own buffer
+			written, err = io.CopyBuffer(struct{ io.Writer }{dstFile}, copySrc, make([]byte, bufferSize))
+		}
+		return written, err
+	}
+	// resume positions the files after the bytes written before a transient error
+	resume := func(written int64) (int64, error) {
+		if digestWriter != nil {
+			// the hash can't be rewound, start over
+			written = 0
+			w, err := newDigestWriter()
+			if err != nil {
+				return 0, err
+			}
+			digestWriter = w
+		}
+		if err := dstFile.Truncate(dstSize + written); err != nil {
+			return 0, err
+		}
+		if _, err := dstFile.Seek(dstSize+written, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if _, err := srcFile.Seek(written, io.SeekStart); err != nil {
+			return 0, err
+		}
+		copiedBytes.Store(written)
+		return written, nil
+	}
+	written, err := retryCopy(ctx, srcPath, copyOnce, resume)
 	if err != nil {
-		return fmt.Errorf("file copy error occurred: %w", err)
+		err = fmt.Errorf("file copy error occurred: %w", err)
+		if mode == CopyModeAppend {
+			// keep the original part, the next run appends again
+			logger.WithError(err).Warnf("Rolling back %s to %d bytes", dstPath, dstSize)
+			if terr := dstFile.Truncate(dstSize); terr != nil {
+				return fmt.Errorf("%w, and failed to resize destination file: %v", err, terr)
+			}
+			if jerr := removeAppendJournal(dstPath); jerr != nil {
+				logger.WithError(jerr).Warnf("Failed to remove the append journal of %s", dstPath)
+			}
+		}
+		return err
 	}
 	if written != srcStat.Size() {
 		return fmt.Errorf("number of bytes copied does not match source file size: expected %d, got %d", srcStat.Size(), written)
@@ -114,25 +286,210 @@ func MoveFile(
 
 	// 3. Check after copying
 	// append: verify digest after transfer
-	if mode == CopyModeAppend && expectedDigestAfterTransfer != "" {
-		md5Res, err := SampleMD5(dstPath, 0, 0)
+	if verifyAfterTransfer {
+		var digest string
+		if digestWriter != nil {
+			digest, err = digestWriter.Sum()
+		} else {
+			digest, err = digester.Digest(dstPath, 0, 0)
+		}
 		if err != nil {
 			return fmt.Errorf("failed to compute destination file digest: %w", err)
 		}
-		if md5Res.Digest != expectedDigestAfterTransfer {
+		if digest != expectedDigestAfterTransfer {
 			// shit, rollback!
 			// resize destination file, keep the original part
-			err = fmt.Errorf("destination file digest mismatch: expected %s, got %s", expectedDigestAfterTransfer, md5Res.Digest)
+			err = fmt.Errorf("destination file digest mismatch: expected %s, got %s (%s)", expectedDigestAfterTransfer, digest, digester.Name())
 			logger.WithError(err).Error("File copy failed, rolling back")
 			if err := os.Truncate(dstPath, dstSize); err != nil {
 				return fmt.Errorf("failed to resize destination file: %w", err)
 			}
+			if jerr := removeAppendJournal(dstPath); jerr != nil {
+				logger.WithError(jerr).Warnf("Failed to remove the append journal of %s", dstPath)
+			}
 			return err
 		}
 	}
+	if opt.Sync {
+		if err := dstFile.Sync(); err != nil {
+			return fmt.Errorf("failed to sync destination file: %w", err)
+		}
+		if err := syncDir(filepath.Dir(dstPath)); err != nil {
+			return fmt.Errorf("failed to sync destination directory: %w", err)
+		}
+	}
+	// the append is complete, the journal goes before the source so the next run can't undo it
+	if mode == CopyModeAppend {
+		if err := removeAppendJournal(dstPath); err != nil {
+			return fmt.Errorf("failed to remove append journal: %w", err)
+		}
+	}
 	// 4. Delete the source file
 	if err := os.Remove(srcPath); err != nil {
 		return fmt.Errorf("failed to delete source file: %w", err)
 	}
+	logger.Infof("Moved file %s successfully", srcPath)
 	return nil
 }
+
+// moveRetries is how often MoveFile resumes a copy after a transient error, waiting moveRetryDelay
+// before the first retry and twice as long before each next one
+var (
+	moveRetries    = 3
+	moveRetryDelay = time.Second
+)
+
+// isTransientError reports whether err is an I/O error that NFS returns now and then, which a retry may not hit again
+func isTransientError(err error) bool {
+	return errors.Is(err, syscall.EIO) || errors.Is(err, syscall.ESTALE) || errors.Is(err, syscall.ETIMEDOUT)
+}
+
+// retryCopy runs copyOnce from offset 0 and after a transient error again from the offset resume returns
+// for the bytes written so far, at most moveRetries times. It returns the bytes written.
+func retryCopy(ctx context.Context, name string, copyOnce func(offset int64) (int64, error), resume func(written int64) (int64, error)) (int64, error) {
+	var offset int64
+	delay := moveRetryDelay
+	for attempt := 1; ; attempt++ {
+		n, err := copyOnce(offset)
+		written := offset + n
+		if err == nil || !isTransientError(err) || attempt > moveRetries || ctx.Err() != nil {
+			return written, err
+		}
+		logger.WithError(err).WithField("file", name).Warnf("Copy failed after %d bytes, retrying in %v (%d/%d)", written, delay, attempt, moveRetries)
+		select {
+		case <-ctx.Done():
+			return written, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if offset, err = resume(written); err != nil {
+			return written, fmt.Errorf("failed to resume copy: %w", err)
+		}
+	}
+}
+
+// keepDestinationAttributes gives srcFile the mode and owner of an existing dstPath, and reports
+// whether it did so that srcFile can replace dstPath. Otherwise, e.g. without the permission to
+// change the owner, the destination is overwritten in place.
+func keepDestinationAttributes(srcFile *os.File, dstPath string) bool {
+	dstStat, err := os.Stat(dstPath)
+	if os.IsNotExist(err) {
+		return true
+	} else if err != nil {
+		return false
+	}
+	if err := srcFile.Chmod(dstStat.Mode().Perm()); err != nil {
+		logger.WithError(err).WithField("path", dstPath).Debug("Can't keep the mode of the destination, copying instead of renaming")
+		return false
+	}
+	if st, ok := dstStat.Sys().(*syscall.Stat_t); ok {
+		if err := srcFile.Chown(int(st.Uid), int(st.Gid)); err != nil {
+			logger.WithError(err).WithField("path", dstPath).Debug("Can't keep the owner of the destination, copying instead of renaming")
+			return false
+		}
+	}
+	return true
+}
+
+// lockPollInterval is how often lockContext retries a lock held by another process
+const lockPollInterval = 100 * time.Millisecond
+
+// lockContext takes the exclusive flock of file, polling so that cancelling ctx stops the wait
+func lockContext(ctx context.Context, file *os.File) error {
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err != syscall.EWOULDBLOCK {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// syncDir flushes the entries of a directory, e.g. a file created in it, to the disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// MoveProgress is how far MoveFile copied a file
+type MoveProgress struct {
+	// Copied is the number of bytes copied of Size
+	Copied, Size int64
+	Percent      float64
+	// Rate is the average number of bytes copied per second
+	Rate float64
+}
+
+// MoveProgressFunc receives the progress of a MoveFile every moveProgressInterval while it copies
+type MoveProgressFunc func(MoveProgress)
+
+// moveProgressInterval is the interval between the calls of a MoveProgressFunc, the progress is logged every moveLogInterval
+var (
+	moveProgressInterval = time.Second
+	moveLogInterval      = 10 * time.Second
+)
+
+// reportMoveProgress passes the progress of copying size bytes, counted by copied, to onProgress and
+// the debug log until the returned func is called; onProgress may be nil
+func reportMoveProgress(copied *atomic.Int64, size int64, srcPath, dstPath string, onProgress MoveProgressFunc) func() {
+	start := time.Now()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(moveProgressInterval)
+		defer ticker.Stop()
+		lastLog := start
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				p := MoveProgress{Copied: copied.Load(), Size: size, Percent: 100}
+				if size > 0 {
+					p.Percent = 100 * float64(p.Copied) / float64(size)
+				}
+				p.Rate = float64(p.Copied) / now.Sub(start).Seconds()
+				if onProgress != nil {
+					onProgress(p)
+				}
+				if now.Sub(lastLog) >= moveLogInterval {
+					lastLog = now
+					remaining := "unknown"
+					if p.Rate > 0 {
+						remaining = (time.Duration(float64(size-p.Copied)/p.Rate) * time.Second).Round(time.Second).String()
+					}
+					logger.Debugf("Moving file %s->%s, %.1f%% copied, remaining %v", srcPath, dstPath, p.Percent, remaining)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// contextReader fails the reads of r once ctx is cancelled and adds the bytes read to counter
+type contextReader struct {
+	ctx     context.Context
+	r       io.Reader
+	counter *atomic.Int64
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	r.counter.Add(int64(n))
+	return n, err
+}
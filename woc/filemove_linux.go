@@ -0,0 +1,111 @@
+package woc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyRangeChunk is how much copyFileRange asks the kernel for at a time, between checks of ctx
+const copyRangeChunk = 64 << 20
+
+// copyFileRange copies the rest of src to the offset of dst with copy_file_range, which moves the bytes
+// inside the kernel and shares the extents on filesystems that support reflinks, like XFS and Btrfs.
+// ok is false if the filesystems don't support it and nothing was copied, the caller copies then.
+func copyFileRange(ctx context.Context, dst, src *os.File, counter *atomic.Int64) (written int64, ok bool, err error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, true, err
+		}
+		n, err := unix.CopyFileRange(int(src.Fd()), nil, int(dst.Fd()), nil, copyRangeChunk, 0)
+		if err != nil {
+			if written == 0 && (errors.Is(err, unix.EXDEV) || errors.Is(err, unix.ENOSYS) ||
+				errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EINVAL) || errors.Is(err, unix.EBADF)) {
+				return 0, false, nil
+			}
+			return written, true, err
+		}
+		if n == 0 {
+			return written, true, nil
+		}
+		written += int64(n)
+		counter.Add(int64(n))
+	}
+}
+
+// preallocate reserves the blocks of size bytes of file from offset on, without changing its size
+func preallocate(file *os.File, offset, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	return unix.Fallocate(int(file.Fd()), unix.FALLOC_FL_KEEP_SIZE, offset, size)
+}
+
+// copyDirect writes src to dstPath from offset on with O_DIRECT, in aligned blocks of bufferSize bytes,
+// and the unaligned head and tail through the page cache. ok is false if the filesystem of dstPath
+// doesn't support O_DIRECT, the caller copies then.
+func copyDirect(dstPath string, offset int64, src io.Reader, bufferSize int) (written int64, ok bool, err error) {
+	direct, err := os.OpenFile(dstPath, os.O_WRONLY|unix.O_DIRECT, 0)
+	if err != nil {
+		if errors.Is(err, unix.EINVAL) {
+			return 0, false, nil
+		}
+		return 0, true, err
+	}
+	defer direct.Close()
+	buffered, err := os.OpenFile(dstPath, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, true, err
+	}
+	defer buffered.Close()
+
+	buf := alignedBuffer(bufferSize)
+	pos := offset
+	// the head up to the first aligned offset
+	if head := pos % directIOAlignment; head != 0 {
+		n, err := io.ReadFull(src, buf[:directIOAlignment-head])
+		if _, werr := buffered.WriteAt(buf[:n], pos); werr != nil {
+			return written, true, werr
+		}
+		written += int64(n)
+		pos += int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return written, true, nil
+		} else if err != nil {
+			return written, true, err
+		}
+	}
+	for {
+		n, err := io.ReadFull(src, buf)
+		aligned := n - n%directIOAlignment
+		if _, werr := direct.WriteAt(buf[:aligned], pos); werr != nil {
+			return written, true, werr
+		}
+		// the tail of the file
+		if _, werr := buffered.WriteAt(buf[aligned:n], pos+int64(aligned)); werr != nil {
+			return written, true, werr
+		}
+		written += int64(n)
+		pos += int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return written, true, nil
+		} else if err != nil {
+			return written, true, err
+		}
+	}
+}
+
+// alignedBuffer returns a buffer of size bytes whose address is aligned for O_DIRECT
+func alignedBuffer(size int) []byte {
+	buf := make([]byte, size+directIOAlignment)
+	shift := int(uintptr(unsafe.Pointer(&buf[0])) % directIOAlignment)
+	if shift != 0 {
+		shift = directIOAlignment - shift
+	}
+	return buf[shift : shift+size]
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package woc
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// copyFileRange, preallocate and direct I/O are only available on Linux, MoveFile copies the files itself elsewhere
+func copyFileRange(ctx context.Context, dst, src *os.File, counter *atomic.Int64) (written int64, ok bool, err error) {
+	return 0, false, nil
+}
+
+func preallocate(file *os.File, offset, size int64) error {
+	return nil
+}
+
+func copyDirect(dstPath string, offset int64, src io.Reader, bufferSize int) (written int64, ok bool, err error) {
+	return 0, false, nil
+}
@@ -1,10 +1,16 @@
 package woc
 
 import (
+	"context"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -72,7 +78,7 @@ func TestMoveFile_OverwriteMode(t *testing.T) {
 	expectedMD5 := th.GetFileMD5(srcPath)
 
 	// Perform move operation
-	err := MoveFile(srcPath, dstPath, CopyModeOverwrite, expectedMD5, -1)
+	err := MoveFile(context.Background(), srcPath, dstPath, CopyModeOverwrite, nil, expectedMD5, -1, nil)
 	assert.NoError(t, err, "Move operation should succeed")
 
 	// Verify source file is deleted
@@ -105,7 +111,7 @@ func TestMoveFile_AppendMode(t *testing.T) {
 	expectedMD5 := th.GetFileMD5(tempFile)
 
 	// Perform move operation
-	err = MoveFile(srcPath, dstPath, CopyModeAppend, expectedMD5, expectedDstSizeBeforeTransfer)
+	err = MoveFile(context.Background(), srcPath, dstPath, CopyModeAppend, nil, expectedMD5, expectedDstSizeBeforeTransfer, nil)
 	assert.NoError(t, err, "Move operation should succeed")
 
 	// Verify source file is deleted
@@ -128,7 +134,7 @@ func TestMoveFile_IncorrectMD5(t *testing.T) {
 	incorrectMD5 := "incorrectmd5hash"
 
 	// Move should fail
-	err := MoveFile(srcPath, dstPath, CopyModeOverwrite, incorrectMD5, -1)
+	err := MoveFile(context.Background(), srcPath, dstPath, CopyModeOverwrite, nil, incorrectMD5, -1, nil)
 	assert.Error(t, err, "Move should fail with incorrect MD5")
 	assert.Contains(t, err.Error(), "digest mismatch", "Error should mention digest mismatch")
 
@@ -151,7 +157,7 @@ func TestMoveFile_IncorrectDestinationSize(t *testing.T) {
 	incorrectSize := int64(999)
 
 	// Move should fail
-	err := MoveFile(srcPath, dstPath, CopyModeAppend, "", incorrectSize)
+	err := MoveFile(context.Background(), srcPath, dstPath, CopyModeAppend, nil, "", incorrectSize, nil)
 	assert.Error(t, err, "Move should fail with incorrect destination size")
 	assert.Contains(t, err.Error(), "size mismatch", "Error should mention size mismatch")
 
@@ -180,7 +186,7 @@ func TestMoveFile_AppendModeRollback(t *testing.T) {
 	incorrectMD5 := "incorrectmd5hash"
 
 	// Move should fail and rollback
-	err = MoveFile(srcPath, dstPath, CopyModeAppend, incorrectMD5, expectedDstSizeBeforeTransfer)
+	err = MoveFile(context.Background(), srcPath, dstPath, CopyModeAppend, nil, incorrectMD5, expectedDstSizeBeforeTransfer, nil)
 	assert.Error(t, err, "Move should fail with incorrect MD5")
 	assert.Contains(t, err.Error(), "digest mismatch", "Error should mention digest mismatch")
 
@@ -191,6 +197,98 @@ func TestMoveFile_AppendModeRollback(t *testing.T) {
 	assert.Equal(t, dstContent, th.ReadFile(dstPath), "Destination should be rolled back to original content")
 }
 
+// Test a cancelled append keeping the source and the original part of the destination
+func TestMoveFile_AppendModeCancelled(t *testing.T) {
+	th := NewFileMoveTestHelper(t)
+	defer th.Cleanup()
+
+	srcPath := th.CreateTestFile("source.txt", "World!")
+	dstPath := th.CreateTestFile("destination.txt", "Hello, ")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := MoveFile(ctx, srcPath, dstPath, CopyModeAppend, nil, "", 7, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.True(t, th.FileExists(srcPath), "Source file should still exist after cancelled move")
+	assert.Equal(t, "Hello, ", th.ReadFile(dstPath), "Destination should be rolled back to original content")
+
+	// a file locked by another mover is given up when ctx is done
+	lockFile, err := os.Open(dstPath + LockSuffix)
+	require.NoError(t, err)
+	defer lockFile.Close()
+	require.NoError(t, syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX))
+	ctx, cancel = context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = MoveFile(ctx, srcPath, dstPath, CopyModeAppend, nil, "", 7, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	require.NoError(t, syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN))
+	require.NoError(t, MoveFile(context.Background(), srcPath, dstPath, CopyModeAppend, nil, "", 7, nil))
+	assert.Equal(t, "Hello, World!", th.ReadFile(dstPath))
+}
+
+// Test an append interrupted by a crash being rolled back by the next move
+func TestMoveFile_AppendJournal(t *testing.T) {
+	th := NewFileMoveTestHelper(t)
+	defer th.Cleanup()
+
+	srcPath := th.CreateTestFile("source.txt", "World!")
+	dstPath := th.CreateTestFile("destination.txt", "Hello, Wor")
+	expectedPath := th.CreateTestFile("expected.txt", "Hello, World!")
+	require.NoError(t, writeAppendJournal(dstPath, AppendJournal{OriginalSize: 7, FinalSize: 13}))
+
+	require.NoError(t, MoveFile(context.Background(), srcPath, dstPath, CopyModeAppend, nil, th.GetFileMD5(expectedPath), 7, nil))
+	assert.Equal(t, "Hello, World!", th.ReadFile(dstPath))
+	assert.False(t, th.FileExists(JournalPath(dstPath)), "Journal should be removed after the append")
+
+	// the size is unknown, the journal is of an append of the source
+	srcPath = th.CreateTestFile("source.txt", " Bye!")
+	require.NoError(t, os.WriteFile(dstPath, []byte("Hello, World! By"), 0644))
+	require.NoError(t, writeAppendJournal(dstPath, AppendJournal{OriginalSize: 13, FinalSize: 18}))
+	require.NoError(t, MoveFile(context.Background(), srcPath, dstPath, CopyModeAppend, nil, "", -1, nil))
+	assert.Equal(t, "Hello, World! Bye!", th.ReadFile(dstPath))
+
+	// the journal of another append is dropped without touching the destination
+	srcPath = th.CreateTestFile("source.txt", "!")
+	require.NoError(t, writeAppendJournal(dstPath, AppendJournal{OriginalSize: 7, FinalSize: 13}))
+	require.NoError(t, MoveFile(context.Background(), srcPath, dstPath, CopyModeAppend, nil, "", 18, nil))
+	assert.Equal(t, "Hello, World! Bye!!", th.ReadFile(dstPath))
+	assert.False(t, th.FileExists(JournalPath(dstPath)))
+
+	// a failed append removes its journal with the rollback
+	srcPath = th.CreateTestFile("source.txt", "?")
+	err := MoveFile(context.Background(), srcPath, dstPath, CopyModeAppend, nil, "incorrectmd5hash", 19, nil)
+	assert.ErrorContains(t, err, "digest mismatch")
+	assert.Equal(t, "Hello, World! Bye!!", th.ReadFile(dstPath))
+	assert.False(t, th.FileExists(JournalPath(dstPath)))
+}
+
+// Test append mode verified with another digest algorithm
+func TestMoveFile_AppendModeDigester(t *testing.T) {
+	th := NewFileMoveTestHelper(t)
+	defer th.Cleanup()
+
+	srcPath := th.CreateTestFile("source.txt", "World!")
+	dstPath := th.CreateTestFile("destination.txt", "Hello, ")
+	expectedPath := th.CreateTestFile("expected.txt", "Hello, World!")
+	digester, err := NewDigester("blake3")
+	require.NoError(t, err)
+	expected, err := digester.Digest(expectedPath, 0, 0)
+	require.NoError(t, err)
+
+	// the sample MD5 of the same bytes doesn't match a BLAKE3 digest
+	err = MoveFile(context.Background(), srcPath, dstPath, CopyModeAppend, nil, expected, 7, nil)
+	assert.ErrorContains(t, err, "digest mismatch")
+	assert.Equal(t, "Hello, ", th.ReadFile(dstPath), "Destination should be rolled back to original content")
+
+	err = MoveFile(context.Background(), srcPath, dstPath, CopyModeAppend, digester, expected, 7, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello, World!", th.ReadFile(dstPath))
+	assert.False(t, th.FileExists(srcPath), "Source file should be deleted after move")
+}
+
 // Test move with non-existent source file
 func TestMoveFile_NonExistentSource(t *testing.T) {
 	th := NewFileMoveTestHelper(t)
@@ -199,7 +297,7 @@ func TestMoveFile_NonExistentSource(t *testing.T) {
 	srcPath := th.GetTempPath("nonexistent.txt")
 	dstPath := th.GetTempPath("destination.txt")
 
-	err := MoveFile(srcPath, dstPath, CopyModeOverwrite, "", -1)
+	err := MoveFile(context.Background(), srcPath, dstPath, CopyModeOverwrite, nil, "", -1, nil)
 	assert.Error(t, err, "Move should fail for non-existent source")
 	assert.Contains(t, err.Error(), "unable to get source file info", "Error should mention source file info")
 }
@@ -215,7 +313,7 @@ func TestMoveFile_DirectoryAsSource(t *testing.T) {
 
 	dstPath := th.GetTempPath("destination.txt")
 
-	err := MoveFile(srcPath, dstPath, CopyModeOverwrite, "", -1)
+	err := MoveFile(context.Background(), srcPath, dstPath, CopyModeOverwrite, nil, "", -1, nil)
 	assert.Error(t, err, "Move should fail for directory source")
 	assert.Contains(t, err.Error(), "not a regular file", "Error should mention regular file requirement")
 }
@@ -232,7 +330,7 @@ func TestMoveFile_OverwriteExistingFile(t *testing.T) {
 
 	expectedMD5 := th.GetFileMD5(srcPath)
 
-	err := MoveFile(srcPath, dstPath, CopyModeOverwrite, expectedMD5, -1)
+	err := MoveFile(context.Background(), srcPath, dstPath, CopyModeOverwrite, nil, expectedMD5, -1, nil)
 	assert.NoError(t, err, "Move should succeed")
 
 	// Verify destination is overwritten
@@ -240,6 +338,57 @@ func TestMoveFile_OverwriteExistingFile(t *testing.T) {
 	assert.False(t, th.FileExists(srcPath), "Source should be deleted")
 }
 
+// Test overwrite mode renaming the source on the same filesystem and copying it to another
+func TestMoveFile_OverwriteModeRename(t *testing.T) {
+	th := NewFileMoveTestHelper(t)
+	defer th.Cleanup()
+
+	srcPath := th.CreateTestFile("source.txt", "New content")
+	dstPath := th.CreateTestFile("destination.txt", "Old content")
+	require.NoError(t, os.Chmod(srcPath, 0600))
+	require.NoError(t, os.Chmod(dstPath, 0640))
+	srcStat, err := os.Stat(srcPath)
+	require.NoError(t, err)
+
+	// a mover waiting for the lock meanwhile locks the same file, not the replaced inode
+	lockFile, err := os.OpenFile(dstPath+LockSuffix, os.O_WRONLY|os.O_CREATE, 0666)
+	require.NoError(t, err)
+	defer lockFile.Close()
+
+	require.NoError(t, MoveFile(context.Background(), srcPath, dstPath, CopyModeOverwrite, nil, th.GetFileMD5(srcPath), -1, nil))
+	dstStat, err := os.Stat(dstPath)
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(srcStat, dstStat), "Source should be renamed instead of copied")
+	assert.Equal(t, os.FileMode(0640), dstStat.Mode().Perm(), "The mode of the replaced destination should be kept")
+	assert.False(t, th.FileExists(srcPath), "Source should be deleted")
+	lockStat, err := lockFile.Stat()
+	require.NoError(t, err)
+	pathStat, err := os.Stat(dstPath + LockSuffix)
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(lockStat, pathStat), "The lock file should stay in place")
+
+	// the digest is still verified before the rename
+	srcPath = th.CreateTestFile("source.txt", "Newer content")
+	err = MoveFile(context.Background(), srcPath, dstPath, CopyModeOverwrite, nil, "incorrectmd5hash", -1, nil)
+	assert.ErrorContains(t, err, "digest mismatch")
+	assert.Equal(t, "New content", th.ReadFile(dstPath))
+
+	otherDir, err := os.MkdirTemp("/dev/shm", "filemove_test_")
+	if err != nil {
+		t.Skip("no /dev/shm to move across filesystems")
+	}
+	defer os.RemoveAll(otherDir)
+	otherStat, err := os.Stat(otherDir)
+	require.NoError(t, err)
+	if otherStat.Sys().(*syscall.Stat_t).Dev == srcStat.Sys().(*syscall.Stat_t).Dev {
+		t.Skip("/dev/shm is on the filesystem of the temporary directory")
+	}
+	otherPath := filepath.Join(otherDir, "destination.txt")
+	require.NoError(t, MoveFile(context.Background(), srcPath, otherPath, CopyModeOverwrite, nil, th.GetFileMD5(srcPath), -1, nil))
+	assert.Equal(t, "Newer content", th.ReadFile(otherPath))
+	assert.False(t, th.FileExists(srcPath), "Source should be deleted")
+}
+
 // Test move without MD5 verification (empty digest)
 func TestMoveFile_NoMD5Verification(t *testing.T) {
 	th := NewFileMoveTestHelper(t)
@@ -250,7 +399,7 @@ func TestMoveFile_NoMD5Verification(t *testing.T) {
 	dstPath := th.GetTempPath("destination.txt")
 
 	// Move without MD5 verification (empty string)
-	err := MoveFile(srcPath, dstPath, CopyModeOverwrite, "", -1)
+	err := MoveFile(context.Background(), srcPath, dstPath, CopyModeOverwrite, nil, "", -1, nil)
 	assert.NoError(t, err, "Move should succeed without MD5 verification")
 
 	assert.False(t, th.FileExists(srcPath), "Source should be deleted")
@@ -269,7 +418,7 @@ func TestMoveFile_AppendModeNoSizeCheck(t *testing.T) {
 	dstPath := th.CreateTestFile("destination.txt", dstContent)
 
 	// Use -1 to skip size check
-	err := MoveFile(srcPath, dstPath, CopyModeAppend, "", -1)
+	err := MoveFile(context.Background(), srcPath, dstPath, CopyModeAppend, nil, "", -1, nil)
 	assert.NoError(t, err, "Move should succeed without size check")
 
 	expectedContent := dstContent + srcContent
@@ -277,6 +426,136 @@ func TestMoveFile_AppendModeNoSizeCheck(t *testing.T) {
 	assert.False(t, th.FileExists(srcPath), "Source should be deleted")
 }
 
+// Test the kernel copy appending to the offset of the destination
+func TestCopyFileRange(t *testing.T) {
+	th := NewFileMoveTestHelper(t)
+	defer th.Cleanup()
+
+	src, err := os.Open(th.CreateTestFile("source.txt", "World!"))
+	require.NoError(t, err)
+	defer src.Close()
+	dstPath := th.CreateTestFile("destination.txt", "Hello, ")
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	defer dst.Close()
+	_, err = dst.Seek(7, io.SeekStart)
+	require.NoError(t, err)
+
+	var counter atomic.Int64
+	written, ok, err := copyFileRange(context.Background(), dst, src, &counter)
+	require.NoError(t, err)
+	if !ok {
+		t.Skip("copy_file_range isn't supported here")
+	}
+	assert.EqualValues(t, 6, written)
+	assert.EqualValues(t, 6, counter.Load())
+	assert.Equal(t, "Hello, World!", th.ReadFile(dstPath))
+}
+
+// Test appending with direct I/O and a small buffer, with an unaligned head and tail
+func TestMoveFile_AppendModeDirectIO(t *testing.T) {
+	th := NewFileMoveTestHelper(t)
+	defer th.Cleanup()
+	defer SetMoveOptions(MoveOptions{})
+
+	assert.Error(t, SetMoveOptions(MoveOptions{BufferSize: -1}))
+	assert.Error(t, SetMoveOptions(MoveOptions{BufferSize: 1000, DirectIO: true}))
+	require.NoError(t, SetMoveOptions(MoveOptions{BufferSize: 8192, DirectIO: true}))
+
+	dstContent := strings.Repeat("d", 100)
+	srcContent := make([]byte, 3*8192+4096+123)
+	for i := range srcContent {
+		srcContent[i] = byte(i % 251)
+	}
+	srcPath := th.CreateTestFile("source.txt", string(srcContent))
+	dstPath := th.CreateTestFile("destination.txt", dstContent)
+	expectedPath := th.CreateTestFile("expected.txt", dstContent+string(srcContent))
+
+	require.NoError(t, MoveFile(context.Background(), srcPath, dstPath, CopyModeAppend, nil, th.GetFileMD5(expectedPath), 100, nil))
+	assert.Equal(t, dstContent+string(srcContent), th.ReadFile(dstPath))
+	assert.False(t, th.FileExists(srcPath), "Source should be deleted")
+}
+
+// Test moves syncing the destinations before removing the sources
+func TestMoveFile_Sync(t *testing.T) {
+	th := NewFileMoveTestHelper(t)
+	defer th.Cleanup()
+	defer SetMoveOptions(MoveOptions{})
+	require.NoError(t, SetMoveOptions(MoveOptions{Sync: true}))
+
+	srcPath := th.CreateTestFile("source.txt", "Hello, ")
+	dstPath := th.GetTempPath("dst/destination.txt")
+	require.NoError(t, os.MkdirAll(filepath.Dir(dstPath), 0755))
+	require.NoError(t, MoveFile(context.Background(), srcPath, dstPath, CopyModeOverwrite, nil, th.GetFileMD5(srcPath), -1, nil))
+	srcPath = th.CreateTestFile("source.txt", "World!")
+	require.NoError(t, MoveFile(context.Background(), srcPath, dstPath, CopyModeAppend, nil, "", 7, nil))
+	assert.Equal(t, "Hello, World!", th.ReadFile(dstPath))
+	assert.False(t, th.FileExists(srcPath), "Source should be deleted")
+}
+
+// Test the progress passed to the callback while copying
+func TestReportMoveProgress(t *testing.T) {
+	defer func(interval time.Duration) { moveProgressInterval = interval }(moveProgressInterval)
+	moveProgressInterval = 10 * time.Millisecond
+
+	var copied atomic.Int64
+	copied.Store(25)
+	progress := make(chan MoveProgress, 100)
+	stop := reportMoveProgress(&copied, 100, "src", "dst", func(p MoveProgress) { progress <- p })
+	p := <-progress
+	stop()
+	assert.Equal(t, int64(25), p.Copied)
+	assert.Equal(t, int64(100), p.Size)
+	assert.Equal(t, 25.0, p.Percent)
+	assert.Greater(t, p.Rate, 0.0)
+
+	// no calls after stop returned
+	n := len(progress)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, n, len(progress))
+}
+
+// Test copies resumed after transient errors
+func TestRetryCopy(t *testing.T) {
+	defer func(delay time.Duration) { moveRetryDelay = delay }(moveRetryDelay)
+	moveRetryDelay = time.Millisecond
+
+	var offsets []int64
+	copyOnce := func(errs ...error) func(int64) (int64, error) {
+		return func(offset int64) (int64, error) {
+			offsets = append(offsets, offset)
+			err := errs[0]
+			errs = errs[1:]
+			return 5, err
+		}
+	}
+	resume := func(written int64) (int64, error) { return written, nil }
+
+	written, err := retryCopy(context.Background(), "a", copyOnce(&os.PathError{Op: "read", Err: syscall.EIO}, syscall.ESTALE, nil), resume)
+	require.NoError(t, err)
+	assert.EqualValues(t, 15, written)
+	assert.Equal(t, []int64{0, 5, 10}, offsets)
+
+	// other errors aren't retried
+	offsets = nil
+	_, err = retryCopy(context.Background(), "a", copyOnce(syscall.ENOSPC), resume)
+	assert.ErrorIs(t, err, syscall.ENOSPC)
+	assert.Len(t, offsets, 1)
+
+	// nor transient ones more than moveRetries times
+	offsets = nil
+	_, err = retryCopy(context.Background(), "a", copyOnce(syscall.EIO, syscall.EIO, syscall.EIO, syscall.EIO), resume)
+	assert.ErrorIs(t, err, syscall.EIO)
+	assert.Len(t, offsets, moveRetries+1)
+
+	// a full digest starts over
+	offsets = nil
+	written, err = retryCopy(context.Background(), "a", copyOnce(syscall.EIO, nil), func(int64) (int64, error) { return 0, nil })
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, written)
+	assert.Equal(t, []int64{0, 0}, offsets)
+}
+
 // Test large file move (basic performance test)
 func TestMoveFile_LargeFile(t *testing.T) {
 	th := NewFileMoveTestHelper(t)
@@ -293,7 +572,7 @@ func TestMoveFile_LargeFile(t *testing.T) {
 
 	expectedMD5 := th.GetFileMD5(srcPath)
 
-	err := MoveFile(srcPath, dstPath, CopyModeOverwrite, expectedMD5, -1)
+	err := MoveFile(context.Background(), srcPath, dstPath, CopyModeOverwrite, nil, expectedMD5, -1, nil)
 	assert.NoError(t, err, "Large file move should succeed")
 
 	// Verify content integrity
@@ -0,0 +1,103 @@
+package woc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+// JournalSuffix is appended to the path of a destination to name the journal of an append to it
+const JournalSuffix = ".syncmate-journal"
+
+// LockSuffix is appended to the path of a destination to name the file MoveFile locks while it writes the
+// destination. The destination itself can't be locked, its inode is replaced when it is overwritten by a rename.
+// The lock files are left in place, a removed one could be locked by a waiting mover meanwhile.
+const LockSuffix = ".syncmate-lock"
+
+// AppendJournal is written next to a destination before MoveFile appends to it and removed once the
+// append is verified, so that an append interrupted by a crash is undone by the next MoveFile
+type AppendJournal struct {
+	// OriginalSize is the size of the destination before the append
+	OriginalSize int64 `json:"original_size"`
+	// FinalSize is the size of the destination after the append
+	FinalSize int64 `json:"final_size"`
+	// Digest is the expected digest of the destination after the append, if it is verified
+	Digest          string `json:"digest,omitempty"`
+	DigestAlgorithm string `json:"digest_algorithm,omitempty"`
+}
+
+// JournalPath returns the name of the journal of the appends to dstPath
+func JournalPath(dstPath string) string {
+	return dstPath + JournalSuffix
+}
+
+// writeAppendJournal records j for dstPath, flushed to the disk before the append writes anything
+func writeAppendJournal(dstPath string, j AppendJournal) error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(JournalPath(dstPath), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readAppendJournal returns the journal of dstPath, nil if there is none
+func readAppendJournal(dstPath string) (*AppendJournal, error) {
+	data, err := os.ReadFile(JournalPath(dstPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var j AppendJournal
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, fmt.Errorf("invalid journal %s: %w", JournalPath(dstPath), err)
+	}
+	return &j, nil
+}
+
+// removeAppendJournal removes the journal of dstPath if there is one
+func removeAppendJournal(dstPath string) error {
+	if err := os.Remove(JournalPath(dstPath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// recoverAppend undoes an interrupted append to dst, which is locked and has size bytes: it is cut back
+// to the size its journal recorded if that is the size expectedSize the next append expects, or
+// expectedSize is unknown (-1) and the journal is of an append of appendSize bytes. A journal of
+// another append is removed without touching dst. It returns the size of dst afterwards.
+func recoverAppend(dst *os.File, dstPath string, size, expectedSize, appendSize int64) (int64, error) {
+	j, err := readAppendJournal(dstPath)
+	if err != nil || j == nil {
+		return size, err
+	}
+	fields := logger.Fields{"file": dstPath, "originalSize": j.OriginalSize, "finalSize": j.FinalSize, "size": size}
+	matches := j.OriginalSize == expectedSize || (expectedSize < 0 && j.FinalSize == j.OriginalSize+appendSize)
+	switch {
+	case !matches:
+		logger.WithFields(fields).Warn("Removing the journal of another append")
+	case size > j.OriginalSize:
+		logger.WithFields(fields).Warn("Rolling back an interrupted append")
+		if err := dst.Truncate(j.OriginalSize); err != nil {
+			return size, fmt.Errorf("failed to roll back interrupted append: %w", err)
+		}
+		size = j.OriginalSize
+	}
+	return size, removeAppendJournal(dstPath)
+}
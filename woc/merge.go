@@ -0,0 +1,77 @@
+package woc
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// MergeProfiles combines the profiles of several mounts of a host, e.g. scanned separately, into one.
+// The versions of a map are combined; a map version or an object in more than one profile must be
+// the same in all of them, and so must the version orders and digest algorithms. names name the
+// profiles in the errors.
+func MergeProfiles(profiles []*WocProfile, names []string) (*WocProfile, error) {
+	name := func(i int) string {
+		if i < len(names) {
+			return names[i]
+		}
+		return fmt.Sprintf("profile %d", i+1)
+	}
+	merged := &WocProfile{
+		Maps:    make(map[string][]WocMap),
+		Objects: make(map[string]WocObject),
+	}
+	// the profiles each map version and object came from
+	mapSources := make(map[string]map[string]int)
+	objectSources := make(map[string]int)
+	for i, profile := range profiles {
+		if i == 0 {
+			merged.VersionOrder = profile.VersionOrder
+			merged.DigestAlgorithm = profile.DigestAlgorithm
+		} else {
+			if orDefault(profile.VersionOrder, DefaultVersionOrder) != orDefault(merged.VersionOrder, DefaultVersionOrder) {
+				return nil, fmt.Errorf("%s has version order %q, %s %q", name(i), profile.VersionOrder, name(0), merged.VersionOrder)
+			}
+			if orDefault(profile.DigestAlgorithm, DefaultDigestAlgorithm) != orDefault(merged.DigestAlgorithm, DefaultDigestAlgorithm) {
+				return nil, fmt.Errorf("%s has digest algorithm %q, %s %q", name(i), profile.DigestAlgorithm, name(0), merged.DigestAlgorithm)
+			}
+		}
+		merged.SchemaVersion = max(merged.SchemaVersion, profile.SchemaVersion)
+
+		for mapName, versions := range profile.Maps {
+			if mapSources[mapName] == nil {
+				mapSources[mapName] = make(map[string]int)
+			}
+			for _, m := range versions {
+				j, ok := mapSources[mapName][m.Version]
+				if !ok {
+					mapSources[mapName][m.Version] = i
+					merged.Maps[mapName] = append(merged.Maps[mapName], m)
+					continue
+				}
+				for _, other := range merged.Maps[mapName] {
+					if other.Version == m.Version && !reflect.DeepEqual(other, m) {
+						return nil, fmt.Errorf("map %s version %s differs between %s and %s", mapName, m.Version, name(j), name(i))
+					}
+				}
+			}
+		}
+		for objectName, object := range profile.Objects {
+			if j, ok := objectSources[objectName]; ok {
+				if !reflect.DeepEqual(merged.Objects[objectName], object) {
+					return nil, fmt.Errorf("object %s differs between %s and %s", objectName, name(j), name(i))
+				}
+				continue
+			}
+			objectSources[objectName] = i
+			merged.Objects[objectName] = object
+		}
+	}
+	return merged, nil
+}
+
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
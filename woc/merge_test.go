@@ -0,0 +1,49 @@
+package woc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeProfiles(t *testing.T) {
+	size, digest := 10, "0123456789abcdef"
+	shard := func(path string) []WocFile { return []WocFile{{Path: path, Size: &size, Digest: &digest}} }
+	basemaps := &WocProfile{
+		SchemaVersion: 2,
+		Maps: map[string][]WocMap{"c2pFull": {
+			{Version: "V2406", Shards: shard("/da5_fast/basemaps/c2pFullV2406.0.tch")},
+			{Version: "V2412", Shards: shard("/da5_fast/basemaps/c2pFullV2412.0.tch")},
+		}},
+	}
+	blobs := &WocProfile{
+		Maps: map[string][]WocMap{"c2pFull": {
+			{Version: "V2412", Shards: shard("/da5_fast/basemaps/c2pFullV2412.0.tch")},
+			{Version: "V2503", Shards: shard("/da5_data/basemaps/c2pFullV2503.0.tch")},
+		}},
+		Objects:         map[string]WocObject{"blob.bin": {Shards: shard("/da5_data/All.blobs/blob_0.bin")}},
+		DigestAlgorithm: DefaultDigestAlgorithm,
+	}
+
+	merged, err := MergeProfiles([]*WocProfile{basemaps, blobs}, []string{"basemaps.json", "blobs.json"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, merged.SchemaVersion)
+	var versions []string
+	for _, m := range merged.Maps["c2pFull"] {
+		versions = append(versions, m.Version)
+	}
+	assert.Equal(t, []string{"V2406", "V2412", "V2503"}, versions)
+	assert.Contains(t, merged.Objects, "blob.bin")
+
+	// the same version with other shards is a conflict
+	other := 20
+	blobs.Maps["c2pFull"][0].Shards[0].Size = &other
+	_, err = MergeProfiles([]*WocProfile{basemaps, blobs}, []string{"basemaps.json", "blobs.json"})
+	assert.EqualError(t, err, "map c2pFull version V2412 differs between basemaps.json and blobs.json")
+
+	blobs.Maps = nil
+	blobs.DigestAlgorithm = "blake3"
+	_, err = MergeProfiles([]*WocProfile{basemaps, blobs}, nil)
+	assert.EqualError(t, err, `profile 2 has digest algorithm "blake3", profile 1 ""`)
+}
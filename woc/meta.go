@@ -0,0 +1,213 @@
+package woc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MetaSuffix is appended to a virtual path to name its TransferMeta sidecar on R2
+const MetaSuffix = ".syncmate.json"
+
+const (
+	MetaModeOverwrite = "overwrite"
+	MetaModeAppend    = "append"
+)
+
+// TransferMeta is uploaded next to an object and tells recv how to place it,
+// so nothing has to be parsed from the object name.
+type TransferMeta struct {
+	VirtualPath string `json:"virtual_path"`
+	// Mode is "overwrite" or "append"
+	Mode string `json:"mode"`
+	// Offset is the expected destination size before an append
+	Offset       int64  `json:"offset"`
+	Size         int64  `json:"size"`
+	SourceDigest string `json:"source_digest,omitempty"`
+	TargetDigest string `json:"target_digest,omitempty"`
+	// Algorithms of the digests, sample_md5 if empty
+	SourceDigestAlgorithm string `json:"source_digest_algorithm,omitempty"`
+	TargetDigestAlgorithm string `json:"target_digest_algorithm,omitempty"`
+}
+
+// MetaPath returns the name of the sidecar of virtualPath
+func MetaPath(virtualPath string) string {
+	return virtualPath + MetaSuffix
+}
+
+// IsMetaPath reports whether name is a sidecar
+func IsMetaPath(name string) bool {
+	return strings.HasSuffix(name, MetaSuffix)
+}
+
+// Suffixes of the objects of a delta transfer, see package delta
+const (
+	// DeltaSuffix names the delta that send --delta uploads instead of the file
+	DeltaSuffix = ".syncmate.delta"
+	// SignatureSuffix names the block signatures of the old destination file that recv --delta uploads
+	SignatureSuffix = ".syncmate.sig"
+)
+
+// DeltaPath returns the name of the delta of virtualPath
+func DeltaPath(virtualPath string) string {
+	return virtualPath + DeltaSuffix
+}
+
+// SignaturePath returns the name of the signatures of the destination of virtualPath
+func SignaturePath(virtualPath string) string {
+	return virtualPath + SignatureSuffix
+}
+
+// CompanionOf returns the virtual path of the file a sidecar, a delta or a signature belongs to, and
+// whether name is one of them
+func CompanionOf(name string) (string, bool) {
+	for _, suffix := range []string{MetaSuffix, DeltaSuffix, SignatureSuffix} {
+		if virtualPath, ok := strings.CutSuffix(name, suffix); ok {
+			return virtualPath, true
+		}
+	}
+	return name, false
+}
+
+// NewTransferMeta describes the transfer of task
+func NewTransferMeta(task *WocSyncTask) *TransferMeta {
+	m := &TransferMeta{
+		VirtualPath:           task.VirtualPath,
+		Mode:                  MetaModeOverwrite,
+		Offset:                task.Offset,
+		Size:                  task.Size,
+		SourceDigestAlgorithm: task.SourceDigestAlgorithm,
+		TargetDigestAlgorithm: task.TargetDigestAlgorithm,
+	}
+	if task.Offset > 0 {
+		m.Mode = MetaModeAppend
+	}
+	if task.SourceDigest != nil {
+		m.SourceDigest = *task.SourceDigest
+	}
+	if task.TargetDigest != nil {
+		m.TargetDigest = *task.TargetDigest
+	}
+	return m
+}
+
+// ParseTransferMeta decodes a sidecar
+func ParseTransferMeta(data []byte) (*TransferMeta, error) {
+	var m TransferMeta
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse transfer metadata: %w", err)
+	}
+	if _, err := m.CopyMode(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// CopyMode returns the MoveFile mode of the transfer
+func (m *TransferMeta) CopyMode() (CopyMode, error) {
+	switch m.Mode {
+	case MetaModeOverwrite:
+		return CopyModeOverwrite, nil
+	case MetaModeAppend:
+		return CopyModeAppend, nil
+	default:
+		return 0, fmt.Errorf("unknown transfer mode %q", m.Mode)
+	}
+}
+
+// Check fails if task doesn't describe the transfer that was uploaded,
+// e.g. because send and recv used different profiles
+func (m *TransferMeta) Check(task *WocSyncTask) error {
+	expected := NewTransferMeta(task)
+	if *m != *expected {
+		return fmt.Errorf("task doesn't match the uploaded transfer: uploaded %+v, task %+v", *m, *expected)
+	}
+	return nil
+}
+
+// Keys of the metadata send attaches to the uploaded objects, see ObjectMetadata
+const (
+	MetadataSourceHost   = "syncmate-source-host"
+	MetadataSourcePath   = "syncmate-source-path"
+	MetadataRunID        = "syncmate-run-id"
+	MetadataMode         = "syncmate-mode"
+	MetadataOffset       = "syncmate-offset"
+	MetadataSourceDigest = "syncmate-source-digest"
+	MetadataTargetDigest = "syncmate-target-digest"
+	// the algorithms are only set if they aren't sample_md5
+	MetadataSourceDigestAlgorithm = "syncmate-source-digest-algorithm"
+	MetadataTargetDigestAlgorithm = "syncmate-target-digest-algorithm"
+)
+
+// ObjectMetadata is the metadata of an uploaded object: the transfer of its sidecar and where it came from,
+// so objects in the bucket describe themselves without the database
+type ObjectMetadata struct {
+	TransferMeta
+	SourceHost string
+	SourcePath string
+	RunID      string
+}
+
+// NewObjectMetadata describes the upload of task by host in the run
+func NewObjectMetadata(task *WocSyncTask, host, runID string) *ObjectMetadata {
+	return &ObjectMetadata{
+		TransferMeta: *NewTransferMeta(task),
+		SourceHost:   host,
+		SourcePath:   task.SourcePath,
+		RunID:        runID,
+	}
+}
+
+// Map returns the metadata as object metadata, without the empty fields
+func (m *ObjectMetadata) Map() map[string]string {
+	metadata := make(map[string]string)
+	for key, value := range map[string]string{
+		MetadataSourceHost:            m.SourceHost,
+		MetadataSourcePath:            m.SourcePath,
+		MetadataRunID:                 m.RunID,
+		MetadataMode:                  m.Mode,
+		MetadataOffset:                strconv.FormatInt(m.Offset, 10),
+		MetadataSourceDigest:          m.SourceDigest,
+		MetadataTargetDigest:          m.TargetDigest,
+		MetadataSourceDigestAlgorithm: m.SourceDigestAlgorithm,
+		MetadataTargetDigestAlgorithm: m.TargetDigestAlgorithm,
+	} {
+		if value != "" {
+			metadata[key] = value
+		}
+	}
+	return metadata
+}
+
+// ParseObjectMetadata reads the metadata of the object virtualPath of size bytes. It returns nil if the
+// object has none, e.g. because it was uploaded by an older version.
+func ParseObjectMetadata(virtualPath string, size int64, metadata map[string]string) (*ObjectMetadata, error) {
+	if metadata[MetadataMode] == "" {
+		return nil, nil
+	}
+	m := &ObjectMetadata{
+		TransferMeta: TransferMeta{
+			VirtualPath:           virtualPath,
+			Mode:                  metadata[MetadataMode],
+			Size:                  size,
+			SourceDigest:          metadata[MetadataSourceDigest],
+			TargetDigest:          metadata[MetadataTargetDigest],
+			SourceDigestAlgorithm: metadata[MetadataSourceDigestAlgorithm],
+			TargetDigestAlgorithm: metadata[MetadataTargetDigestAlgorithm],
+		},
+		SourceHost: metadata[MetadataSourceHost],
+		SourcePath: metadata[MetadataSourcePath],
+		RunID:      metadata[MetadataRunID],
+	}
+	if _, err := m.CopyMode(); err != nil {
+		return nil, err
+	}
+	if offset := metadata[MetadataOffset]; offset != "" {
+		var err error
+		if m.Offset, err = strconv.ParseInt(offset, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid %s %q in the object metadata", MetadataOffset, offset)
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,89 @@
+package woc
+
+import (
+	"encoding/json"
+	"testing"
+
+	of "github.com/hrz6976/syncmate/offsetfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferMeta(t *testing.T) {
+	srcDigest, dstDigest := "0123456789abcdef", "fedcba9876543210"
+	task := &WocSyncTask{
+		FileConfig:   of.FileConfig{VirtualPath: "blob_0.bin.offset.100", Offset: 100, Size: 50},
+		SourceDigest: &srcDigest,
+		TargetDigest: &dstDigest,
+	}
+
+	data, err := json.Marshal(NewTransferMeta(task))
+	require.NoError(t, err)
+	m, err := ParseTransferMeta(data)
+	require.NoError(t, err)
+	mode, err := m.CopyMode()
+	require.NoError(t, err)
+	assert.Equal(t, CopyModeAppend, mode)
+	assert.Equal(t, int64(100), m.Offset)
+	assert.NoError(t, m.Check(task))
+
+	other := *task
+	other.Size = 60
+	assert.Error(t, m.Check(&other))
+	// the digests of another algorithm aren't the uploaded transfer either
+	other = *task
+	other.SourceDigestAlgorithm = "blake3"
+	assert.Error(t, m.Check(&other))
+
+	full := &WocSyncTask{FileConfig: of.FileConfig{VirtualPath: "name.with.dots", Size: 10}}
+	assert.Equal(t, MetaModeOverwrite, NewTransferMeta(full).Mode)
+	assert.True(t, IsMetaPath(MetaPath("name.with.dots")))
+	assert.False(t, IsMetaPath("name.with.dots"))
+	for _, name := range []string{MetaPath("a.tch"), DeltaPath("a.tch"), SignaturePath("a.tch")} {
+		virtualPath, ok := CompanionOf(name)
+		assert.True(t, ok)
+		assert.Equal(t, "a.tch", virtualPath)
+	}
+	virtualPath, ok := CompanionOf("a.tch")
+	assert.False(t, ok)
+	assert.Equal(t, "a.tch", virtualPath)
+
+	_, err = ParseTransferMeta([]byte(`{"mode":"prepend"}`))
+	assert.Error(t, err)
+}
+
+func TestObjectMetadata(t *testing.T) {
+	srcDigest := "0123456789abcdef"
+	task := &WocSyncTask{
+		FileConfig:   of.FileConfig{VirtualPath: "blob_0.bin.offset.100", SourcePath: "/data/blob_0.bin", Offset: 100, Size: 50},
+		SourceDigest: &srcDigest,
+	}
+	metadata := NewObjectMetadata(task, "da5", "2025q1").Map()
+	assert.Equal(t, map[string]string{
+		MetadataSourceHost:   "da5",
+		MetadataSourcePath:   "/data/blob_0.bin",
+		MetadataRunID:        "2025q1",
+		MetadataMode:         MetaModeAppend,
+		MetadataOffset:       "100",
+		MetadataSourceDigest: srcDigest,
+	}, metadata)
+
+	m, err := ParseObjectMetadata(task.VirtualPath, 50, metadata)
+	require.NoError(t, err)
+	assert.Equal(t, "da5", m.SourceHost)
+	assert.NoError(t, m.Check(task))
+
+	task.TargetDigestAlgorithm = "xxhash64:sampled"
+	metadata = NewObjectMetadata(task, "da5", "2025q1").Map()
+	assert.Equal(t, "xxhash64:sampled", metadata[MetadataTargetDigestAlgorithm])
+	m, err = ParseObjectMetadata(task.VirtualPath, 50, metadata)
+	require.NoError(t, err)
+	assert.NoError(t, m.Check(task))
+
+	m, err = ParseObjectMetadata(task.VirtualPath, 50, map[string]string{"mtime": "2025-01-10T14:02:30Z"})
+	assert.NoError(t, err)
+	assert.Nil(t, m, "objects without metadata are accepted")
+	metadata[MetadataOffset] = "a lot"
+	_, err = ParseObjectMetadata(task.VirtualPath, 50, metadata)
+	assert.Error(t, err)
+}
@@ -0,0 +1,97 @@
+package woc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hrz6976/syncmate/tracing"
+	logger "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// interval between digest progress logs
+const digestProgressInterval = 10 * time.Second
+
+// digestJob is a digest computation over the first size bytes of a file
+type digestJob struct {
+	path string
+	// size is the number of bytes to digest, 0 for the whole file
+	size int64
+	// digester computes the digest, SampleMD5 if nil
+	digester Digester
+
+	digest string
+	err    error
+}
+
+// digestFiles computes the digest of every job with a bounded number of workers.
+// Per-file errors are stored in the jobs; the returned error is only set when ctx is cancelled.
+func digestFiles(ctx context.Context, jobs []*digestJob, workers int) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	workers = min(workers, len(jobs))
+
+	var finished atomic.Int64
+	stopProgress := make(chan struct{})
+	var progressWg sync.WaitGroup
+	progressWg.Add(1)
+	go func() {
+		defer progressWg.Done()
+		ticker := time.NewTicker(digestProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				logger.Infof("Calculated %d/%d digests", finished.Load(), len(jobs))
+			case <-stopProgress:
+				return
+			}
+		}
+	}()
+
+	jobChan := make(chan *digestJob)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobChan {
+				logger.WithFields(logger.Fields{
+					"path": job.path,
+					"size": job.size,
+				}).Debug("Calculating digest for file")
+				_, span := tracing.Start(ctx, "digest", attribute.String("syncmate.path", job.path), attribute.Int64("syncmate.bytes", job.size))
+				job.digest, job.err = digesterOrDefault(job.digester).Digest(job.path, 0, job.size)
+				tracing.End(span, job.err)
+				finished.Add(1)
+			}
+		}()
+	}
+
+	var err error
+feed:
+	for _, job := range jobs {
+		select {
+		case jobChan <- job:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break feed
+		}
+	}
+	close(jobChan)
+	wg.Wait()
+	close(stopProgress)
+	progressWg.Wait()
+
+	if err != nil {
+		logger.Infof("Digest calculation cancelled after %d/%d files", finished.Load(), len(jobs))
+		return err
+	}
+	return nil
+}
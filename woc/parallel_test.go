@@ -0,0 +1,53 @@
+package woc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigestFiles(t *testing.T) {
+	tempDir := t.TempDir()
+
+	var jobs []*digestJob
+	for i := range 8 {
+		path := filepath.Join(tempDir, fmt.Sprintf("shard.%d", i))
+		content := make([]byte, 10000+i*1000)
+		for j := range content {
+			content[j] = byte((j*7 + i) % 251)
+		}
+		require.NoError(t, os.WriteFile(path, content, 0644))
+		jobs = append(jobs, &digestJob{path: path})
+	}
+	// partial digest of the first shard
+	jobs = append(jobs, &digestJob{path: jobs[0].path, size: 5000})
+	// missing files are reported per job
+	jobs = append(jobs, &digestJob{path: filepath.Join(tempDir, "missing")})
+
+	require.NoError(t, digestFiles(context.Background(), jobs, 3))
+
+	for _, job := range jobs[:len(jobs)-1] {
+		require.NoError(t, job.err)
+		expected, err := SampleMD5(job.path, 0, job.size)
+		require.NoError(t, err)
+		assert.Equal(t, expected.Digest, job.digest, "digest mismatch for %s", job.path)
+	}
+	assert.NotEqual(t, jobs[0].digest, jobs[8].digest)
+	assert.True(t, os.IsNotExist(jobs[len(jobs)-1].err))
+}
+
+func TestDigestFiles_Cancelled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shard")
+	require.NoError(t, os.WriteFile(path, []byte("content"), 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	jobs := []*digestJob{{path: path}, {path: path}, {path: path}}
+	err := digestFiles(ctx, jobs, 1)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -3,8 +3,10 @@ package woc
 import (
 	"crypto/md5"
 	"fmt"
+	"hash"
 	"io"
 	"os"
+	"sync"
 )
 
 type SampleMD5Result struct {
@@ -24,13 +26,31 @@ type SampleMD5Result struct {
 //   - digest: The 16-character MD5 digest
 //   - error: Any error encountered during processing
 func SampleMD5(filePath string, skip int64, size int64) (*SampleMD5Result, error) {
-	// Get file size
-	fileInfo, err := os.Stat(filePath)
+	return sampleDigest(filePath, skip, size, md5.New)
+}
+
+// SampleMD5ReaderAt is SampleMD5 of the fsize bytes of r, such as an OffsetFS window, a remote object
+// or a bytes.Reader
+func SampleMD5ReaderAt(r io.ReaderAt, fsize int64, skip int64, size int64) (*SampleMD5Result, error) {
+	return sampleDigestReaderAt(r, fsize, skip, size, md5.New)
+}
+
+// sampleDigest is SampleMD5 with the samples hashed by newHash
+func sampleDigest(filePath string, skip int64, size int64, newHash func() hash.Hash) (*SampleMD5Result, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
 	if err != nil {
 		return nil, err
 	}
-	fsize := fileInfo.Size()
+	return sampleDigestReaderAt(file, fileInfo.Size(), skip, size, newHash)
+}
 
+// sampleDigestReaderAt is SampleMD5ReaderAt with the samples hashed by newHash
+func sampleDigestReaderAt(r io.ReaderAt, fsize int64, skip int64, size int64, newHash func() hash.Hash) (*SampleMD5Result, error) {
 	// Determine the size to consider
 	var actualSize int64
 	if size <= 0 {
@@ -44,84 +64,153 @@ func SampleMD5(filePath string, skip int64, size int64) (*SampleMD5Result, error
 		return nil, fmt.Errorf("supplied size %dB > file size %dB", actualSize, fsize)
 	}
 
-	// Open file
-	file, err := os.Open(filePath)
+	samples, err := readSamples(r, skip, sampleRanges(actualSize))
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	hasher := newHash()
+	for _, sample := range samples {
+		hasher.Write(sample)
+	}
 
-	// Initialize MD5 hasher
-	hasher := md5.New()
+	digest := fmt.Sprintf("%x", hasher.Sum(nil))
+	return &SampleMD5Result{
+		Size:   actualSize,
+		Digest: digest[:16],
+	}, nil
+}
 
-	// Hash all bytes if file is small
-	if actualSize <= 4096 { // typical block size of ext4
-		_, err = file.Seek(skip, io.SeekStart)
+// sampleReadWorkers is the number of samples read at once, to overlap the latency of NFS
+var sampleReadWorkers = 4
+
+// readSamples reads the ranges of r from skip on concurrently and returns them in the order of ranges
+func readSamples(r io.ReaderAt, skip int64, ranges []sampleRange) ([][]byte, error) {
+	samples := make([][]byte, len(ranges))
+	errs := make([]error, len(ranges))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(sampleReadWorkers, len(ranges)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				samples[i] = make([]byte, ranges[i].size)
+				_, errs[i] = r.ReadAt(samples[i], skip+ranges[i].offset)
+			}
+		}()
+	}
+	for i := range ranges {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	for _, err := range errs {
 		if err != nil {
 			return nil, err
 		}
+	}
+	return samples, nil
+}
 
-		buffer := make([]byte, actualSize)
-		_, err = file.Read(buffer)
-		if err != nil {
-			return nil, err
-		}
+// sampleRange is a run of bytes hashed by SampleMD5
+type sampleRange struct {
+	offset int64
+	size   int64
+}
 
-		hasher.Write(buffer)
-		digest := fmt.Sprintf("%x", hasher.Sum(nil))
-		return &SampleMD5Result{
-			Size:   actualSize,
-			Digest: digest[:16],
-		}, nil
+// sampleRanges returns the bytes SampleMD5 hashes from a portion of size bytes, in hashing order
+func sampleRanges(size int64) []sampleRange {
+	// Hash all bytes if file is small
+	if size <= 4096 { // typical block size of ext4
+		return []sampleRange{{0, size}}
 	}
 
 	// A heuristic to find the optimal chunk size
 	// Number of chunks is between 2 and 8, chunk size must be a power of 2
-	chunkSize := int64(1) << (bitLength(actualSize/bitLength(actualSize)) + 2)
-	numChunks := (actualSize - 256) / chunkSize // don't hash the same bytes twice
+	chunkSize := int64(1) << (bitLength(size/bitLength(size)) + 2)
+	numChunks := (size - 256) / chunkSize // don't hash the same bytes twice
 
-	// Seek to start position and read first 128 bytes
-	_, err = file.Seek(skip, io.SeekStart)
-	if err != nil {
-		return nil, err
+	// first 128 bytes, 128 bytes at the start of every chunk, last 128 bytes
+	ranges := []sampleRange{{0, 128}}
+	for i := int64(1); i <= numChunks; i++ {
+		ranges = append(ranges, sampleRange{i * chunkSize, 128})
 	}
+	return append(ranges, sampleRange{size - 128, 128})
+}
 
-	buffer := make([]byte, 128)
-	_, err = file.Read(buffer)
-	if err != nil {
-		return nil, err
+// SampleMD5Writer computes the SampleMD5 digest of a file while it is written,
+// so it doesn't have to be read back. The bytes written are the file from
+// the offset given to NewSampleMD5Writer on.
+type SampleMD5Writer struct {
+	newHash func() hash.Hash
+	size    int64
+	pos     int64
+	ranges  []sampleRange
+	samples [][]byte
+	filled  []int64
+}
+
+// NewSampleMD5Writer returns a writer for the digest of a file of size bytes whose bytes from offset on will be written
+func NewSampleMD5Writer(size int64, offset int64) *SampleMD5Writer {
+	return newSampleWriter(size, offset, md5.New)
+}
+
+// newSampleWriter is NewSampleMD5Writer with the samples hashed by newHash
+func newSampleWriter(size int64, offset int64, newHash func() hash.Hash) *SampleMD5Writer {
+	w := &SampleMD5Writer{newHash: newHash, size: size, pos: offset, ranges: sampleRanges(size)}
+	w.samples = make([][]byte, len(w.ranges))
+	w.filled = make([]int64, len(w.ranges))
+	for i, s := range w.ranges {
+		w.samples[i] = make([]byte, s.size)
 	}
-	hasher.Write(buffer)
+	return w
+}
 
-	// Hash chunks
-	for i := int64(0); i < numChunks; i++ {
-		_, err = file.Seek(chunkSize-128, io.SeekCurrent)
-		if err != nil {
-			return nil, err
+// ReadPrefix reads the samples before the offset of the writer from r, which holds the start of the file
+func (w *SampleMD5Writer) ReadPrefix(r io.ReaderAt) error {
+	for i, s := range w.ranges {
+		n := min(s.size, w.pos-s.offset)
+		if n <= 0 {
+			continue
 		}
-
-		_, err = file.Read(buffer)
-		if err != nil {
-			return nil, err
+		if _, err := r.ReadAt(w.samples[i][:n], s.offset); err != nil {
+			return fmt.Errorf("failed to read sample at %d: %w", s.offset, err)
 		}
-		hasher.Write(buffer)
+		w.filled[i] = max(w.filled[i], n)
 	}
+	return nil
+}
 
-	// Hash last 128 bytes
-	_, err = file.Seek(skip+actualSize-128, io.SeekStart)
-	if err != nil {
-		return nil, err
+// Write records the sampled bytes of p
+func (w *SampleMD5Writer) Write(p []byte) (int, error) {
+	start, end := w.pos, w.pos+int64(len(p))
+	for i, s := range w.ranges {
+		lo, hi := max(start, s.offset), min(end, s.offset+s.size)
+		if lo >= hi {
+			continue
+		}
+		copy(w.samples[i][lo-s.offset:hi-s.offset], p[lo-start:hi-start])
+		w.filled[i] = max(w.filled[i], hi-s.offset)
 	}
+	w.pos = end
+	return len(p), nil
+}
 
-	_, err = file.Read(buffer)
-	if err != nil {
-		return nil, err
+// Sum returns the digest, or an error if the file wasn't written up to its size
+func (w *SampleMD5Writer) Sum() (*SampleMD5Result, error) {
+	if w.pos != w.size {
+		return nil, fmt.Errorf("written %dB of %dB", w.pos, w.size)
+	}
+	hasher := w.newHash()
+	for i, sample := range w.samples {
+		if w.filled[i] != int64(len(sample)) {
+			return nil, fmt.Errorf("sample at %d is incomplete", w.ranges[i].offset)
+		}
+		hasher.Write(sample)
 	}
-	hasher.Write(buffer)
-
 	digest := fmt.Sprintf("%x", hasher.Sum(nil))
 	return &SampleMD5Result{
-		Size:   actualSize,
+		Size:   w.size,
 		Digest: digest[:16],
 	}, nil
 }
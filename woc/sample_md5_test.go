@@ -0,0 +1,102 @@
+package woc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampleMD5Writer(t *testing.T) {
+	tmpDir := t.TempDir()
+	rnd := rand.New(rand.NewSource(1))
+	for _, size := range []int64{0, 100, 4096, 4097, 70000, 1<<20 + 17} {
+		content := make([]byte, size)
+		rnd.Read(content)
+		path := filepath.Join(tmpDir, "file.bin")
+		require.NoError(t, os.WriteFile(path, content, 0644))
+		expected, err := SampleMD5(path, 0, 0)
+		require.NoError(t, err)
+
+		for _, offset := range []int64{0, size / 3, size} {
+			w := NewSampleMD5Writer(size, offset)
+			require.NoError(t, w.ReadPrefix(bytes.NewReader(content[:offset])))
+			// write in odd-sized pieces to cross the sample boundaries
+			for rest := content[offset:]; len(rest) > 0; {
+				n := min(len(rest), 1000)
+				_, err := w.Write(rest[:n])
+				require.NoError(t, err)
+				rest = rest[n:]
+			}
+			res, err := w.Sum()
+			require.NoError(t, err, "size %d, offset %d", size, offset)
+			assert.Equal(t, expected.Digest, res.Digest, "size %d, offset %d", size, offset)
+		}
+	}
+}
+
+func TestSampleMD5ReaderAt(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	content := make([]byte, 70000)
+	rnd.Read(content)
+	path := filepath.Join(t.TempDir(), "file.bin")
+	require.NoError(t, os.WriteFile(path, content, 0644))
+
+	for _, window := range [][2]int64{{0, 0}, {100, 0}, {1000, 5000}, {0, 70000}} {
+		expected, err := SampleMD5(path, window[0], window[1])
+		require.NoError(t, err)
+		res, err := SampleMD5ReaderAt(bytes.NewReader(content), int64(len(content)), window[0], window[1])
+		require.NoError(t, err)
+		assert.Equal(t, expected, res, "window %v", window)
+	}
+	// a window of a larger file is the file of its bytes
+	section := io.NewSectionReader(bytes.NewReader(content), 1000, 5000)
+	res, err := SampleMD5ReaderAt(section, 5000, 0, 0)
+	require.NoError(t, err)
+	expected, _ := SampleMD5(path, 1000, 5000)
+	assert.Equal(t, expected, res)
+
+	_, err = SampleMD5ReaderAt(bytes.NewReader(content), int64(len(content)), 100, int64(len(content)))
+	assert.ErrorContains(t, err, "file size")
+}
+
+// slowReaderAt is a file of zeros that waits latency before every read, like one on a busy NFS server
+type slowReaderAt struct {
+	latency time.Duration
+}
+
+func (r slowReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	time.Sleep(r.latency)
+	clear(p)
+	return len(p), nil
+}
+
+func BenchmarkSampleMD5ReaderAt(b *testing.B) {
+	r := slowReaderAt{2 * time.Millisecond}
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			defer func(n int) { sampleReadWorkers = n }(sampleReadWorkers)
+			sampleReadWorkers = workers
+			for b.Loop() {
+				if _, err := SampleMD5ReaderAt(r, 1<<40, 0, 0); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestSampleMD5Writer_Incomplete(t *testing.T) {
+	w := NewSampleMD5Writer(10000, 0)
+	_, err := w.Write(make([]byte, 5000))
+	require.NoError(t, err)
+	_, err = w.Sum()
+	assert.Error(t, err)
+}
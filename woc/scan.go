@@ -0,0 +1,210 @@
+package woc
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+// ProfileSchemaVersion is the wocSchemaVersion written by ScanProfile
+const ProfileSchemaVersion = 2
+
+var (
+	// map shards, e.g. c2pFullV2412.0.tch, or p2cFullU.tch without a shard number
+	mapShardRe = regexp.MustCompile(`^(\w+2\w+?)Full(\w+?)(?:\.(\d+))?\.tch$`)
+	// large values split off a map shard, e.g. c2pFullV2412.0.tch.large.1f2e
+	mapLargeRe = regexp.MustCompile(`^(\w+2\w+?)Full(\w+?)(?:\.(\d+))?\.tch\.large\.(\w+)$`)
+	// object shards, e.g. tree_0.idx or sha1.tree_127.tch, named tree.idx and sha1.tree.tch
+	objectShardRe = regexp.MustCompile(`^([\w.]+?)_(\d+)\.(tch|idx|bin)$`)
+)
+
+// ScanOptions controls how ScanProfile builds a profile
+type ScanOptions struct {
+	// DigestWorkers is the number of files digested in parallel. Values below 1 mean 1.
+	DigestWorkers int
+	// SkipDigest leaves the digests out of the profile, they are computed by task generation then.
+	SkipDigest bool
+	// Digester computes the digests, SampleMD5 if nil. Its algorithm is recorded in the profile.
+	Digester Digester
+}
+
+// scannedFile is a shard found by ScanProfile before it is grouped into a map or object
+type scannedFile struct {
+	shard int
+	file  WocFile
+}
+
+type scannedMap struct {
+	shards []scannedFile
+	larges map[string]WocFile
+}
+
+// ScanProfile lists the WoC files in dirs and groups them into maps and objects by their names.
+// Subdirectories are not scanned. Files that don't look like WoC shards are ignored.
+func ScanProfile(ctx context.Context, dirs []string, opt ScanOptions) (*WocProfile, error) {
+	maps := make(map[string]map[string]*scannedMap) // name -> version -> shards
+	objects := make(map[string][]scannedFile)
+
+	for _, dir := range dirs {
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			path := filepath.Join(dir, name)
+			stat, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			if !stat.Mode().IsRegular() {
+				continue
+			}
+			size := int(stat.Size())
+			file := WocFile{Path: path, Size: &size}
+
+			getMap := func(name, version string) *scannedMap {
+				if maps[name] == nil {
+					maps[name] = make(map[string]*scannedMap)
+				}
+				m, ok := maps[name][version]
+				if !ok {
+					m = &scannedMap{larges: make(map[string]WocFile)}
+					maps[name][version] = m
+				}
+				return m
+			}
+			switch {
+			case mapLargeRe.MatchString(name):
+				match := mapLargeRe.FindStringSubmatch(name)
+				m := getMap(match[1], match[2])
+				if _, ok := m.larges[match[4]]; ok {
+					return nil, fmt.Errorf("duplicate large file %s", path)
+				}
+				m.larges[match[4]] = file
+			case mapShardRe.MatchString(name):
+				match := mapShardRe.FindStringSubmatch(name)
+				m := getMap(match[1], match[2])
+				shard, _ := strconv.Atoi(match[3]) // a map without a shard number has only shard 0
+				m.shards = append(m.shards, scannedFile{shard, file})
+			case objectShardRe.MatchString(name):
+				match := objectShardRe.FindStringSubmatch(name)
+				shard, _ := strconv.Atoi(match[2])
+				object := match[1] + "." + match[3]
+				objects[object] = append(objects[object], scannedFile{shard, file})
+			default:
+				logger.WithField("path", path).Debug("Ignoring file that is not a WoC shard")
+			}
+		}
+	}
+
+	profile := &WocProfile{
+		SchemaVersion: ProfileSchemaVersion,
+		Maps:          make(map[string][]WocMap),
+		Objects:       make(map[string]WocObject),
+		// recorded with SkipDigest too, task generation computes the digests with it
+		DigestAlgorithm: DigestAlgorithmField(opt.Digester),
+	}
+	for name, versions := range maps {
+		for version, m := range versions {
+			if len(m.shards) == 0 {
+				logger.WithFields(logger.Fields{"map": name, "version": version}).Warn("Skipping large files of a map without shards")
+				continue
+			}
+			shards, shardingBits, err := orderShards(m.shards)
+			if err != nil {
+				return nil, fmt.Errorf("map %s version %s: %w", name, version, err)
+			}
+			profile.Maps[name] = append(profile.Maps[name], WocMap{
+				Version:      version,
+				ShardingBits: shardingBits,
+				Shards:       shards,
+				Larges:       m.larges,
+				DTypes:       []string{},
+			})
+		}
+		sort.Slice(profile.Maps[name], func(i, j int) bool {
+			return CompareVersionsNatural(profile.Maps[name][i].Version, profile.Maps[name][j].Version) < 0
+		})
+	}
+	for name, scanned := range objects {
+		shards, shardingBits, err := orderShards(scanned)
+		if err != nil {
+			return nil, fmt.Errorf("object %s: %w", name, err)
+		}
+		profile.Objects[name] = WocObject{ShardingBits: shardingBits, Shards: shards}
+	}
+
+	if opt.SkipDigest {
+		return profile, nil
+	}
+	var jobs []*digestJob
+	var setDigest []func(digest *string)
+	addJob := func(path string, set func(digest *string)) {
+		jobs = append(jobs, &digestJob{path: path, digester: opt.Digester})
+		setDigest = append(setDigest, set)
+	}
+	for _, versions := range profile.Maps {
+		for _, m := range versions {
+			for i := range m.Shards {
+				shard := &m.Shards[i]
+				addJob(shard.Path, func(digest *string) { shard.Digest = digest })
+			}
+			for key, large := range m.Larges {
+				// values in a map can't be updated in place
+				addJob(large.Path, func(digest *string) {
+					large.Digest = digest
+					m.Larges[key] = large
+				})
+			}
+		}
+	}
+	for _, object := range profile.Objects {
+		for i := range object.Shards {
+			shard := &object.Shards[i]
+			addJob(shard.Path, func(digest *string) { shard.Digest = digest })
+		}
+	}
+	logger.WithField("count", len(jobs)).Info("Calculating digests of the scanned files")
+	if err := digestFiles(ctx, jobs, opt.DigestWorkers); err != nil {
+		return nil, err
+	}
+	for i, job := range jobs {
+		if job.err != nil {
+			return nil, fmt.Errorf("failed to digest %s: %w", job.path, job.err)
+		}
+		digest := job.digest
+		setDigest[i](&digest)
+	}
+	return profile, nil
+}
+
+// orderShards sorts the shards by number and checks that they are 0 to 2^bits-1
+func orderShards(scanned []scannedFile) ([]WocFile, int, error) {
+	sort.Slice(scanned, func(i, j int) bool { return scanned[i].shard < scanned[j].shard })
+	shards := make([]WocFile, len(scanned))
+	for i, s := range scanned {
+		if s.shard != i {
+			if s.shard < i {
+				return nil, 0, fmt.Errorf("duplicate shard %d", s.shard)
+			}
+			return nil, 0, fmt.Errorf("shard %d is missing", i)
+		}
+		shards[i] = s.file
+	}
+	if bits.OnesCount(uint(len(shards))) != 1 {
+		return nil, 0, fmt.Errorf("%d shards is not a power of two", len(shards))
+	}
+	return shards, bits.TrailingZeros(uint(len(shards))), nil
+}
@@ -0,0 +1,91 @@
+package woc
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScanProfile(t *testing.T) {
+	tmpDir := t.TempDir()
+	basemaps := filepath.Join(tmpDir, "basemaps")
+	blobs := filepath.Join(tmpDir, "All.blobs")
+	for _, dir := range []string{basemaps, blobs, filepath.Join(blobs, "tmp")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		"basemaps/c2pFullV2412.0.tch":            "shard 0",
+		"basemaps/c2pFullV2412.1.tch":            "shard 1",
+		"basemaps/c2pFullV2412.0.tch.large.ab12": "large",
+		"basemaps/c2pFullU.0.tch":                "old shard",
+		"basemaps/p2cFullV2412.tch":              "single shard",
+		"basemaps/README":                        "not a shard",
+		"All.blobs/tree_0.idx":                   "idx 0",
+		"All.blobs/tree_1.idx":                   "idx 1",
+		"All.blobs/sha1.tree_0.tch":              "sha1 0",
+		"All.blobs/sha1.tree_1.tch":              "sha1 1",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	profile, err := ScanProfile(context.Background(), []string{basemaps, blobs}, ScanOptions{DigestWorkers: 2})
+	if err != nil {
+		t.Fatalf("Failed to scan profile: %v", err)
+	}
+	c2p := profile.Maps["c2p"]
+	if len(c2p) != 2 || c2p[0].Version != "U" || c2p[1].Version != "V2412" {
+		t.Fatalf("Expected versions U and V2412 of c2p, got %+v", c2p)
+	}
+	if c2p[1].ShardingBits != 1 || len(c2p[1].Shards) != 2 || c2p[1].Shards[1].Path != filepath.Join(basemaps, "c2pFullV2412.1.tch") {
+		t.Errorf("Unexpected shards of c2pFullV2412: %+v", c2p[1])
+	}
+	large, ok := c2p[1].Larges["ab12"]
+	if !ok || large.Digest == nil || *large.Size != len("large") {
+		t.Errorf("Expected a digested large file, got %+v", c2p[1].Larges)
+	}
+	if p2c := profile.Maps["p2c"]; len(p2c) != 1 || p2c[0].ShardingBits != 0 || len(p2c[0].Shards) != 1 {
+		t.Errorf("Unexpected p2c map: %+v", p2c)
+	}
+	for _, name := range []string{"tree.idx", "sha1.tree.tch"} {
+		object, ok := profile.Objects[name]
+		if !ok || object.ShardingBits != 1 || len(object.Shards) != 2 || object.Shards[0].Digest == nil {
+			t.Errorf("Unexpected object %s: %+v", name, object)
+		}
+	}
+	if len(profile.Objects) != 2 {
+		t.Errorf("Expected 2 objects, got %d", len(profile.Objects))
+	}
+
+	// the written profile can be parsed
+	data, err := json.Marshal(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(tmpDir, "woc.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseWocProfile(&path)
+	if err != nil {
+		t.Fatalf("Failed to parse scanned profile: %v", err)
+	}
+	if parsed.Maps["c2p"].Version != "V2412" {
+		t.Errorf("Expected the latest c2p version, got %s", parsed.Maps["c2p"].Version)
+	}
+
+	if err := os.Remove(filepath.Join(blobs, "tree_0.idx")); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ScanProfile(context.Background(), []string{blobs}, ScanOptions{SkipDigest: true})
+	if err == nil || !strings.Contains(err.Error(), "object tree.idx: shard 0 is missing") {
+		t.Errorf("Expected a missing shard error, got %v", err)
+	}
+}
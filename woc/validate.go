@@ -0,0 +1,183 @@
+package woc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ProfileIssue is a problem of a profile found by ValidateWocProfile
+type ProfileIssue struct {
+	// Field is the location of the problem in the profile, e.g. maps.c2pFull[0].shards[3]
+	Field string
+	// Line is the line of the field in the profile file, 0 if unknown
+	Line    int
+	Problem string
+}
+
+func (i ProfileIssue) String() string {
+	if i.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", i.Line, i.Field, i.Problem)
+	}
+	return fmt.Sprintf("%s: %s", i.Field, i.Problem)
+}
+
+// ProfileError is a profile that failed validation, see ParseOptions.Strict
+type ProfileError struct {
+	File   string
+	Issues []ProfileIssue
+}
+
+func (e *ProfileError) Error() string {
+	lines := make([]string, 0, len(e.Issues)+1)
+	lines = append(lines, fmt.Sprintf("%s has %d issues:", e.File, len(e.Issues)))
+	for _, issue := range e.Issues {
+		if issue.Line > 0 {
+			lines = append(lines, fmt.Sprintf("  %s:%d: %s: %s", e.File, issue.Line, issue.Field, issue.Problem))
+		} else {
+			lines = append(lines, fmt.Sprintf("  %s: %s: %s", e.File, issue.Field, issue.Problem))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ValidateWocProfile reports every shard of profile missing its path, size or digest, the paths in
+// more than one place, and the maps and objects without shards or with another number of shards than
+// their sharding bits give. data is the JSON profile was parsed from, which the lines of the issues
+// are looked up in; the issues are sorted by line.
+func ValidateWocProfile(data []byte, profile *WocProfile) []ProfileIssue {
+	lines := jsonFieldLines(data)
+	var issues []ProfileIssue
+	report := func(field, format string, args ...any) {
+		issues = append(issues, ProfileIssue{Field: field, Line: lines[field], Problem: fmt.Sprintf(format, args...)})
+	}
+	// fields by path, to tell where else a duplicate path is
+	seen := make(map[string]string)
+	checkFile := func(field string, file WocFile) {
+		if file.Path == "" {
+			report(field, "path is missing")
+		} else if other, ok := seen[file.Path]; ok {
+			report(field, "path %s is also %s", file.Path, fieldWithLine(other, lines[other]))
+		} else {
+			seen[file.Path] = field
+		}
+		if file.Size == nil {
+			report(field, "size is missing")
+		} else if *file.Size < 0 {
+			report(field, "size %d is negative", *file.Size)
+		}
+		if file.Digest == nil {
+			report(field, "digest is missing, the profile wasn't generated with --with-digest")
+		}
+	}
+	checkShards := func(field string, shardingBits int, shards []WocFile) {
+		if len(shards) == 0 {
+			report(field, "has no shards")
+		} else if shardingBits >= 0 && shardingBits < 31 && len(shards) != 1<<shardingBits {
+			report(field, "has %d shards, sharding_bits %d means %d", len(shards), shardingBits, 1<<shardingBits)
+		}
+		for i, shard := range shards {
+			checkFile(fmt.Sprintf("%s.shards[%d]", field, i), shard)
+		}
+	}
+
+	for _, name := range sortedKeys(profile.Maps) {
+		field := "maps." + name
+		if len(profile.Maps[name]) == 0 {
+			report(field, "has no versions")
+		}
+		for i, m := range profile.Maps[name] {
+			versionField := fmt.Sprintf("%s[%d]", field, i)
+			if m.Version == "" {
+				report(versionField, "version is missing")
+			}
+			checkShards(versionField, m.ShardingBits, m.Shards)
+			for _, key := range sortedKeys(m.Larges) {
+				checkFile(versionField+".larges."+key, m.Larges[key])
+			}
+		}
+	}
+	for _, name := range sortedKeys(profile.Objects) {
+		object := profile.Objects[name]
+		checkShards("objects."+name, object.ShardingBits, object.Shards)
+	}
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].Line < issues[j].Line })
+	return issues
+}
+
+// fieldWithLine names a field for an issue of another one
+func fieldWithLine(field string, line int) string {
+	if line > 0 {
+		return fmt.Sprintf("%s at line %d", field, line)
+	}
+	return field
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// jsonFieldLines returns the lines of the values of a JSON document by field, named like
+// maps.c2pFull[0].shards[3]. It returns what it found before a syntax error.
+func jsonFieldLines(data []byte) map[string]int {
+	lines := make(map[string]int)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var newlines []int64
+	for i, c := range data {
+		if c == '\n' {
+			newlines = append(newlines, int64(i))
+		}
+	}
+	// the offset is where the previous token ended, the value starts after the separators
+	lineAt := func(offset int64) int {
+		for offset < int64(len(data)) && strings.IndexByte(" \t\r\n:,", data[offset]) >= 0 {
+			offset++
+		}
+		return sort.Search(len(newlines), func(i int) bool { return newlines[i] >= offset }) + 1
+	}
+	var walk func(field string) error
+	walk = func(field string) error {
+		line := lineAt(dec.InputOffset())
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if field != "" {
+			lines[field] = line
+		}
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				child := fmt.Sprint(key)
+				if field != "" {
+					child = field + "." + child
+				}
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				if err := walk(fmt.Sprintf("%s[%d]", field, i)); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		}
+		return err
+	}
+	_ = walk("")
+	return lines
+}
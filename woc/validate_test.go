@@ -0,0 +1,75 @@
+package woc
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const badProfile = `{
+  "maps": {
+    "c2pFull": [
+      {
+        "version": "V2412",
+        "sharding_bits": 1,
+        "shards": [
+          {"path": "/data/c2pFullV2412.0.tch", "size": 10, "digest": "0123456789abcdef"},
+          {"path": "/data/c2pFullV2412.1.tch", "digest": "0123456789abcdef"}
+        ],
+        "larges": {
+          "key": {"path": "/data/c2pFullV2412.0.tch", "size": 5}
+        }
+      }
+    ],
+    "empty": []
+  },
+  "objects": {
+    "tree": {
+      "sharding_bits": 2,
+      "shards": [
+        {"path": "/data/tree_0.idx", "size": 10, "digest": "0123456789abcdef"}
+      ]
+    }
+  }
+}
+`
+
+func TestValidateWocProfile(t *testing.T) {
+	var profile WocProfile
+	require.NoError(t, json.Unmarshal([]byte(badProfile), &profile))
+	issues := ValidateWocProfile([]byte(badProfile), &profile)
+	assert.Equal(t, []ProfileIssue{
+		{"maps.c2pFull[0].shards[1]", 9, "size is missing"},
+		{"maps.c2pFull[0].larges.key", 12, "path /data/c2pFullV2412.0.tch is also maps.c2pFull[0].shards[0] at line 8"},
+		{"maps.c2pFull[0].larges.key", 12, "digest is missing, the profile wasn't generated with --with-digest"},
+		{"maps.empty", 16, "has no versions"},
+		{"objects.tree", 19, "has 1 shards, sharding_bits 2 means 4"},
+	}, issues)
+
+	data, err := os.ReadFile("woc.src.json")
+	require.NoError(t, err)
+	profile = WocProfile{}
+	require.NoError(t, json.Unmarshal(data, &profile))
+	assert.Empty(t, ValidateWocProfile(data, &profile))
+}
+
+func TestParseWocProfileWithOptions_Strict(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "woc.json")
+	require.NoError(t, os.WriteFile(path, []byte(badProfile), 0644))
+
+	// the issues are only logged by default
+	profile, err := ParseWocProfileWithOptions(path, ParseOptions{})
+	require.NoError(t, err)
+	assert.Len(t, profile.Maps, 1)
+
+	_, err = ParseWocProfileWithOptions(path, ParseOptions{Strict: true})
+	var profileErr *ProfileError
+	require.True(t, errors.As(err, &profileErr), "got %v", err)
+	assert.Len(t, profileErr.Issues, 5)
+	assert.Contains(t, err.Error(), path+":9: maps.c2pFull[0].shards[1]: size is missing")
+}
@@ -0,0 +1,89 @@
+package woc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// VersionComparator compares two map versions, returning a negative number if a is older than b,
+// 0 if they are the same version and a positive number if a is newer.
+type VersionComparator func(a, b string) int
+
+// DefaultVersionOrder is the version order of profiles that don't set versionOrder
+const DefaultVersionOrder = "natural"
+
+var versionOrders = map[string]VersionComparator{
+	"natural": CompareVersionsNatural,
+	"lexical": strings.Compare,
+}
+
+// RegisterVersionOrder makes a comparator available to the versionOrder of profiles
+func RegisterVersionOrder(name string, cmp VersionComparator) {
+	versionOrders[name] = cmp
+}
+
+// VersionOrder returns the comparator registered as name, the default one if name is empty
+func VersionOrder(name string) (VersionComparator, error) {
+	if name == "" {
+		name = DefaultVersionOrder
+	}
+	cmp, ok := versionOrders[name]
+	if !ok {
+		names := make([]string, 0, len(versionOrders))
+		for n := range versionOrders {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown version order %q, must be one of %v", name, names)
+	}
+	return cmp, nil
+}
+
+// versionSegments splits a version into runs of letters and digits, e.g. V2412a into V, 2412 and a
+func versionSegments(version string) []string {
+	var segments []string
+	for i := 0; i < len(version); {
+		j := i + 1
+		digit := unicode.IsDigit(rune(version[i]))
+		for j < len(version) && unicode.IsDigit(rune(version[j])) == digit {
+			j++
+		}
+		segments = append(segments, version[i:j])
+		i = j
+	}
+	return segments
+}
+
+// CompareVersionsNatural orders versions by their runs of letters and digits. Letter runs are
+// ordered like spreadsheet columns (Z < AA), digit runs numerically (V9 < V10), a digit run comes
+// before a letter run, and a version is older than the versions it is a prefix of (V < V2412).
+// Versions that only differ in leading zeros are ordered by their raw strings (V007 < V7), so no
+// two different versions compare equal.
+func CompareVersionsNatural(a, b string) int {
+	sa, sb := versionSegments(a), versionSegments(b)
+	for i := 0; i < len(sa) && i < len(sb); i++ {
+		x, y := sa[i], sb[i]
+		xDigit, yDigit := unicode.IsDigit(rune(x[0])), unicode.IsDigit(rune(y[0]))
+		if xDigit != yDigit {
+			if xDigit {
+				return -1
+			}
+			return 1
+		}
+		if xDigit {
+			x, y = strings.TrimLeft(x, "0"), strings.TrimLeft(y, "0")
+		}
+		if len(x) != len(y) {
+			return len(x) - len(y)
+		}
+		if c := strings.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	if len(sa) != len(sb) {
+		return len(sa) - len(sb)
+	}
+	return strings.Compare(a, b)
+}
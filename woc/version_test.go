@@ -0,0 +1,58 @@
+package woc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompareVersionsNatural(t *testing.T) {
+	// versions that only differ in leading zeros are ordered by their raw strings
+	ordered := []string{"R", "U", "V", "V2", "V007", "V7", "V10", "V2412", "V2412a", "Z", "AA", "AB"}
+	for i := range ordered {
+		for j := range ordered {
+			c := CompareVersionsNatural(ordered[i], ordered[j])
+			if (i < j && c >= 0) || (i == j && c != 0) || (i > j && c <= 0) {
+				t.Errorf("CompareVersionsNatural(%q, %q) = %d", ordered[i], ordered[j], c)
+			}
+		}
+	}
+}
+
+func TestParseWocProfile_VersionOrder(t *testing.T) {
+	tmpDir := t.TempDir()
+	profile := `{"versionOrder": %q, "maps": {"c2p": [
+		{"version": "AA", "shards": []}, {"version": "Z", "shards": []}
+	]}, "objects": {}}`
+	write := func(order string) string {
+		path := filepath.Join(tmpDir, order+".json")
+		if err := os.WriteFile(path, []byte(fmt.Sprintf(profile, order)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	path := write("natural")
+	parsed, err := ParseWocProfile(&path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Maps["c2p"].Version != "AA" || parsed.Versions["c2p"][0].Version != "Z" {
+		t.Errorf("Expected AA to be newer than Z, got %+v", parsed.Versions["c2p"])
+	}
+
+	path = write("lexical")
+	parsed, err = ParseWocProfile(&path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Maps["c2p"].Version != "Z" {
+		t.Errorf("Expected Z with lexical order, got %s", parsed.Maps["c2p"].Version)
+	}
+
+	path = write("semver")
+	if _, err := ParseWocProfile(&path); err == nil {
+		t.Error("Expected an error for an unknown version order")
+	}
+}
@@ -1,10 +1,14 @@
 package woc
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	of "github.com/hrz6976/syncmate/offsetfs" // Assuming offsetfs is the package where WocFile, WocObject, WocMap, and WocProfile are defined
@@ -19,7 +23,7 @@ type WocFile struct {
 	// Size of file in bytes.
 	Size *int `json:"size,omitempty"`
 
-	// 16-char digest calculated by woc.utils.fast_digest.
+	// Digest of the algorithm of the profile, the 16-char digest calculated by woc.utils.fast_digest by default.
 	Digest *string `json:"digest,omitempty"`
 }
 
@@ -53,20 +57,111 @@ type WocMap struct {
 
 // WocProfile represents the main configuration structure for WoC.
 type WocProfile struct {
+	// SchemaVersion is the version of the profile format, 2 for the current one.
+	SchemaVersion int `json:"wocSchemaVersion,omitempty"`
+
 	// Maps contains all the map objects indexed by name.
 	Maps map[string][]WocMap `json:"maps"`
 
 	// Objects contains all the object files indexed by name.
 	Objects map[string]WocObject `json:"objects"`
+
+	// VersionOrder names the comparator of map versions, see VersionOrder. Defaults to natural.
+	VersionOrder string `json:"versionOrder,omitempty"`
+
+	// DigestAlgorithm names the algorithm of the digests, see NewDigester. Defaults to sample_md5.
+	DigestAlgorithm string `json:"digestAlgorithm,omitempty"`
 }
 
 type ParsedWocProfile struct {
+	// Maps holds the latest version of each map.
 	Maps    map[string]WocMap    `json:"maps"`
 	Objects map[string]WocObject `json:"objects"`
+	// Versions holds all versions of each map, oldest first.
+	Versions map[string][]WocMap `json:"versions,omitempty"`
+	// CompareVersions orders the map versions, the default order if nil.
+	CompareVersions VersionComparator `json:"-"`
+	// Digester computes the digests of the profile, the default algorithm if nil.
+	Digester Digester `json:"-"`
+}
+
+// compareVersions compares map versions with the comparator of the profile
+func (p *ParsedWocProfile) compareVersions(a, b string) int {
+	if p.CompareVersions == nil {
+		return versionOrders[DefaultVersionOrder](a, b)
+	}
+	return p.CompareVersions(a, b)
+}
+
+// digester returns the digester of the digests of the profile
+func (p *ParsedWocProfile) digester() Digester {
+	return digesterOrDefault(p.Digester)
+}
+
+// PathRewrite replaces the prefix of the paths of a profile on the hosts it applies to
+type PathRewrite struct {
+	// Host is a path.Match pattern of the short host name, e.g. "da[0-9]", every host if empty
+	Host string `json:"host,omitempty"`
+	// Prefix is replaced by Replacement. "{host}" in Prefix stands for the short host name.
+	Prefix      string `json:"prefix"`
+	Replacement string `json:"replacement"`
+}
+
+// DefaultPathRewrites are the rules of the da* servers: /da?_data is mounted as NFS on da?.eecs.utk.edu,
+// the local disk is /data, on da8 /mnt/ordos/data/data and on da7 and ishia /corrino
+var DefaultPathRewrites = []PathRewrite{
+	{Host: "da8", Prefix: "/da8_data", Replacement: "/mnt/ordos/data/data"},
+	{Host: "da7", Prefix: "/da7_data", Replacement: "/corrino"},
+	{Host: "ishia", Prefix: "/da7_data", Replacement: "/corrino"},
+	{Prefix: "/{host}_", Replacement: "/"},
+}
+
+// ValidatePathRewrites checks the host patterns and prefixes of rules
+func ValidatePathRewrites(rules []PathRewrite) error {
+	for i, rule := range rules {
+		if rule.Prefix == "" {
+			return fmt.Errorf("rule %d has no prefix", i)
+		}
+		if _, err := path.Match(rule.Host, ""); err != nil {
+			return fmt.Errorf("rule %d: invalid host pattern %q: %w", i, rule.Host, err)
+		}
+	}
+	return nil
+}
+
+// pathRewrites are the rules of RelocatePath, see SetPathRewrites
+var pathRewrites = DefaultPathRewrites
+
+// SetPathRewrites replaces the rules of RelocatePath, nil restores DefaultPathRewrites
+func SetPathRewrites(rules []PathRewrite) error {
+	if err := ValidatePathRewrites(rules); err != nil {
+		return err
+	}
+	if rules == nil {
+		rules = DefaultPathRewrites
+	}
+	pathRewrites = rules
+	return nil
+}
+
+// RewritePath applies the first of rules that applies to the short host name and whose prefix fname has
+func RewritePath(shortHostName, fname string, rules []PathRewrite) string {
+	for _, rule := range rules {
+		if rule.Host != "" {
+			if ok, _ := path.Match(rule.Host, shortHostName); !ok {
+				continue
+			}
+		}
+		prefix := strings.ReplaceAll(rule.Prefix, "{host}", shortHostName)
+		if strings.HasPrefix(fname, prefix) {
+			return rule.Replacement + strings.TrimPrefix(fname, prefix)
+		}
+	}
+	return fname
 }
 
-// quirk on da* servers: resolve /da?_data to /data on da?.eecs.utk.edu
-// the NFS trick does not work anymore because /da?_data are mounted as NFS
+// RelocatePath resolves a path of a profile to the path of the file on this host with the rules of
+// SetPathRewrites, e.g. /da5_data/All.blobs to /data/All.blobs on da5, whose /da?_data are mounted as NFS
 func RelocatePath(fname *string) error {
 	hostName, err := os.Hostname()
 	if err != nil {
@@ -76,26 +171,28 @@ func RelocatePath(fname *string) error {
 		return fmt.Errorf("file name cannot be empty")
 	}
 	shortHostName := strings.Split(hostName, ".")[0]
-	if shortHostName == "ishia" {
-		shortHostName = "da7" // treat ishia as da7 for compatibility
-	}
-	if strings.HasPrefix(*fname, "/"+shortHostName) {
-		switch shortHostName {
-		case "da8":
-			*fname = "/mnt/ordos/data/data" + strings.TrimPrefix(*fname, "/da8_data")
-		case "da7":
-			*fname = "/corrino" + strings.TrimPrefix(*fname, "/da7_data")
-		default:
-			*fname = "/" + strings.TrimPrefix(*fname, "/"+shortHostName+"_")
-		}
+	if relocated := RewritePath(shortHostName, *fname, pathRewrites); relocated != *fname {
+		*fname = relocated
 		logger.WithField("file", *fname).Debugf("Resolved source path to %s", *fname)
 	}
 	return nil
 }
 
+// ParseOptions controls how ParseWocProfileWithOptions checks a profile
+type ParseOptions struct {
+	// Strict fails the parsing with a ProfileError if ValidateWocProfile finds issues,
+	// they are only logged otherwise.
+	Strict bool
+}
+
 func ParseWocProfile(profilePath *string) (*ParsedWocProfile, error) {
-	// Read the JSON file
-	data, err := os.ReadFile(*profilePath)
+	return ParseWocProfileWithOptions(*profilePath, ParseOptions{})
+}
+
+// ParseWocProfileWithOptions is ParseWocProfile with validation, see ValidateWocProfile
+func ParseWocProfileWithOptions(profilePath string, opt ParseOptions) (*ParsedWocProfile, error) {
+	// Read the JSON file, or fetch it from the other side
+	data, err := ReadProfileData(context.Background(), profilePath)
 	if err != nil {
 		return nil, err
 	}
@@ -104,12 +201,36 @@ func ParseWocProfile(profilePath *string) (*ParsedWocProfile, error) {
 	var profile WocProfile
 	err = json.Unmarshal(data, &profile)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%s: %w", profilePath, err)
+	}
+
+	if issues := ValidateWocProfile(data, &profile); len(issues) > 0 {
+		if opt.Strict {
+			return nil, &ProfileError{File: profilePath, Issues: issues}
+		}
+		for _, issue := range issues {
+			logger.WithField("profile", profilePath).Debug(issue.String())
+		}
+		logger.WithFields(logger.Fields{
+			"profile": profilePath,
+			"issues":  len(issues),
+		}).Warnf("Profile has issues, e.g. %s; --strict lists all and fails", issues[0])
 	}
 
+	compareVersions, err := VersionOrder(profile.VersionOrder)
+	if err != nil {
+		return nil, err
+	}
+	digester, err := NewDigester(profile.DigestAlgorithm)
+	if err != nil {
+		return nil, err
+	}
 	var parsedProfile ParsedWocProfile = ParsedWocProfile{
-		Maps:    make(map[string]WocMap),
-		Objects: make(map[string]WocObject),
+		CompareVersions: compareVersions,
+		Digester:        digester,
+		Maps:            make(map[string]WocMap),
+		Objects:         make(map[string]WocObject),
+		Versions:        make(map[string][]WocMap),
 	}
 	// Set the Name field for each object based on the map key
 	for name, obj := range profile.Objects {
@@ -118,13 +239,20 @@ func ParseWocProfile(profilePath *string) (*ParsedWocProfile, error) {
 
 	// pick the map entry with the latest version
 	for name, maps := range profile.Maps {
+		if len(maps) == 0 {
+			// reported by ValidateWocProfile
+			continue
+		}
 		latestMap := maps[0]
 		for _, m := range maps {
-			if m.Version > latestMap.Version {
+			if compareVersions(m.Version, latestMap.Version) > 0 {
 				latestMap = m
 			}
 		}
 		parsedProfile.Maps[name] = latestMap
+		versions := append([]WocMap(nil), maps...)
+		sort.SliceStable(versions, func(i, j int) bool { return compareVersions(versions[i].Version, versions[j].Version) < 0 })
+		parsedProfile.Versions[name] = versions
 	}
 	parsedProfile.Objects = profile.Objects
 	return &parsedProfile, nil
@@ -132,157 +260,349 @@ func ParseWocProfile(profilePath *string) (*ParsedWocProfile, error) {
 
 type WocSyncTask struct {
 	of.FileConfig
+	Dataset      string  `json:"dataset,omitempty"`       // Name of the WoC map or object the file belongs to
 	TargetPath   string  `json:"target_path"`             // Destination path for the file
 	SourceDigest *string `json:"source_digest,omitempty"` // Source file digest for verification
 	TargetDigest *string `json:"target_digest,omitempty"` // Target file digest for verification
+	// Algorithms of the digests of the source and destination profiles, sample_md5 if empty
+	SourceDigestAlgorithm string `json:"source_digest_algorithm,omitempty"`
+	TargetDigestAlgorithm string `json:"target_digest_algorithm,omitempty"`
+}
+
+// SourceDigester returns the digester of SourceDigest
+func (t *WocSyncTask) SourceDigester() (Digester, error) {
+	return NewDigester(t.SourceDigestAlgorithm)
+}
+
+// TargetDigester returns the digester of TargetDigest
+func (t *WocSyncTask) TargetDigester() (Digester, error) {
+	return NewDigester(t.TargetDigestAlgorithm)
+}
+
+// GenerateOptions controls how GenerateFileListsContext computes digests
+type GenerateOptions struct {
+	// DigestWorkers is the number of files digested in parallel. Values below 1 mean 1.
+	DigestWorkers int
+	// SelectMap and SelectObject choose the maps and objects that are compared by name, all if nil.
+	SelectMap    func(name string) bool
+	SelectObject func(name string) bool
+	// SkipBadShards leaves out the shards with nil sizes, failed digests or unresolvable paths
+	// instead of failing the generation.
+	SkipBadShards bool
+	// AllVersions copies every version of a map that is newer than the destination's, not only the latest.
+	AllVersions bool
 }
 
 // produce file lists by comparing two WocProfile objects
-func GenerateFileLists(dstProfile, srcProfile *ParsedWocProfile) map[string]*WocSyncTask {
+func GenerateFileLists(dstProfile, srcProfile *ParsedWocProfile) (map[string]*WocSyncTask, error) {
+	return GenerateFileListsContext(context.Background(), dstProfile, srcProfile, GenerateOptions{})
+}
+
+// reshardWarning tells if a dataset is sharded differently in the source and destination profiles and warns about it
+func reshardWarning(dataset string, srcBits, srcShards, dstBits, dstShards int) bool {
+	if srcBits == dstBits && srcShards == dstShards {
+		return false
+	}
+	logger.WithFields(logger.Fields{
+		"dataset":         dataset,
+		"srcShardingBits": srcBits,
+		"srcShards":       srcShards,
+		"dstShardingBits": dstBits,
+		"dstShards":       dstShards,
+	}).Warn("Sharding changed, copying every shard in full. Destination shards beyond the new count are not removed.")
+	return true
+}
+
+// ShardError is a shard that no task could be generated for
+type ShardError struct {
+	Dataset string
+	Path    string
+	Err     error
+}
+
+func (e *ShardError) Error() string {
+	return fmt.Sprintf("%s: shard %s: %v", e.Dataset, e.Path, e.Err)
+}
+
+func (e *ShardError) Unwrap() error {
+	return e.Err
+}
+
+// GenerateFileListsContext is GenerateFileLists with cancellation and parallel digest computation.
+// Digests are computed in two rounds: partial digests of grown object shards decide between full
+// and partial copies, then the digests missing from the profiles are filled into the tasks.
+// Bad shards don't stop the comparison of the others: their errors are joined into the returned
+// error, or only logged and the shards left out with opt.SkipBadShards.
+func GenerateFileListsContext(ctx context.Context, dstProfile, srcProfile *ParsedWocProfile, opt GenerateOptions) (map[string]*WocSyncTask, error) {
 	var fileList = make(map[string]*WocSyncTask)
+	// the digests of each profile are computed and compared with its own algorithm
+	srcDigester, dstDigester := srcProfile.digester(), dstProfile.digester()
+	// digests missing from the profiles, filled after all tasks are known
+	var missingDigests []*digestJob
+	missingDigestSlots := make(map[*digestJob][]**string)
+	missingDigestTasks := make(map[*digestJob][]*WocSyncTask)
+	missingDigestByPath := make(map[string]*digestJob)
 
-	calcDigests := func(file WocFile) {
-		if file.Size == nil {
-			panic(fmt.Errorf("shard size is nil for file %s", file.Path))
+	var shardErrs []error
+	badShard := func(dataset, path string, err error) {
+		err = &ShardError{Dataset: dataset, Path: path, Err: err}
+		if opt.SkipBadShards {
+			logger.WithError(err).Warn("Skipping bad shard")
+			return
 		}
-		if file.Digest == nil {
-			logger.WithFields(logger.Fields{
-				"path": file.Path,
-				"size": *file.Size,
-			}).Debug("Calculating sample MD5 for file")
-			res, err := SampleMD5(file.Path, 0, 0)
-			if err == nil {
-				file.Digest = &res.Digest
-			} else {
-				logger.WithField("path", file.Path).WithError(err).Error("failed to calculate sample md5, were the profiles generated with --with-digest?")
-				panic(err)
-			}
+		shardErrs = append(shardErrs, err)
+	}
+
+	requireDigest := func(task *WocSyncTask, file WocFile, digester Digester, slot **string) {
+		if file.Digest != nil {
+			*slot = file.Digest
+			return
+		}
+		key := file.Path + "\x00" + digester.Name()
+		job, ok := missingDigestByPath[key]
+		if !ok {
+			job = &digestJob{path: file.Path, digester: digester}
+			missingDigestByPath[key] = job
+			missingDigests = append(missingDigests, job)
 		}
+		missingDigestSlots[job] = append(missingDigestSlots[job], slot)
+		missingDigestTasks[job] = append(missingDigestTasks[job], task)
 	}
 
-	addFullCopyTask := func(srcFile WocFile, dstFile *WocFile) {
+	// relocate resolves the path of a source file to this host, see RelocatePath
+	relocate := func(dataset string, file *WocFile) bool {
+		if err := RelocatePath(&file.Path); err != nil {
+			logger.WithField("path", file.Path).WithError(err).Error("Failed to relocate path")
+			badShard(dataset, file.Path, err)
+			return false
+		}
+		return true
+	}
+
+	addFullCopyTask := func(dataset string, srcFile WocFile, dstFile *WocFile) {
 		virtualPath := filepath.Base(srcFile.Path)
 		if srcFile.Size == nil {
-			panic(fmt.Errorf("shard size is nil for file %s", srcFile.Path))
-		}
-		if srcFile.Digest == nil {
-			calcDigests(srcFile)
+			badShard(dataset, srcFile.Path, errors.New("shard size is nil"))
+			return
 		}
 		var tarPath string
 		if dstFile != nil {
 			tarPath = dstFile.Path
 		}
-		fileList[virtualPath] = &WocSyncTask{
+		task := &WocSyncTask{
 			FileConfig: of.FileConfig{
 				VirtualPath: virtualPath,
 				SourcePath:  srcFile.Path, // Assuming we take the first shard as source
 				Offset:      0,
 				Size:        int64(*srcFile.Size),
 			},
-			TargetPath:   tarPath,        // Destination path for the file
-			SourceDigest: srcFile.Digest, // Source digest for verification
-			TargetDigest: nil,            // Target digest does not matter
+			Dataset:               dataset,
+			TargetPath:            tarPath, // Destination path for the file
+			TargetDigest:          nil,     // Target digest does not matter
+			SourceDigestAlgorithm: DigestAlgorithmField(srcDigester),
 		}
+		requireDigest(task, srcFile, srcDigester, &task.SourceDigest) // Source digest for verification
+		fileList[virtualPath] = task
 	}
 
-	addPartialCopyTask := func(srcFile WocFile, dstFile WocFile) {
+	addPartialCopyTask := func(dataset string, srcFile WocFile, dstFile WocFile) {
 		virtualPath := fmt.Sprintf("%s.offset.%d", filepath.Base(srcFile.Path), int64(*dstFile.Size))
-		if srcFile.Size == nil || dstFile.Size == nil {
-			panic(fmt.Errorf("shard size is nil for file %s", srcFile.Path))
-		}
 		if *srcFile.Size < *dstFile.Size {
 			logger.Warn(fmt.Sprintf("source file %s size %d is smaller than destination file %s size %d",
 				srcFile.Path, *srcFile.Size, dstFile.Path, *dstFile.Size))
-			addFullCopyTask(srcFile, &dstFile)
+			addFullCopyTask(dataset, srcFile, &dstFile)
 			return
 		}
-		if srcFile.Digest == nil {
-			calcDigests(srcFile)
-			if srcFile.Digest == nil {
-				calcDigests(srcFile)
-			}
-			if dstFile.Digest == nil {
-				calcDigests(dstFile)
-			}
-		}
-		fileList[virtualPath] = &WocSyncTask{
+		task := &WocSyncTask{
 			FileConfig: of.FileConfig{
 				VirtualPath: virtualPath,
 				SourcePath:  srcFile.Path, // Assuming we take the first shard as source
 				Offset:      int64(*dstFile.Size),
 				Size:        int64(*srcFile.Size) - int64(*dstFile.Size),
 			},
-			TargetPath:   dstFile.Path, // Destination path for the file
-			SourceDigest: srcFile.Digest,
-			TargetDigest: dstFile.Digest,
+			Dataset:               dataset,
+			TargetPath:            dstFile.Path, // Destination path for the file
+			SourceDigestAlgorithm: DigestAlgorithmField(srcDigester),
+			TargetDigestAlgorithm: DigestAlgorithmField(dstDigester),
 		}
+		requireDigest(task, srcFile, srcDigester, &task.SourceDigest)
+		// nil if the destination profile has none and the destination file isn't on this host
+		task.TargetDigest = dstFile.Digest
+		fileList[virtualPath] = task
 	}
 
 	for k, v := range srcProfile.Maps {
+		if opt.SelectMap != nil && !opt.SelectMap(k) {
+			continue
+		}
 		oldMap, exists := dstProfile.Maps[k]
-		if !exists || (exists && v.Version > oldMap.Version) {
+		// versions are compared in the order of the source profile
+		resharded := exists && srcProfile.compareVersions(v.Version, oldMap.Version) == 0 && reshardWarning(k, v.ShardingBits, len(v.Shards), oldMap.ShardingBits, len(oldMap.Shards))
+		versions := []WocMap{v}
+		if opt.AllVersions && len(srcProfile.Versions[k]) > 0 {
+			versions = srcProfile.Versions[k]
+		}
+		for _, m := range versions {
+			if c := srcProfile.compareVersions(m.Version, oldMap.Version); exists && (c < 0 || c == 0 && !resharded) {
+				continue
+			}
 			// If versions differ, add the new map to the file list
 			// virtual path is the base name of the file
 			// add shards
 			// Convert larges map to a slice
 			var largesSlice []WocFile
-			for _, large := range v.Larges {
+			for _, large := range m.Larges {
 				largesSlice = append(largesSlice, large)
 			}
-			shards := append(v.Shards, largesSlice...)
+			shards := append(m.Shards, largesSlice...)
 			for _, shard := range shards {
-				addFullCopyTask(shard, nil)
+				if relocate(k, &shard) {
+					addFullCopyTask(k, shard, nil)
+				}
 			}
 		}
 	}
+
+	// grown object shards whose head has to be compared with the destination
+	type grownShard struct {
+		dataset  string
+		shard    WocFile
+		oldShard WocFile
+		job      *digestJob
+		// oldJob digests the destination file if the destination profile has no digest of it
+		oldJob *digestJob
+	}
+	var grownShards []grownShard
 	for k, v := range srcProfile.Objects {
+		if opt.SelectObject != nil && !opt.SelectObject(k) {
+			continue
+		}
 		oldMap, exists := dstProfile.Objects[k]
+		// shard i of the source holds other keys than shard i of the destination after resharding,
+		// so the whole object is copied again
+		resharded := exists && reshardWarning(k, v.ShardingBits, len(v.Shards), oldMap.ShardingBits, len(oldMap.Shards))
 		for i, shard := range v.Shards {
+			if !relocate(k, &shard) {
+				continue
+			}
 			if !exists {
-				addFullCopyTask(shard, nil)
+				addFullCopyTask(k, shard, nil)
+				continue
+			}
+			if resharded {
+				// the new shards go next to the old ones
+				var target *WocFile
+				if len(oldMap.Shards) > 0 {
+					target = &WocFile{Path: filepath.Join(filepath.Dir(oldMap.Shards[0].Path), filepath.Base(shard.Path))}
+				}
+				addFullCopyTask(k, shard, target)
 				continue
 			}
 			oldShard := oldMap.Shards[i]
+			if shard.Size == nil || oldShard.Size == nil {
+				badShard(k, shard.Path, errors.New("shard size is nil"))
+				continue
+			}
 			if *oldShard.Size > *shard.Size {
 				logger.Warn(fmt.Sprintf("source file %s size %d is smaller than destination file %s size %d",
 					shard.Path, *shard.Size, oldShard.Path, *oldShard.Size))
-				addFullCopyTask(shard, &oldShard)
+				addFullCopyTask(k, shard, &oldShard)
 				continue
 			}
 
-			// rewrite shard.Path to the full path
-			if err := RelocatePath(&shard.Path); err != nil {
-				logger.WithField("path", shard.Path).WithError(err).Error("Failed to relocate path")
-				panic(err)
+			g := grownShard{
+				dataset:  k,
+				shard:    shard,
+				oldShard: oldShard,
+				// the head is compared with the digest of the destination profile
+				job: &digestJob{path: shard.Path, size: int64(*oldShard.Size), digester: dstDigester},
+			}
+			if oldShard.Digest == nil {
+				// e.g. a profile scanned with --skip-digest
+				g.oldJob = &digestJob{path: oldShard.Path, size: int64(*oldShard.Size), digester: dstDigester}
 			}
+			grownShards = append(grownShards, g)
+		}
+	}
 
-			// On the destination, we can never check the digest of source files.
-			// So it adds both the full copy and the partial copy tasks.
-			// File will be copied in full if the file exists on the remote.
-			partialMd5, err := SampleMD5(shard.Path, 0, int64(*oldShard.Size))
-			if err != nil {
-				if os.IsNotExist(err) || strings.Contains(err.Error(), "no such file or directory") {
-					logger.Debug("Source file missing. Add both full and partial tasks and skip digest verification.", "path", shard.Path)
-					addFullCopyTask(shard, &oldShard)
-				} else {
-					logger.WithError(err).WithField("path", shard.Path).Error("Failed to calculate sample MD5")
-					panic(err)
-				}
-			} else { // here we have a valid partial MD5
-				logger.WithFields(logger.Fields{
-					"path":   shard.Path,
-					"size":   *shard.Size,
-					"digest": partialMd5.Digest,
-				}).Debug("Calculated partial MD5 for shard")
-				if partialMd5.Digest != *oldShard.Digest {
-					logger.Debug(fmt.Sprintf("partial MD5 mismatch for shard %s: %s != %s",
-						shard.Path, partialMd5.Digest, *oldShard.Digest))
-					addFullCopyTask(shard, &oldShard)
-					continue
+	partialJobs := make([]*digestJob, 0, len(grownShards))
+	for _, g := range grownShards {
+		partialJobs = append(partialJobs, g.job)
+		if g.oldJob != nil {
+			partialJobs = append(partialJobs, g.oldJob)
+		}
+	}
+	logger.WithField("count", len(partialJobs)).Info("Calculating partial digests of grown shards")
+	if err := digestFiles(ctx, partialJobs, opt.DigestWorkers); err != nil {
+		return nil, err
+	}
+
+	for _, g := range grownShards {
+		shard, oldShard := g.shard, g.oldShard
+		if g.oldJob != nil {
+			if err := g.oldJob.err; err == nil {
+				oldShard.Digest = &g.oldJob.digest
+			} else if os.IsNotExist(err) || strings.Contains(err.Error(), "no such file or directory") {
+				// the head can't be compared, the appended file is still verified with the source digest when it is placed
+				logger.Debug("Destination file missing and without digest. Add the partial task without comparing its head.", "path", oldShard.Path)
+			} else {
+				logger.WithError(err).WithField("path", oldShard.Path).Error("Failed to calculate the digest of the destination file")
+				badShard(g.dataset, shard.Path, err)
+				continue
+			}
+		}
+		// On the destination, we can never check the digest of source files.
+		// So it adds both the full copy and the partial copy tasks.
+		// File will be copied in full if the file exists on the remote.
+		if err := g.job.err; err != nil {
+			if os.IsNotExist(err) || strings.Contains(err.Error(), "no such file or directory") {
+				logger.Debug("Source file missing. Add both full and partial tasks and skip digest verification.", "path", shard.Path)
+				addFullCopyTask(g.dataset, shard, &oldShard)
+			} else {
+				logger.WithError(err).WithField("path", shard.Path).Error("Failed to calculate sample MD5")
+				badShard(g.dataset, shard.Path, err)
+				continue
+			}
+		} else { // here we have a valid partial MD5
+			logger.WithFields(logger.Fields{
+				"path":   shard.Path,
+				"size":   *shard.Size,
+				"digest": g.job.digest,
+			}).Debug("Calculated partial MD5 for shard")
+			if oldShard.Digest != nil && g.job.digest != *oldShard.Digest {
+				logger.Debug(fmt.Sprintf("partial MD5 mismatch for shard %s: %s != %s",
+					shard.Path, g.job.digest, *oldShard.Digest))
+				addFullCopyTask(g.dataset, shard, &oldShard)
+				continue
+			}
+		}
+		addPartialCopyTask(g.dataset, shard, oldShard)
+	}
+
+	if len(missingDigests) > 0 {
+		logger.WithField("count", len(missingDigests)).Info("Calculating digests missing from the profiles")
+		if err := digestFiles(ctx, missingDigests, opt.DigestWorkers); err != nil {
+			return nil, err
+		}
+		for _, job := range missingDigests {
+			if job.err != nil {
+				logger.WithField("path", job.path).WithError(job.err).Error("failed to calculate sample md5, were the profiles generated with --with-digest?")
+				for _, task := range missingDigestTasks[job] {
+					if fileList[task.VirtualPath] == task {
+						delete(fileList, task.VirtualPath)
+						badShard(task.Dataset, task.SourcePath, job.err)
+					}
 				}
+				continue
+			}
+			for _, slot := range missingDigestSlots[job] {
+				digest := job.digest
+				*slot = &digest
 			}
-			addPartialCopyTask(shard, oldShard)
 		}
 	}
-	return fileList
+	if len(shardErrs) > 0 {
+		return nil, errors.Join(shardErrs...)
+	}
+	return fileList, nil
 }
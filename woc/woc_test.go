@@ -1,8 +1,13 @@
 package woc
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -33,7 +38,10 @@ func TestGenerateFileList(t *testing.T) {
 		t.Fatalf("Failed to parse source profile: %v", err)
 	}
 
-	fileList := GenerateFileLists(dstProfile, srcProfile)
+	fileList, err := GenerateFileLists(dstProfile, srcProfile)
+	if err != nil {
+		t.Fatalf("Failed to generate file list: %v", err)
+	}
 	// dump the file list to json
 	_, err = json.MarshalIndent(fileList, "", "  ")
 	if err != nil {
@@ -172,3 +180,288 @@ func TestRelocatePathRealHostname(t *testing.T) {
 
 	t.Logf("Current hostname: %s", hostname)
 }
+
+func TestRewritePath(t *testing.T) {
+	tests := []struct {
+		host, path, expected string
+	}{
+		{"da8", "/da8_data/test/file.txt", "/mnt/ordos/data/data/test/file.txt"},
+		{"da7", "/da7_data/test/file.txt", "/corrino/test/file.txt"},
+		{"ishia", "/da7_data/test/file.txt", "/corrino/test/file.txt"},
+		{"da5", "/da5_data/test/file.txt", "/data/test/file.txt"},
+		{"da5", "/da5_fast/All.sha1c/tree_0.idx", "/fast/All.sha1c/tree_0.idx"},
+		{"da5", "/da3_data/test/file.txt", "/da3_data/test/file.txt"},
+		{"da8", "/other/path/file.txt", "/other/path/file.txt"},
+	}
+	for _, tt := range tests {
+		if got := RewritePath(tt.host, tt.path, DefaultPathRewrites); got != tt.expected {
+			t.Errorf("RewritePath(%s, %s) = %s, want %s", tt.host, tt.path, got, tt.expected)
+		}
+	}
+
+	rules := []PathRewrite{
+		{Host: "da1[0-9]", Prefix: "/{host}_data/", Replacement: "/scratch/"},
+		{Prefix: "/nfs/", Replacement: "/mnt/nfs/"},
+	}
+	if got := RewritePath("da12", "/da12_data/a.bin", rules); got != "/scratch/a.bin" {
+		t.Errorf("Expected the rule of da12 to apply, got %s", got)
+	}
+	if got := RewritePath("da5", "/da5_data/a.bin", rules); got != "/da5_data/a.bin" {
+		t.Errorf("Expected no rule to apply on da5, got %s", got)
+	}
+	if got := RewritePath("da5", "/nfs/a.bin", rules); got != "/mnt/nfs/a.bin" {
+		t.Errorf("Expected the rule of every host to apply, got %s", got)
+	}
+
+	if err := SetPathRewrites([]PathRewrite{{Host: "da[", Prefix: "/"}}); err == nil {
+		t.Error("Expected an error for an invalid host pattern")
+	}
+	if err := SetPathRewrites([]PathRewrite{{Replacement: "/data"}}); err == nil {
+		t.Error("Expected an error for a rule without prefix")
+	}
+	if err := SetPathRewrites(rules); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetPathRewrites(nil) })
+	nfsPath := "/nfs/a.bin"
+	if err := RelocatePath(&nfsPath); err != nil || nfsPath != "/mnt/nfs/a.bin" {
+		t.Errorf("Expected RelocatePath to use the rules, got %s, %v", nfsPath, err)
+	}
+}
+
+func TestGenerateFileListsContext_Select(t *testing.T) {
+	srcPath := "woc.src.json"
+	dstPath := "woc.dst.json"
+	dstProfile, err := ParseWocProfile(&dstPath)
+	if err != nil {
+		t.Fatalf("Failed to parse destination profile: %v", err)
+	}
+	srcProfile, err := ParseWocProfile(&srcPath)
+	if err != nil {
+		t.Fatalf("Failed to parse source profile: %v", err)
+	}
+
+	fileList, err := GenerateFileListsContext(context.Background(), dstProfile, srcProfile, GenerateOptions{
+		SelectMap:    func(name string) bool { return false },
+		SelectObject: func(name string) bool { return name == "tree.idx" },
+	})
+	if err != nil {
+		t.Fatalf("Failed to generate file list: %v", err)
+	}
+	if len(fileList) == 0 {
+		t.Fatal("Expected tasks of tree.idx")
+	}
+	for virtualPath, task := range fileList {
+		if task.Dataset != "tree.idx" {
+			t.Errorf("Task %s of dataset %s was not selected", virtualPath, task.Dataset)
+		}
+	}
+}
+
+func TestGenerateFileListsContext_BadShards(t *testing.T) {
+	size, digest := 10, "0123456789abcdef"
+	srcProfile := &ParsedWocProfile{
+		Maps: map[string]WocMap{"c2pFull": {Version: "V2412", Shards: []WocFile{
+			{Path: "/da0_data/basemaps/c2pFullV2412.0.tch"},
+			{Path: "/da0_data/basemaps/c2pFullV2412.1.tch", Size: &size, Digest: &digest},
+			{Path: "/da0_data/basemaps/c2pFullV2412.2.tch"},
+		}}},
+		Objects: map[string]WocObject{},
+	}
+	dstProfile := &ParsedWocProfile{Maps: map[string]WocMap{}, Objects: map[string]WocObject{}}
+
+	// all bad shards are reported, not only the first one
+	_, err := GenerateFileListsContext(context.Background(), dstProfile, srcProfile, GenerateOptions{})
+	if err == nil {
+		t.Fatal("Expected an error for the shards without size")
+	}
+	for _, path := range []string{"c2pFullV2412.0.tch: shard size is nil", "c2pFullV2412.2.tch: shard size is nil"} {
+		if !strings.Contains(err.Error(), path) {
+			t.Errorf("Expected %q in %v", path, err)
+		}
+	}
+	var shardErr *ShardError
+	if !errors.As(err, &shardErr) || shardErr.Dataset != "c2pFull" {
+		t.Errorf("Expected a ShardError of c2pFull, got %v", err)
+	}
+
+	fileList, err := GenerateFileListsContext(context.Background(), dstProfile, srcProfile, GenerateOptions{SkipBadShards: true})
+	if err != nil {
+		t.Fatalf("Expected the bad shards to be skipped, got %v", err)
+	}
+	if len(fileList) != 1 || fileList["c2pFullV2412.1.tch"] == nil {
+		t.Errorf("Expected only the task of the good shard, got %v", fileList)
+	}
+}
+
+func TestGenerateFileListsContext_Resharded(t *testing.T) {
+	size, digest := 10, "0123456789abcdef"
+	shards := func(dir string, n int) []WocFile {
+		var files []WocFile
+		for i := range n {
+			files = append(files, WocFile{Path: fmt.Sprintf("%s/tree_%d.tch", dir, i), Size: &size, Digest: &digest})
+		}
+		return files
+	}
+	srcProfile := &ParsedWocProfile{
+		Maps:    map[string]WocMap{},
+		Objects: map[string]WocObject{"tree.tch": {ShardingBits: 2, Shards: shards("/da5_fast/All.sha1c", 4)}},
+	}
+	dstProfile := &ParsedWocProfile{
+		Maps:    map[string]WocMap{},
+		Objects: map[string]WocObject{"tree.tch": {ShardingBits: 1, Shards: shards("/da7_fast/All.sha1c", 2)}},
+	}
+
+	fileList, err := GenerateFileListsContext(context.Background(), dstProfile, srcProfile, GenerateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to generate file list: %v", err)
+	}
+	if len(fileList) != 4 {
+		t.Fatalf("Expected full copies of all 4 shards, got %d tasks", len(fileList))
+	}
+	for i := range 4 {
+		task := fileList[fmt.Sprintf("tree_%d.tch", i)]
+		if task == nil || task.Offset != 0 || task.TargetPath != fmt.Sprintf("/da7_fast/All.sha1c/tree_%d.tch", i) {
+			t.Errorf("Unexpected task of shard %d: %+v", i, task)
+		}
+	}
+}
+
+func TestGenerateFileListsContext_AllVersions(t *testing.T) {
+	size, digest := 10, "0123456789abcdef"
+	version := func(v string) WocMap {
+		return WocMap{Version: v, Shards: []WocFile{{Path: "/da0_data/basemaps/c2pFull" + v + ".0.tch", Size: &size, Digest: &digest}}}
+	}
+	srcProfile := &ParsedWocProfile{
+		Maps:     map[string]WocMap{"c2p": version("V")},
+		Objects:  map[string]WocObject{},
+		Versions: map[string][]WocMap{"c2p": {version("R"), version("U"), version("V")}},
+	}
+	dstProfile := &ParsedWocProfile{
+		Maps:    map[string]WocMap{"c2p": version("R")},
+		Objects: map[string]WocObject{},
+	}
+
+	fileList, err := GenerateFileListsContext(context.Background(), dstProfile, srcProfile, GenerateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to generate file list: %v", err)
+	}
+	if len(fileList) != 1 || fileList["c2pFullV.0.tch"] == nil {
+		t.Errorf("Expected only the latest version, got %v", fileList)
+	}
+
+	fileList, err = GenerateFileListsContext(context.Background(), dstProfile, srcProfile, GenerateOptions{AllVersions: true})
+	if err != nil {
+		t.Fatalf("Failed to generate file list: %v", err)
+	}
+	if len(fileList) != 2 || fileList["c2pFullU.0.tch"] == nil || fileList["c2pFullV.0.tch"] == nil {
+		t.Errorf("Expected versions U and V, got %v", fileList)
+	}
+}
+
+func TestGenerateFileListsContext_DigestAlgorithms(t *testing.T) {
+	dir := t.TempDir()
+	srcPath := dir + "/tree_0.idx"
+	if err := os.WriteFile(srcPath, []byte("0123456789abcdefghij"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srcDigester, _ := NewDigester("xxhash64")
+	dstDigester, _ := NewDigester("blake3:sampled")
+	// the destination has the head of the source, digested with its own algorithm
+	head, err := dstDigester.Digest(srcPath, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcSize, dstSize := 20, 10
+	srcProfile := &ParsedWocProfile{
+		Maps:     map[string]WocMap{},
+		Objects:  map[string]WocObject{"tree": {Shards: []WocFile{{Path: srcPath, Size: &srcSize}}}},
+		Digester: srcDigester,
+	}
+	dstProfile := &ParsedWocProfile{
+		Maps:     map[string]WocMap{},
+		Objects:  map[string]WocObject{"tree": {Shards: []WocFile{{Path: "/dst/tree_0.idx", Size: &dstSize, Digest: &head}}}},
+		Digester: dstDigester,
+	}
+
+	fileList, err := GenerateFileListsContext(context.Background(), dstProfile, srcProfile, GenerateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := fileList["tree_0.idx.offset.10"]
+	if task == nil {
+		t.Fatalf("Expected a partial copy, got %v", fileList)
+	}
+	full, _ := srcDigester.Digest(srcPath, 0, 0)
+	if task.SourceDigest == nil || *task.SourceDigest != full || task.SourceDigestAlgorithm != "xxhash64" {
+		t.Errorf("Expected the xxhash64 source digest %s, got %v (%s)", full, task.SourceDigest, task.SourceDigestAlgorithm)
+	}
+	if *task.TargetDigest != head || task.TargetDigestAlgorithm != "blake3:sampled" {
+		t.Errorf("Expected the blake3:sampled target digest %s, got %s (%s)", head, *task.TargetDigest, task.TargetDigestAlgorithm)
+	}
+
+	// a head digested with another algorithm doesn't match, so the shard is copied in full
+	dstProfile.Digester = nil
+	fileList, err = GenerateFileListsContext(context.Background(), dstProfile, srcProfile, GenerateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileList["tree_0.idx"] == nil {
+		t.Errorf("Expected a full copy, got %v", fileList)
+	}
+}
+
+func TestGenerateFileListsContext_DestinationWithoutDigests(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 200)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	srcPath, dstPath := filepath.Join(dir, "src", "commit_0.tch"), filepath.Join(dir, "dst", "commit_0.tch")
+	for path, content := range map[string][]byte{srcPath: data, dstPath: data[:100]} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srcSize, dstSize, srcDigest := 200, 100, "0123456789abcdef"
+	srcProfile := &ParsedWocProfile{
+		Maps:    map[string]WocMap{},
+		Objects: map[string]WocObject{"commit": {Shards: []WocFile{{Path: srcPath, Size: &srcSize, Digest: &srcDigest}}}},
+	}
+	// scanned with profile scan --skip-digest
+	dstProfile := &ParsedWocProfile{
+		Maps:    map[string]WocMap{},
+		Objects: map[string]WocObject{"commit": {Shards: []WocFile{{Path: dstPath, Size: &dstSize}}}},
+	}
+
+	fileList, err := GenerateFileListsContext(context.Background(), dstProfile, srcProfile, GenerateOptions{})
+	if err != nil {
+		t.Fatalf("Expected the missing destination digest to be calculated, got %v", err)
+	}
+	task := fileList["commit_0.tch.offset.100"]
+	if len(fileList) != 1 || task == nil {
+		t.Fatalf("Expected a partial copy task, got %v", fileList)
+	}
+	want, err := SampleMD5(dstPath, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.TargetDigest == nil || *task.TargetDigest != want.Digest {
+		t.Errorf("Expected the digest of the destination file %s, got %v", want.Digest, task.TargetDigest)
+	}
+
+	// on the source host, the destination file isn't there to compare the head with
+	if err := os.Remove(dstPath); err != nil {
+		t.Fatal(err)
+	}
+	fileList, err = GenerateFileListsContext(context.Background(), dstProfile, srcProfile, GenerateOptions{})
+	if err != nil {
+		t.Fatalf("Expected the missing destination file to be skipped, got %v", err)
+	}
+	if task := fileList["commit_0.tch.offset.100"]; len(fileList) != 1 || task == nil || task.TargetDigest != nil {
+		t.Errorf("Expected a partial copy task without target digest, got %v", fileList)
+	}
+}